@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A11yElement is one element of the AT-SPI accessibility tree
+type A11yElement struct {
+	Name        string   `json:"name"`
+	Role        string   `json:"role"`
+	Description string   `json:"description,omitempty"`
+	Application string   `json:"application"`
+	Depth       int      `json:"depth"`
+	X           int      `json:"x"`
+	Y           int      `json:"y"`
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	States      []string `json:"states,omitempty"`
+	Actions     []string `json:"actions,omitempty"`
+	Value       *string  `json:"value,omitempty"`
+}
+
+// A11yConfig overrides how the accessibility tree is dumped
+type A11yConfig struct {
+	// Command prints the tree as a JSON array of elements. Defaults to the
+	// accessibility.py helper shipped next to the executor.
+	Command []string `json:"command"`
+}
+
+func a11yCommand() ([]string, error) {
+	cfg, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.A11y.Command) > 0 {
+		return cfg.A11y.Command, nil
+	}
+	script, err := a11yScript()
+	if err != nil {
+		return nil, err
+	}
+	return []string{"python3", script, "--dump-json"}, nil
+}
+
+// a11yScript is the accessibility.py helper shipped next to the executor
+func a11yScript() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(exe), "accessibility.py"), nil
+}
+
+// dumpA11y returns the flattened accessibility tree of the desktop
+func dumpA11y() ([]A11yElement, error) {
+	if elements, ok := warm.dumpA11y(); ok {
+		return elements, nil
+	}
+	argv, err := a11yCommand()
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("a11y dump: %s", msg)
+		}
+		return nil, fmt.Errorf("a11y dump: %v", err)
+	}
+	var elements []A11yElement
+	if err := json.Unmarshal(out, &elements); err != nil {
+		return nil, fmt.Errorf("a11y dump: %v", err)
+	}
+	return elements, nil
+}
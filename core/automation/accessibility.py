@@ -12,7 +12,7 @@ Current version uses xdotool coordinates; this will enable more robust automatio
 
 import logging
 from typing import Optional, List, Dict, Any
-from dataclasses import dataclass
+from dataclasses import dataclass, asdict
 from enum import Enum
 
 logger = logging.getLogger(__name__)
@@ -242,6 +242,11 @@ class AccessibilityManager:
             value = None
             if value_iface:
                 value = str(value_iface.get_current_value())
+            elif "editable" in states:
+                # The text of an entry or text area
+                text_iface = node.get_text_iface()
+                if text_iface:
+                    value = text_iface.get_text(0, text_iface.get_character_count())
             
             # Map role
             role_name = node.get_role_name()
@@ -267,6 +272,48 @@ class AccessibilityManager:
             logger.error(f"Failed to convert node to element: {e}")
             return None
 
+    def dump_tree(self, max_depth: int = 32) -> List[Dict[str, Any]]:
+        """
+        Flatten the desktop's accessibility tree into a list of elements.
+        
+        Only elements with on-screen geometry are included; each entry
+        carries its depth and the name of its application.
+        
+        Args:
+            max_depth: Stop descending below this depth
+            
+        Returns:
+            List of element dictionaries
+        """
+        elements = []
+        desktop = self.get_desktop()
+        if not desktop:
+            return elements
+        
+        def walk(node: Any, depth: int, app_name: str):
+            if depth > max_depth:
+                return
+            try:
+                element = self._node_to_element(node)
+                if element and element.width > 0 and element.height > 0:
+                    entry = asdict(element)
+                    entry["role"] = node.get_role_name()
+                    entry["application"] = app_name
+                    entry["depth"] = depth
+                    elements.append(entry)
+                for i in range(node.get_child_count()):
+                    child = node.get_child_at_index(i)
+                    if child:
+                        walk(child, depth + 1, app_name)
+            except Exception:
+                return
+        
+        for i in range(desktop.get_child_count()):
+            app = desktop.get_child_at_index(i)
+            if app:
+                walk(app, 0, app.get_name() or "")
+        return elements
+
     def click_element(self, element: AccessibleElement) -> bool:
         """
         Click on an accessible element.
@@ -364,3 +411,26 @@ class AccessibilityWatcher:
         self.listeners.clear()
         logger.info("Accessibility watcher stopped")
 
+
+if __name__ == "__main__":
+    # Used by the executor binary for a11y dumps: prints the flattened tree as JSON
+    import json
+    import sys
+
+    logging.basicConfig(stream=sys.stderr, level=logging.WARNING)
+    # With --serve-json it stays running and prints one tree per input line,
+    # so the executor connects to AT-SPI once per run
+    if not {"--dump-json", "--serve-json"} & set(sys.argv[1:]):
+        print("usage: accessibility.py --dump-json | --serve-json", file=sys.stderr)
+        sys.exit(2)
+    manager = AccessibilityManager()
+    if not manager.is_available():
+        print("AT-SPI2 not available", file=sys.stderr)
+        sys.exit(1)
+    if "--serve-json" in sys.argv[1:]:
+        for _ in sys.stdin:
+            json.dump(manager.dump_tree(), sys.stdout)
+            sys.stdout.write("\n")
+            sys.stdout.flush()
+    else:
+        json.dump(manager.dump_tree(), sys.stdout)
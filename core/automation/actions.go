@@ -0,0 +1,367 @@
+package main
+
+import "strconv"
+
+// ParamSpec describes one positional parameter of an action
+type ParamSpec struct {
+	Name        string
+	Type        string // JSON Schema type: integer, number, string, boolean or array of strings
+	Description string
+	Required    bool
+	Enum        []string
+	Default     interface{}
+}
+
+// ActionSpec describes a script action, for generated API documents and
+// tool definitions. Params are listed in script order.
+type ActionSpec struct {
+	Name        string
+	Description string
+	Syntax      string
+	Params      []ParamSpec
+}
+
+var pointParams = []ParamSpec{
+	{Name: "x", Type: "integer", Description: "X coordinate in screen pixels", Required: true},
+	{Name: "y", Type: "integer", Description: "Y coordinate in screen pixels", Required: true},
+	{Name: "monitor", Type: "integer", Description: "Monitor the coordinates are relative to, numbered from 1 as monitors lists them"},
+}
+
+var actionSpecs = []ActionSpec{
+	{
+		Name: "pointer", Syntax: "pointer [MONITOR:]X [MONITOR:]Y",
+		Description: "Move the mouse pointer to a screen position",
+		Params:      pointParams,
+	},
+	{
+		Name: "pointer_rel", Syntax: "pointer_rel DX DY",
+		Description: "Move the mouse pointer by an offset from its current position, reporting where it ended",
+		Params: []ParamSpec{
+			{Name: "dx", Type: "integer", Description: "Pixels to move right, negative for left", Required: true},
+			{Name: "dy", Type: "integer", Description: "Pixels to move down, negative for up", Required: true},
+		},
+	},
+	{
+		Name: "hover", Syntax: "hover [MONITOR:]X [MONITOR:]Y SECONDS",
+		Description: "Move the mouse pointer to a screen position and hold it there, so tooltips and menus open; the step's screenshot shows them",
+		Params: append(append([]ParamSpec{}, pointParams...),
+			ParamSpec{Name: "seconds", Type: "number", Description: "Seconds to dwell before the next step", Required: true}),
+	},
+	{
+		Name: "click", Syntax: "click [left|right|middle] [single|double|triple] [[MONITOR:]X [MONITOR:]Y]",
+		Description: "Click a mouse button, at a screen position if given, else where the pointer is",
+		Params: []ParamSpec{
+			{Name: "button", Type: "integer", Description: "1 left, 2 middle, 3 right; the line syntax takes the names", Default: 1, Enum: []string{"1", "2", "3"}},
+			{Name: "clicks", Type: "string", Description: "s for a single click, d for a double click, t for a triple click", Default: "s", Enum: []string{"s", "d", "t"}},
+			{Name: "x", Type: "integer", Description: "X coordinate to click at in screen pixels"},
+			{Name: "y", Type: "integer", Description: "Y coordinate to click at in screen pixels"},
+			{Name: "monitor", Type: "integer", Description: "Monitor the coordinates are relative to, numbered from 1 as monitors lists them"},
+		},
+	},
+	{
+		Name: "type", Syntax: `type "TEXT"`,
+		Description: "Type text with the keyboard",
+		Params:      []ParamSpec{{Name: "text", Type: "string", Description: "Text to type", Required: true}},
+	},
+	{
+		Name: "type_verified", Syntax: `type_verified "TEXT"`,
+		Description: "Type text over the focused field's content and read the field back, typing again on a mismatch",
+		Params:      []ParamSpec{{Name: "text", Type: "string", Description: "Text the field should hold", Required: true}},
+	},
+	{
+		Name: "fill_form", Syntax: "fill_form FILE [right|below|tab]",
+		Description: "Fill a form from a JSON object of field labels and values, finding each label with the accessibility tree or OCR and verifying each field",
+		Params: []ParamSpec{
+			{Name: "file", Type: "string", Description: "JSON file of labels and values, filled in order; values may be cred:SERVICE/FIELD", Required: true},
+			{Name: "layout", Type: "string", Description: "Where fields are from their labels: right, below, or tab to tab from the first", Default: "right", Enum: []string{"right", "below", "tab"}},
+		},
+	},
+	{
+		Name: "set_capslock", Syntax: "set_capslock on|off",
+		Description: "Turn Caps Lock on or off",
+		Params:      []ParamSpec{{Name: "on", Type: "boolean", Description: "Whether Caps Lock is on", Required: true}},
+	},
+	{
+		Name: "set_numlock", Syntax: "set_numlock on|off",
+		Description: "Turn Num Lock on or off",
+		Params:      []ParamSpec{{Name: "on", Type: "boolean", Description: "Whether Num Lock is on", Required: true}},
+	},
+	{
+		Name: "type_totp", Syntax: "type_totp cred:SERVICE/FIELD",
+		Description: "Type the current time-based one-time code of a secret in the credentials store",
+		Params:      []ParamSpec{{Name: "credential", Type: "string", Description: "cred:SERVICE/FIELD reference to a base32 secret or otpauth:// URI", Required: true}},
+	},
+	{
+		Name: "key", Syntax: "key KEY",
+		Description: "Press a key or key combination, e.g. Return or ctrl+c",
+		Params:      []ParamSpec{{Name: "key", Type: "string", Description: "xdotool key name or combination", Required: true}},
+	},
+	{
+		Name: "keydown", Syntax: "keydown KEY",
+		Description: "Hold a key or combination down across the following steps, e.g. shift for a shift-click; released at the end of the script or when a step fails",
+		Params:      []ParamSpec{{Name: "key", Type: "string", Description: "xdotool key name or combination", Required: true}},
+	},
+	{
+		Name: "keys", Syntax: "keys",
+		Description: "List the key and modifier names key, keydown and keyup accept",
+	},
+	{
+		Name: "keyup", Syntax: "keyup KEY",
+		Description: "Release a key or combination held with keydown",
+		Params:      []ParamSpec{{Name: "key", Type: "string", Description: "xdotool key name or combination", Required: true}},
+	},
+	{
+		Name: "wait", Syntax: "wait SECONDS | wait auto [SECONDS]",
+		Description: "Pause; with auto the time is scaled by this host's calibrated wait factor",
+		Params: []ParamSpec{
+			{Name: "seconds", Type: "number", Description: "Seconds to wait", Required: true},
+			{Name: "auto", Type: "boolean", Description: "Scale by the host's calibrated wait factor", Default: false},
+		},
+	},
+	{
+		Name: "drag", Syntax: "drag [MONITOR:]X1 [MONITOR:]Y1 [MONITOR:]X2 [MONITOR:]Y2 DURATION",
+		Description: "Drag with the left button from one point to another",
+		Params: []ParamSpec{
+			{Name: "x1", Type: "integer", Description: "Start X", Required: true},
+			{Name: "y1", Type: "integer", Description: "Start Y", Required: true},
+			{Name: "monitor1", Type: "integer", Description: "Monitor the start is relative to"},
+			{Name: "x2", Type: "integer", Description: "End X", Required: true},
+			{Name: "y2", Type: "integer", Description: "End Y", Required: true},
+			{Name: "monitor2", Type: "integer", Description: "Monitor the end is relative to"},
+			{Name: "duration", Type: "number", Description: "Seconds the drag takes", Required: true},
+		},
+	},
+	{
+		Name: "scroll", Syntax: "scroll [MONITOR:]X [MONITOR:]Y AMOUNT",
+		Description: "Scroll at a position; positive amounts scroll down, negative up",
+		Params: append(append([]ParamSpec{}, pointParams...),
+			ParamSpec{Name: "amount", Type: "integer", Description: "Scroll steps, positive is down", Required: true}),
+	},
+	{
+		Name: "screenshot", Syntax: "screenshot FILENAME [MONITOR]",
+		Description: "Take a named screenshot, of the whole screen or one monitor",
+		Params: []ParamSpec{
+			{Name: "filename", Type: "string", Description: "File name for the screenshot", Required: true},
+			{Name: "monitor", Type: "integer", Description: "Monitor to capture, numbered from 1"},
+		},
+	},
+	{
+		Name: "display", Syntax: "display DISPLAY",
+		Description: "Drive another X display, e.g. :99 or host:0, for the rest of the run",
+		Params:      []ParamSpec{{Name: "display", Type: "string", Description: "X display name", Required: true}},
+	},
+	{
+		Name: "monitors", Syntax: "monitors",
+		Description: "List the connected monitors with their index, name, position and size",
+	},
+	{
+		Name: "click_image", Syntax: "click_image FILE [THRESHOLD]",
+		Description: "Click the center of the best on-screen match for a template image",
+		Params:      imageParams,
+	},
+	{
+		Name: "assert_image", Syntax: "assert_image FILE [THRESHOLD]",
+		Description: "Fail unless a template image is visible on screen",
+		Params:      imageParams,
+	},
+	{
+		Name: "assert_screen", Syntax: "assert_screen FILE [THRESHOLD]",
+		Description: "Fail unless the whole screen matches a baseline image",
+		Params:      imageParams,
+	},
+	{
+		Name: "click_text", Syntax: `click_text "TEXT"`,
+		Description: "Click text found on screen with OCR",
+		Params:      textParams,
+	},
+	{
+		Name: "assert_text", Syntax: `assert_text "TEXT"`,
+		Description: "Fail unless text is found on screen with OCR",
+		Params:      textParams,
+	},
+	{
+		Name: "find_text", Syntax: `find_text "TEXT" [above|below|left_of|right_of|near="ANCHOR"]...`,
+		Description: "Find text on screen with OCR by where it is relative to anchor text, returning the nearest match's box",
+		Params: append(append([]ParamSpec{}, textParams...),
+			ParamSpec{Name: "above", Type: "string", Description: "Anchor text the match is above"},
+			ParamSpec{Name: "below", Type: "string", Description: "Anchor text the match is below"},
+			ParamSpec{Name: "left_of", Type: "string", Description: "Anchor text the match is left of"},
+			ParamSpec{Name: "right_of", Type: "string", Description: "Anchor text the match is right of"},
+			ParamSpec{Name: "near", Type: "string", Description: "Anchor text the match is within 150 pixels of"}),
+	},
+	{
+		Name: "click_described", Syntax: `click_described "DESCRIPTION"`,
+		Description: "Click the element matching a natural language description, located by the vision grounding provider",
+		Params:      []ParamSpec{{Name: "text", Type: "string", Description: "Description of the element", Required: true}},
+	},
+	{
+		Name: "read_text", Syntax: "read_text [X Y W H]",
+		Description: "Return the text on screen, or in a region, with word positions",
+		Params:      regionParams,
+	},
+	{
+		Name: "read_qr", Syntax: "read_qr [X Y W H]",
+		Description: "Decode the QR codes and barcodes on screen, or in a region, returning their content and positions",
+		Params:      regionParams,
+	},
+	{
+		Name: "observe", Syntax: "observe [ocr] [a11y] [image=FILE]... [threshold=N]",
+		Description: "Capture one frame and return OCR text, template matches and the accessibility tree together",
+		Params: []ParamSpec{
+			{Name: "ocr", Type: "boolean", Description: "Include OCR words and text"},
+			{Name: "a11y", Type: "boolean", Description: "Include the accessibility tree"},
+			{Name: "image", Type: "array", Description: "Template images to look for"},
+			{Name: "threshold", Type: "number", Description: "Template match threshold", Default: defaultImageThreshold},
+		},
+	},
+	{
+		Name: "snapshot_session", Syntax: "snapshot_session [NAME]",
+		Description: "Save the open windows, their geometry and workspace, and the focused window",
+		Params:      sessionParams,
+	},
+	{
+		Name: "restore_session", Syntax: "restore_session [NAME]",
+		Description: "Move windows back to a saved session layout and refocus the saved window",
+		Params:      sessionParams,
+	},
+	{
+		Name: "close_window", Syntax: "close_window TITLE",
+		Description: "Close every window whose title contains the text",
+		Params: []ParamSpec{
+			{Name: "title", Type: "string", Description: "Part of the window title, ignoring case", Required: true},
+		},
+	},
+	{
+		Name: "clear_clipboard", Syntax: "clear_clipboard",
+		Description: "Empty the clipboard",
+	},
+	{
+		Name: "launch", Syntax: "launch APP [ARGS...]",
+		Description: "Start an application in the background",
+		Params:      launchParams,
+	},
+	{
+		Name: "launch_sandboxed", Syntax: "launch_sandboxed APP [ARGS...]",
+		Description: "Start an application with a temporary home, XDG directories and browser profile, stopped and deleted when the run ends",
+		Params:      launchParams,
+	},
+	{
+		Name: "do_not_disturb", Syntax: "do_not_disturb on|off",
+		Description: "Turn the desktop's notification do-not-disturb mode on or off",
+		Params: []ParamSpec{
+			{Name: "on", Type: "boolean", Description: "Whether notifications are held back", Required: true},
+		},
+	},
+	{
+		Name: "tty_open", Syntax: "tty_open DEVICE [BAUD]",
+		Description: "Open a serial console for the tty actions, raw 8N1, read until the run ends",
+		Params: []ParamSpec{
+			{Name: "device", Type: "string", Description: "Serial device, e.g. /dev/ttyUSB0 or COM3", Required: true},
+			{Name: "baud", Type: "integer", Description: "Line speed", Default: defaultBaud},
+		},
+	},
+	{
+		Name: "tty_send", Syntax: `tty_send "TEXT"`,
+		Description: "Write text to the serial console; in quotes, escapes such as \\r work",
+		Params:      []ParamSpec{{Name: "text", Type: "string", Description: "Text to send", Required: true}},
+	},
+	{
+		Name: "tty_expect", Syntax: `tty_expect "REGEXP" [SECONDS]`,
+		Description: "Wait for a regular expression in the serial output since the last match",
+		Params: []ParamSpec{
+			{Name: "pattern", Type: "string", Description: "Regular expression to wait for", Required: true},
+			{Name: "timeout", Type: "number", Description: "Seconds to wait", Default: defaultTTYTimeout},
+		},
+	},
+	{
+		Name: "wait_for_sound", Syntax: "wait_for_sound THRESHOLD_DB [SECONDS]",
+		Description: "Wait for sound from the default output louder than a level in dBFS, e.g. -30; 0 is full scale",
+		Params: []ParamSpec{
+			{Name: "threshold", Type: "number", Description: "Level in dBFS to wait for, at most 0", Required: true},
+			{Name: "timeout", Type: "number", Description: "Seconds to wait", Default: defaultSoundWait},
+		},
+	},
+	{
+		Name: "speak", Syntax: `speak "TEXT"`,
+		Description: "Say text aloud through speech-dispatcher, e.g. to call a person to the machine",
+		Params:      []ParamSpec{{Name: "text", Type: "string", Description: "Text to say", Required: true}},
+	},
+	{
+		Name: "beep", Syntax: "beep [FILE]",
+		Description: "Play a short tone, or a sound file, with paplay",
+		Params:      []ParamSpec{{Name: "file", Type: "string", Description: "Sound file to play instead of the tone"}},
+	},
+	{
+		Name: "capture_camera", Syntax: "capture_camera FILE [DEVICE]",
+		Description: "Photograph attached hardware with a V4L2 webcam, saving a JPEG or PNG; relative files go in the screenshots directory",
+		Params: []ParamSpec{
+			{Name: "file", Type: "string", Description: "Output .jpg, .jpeg or .png", Required: true},
+			{Name: "device", Type: "string", Description: "V4L2 device, default camera.device or /dev/video0"},
+		},
+	},
+	{
+		Name: "print_to_pdf", Syntax: `print_to_pdf "FILE" [SECONDS]`,
+		Description: "Print the focused application to a PDF through its GTK or Qt print dialog and wait for the file; relative files go in the screenshots directory",
+		Params: []ParamSpec{
+			{Name: "file", Type: "string", Description: "Output .pdf, replaced if it exists", Required: true},
+			{Name: "timeout", Type: "number", Description: "Seconds for the whole flow", Default: defaultPrintWait},
+		},
+	},
+}
+
+var regionParams = []ParamSpec{
+	{Name: "x", Type: "integer", Description: "Region left"},
+	{Name: "y", Type: "integer", Description: "Region top"},
+	{Name: "w", Type: "integer", Description: "Region width"},
+	{Name: "h", Type: "integer", Description: "Region height"},
+}
+
+var launchParams = []ParamSpec{
+	{Name: "app", Type: "string", Description: "Executable name or path", Required: true},
+	{Name: "args", Type: "array", Description: "Command line arguments"},
+}
+
+var sessionParams = []ParamSpec{
+	{Name: "name", Type: "string", Description: "Snapshot name", Default: "default"},
+}
+
+var imageParams = []ParamSpec{
+	{Name: "file", Type: "string", Description: "PNG file to compare with", Required: true},
+	{Name: "threshold", Type: "number", Description: "Minimum similarity from 0 to 1", Default: defaultImageThreshold},
+}
+
+var textParams = []ParamSpec{
+	{Name: "text", Type: "string", Description: "Text to look for, case-insensitive", Required: true},
+}
+
+// paramsSchema is the JSON Schema of an action's parameters
+func (a ActionSpec) paramsSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, p := range a.Params {
+		prop := map[string]interface{}{"type": p.Type, "description": p.Description}
+		if p.Type == "array" {
+			prop["items"] = map[string]interface{}{"type": "string"}
+		}
+		if len(p.Enum) > 0 {
+			if p.Type == "integer" {
+				var values []int
+				for _, e := range p.Enum {
+					n, _ := strconv.Atoi(e)
+					values = append(values, n)
+				}
+				prop["enum"] = values
+			} else {
+				prop["enum"] = p.Enum
+			}
+		}
+		if p.Default != nil {
+			prop["default"] = p.Default
+		}
+		properties[p.Name] = prop
+		if p.Required {
+			required = append(required, p.Name)
+		}
+	}
+	return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+}
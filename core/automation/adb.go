@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adbBackend drives an Android device with adb, selected with
+// --target adb:SERIAL. The pointer becomes touch: a click is a tap, a drag
+// a swipe, right click a long press and the wheel a short fling. Typing
+// goes through input text and keys through input keyevent.
+type adbBackend struct {
+	serial string
+
+	mu     sync.Mutex
+	size   image.Point // screen size in pixels
+	pos    image.Point
+	down   bool // button 1 held since downAt at downPos
+	downAt time.Time
+	downPt image.Point
+}
+
+// adbLongPress is how long a right click holds the finger down
+const adbLongPress = 800 * time.Millisecond
+
+// initADBTarget checks the device is online and replaces the backend
+func initADBTarget(serial string) error {
+	if backendName != defaultBackend() {
+		return fmt.Errorf("--target adb:%s drives the device itself and cannot be combined with --backend %s", serial, backendName)
+	}
+	b := &adbBackend{serial: serial}
+	out, err := b.adb("get-state")
+	if err != nil {
+		return err
+	}
+	if state := strings.TrimSpace(string(out)); state != "device" {
+		return fmt.Errorf("android device %s is %s", serial, state)
+	}
+	if b.size, err = b.screenSize(); err != nil {
+		return err
+	}
+	activeBackend = b
+	return nil
+}
+
+// adb runs an adb command against the device
+func (b *adbBackend) adb(args ...string) ([]byte, error) {
+	out, err := exec.Command("adb", append([]string{"-s", b.serial}, args...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("adb %s: %v", args[0], commandError(err))
+	}
+	return out, nil
+}
+
+// input runs the device's input tool. adb shell hands its arguments to the
+// device's shell as one line, so each is quoted.
+func (b *adbBackend) input(args ...string) error {
+	cmd := []string{"shell", "input"}
+	for _, a := range args {
+		cmd = append(cmd, shellQuote(a))
+	}
+	_, err := b.adb(cmd...)
+	return err
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// screenSize is the display size in use, an override set with wm size
+// taking precedence over the physical size
+func (b *adbBackend) screenSize() (image.Point, error) {
+	out, err := b.adb("shell", "wm", "size")
+	if err != nil {
+		return image.Point{}, err
+	}
+	var size image.Point
+	for _, line := range strings.Split(string(out), "\n") {
+		label, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		w, h, ok := strings.Cut(strings.TrimSpace(value), "x")
+		x, errW := strconv.Atoi(w)
+		y, errH := strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil {
+			continue
+		}
+		if size == (image.Point{}) || strings.HasPrefix(label, "Override") {
+			size = image.Pt(x, y)
+		}
+	}
+	if size == (image.Point{}) {
+		return size, fmt.Errorf("cannot read the screen size from wm size: %q", strings.TrimSpace(string(out)))
+	}
+	return size, nil
+}
+
+func (b *adbBackend) Name() string { return "adb" }
+
+// MoveMouse only moves where the next touch lands; a held button turns the
+// moves into a swipe when it is released
+func (b *adbBackend) MoveMouse(x, y int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pos = image.Pt(clampInt(x, 0, b.size.X-1), clampInt(y, 0, b.size.Y-1))
+	return nil
+}
+
+func (b *adbBackend) MouseDown(button int) error {
+	if button != 1 {
+		return fmt.Errorf("button %d cannot be held on a touch screen", button)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.down, b.downAt, b.downPt = true, time.Now(), b.pos
+	return nil
+}
+
+// MouseUp lifts the finger: a swipe from where it went down to here,
+// taking as long as the button was held
+func (b *adbBackend) MouseUp(button int) error {
+	if button != 1 {
+		return fmt.Errorf("button %d cannot be held on a touch screen", button)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.down {
+		return nil
+	}
+	b.down = false
+	held := time.Since(b.downAt)
+	if b.pos == b.downPt && held < adbLongPress {
+		return b.input("tap", strconv.Itoa(b.pos.X), strconv.Itoa(b.pos.Y))
+	}
+	return b.swipe(b.downPt, b.pos, held)
+}
+
+func (b *adbBackend) swipe(from, to image.Point, d time.Duration) error {
+	return b.input("swipe", strconv.Itoa(from.X), strconv.Itoa(from.Y),
+		strconv.Itoa(to.X), strconv.Itoa(to.Y), strconv.Itoa(int(d/time.Millisecond)))
+}
+
+// Click taps with button 1, long presses with button 3 and flings a
+// sixth of the screen per wheel step with buttons 4 and 5
+func (b *adbBackend) Click(button, count int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := b.pos
+	step := b.size.Y / 6
+	for i := 0; i < count; i++ {
+		var err error
+		switch button {
+		case 1:
+			err = b.input("tap", strconv.Itoa(p.X), strconv.Itoa(p.Y))
+		case 3:
+			err = b.swipe(p, p, adbLongPress)
+		case 4: // content moves down, the finger too
+			err = b.swipe(p, image.Pt(p.X, clampInt(p.Y+step, 0, b.size.Y-1)), 150*time.Millisecond)
+		case 5:
+			err = b.swipe(p, image.Pt(p.X, clampInt(p.Y-step, 0, b.size.Y-1)), 150*time.Millisecond)
+		default:
+			err = fmt.Errorf("button %d has no touch equivalent", button)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TypeText types with input text, which only takes ASCII and no line
+// breaks: newlines become the enter key. input text reads %s as a space.
+func (b *adbBackend) TypeText(text string) error {
+	for _, r := range text {
+		if r > 0x7e || (r < 0x20 && r != '\n') {
+			return fmt.Errorf("cannot type %q on an android device", r)
+		}
+	}
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			if err := b.input("keyevent", "KEYCODE_ENTER"); err != nil {
+				return err
+			}
+		}
+		// A literal %s would be read as a space, so its % goes alone
+		for j, part := range strings.Split(line, "%s") {
+			if j > 0 {
+				if err := b.input("text", "%"); err != nil {
+					return err
+				}
+				part = "s" + part
+			}
+			if part == "" {
+				continue
+			}
+			if err := b.input("text", strings.ReplaceAll(part, " ", "%s")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Key takes xdotool key syntax, Android's own KEYCODE_ names, or
+// combinations of either, which need Android 13's input keycombination
+func (b *adbBackend) Key(keys string) error {
+	for _, combo := range strings.Fields(keys) {
+		var codes []string
+		for _, name := range strings.Split(combo, "+") {
+			code, ok := adbKeycode(name)
+			if !ok {
+				return fmt.Errorf("unknown key %q", name)
+			}
+			codes = append(codes, code)
+		}
+		args := append([]string{"keyevent"}, codes...)
+		if len(codes) > 1 {
+			args[0] = "keycombination"
+		}
+		if err := b.input(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyDown and KeyUp fail: input sends whole key presses only
+func (b *adbBackend) KeyDown(key string) error {
+	return fmt.Errorf("the adb backend cannot hold keys down")
+}
+
+func (b *adbBackend) KeyUp(key string) error {
+	return fmt.Errorf("the adb backend cannot hold keys down")
+}
+
+// adbKeyNames maps xdotool key names to Android key codes
+var adbKeyNames = map[string]string{
+	"Return": "ENTER", "KP_Enter": "NUMPAD_ENTER", "Escape": "ESCAPE", "BackSpace": "DEL",
+	"Delete": "FORWARD_DEL", "Tab": "TAB", "space": "SPACE", "Insert": "INSERT",
+	"Home": "MOVE_HOME", "End": "MOVE_END", "Prior": "PAGE_UP", "Page_Up": "PAGE_UP", "Next": "PAGE_DOWN", "Page_Down": "PAGE_DOWN",
+	"Up": "DPAD_UP", "Down": "DPAD_DOWN", "Left": "DPAD_LEFT", "Right": "DPAD_RIGHT",
+	"ctrl": "CTRL_LEFT", "Control_L": "CTRL_LEFT", "Control_R": "CTRL_RIGHT",
+	"alt": "ALT_LEFT", "Alt_L": "ALT_LEFT", "Alt_R": "ALT_RIGHT",
+	"shift": "SHIFT_LEFT", "Shift_L": "SHIFT_LEFT", "Shift_R": "SHIFT_RIGHT",
+	"super": "META_LEFT", "Super_L": "META_LEFT", "Super_R": "META_RIGHT",
+	"Menu": "MENU", "Print": "SYSRQ", "Caps_Lock": "CAPS_LOCK",
+	"minus": "MINUS", "equal": "EQUALS", "bracketleft": "LEFT_BRACKET", "bracketright": "RIGHT_BRACKET",
+	"backslash": "BACKSLASH", "semicolon": "SEMICOLON", "apostrophe": "APOSTROPHE", "grave": "GRAVE",
+	"comma": "COMMA", "period": "PERIOD", "slash": "SLASH",
+	"XF86Back": "BACK", "XF86HomePage": "HOME", "XF86PowerOff": "POWER", "XF86Search": "SEARCH",
+	"XF86AudioRaiseVolume": "VOLUME_UP", "XF86AudioLowerVolume": "VOLUME_DOWN", "XF86AudioMute": "VOLUME_MUTE",
+}
+
+func adbKeycode(name string) (string, bool) {
+	if strings.HasPrefix(name, "KEYCODE_") {
+		return name, true
+	}
+	if code, ok := adbKeyNames[name]; ok {
+		return "KEYCODE_" + code, true
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "F")); err == nil && strings.HasPrefix(name, "F") && n >= 1 && n <= 12 {
+		return "KEYCODE_F" + strconv.Itoa(n), true
+	}
+	if len(name) == 1 {
+		c := name[0]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			return "KEYCODE_" + strings.ToUpper(name), true
+		}
+	}
+	return "", false
+}
+
+// Capture reads screencap's PNG straight off the device
+func (b *adbBackend) Capture() (image.Image, error) {
+	out, err := b.adb("exec-out", "screencap", "-p")
+	if err != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", err)
+	}
+	b.mu.Lock()
+	b.size = img.Bounds().Size()
+	b.mu.Unlock()
+	return img, nil
+}
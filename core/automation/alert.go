@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alerter pages fleet operators about a serve daemon in trouble. An alert
+// is triggered once when a condition starts and resolved when it clears;
+// key identifies the condition on this host across both calls.
+type Alerter interface {
+	Name() string
+	Trigger(a Alert) error
+	Resolve(a Alert) error
+}
+
+// Alert is one condition on one host
+type Alert struct {
+	Key      string // e.g. agentos-host-1-display
+	Summary  string
+	Details  map[string]interface{}
+	Severity string // critical or warning
+}
+
+// AlertConfig configures alerting from serve mode
+type AlertConfig struct {
+	Targets []AlerterConfig `json:"targets"`
+	// QueueThreshold alerts when more runs than this wait for the screen
+	// (default 10)
+	QueueThreshold int `json:"queue_threshold"`
+	// ConsecutiveFailures alerts after this many failed runs in a row
+	// (default 3)
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// DisplayCheck is how often an idle daemon checks it can still capture
+	// the screen, e.g. "1m" (default 1m)
+	DisplayCheck string `json:"display_check_interval"`
+}
+
+// AlerterConfig selects and configures one alert target
+type AlerterConfig struct {
+	Provider string `json:"provider"` // pagerduty or opsgenie
+	Key      string `json:"key"`      // routing or API key, or "env:NAME"
+	Endpoint string `json:"endpoint"` // API base, e.g. https://api.eu.opsgenie.com
+}
+
+var alerters = map[string]func(AlerterConfig) Alerter{
+	"pagerduty": func(c AlerterConfig) Alerter {
+		return &pagerDuty{routingKey: secretValue(c.Key), endpoint: firstNonEmpty(c.Endpoint, "https://events.pagerduty.com")}
+	},
+	"opsgenie": func(c AlerterConfig) Alerter {
+		return &opsgenie{apiKey: secretValue(c.Key), endpoint: firstNonEmpty(c.Endpoint, "https://api.opsgenie.com")}
+	},
+}
+
+const (
+	defaultQueueThreshold      = 10
+	defaultConsecutiveFailures = 3
+	defaultDisplayCheck        = time.Minute
+)
+
+// alertManager tracks the conditions of a daemon and tells the alerters
+// when each starts and clears
+type alertManager struct {
+	targets   []Alerter
+	queueMax  int
+	failMax   int
+	interval  time.Duration
+	host      string
+	mu        sync.Mutex
+	active    map[string]bool
+	failures  int
+	lastError string
+	outbox    chan alertChange
+}
+
+type alertChange struct {
+	on    bool
+	alert Alert
+}
+
+func newAlertManager(cfg AlertConfig) (*alertManager, error) {
+	m := &alertManager{queueMax: cfg.QueueThreshold, failMax: cfg.ConsecutiveFailures,
+		interval: defaultDisplayCheck, active: map[string]bool{}}
+	for i, t := range cfg.Targets {
+		build, ok := alerters[t.Provider]
+		if !ok {
+			return nil, fmt.Errorf("alerts.targets[%d]: unknown provider %q (want pagerduty or opsgenie)", i, t.Provider)
+		}
+		if t.Key == "" {
+			return nil, fmt.Errorf("alerts.targets[%d]: needs key", i)
+		}
+		m.targets = append(m.targets, build(t))
+	}
+	if m.queueMax <= 0 {
+		m.queueMax = defaultQueueThreshold
+	}
+	if m.failMax <= 0 {
+		m.failMax = defaultConsecutiveFailures
+	}
+	if cfg.DisplayCheck != "" {
+		d, err := time.ParseDuration(cfg.DisplayCheck)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("alerts.display_check_interval: invalid duration %q", cfg.DisplayCheck)
+		}
+		m.interval = d
+	}
+	m.host, _ = os.Hostname()
+	if len(m.targets) > 0 {
+		m.outbox = make(chan alertChange, 64)
+		go m.deliver()
+	}
+	return m, nil
+}
+
+// set starts or clears a condition, telling the alerters only on a change.
+// Alerts go out in order in the background, so runs never wait on them.
+func (m *alertManager) set(on bool, condition, summary string, details map[string]interface{}) {
+	if m == nil || len(m.targets) == 0 {
+		return
+	}
+	m.mu.Lock()
+	changed := m.active[condition] != on
+	m.active[condition] = on
+	m.mu.Unlock()
+	if !changed {
+		return
+	}
+	a := Alert{Key: "agentos-" + m.host + "-" + condition, Summary: fmt.Sprintf("%s: %s", m.host, summary),
+		Details: details, Severity: "critical"}
+	if condition == "queue" {
+		a.Severity = "warning"
+	}
+	select {
+	case m.outbox <- alertChange{on, a}:
+	default:
+		fmt.Fprintf(os.Stderr, "Warning: alert outbox full, dropped %s\n", a.Key)
+	}
+}
+
+func (m *alertManager) deliver() {
+	for change := range m.outbox {
+		for _, t := range m.targets {
+			var err error
+			if change.on {
+				err = t.Trigger(change.alert)
+			} else {
+				err = t.Resolve(change.alert)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s alert %s: %v\n", t.Name(), change.alert.Key, err)
+			}
+		}
+	}
+}
+
+// queued reports how many runs wait for the screen
+func (m *alertManager) queued(waiting int) {
+	if m == nil {
+		return
+	}
+	m.set(waiting > m.queueMax, "queue", fmt.Sprintf("%d runs queued for the screen", waiting),
+		map[string]interface{}{"queued": waiting, "threshold": m.queueMax})
+}
+
+// ran counts a finished run towards the consecutive failure alert
+func (m *alertManager) ran(result ExecutionResult) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if result.Status == "error" {
+		m.failures++
+		if len(result.Errors) > 0 {
+			m.lastError = result.Errors[0]
+		}
+	} else {
+		m.failures = 0
+	}
+	failures, lastError := m.failures, m.lastError
+	m.mu.Unlock()
+	if failures >= m.failMax {
+		m.set(true, "failures", fmt.Sprintf("%d runs in a row failed", failures),
+			map[string]interface{}{"consecutive_failures": failures, "last_error": lastError})
+	} else if failures == 0 {
+		m.set(false, "failures", "runs succeed again", nil)
+	}
+}
+
+// watchDisplay captures the screen every interval while no run holds it,
+// alerting when the display stops answering
+func (m *alertManager) watchDisplay(screen *sync.Mutex) {
+	if m == nil || len(m.targets) == 0 {
+		return
+	}
+	for range time.Tick(m.interval) {
+		if !screen.TryLock() {
+			continue // a run is using the display, and reports its own failures
+		}
+		img, err := baseBackend().Capture()
+		screen.Unlock()
+		if err != nil {
+			m.set(true, "display", "display unreachable", map[string]interface{}{"error": err.Error(), "backend": baseBackend().Name()})
+			continue
+		}
+		releaseFrame(img)
+		m.set(false, "display", "display reachable again", nil)
+	}
+}
+
+// pagerDuty sends Events API v2 events
+type pagerDuty struct {
+	routingKey string
+	endpoint   string
+}
+
+func (p *pagerDuty) Name() string { return "pagerduty" }
+
+func (p *pagerDuty) Trigger(a Alert) error {
+	return p.event("trigger", a, map[string]interface{}{
+		"summary": a.Summary, "source": a.Key, "severity": a.Severity,
+		"component": "agentos-executor", "custom_details": a.Details,
+	})
+}
+
+func (p *pagerDuty) Resolve(a Alert) error { return p.event("resolve", a, nil) }
+
+func (p *pagerDuty) event(action string, a Alert, payload map[string]interface{}) error {
+	body := map[string]interface{}{"routing_key": p.routingKey, "event_action": action, "dedup_key": a.Key}
+	if payload != nil {
+		body["payload"] = payload
+	}
+	return alertPost(p.endpoint+"/v2/enqueue", nil, body)
+}
+
+// opsgenie uses the Alert API, with the key as the alert alias
+type opsgenie struct {
+	apiKey   string
+	endpoint string
+}
+
+func (o *opsgenie) Name() string { return "opsgenie" }
+
+func (o *opsgenie) Trigger(a Alert) error {
+	priority := "P1"
+	if a.Severity == "warning" {
+		priority = "P3"
+	}
+	details := map[string]string{}
+	for k, v := range a.Details {
+		details[k] = fmt.Sprint(v)
+	}
+	return alertPost(o.endpoint+"/v2/alerts", o.headers(), map[string]interface{}{
+		"message": a.Summary, "alias": a.Key, "priority": priority,
+		"source": "agentos-executor", "details": details,
+	})
+}
+
+func (o *opsgenie) Resolve(a Alert) error {
+	return alertPost(o.endpoint+"/v2/alerts/"+url.PathEscape(a.Key)+"/close?identifierType=alias", o.headers(),
+		map[string]interface{}{"source": "agentos-executor", "note": a.Summary})
+}
+
+func (o *opsgenie) headers() map[string]string {
+	return map[string]string{"Authorization": "GenieKey " + o.apiKey}
+}
+
+func alertPost(target string, headers map[string]string, body interface{}) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Timeout: notifyTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionConfig enables automatic purging of old artifacts
+type RetentionConfig struct {
+	MaxAge string `json:"max_age"` // e.g. "30d"; empty disables retention
+}
+
+// PurgeResult summarizes a purge
+type PurgeResult struct {
+	Dir       string `json:"dir"`
+	Tenant    string `json:"tenant,omitempty"`
+	OlderThan string `json:"older_than"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+	DryRun    bool   `json:"dry_run,omitempty"`
+}
+
+// tenant scopes artifacts to <screenshots-dir>/<tenant>
+var tenant string
+
+// parseAge accepts Go durations plus a "d" suffix for days
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+func validTenant(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// artifactNames are the names of the files the executor writes under its
+// artifacts directory, the only ones purges remove: step screenshots,
+// filmstrips, and the recording.json and frames of --record directories.
+// Files a script names itself, such as transcripts and camera or print
+// captures, are the user's to remove.
+var artifactNames = []string{
+	"screenshot_*.png", "filmstrip_*.webp", "filmstrip_*.avif",
+	"recording.json", "frames/[0-9][0-9][0-9][0-9][0-9][0-9].png",
+}
+
+// executorArtifact says whether path is named as one of artifactNames
+func executorArtifact(path string) bool {
+	name, parent := filepath.Base(path), filepath.Base(filepath.Dir(path))
+	for _, pattern := range artifactNames {
+		dir, file, nested := strings.Cut(pattern, "/")
+		if !nested {
+			file = pattern
+		} else if dir != parent {
+			continue
+		}
+		if ok, _ := filepath.Match(file, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeArtifacts removes the executor's artifacts under dir last modified
+// before now-age, then prunes directories left empty. dir itself is kept.
+func purgeArtifacts(dir string, age time.Duration, dryRun bool) (files int, bytes int64, err error) {
+	cutoff := time.Now().Add(-age)
+	var dirs []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != dir {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if !executorArtifact(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	if !dryRun {
+		// Deepest first so parents become empty after their children
+		sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+		for _, d := range dirs {
+			os.Remove(d) // fails harmlessly if not empty
+		}
+	}
+	return files, bytes, err
+}
+
+func runArtifacts(args []string) {
+	if len(args) == 0 || args[0] != "purge" {
+		fmt.Fprintln(os.Stderr, "usage: executor artifacts purge --older-than AGE [--tenant NAME] [--dir DIR] [--dry-run]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("artifacts purge", flag.ExitOnError)
+	olderThan := fs.String("older-than", "", "remove artifacts older than this, e.g. 30d or 12h")
+	tenantName := fs.String("tenant", "", "only purge this tenant's artifacts")
+	dir := fs.String("dir", screenshotsDir, "artifacts root directory")
+	dryRun := fs.Bool("dry-run", false, "report what would be removed without removing it")
+	fs.Parse(args[1:])
+
+	age, err := parseAge(*olderThan)
+	if *olderThan == "" || err != nil {
+		fmt.Fprintln(os.Stderr, "Error: --older-than is required, e.g. --older-than 30d")
+		os.Exit(2)
+	}
+	root := *dir
+	if *tenantName != "" {
+		if !validTenant(*tenantName) {
+			fmt.Fprintf(os.Stderr, "Error: invalid tenant name %q\n", *tenantName)
+			os.Exit(2)
+		}
+		root = filepath.Join(root, *tenantName)
+	}
+
+	result := PurgeResult{Dir: root, Tenant: *tenantName, OlderThan: *olderThan, DryRun: *dryRun}
+	result.Files, result.Bytes, err = purgeArtifacts(root, age, *dryRun)
+	if !*dryRun {
+		details := map[string]interface{}{
+			"trigger": "manual", "dir": root, "older_than": *olderThan,
+			"files": result.Files, "bytes": result.Bytes,
+		}
+		if err != nil {
+			details["error"] = err.Error()
+		}
+		if auditErr := audit("artifacts_purged", *tenantName, details); auditErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not write audit log: %v\n", auditErr)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error purging artifacts: %v\n", err)
+		os.Exit(1)
+	}
+	jsonOutput, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(jsonOutput))
+}
+
+// enforceRetentionHourly enforces retention on dir now and every hour
+// after, for services that outlive a single run
+func enforceRetentionHourly(dir string) {
+	for {
+		if err := enforceRetention(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: retention enforcement failed: %v\n", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// enforceRetention purges artifacts past the configured max age across all
+// tenants. It is a no-op unless retention.max_age is set.
+func enforceRetention(dir string) error {
+	cfg, err := currentConfig()
+	if err != nil || cfg.Retention.MaxAge == "" {
+		return err
+	}
+	age, err := parseAge(cfg.Retention.MaxAge)
+	if err != nil {
+		return fmt.Errorf("retention.max_age: %v", err)
+	}
+	files, bytes, err := purgeArtifacts(dir, age, false)
+	if files == 0 && err == nil {
+		return nil
+	}
+	details := map[string]interface{}{
+		"trigger": "retention", "dir": dir, "older_than": cfg.Retention.MaxAge,
+		"files": files, "bytes": bytes,
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	if auditErr := audit("artifacts_purged", "", details); auditErr != nil && err == nil {
+		err = auditErr
+	}
+	return err
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sound is heard through the monitor of the default output, recorded with
+// parec, which PipeWire provides too through pipewire-pulse. Levels are
+// dBFS, 0 being full scale, per 20ms chunk.
+//
+//	wait_for_sound -30 10    waits up to 10s for anything louder than -30 dBFS
+//
+// With --audio every step is also flagged when something played while it
+// ran, for apps that only chime when they are done.
+
+const (
+	audioRate          = 16000
+	audioChunk         = audioRate / 50 // samples per level
+	audioKeptChunks    = 50 * 600       // ten minutes of levels
+	audioSilence       = -120.0
+	audioActivityLevel = -45.0 // dBFS that counts as activity for --audio
+	defaultSoundWait   = 10.0
+)
+
+// audioEnabled is set by --audio
+var audioEnabled bool
+
+// audioMonitor records the level of what the default output plays
+type audioMonitor struct {
+	cmd *exec.Cmd
+
+	mu      sync.Mutex
+	base    int       // chunk number of levels[0]
+	levels  []float64 // dBFS
+	err     error     // why recording stopped
+	updated chan struct{}
+}
+
+// runAudio is the run's audio monitor, started by --audio or the first
+// wait_for_sound
+var runAudio *audioMonitor
+
+func startAudioMonitor() (*audioMonitor, error) {
+	cmd := exec.Command("parec", "--device=@DEFAULT_MONITOR@", "--format=s16le",
+		"--rate="+strconv.Itoa(audioRate), "--channels=1", "--raw", "--latency-msec=20")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("recording audio: %v", err)
+	}
+	m := &audioMonitor{cmd: cmd, updated: make(chan struct{}, 1)}
+	background.start("audio monitor", func(ctx context.Context) error {
+		defer context.AfterFunc(ctx, func() { cmd.Process.Kill() })()
+		m.read(out)
+		return m.recordingError()
+	})
+	return m, nil
+}
+
+func (m *audioMonitor) recordingError() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *audioMonitor) read(out io.Reader) {
+	buf := make([]byte, audioChunk*2)
+	for {
+		_, err := io.ReadFull(out, buf)
+		m.mu.Lock()
+		if err != nil {
+			m.err = fmt.Errorf("audio recording stopped: %v", commandError(m.cmd.Wait()))
+		} else {
+			m.levels = append(m.levels, chunkLevel(buf))
+			if len(m.levels) > audioKeptChunks {
+				drop := len(m.levels) - audioKeptChunks
+				m.levels = append([]float64{}, m.levels[drop:]...)
+				m.base += drop
+			}
+		}
+		m.mu.Unlock()
+		select {
+		case m.updated <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// chunkLevel is the RMS level of signed 16-bit samples in dBFS
+func chunkLevel(buf []byte) float64 {
+	var sum float64
+	for i := 0; i+1 < len(buf); i += 2 {
+		s := float64(int16(binary.LittleEndian.Uint16(buf[i:])))
+		sum += s * s
+	}
+	rms := math.Sqrt(sum/float64(len(buf)/2)) / 32768
+	if rms == 0 {
+		return audioSilence
+	}
+	return math.Max(20*math.Log10(rms), audioSilence)
+}
+
+// mark is the position of the next level, for peakSince
+func (m *audioMonitor) mark() int {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.base + len(m.levels)
+}
+
+// peakSince is the loudest level recorded since mark
+func (m *audioMonitor) peakSince(mark int) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	peak := audioSilence
+	for i := max(mark-m.base, 0); i < len(m.levels); i++ {
+		peak = math.Max(peak, m.levels[i])
+	}
+	return peak
+}
+
+// waitFor waits for a level of at least threshold, returning it
+func (m *audioMonitor) waitFor(threshold float64, timeout time.Duration) (float64, error) {
+	start := m.mark()
+	from := start
+	deadline := clock.After(timeout)
+	for {
+		m.mu.Lock()
+		for i := max(from-m.base, 0); i < len(m.levels); i++ {
+			if m.levels[i] >= threshold {
+				m.mu.Unlock()
+				return m.levels[i], nil
+			}
+		}
+		from = m.base + len(m.levels)
+		err := m.err
+		m.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case <-m.updated:
+		case <-deadline:
+			return 0, fmt.Errorf("no sound over %.1f dBFS within %s (loudest %.1f dBFS)", threshold, timeout, m.peakSince(start))
+		}
+	}
+}
+
+func (m *audioMonitor) close() {
+	m.cmd.Process.Kill()
+}
+
+// closeAudio stops the run's audio monitor
+func closeAudio() {
+	if runAudio != nil {
+		runAudio.close()
+		runAudio = nil
+	}
+}
+
+// startRunAudio starts the audio monitor for --audio at the start of a run
+func (r *runner) startRunAudio() {
+	if !audioEnabled || runAudio != nil {
+		return
+	}
+	m, err := startAudioMonitor()
+	if err != nil {
+		r.result.Events = append(r.result.Events, Event{Type: "audio_error", Message: err.Error()})
+		return
+	}
+	runAudio = m
+}
+
+// audioActivity flags a step during which something played, with --audio
+func (r *runner) audioActivity(step *StepResult, mark int) {
+	if !audioEnabled || runAudio == nil {
+		return
+	}
+	if peak := runAudio.peakSince(mark); peak >= audioActivityLevel {
+		step.Audio = true
+		event := Event{Step: step.Step, Type: "audio", Message: fmt.Sprintf("sound up to %.1f dBFS", peak)}
+		r.result.Events = append(r.result.Events, event)
+		step.Events = append(step.Events, event)
+	}
+}
+
+// waitForSound runs wait_for_sound
+func waitForSound(cmd *Command) error {
+	if runAudio == nil {
+		m, err := startAudioMonitor()
+		if err != nil {
+			return err
+		}
+		runAudio = m
+	}
+	threshold := cmd.Params["threshold"].(float64)
+	timeout := time.Duration(cmd.Params["timeout"].(float64) * float64(time.Second))
+	start := clock.Now()
+	level, err := runAudio.waitFor(threshold, timeout)
+	if err != nil {
+		return err
+	}
+	cmd.Output = map[string]interface{}{"level_db": math.Round(level*10) / 10, "seconds": since(start).Seconds()}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditEntry is one line of the append-only audit log
+type AuditEntry struct {
+	Time    time.Time              `json:"time"`
+	Event   string                 `json:"event"`
+	Tenant  string                 `json:"tenant,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+// stateDir is where the executor keeps logs and other mutable state
+func stateDir() string {
+	if portableDir != "" {
+		return filepath.Join(portableDir, "state")
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "agentos")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "agentos")
+	}
+	return filepath.Join(home, ".local", "state", "agentos")
+}
+
+func auditLogPath() string {
+	return filepath.Join(stateDir(), "audit.log")
+}
+
+// audit appends an entry to the audit log. Failures are returned so callers
+// that must not proceed unaudited can refuse to.
+func audit(event, tenant string, details map[string]interface{}) error {
+	entry := AuditEntry{Time: time.Now().UTC(), Event: event, Tenant: tenant, Details: details}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	path := auditLogPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Backend delivers input to a display and captures it
+type Backend interface {
+	Name() string
+	MoveMouse(x, y int) error
+	MouseDown(button int) error
+	MouseUp(button int) error
+	// Click presses and releases button count times; buttons 4 and 5 scroll
+	Click(button, count int) error
+	TypeText(text string) error
+	// Key presses a key or combination in xdotool syntax, e.g. ctrl+c
+	Key(keys string) error
+	// KeyDown and KeyUp press and release one key, named as for Key,
+	// leaving it held in between
+	KeyDown(key string) error
+	KeyUp(key string) error
+	Capture() (image.Image, error)
+}
+
+// fileCapturer is implemented by backends that can write a screenshot file
+// directly, cheaper than capturing and re-encoding
+type fileCapturer interface {
+	captureFile(path string) error
+}
+
+// runCloser is implemented by backends that hold something for the length
+// of a run, such as virtual devices, to give back when it ends
+type runCloser interface {
+	closeRun()
+}
+
+var backends = map[string]func() (Backend, error){
+	"xdotool": func() (Backend, error) { return xdotoolBackend{}, nil },
+	"x11":     newX11Backend,
+	"mock":    newMockBackend,
+	"qmp":     newQMPBackend,
+	"vnc":     newVNCBackend,
+	"wayland": newWaylandBackend,
+	"uinput":  newUinputBackend,
+	"macos":   newMacBackend,
+	"windows": newWindowsBackend,
+}
+
+// backendName is set by --backend
+var backendName = defaultBackend()
+
+var (
+	backendOnce   sync.Once
+	activeBackend Backend
+)
+
+func backendNames() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// initBackend creates the backend selected with --backend
+func initBackend() error {
+	factory, ok := backends[backendName]
+	if !ok {
+		return fmt.Errorf("unknown backend %q (available: %s)", backendName, strings.Join(backendNames(), ", "))
+	}
+	b, err := factory()
+	if err != nil {
+		return fmt.Errorf("backend %s: %v", backendName, err)
+	}
+	activeBackend = b
+	return nil
+}
+
+// currentBackend returns the active backend, defaulting to the session's
+// for subcommands that never select one
+func currentBackend() Backend {
+	backendOnce.Do(func() {
+		if activeBackend == nil {
+			activeBackend = xdotoolBackend{}
+			if b, err := backends[defaultBackend()](); err == nil {
+				activeBackend = b
+			}
+		}
+	})
+	return activeBackend
+}
+
+// saveScreenshot writes the current screen to path as PNG
+func saveScreenshot(path string) error {
+	b := currentBackend()
+	if fc, ok := b.(fileCapturer); ok {
+		return fc.captureFile(path)
+	}
+	img, err := b.Capture()
+	if err != nil {
+		return err
+	}
+	defer releaseFrame(img)
+	return writePNG(path, img)
+}
+
+// xdotoolBackend drives an X display through the xdotool and ImageMagick
+// command line tools
+type xdotoolBackend struct{}
+
+func (xdotoolBackend) Name() string { return "xdotool" }
+
+// runXdotool runs one xdotool command. Only the xdotool backend and the
+// X-only extras it implements (sessions, modifiers) call it; everything
+// else goes through Backend.
+func runXdotool(args ...string) error {
+	cmd := exec.Command("xdotool", args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func (xdotoolBackend) MoveMouse(x, y int) error {
+	return runXdotool("mousemove", strconv.Itoa(x), strconv.Itoa(y))
+}
+
+func (xdotoolBackend) MouseDown(button int) error {
+	return runXdotool("mousedown", strconv.Itoa(button))
+}
+
+func (xdotoolBackend) MouseUp(button int) error {
+	return runXdotool("mouseup", strconv.Itoa(button))
+}
+
+func (xdotoolBackend) Click(button, count int) error {
+	if count > 1 {
+		return runXdotool("click", "--repeat", strconv.Itoa(count), strconv.Itoa(button))
+	}
+	return runXdotool("click", strconv.Itoa(button))
+}
+
+func (xdotoolBackend) TypeText(text string) error {
+	// Escape special characters for xdotool
+	text = strings.ReplaceAll(text, "\"", "\\\"")
+	return runXdotool("type", "--delay", "50", text)
+}
+
+// Key holds each combination's keys down in order and releases them in
+// reverse, spelled out as chained keydown and keyup commands
+func (xdotoolBackend) Key(keys string) error {
+	var args []string
+	for _, combo := range strings.Fields(keys) {
+		names := strings.Split(combo, "+")
+		args = append(args, "keydown")
+		args = append(args, names...)
+		args = append(args, "keyup")
+		for i := len(names) - 1; i >= 0; i-- {
+			args = append(args, names[i])
+		}
+	}
+	return runXdotool(args...)
+}
+
+func (xdotoolBackend) KeyDown(key string) error {
+	return runXdotool("keydown", key)
+}
+
+func (xdotoolBackend) KeyUp(key string) error {
+	return runXdotool("keyup", key)
+}
+
+// Capture grabs the root window into memory, decoding as import writes
+// rather than holding the whole PNG first
+func (xdotoolBackend) Capture() (image.Image, error) {
+	cmd := exec.Command("import", "-window", "root", "png:-")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", err)
+	}
+	img, decodeErr := png.Decode(bufio.NewReader(out))
+	io.Copy(io.Discard, out)
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", err)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", decodeErr)
+	}
+	return img, nil
+}
+
+func (xdotoolBackend) captureFile(path string) error {
+	// Try import first (ImageMagick)
+	if err := exec.Command("import", "-window", "root", path).Run(); err == nil {
+		return nil
+	}
+
+	// Try xwd + convert (X11)
+	if err := exec.Command("xwd", "-root", "-out", path+".xwd").Run(); err != nil {
+		return err
+	}
+	defer os.Remove(path + ".xwd")
+	return exec.Command("convert", path+".xwd", path).Run()
+}
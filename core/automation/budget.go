@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// runBudget is set by --run-budget. A run that succeeds but takes longer is
+// reported as degraded, as is one with a step over its @budget.
+var runBudget time.Duration
+
+// parseBudget reads a @budget value such as 2s or 500ms
+func parseBudget(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("@budget needs a positive duration such as 2s, got %q", value)
+	}
+	return d, nil
+}
+
+// overBudget notes a step that ran longer than its budget
+func (r *runner) overBudget(step *StepResult, took, budget time.Duration) {
+	event := Event{Step: step.Step, Type: "over_budget",
+		Message: fmt.Sprintf("took %s, budget %s", took.Round(time.Millisecond), budget)}
+	r.result.Events = append(r.result.Events, event)
+	step.Events = append(step.Events, event)
+	r.degraded = true
+}
+
+// settleBudget checks the run budget and marks a successful run that went
+// over any budget as degraded
+func (r *runner) settleBudget() {
+	if took := since(r.started); runBudget > 0 && took > runBudget {
+		r.result.Events = append(r.result.Events, Event{Step: r.step, Type: "over_budget",
+			Message: fmt.Sprintf("run took %s, budget %s", took.Round(time.Millisecond), runBudget)})
+		r.degraded = true
+	}
+	if r.degraded && r.result.Status == "success" {
+		r.result.Status = "degraded"
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// A script bundle is a directory holding a script and everything it
+// needs, shared and versioned as one unit and run with `executor run DIR`:
+//
+//	login-flow/
+//	  bundle.json      the manifest
+//	  script.gcode     the script; or script.json, script.yaml
+//	  images/          template images for click_image and the like
+//	  locators.json    the locator catalog
+//	  data/            files the script's programs open
+//
+// Relative template paths in the script are read from the bundle, and
+// programs it launches start there unless --cwd or @cwd says otherwise.
+// The locator catalog names targets, {"save": "images/save.png"}, which
+// the script refers to as {{save}}; each reference is replaced with the
+// value as written, before the script is parsed. Before anything runs the
+// bundle is checked against the host: its platforms, backends, tools and
+// capabilities, every line of the script and every template it names.
+
+// BundleManifest is a bundle's bundle.json
+type BundleManifest struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+	// Script is the script's file in the bundle, found by name if empty.
+	// Its extension gives its format: .json, .yaml or .yml, else text.
+	Script   string             `json:"script,omitempty"`
+	Requires BundleRequirements `json:"requires"`
+}
+
+// BundleRequirements are what a bundle needs of the host to run
+type BundleRequirements struct {
+	Platforms []string `json:"platforms,omitempty"` // GOOS values, any of
+	Backends  []string `json:"backends,omitempty"`  // any of
+	Tools     []string `json:"tools,omitempty"`     // programs in PATH, all of
+	// Capabilities are what the desktop must offer, all of, e.g. "ocr:deu"
+	// or "monitor>=2"; see capabilityCheckers
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+const (
+	bundleManifestFile = "bundle.json"
+	bundleLocatorsFile = "locators.json"
+)
+
+// bundleScripts are the script names looked for without a manifest entry
+var bundleScripts = []string{"script.gcode", "script.json", "script.yaml", "script.yml"}
+
+// scriptDir is where relative template paths are read from: the running
+// bundle, or the working directory
+var scriptDir string
+
+// scriptPath resolves a file named by the script
+func scriptPath(file string) string {
+	if scriptDir == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(scriptDir, file)
+}
+
+// scriptBundle is a loaded bundle
+type scriptBundle struct {
+	dir      string
+	manifest BundleManifest
+	locators map[string]string
+}
+
+func loadBundle(dir string) (*scriptBundle, error) {
+	b := &scriptBundle{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, bundleManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading bundle manifest: %v", err)
+	}
+	if err := json.Unmarshal(data, &b.manifest); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", bundleManifestFile, err)
+	}
+	if b.manifest.Name == "" || b.manifest.Version == "" {
+		return nil, fmt.Errorf("%s needs a name and a version", bundleManifestFile)
+	}
+	if b.manifest.Script == "" {
+		for _, name := range bundleScripts {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				b.manifest.Script = name
+				break
+			}
+		}
+		if b.manifest.Script == "" {
+			return nil, fmt.Errorf("bundle has no script (%s)", strings.Join(bundleScripts, ", "))
+		}
+	}
+	if !filepath.IsLocal(b.manifest.Script) {
+		return nil, fmt.Errorf("script %s is outside the bundle", b.manifest.Script)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, bundleLocatorsFile)); err == nil {
+		if err := json.Unmarshal(data, &b.locators); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", bundleLocatorsFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return b, nil
+}
+
+// format is the script's format, from its extension
+func (b *scriptBundle) format() string {
+	switch strings.ToLower(filepath.Ext(b.manifest.Script)) {
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+	return "text"
+}
+
+// locatorRe matches a locator reference, {{name}}
+var locatorRe = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// script reads the script with its locators filled in, as script lines
+func (b *scriptBundle) script() ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(b.dir, b.manifest.Script))
+	if err != nil {
+		return nil, err
+	}
+	var unknown []string
+	data = locatorRe.ReplaceAllFunc(data, func(ref []byte) []byte {
+		name := string(locatorRe.FindSubmatch(ref)[1])
+		value, ok := b.locators[name]
+		if !ok {
+			unknown = append(unknown, name)
+			return ref
+		}
+		return []byte(value)
+	})
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown locators: %s", strings.Join(unknown, ", "))
+	}
+	return scriptLines(b.format(), data)
+}
+
+// check lists what stops the bundle running on this host
+func (b *scriptBundle) check(script []byte) []string {
+	var problems []string
+	req := b.manifest.Requires
+	if len(req.Platforms) > 0 && !contains(req.Platforms, runtime.GOOS) {
+		problems = append(problems, fmt.Sprintf("runs on %s, not %s", strings.Join(req.Platforms, ", "), runtime.GOOS))
+	}
+	if backend := baseBackend().Name(); len(req.Backends) > 0 && !contains(req.Backends, backend) {
+		problems = append(problems, fmt.Sprintf("needs backend %s, not %s", strings.Join(req.Backends, " or "), backend))
+	}
+	for _, tool := range req.Tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			problems = append(problems, fmt.Sprintf("needs %s, which is not in PATH", tool))
+		}
+	}
+	problems = append(problems, checkCapabilities(req.Capabilities)...)
+
+	templates, dataFiles := map[string]bool{}, map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(script))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || markerLine(line) {
+			continue
+		}
+		if name, value, ok := parseAnnotation(line); ok {
+			if !knownAnnotations[name] {
+				problems = append(problems, fmt.Sprintf("line %d: unknown annotation @%s", n, name))
+			} else if err := checkAnnotation(name, value); err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: %v", n, err))
+			} else if name == "pre" || name == "post" {
+				for _, file := range conditionImages(value) {
+					templates[file] = true
+				}
+			}
+			continue
+		}
+		cmd := parseCommand(line)
+		if cmd == nil {
+			problems = append(problems, fmt.Sprintf("line %d: could not parse: %s", n, line))
+			continue
+		}
+		switch cmd.Action {
+		case "click_image", "assert_image", "assert_screen":
+			templates[cmd.Params["file"].(string)] = true
+		case "fill_form":
+			dataFiles[cmd.Params["file"].(string)] = true
+		}
+		for _, v := range cmd.Params {
+			if req, ok := v.(PerceptionRequest); ok {
+				for _, t := range req.Templates {
+					templates[t] = true
+				}
+			}
+		}
+	}
+	var missing []string
+	for t := range templates {
+		if _, err := os.Stat(scriptPath(t)); err != nil {
+			missing = append(missing, t)
+		}
+	}
+	sort.Strings(missing)
+	for _, t := range missing {
+		problems = append(problems, fmt.Sprintf("template %s is not in the bundle", t))
+	}
+	missing = missing[:0]
+	for f := range dataFiles {
+		if _, err := os.Stat(scriptPath(f)); err != nil {
+			missing = append(missing, f)
+		}
+	}
+	sort.Strings(missing)
+	for _, f := range missing {
+		problems = append(problems, fmt.Sprintf("form data %s is not in the bundle", f))
+	}
+	return problems
+}
+
+// executeBundle checks a bundle against the host and runs it
+func executeBundle(dir string) {
+	b, err := loadBundle(dir)
+	var script []byte
+	if err == nil {
+		scriptDir = dir
+		script, err = b.script()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: bundle %s: %v\n", dir, err)
+		os.Exit(2)
+	}
+	if problems := b.check(script); len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: bundle %s %s cannot run here:\n", b.manifest.Name, b.manifest.Version)
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "  %s\n", p)
+		}
+		os.Exit(2)
+	}
+	if runCwd == "" {
+		runCwd, _ = filepath.Abs(dir)
+	}
+	if repeatCount > 1 || flakeReport {
+		executeRepeated(bytes.NewReader(script))
+		return
+	}
+	preloadScript(script)
+	executeCommands(bufio.NewScanner(bytes.NewReader(script)))
+}
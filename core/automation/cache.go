@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStats counts warm cache use since the executor started, so in serve
+// mode they span runs
+type CacheStats struct {
+	Templates CacheCounts `json:"templates"`
+	Regexps   CacheCounts `json:"regexps"`
+	// A11y hits reuse the running accessibility helper; misses start it
+	A11y CacheCounts `json:"a11y"`
+	// OCR hits are whole-screen reads that reused words of the last one,
+	// reading only what changed; misses read the whole screen
+	OCR CacheCounts `json:"ocr"`
+}
+
+// CacheCounts is the use of one cache
+type CacheCounts struct {
+	Hits    int `json:"hits"`
+	Misses  int `json:"misses"`
+	Entries int `json:"entries"`
+}
+
+// warmCache holds decoded template images, compiled regexes and a running
+// accessibility helper for the life of the process
+type warmCache struct {
+	mu        sync.Mutex
+	templates map[string]cachedTemplate
+	used      int64 // ticks each template use, for eviction
+	regexps   map[string]*regexp.Regexp
+	a11y      *a11yHelper
+	// a11yRetry is when to try the helper again after it failed; until
+	// then the tree is dumped one shot at a time
+	a11yRetry time.Time
+	stats     CacheStats
+}
+
+// warmTemplates is how many decoded templates are kept; the least recently
+// used goes first
+const warmTemplates = 64
+
+// a11yBackoff is how long a failed accessibility helper is left before it
+// is started again
+const a11yBackoff = time.Minute
+
+var warm = &warmCache{templates: map[string]cachedTemplate{}, regexps: map[string]*regexp.Regexp{}}
+
+// cachedTemplate is a decoded image, valid while its file is unchanged
+type cachedTemplate struct {
+	img     image.Image
+	modTime time.Time
+	size    int64
+	used    int64
+}
+
+// loadTemplate decodes a template image, reusing the last decode until the
+// file changes. Relative paths are the script's.
+func loadTemplate(file string) (image.Image, error) {
+	file = scriptPath(file)
+	info, err := os.Stat(file)
+	if err != nil {
+		return loadPNG(file) // for its error
+	}
+	key, _ := filepath.Abs(file)
+	warm.mu.Lock()
+	cached, ok := warm.templates[key]
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		warm.stats.Templates.Hits++
+		warm.used++
+		cached.used = warm.used
+		warm.templates[key] = cached
+		warm.mu.Unlock()
+		return cached.img, nil
+	}
+	warm.stats.Templates.Misses++
+	warm.mu.Unlock()
+
+	img, err := loadPNG(file)
+	if err != nil {
+		return nil, err
+	}
+	warm.mu.Lock()
+	warm.used++
+	warm.templates[key] = cachedTemplate{img: img, modTime: info.ModTime(), size: info.Size(), used: warm.used}
+	for len(warm.templates) > warmTemplates {
+		oldest := ""
+		for k, t := range warm.templates {
+			if oldest == "" || t.used < warm.templates[oldest].used {
+				oldest = k
+			}
+		}
+		delete(warm.templates, oldest)
+	}
+	warm.mu.Unlock()
+	return img, nil
+}
+
+// compileRegexp compiles expr once per process
+func compileRegexp(expr string) (*regexp.Regexp, error) {
+	warm.mu.Lock()
+	defer warm.mu.Unlock()
+	if re, ok := warm.regexps[expr]; ok {
+		warm.stats.Regexps.Hits++
+		return re, nil
+	}
+	warm.stats.Regexps.Misses++
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	warm.regexps[expr] = re
+	return re, nil
+}
+
+// cacheStats returns the counts so far, or nil if no cache was used
+func cacheStats() *CacheStats {
+	warm.mu.Lock()
+	defer warm.mu.Unlock()
+	stats := warm.stats
+	stats.Templates.Entries = len(warm.templates)
+	stats.Regexps.Entries = len(warm.regexps)
+	if warm.a11y != nil {
+		stats.A11y.Entries = 1
+	}
+	lastOCR.Lock()
+	if lastOCR.read.strips != nil {
+		stats.OCR.Entries = 1
+	}
+	lastOCR.Unlock()
+	if stats == (CacheStats{}) {
+		return nil
+	}
+	return &stats
+}
+
+// preloadScript warms the caches for everything a script refers to before
+// it runs: its template images, the redaction patterns and, if a step reads
+// the accessibility tree, the accessibility helper. Failures are left for
+// the steps themselves to report.
+func preloadScript(data []byte) {
+	a11y, seen := false, map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "@") || markerLine(line) {
+			continue
+		}
+		cmd := parseCommand(line)
+		if cmd == nil {
+			continue
+		}
+		var files []string
+		for _, v := range cmd.Params {
+			switch v := v.(type) {
+			case string:
+				if strings.HasSuffix(strings.ToLower(v), ".png") {
+					files = append(files, v)
+				}
+			case PerceptionRequest:
+				files = append(files, v.Templates...)
+				a11y = a11y || v.A11y
+			}
+		}
+		for _, file := range files {
+			if !seen[file] {
+				seen[file] = true
+				loadTemplate(file)
+			}
+		}
+	}
+	if cfg, err := currentConfig(); err == nil {
+		compileRedactPatterns(cfg.Redact.Patterns)
+	}
+	if a11y {
+		warm.mu.Lock()
+		warm.startA11y()
+		warm.mu.Unlock()
+	}
+}
+
+// a11yHelper is accessibility.py kept running with --serve-json: it dumps
+// the tree once for every line it reads, so the AT-SPI connection is made
+// once rather than on every dump. mu keeps one dump at a time on its pipes.
+type a11yHelper struct {
+	mu  sync.Mutex
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+// startA11y starts the helper unless it is running or failed within the
+// backoff. Called with mu held.
+func (w *warmCache) startA11y() *a11yHelper {
+	if w.a11y != nil || clock.Now().Before(w.a11yRetry) {
+		return w.a11y
+	}
+	cfg, err := currentConfig()
+	if err != nil || len(cfg.A11y.Command) > 0 {
+		return nil // a configured command is run one shot
+	}
+	script, err := a11yScript()
+	if err != nil {
+		w.a11yRetry = clock.Now().Add(a11yBackoff)
+		return nil
+	}
+	cmd := exec.Command("python3", script, "--serve-json")
+	in, err := cmd.StdinPipe()
+	if err == nil {
+		var out io.ReadCloser
+		if out, err = cmd.StdoutPipe(); err == nil {
+			if err = cmd.Start(); err == nil {
+				w.a11y = &a11yHelper{cmd: cmd, in: in, out: bufio.NewReaderSize(out, 1<<20)}
+				w.stats.A11y.Misses++
+				return w.a11y
+			}
+		}
+	}
+	w.a11yRetry = clock.Now().Add(a11yBackoff)
+	return nil
+}
+
+// dumpA11y dumps the tree through the helper. ok is false when the helper
+// is unavailable and the caller should dump one shot instead. The cache is
+// not held during the dump, which can take seconds on a busy desktop.
+func (w *warmCache) dumpA11y() (elements []A11yElement, ok bool) {
+	w.mu.Lock()
+	started := w.a11y != nil
+	h := w.startA11y()
+	w.mu.Unlock()
+	if h == nil {
+		return nil, false
+	}
+	line, err := h.dump()
+	if err == nil {
+		err = json.Unmarshal(line, &elements)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		// Another dump may have given up on it already
+		if w.a11y == h {
+			h.stop()
+			w.a11y, w.a11yRetry = nil, clock.Now().Add(a11yBackoff)
+		}
+		return nil, false
+	}
+	if started {
+		w.stats.A11y.Hits++
+	}
+	return elements, true
+}
+
+func (h *a11yHelper) dump() ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := io.WriteString(h.in, "dump\n"); err != nil {
+		return nil, err
+	}
+	line, err := h.out.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("a11y helper: %v", err)
+	}
+	return bytes.TrimSpace(line), nil
+}
+
+func (h *a11yHelper) stop() {
+	h.in.Close()
+	h.cmd.Process.Kill()
+	h.cmd.Wait()
+}
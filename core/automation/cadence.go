@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ScreenshotConfig sets when a screenshot is taken after a step
+type ScreenshotConfig struct {
+	// Cadence is adaptive (the default) or every, for a screenshot after
+	// every step
+	Cadence string `json:"cadence"`
+	// Actions overrides the adaptive policy per action: always, never or
+	// coalesce
+	Actions map[string]string `json:"actions"`
+}
+
+// screenshotCadence is set by --screenshot-cadence and overrides the config
+var screenshotCadence string
+
+var screenshotCadences = []string{"adaptive", "every"}
+
+// defaultScreenshotPolicies is the adaptive cadence. Actions that cannot
+// change the screen are never captured. Input actions coalesce: in a run
+// of them only the last is captured, just before the next step of another
+// kind or at the end of the script. Other actions, and every failed step,
+// are always captured.
+var defaultScreenshotPolicies = map[string]string{
+	"pointer":          "never",
+	"pointer_rel":      "never",
+	"wait":             "never",
+	"read_text":        "never",
+	"read_qr":          "never",
+	"monitors":         "never",
+	"keys":             "never",
+	"display":          "never",
+	"observe":          "never",
+	"assert_text":      "never",
+	"find_text":        "never",
+	"assert_image":     "never",
+	"assert_screen":    "never",
+	"snapshot_session": "never",
+	"clear_clipboard":  "never",
+	"do_not_disturb":   "never",
+	"set_capslock":     "never",
+	"set_numlock":      "never",
+	"tty_open":         "never",
+	"tty_send":         "never",
+	"tty_expect":       "never",
+	"wait_for_sound":   "never",
+	"speak":            "never",
+	"beep":             "never",
+	"capture_camera":   "never",
+	"click":            "coalesce",
+	"type":             "coalesce",
+	"type_verified":    "coalesce",
+	"type_totp":        "coalesce",
+	"key":              "coalesce",
+	"keydown":          "coalesce",
+	"keyup":            "coalesce",
+	"scroll":           "coalesce",
+	"drag":             "coalesce",
+	"click_text":       "coalesce",
+	"click_image":      "coalesce",
+	"click_described":  "coalesce",
+}
+
+var screenshotPolicyNames = []string{"always", "never", "coalesce"}
+
+// checkScreenshotConfig validates the cadence settings at startup
+func checkScreenshotConfig(cfg ScreenshotConfig) error {
+	if screenshotCadence != "" && !contains(screenshotCadences, screenshotCadence) {
+		return fmt.Errorf("unknown --screenshot-cadence %q (want adaptive or every)", screenshotCadence)
+	}
+	if cfg.Cadence != "" && !contains(screenshotCadences, cfg.Cadence) {
+		return fmt.Errorf("screenshots.cadence: unknown cadence %q (want adaptive or every)", cfg.Cadence)
+	}
+	for action, policy := range cfg.Actions {
+		if !contains(screenshotPolicyNames, policy) {
+			return fmt.Errorf("screenshots.actions.%s: unknown policy %q (want always, never or coalesce)", action, policy)
+		}
+	}
+	return nil
+}
+
+// screenshotPolicy returns always, never or coalesce for an action
+func screenshotPolicy(action string) string {
+	cfg, _ := currentConfig()
+	cadence := screenshotCadence
+	if cadence == "" {
+		cadence = cfg.Screenshots.Cadence
+	}
+	if cadence == "every" {
+		return "always"
+	}
+	if policy, ok := cfg.Screenshots.Actions[action]; ok {
+		return policy
+	}
+	if policy, ok := defaultScreenshotPolicies[action]; ok {
+		return policy
+	}
+	if strings.HasPrefix(action, "get_") {
+		return "never"
+	}
+	return "always"
+}
+
+// screenshot takes, defers or skips the capture after a step
+func (r *runner) screenshot(step *StepResult) {
+	policy := screenshotPolicy(step.Action)
+	if step.Status == "error" {
+		policy = "always"
+	}
+	switch policy {
+	case "never":
+		return
+	case "coalesce":
+		r.pendingShot = step
+		return
+	}
+	r.pendingShot = nil // superseded by this capture
+	r.capture(step)
+}
+
+// flushScreenshot takes the capture deferred for the last step of a run of
+// coalesced steps
+func (r *runner) flushScreenshot() {
+	if step := r.pendingShot; step != nil {
+		r.pendingShot = nil
+		r.capture(step)
+	}
+}
+
+func (r *runner) capture(step *StepResult) {
+	file := takeScreenshot(step.Step, step.Action)
+	if file == "" {
+		return
+	}
+	shot := Screenshot{Step: step.Step, Name: step.Name, File: file, Action: step.Action}
+	r.result.Screenshots = append(r.result.Screenshots, shot)
+	step.Screenshot = &shot
+	if r.onCapture != nil {
+		r.onCapture(step)
+	}
+	r.transcript.readScreen(step)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HostTiming holds the measured latencies of this host and the factor that
+// `wait auto` scales scripted waits by
+type HostTiming struct {
+	Host            string    `json:"host"`
+	CalibratedAt    time.Time `json:"calibrated_at"`
+	WindowOpenMs    float64   `json:"window_open_ms"`
+	InputToRenderMs float64   `json:"input_to_render_ms"`
+	ScreenshotMs    float64   `json:"screenshot_ms"`
+	WaitFactor      float64   `json:"wait_factor"`
+}
+
+// Latencies of the reference machine scripts are assumed to be written on
+const (
+	referenceWindowOpenMs    = 20.0
+	referenceInputToRenderMs = 40.0
+)
+
+const (
+	minWaitFactor      = 0.5
+	maxWaitFactor      = 5.0
+	calibrationSamples = 5
+)
+
+var (
+	hostTimingOnce sync.Once
+	hostTiming     HostTiming
+)
+
+// configDir is where per-user executor state lives
+func configDir() string {
+	if portableDir != "" {
+		return filepath.Join(portableDir, "config")
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "agentos")
+}
+
+func hostTimingFile() string {
+	host, _ := os.Hostname()
+	return filepath.Join(configDir(), "calibration", host+".json")
+}
+
+// currentHostTiming loads this host's calibration, defaulting to a factor
+// of 1 when the host has never been calibrated
+func currentHostTiming() HostTiming {
+	hostTimingOnce.Do(func() {
+		hostTiming = HostTiming{WaitFactor: 1}
+		data, err := os.ReadFile(hostTimingFile())
+		if err != nil {
+			return
+		}
+		var t HostTiming
+		if json.Unmarshal(data, &t) == nil && t.WaitFactor > 0 {
+			hostTiming = t
+		}
+	})
+	return hostTiming
+}
+
+func runCalibrate(args []string) {
+	fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "measure and print without saving")
+	fs.Parse(args)
+
+	timing, err := calibrate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Calibration failed: %v\n", err)
+		os.Exit(1)
+	}
+	if !*dryRun {
+		if err := saveHostTiming(timing); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving calibration: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	jsonOutput, _ := json.MarshalIndent(timing, "", "  ")
+	fmt.Println(string(jsonOutput))
+}
+
+func saveHostTiming(timing HostTiming) error {
+	file := hostTimingFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(timing, "", "  ")
+	return os.WriteFile(file, data, 0644)
+}
+
+// calibrate measures window-open, input-to-render and screenshot latency
+// against the calibration window
+func calibrate() (HostTiming, error) {
+	x, err := openX("")
+	if err != nil {
+		return HostTiming{}, err
+	}
+	defer x.Close()
+
+	var windowOpen, inputToRender, screenshot []time.Duration
+	var w *calibrationWindow
+	for i := 0; i < calibrationSamples; i++ {
+		if w != nil {
+			w.close()
+			drainEvents(x, func() {})
+		}
+		start := time.Now()
+		if w, err = openCalibrationWindow(x); err != nil {
+			return HostTiming{}, err
+		}
+		if _, ok := waitEvent(x, xExpose, 2*time.Second, func() {}); !ok {
+			w.close()
+			return HostTiming{}, fmt.Errorf("calibration window never became visible")
+		}
+		windowOpen = append(windowOpen, time.Since(start))
+	}
+	defer w.close()
+	w.draw()
+	x.flush()
+
+	target := selftestTargets[0]
+	px := selftestX + target.x + target.size/2
+	py := selftestY + target.y + target.size/2
+	if err := executeCommand(parseCommand(fmt.Sprintf("pointer %d %d", px, py))); err != nil {
+		return HostTiming{}, err
+	}
+	for i := 0; i < calibrationSamples; i++ {
+		drainEvents(x, w.draw)
+		start := time.Now()
+		if err := executeCommand(parseCommand("click 1 s")); err != nil {
+			return HostTiming{}, err
+		}
+		if _, ok := waitEvent(x, xButtonPress, 2*time.Second, w.draw); !ok {
+			return HostTiming{}, fmt.Errorf("click never reached the calibration window")
+		}
+		inputToRender = append(inputToRender, time.Since(start))
+	}
+
+	for i := 0; i < calibrationSamples; i++ {
+		start := time.Now()
+		frame, err := captureScreen()
+		if err != nil {
+			return HostTiming{}, err
+		}
+		releaseFrame(frame)
+		screenshot = append(screenshot, time.Since(start))
+	}
+
+	host, _ := os.Hostname()
+	t := HostTiming{
+		Host:            host,
+		CalibratedAt:    time.Now().UTC(),
+		WindowOpenMs:    medianMs(windowOpen),
+		InputToRenderMs: medianMs(inputToRender),
+		ScreenshotMs:    medianMs(screenshot),
+	}
+	// Waits in scripts mostly cover windows appearing and UI reacting to
+	// input, so those two drive the factor; capture time is informational.
+	factor := t.WindowOpenMs / referenceWindowOpenMs
+	if f := t.InputToRenderMs / referenceInputToRenderMs; f > factor {
+		factor = f
+	}
+	t.WaitFactor = clamp(factor, minWaitFactor, maxWaitFactor)
+	return t, nil
+}
+
+func medianMs(samples []time.Duration) float64 {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return float64(sorted[len(sorted)/2].Microseconds()) / 1000
+}
+
+func clamp(v, lo, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CameraConfig sets up capture_camera
+type CameraConfig struct {
+	// Device is the V4L2 device (default /dev/video0)
+	Device string `json:"device"`
+	// Size asks the camera for a resolution, e.g. "1280x720"
+	Size string `json:"size"`
+	// Warmup is how long frames are dropped while exposure and focus
+	// settle, e.g. "1s" (default 1s)
+	Warmup string `json:"warmup"`
+}
+
+const (
+	defaultCameraDevice = "/dev/video0"
+	defaultCameraWarmup = time.Second
+)
+
+var cameraSizePattern = regexp.MustCompile(`^[0-9]+x[0-9]+$`)
+
+// checkCameraConfig validates the camera section at startup
+func checkCameraConfig(c CameraConfig) error {
+	if c.Size != "" && !cameraSizePattern.MatchString(c.Size) {
+		return fmt.Errorf("camera.size: want WIDTHxHEIGHT, got %q", c.Size)
+	}
+	if c.Warmup != "" {
+		if d, err := time.ParseDuration(c.Warmup); err != nil || d < 0 {
+			return fmt.Errorf("camera.warmup: invalid duration %q", c.Warmup)
+		}
+	}
+	return nil
+}
+
+// parseCaptureCamera reads capture_camera FILE [DEVICE]. The photo is a
+// JPEG or PNG by its extension.
+func parseCaptureCamera(cmd *Command, parts []string) *Command {
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil
+	}
+	file := strings.Trim(parts[1], "\"")
+	if !validCameraFile(file) {
+		return nil
+	}
+	cmd.Params["file"] = file
+	if len(parts) == 3 {
+		cmd.Params["device"] = parts[2]
+	}
+	return cmd
+}
+
+func validCameraFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
+
+// captureCamera saves one frame from a webcam with ffmpeg's V4L2 input, to
+// document hardware next to the screen: LEDs, e-ink panels, displays. A
+// relative file goes in the screenshots directory.
+func captureCamera(cmd *Command) error {
+	cfg, err := currentConfig()
+	if err != nil {
+		return err
+	}
+	c := cfg.Camera
+	device, _ := cmd.Params["device"].(string)
+	device = firstNonEmpty(device, c.Device, defaultCameraDevice)
+	file := cmd.Params["file"].(string)
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(screenshotsDir, file)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	warmup := defaultCameraWarmup
+	if c.Warmup != "" {
+		warmup, _ = time.ParseDuration(c.Warmup)
+	}
+
+	args := []string{"-y", "-loglevel", "error", "-f", "v4l2"}
+	if c.Size != "" {
+		args = append(args, "-video_size", c.Size)
+	}
+	args = append(args, "-i", device, "-ss", strconv.FormatFloat(warmup.Seconds(), 'f', -1, 64), "-frames:v", "1")
+	if ext := strings.ToLower(filepath.Ext(file)); ext == ".jpg" || ext == ".jpeg" {
+		args = append(args, "-q:v", "2")
+	}
+	args = append(args, file)
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("capture_camera needs ffmpeg")
+	}
+	if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("capturing from %s: %v: %s", device, err, strings.TrimSpace(string(out)))
+	}
+	if _, err := os.Stat(file); err != nil {
+		return fmt.Errorf("capturing from %s: no frame was saved", device)
+	}
+	cmd.Output = map[string]interface{}{"file": file, "device": device}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// A bundle's requires.capabilities names what the host's desktop must
+// offer, beyond its platform, backend and tools:
+//
+//	wayland, x11               the session type
+//	a11y                       the accessibility tree can be read
+//	ocr, ocr:deu               OCR, and with tesseract the language's data
+//	monitor>=2                 at least that many monitors
+//	resolution>=1920x1080      a screen at least that large
+//
+// Each one missing is reported before the run starts, rather than as a
+// failed step halfway through.
+
+// capabilityCheckers check a capability by name, given what follows the
+// name (":deu", ">=2"), returning why it is missing or ""
+var capabilityCheckers = map[string]func(arg string) string{
+	"wayland":    checkWaylandCapability,
+	"x11":        checkX11Capability,
+	"a11y":       checkA11yCapability,
+	"ocr":        checkOCRCapability,
+	"monitor":    checkMonitorCapability,
+	"resolution": checkResolutionCapability,
+}
+
+// checkCapabilities lists the capabilities the host lacks
+func checkCapabilities(capabilities []string) []string {
+	var problems []string
+	for _, c := range capabilities {
+		name, arg := c, ""
+		if i := strings.IndexAny(c, ":>"); i >= 0 {
+			name, arg = c[:i], c[i:]
+		}
+		check, ok := capabilityCheckers[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown capability %q", c))
+			continue
+		}
+		if why := check(arg); why != "" {
+			problems = append(problems, fmt.Sprintf("needs %s: %s", c, why))
+		}
+	}
+	return problems
+}
+
+// atLeast parses a ">=N" argument
+func atLeast(arg string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, ">="))
+	return n, err == nil && strings.HasPrefix(arg, ">=") && n > 0
+}
+
+func checkWaylandCapability(arg string) string {
+	if arg != "" {
+		return "takes no argument"
+	}
+	if os.Getenv("WAYLAND_DISPLAY") == "" && os.Getenv("XDG_SESSION_TYPE") != "wayland" {
+		return "not a Wayland session"
+	}
+	return ""
+}
+
+func checkX11Capability(arg string) string {
+	if arg != "" {
+		return "takes no argument"
+	}
+	if os.Getenv("DISPLAY") == "" {
+		return "DISPLAY is not set"
+	}
+	x, err := openX("")
+	if err != nil {
+		return err.Error()
+	}
+	x.Close()
+	return ""
+}
+
+// checkA11yCapability looks for the dump command and, for the bundled
+// helper, the AT-SPI bindings it imports
+func checkA11yCapability(arg string) string {
+	if arg != "" {
+		return "takes no argument"
+	}
+	cfg, err := currentConfig()
+	if err != nil {
+		return err.Error()
+	}
+	if len(cfg.A11y.Command) > 0 {
+		if _, err := exec.LookPath(cfg.A11y.Command[0]); err != nil {
+			return fmt.Sprintf("%s is not in PATH", cfg.A11y.Command[0])
+		}
+		return ""
+	}
+	script, err := a11yScript()
+	if err != nil {
+		return err.Error()
+	}
+	if _, err := os.Stat(script); err != nil {
+		return "accessibility.py is not next to the executor"
+	}
+	check := exec.Command("python3", "-c", "import gi; gi.require_version('Atspi', '2.0'); from gi.repository import Atspi")
+	if err := check.Run(); err != nil {
+		return "python3 cannot import the AT-SPI bindings (install python3-gi and gir1.2-atspi-2.0)"
+	}
+	return ""
+}
+
+// checkOCRCapability checks the provider exists and, for tesseract, that
+// each language of ocr:LANG or ocr:eng+deu is installed. Other providers
+// are assumed to read any language.
+func checkOCRCapability(arg string) string {
+	if arg != "" && !strings.HasPrefix(arg, ":") {
+		return "want ocr or ocr:LANG"
+	}
+	provider, _, err := currentOCR()
+	if err != nil {
+		return err.Error()
+	}
+	if provider.Name() != "tesseract" {
+		return ""
+	}
+	out, err := exec.Command("tesseract", "--list-langs").CombinedOutput()
+	if err != nil {
+		return fmt.Sprintf("tesseract: %v", commandError(err))
+	}
+	installed := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		installed[strings.TrimSpace(line)] = true
+	}
+	var missing []string
+	for _, lang := range strings.Split(strings.TrimPrefix(arg, ":"), "+") {
+		if lang != "" && !installed[lang] {
+			missing = append(missing, lang)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Sprintf("tesseract has no %s language data", strings.Join(missing, ", "))
+	}
+	return ""
+}
+
+func checkMonitorCapability(arg string) string {
+	want, ok := atLeast(arg)
+	if !ok {
+		return "want monitor>=N"
+	}
+	monitors, err := queryMonitors()
+	if err != nil {
+		return err.Error()
+	}
+	if len(monitors) < want {
+		return fmt.Sprintf("%d connected", len(monitors))
+	}
+	return ""
+}
+
+// checkResolutionCapability compares the size of a screenshot, which
+// spans every monitor
+func checkResolutionCapability(arg string) string {
+	ws, hs, ok := strings.Cut(strings.TrimPrefix(arg, ">="), "x")
+	w, errW := strconv.Atoi(ws)
+	h, errH := strconv.Atoi(hs)
+	if !ok || !strings.HasPrefix(arg, ">=") || errW != nil || errH != nil {
+		return "want resolution>=WIDTHxHEIGHT"
+	}
+	img, err := baseBackend().Capture()
+	if err != nil {
+		return err.Error()
+	}
+	defer releaseFrame(img)
+	if size := img.Bounds().Size(); size.X < w || size.Y < h {
+		return fmt.Sprintf("the screen is %dx%d", size.X, size.Y)
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chaosSpec and chaosSeed are set by --chaos and --chaos-seed
+var (
+	chaosSpec string
+	chaosSeed int64
+)
+
+// chaosRule injects a fault into one action type ("*" for all). A fail
+// rule fails the step with the given probability without running it; a
+// delay rule sleeps a random time up to the given duration first.
+type chaosRule struct {
+	kind     string
+	action   string
+	chance   float64
+	maxDelay time.Duration
+}
+
+type chaosMonkey struct {
+	rules []chaosRule
+	rng   *rand.Rand
+	seed  int64
+}
+
+// parseChaos reads "fail=click:0.1,delay=type:500ms"
+func parseChaos(spec string) ([]chaosRule, error) {
+	var rules []chaosRule
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kind, rest, ok := strings.Cut(item, "=")
+		action, value, ok2 := strings.Cut(rest, ":")
+		if !ok || !ok2 || action == "" {
+			return nil, fmt.Errorf("chaos rule %q: want kind=action:value", item)
+		}
+		rule := chaosRule{kind: kind, action: action}
+		switch kind {
+		case "fail":
+			p, err := strconv.ParseFloat(value, 64)
+			if err != nil || p < 0 || p > 1 {
+				return nil, fmt.Errorf("chaos rule %q: probability must be between 0 and 1", item)
+			}
+			rule.chance = p
+		case "delay":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("chaos rule %q: invalid duration", item)
+			}
+			rule.maxDelay = d
+		default:
+			return nil, fmt.Errorf("chaos rule %q: unknown kind %s (want fail or delay)", item, kind)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// newChaosMonkey returns nil when --chaos is not set. Without --chaos-seed
+// a seed is picked and reported so a failing run can be reproduced.
+func newChaosMonkey() (*chaosMonkey, error) {
+	if chaosSpec == "" {
+		return nil, nil
+	}
+	rules, err := parseChaos(chaosSpec)
+	if err != nil {
+		return nil, err
+	}
+	seed := chaosSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &chaosMonkey{rules: rules, rng: rand.New(rand.NewSource(seed)), seed: seed}, nil
+}
+
+// inject applies the rules for an action before it runs. It returns the
+// events to report and a non-nil error if the step must fail.
+func (c *chaosMonkey) inject(step int, action string) ([]Event, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var events []Event
+	for _, rule := range c.rules {
+		if rule.action != action && rule.action != "*" {
+			continue
+		}
+		switch rule.kind {
+		case "delay":
+			d := time.Duration(c.rng.Int63n(int64(rule.maxDelay) + 1))
+			events = append(events, Event{Step: step, Type: "chaos", Message: fmt.Sprintf("delayed %s by %v", action, d.Round(time.Millisecond))})
+			clock.Sleep(d)
+		case "fail":
+			if c.rng.Float64() < rule.chance {
+				msg := fmt.Sprintf("injected failure of %s (seed %d)", action, c.seed)
+				events = append(events, Event{Step: step, Type: "chaos", Message: msg})
+				return events, fmt.Errorf("chaos: %s", msg)
+			}
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import "strconv"
+
+// click takes the button and count by name and, optionally, where to
+// click, so a target is clicked in one line:
+//
+//	click                       left single click where the pointer is
+//	click right 640 400         move there, then right click
+//	click double 2:100 200      double click on monitor 2
+//	click middle triple
+//
+// The button is left, right or middle (default left) and the count single,
+// double or triple (default single); either may be given first. The older
+// click BUTTON s|d, with the button as an X button number from 1 to 9,
+// still reads as before, so click 4 s and click 5 s turn the wheel.
+
+// clickButtons maps button names to the numbers backends take
+var clickButtons = map[string]int{"left": 1, "middle": 2, "right": 3}
+
+// maxClickButton is the highest X button number click takes: 4 to 7 are
+// the wheel, 8 and 9 back and forward
+const maxClickButton = 9
+
+// clickCounts maps click counts, by name or letter, to the letter of the
+// clicks param
+var clickCounts = map[string]string{
+	"single": "s", "double": "d", "triple": "t",
+	"s": "s", "d": "d", "t": "t",
+}
+
+// clickRepeats is how many clicks each letter of the clicks param makes
+var clickRepeats = map[string]int{"s": 1, "d": 2, "t": 3}
+
+// parseClick reads click [left|right|middle] [single|double|triple] [X Y]
+// and click BUTTON s|d
+func parseClick(cmd *Command, parts []string) *Command {
+	args := parts[1:]
+	cmd.Params["button"], cmd.Params["clicks"] = 1, "s"
+	var button, count bool
+	// click BUTTON s|d, told apart from click X Y by its count
+	if len(args) >= 2 && clickCounts[args[1]] != "" {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			if n < 1 || n > maxClickButton {
+				return nil
+			}
+			cmd.Params["button"], button = n, true
+			args = args[1:]
+		}
+	}
+	for len(args) > 0 {
+		if n, ok := clickButtons[args[0]]; ok && !button {
+			cmd.Params["button"], button = n, true
+		} else if c, ok := clickCounts[args[0]]; ok && !count {
+			cmd.Params["clicks"], count = c, true
+		} else {
+			break
+		}
+		args = args[1:]
+	}
+	switch len(args) {
+	case 0:
+		return cmd
+	case 2:
+		if parsePoint(cmd, coordinateParams[0], args[0], args[1]) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// clickAt runs click, moving the pointer first when the step has a
+// position
+func clickAt(cmd *Command, input Backend) error {
+	if x, ok := cmd.Params["x"].(int); ok {
+		if err := movePointer(input, x, cmd.Params["y"].(int)); err != nil {
+			return err
+		}
+	}
+	return input.Click(cmd.Params["button"].(int), clickRepeats[cmd.Params["clicks"].(string)])
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time steps are paced and timed by: wait, drag, retries,
+// @timeout and @budget, and the polling of print_to_pdf, tty_expect and
+// wait_for_sound. Embedders and tests set clock to a fakeClock, under
+// which those run at once and still see the time they waited pass.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// After delivers the time on the channel once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// clock is the Clock in use, the wall clock unless replaced before a run
+var clock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// since is time.Since on the clock
+func since(t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
+
+// fakeClock is a frozen clock that moves only when slept on or advanced.
+// Sleep returns at once, having moved the time on by d, so a wait 30 step
+// takes no real time but a step's duration still reads 30s; an After
+// channel fires when the time passes its deadline.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(start time.Time) *fakeClock {
+	return &fakeClock{now: start}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{c.now.Add(d), ch})
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+	return ch
+}
+
+// Advance moves the time on by d, firing the After channels it passes
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	for len(c.waiters) > 0 && !c.waiters[0].at.After(c.now) {
+		c.waiters[0].ch <- c.now
+		c.waiters = c.waiters[1:]
+	}
+}
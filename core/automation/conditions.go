@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"time"
+)
+
+// Preconditions and postconditions state what a step assumes and what it
+// should bring about, as annotations:
+//
+//	@pre: window "Checkout"
+//	@post: text "Order confirmed"
+//	click_text "Place order"
+//
+// A condition is one of
+//
+//	window "TITLE"   the active window's title contains TITLE
+//	text "TEXT"      TEXT is on the screen
+//	image FILE       the template image is on the screen
+//
+// and "not" before one turns it around: @pre: not text "Sign in". Any
+// number of each may be given; all must hold. Preconditions are checked
+// once, just before the step, which fails with PRECONDITION_FAILED without
+// running if one does not hold. Postconditions are checked after a step
+// that succeeded, for up to postconditionWait while the screen catches up,
+// and fail it with POSTCONDITION_FAILED.
+
+const (
+	preconditionError  = "PRECONDITION_FAILED"
+	postconditionError = "POSTCONDITION_FAILED"
+)
+
+// postconditionWait is how long postconditions get to hold, checked every
+// postconditionPoll
+const (
+	postconditionWait = 5 * time.Second
+	postconditionPoll = 500 * time.Millisecond
+)
+
+var conditionKinds = []string{"window", "text", "image"}
+
+// condition is one @pre or @post condition
+type condition struct {
+	not  bool
+	kind string
+	arg  string
+}
+
+func (c condition) String() string {
+	s := fmt.Sprintf("%s %q", c.kind, c.arg)
+	if c.not {
+		s = "not " + s
+	}
+	return s
+}
+
+// parseConditions reads the conditions of a @pre or @post value, one per
+// line as addAnnotation collects them
+func parseConditions(value string) ([]condition, error) {
+	var conds []condition
+	for _, line := range strings.Split(value, "\n") {
+		var c condition
+		kind, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if kind == "not" {
+			c.not = true
+			kind, arg, _ = strings.Cut(strings.TrimSpace(arg), " ")
+		}
+		c.kind, c.arg = kind, strings.Trim(strings.TrimSpace(arg), "\"")
+		if !contains(conditionKinds, c.kind) {
+			return nil, fmt.Errorf("unknown condition %q: want window, text or image, optionally after not", line)
+		}
+		if c.arg == "" {
+			return nil, fmt.Errorf("condition %s needs a value", c.kind)
+		}
+		conds = append(conds, c)
+	}
+	return conds, nil
+}
+
+// holds checks the condition, describing what was found when it does not
+func (c condition) holds() (bool, string, error) {
+	var found bool
+	var seen string
+	switch c.kind {
+	case "window":
+		title, err := activeWindowTitle()
+		if err != nil {
+			return false, "", err
+		}
+		found = strings.Contains(strings.ToLower(title), strings.ToLower(c.arg))
+		seen = fmt.Sprintf("the active window is %q", title)
+	case "text":
+		words, err := recognizeScreen(image.Rectangle{})
+		if err != nil {
+			return false, "", err
+		}
+		var box OCRWord
+		if box, found = findText(words, c.arg); found {
+			seen = fmt.Sprintf("found at %d,%d", box.X, box.Y)
+		}
+	case "image":
+		screen, err := captureScreen()
+		if err != nil {
+			return false, "", err
+		}
+		match, err := matchOnFrame(screen, c.arg, defaultImageThreshold)
+		releaseFrame(screen)
+		if err != nil {
+			return false, "", err
+		}
+		found = match.Found
+		seen = fmt.Sprintf("best match %.3f at %d,%d", match.Score, match.X, match.Y)
+		if found {
+			seen = fmt.Sprintf("found at %d,%d", match.X, match.Y)
+		}
+	}
+	return found != c.not, seen, nil
+}
+
+// checkConditions checks the @pre or @post conditions of a step, returning
+// the step's error if one does not hold
+func checkConditions(value string, post bool) error {
+	if value == "" {
+		return nil
+	}
+	conds, _ := parseConditions(value) // validated with the annotation
+	code, which := preconditionError, "precondition"
+	if post {
+		code, which = postconditionError, "postcondition"
+	}
+	start := clock.Now()
+	for {
+		failed, err := failedCondition(conds)
+		if err != nil {
+			return fmt.Errorf("%s: checking %ss: %v", code, which, err)
+		}
+		if failed == "" {
+			return nil
+		}
+		if !post || since(start) >= postconditionWait {
+			return fmt.Errorf("%s: %s", code, failed)
+		}
+		clock.Sleep(postconditionPoll)
+	}
+}
+
+// failedCondition describes the first of conds that does not hold, or is
+// empty if they all do
+func failedCondition(conds []condition) (string, error) {
+	for _, c := range conds {
+		ok, seen, err := c.holds()
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", c, err)
+		}
+		if !ok {
+			if seen != "" {
+				return fmt.Sprintf("%s does not hold (%s)", c, seen), nil
+			}
+			return fmt.Sprintf("%s does not hold", c), nil
+		}
+	}
+	return "", nil
+}
+
+// conditionImages lists the template images of a @pre or @post value
+func conditionImages(value string) []string {
+	conds, _ := parseConditions(value)
+	var files []string
+	for _, c := range conds {
+		if c.kind == "image" {
+			files = append(files, c.arg)
+		}
+	}
+	return files
+}
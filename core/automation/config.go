@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Config is the executor's optional JSON configuration file
+type Config struct {
+	OCR       OCRConfig       `json:"ocr"`
+	Grounding GroundingConfig `json:"grounding"`
+	A11y      A11yConfig      `json:"a11y"`
+	Redact    RedactConfig    `json:"redact"`
+	Retention RetentionConfig `json:"retention"`
+	Serve     ServeConfig     `json:"serve"`
+	Push      PushConfig      `json:"push"`
+	Notify    NotifyConfig    `json:"notify"`
+	Alerts    AlertConfig     `json:"alerts"`
+	Mock      MockConfig      `json:"mock"`
+	QMP       QMPConfig       `json:"qmp"`
+	VNC       VNCConfig       `json:"vnc"`
+
+	Credentials CredentialsConfig `json:"credentials"`
+	Camera      CameraConfig      `json:"camera"`
+	LockKeys    LockKeysConfig    `json:"lock_keys"`
+	Modifiers   ModifiersConfig   `json:"modifiers"`
+	ScreenLock  ScreenLockConfig  `json:"screen_lock"`
+	Recovery    RecoveryConfig    `json:"recovery"`
+	Typing      TypingConfig      `json:"typing"`
+	Registry    RegistryConfig    `json:"registry"`
+
+	Screenshots ScreenshotConfig `json:"screenshots"`
+}
+
+var (
+	configPath = filepath.Join(configDir(), "executor.json")
+	configOnce sync.Once
+	config     Config
+	configErr  error
+)
+
+// currentConfig loads the config file on first use. A missing file at the
+// default location is not an error; a missing file passed with --config is.
+func currentConfig() (Config, error) {
+	configOnce.Do(func() {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			if os.IsNotExist(err) && configPath == filepath.Join(configDir(), "executor.json") {
+				return
+			}
+			configErr = fmt.Errorf("reading config: %v", err)
+			return
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			configErr = fmt.Errorf("parsing config %s: %v", configPath, err)
+		}
+	})
+	return config, configErr
+}
+
+// secretValue resolves "env:NAME" references so secrets can stay out of
+// the config file
+func secretValue(v string) string {
+	if name, ok := strings.CutPrefix(v, "env:"); ok {
+		return os.Getenv(name)
+	}
+	return v
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// CredentialsConfig says where cred:SERVICE/FIELD references are looked up
+type CredentialsConfig struct {
+	// File holds a JSON object of services, each an object of fields:
+	// {"github": {"totp-secret": "JBSWY3DPEHPK3PXP"}}. Values may be
+	// "env:NAME". Default: credentials.json in the config directory.
+	File string `json:"file"`
+	// Command, when set, is used instead of File: it is run with
+	// SERVICE/FIELD as its last argument and prints the value, e.g.
+	// ["pass", "show"]
+	Command []string `json:"command"`
+}
+
+var credentialRef = regexp.MustCompile(`^cred:([A-Za-z0-9._-]+)/([A-Za-z0-9._-]+)$`)
+
+func validCredentialRef(ref string) bool {
+	return credentialRef.MatchString(ref)
+}
+
+// resolveCredential looks up a cred:SERVICE/FIELD reference. Values never
+// appear in errors.
+func resolveCredential(ref string) (string, error) {
+	m := credentialRef.FindStringSubmatch(ref)
+	if m == nil {
+		return "", fmt.Errorf("%q is not a cred:SERVICE/FIELD reference", ref)
+	}
+	service, field := m[1], m[2]
+	cfg, err := currentConfig()
+	if err != nil {
+		return "", err
+	}
+	c := cfg.Credentials
+	if len(c.Command) > 0 {
+		args := append(append([]string{}, c.Command[1:]...), service+"/"+field)
+		out, err := exec.Command(c.Command[0], args...).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s: credentials command: %v", ref, commandError(err))
+		}
+		// Helpers such as pass print the secret on the first line
+		value, _, _ := strings.Cut(string(out), "\n")
+		if value = strings.TrimSpace(value); value == "" {
+			return "", fmt.Errorf("%s: credentials command printed nothing", ref)
+		}
+		return value, nil
+	}
+
+	file := c.File
+	if file == "" {
+		file = filepath.Join(configDir(), "credentials.json")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("%s: reading credentials: %v", ref, err)
+	}
+	var services map[string]map[string]string
+	if err := json.Unmarshal(data, &services); err != nil {
+		return "", fmt.Errorf("%s: parsing %s: want an object of services, each an object of fields", ref, file)
+	}
+	value, ok := services[service][field]
+	if !ok {
+		return "", fmt.Errorf("%s: no such credential in %s", ref, file)
+	}
+	if value = secretValue(value); value == "" {
+		return "", fmt.Errorf("%s: credential is empty", ref)
+	}
+	return value, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// cursorMode is set by --cursor. It applies to the frames captured for
+// image matching and OCR, where a cursor sprite over a button corrupts the
+// match: show leaves the cursor alone, hide hides it during the capture,
+// park moves it to the bottom right corner and back. hide falls back to
+// park on backends that cannot hide the cursor.
+var cursorMode = "show"
+
+var cursorModes = []string{"show", "hide", "park"}
+
+// parkPosition is past every screen edge; the display clamps it to the
+// bottom right corner
+const parkPosition = 1<<15 - 1
+
+// cursorHider is implemented by backends that can hide the cursor
+type cursorHider interface {
+	hideCursor() (show func(), err error)
+}
+
+// cursorLocator is implemented by backends that can report where the
+// cursor is, so it can be put back after parking
+type cursorLocator interface {
+	cursorPosition() (x, y int, err error)
+}
+
+// captureClean captures a frame for analysis under --cursor
+func captureClean() (image.Image, error) {
+	b := currentBackend()
+	switch cursorMode {
+	case "hide":
+		if h, ok := baseBackend().(cursorHider); ok {
+			if show, err := h.hideCursor(); err == nil {
+				defer show()
+				return b.Capture()
+			}
+		}
+		return captureParked(b)
+	case "park":
+		return captureParked(b)
+	}
+	return b.Capture()
+}
+
+func captureParked(b Backend) (image.Image, error) {
+	if l, ok := b.(cursorLocator); ok {
+		if x, y, err := l.cursorPosition(); err == nil {
+			defer b.MoveMouse(x, y)
+		}
+	}
+	if err := b.MoveMouse(parkPosition, parkPosition); err != nil {
+		return nil, fmt.Errorf("parking cursor: %v", err)
+	}
+	return b.Capture()
+}
+
+func (xdotoolBackend) cursorPosition() (int, int, error) {
+	out, err := exec.Command("xdotool", "getmouselocation", "--shell").Output()
+	if err != nil {
+		return 0, 0, err
+	}
+	var x, y int
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "X":
+			x, _ = strconv.Atoi(value)
+		case "Y":
+			y, _ = strconv.Atoi(value)
+		}
+	}
+	return x, y, nil
+}
+
+// hideCursor uses the XFixes extension. The cursor stays hidden only while
+// the connection that hid it is open.
+func (xdotoolBackend) hideCursor() (func(), error) {
+	x, err := openX("")
+	if err != nil {
+		return nil, err
+	}
+	major, present, err := x.queryExtension("XFIXES")
+	if err == nil && !present {
+		err = fmt.Errorf("X server has no XFIXES extension")
+	}
+	if err == nil {
+		// XFixesQueryVersion must come first; HideCursor needs version 4
+		_, err = x.roundTrip(x.req(major, 0, cat(u32(4), u32(0))))
+	}
+	if err == nil {
+		var seq uint16
+		if seq, err = x.request(x.req(major, 29, u32(x.screen.Root))); err == nil {
+			err = x.sync(seq)
+		}
+	}
+	if err != nil {
+		x.Close()
+		return nil, err
+	}
+	return func() {
+		x.request(x.req(major, 30, u32(x.screen.Root))) // ShowCursor
+		x.flush()
+		x.Close()
+	}, nil
+}
+
+func (m *mockBackend) cursorPosition() (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cursor.X, m.cursor.Y, nil
+}
+
+func (m *mockBackend) hideCursor() (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cursorHidden = true
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.cursorHidden = false
+	}, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// daemon keeps one executor process running on a Unix socket, so a caller
+// that runs many short scripts does not pay for starting the binary and
+// connecting the backend each time. The screenshot directory and counter
+// carry over from job to job, and so do the backend's connections and
+// caches. Jobs run one at a time, in the order they arrive.
+//
+// A connection sends one JSON request per line and gets one JSON line back
+// for each:
+//
+//	{"script": "click 10 20\nscreenshot", "format": "text"}
+//	{"status": "success", "commands_executed": 2, ...}
+//
+// format is text (the default), json or yaml. A request that cannot be run
+// is answered with {"error": "..."}. exec --socket PATH is the client.
+// --when-idle holds jobs back while the machine is in use.
+
+// daemonRequest is one job
+type daemonRequest struct {
+	Script string `json:"script"`
+	Format string `json:"format,omitempty"`
+}
+
+// daemonError answers a request that was not run
+type daemonError struct {
+	Error string `json:"error"`
+}
+
+// serviceFlags adds the run flags shared by the long-running modes: serve,
+// daemon, dbus, mcp, watch and repl
+func serviceFlags(fs *flag.FlagSet) {
+	fs.StringVar(&screenshotsDir, "screenshots-dir", screenshotsDir, "directory for step screenshots")
+	fs.StringVar(&configPath, "config", configPath, "path to the executor config file")
+	fs.BoolVar(&pushEnabled, "push", pushEnabled, "upload screenshots over push.threshold_bytes to push.endpoint")
+	fs.StringVar(&displayFlag, "display", displayFlag, "X display to drive, e.g. :99 or host:0, instead of $DISPLAY")
+	fs.StringVar(&headlessSize, "headless", headlessSize, "run each job on a fresh X server of this size, e.g. 1920x1080, stopped when it ends")
+	fs.StringVar(&headlessServer, "headless-server", headlessServer, "X server for --headless: xvfb, or xephyr to watch in a window")
+	fs.StringVar(&backendName, "backend", backendName, "input and capture backend: "+strings.Join(backendNames(), ", "))
+	fs.BoolVar(&suppressNotifications, "suppress-notifications", suppressNotifications, "turn on notification do-not-disturb during each run")
+	fs.BoolVar(&audioEnabled, "audio", audioEnabled, "record the default output's monitor with parec and flag steps during which sound played")
+	fs.StringVar(&mouseProfile, "mouse-profile", mouseProfile, "how the pointer moves: direct, warping, or human, along curved eased paths")
+	fs.StringVar(&typingProfile, "typing-profile", typingProfile, "how type steps type: fixed, with the backend's delay, or human, at the config's typing pace")
+	fs.StringVar(&cursorMode, "cursor", cursorMode, "cursor during captures for matching and OCR: show, hide or park")
+	fs.StringVar(&chaosSpec, "chaos", chaosSpec, `inject faults, e.g. "fail=click:0.1,delay=type:500ms"`)
+	fs.Int64Var(&chaosSeed, "chaos-seed", chaosSeed, "random seed for --chaos (default: time based, reported in events)")
+}
+
+// checkServiceSetup validates the flags of serviceFlags and the config,
+// exiting on an error, and returns the config
+func checkServiceSetup() Config {
+	cfg, err := currentConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if !contains(cursorModes, cursorMode) {
+		fmt.Fprintf(os.Stderr, "Unknown --cursor mode: %s\n", cursorMode)
+		os.Exit(2)
+	}
+	if err := checkMouseProfile(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := checkTypingProfile(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if _, err := parseChaos(chaosSpec); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if displayFlag != "" {
+		if err := setDisplay(displayFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --display: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if err := checkHeadless(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := checkNotifyConfig(cfg.Notify); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := checkCameraConfig(cfg.Camera); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := checkLockKeysConfig(cfg.LockKeys); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := checkModifiersConfig(cfg.Modifiers); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := checkScreenLockConfig(cfg.ScreenLock); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := checkRecoveryConfig(cfg.Recovery); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := checkTypingConfig(cfg.Typing); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	return cfg
+}
+
+// defaultDaemonSocket is where daemon listens and exec connects
+func defaultDaemonSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "agentos", "executor.sock")
+}
+
+func runDaemon(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	socket := fs.String("socket", defaultDaemonSocket(), "Unix socket to listen on")
+	whenIdle := fs.Duration("when-idle", 0, "start jobs only once the user has been idle this long, pausing them when the user returns")
+	serviceFlags(fs)
+	fs.Parse(args)
+
+	checkServiceSetup()
+	if err := initBackend(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	os.MkdirAll(screenshotsDir, 0755)
+	go enforceRetentionHourly(screenshotsDir)
+	var gate *idleGate
+	if *whenIdle > 0 {
+		gate = newIdleGate(*whenIdle)
+		if gate.activity != nil {
+			sharedActivity, yieldQuiet = gate.activity, *whenIdle
+		}
+	}
+
+	ln, err := listenUnix(*socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	var jobs sync.Mutex
+	// A signal stops new jobs; one that is running is rolled back by
+	// runScript, which sees the signal too, and answered before exit
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		signal.Stop(stop) // a second signal exits at once
+		ln.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", *socket)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			break
+		}
+		go serveDaemonConn(conn, &jobs, gate)
+	}
+	jobs.Lock()
+	os.Remove(*socket)
+}
+
+// listenUnix listens on path, in a directory and as a socket that are
+// this user's only. A socket left by a daemon that has exited is
+// replaced; a live one is an error.
+func listenUnix(path string) (net.Listener, error) {
+	if err := privateDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("something is already listening on %s", path)
+		}
+		os.Remove(path)
+	}
+	return listenPrivate(path)
+}
+
+// serveDaemonConn answers a connection's requests in order until it
+// closes. With a gate each job first waits for the user to be idle.
+func serveDaemonConn(conn net.Conn, jobs *sync.Mutex, gate *idleGate) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		var req daemonRequest
+		if jerr := json.Unmarshal(line, &req); jerr != nil {
+			enc.Encode(daemonError{fmt.Sprintf("invalid request: %v", jerr)})
+		} else if script, serr := scriptLines(req.Format, []byte(req.Script)); serr != nil {
+			enc.Encode(daemonError{serr.Error()})
+		} else {
+			jobs.Lock()
+			if gate != nil {
+				gate.wait()
+			}
+			preloadScript(script)
+			result := runCommands(bufio.NewScanner(bytes.NewReader(script)))
+			savePortableResult(result)
+			// Answered under the lock, so a daemon stopping waits for it
+			err := enc.Encode(result)
+			jobs.Unlock()
+			if err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runExec is the daemon's client: it sends the script from the file
+// argument or stdin and prints the result like a run of the binary
+func runExec(args []string) {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	socket := fs.String("socket", defaultDaemonSocket(), "Unix socket of the daemon")
+	format := fs.String("format", "text", "script format: text, json or yaml")
+	fs.Parse(args)
+	if !contains(scriptFormats, *format) {
+		fmt.Fprintf(os.Stderr, "Unknown --format: %s\n", *format)
+		os.Exit(2)
+	}
+
+	var data []byte
+	var err error
+	if fs.NArg() > 0 {
+		data, err = os.ReadFile(fs.Arg(0))
+	} else {
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading script: %v\n", err)
+		os.Exit(1)
+	}
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: connecting to the daemon: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(daemonRequest{Script: string(data), Format: *format}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var resp json.RawMessage
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: reading the result: %v\n", err)
+		os.Exit(1)
+	}
+	var failed daemonError
+	if json.Unmarshal(resp, &failed) == nil && failed.Error != "" {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", failed.Error)
+		os.Exit(2)
+	}
+	var out bytes.Buffer
+	json.Indent(&out, resp, "", "  ")
+	fmt.Println(out.String())
+}
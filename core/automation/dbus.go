@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// dbus serves the executor on the session bus (or --bus system, or an
+// address) as org.agentos.Automation, so desktop components and session
+// managers can drive it without a socket of their own:
+//
+//	gdbus call --session --dest org.agentos.Automation \
+//	    --object-path /org/agentos/Automation \
+//	    --method org.agentos.Automation.ExecuteScript "click 10 20" text
+//
+// ExecuteScript returns the run's status and its ExecutionResult as JSON.
+// Scripts run one at a time; a call made while one runs waits for it, so
+// callers should give it a long timeout. Each step of a run is broadcast
+// as a StepCompleted signal as it finishes.
+
+const (
+	dbusServiceName = "org.agentos.Automation"
+	dbusObjectPath  = "/org/agentos/Automation"
+)
+
+// dbusIntrospection describes the object for Introspect
+const dbusIntrospection = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+<node>
+  <interface name="org.agentos.Automation">
+    <method name="ExecuteScript">
+      <arg name="script" type="s" direction="in"/>
+      <arg name="format" type="s" direction="in"/>
+      <arg name="status" type="s" direction="out"/>
+      <arg name="result_json" type="s" direction="out"/>
+    </method>
+    <method name="TakeScreenshot">
+      <arg name="file" type="s" direction="out"/>
+    </method>
+    <signal name="StepCompleted">
+      <arg name="step" type="i"/>
+      <arg name="action" type="s"/>
+      <arg name="status" type="s"/>
+      <arg name="step_json" type="s"/>
+    </signal>
+  </interface>
+  <interface name="org.freedesktop.DBus.Introspectable">
+    <method name="Introspect">
+      <arg name="xml_data" type="s" direction="out"/>
+    </method>
+  </interface>
+  <interface name="org.freedesktop.DBus.Peer">
+    <method name="Ping"/>
+  </interface>
+</node>
+`
+
+// dbusService answers calls to the object
+type dbusService struct {
+	conn *dbusConn
+	jobs sync.Mutex
+}
+
+func runDBus(args []string) {
+	fs := flag.NewFlagSet("dbus", flag.ExitOnError)
+	bus := fs.String("bus", "session", "bus to own "+dbusServiceName+" on: session, system or a D-Bus address")
+	serviceFlags(fs)
+	fs.Parse(args)
+
+	checkServiceSetup()
+	if err := initBackend(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	os.MkdirAll(screenshotsDir, 0755)
+
+	address, err := dbusBusAddress(*bus)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	conn, err := dialDBus(address)
+	if err == nil {
+		err = conn.requestName(dbusServiceName)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: D-Bus: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Serving %s on the %s bus\n", dbusServiceName, *bus)
+	svc := &dbusService{conn: conn}
+	if err := svc.serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: D-Bus connection lost: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// serve dispatches calls until the bus connection is lost
+func (s *dbusService) serve() error {
+	for {
+		m, err := readDBusMessage(s.conn.reader)
+		if err != nil {
+			return err
+		}
+		if m.Type == dbusMethodCall {
+			go s.handle(m)
+		}
+	}
+}
+
+func (s *dbusService) handle(call *dbusMessage) {
+	if call.Path != dbusObjectPath {
+		s.conn.replyError(call, "org.freedesktop.DBus.Error.UnknownObject", "no object at "+call.Path)
+		return
+	}
+	switch {
+	case call.Member == "Ping" && (call.Interface == "" || call.Interface == "org.freedesktop.DBus.Peer"):
+		s.conn.reply(call, "")
+	case call.Member == "Introspect" && (call.Interface == "" || call.Interface == "org.freedesktop.DBus.Introspectable"):
+		s.conn.reply(call, "s", dbusIntrospection)
+	case call.Member == "ExecuteScript" && (call.Interface == "" || call.Interface == dbusServiceName):
+		if call.Signature != "ss" || len(call.Body) != 2 {
+			s.conn.replyError(call, "org.freedesktop.DBus.Error.InvalidArgs", "ExecuteScript takes (ss), got ("+call.Signature+")")
+			return
+		}
+		s.executeScript(call, call.Body[0].(string), call.Body[1].(string))
+	case call.Member == "TakeScreenshot" && (call.Interface == "" || call.Interface == dbusServiceName):
+		file := takeScreenshot(0, "dbus")
+		if file == "" {
+			s.conn.replyError(call, dbusServiceName+".Error.Screenshot", "no screenshot could be taken")
+			return
+		}
+		s.conn.reply(call, "s", file)
+	default:
+		s.conn.replyError(call, "org.freedesktop.DBus.Error.UnknownMethod", "unknown method "+call.Interface+"."+call.Member)
+	}
+}
+
+// executeScript runs one script, signalling each step as it finishes
+func (s *dbusService) executeScript(call *dbusMessage, text, format string) {
+	script, err := scriptLines(format, []byte(text))
+	if err != nil {
+		s.conn.replyError(call, dbusServiceName+".Error.InvalidScript", err.Error())
+		return
+	}
+	s.jobs.Lock()
+	defer s.jobs.Unlock()
+	preloadScript(script)
+	result := runScript(bufio.NewScanner(bytes.NewReader(script)), func(step *StepResult, _ time.Duration) {
+		data, _ := json.Marshal(step)
+		s.conn.emit(dbusObjectPath, dbusServiceName, "StepCompleted", "isss",
+			int32(step.Step), step.Action, step.Status, string(data))
+	})
+	savePortableResult(result)
+	data, _ := json.Marshal(result)
+	s.conn.reply(call, "ss", result.Status, string(data))
+}
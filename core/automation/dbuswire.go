@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal D-Bus client, enough to own a name on a bus, answer method calls
+// and emit signals: unix socket transports, EXTERNAL authentication and
+// message bodies of basic types. File descriptors are not passed.
+
+// Message types
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+	dbusSignal       = 4
+)
+
+// dbusNoReplyExpected is the flag of calls that want no answer
+const dbusNoReplyExpected = 0x1
+
+// Header fields
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSender      = 7
+	dbusFieldSignature   = 8
+)
+
+const dbusMaxMessage = 128 << 20
+
+// dbusMessage is one message. Body holds the arguments, strings for s, o
+// and g, uint32, int32 and bool for u, i and b.
+type dbusMessage struct {
+	Type        byte
+	Flags       byte
+	Serial      uint32
+	Path        string
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+	Signature   string
+	Body        []interface{}
+}
+
+// dbusEncoder writes little-endian D-Bus data, aligned from the start of
+// the message
+type dbusEncoder []byte
+
+func (e *dbusEncoder) align(n int) {
+	for len(*e)%n != 0 {
+		*e = append(*e, 0)
+	}
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	*e = binary.LittleEndian.AppendUint32(*e, v)
+}
+
+func (e *dbusEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	*e = append(append(*e, s...), 0)
+}
+
+func (e *dbusEncoder) signature(s string) {
+	*e = append(append(append(*e, byte(len(s))), s...), 0)
+}
+
+// value writes one argument of a basic type
+func (e *dbusEncoder) value(kind byte, v interface{}) error {
+	switch kind {
+	case 'y':
+		*e = append(*e, v.(byte))
+	case 'b':
+		b := uint32(0)
+		if v.(bool) {
+			b = 1
+		}
+		e.uint32(b)
+	case 'u':
+		e.uint32(v.(uint32))
+	case 'i':
+		e.uint32(uint32(v.(int32)))
+	case 's', 'o':
+		e.string(v.(string))
+	case 'g':
+		e.signature(v.(string))
+	default:
+		return fmt.Errorf("unsupported D-Bus type %c", kind)
+	}
+	return nil
+}
+
+// encode serializes m. Its Signature must list Body's basic types.
+func (m *dbusMessage) encode() ([]byte, error) {
+	if len(m.Signature) != len(m.Body) {
+		return nil, fmt.Errorf("signature %q does not fit %d arguments", m.Signature, len(m.Body))
+	}
+	var body dbusEncoder
+	for i, v := range m.Body {
+		if err := body.value(m.Signature[i], v); err != nil {
+			return nil, err
+		}
+	}
+
+	e := dbusEncoder{'l', m.Type, m.Flags, 1}
+	e.uint32(uint32(len(body)))
+	e.uint32(m.Serial)
+	e.uint32(0) // header fields length, filled in below
+	start := len(e)
+	field := func(code byte, kind byte, v interface{}) {
+		e.align(8)
+		e = append(e, code)
+		e.signature(string(kind))
+		e.value(kind, v)
+	}
+	fields := []struct {
+		code byte
+		kind byte
+		v    string
+	}{
+		{dbusFieldPath, 'o', m.Path}, {dbusFieldInterface, 's', m.Interface},
+		{dbusFieldMember, 's', m.Member}, {dbusFieldErrorName, 's', m.ErrorName},
+		{dbusFieldDestination, 's', m.Destination}, {dbusFieldSender, 's', m.Sender},
+		{dbusFieldSignature, 'g', m.Signature},
+	}
+	for _, f := range fields {
+		if f.v != "" {
+			field(f.code, f.kind, f.v)
+		}
+	}
+	if m.ReplySerial != 0 {
+		field(dbusFieldReplySerial, 'u', m.ReplySerial)
+	}
+	binary.LittleEndian.PutUint32(e[12:], uint32(len(e)-start))
+	e.align(8)
+	return append(e, body...), nil
+}
+
+// dbusDecoder reads D-Bus data in the message's byte order
+type dbusDecoder struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (d *dbusDecoder) align(n int) error {
+	for d.pos%n != 0 {
+		d.pos++
+	}
+	if d.pos > len(d.data) {
+		return fmt.Errorf("truncated D-Bus message")
+	}
+	return nil
+}
+
+func (d *dbusDecoder) uint32() (uint32, error) {
+	if err := d.align(4); err != nil {
+		return 0, err
+	}
+	if d.pos+4 > len(d.data) {
+		return 0, fmt.Errorf("truncated D-Bus message")
+	}
+	v := d.order.Uint32(d.data[d.pos:])
+	d.pos += 4
+	return v, nil
+}
+
+func (d *dbusDecoder) text(size int) (string, error) {
+	if size < 0 || d.pos+size+1 > len(d.data) {
+		return "", fmt.Errorf("truncated D-Bus message")
+	}
+	s := string(d.data[d.pos : d.pos+size])
+	d.pos += size + 1
+	return s, nil
+}
+
+func (d *dbusDecoder) value(kind byte) (interface{}, error) {
+	switch kind {
+	case 'y', 'g':
+		if d.pos >= len(d.data) {
+			return nil, fmt.Errorf("truncated D-Bus message")
+		}
+		b := d.data[d.pos]
+		d.pos++
+		if kind == 'y' {
+			return b, nil
+		}
+		return d.text(int(b))
+	case 'b':
+		v, err := d.uint32()
+		return v != 0, err
+	case 'u':
+		return d.uint32()
+	case 'i':
+		v, err := d.uint32()
+		return int32(v), err
+	case 's', 'o':
+		size, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		return d.text(int(size))
+	}
+	return nil, fmt.Errorf("unsupported D-Bus type %c", kind)
+}
+
+// readDBusMessage reads the next message. A body of types other than the
+// basic ones is left undecoded.
+func readDBusMessage(r io.Reader) (*dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	switch fixed[0] {
+	case 'l':
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("bad D-Bus byte order %q", fixed[0])
+	}
+	bodyLen, fieldsLen := order.Uint32(fixed[4:]), order.Uint32(fixed[12:])
+	headerLen := (16 + int(fieldsLen) + 7) &^ 7
+	if uint64(headerLen)+uint64(bodyLen) > dbusMaxMessage {
+		return nil, fmt.Errorf("D-Bus message of %d bytes is too large", uint64(headerLen)+uint64(bodyLen))
+	}
+	data := make([]byte, headerLen+int(bodyLen))
+	copy(data, fixed)
+	if _, err := io.ReadFull(r, data[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &dbusMessage{Type: fixed[1], Flags: fixed[2], Serial: order.Uint32(fixed[8:])}
+	d := &dbusDecoder{data: data[:16+fieldsLen], pos: 16, order: order}
+	for d.pos < len(d.data) {
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		if d.pos >= len(d.data) {
+			break
+		}
+		code := d.data[d.pos]
+		d.pos++
+		sig, err := d.value('g')
+		if err != nil {
+			return nil, err
+		}
+		if len(sig.(string)) != 1 {
+			return nil, fmt.Errorf("bad D-Bus header field %d", code)
+		}
+		v, err := d.value(sig.(string)[0])
+		if err != nil {
+			return nil, err
+		}
+		s, _ := v.(string)
+		switch code {
+		case dbusFieldPath:
+			m.Path = s
+		case dbusFieldInterface:
+			m.Interface = s
+		case dbusFieldMember:
+			m.Member = s
+		case dbusFieldErrorName:
+			m.ErrorName = s
+		case dbusFieldReplySerial:
+			m.ReplySerial, _ = v.(uint32)
+		case dbusFieldDestination:
+			m.Destination = s
+		case dbusFieldSender:
+			m.Sender = s
+		case dbusFieldSignature:
+			m.Signature = s
+		}
+	}
+
+	// Alignment in the body counts from the start of the message
+	body := &dbusDecoder{data: data, pos: headerLen, order: order}
+	for i := 0; i < len(m.Signature); i++ {
+		v, err := body.value(m.Signature[i])
+		if err != nil {
+			m.Body = nil
+			break
+		}
+		m.Body = append(m.Body, v)
+	}
+	return m, nil
+}
+
+// dbusConn is a connection to a bus that has said Hello
+type dbusConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	name   string // the unique name the bus gave us
+
+	mu     sync.Mutex // guards serial and writes
+	serial uint32
+}
+
+// dbusBusAddress resolves "session", "system" or a D-Bus address
+func dbusBusAddress(bus string) (string, error) {
+	switch bus {
+	case "session":
+		addr := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+		if addr == "" {
+			if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+				return "unix:path=" + dir + "/bus", nil
+			}
+			return "", fmt.Errorf("no session bus: DBUS_SESSION_BUS_ADDRESS is not set")
+		}
+		return addr, nil
+	case "system":
+		if addr := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); addr != "" {
+			return addr, nil
+		}
+		return "unix:path=/var/run/dbus/system_bus_socket", nil
+	}
+	return bus, nil
+}
+
+// dialDBus connects to the first unix transport of address that answers,
+// authenticates and says Hello
+func dialDBus(address string) (*dbusConn, error) {
+	var lastErr error
+	for _, transport := range strings.Split(address, ";") {
+		kind, params, _ := strings.Cut(transport, ":")
+		if kind != "unix" {
+			lastErr = fmt.Errorf("unsupported D-Bus transport %q", kind)
+			continue
+		}
+		var path string
+		for _, kv := range strings.Split(params, ",") {
+			k, v, _ := strings.Cut(kv, "=")
+			switch k {
+			case "path":
+				path = dbusUnescape(v)
+			case "abstract":
+				path = "@" + dbusUnescape(v)
+			}
+		}
+		if path == "" {
+			lastErr = fmt.Errorf("unsupported D-Bus address %q", transport)
+			continue
+		}
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+		if err := c.auth(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := c.hello(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("empty D-Bus address")
+	}
+	return nil, lastErr
+}
+
+// dbusUnescape undoes the %XX escapes of address values
+func dbusUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// auth runs the EXTERNAL mechanism: the bus checks our uid on the socket
+func (c *dbusConn) auth() error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(c.conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		return err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("D-Bus authentication: %v", err)
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("D-Bus authentication rejected: %s", strings.TrimSpace(line))
+	}
+	_, err = io.WriteString(c.conn, "BEGIN\r\n")
+	return err
+}
+
+// send writes m with the next serial, which it returns
+func (c *dbusConn) send(m *dbusMessage) (uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serial++
+	m.Serial = c.serial
+	data, err := m.encode()
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.conn.Write(data)
+	return m.Serial, err
+}
+
+// call sends a method call to the bus and waits for its reply. It is only
+// used before the connection's messages are dispatched, so other messages
+// are dropped meanwhile.
+func (c *dbusConn) call(member, signature string, args ...interface{}) (*dbusMessage, error) {
+	serial, err := c.send(&dbusMessage{
+		Type: dbusMethodCall, Destination: "org.freedesktop.DBus", Path: "/org/freedesktop/DBus",
+		Interface: "org.freedesktop.DBus", Member: member, Signature: signature, Body: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for {
+		m, err := readDBusMessage(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if m.ReplySerial != serial {
+			continue
+		}
+		if m.Type == dbusError {
+			msg := m.ErrorName
+			if len(m.Body) > 0 {
+				msg += ": " + fmt.Sprint(m.Body[0])
+			}
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return m, nil
+	}
+}
+
+func (c *dbusConn) hello() error {
+	reply, err := c.call("Hello", "")
+	if err != nil {
+		return fmt.Errorf("D-Bus Hello: %v", err)
+	}
+	if len(reply.Body) == 1 {
+		c.name, _ = reply.Body[0].(string)
+	}
+	return nil
+}
+
+// requestName takes a well-known name, failing if another connection has
+// it
+func (c *dbusConn) requestName(name string) error {
+	const doNotQueue, primaryOwner = 4, 1
+	reply, err := c.call("RequestName", "su", name, uint32(doNotQueue))
+	if err != nil {
+		return fmt.Errorf("requesting %s: %v", name, err)
+	}
+	if len(reply.Body) != 1 || reply.Body[0] != uint32(primaryOwner) {
+		return fmt.Errorf("%s is already owned on the bus", name)
+	}
+	return nil
+}
+
+// reply answers call with args of signature
+func (c *dbusConn) reply(call *dbusMessage, signature string, args ...interface{}) error {
+	if call.Flags&dbusNoReplyExpected != 0 {
+		return nil
+	}
+	_, err := c.send(&dbusMessage{
+		Type: dbusMethodReturn, ReplySerial: call.Serial, Destination: call.Sender,
+		Signature: signature, Body: args,
+	})
+	return err
+}
+
+// replyError answers call with a D-Bus error
+func (c *dbusConn) replyError(call *dbusMessage, name, msg string) error {
+	if call.Flags&dbusNoReplyExpected != 0 {
+		return nil
+	}
+	_, err := c.send(&dbusMessage{
+		Type: dbusError, ReplySerial: call.Serial, Destination: call.Sender,
+		ErrorName: name, Signature: "s", Body: []interface{}{msg},
+	})
+	return err
+}
+
+// emit broadcasts a signal
+func (c *dbusConn) emit(path, iface, member, signature string, args ...interface{}) error {
+	_, err := c.send(&dbusMessage{
+		Type: dbusSignal, Path: path, Interface: iface, Member: member,
+		Signature: signature, Body: args,
+	})
+	return err
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Desktop settings that setup sections reset: the clipboard and
+// notification do-not-disturb
+
+// clipboardClearer is implemented by backends with their own clipboard
+type clipboardClearer interface {
+	clearClipboard() error
+}
+
+// clipboardAccess is implemented by backends whose clipboard can also be
+// read and set
+type clipboardAccess interface {
+	readClipboard() (string, error)
+	writeClipboard(text string) error
+}
+
+// clipboardTools read and set the clipboard, the first one installed
+// being used
+var clipboardTools = []struct {
+	name        string
+	read, write []string
+}{
+	{"xsel", []string{"xsel", "--clipboard", "--output"}, []string{"xsel", "--clipboard", "--input"}},
+	{"xclip", []string{"xclip", "-selection", "clipboard", "-o"}, []string{"xclip", "-selection", "clipboard", "-i"}},
+	{"wl-paste", []string{"wl-paste", "--no-newline"}, []string{"wl-copy"}},
+}
+
+// readClipboard returns the clipboard's text
+func readClipboard() (string, error) {
+	if c, ok := baseBackend().(clipboardAccess); ok {
+		return c.readClipboard()
+	}
+	for _, tool := range clipboardTools {
+		if _, err := exec.LookPath(tool.name); err != nil {
+			continue
+		}
+		out, err := exec.Command(tool.read[0], tool.read[1:]...).Output()
+		if err != nil {
+			// An empty clipboard is an error to xclip and wl-paste
+			return "", nil
+		}
+		return string(out), nil
+	}
+	return "", fmt.Errorf("no clipboard tool found (install xsel, xclip or wl-clipboard)")
+}
+
+// writeClipboard sets the clipboard's text
+func writeClipboard(text string) error {
+	if c, ok := baseBackend().(clipboardAccess); ok {
+		return c.writeClipboard(text)
+	}
+	for _, tool := range clipboardTools {
+		if _, err := exec.LookPath(tool.name); err != nil {
+			continue
+		}
+		cmd := exec.Command(tool.write[0], tool.write[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %v", tool.write[0], commandError(err))
+		}
+		return nil
+	}
+	return fmt.Errorf("no clipboard tool found (install xsel, xclip or wl-clipboard)")
+}
+
+// clearClipboard empties the clipboard and primary selection with
+// whichever clipboard tool is installed
+func clearClipboard() error {
+	if c, ok := baseBackend().(clipboardClearer); ok {
+		return c.clearClipboard()
+	}
+	tools := [][]string{
+		{"xsel", "--clipboard", "--clear"},
+		{"xclip", "-selection", "clipboard", "-i", "/dev/null"},
+		{"wl-copy", "--clear"},
+	}
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			continue
+		}
+		if err := exec.Command(tool[0], tool[1:]...).Run(); err != nil {
+			return fmt.Errorf("%s: %v", tool[0], err)
+		}
+		if tool[0] == "xsel" {
+			exec.Command("xsel", "--primary", "--clear").Run()
+		}
+		return nil
+	}
+	return fmt.Errorf("no clipboard tool found (install xsel, xclip or wl-clipboard)")
+}
+
+// dndControl switches a notification daemon's do-not-disturb mode
+type dndControl struct {
+	name string
+	tool string
+	get  func() (bool, error)
+	set  func(on bool) error
+}
+
+// dndControls are tried in order; the first whose tool is installed and
+// answers is used
+var dndControls = []dndControl{
+	{
+		// GNOME Shell reads the setting over D-Bus from dconf
+		name: "gnome", tool: "gsettings",
+		get: func() (bool, error) {
+			out, err := exec.Command("gsettings", "get", "org.gnome.desktop.notifications", "show-banners").Output()
+			return strings.TrimSpace(string(out)) == "false", err
+		},
+		set: func(on bool) error {
+			return exec.Command("gsettings", "set", "org.gnome.desktop.notifications", "show-banners", fmt.Sprint(!on)).Run()
+		},
+	},
+	{
+		name: "xfce", tool: "xfconf-query",
+		get: func() (bool, error) {
+			out, err := exec.Command("xfconf-query", "-c", "xfce4-notifyd", "-p", "/do-not-disturb").Output()
+			return strings.TrimSpace(string(out)) == "true", err
+		},
+		set: func(on bool) error {
+			return exec.Command("xfconf-query", "-c", "xfce4-notifyd", "-p", "/do-not-disturb", "-n", "-t", "bool", "-s", fmt.Sprint(on)).Run()
+		},
+	},
+	{
+		name: "dunst", tool: "dunstctl",
+		get: func() (bool, error) {
+			out, err := exec.Command("dunstctl", "is-paused").Output()
+			return strings.TrimSpace(string(out)) == "true", err
+		},
+		set: func(on bool) error {
+			return exec.Command("dunstctl", "set-paused", fmt.Sprint(on)).Run()
+		},
+	},
+	{
+		name: "mako", tool: "makoctl",
+		get: func() (bool, error) {
+			out, err := exec.Command("makoctl", "mode").Output()
+			return strings.Contains(string(out), "do-not-disturb"), err
+		},
+		set: func(on bool) error {
+			flag := "-r"
+			if on {
+				flag = "-a"
+			}
+			return exec.Command("makoctl", "mode", flag, "do-not-disturb").Run()
+		},
+	},
+}
+
+// findDND returns the do-not-disturb control for this desktop
+func findDND() (*dndControl, error) {
+	for i := range dndControls {
+		c := &dndControls[i]
+		if _, err := exec.LookPath(c.tool); err != nil {
+			continue
+		}
+		if _, err := c.get(); err == nil {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no do-not-disturb control found (GNOME, Xfce, dunst and mako are supported)")
+}
+
+// suppressNotifications is set by --suppress-notifications
+var suppressNotifications bool
+
+// dndMarkerPath exists while a run holds do-not-disturb on, so the next
+// run can switch it back off if this one was killed
+func dndMarkerPath() string {
+	return filepath.Join(stateDir(), "dnd-held")
+}
+
+// holdNotifications turns do-not-disturb on for the run when
+// --suppress-notifications is set. The returned function restores the
+// previous setting.
+func (r *runner) holdNotifications() (restore func()) {
+	restore = func() {}
+	if !suppressNotifications {
+		return
+	}
+	report := func(kind, msg string) {
+		r.result.Events = append(r.result.Events, Event{Step: r.step, Type: kind, Message: msg})
+	}
+	c, err := findDND()
+	if err != nil {
+		report("notifications_error", err.Error())
+		return
+	}
+	on, err := c.get()
+	if err != nil {
+		report("notifications_error", fmt.Sprintf("%s do-not-disturb: %v", c.name, err))
+		return
+	}
+	// A marker left by a killed run means it was off before that run
+	if _, err := os.Stat(dndMarkerPath()); on && err != nil {
+		report("notifications", c.name+" do-not-disturb was already on")
+		return
+	}
+	os.MkdirAll(filepath.Dir(dndMarkerPath()), 0700)
+	os.WriteFile(dndMarkerPath(), []byte(c.name+"\n"), 0600)
+	if err := c.set(true); err != nil {
+		os.Remove(dndMarkerPath())
+		report("notifications_error", fmt.Sprintf("%s do-not-disturb: %v", c.name, err))
+		return
+	}
+	report("notifications", c.name+" do-not-disturb on for the run")
+	return func() {
+		if err := c.set(false); err != nil {
+			report("notifications_error", fmt.Sprintf("restoring %s do-not-disturb: %v", c.name, err))
+			return
+		}
+		os.Remove(dndMarkerPath())
+	}
+}
+
+// setDoNotDisturb switches do-not-disturb and returns the previous state
+func setDoNotDisturb(on bool) (bool, error) {
+	c, err := findDND()
+	if err != nil {
+		return false, err
+	}
+	was, _ := c.get()
+	if err := c.set(on); err != nil {
+		return was, fmt.Errorf("%s do-not-disturb: %v", c.name, err)
+	}
+	return was, nil
+}
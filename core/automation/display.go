@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --display chooses the X server a run drives, such as an Xvfb on :99 or a
+// remote host:0, instead of the DISPLAY the executor was started with. A
+// script can switch for the rest of its run with the display action:
+//
+//	display :99
+//
+// Every X client the executor starts or connects reads it: xdotool,
+// import, xrandr and xev, the x11 backend's connection, the clipboard
+// tools and launched applications. The run's starting display comes back
+// when it ends.
+
+// displayFlag is set by --display
+var displayFlag string
+
+// setDisplay makes display the one X clients use
+func setDisplay(display string) error {
+	if _, _, err := parseDisplay(display); err != nil {
+		return err
+	}
+	return os.Setenv("DISPLAY", display)
+}
+
+// useDisplay switches a run to display, reconnecting the x11 backend
+func useDisplay(display string) error {
+	if err := setDisplay(display); err != nil {
+		return err
+	}
+	if b, ok := baseBackend().(*x11Backend); ok {
+		b.disconnect()
+	}
+	return nil
+}
+
+// restoreDisplay puts back the display a run started with
+func (r *runner) restoreDisplay() {
+	if os.Getenv("DISPLAY") == r.displayBefore {
+		return
+	}
+	if r.displayBefore != "" {
+		if err := useDisplay(r.displayBefore); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: restoring DISPLAY %s: %v\n", r.displayBefore, err)
+		}
+		return
+	}
+	os.Unsetenv("DISPLAY")
+	if b, ok := baseBackend().(*x11Backend); ok {
+		b.disconnect()
+	}
+}
+
+// parseDisplayAction reads display DISPLAY
+func parseDisplayAction(cmd *Command, parts []string) *Command {
+	if len(parts) != 2 {
+		return nil
+	}
+	display := strings.Trim(parts[1], "\"")
+	if _, _, err := parseDisplay(display); err != nil {
+		return nil
+	}
+	cmd.Params["display"] = display
+	return cmd
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailNotify mails the HTML report with an archive of the run's artifacts,
+// for change processes that want evidence of every automated procedure
+type EmailNotify struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"` // default 587, or 465 with tls "implicit"
+	TLS      string   `json:"tls"`  // starttls (default), implicit or none
+	Username string   `json:"username"`
+	Password string   `json:"password"` // "env:NAME" is resolved
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// On lists the runs mailed: failure (default) and finish for runs
+	// that did not fail
+	On []string `json:"on"`
+	// MaxArchiveBytes leaves the archive out of mails that would exceed
+	// it (default 10MB)
+	MaxArchiveBytes int64 `json:"max_archive_bytes"`
+}
+
+var emailTLSModes = []string{"starttls", "implicit", "none"}
+
+const defaultMaxArchive = 10 << 20
+
+func checkEmailNotify(i int, n EmailNotify) error {
+	if n.Host == "" || n.From == "" || len(n.To) == 0 {
+		return fmt.Errorf("notify.email[%d]: needs host, from and to", i)
+	}
+	if n.TLS != "" && !contains(emailTLSModes, n.TLS) {
+		return fmt.Errorf("notify.email[%d].tls: unknown mode %q (want starttls, implicit or none)", i, n.TLS)
+	}
+	for _, k := range n.On {
+		if k != "finish" && k != "failure" {
+			return fmt.Errorf("notify.email[%d].on: unknown run %q (want finish or failure)", i, k)
+		}
+	}
+	return nil
+}
+
+func (n EmailNotify) send(msg notifyMessage) error {
+	if msg.result == nil {
+		return nil
+	}
+	mail, err := n.compose(msg)
+	if err != nil {
+		return err
+	}
+	mode := n.TLS
+	if mode == "" {
+		mode = "starttls"
+	}
+	port := n.Port
+	if port == 0 {
+		port = 587
+		if mode == "implicit" {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: n.Host}
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: notifyTimeout}
+	if mode == "implicit" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(4 * notifyTimeout))
+	c, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if mode == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %v", err)
+		}
+	}
+	if n.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.Username, secretValue(n.Password), n.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	for _, to := range n.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %v", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(mail); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// compose builds the mail: the HTML report with the failing screenshot
+// inline, and the archive attached
+func (n EmailNotify) compose(msg notifyMessage) ([]byte, error) {
+	result := *msg.result
+	var body bytes.Buffer
+	mixed := multipart.NewWriter(&body)
+
+	headers := []string{
+		"From: " + n.From,
+		"To: " + strings.Join(n.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", strings.SplitN(msg.text, "\n", 2)[0]),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + mixed.Boundary(),
+	}
+
+	var inline []Screenshot
+	html := htmlReport(result, runName(), func(shot Screenshot) string {
+		inline = append(inline, shot)
+		return fmt.Sprintf("cid:step%d", shot.Step)
+	})
+	var related bytes.Buffer
+	rw := multipart.NewWriter(&related)
+	part, _ := rw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	writeBase64(part, []byte(html))
+	for _, shot := range inline {
+		data, err := os.ReadFile(shot.File)
+		if err != nil {
+			continue // the report still names the step
+		}
+		part, _ := rw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/png"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-ID":                {fmt.Sprintf("<step%d>", shot.Step)},
+			"Content-Disposition":       {fmt.Sprintf("inline; filename=%q", filepath.Base(shot.File))},
+		})
+		writeBase64(part, data)
+	}
+	rw.Close()
+	part, _ = mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/related; boundary=" + rw.Boundary()},
+	})
+	part.Write(related.Bytes())
+
+	archive, err := runArchive(result)
+	if err != nil {
+		return nil, fmt.Errorf("archiving artifacts: %v", err)
+	}
+	limit := n.MaxArchiveBytes
+	if limit <= 0 {
+		limit = defaultMaxArchive
+	}
+	name := fmt.Sprintf("run_%s.zip", time.Now().Format("20060102_150405"))
+	if int64(len(archive)) <= limit {
+		part, _ = mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"application/zip"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", name)},
+		})
+		writeBase64(part, archive)
+	} else {
+		part, _ = mixed.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+		fmt.Fprintf(part, "The artifact archive (%d bytes) is over the %d byte limit and was left out.\r\n", len(archive), limit)
+	}
+	mixed.Close()
+	return append([]byte(strings.Join(headers, "\r\n")+"\r\n\r\n"), body.Bytes()...), nil
+}
+
+// runArchive zips the result, screenshots, filmstrip and transcript
+func runArchive(result ExecutionResult) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	data, _ := json.MarshalIndent(result, "", "  ")
+	w, err := zw.Create("result.json")
+	if err != nil {
+		return nil, err
+	}
+	w.Write(data)
+	files := []string{result.Filmstrip, transcriptPath}
+	for _, shot := range result.Screenshots {
+		files = append(files, shot.File)
+	}
+	seen := map[string]bool{}
+	for _, file := range files {
+		if file == "" || seen[file] {
+			continue
+		}
+		seen[file] = true
+		if err := addToZip(zw, file); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func addToZip(zw *zip.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// PNG and WebP are compressed already
+	method := zip.Store
+	if !strings.HasSuffix(file, ".png") && !strings.HasSuffix(file, ".webp") && !strings.HasSuffix(file, ".avif") {
+		method = zip.Deflate
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: filepath.Base(file), Method: method, Modified: info.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writeBase64 writes data base64 encoded in 76 column lines
+func writeBase64(w io.Writer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		io.WriteString(w, enc[:76]+"\r\n")
+		enc = enc[76:]
+	}
+	io.WriteString(w, enc+"\r\n")
+}
@@ -2,13 +2,21 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"image"
+	"io"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -17,6 +25,12 @@ type Command struct {
 	Action   string                 `json:"action"`
 	Params   map[string]interface{} `json:"params,omitempty"`
 	Original string                 `json:"original,omitempty"`
+	// Output is data produced by query actions, reported in the result
+	Output map[string]interface{} `json:"-"`
+	// Annotations come from @name lines before the step
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// sandbox is that of the run the step is in, for launch_sandboxed
+	sandbox *sandbox
 }
 
 // ExecutionResult represents the result of executing commands
@@ -25,40 +39,309 @@ type ExecutionResult struct {
 	CommandsExecuted int        `json:"commands_executed"`
 	Screenshots     []Screenshot `json:"screenshots"`
 	Errors          []string    `json:"errors"`
+	Events          []Event     `json:"events,omitempty"`
+	Outputs         []StepOutput `json:"outputs,omitempty"`
+	Filmstrip       string      `json:"filmstrip,omitempty"`
+	// FilmstripUploaded is where --push sent the filmstrip
+	FilmstripUploaded string      `json:"filmstrip_uploaded,omitempty"`
+	Cache           *CacheStats `json:"cache,omitempty"`
+}
+
+// StepOutput is data returned by a query action such as read_text
+type StepOutput struct {
+	Step   int                    `json:"step"`
+	Name   string                 `json:"name,omitempty"`
+	Action string                 `json:"action"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+// Event is a notable condition observed during a run that is not an error
+type Event struct {
+	Step    int    `json:"step"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
 }
 
 // Screenshot represents a screenshot taken after an action
 type Screenshot struct {
 	Step   int    `json:"step"`
+	Name   string `json:"name,omitempty"`
 	File   string `json:"file"`
 	Action string `json:"action"`
+	// URL is a signed download link, set in serve mode
+	URL string `json:"url,omitempty"`
+	// Uploaded is where --push sent the file
+	Uploaded string `json:"uploaded,omitempty"`
 }
 
 var screenshotsDir = "/tmp/cosmic-screenshots"
 var screenshotCounter = 0
 
 func main() {
+	// --portable comes first so it also applies to subcommands
+	args, err := stripPortableFlag(os.Args[1:])
+	if err == nil {
+		err = setupPortable()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	// exec only talks to a daemon, which holds the portable lock itself
+	if len(args) > 0 && args[0] == "exec" {
+		runExec(args[1:])
+		return
+	}
+	release, err := acquirePortableLock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	defer release()
+
+	// Subcommands
+	if len(args) > 0 {
+		switch args[0] {
+		case "selftest":
+			runSelftest(args[1:])
+			return
+		case "calibrate":
+			runCalibrate(args[1:])
+			return
+		case "artifacts":
+			runArtifacts(args[1:])
+			return
+		case "serve":
+			runServe(args[1:])
+			return
+		case "daemon":
+			runDaemon(args[1:])
+			return
+		case "dbus":
+			runDBus(args[1:])
+			return
+		case "mcp":
+			runMCP(args[1:])
+			return
+		case "watch":
+			runWatch(args[1:])
+			return
+		case "repl":
+			runREPL(args[1:])
+			return
+		case "tools":
+			runTools(args[1:])
+			return
+		case "test-parse":
+			runTestParse(args[1:])
+			return
+		case "k8s-run":
+			runK8s(args[1:])
+			return
+		case "bundle":
+			runBundle(args[1:])
+			return
+		case "uinput-setup":
+			runUinputSetup(args[1:])
+			return
+		case "run":
+			// The default mode, named for use with --repeat
+			args = args[1:]
+		}
+	}
+
+	flag.StringVar(&screenshotsDir, "screenshots-dir", screenshotsDir, "directory for step screenshots")
+	flag.StringVar(&configPath, "config", configPath, "path to the executor config file")
+	flag.StringVar(&tenant, "tenant", tenant, "store this run's artifacts under <screenshots-dir>/<tenant>")
+	flag.BoolVar(&pushEnabled, "push", pushEnabled, "upload screenshots over push.threshold_bytes to push.endpoint")
+	flag.BoolVar(&redactEnabled, "redact", redactEnabled, "redact emails, card numbers and configured regions in screenshots")
+	flag.StringVar(&maskWindows, "mask-windows", maskWindows, `black out all windows in screenshots except these, e.g. "Firefox,class:gedit"`)
+	flag.StringVar(&displayFlag, "display", displayFlag, "X display to drive, e.g. :99 or host:0, instead of $DISPLAY")
+	flag.StringVar(&headlessSize, "headless", headlessSize, "run on a fresh X server of this size, e.g. 1920x1080 or 1280x800x16, stopped when the run ends")
+	flag.StringVar(&headlessServer, "headless-server", headlessServer, "X server for --headless: xvfb, or xephyr to watch in a window")
+	flag.StringVar(&backendName, "backend", backendName, "input and capture backend: "+strings.Join(backendNames(), ", "))
+	flag.StringVar(&targetSpec, "target", targetSpec, "drive applications in a container, docker:CONTAINER, or an Android device, adb:SERIAL")
+	flag.StringVar(&recordDir, "record", recordDir, "record backend calls and frames into this directory")
+	flag.StringVar(&replayDir, "replay", replayDir, "replay a recording instead of using a display, failing if the run diverges from it")
+	flag.StringVar(&chaosSpec, "chaos", chaosSpec, `inject faults, e.g. "fail=click:0.1,delay=type:500ms"`)
+	flag.Int64Var(&chaosSeed, "chaos-seed", chaosSeed, "random seed for --chaos (default: time based, reported in events)")
+	flag.StringVar(&setupFile, "setup", setupFile, "run this script as a setup section before the main script")
+	flag.StringVar(&runEnv, "env", runEnv, `environment for programs steps launch, e.g. "LANG=C DEBUG=1"; @env adds to it per step`)
+	flag.StringVar(&runCwd, "cwd", runCwd, "working directory for programs steps launch; @cwd overrides it per step")
+	flag.StringVar(&setupPolicy, "setup-policy", setupPolicy, "what a failing setup step does: continue or abort")
+	flag.BoolVar(&suppressNotifications, "suppress-notifications", suppressNotifications, "turn on notification do-not-disturb for the run and restore it after")
+	flag.BoolVar(&audioEnabled, "audio", audioEnabled, "record the default output's monitor with parec and flag steps during which sound played")
+	flag.StringVar(&mouseProfile, "mouse-profile", mouseProfile, "how the pointer moves: direct, warping, or human, along curved eased paths")
+	flag.StringVar(&typingProfile, "typing-profile", typingProfile, "how type steps type: fixed, with the backend's delay, or human, at the config's typing pace")
+	flag.StringVar(&cursorMode, "cursor", cursorMode, "cursor during captures for matching and OCR: show, hide or park")
+	flag.StringVar(&screenshotCadence, "screenshot-cadence", screenshotCadence, "when to screenshot after a step: adaptive or every (default from config, else adaptive)")
+	flag.StringVar(&filmstripFormat, "filmstrip", filmstripFormat, "also write the run's screenshots as one animation: webp or avif")
+	flag.StringVar(&controlPath, "control", controlPath, "listen on this Unix socket for take_over and give_back, to hand the run to a person mid-script and back")
+	flag.DurationVar(&yieldQuiet, "yield", yieldQuiet, "pause before each step while someone uses the keyboard or mouse, until they are idle this long or SIGUSR1")
+	flag.DurationVar(&runBudget, "run-budget", runBudget, "report a successful run as degraded if it takes longer than this; steps take @budget annotations")
+	flag.StringVar(&maxFrameBuffer, "max-frame-buffer", maxFrameBuffer, "most memory kept in pooled capture buffers for reuse, e.g. 512MB; 0 disables pooling")
+	flag.StringVar(&transcriptPath, "transcript", transcriptPath, "write a Markdown transcript of steps, new screen text and errors to this file")
+	flag.StringVar(&reportFormat, "report", reportFormat, "result format on stdout: json, md for a Markdown summary to post to chat, or html")
+	flag.IntVar(&repeatCount, "repeat", repeatCount, "run the script this many times, printing every result")
+	flag.BoolVar(&flakeReport, "flake-report", flakeReport, "with --repeat, print per-step pass rates, timing and screenshot variance instead")
+	flag.StringVar(&scriptFormat, "format", scriptFormat, "script format: text, json for an array of command objects or one object per line, or yaml for a list of named steps")
+	flag.StringVar(&colorNormalize, "color-normalize", colorNormalize, "color normalization for image checks: none, gray or ncc")
+	flag.CommandLine.Parse(args)
+
+	if !contains(colorNormalizeModes, colorNormalize) {
+		fmt.Fprintf(os.Stderr, "Unknown --color-normalize mode: %s\n", colorNormalize)
+		os.Exit(2)
+	}
+
+	if !contains(scriptFormats, scriptFormat) {
+		fmt.Fprintf(os.Stderr, "Unknown --format: %s\n", scriptFormat)
+		os.Exit(2)
+	}
+
+	if !contains(reportFormats, reportFormat) {
+		fmt.Fprintf(os.Stderr, "Unknown --report format: %s\n", reportFormat)
+		os.Exit(2)
+	}
+	if reportFormat != "json" && flakeReport {
+		fmt.Fprintf(os.Stderr, "--report %s does not apply to --flake-report\n", reportFormat)
+		os.Exit(2)
+	}
+
+	if !contains(cursorModes, cursorMode) {
+		fmt.Fprintf(os.Stderr, "Unknown --cursor mode: %s\n", cursorMode)
+		os.Exit(2)
+	}
+	if err := checkMouseProfile(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := checkTypingProfile(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if filmstripFormat != "" && !contains(filmstripFormats, filmstripFormat) {
+		fmt.Fprintf(os.Stderr, "Unknown --filmstrip format: %s\n", filmstripFormat)
+		os.Exit(2)
+	}
+	if !contains(setupPolicies, setupPolicy) {
+		fmt.Fprintf(os.Stderr, "Unknown --setup-policy: %s\n", setupPolicy)
+		os.Exit(2)
+	}
+
+	cfg, err := currentConfig()
+	if err == nil {
+		err = checkScreenshotConfig(cfg.Screenshots)
+	}
+	if err == nil {
+		err = checkNotifyConfig(cfg.Notify)
+	}
+	if err == nil {
+		err = checkCameraConfig(cfg.Camera)
+	}
+	if err == nil {
+		err = checkLockKeysConfig(cfg.LockKeys)
+	}
+	if err == nil {
+		err = checkModifiersConfig(cfg.Modifiers)
+	}
+	if err == nil {
+		err = checkScreenLockConfig(cfg.ScreenLock)
+	}
+	if err == nil {
+		err = checkRecoveryConfig(cfg.Recovery)
+	}
+	if err == nil {
+		err = checkTypingConfig(cfg.Typing)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	_, err = parseChaos(chaosSpec)
+	if err == nil {
+		err = checkRunEnv()
+	}
+	if err == nil && displayFlag != "" {
+		err = setDisplay(displayFlag)
+	}
+	if err == nil {
+		err = checkHeadless()
+	}
+	if err == nil {
+		err = setFrameBufferLimit(maxFrameBuffer)
+	}
+	if err == nil {
+		err = initBackend()
+	}
+	if err == nil {
+		err = initTarget()
+	}
+	if err == nil {
+		err = wrapBackend()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if err := enforceRetention(screenshotsDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: retention enforcement failed: %v\n", err)
+	}
+	if tenant != "" {
+		if !validTenant(tenant) {
+			fmt.Fprintf(os.Stderr, "Invalid --tenant: %s\n", tenant)
+			os.Exit(2)
+		}
+		screenshotsDir = filepath.Join(screenshotsDir, tenant)
+	}
+
 	// Create screenshots directory
 	os.MkdirAll(screenshotsDir, 0755)
 
-	// Check for command line arguments
-	if len(os.Args) > 1 {
-		// Read from file
-		if os.Args[1] == "--screenshots-dir" && len(os.Args) > 2 {
-			screenshotsDir = os.Args[2]
-			os.MkdirAll(screenshotsDir, 0755)
-			if len(os.Args) > 3 {
-				executeFromFile(os.Args[3])
-			} else {
-				executeFromStdin()
+	if repeatCount < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid --repeat: %d\n", repeatCount)
+		os.Exit(2)
+	}
+	if reportFormat == "html" && repeatCount > 1 {
+		fmt.Fprintf(os.Stderr, "--report html shows one run and does not apply to --repeat\n")
+		os.Exit(2)
+	}
+	if flag.NArg() > 0 {
+		notifyScript = flag.Arg(0)
+	}
+	if info, err := os.Stat(flag.Arg(0)); flag.NArg() > 0 && err == nil && info.IsDir() {
+		executeBundle(flag.Arg(0))
+	} else if scriptFormat != "text" {
+		executeStructured()
+	} else if repeatCount > 1 || flakeReport {
+		input := os.Stdin
+		if flag.NArg() > 0 {
+			if input, err = os.Open(flag.Arg(0)); err != nil {
+				fmt.Fprintf(os.Stderr, "Error opening file: %v\n", err)
+				os.Exit(1)
 			}
-		} else {
-			executeFromFile(os.Args[1])
 		}
+		executeRepeated(input)
+	} else if flag.NArg() > 0 {
+		// Read from file
+		executeFromFile(flag.Arg(0))
 	} else {
 		// Read from stdin
 		executeFromStdin()
 	}
+	if err := closeBackend(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
 
 func executeFromFile(filename string) {
@@ -69,8 +352,40 @@ func executeFromFile(filename string) {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	executeCommands(scanner)
+	data, err := io.ReadAll(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+		os.Exit(1)
+	}
+	preloadScript(data)
+	executeCommands(bufio.NewScanner(bytes.NewReader(data)))
+}
+
+// executeStructured runs a JSON or YAML script from the file argument or
+// stdin, once it has all been checked
+func executeStructured() {
+	var data []byte
+	var err error
+	if flag.NArg() > 0 {
+		data, err = os.ReadFile(flag.Arg(0))
+	} else {
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading script: %v\n", err)
+		os.Exit(1)
+	}
+	script, err := scriptLines(scriptFormat, data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if repeatCount > 1 || flakeReport {
+		executeRepeated(bytes.NewReader(script))
+		return
+	}
+	preloadScript(script)
+	executeCommands(bufio.NewScanner(bytes.NewReader(script)))
 }
 
 func executeFromStdin() {
@@ -79,50 +394,436 @@ func executeFromStdin() {
 }
 
 func executeCommands(scanner *bufio.Scanner) {
-	result := ExecutionResult{
-		Status:          "success",
-		CommandsExecuted: 0,
-		Screenshots:     []Screenshot{},
-		Errors:          []string{},
+	result := runCommands(scanner)
+
+	switch reportFormat {
+	case "md":
+		fmt.Print(markdownReport(result))
+	case "html":
+		fmt.Print(htmlReport(result, "", shotURL))
+	default:
+		// Output result as JSON
+		jsonOutput, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(jsonOutput))
+	}
+	savePortableResult(result)
+}
+
+// runCommands executes a script and returns its result
+func runCommands(scanner *bufio.Scanner) ExecutionResult {
+	return runScript(scanner, nil)
+}
+
+// runScript executes a script, passing each step of the main body and its
+// rollback to observe if set
+func runScript(scanner *bufio.Scanner, observe func(step *StepResult, took time.Duration)) ExecutionResult {
+	r := newRunner()
+	defer r.close()
+
+	// An interrupt aborts the run between steps so it can be rolled back
+	interrupt, interrupted := make(chan os.Signal, 1), make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	r.background.start("interrupt", func(ctx context.Context) error {
+		select {
+		case sig := <-interrupt:
+			signal.Stop(interrupt) // a second interrupt exits at once
+			interrupted <- sig
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	r.notifyStart()
+	restoreNotifications := r.holdNotifications()
+	r.runSetupFile()
+	for !r.aborted && scanner.Scan() {
+		start := clock.Now()
+		step := r.runLine(scanner.Text())
+		if step != nil && observe != nil {
+			observe(step, since(start))
+		}
+		select {
+		case sig := <-interrupted:
+			r.abort(fmt.Sprintf("interrupted by %v", sig))
+		default:
+		}
+	}
+	for _, step := range r.finish() {
+		if observe != nil {
+			observe(step, 0)
+		}
+	}
+	restoreNotifications()
+	r.stopBackground()
+	writeTranscript(r.transcript, &r.result)
+	writeFilmstrip(&r.result)
+	pushArtifacts(&r.result)
+	r.notifyFinish()
+	return r.result
+}
+
+// runner executes a script one line at a time, accumulating the result
+type runner struct {
+	result   ExecutionResult
+	geometry *geometryWatcher
+	// background supervises the goroutines the run keeps going
+	background *supervisor
+	chaos    *chaosMonkey
+	idem     *idemStore
+	step     int
+	last     *StepResult
+	// pending holds annotations waiting for the next step
+	pending map[string]string
+
+	collecting  *rollbackBlock
+	rollbacks   []rollbackBlock
+	rollingBack bool
+	aborted     bool
+
+	// branches collects a parallel block, nil outside one. While its
+	// branches run, mu guards the run's state: a branch holds it except
+	// while its step executes.
+	branches   [][]string
+	inParallel bool
+	mu         sync.Mutex
+
+	inSetup     bool
+	setupPolicy string
+	bodyStarted bool
+
+	pendingShot *StepResult // coalesced step still to be captured
+	// onCapture, when set, is told of each step screenshot as it is taken
+	onCapture func(step *StepResult)
+
+	started  time.Time
+	degraded bool // a budget was exceeded
+
+	// locksBefore is the lock key state found at the start, restored at
+	// the end; nil if it was left alone
+	locksBefore *lockState
+
+	transcript *transcript
+
+	// activity is the user's input, watched with --yield
+	activity *userActivity
+
+	// displayBefore is DISPLAY at the start, put back at the end
+	displayBefore string
+	// headless is the run's own X server with --headless
+	headless *headlessSession
+	// xauthBefore is XAUTHORITY, if hadXauth, before the headless server
+	xauthBefore string
+	hadXauth    bool
+	// sandbox holds what the run started with launch_sandboxed
+	sandbox *sandbox
+	// heldKeys are the keys held with keydown, in the order pressed
+	heldKeys []string
+	// inputAudited is set once the run's input provenance is audited
+	inputAudited bool
+	// handoff is the run's --control socket state, nil without one
+	handoff *handoff
+
+	// playbooks are the script's recover blocks; playbook is the one being
+	// collected
+	playbooks []RecoveryPlaybook
+	playbook  *RecoveryPlaybook
+	// recoveries counts the run's recoveries; recovering is set while one
+	// runs
+	recoveries int
+	recovering bool
+}
+
+// StepResult is what a single step produced
+type StepResult struct {
+	Step       int                    `json:"step"`
+	Name       string                 `json:"name,omitempty"`
+	Action     string                 `json:"action,omitempty"`
+	Status     string                 `json:"status"`
+	Error      string                 `json:"error,omitempty"`
+	Screenshot *Screenshot            `json:"screenshot,omitempty"`
+	Output     map[string]interface{} `json:"output,omitempty"`
+	Events     []Event                `json:"events,omitempty"`
+	Audio      bool                   `json:"audio,omitempty"`
+
+	timedOut bool
+}
+
+func newRunner() *runner {
+	chaos, _ := newChaosMonkey() // validated at startup
+	background = newSupervisor()
+	r := &runner{
+		result: ExecutionResult{
+			Status:           "success",
+			CommandsExecuted: 0,
+			Screenshots:      []Screenshot{},
+			Errors:           []string{},
+		},
+		background: background,
+		chaos:      chaos,
+		sandbox:    &sandbox{},
+		started:    clock.Now(),
+		transcript: newTranscript(),
+
+		displayBefore: os.Getenv("DISPLAY"),
+	}
+	r.startHeadless()
+	r.geometry = startGeometryWatcher()
+	r.startRunAudio()
+	r.startYield()
+	r.holdLockKeys()
+	r.startControl()
+	return r
+}
+
+func (r *runner) close() {
+	awaitAbandoned()
+	r.stopBackground()
+	r.geometry.stop()
+	if c, ok := baseBackend().(runCloser); ok {
+		c.closeRun()
+	}
+	r.stopHeadless()
+	r.restoreDisplay()
+	r.restoreLockKeys()
+	closeSandbox(r.sandbox)
+	closeTTY()
+	closeAudio()
+}
+
+// runLine executes one script line. Returns nil for blank lines, comments,
+// annotations, block and section markers and anything after the run
+// aborted.
+func (r *runner) runLine(line string) *StepResult {
+	line = strings.TrimSpace(line)
+	if r.aborted {
+		return nil
+	}
+	if step, ok := r.parallelLine(line); ok {
+		if step != nil {
+			r.last, r.bodyStarted = step, true
+			r.abortOnFailure(step)
+		}
+		return step
+	}
+	if r.recoverLine(line) || r.blockLine(line) || r.setupLine(line) {
+		return nil
+	}
+	start := clock.Now()
+	if r.inSetup {
+		if step := r.runSetupStep(line); step != nil {
+			r.last = step
+			r.transcript.add(start, line, step)
+			return step
+		}
+		return nil
+	}
+	annotations, errors, status := r.pending, len(r.result.Errors), r.result.Status
+	step := r.runStep(line)
+	if step == nil {
+		return nil
 	}
+	r.transcript.add(start, line, step)
+	if step.Status == "error" {
+		step = r.recoverStep(step, line, annotations, errors, status)
+	}
+	r.last, r.bodyStarted = step, true
+	r.abortOnFailure(step)
+	return step
+}
+
+// abortOnFailure aborts the run after a failed step once there is
+// something to roll back, or after a step that timed out
+func (r *runner) abortOnFailure(step *StepResult) {
+	if step.Status == "error" && !r.aborted && (len(r.rollbacks) > 0 || step.timedOut) {
+		r.abort(fmt.Sprintf("step %d failed", step.Step))
+		step.Events = append(step.Events, r.result.Events[len(r.result.Events)-1])
+	}
+}
 
-	step := 0
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue // Skip empty lines and comments
+// abort stops the run; the caller rolls it back with finish
+func (r *runner) abort(reason string) {
+	r.aborted = true
+	r.result.Status = "error"
+	r.result.Events = append(r.result.Events, Event{Step: r.step, Type: "aborted",
+		Message: fmt.Sprintf("%s, rolling back %d blocks", reason, len(r.rollbacks))})
+}
+
+// runStep parses and executes one step
+func (r *runner) runStep(line string) *StepResult {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil // Skip empty lines and comments
+	}
+	if name, value, ok := parseAnnotation(line); ok {
+		if !knownAnnotations[name] {
+			r.result.Errors = append(r.result.Errors, fmt.Sprintf("Before step %d: unknown annotation @%s", r.step+1, name))
+			r.result.Status = "error"
+			return nil
 		}
+		if err := checkAnnotation(name, value); err != nil {
+			r.result.Errors = append(r.result.Errors, fmt.Sprintf("Before step %d: %v", r.step+1, err))
+			r.result.Status = "error"
+			return nil
+		}
+		r.pending = addAnnotation(r.pending, name, value)
+		return nil
+	}
+	annotations := r.pending
+	r.pending = nil
+	return r.runAnnotated(line, annotations)
+}
+
+// addAnnotation records an annotation for the next step; @env lines add
+// up, and @pre and @post lines collect one condition per line
+func addAnnotation(pending map[string]string, name, value string) map[string]string {
+	if pending == nil {
+		pending = map[string]string{}
+	}
+	if name == "env" && pending[name] != "" {
+		value = pending[name] + " " + value
+	}
+	if (name == "pre" || name == "post") && pending[name] != "" {
+		value = pending[name] + "\n" + value
+	}
+	pending[name] = value
+	return pending
+}
+
+// runAnnotated executes a step line with the annotations written before it
+func (r *runner) runAnnotated(line string, annotations map[string]string) *StepResult {
+	r.step++
+	step := &StepResult{Step: r.step, Name: annotations["name"], Status: "success"}
+	cmd := parseCommand(line)
+	if cmd == nil {
+		step.Status, step.Error = "error", "Could not parse: "+line
+		r.result.Errors = append(r.result.Errors, fmt.Sprintf("%s: %s", stepLabel(step.Step, step.Name), step.Error))
+		return step
+	}
+	step.Action = cmd.Action
+	cmd.Annotations = annotations
+	if screenshotPolicy(cmd.Action) != "coalesce" {
+		r.flushScreenshot() // the burst before this step is over
+	}
 
-		step++
-		cmd := parseCommand(line)
-		if cmd == nil {
-			result.Errors = append(result.Errors, fmt.Sprintf("Step %d: Could not parse: %s", step, line))
-			continue
+	// Steps whose idempotency key already succeeded are not run again
+	idemKey := annotations["idem"]
+	if idemKey != "" {
+		if r.idem == nil {
+			r.idem = openIdemStore()
 		}
+		if done, ok := r.idem.done(idemKey); ok {
+			step.Status = "skipped"
+			step.Events = []Event{{Step: step.Step, Type: "idempotent_skip",
+				Message: fmt.Sprintf("%s already done at %s", idemKey, done.Done.Format(time.RFC3339))}}
+			r.result.Events = append(r.result.Events, step.Events...)
+			return step
+		}
+	}
 
-		// Execute command
-		err := executeCommand(cmd)
+	// A person who asked for control gets it before the step
+	if events := r.handOver(step); len(events) > 0 {
+		r.result.Events = append(r.result.Events, events...)
+		step.Events = append(step.Events, events...)
+	}
+
+	// Re-check monitor geometry so we never click against a stale layout
+	if change := r.geometry.refresh(); change != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", change)
+		event := Event{Step: step.Step, Type: "geometry_changed", Message: change}
+		r.result.Events = append(r.result.Events, event)
+		step.Events = append(step.Events, event)
+	}
+
+	// Someone using the machine goes first
+	if event := r.yieldToUser(step.Step); event != nil {
+		r.result.Events = append(r.result.Events, *event)
+		step.Events = append(step.Events, *event)
+	}
+
+	// Input meant for the desktop must not go to a lock screen
+	if defaultScreenshotPolicies[cmd.Action] != "never" {
+		event, err := r.checkScreenLock(step.Step)
+		if event != nil {
+			r.result.Events = append(r.result.Events, *event)
+			step.Events = append(step.Events, *event)
+		}
 		if err != nil {
-			result.Errors = append(result.Errors, fmt.Sprintf("Step %d: %v", step, err))
-			result.Status = "error"
-		} else {
-			result.CommandsExecuted++
+			step.Status, step.Error = "error", err.Error()
+			r.result.Errors = append(r.result.Errors, fmt.Sprintf("%s: %s", stepLabel(step.Step, step.Name), step.Error))
+			r.abort("the session is locked")
+			step.Events = append(step.Events, r.result.Events[len(r.result.Events)-1])
+			return step
 		}
+	}
+
+	// A modifier held by the user would change every key the step sends
+	if keyboardActions[cmd.Action] {
+		if event := checkStuckModifiers(step.Step, r.heldModifiers()); event != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", event.Message)
+			r.result.Events = append(r.result.Events, *event)
+			step.Events = append(step.Events, *event)
+		}
+	}
 
-		// Take screenshot after action (for verification)
-		screenshotFile := takeScreenshot(step, cmd.Action)
-		if screenshotFile != "" {
-			result.Screenshots = append(result.Screenshots, Screenshot{
-				Step:   step,
-				File:   screenshotFile,
-				Action: cmd.Action,
-			})
+	// Execute command
+	start := clock.Now()
+	audioMark := runAudio.mark()
+	events, err := r.chaos.inject(step.Step, cmd.Action)
+	r.result.Events = append(r.result.Events, events...)
+	step.Events = append(step.Events, events...)
+	if err == nil {
+		err = r.geometry.remapCommand(cmd)
+	}
+	if err == nil {
+		r.unlocked(func() { err = checkConditions(annotations["pre"], false) })
+	}
+	if err == nil {
+		err = r.execute(cmd, step)
+		if defaultScreenshotPolicies[cmd.Action] != "never" {
+			r.auditInput()
+		}
+	}
+	if err == nil && (cmd.Action == "keydown" || cmd.Action == "keyup") {
+		r.trackHeldKeys(cmd)
+	}
+	if err == nil && cmd.Action == "display" {
+		// Watch the new display's monitors from here on
+		r.geometry.stop()
+		r.geometry = startGeometryWatcher()
+	}
+	if err == nil {
+		r.unlocked(func() { err = checkConditions(annotations["post"], true) })
+	}
+	r.audioActivity(step, audioMark)
+	if budget, _ := parseBudget(annotations["budget"]); budget > 0 && err == nil {
+		if took := since(start); took > budget {
+			r.overBudget(step, took, budget)
+		}
+	}
+	if err != nil {
+		step.Status, step.Error = "error", err.Error()
+		_, step.timedOut = err.(timeoutError)
+		r.result.Errors = append(r.result.Errors, fmt.Sprintf("%s: %v", stepLabel(step.Step, step.Name), err))
+		r.result.Status = "error"
+		r.releaseHeldKeys(step, "the step failed")
+	} else {
+		r.result.CommandsExecuted++
+		if idemKey != "" {
+			if err := r.idem.record(idemKey, IdemRecord{Action: cmd.Action, Line: line, Done: time.Now().UTC()}); err != nil {
+				event := Event{Step: step.Step, Type: "idempotency_error", Message: err.Error()}
+				r.result.Events = append(r.result.Events, event)
+				step.Events = append(step.Events, event)
+			}
 		}
 	}
+	if cmd.Output != nil {
+		step.Output = cmd.Output
+		r.result.Outputs = append(r.result.Outputs, StepOutput{Step: step.Step, Name: step.Name, Action: cmd.Action, Data: cmd.Output})
+	}
 
-	// Output result as JSON
-	jsonOutput, _ := json.MarshalIndent(result, "", "  ")
-	fmt.Println(string(jsonOutput))
+	// Take screenshot after action (for verification)
+	r.screenshot(step)
+	return step
 }
 
 func parseCommand(line string) *Command {
@@ -130,6 +831,9 @@ func parseCommand(line string) *Command {
 	if len(parts) == 0 {
 		return nil
 	}
+	if strings.HasPrefix(line, "{") {
+		return parseJSONCommand(line)
+	}
 
 	action := strings.ToLower(parts[0])
 	cmd := &Command{
@@ -140,114 +844,266 @@ func parseCommand(line string) *Command {
 
 	switch action {
 	case "pointer":
-		if len(parts) >= 3 {
-			x, _ := strconv.Atoi(parts[1])
-			y, _ := strconv.Atoi(parts[2])
-			cmd.Params["x"] = x
-			cmd.Params["y"] = y
+		if len(parts) >= 3 && parsePoint(cmd, coordinateParams[0], parts[1], parts[2]) {
 			return cmd
 		}
-	case "click":
-		if len(parts) >= 3 {
-			button, _ := strconv.Atoi(parts[1])
-			clicks := parts[2]
-			cmd.Params["button"] = button
-			cmd.Params["clicks"] = clicks
+	case "pointer_rel":
+		return parsePointerRel(cmd, parts)
+	case "hover":
+		// hover X Y SECONDS
+		if len(parts) == 4 && parsePoint(cmd, coordinateParams[0], parts[1], parts[2]) {
+			seconds, err := strconv.ParseFloat(parts[3], 64)
+			if err != nil || seconds < 0 {
+				return nil
+			}
+			cmd.Params["seconds"] = seconds
 			return cmd
 		}
+	case "click":
+		return parseClick(cmd, parts)
 	case "type":
 		// Extract text in quotes
 		text := strings.TrimPrefix(line, "type ")
 		text = strings.Trim(text, "\"")
 		cmd.Params["text"] = text
 		return cmd
+	case "type_verified":
+		// type_verified "TEXT"
+		text := strings.Trim(strings.TrimSpace(line[len(parts[0]):]), "\"")
+		cmd.Params["text"] = text
+		return cmd
 	case "key":
+		// key COMBO [COMBO...], pressed in order
 		if len(parts) >= 2 {
-			cmd.Params["key"] = parts[1]
+			cmd.Params["key"] = strings.Join(parts[1:], " ")
+			return cmd
+		}
+	case "fill_form":
+		// fill_form FILE [right|below|tab]
+		return parseFillForm(cmd, parts)
+	case "type_totp":
+		// type_totp cred:SERVICE/FIELD
+		if len(parts) == 2 && validCredentialRef(parts[1]) {
+			cmd.Params["credential"] = parts[1]
 			return cmd
 		}
 	case "wait":
+		if len(parts) >= 2 && parts[1] == "auto" {
+			// wait auto [seconds]: scaled by this host's calibrated factor
+			seconds := 1.0
+			if len(parts) >= 3 {
+				seconds, _ = strconv.ParseFloat(parts[2], 64)
+			}
+			cmd.Params["seconds"] = seconds
+			cmd.Params["auto"] = true
+			return cmd
+		}
 		if len(parts) >= 2 {
 			seconds, _ := strconv.ParseFloat(parts[1], 64)
 			cmd.Params["seconds"] = seconds
 			return cmd
 		}
 	case "drag":
-		if len(parts) >= 6 {
-			x1, _ := strconv.Atoi(parts[1])
-			y1, _ := strconv.Atoi(parts[2])
-			x2, _ := strconv.Atoi(parts[3])
-			y2, _ := strconv.Atoi(parts[4])
+		if len(parts) >= 6 && parsePoint(cmd, coordinateParams[1], parts[1], parts[2]) &&
+			parsePoint(cmd, coordinateParams[2], parts[3], parts[4]) {
 			duration, _ := strconv.ParseFloat(parts[5], 64)
-			cmd.Params["x1"] = x1
-			cmd.Params["y1"] = y1
-			cmd.Params["x2"] = x2
-			cmd.Params["y2"] = y2
 			cmd.Params["duration"] = duration
 			return cmd
 		}
 	case "scroll":
-		if len(parts) >= 4 {
-			x, _ := strconv.Atoi(parts[1])
-			y, _ := strconv.Atoi(parts[2])
+		if len(parts) >= 4 && parsePoint(cmd, coordinateParams[0], parts[1], parts[2]) {
 			amount, _ := strconv.Atoi(parts[3])
-			cmd.Params["x"] = x
-			cmd.Params["y"] = y
 			cmd.Params["amount"] = amount
 			return cmd
 		}
 	case "screenshot":
+		// screenshot FILENAME [MONITOR]
 		if len(parts) >= 2 {
 			filename := strings.Trim(parts[1], "\"")
 			cmd.Params["filename"] = filename
+			if len(parts) >= 3 {
+				monitor, err := strconv.Atoi(parts[2])
+				if err != nil || monitor < 1 {
+					return nil
+				}
+				cmd.Params["monitor"] = monitor
+			}
+			return cmd
+		}
+	case "monitors":
+		return cmd
+	case "display":
+		return parseDisplayAction(cmd, parts)
+	case "keydown", "keyup":
+		return parseKeyHold(cmd, parts)
+	case "keys":
+		if len(parts) == 1 {
+			return cmd
+		}
+	case "click_image", "assert_image", "assert_screen":
+		if len(parts) >= 2 {
+			cmd.Params["file"] = strings.Trim(parts[1], "\"")
+			threshold := defaultImageThreshold
+			if len(parts) >= 3 {
+				if t, err := strconv.ParseFloat(parts[2], 64); err == nil {
+					threshold = t
+				}
+			}
+			cmd.Params["threshold"] = threshold
 			return cmd
 		}
+	case "click_text", "assert_text", "click_described":
+		text := strings.Trim(strings.TrimSpace(line[len(parts[0]):]), "\"")
+		if text != "" {
+			cmd.Params["text"] = text
+			return cmd
+		}
+	case "find_text":
+		// find_text "TEXT" [RELATION="ANCHOR"]...
+		return parseFindText(cmd, line[len(parts[0]):])
+	case "observe":
+		return parseObserve(cmd, parts)
+	case "close_window":
+		// close_window TITLE
+		title := strings.Trim(strings.TrimSpace(line[len(parts[0]):]), "\"")
+		if title != "" {
+			cmd.Params["title"] = title
+			return cmd
+		}
+	case "clear_clipboard":
+		return cmd
+	case "launch", "launch_sandboxed":
+		// launch APP [ARGS...]
+		if len(parts) >= 2 {
+			cmd.Params["app"] = parts[1]
+			cmd.Params["args"] = parts[2:]
+			return cmd
+		}
+	case "tty_open", "tty_send", "tty_expect":
+		return parseTTY(cmd, line, parts)
+	case "speak", "beep":
+		return parseSound(cmd, line, parts)
+	case "capture_camera":
+		return parseCaptureCamera(cmd, parts)
+	case "print_to_pdf":
+		return parsePrintToPDF(cmd, line, parts)
+	case "wait_for_sound":
+		// wait_for_sound THRESHOLD_DB [SECONDS]
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil
+		}
+		threshold, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || threshold > 0 {
+			return nil
+		}
+		timeout := defaultSoundWait
+		if len(parts) == 3 {
+			if timeout, err = strconv.ParseFloat(parts[2], 64); err != nil || timeout <= 0 {
+				return nil
+			}
+		}
+		cmd.Params["threshold"] = threshold
+		cmd.Params["timeout"] = timeout
+		return cmd
+	case "set_capslock", "set_numlock":
+		// set_capslock on|off
+		if len(parts) == 2 && (parts[1] == "on" || parts[1] == "off") {
+			cmd.Params["on"] = parts[1] == "on"
+			return cmd
+		}
+	case "do_not_disturb":
+		// do_not_disturb on|off
+		if len(parts) >= 2 && (parts[1] == "on" || parts[1] == "off") {
+			cmd.Params["on"] = parts[1] == "on"
+			return cmd
+		}
+	case "snapshot_session", "restore_session":
+		// snapshot_session [NAME]
+		name := "default"
+		if len(parts) >= 2 {
+			name = parts[1]
+		}
+		if validSessionName(name) {
+			cmd.Params["name"] = name
+			return cmd
+		}
+	case "read_text", "read_qr":
+		// read_text [x y w h]
+		if len(parts) != 1 && len(parts) != 5 {
+			return nil
+		}
+		for i, name := range []string{"x", "y", "w", "h"}[:len(parts)-1] {
+			v, err := strconv.Atoi(parts[i+1])
+			if err != nil || (name == "w" || name == "h") && v <= 0 {
+				return nil
+			}
+			cmd.Params[name] = v
+		}
+		return cmd
 	}
 
 	return nil
 }
 
 func executeCommand(cmd *Command) error {
+	input := currentBackend()
+	if err := resolveMonitorCoordinates(cmd); err != nil {
+		return err
+	}
 	switch cmd.Action {
 	case "pointer":
 		x := int(cmd.Params["x"].(int))
 		y := int(cmd.Params["y"].(int))
-		return runXdotool("mousemove", strconv.Itoa(x), strconv.Itoa(y))
+		return movePointer(input, x, y)
 
-	case "click":
-		button := int(cmd.Params["button"].(int))
-		clicks := cmd.Params["clicks"].(string)
-		
-		// Get current mouse position or use coordinates if provided
-		if x, ok := cmd.Params["x"]; ok {
-			// Click at specific coordinates
-			xVal := int(x.(int))
-			yVal := int(cmd.Params["y"].(int))
-			runXdotool("mousemove", strconv.Itoa(xVal), strconv.Itoa(yVal))
-		}
-		
-		if clicks == "d" || clicks == "double" {
-			// Double click
-			runXdotool("click", "--repeat", "2", strconv.Itoa(button))
-		} else {
-			// Single click
-			runXdotool("click", strconv.Itoa(button))
+	case "pointer_rel":
+		return pointerRel(cmd)
+
+	case "hover":
+		// The step's screenshot comes after the dwell, with the pointer
+		// still there, so it shows the tooltip or menu the hover opened
+		if err := movePointer(input, cmd.Params["x"].(int), cmd.Params["y"].(int)); err != nil {
+			return err
 		}
+		clock.Sleep(time.Duration(cmd.Params["seconds"].(float64) * float64(time.Second)))
 		return nil
 
+	case "click":
+		return clickAt(cmd, input)
+
 	case "type":
 		text := cmd.Params["text"].(string)
-		// Escape special characters for xdotool
-		text = strings.ReplaceAll(text, "\"", "\\\"")
-		return runXdotool("type", "--delay", "50", text)
+		return typeText(cmd, input, text)
 
 	case "key":
 		key := cmd.Params["key"].(string)
-		return runXdotool("key", key)
+		if err := checkKeys(key); err != nil {
+			return err
+		}
+		return input.Key(key)
+
+	case "keys":
+		cmd.Output = keysOutput()
+		return nil
+
+	case "type_verified":
+		return typeVerified(cmd)
+
+	case "set_capslock", "set_numlock":
+		return setLockKey(cmd)
+
+	case "type_totp":
+		return typeTOTP(cmd)
+
+	case "fill_form":
+		return fillForm(cmd)
 
 	case "wait":
 		seconds := cmd.Params["seconds"].(float64)
-		time.Sleep(time.Duration(seconds * float64(time.Second)))
+		if _, ok := cmd.Params["auto"]; ok {
+			seconds *= currentHostTiming().WaitFactor
+		}
+		clock.Sleep(time.Duration(seconds * float64(time.Second)))
 		return nil
 
 	case "drag":
@@ -258,8 +1114,13 @@ func executeCommand(cmd *Command) error {
 		duration := cmd.Params["duration"].(float64)
 		
 		// Move to start, press button, move to end, release
-		runXdotool("mousemove", strconv.Itoa(x1), strconv.Itoa(y1))
-		runXdotool("mousedown", "1")
+		movePointer(input, x1, y1)
+		input.MouseDown(1)
+		if mouseProfile == "human" {
+			err := moveHuman(input, image.Pt(x1, y1), image.Pt(x2, y2), time.Duration(duration*float64(time.Second)))
+			input.MouseUp(1)
+			return err
+		}
 		
 		// Smooth drag over duration
 		steps := int(duration * 10) // 10 steps per second
@@ -273,12 +1134,12 @@ func executeCommand(cmd *Command) error {
 		for i := 0; i < steps; i++ {
 			px := x1 + int(float64(i)*dx)
 			py := y1 + int(float64(i)*dy)
-			runXdotool("mousemove", strconv.Itoa(px), strconv.Itoa(py))
-			time.Sleep(stepDuration)
+			input.MoveMouse(px, py)
+			clock.Sleep(stepDuration)
 		}
 		
-		runXdotool("mousemove", strconv.Itoa(x2), strconv.Itoa(y2))
-		runXdotool("mouseup", "1")
+		input.MoveMouse(x2, y2)
+		input.MouseUp(1)
 		return nil
 
 	case "scroll":
@@ -286,58 +1147,231 @@ func executeCommand(cmd *Command) error {
 		y := int(cmd.Params["y"].(int))
 		amount := int(cmd.Params["amount"].(int))
 		
-		runXdotool("mousemove", strconv.Itoa(x), strconv.Itoa(y))
+		movePointer(input, x, y)
 		// Scroll: 4 = up, 5 = down
-		button := "4"
+		button := 4
 		if amount > 0 {
-			button = "5" // Scroll down
+			button = 5 // Scroll down
 		} else {
 			amount = -amount // Make positive for repeat count
 		}
-		runXdotool("click", "--repeat", strconv.Itoa(amount), button)
+		input.Click(button, amount)
 		return nil
 
 	case "screenshot":
-		// Screenshot is handled separately in takeScreenshot
+		// The step's own screenshot is taken in takeScreenshot; a monitor's
+		// is saved under the name given
+		monitor, ok := cmd.Params["monitor"].(int)
+		if !ok {
+			return nil
+		}
+		file, err := monitorScreenshot(cmd.Params["filename"].(string), monitor)
+		if err != nil {
+			return err
+		}
+		cmd.Output = map[string]interface{}{"file": file, "monitor": monitor}
+		return nil
+
+	case "display":
+		return useDisplay(cmd.Params["display"].(string))
+
+	case "keydown", "keyup":
+		return holdKeys(cmd)
+
+	case "monitors":
+		monitors, err := queryMonitors()
+		if err != nil {
+			return err
+		}
+		if monitors == nil {
+			monitors = []Monitor{}
+		}
+		cmd.Output = map[string]interface{}{"monitors": monitors}
+		return nil
+
+	case "click_image":
+		x, y, err := findOnScreen(cmd.Params["file"].(string), cmd.Params["threshold"].(float64))
+		if err != nil {
+			return err
+		}
+		movePointer(input, x, y)
+		return input.Click(1, 1)
+
+	case "assert_image":
+		_, _, err := findOnScreen(cmd.Params["file"].(string), cmd.Params["threshold"].(float64))
+		return err
+
+	case "assert_screen":
+		file := cmd.Params["file"].(string)
+		threshold := cmd.Params["threshold"].(float64)
+		baseline, err := loadTemplate(file)
+		if err != nil {
+			return err
+		}
+		screen, err := captureScreen()
+		if err != nil {
+			return err
+		}
+		score, err := compareImages(screen, baseline)
+		releaseFrame(screen)
+		if err != nil {
+			return err
+		}
+		if score < threshold {
+			return fmt.Errorf("screen differs from %s (similarity %.3f < %.3f)", file, score, threshold)
+		}
+		return nil
+
+	case "read_text":
+		words, err := recognizeScreen(ocrRegionFromParams(cmd.Params))
+		if err == nil {
+			// Text that is masked or redacted in screenshots is not read out
+			words, err = redactWords(words)
+		}
+		if err != nil {
+			return err
+		}
+		if words == nil {
+			words = []OCRWord{}
+		}
+		texts := make([]string, len(words))
+		for i, w := range words {
+			texts[i] = w.Text
+		}
+		cmd.Output = map[string]interface{}{"text": strings.Join(texts, " "), "words": words}
+		return nil
+
+	case "read_qr":
+		codes, err := readBarcodes(ocrRegionFromParams(cmd.Params))
+		if err != nil {
+			return err
+		}
+		cmd.Output = map[string]interface{}{"codes": codes}
+		return nil
+
+	case "click_text", "assert_text":
+		text := cmd.Params["text"].(string)
+		words, err := recognizeScreen(image.Rectangle{})
+		if err == nil {
+			words, err = redactWords(words)
+		}
+		if err != nil {
+			return err
+		}
+		box, ok := findText(words, text)
+		if !ok {
+			return fmt.Errorf("text %q not found on screen", text)
+		}
+		cmd.Output = map[string]interface{}{"match": box}
+		if cmd.Action == "assert_text" {
+			return nil
+		}
+		movePointer(input, box.X+box.Width/2, box.Y+box.Height/2)
+		return input.Click(1, 1)
+
+	case "find_text":
+		return findTextRelative(cmd)
+
+	case "observe":
+		observation := perceive(cmd.Params["request"].(PerceptionRequest))
+		cmd.Output = map[string]interface{}{"observation": observation}
+		if len(observation.Errors) > 0 {
+			var failed []string
+			for name, msg := range observation.Errors {
+				failed = append(failed, name+": "+msg)
+			}
+			sort.Strings(failed)
+			return fmt.Errorf("observe: %s", strings.Join(failed, "; "))
+		}
+		return nil
+
+	case "click_described":
+		target, err := locateDescribed(cmd.Params["text"].(string))
+		if err != nil {
+			return err
+		}
+		cmd.Output = map[string]interface{}{"match": target}
+		movePointer(input, target.X, target.Y)
+		return input.Click(1, 1)
+
+	case "snapshot_session":
+		snap, err := snapshotSession(cmd.Params["name"].(string))
+		if err != nil {
+			return err
+		}
+		cmd.Output = map[string]interface{}{"session": snap}
 		return nil
 
+	case "restore_session":
+		output, err := restoreSession(cmd.Params["name"].(string))
+		cmd.Output = output
+		return err
+
+	case "close_window":
+		closed, err := closeWindows(cmd.Params["title"].(string))
+		cmd.Output = map[string]interface{}{"closed": closed}
+		return err
+
+	case "clear_clipboard":
+		return clearClipboard()
+
+	case "launch", "launch_sandboxed":
+		sandbox, err := launchApp(cmd.Params["app"].(string), cmd.Params["args"].([]string), cmd.Action == "launch_sandboxed", stepLaunchEnv(cmd.Annotations), cmd.sandbox)
+		if sandbox != "" {
+			cmd.Output = map[string]interface{}{"sandbox": sandbox}
+		}
+		return err
+
+	case "tty_open", "tty_send", "tty_expect":
+		return runTTYAction(cmd)
+
+	case "wait_for_sound":
+		return waitForSound(cmd)
+
+	case "speak":
+		return speak(cmd.Params["text"].(string))
+
+	case "capture_camera":
+		return captureCamera(cmd)
+
+	case "print_to_pdf":
+		return printToPDF(cmd)
+
+	case "beep":
+		file, _ := cmd.Params["file"].(string)
+		return beep(file)
+
+	case "do_not_disturb":
+		was, err := setDoNotDisturb(cmd.Params["on"].(bool))
+		cmd.Output = map[string]interface{}{"was_on": was}
+		return err
+
 	default:
 		return fmt.Errorf("unknown action: %s", cmd.Action)
 	}
 }
 
-func runXdotool(args ...string) error {
-	cmd := exec.Command("xdotool", args...)
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
 func takeScreenshot(step int, action string) string {
 	screenshotCounter++
 	filename := fmt.Sprintf("screenshot_%d_%s_%d.png", step, action, screenshotCounter)
 	filepath := filepath.Join(screenshotsDir, filename)
 	
-	// Use mss or import command (if available)
-	// For now, use import (ImageMagick) as fallback, or xdotool screenshot if available
-	// Try import first (ImageMagick)
-	cmd := exec.Command("import", "-window", "root", filepath)
-	if err := cmd.Run(); err == nil {
-		return filepath
-	}
-	
-	// Try xwd + convert (X11)
-	cmd = exec.Command("xwd", "-root", "-out", filepath+".xwd")
-	if err := cmd.Run(); err == nil {
-		// Convert xwd to png
-		convertCmd := exec.Command("convert", filepath+".xwd", filepath)
-		if convertCmd.Run() == nil {
-			os.Remove(filepath + ".xwd")
-			return filepath
-		}
-		os.Remove(filepath + ".xwd")
+	if err := saveScreenshot(filepath); err == nil {
+		return finishScreenshot(filepath)
 	}
-	
+
 	// If all else fails, return empty (screenshot not available)
 	return ""
 }
 
+// finishScreenshot applies post-processing to a stored screenshot. If
+// redaction is required but fails, the file is deleted rather than kept
+// unredacted.
+func finishScreenshot(path string) string {
+	if err := redactScreenshotFile(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: dropping screenshot %s, redaction failed: %v\n", path, err)
+		os.Remove(path)
+		return ""
+	}
+	return path
+}
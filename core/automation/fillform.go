@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// fill_form fills a form from a JSON object of field labels and values,
+// in place of a click and a type step per field:
+//
+//	fill_form signup.json
+//	fill_form address.json below
+//
+//	{"First name": "Jane", "Email": "jane.doe@example.com", "Password": "cred:shop/password"}
+//
+// Fields are filled in the file's order. Each label is looked up in the
+// accessibility tree, as the name of an editable element, and otherwise
+// on screen with OCR; the field is then clicked: the element itself, or
+// the point just past the label on the side the layout says fields are,
+// right (the default) or below. With the tab layout only the first field
+// is clicked and Tab moves on to each next one. The field's content is
+// replaced with the value and read back as type_verified does, failing
+// the step at the first field that does not take its value. Values may be
+// cred:SERVICE/FIELD references; no value appears in the step's output or
+// errors. Those, and password fields, which cannot be copied, are never
+// read back through the clipboard: only the length of the field's value
+// in the accessibility tree is checked, where it has one.
+
+var fillFormLayouts = []string{"right", "below", "tab"}
+
+// fillFormReach is how far past its label a field is clicked, across the
+// gap between them
+const (
+	fillFormReachX = 40
+	fillFormReachY = 20
+)
+
+// formField is one label and value of a fill_form file
+type formField struct {
+	label, value string
+}
+
+// parseFillForm reads fill_form FILE [right|below|tab]
+func parseFillForm(cmd *Command, parts []string) *Command {
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil
+	}
+	cmd.Params["file"] = parts[1]
+	cmd.Params["layout"] = "right"
+	if len(parts) == 3 {
+		if !contains(fillFormLayouts, parts[2]) {
+			return nil
+		}
+		cmd.Params["layout"] = parts[2]
+	}
+	return cmd
+}
+
+// readFormFields reads a fill_form file's fields in the file's order
+func readFormFields(file string) ([]formField, error) {
+	data, err := os.ReadFile(scriptPath(file))
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("%s: want a JSON object of field labels and values", file)
+	}
+	var fields []formField
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		label := t.(string)
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("%s: %q: %v", file, label, err)
+		}
+		switch v := value.(type) {
+		case string:
+			fields = append(fields, formField{label, v})
+		case json.Number, bool:
+			fields = append(fields, formField{label, fmt.Sprint(v)})
+		default:
+			return nil, fmt.Errorf("%s: %q: want a string, number or boolean value", file, label)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s: no fields", file)
+	}
+	return fields, nil
+}
+
+// fillForm runs fill_form
+func fillForm(cmd *Command) error {
+	input := currentBackend()
+	layout := cmd.Params["layout"].(string)
+	fields, err := readFormFields(cmd.Params["file"].(string))
+	if err != nil {
+		return err
+	}
+	// Labels stay put while the fields fill, so one read serves them all
+	words, ocrErr := recognizeScreen(image.Rectangle{})
+	elements, _ := dumpA11y()
+	var filled []map[string]interface{}
+	for i, f := range fields {
+		entry := map[string]interface{}{"label": f.label}
+		secret := false
+		if i > 0 && layout == "tab" {
+			if err := input.Key("Tab"); err != nil {
+				return fmt.Errorf("field %q: %v", f.label, err)
+			}
+			entry["via"] = "tab"
+		} else {
+			at, via, password, err := locateFormField(f.label, layout, words, ocrErr, elements)
+			if err != nil {
+				return fmt.Errorf("field %q: %v", f.label, err)
+			}
+			if err := movePointer(input, at.X, at.Y); err != nil {
+				return fmt.Errorf("field %q: %v", f.label, err)
+			}
+			if err := input.Click(1, 1); err != nil {
+				return fmt.Errorf("field %q: %v", f.label, err)
+			}
+			entry["via"], entry["x"], entry["y"] = via, at.X, at.Y
+			secret = password
+		}
+		secret = secret || strings.Contains(strings.ToLower(f.label), "password")
+		value := f.value
+		if validCredentialRef(value) {
+			if value, err = resolveCredential(value); err != nil {
+				return fmt.Errorf("field %q: %v", f.label, err)
+			}
+			secret = true
+		}
+		if secret {
+			checked, err := enterSecret(value)
+			if err != nil {
+				return fmt.Errorf("field %q: %v", f.label, err)
+			}
+			entry["verified"] = checked
+			filled = append(filled, entry)
+			continue
+		}
+		attempts, _, err := enterVerified(value)
+		var mismatch *fieldMismatch
+		if errors.As(err, &mismatch) {
+			return fmt.Errorf("field %q does not hold its value after %d attempts", f.label, typeVerifyAttempts)
+		}
+		if err != nil {
+			return fmt.Errorf("field %q: %v", f.label, err)
+		}
+		entry["attempts"], entry["verified"] = attempts, "read_back"
+		filled = append(filled, entry)
+	}
+	cmd.Output = map[string]interface{}{"fields": filled}
+	return nil
+}
+
+// locateFormField finds where to click for a label's field, whether the
+// accessibility tree or OCR found it, and whether the tree says it is a
+// password field
+func locateFormField(label, layout string, words []OCRWord, ocrErr error, elements []A11yElement) (image.Point, string, bool, error) {
+	for _, e := range elements {
+		if contains(e.States, "editable") && e.Width > 0 && strings.Contains(strings.ToLower(e.Name), strings.ToLower(label)) {
+			return image.Pt(e.X+e.Width/2, e.Y+e.Height/2), "a11y", strings.Contains(e.Role, "password"), nil
+		}
+	}
+	if ocrErr != nil {
+		return image.Point{}, "", false, fmt.Errorf("not in the accessibility tree, and %v", ocrErr)
+	}
+	box, ok := findText(words, label)
+	if !ok {
+		return image.Point{}, "", false, fmt.Errorf("label not found on screen")
+	}
+	if layout == "below" {
+		return image.Pt(box.X+fillFormReachX/2, box.Y+box.Height+fillFormReachY), "ocr", false, nil
+	}
+	return image.Pt(box.X+box.Width+fillFormReachX, box.Y+box.Height/2), "ocr", false, nil
+}
+
+// enterSecret replaces the focused field's content with a secret, typed
+// once. The field is checked only by the length of its value in the
+// accessibility tree, as password fields show bullets; it returns
+// "length" when it was, "none" when the tree has no value to check.
+func enterSecret(text string) (string, error) {
+	input := currentBackend()
+	if err := input.Key("ctrl+a"); err != nil {
+		return "", err
+	}
+	if err := input.Key("BackSpace"); err != nil {
+		return "", err
+	}
+	if err := typeSecret(input, text); err != nil {
+		return "", err
+	}
+	clock.Sleep(typeVerifySettle)
+	got, err := focusedA11yValue()
+	if err != nil {
+		return "none", nil
+	}
+	if n, want := utf8.RuneCountInString(got), utf8.RuneCountInString(text); n != want {
+		return "", fmt.Errorf("field holds %d characters, want %d", n, want)
+	}
+	return "length", nil
+}
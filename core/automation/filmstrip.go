@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// filmstripFormat is set by --filmstrip: webp, avif, or empty for none
+var filmstripFormat string
+
+var filmstripFormats = []string{"webp", "avif"}
+
+const (
+	filmstripWidth     = 640 // frames are scaled down to at most this width
+	filmstripFrameTime = 800 * time.Millisecond
+	filmstripLastTime  = 2500 * time.Millisecond
+	filmstripLabelSize = 2
+)
+
+// writeFilmstrip animates the run's screenshots into one file, each frame
+// labelled with its step, and reports it in the result
+func writeFilmstrip(result *ExecutionResult) {
+	if filmstripFormat == "" || len(result.Screenshots) == 0 {
+		return
+	}
+	path := filepath.Join(screenshotsDir, fmt.Sprintf("filmstrip_%s.%s", time.Now().Format("20060102_150405"), filmstripFormat))
+	if err := encodeFilmstrip(result.Screenshots, path); err != nil {
+		result.Events = append(result.Events, Event{Type: "filmstrip_error", Message: err.Error()})
+		return
+	}
+	result.Filmstrip = path
+}
+
+func encodeFilmstrip(shots []Screenshot, path string) error {
+	var frames []webpFrame
+	for i, shot := range shots {
+		img, err := loadPNG(shot.File)
+		if err != nil {
+			return fmt.Errorf("filmstrip: %v", err)
+		}
+		if len(frames) > 0 {
+			img = scaleTo(img, frames[0].img.Bounds())
+		}
+		frame := scaleDown(img, filmstripWidth)
+		burnLabel(frame, fmt.Sprintf("%d %s", shot.Step, shot.Action))
+		duration := filmstripFrameTime
+		if i == len(shots)-1 {
+			duration = filmstripLastTime
+		}
+		frames = append(frames, webpFrame{img: frame, duration: duration})
+	}
+
+	if filmstripFormat == "avif" {
+		return encodeAVIF(frames, path)
+	}
+	return os.WriteFile(path, encodeAnimatedWebP(frames), 0644)
+}
+
+// encodeAVIF has ffmpeg's AV1 encoder build the animation, as there is no
+// AVIF encoder in the standard library
+func encodeAVIF(frames []webpFrame, path string) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("filmstrip: avif needs ffmpeg with libaom-av1")
+	}
+	dir, err := os.MkdirTemp("", "filmstrip")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	// ffmpeg wants a constant frame rate, so longer frames repeat
+	n := 0
+	for _, frame := range frames {
+		for t := time.Duration(0); t < frame.duration; t += 100 * time.Millisecond {
+			n++
+			if err := writePNG(filepath.Join(dir, fmt.Sprintf("%06d.png", n)), frame.img); err != nil {
+				return err
+			}
+		}
+	}
+	cmd := exec.Command("ffmpeg", "-y", "-loglevel", "error", "-framerate", "10",
+		"-i", filepath.Join(dir, "%06d.png"), "-c:v", "libaom-av1", "-cpu-used", "8",
+		"-crf", "40", "-pix_fmt", "yuv420p", path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("filmstrip: ffmpeg: %v: %s", err, out)
+	}
+	return nil
+}
+
+// scaleDown shrinks img to at most width pixels wide by averaging, and
+// always returns a copy
+func scaleDown(img image.Image, width int) *image.RGBA {
+	b := img.Bounds()
+	if b.Dx() <= width {
+		out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+		return out
+	}
+	height := b.Dy() * width / b.Dx()
+	return scaleTo(img, image.Rect(0, 0, width, height)).(*image.RGBA)
+}
+
+// scaleTo resamples img to the size of r, averaging the source pixels
+// behind each output pixel
+func scaleTo(img image.Image, r image.Rectangle) image.Image {
+	b := img.Bounds()
+	if b.Size() == r.Size() {
+		return img
+	}
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	for y := 0; y < r.Dy(); y++ {
+		y0, y1 := y*b.Dy()/r.Dy(), max((y+1)*b.Dy()/r.Dy(), y*b.Dy()/r.Dy()+1)
+		for x := 0; x < r.Dx(); x++ {
+			x0, x1 := x*b.Dx()/r.Dx(), max((x+1)*b.Dx()/r.Dx(), x*b.Dx()/r.Dx()+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					p := src.Pix[src.PixOffset(sx, sy):]
+					for c := range sum {
+						sum[c] += int(p[c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			p := out.Pix[out.PixOffset(x, y):]
+			for c := range sum {
+				p[c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return out
+}
+
+// burnLabel writes text in the top left corner on a dark box
+func burnLabel(img *image.RGBA, text string) {
+	const scale, pad = filmstripLabelSize, 4
+	advance := (mockGlyphW + 1) * scale
+	runes := []rune(text)
+	box := image.Rect(0, 0, len(runes)*advance+2*pad, mockGlyphH*scale+2*pad)
+	draw.Draw(img, box, &image.Uniform{color.RGBA{0, 0, 0, 0xc0}}, image.Point{}, draw.Over)
+	pen := &image.Uniform{color.White}
+	for i, r := range runes {
+		if r == ' ' {
+			continue
+		}
+		glyph := mockGlyph(r)
+		x := pad + i*advance
+		for row := 0; row < mockGlyphH; row++ {
+			for col := 0; col < mockGlyphW; col++ {
+				if glyph[row]&(1<<(mockGlyphW-1-col)) != 0 {
+					dot := image.Rect(x+col*scale, pad+row*scale, x+(col+1)*scale, pad+(row+1)*scale)
+					draw.Draw(img, dot, pen, image.Point{}, draw.Src)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// repeatCount and flakeReport are set by --repeat and --flake-report
+var (
+	repeatCount = 1
+	flakeReport bool
+)
+
+// FlakeReport summarizes repeated runs of one script, step by step
+type FlakeReport struct {
+	Runs     int         `json:"runs"`
+	Passed   int         `json:"passed"`
+	Statuses []string    `json:"statuses"`
+	Steps    []FlakeStep `json:"steps"`
+	Flaky    []int       `json:"flaky"` // step numbers
+}
+
+// FlakeStep is how one step behaved across the runs. A step is flaky when
+// it passed in some runs and not others, or its screenshot differed.
+type FlakeStep struct {
+	Step        int      `json:"step"`
+	Action      string   `json:"action"`
+	Runs        int      `json:"runs"`
+	Passed      int      `json:"passed"`
+	PassRate    float64  `json:"pass_rate"`
+	MeanMs      float64  `json:"mean_ms"`
+	StdDevMs    float64  `json:"stddev_ms"`
+	MinMs       float64  `json:"min_ms"`
+	MaxMs       float64  `json:"max_ms"`
+	Screenshots int      `json:"distinct_screenshots"`
+	Errors      []string `json:"errors,omitempty"`
+	Flaky       bool     `json:"flaky"`
+}
+
+// stepSample is one step of one run
+type stepSample struct {
+	step *StepResult
+	took time.Duration
+}
+
+// executeRepeated runs the script --repeat times and prints every result,
+// or with --flake-report a report of how each step varied
+func executeRepeated(input io.Reader) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading script: %v\n", err)
+		os.Exit(1)
+	}
+	preloadScript(data)
+	var results []ExecutionResult
+	var samples [][]stepSample
+	for i := 0; i < repeatCount; i++ {
+		var run []stepSample
+		result := runScript(bufio.NewScanner(bytes.NewReader(data)), func(step *StepResult, took time.Duration) {
+			run = append(run, stepSample{step, took})
+		})
+		savePortableResult(result)
+		results = append(results, result)
+		samples = append(samples, run)
+	}
+
+	if reportFormat == "md" {
+		for i, result := range results {
+			if i > 0 {
+				fmt.Print("\n---\n\n")
+			}
+			fmt.Printf("**Run %d of %d**\n\n%s", i+1, len(results), markdownReport(result))
+		}
+		return
+	}
+	var out interface{} = results
+	if flakeReport {
+		out = buildFlakeReport(results, samples)
+	}
+	jsonOutput, _ := json.MarshalIndent(out, "", "  ")
+	fmt.Println(string(jsonOutput))
+}
+
+func buildFlakeReport(results []ExecutionResult, samples [][]stepSample) FlakeReport {
+	report := FlakeReport{Runs: len(results), Statuses: []string{}, Steps: []FlakeStep{}, Flaky: []int{}}
+	for _, r := range results {
+		report.Statuses = append(report.Statuses, r.Status)
+		if r.Status != "error" {
+			report.Passed++
+		}
+	}
+
+	type accum struct {
+		FlakeStep
+		times  []float64
+		shots  map[[32]byte]bool
+		errors map[string]bool
+	}
+	steps := map[int]*accum{}
+	for _, run := range samples {
+		for _, s := range run {
+			a := steps[s.step.Step]
+			if a == nil {
+				a = &accum{FlakeStep: FlakeStep{Step: s.step.Step, Action: s.step.Action},
+					shots: map[[32]byte]bool{}, errors: map[string]bool{}}
+				steps[s.step.Step] = a
+			}
+			a.Runs++
+			if s.step.Status != "error" {
+				a.Passed++
+			} else {
+				a.errors[s.step.Error] = true
+			}
+			a.times = append(a.times, float64(s.took)/float64(time.Millisecond))
+			if s.step.Screenshot != nil {
+				if sum, err := screenshotDigest(s.step.Screenshot.File); err == nil {
+					a.shots[sum] = true
+				}
+			}
+		}
+	}
+
+	for _, a := range steps {
+		step := a.FlakeStep
+		step.PassRate = math.Round(float64(step.Passed)/float64(step.Runs)*1000) / 1000
+		step.MeanMs, step.StdDevMs, step.MinMs, step.MaxMs = timingStats(a.times)
+		step.Screenshots = len(a.shots)
+		for msg := range a.errors {
+			step.Errors = append(step.Errors, msg)
+		}
+		sort.Strings(step.Errors)
+		step.Flaky = step.Passed > 0 && step.Passed < step.Runs || step.Screenshots > 1
+		report.Steps = append(report.Steps, step)
+	}
+	sort.Slice(report.Steps, func(i, j int) bool { return report.Steps[i].Step < report.Steps[j].Step })
+	for _, step := range report.Steps {
+		if step.Flaky {
+			report.Flaky = append(report.Flaky, step.Step)
+		}
+	}
+	return report
+}
+
+// screenshotDigest hashes a screenshot's pixels, so identical screens
+// match even if their files were encoded differently
+func screenshotDigest(file string) ([32]byte, error) {
+	img, err := loadPNG(file)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	h := sha256.New()
+	b := img.Bounds()
+	fmt.Fprint(h, b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			h.Write([]byte{byte(r >> 8), byte(g >> 8), byte(bl >> 8), byte(a >> 8)})
+		}
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+func timingStats(ms []float64) (mean, stddev, lo, hi float64) {
+	if len(ms) == 0 {
+		return 0, 0, 0, 0
+	}
+	lo, hi = ms[0], ms[0]
+	for _, v := range ms {
+		mean += v
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	mean /= float64(len(ms))
+	for _, v := range ms {
+		stddev += (v - mean) * (v - mean)
+	}
+	stddev = math.Sqrt(stddev / float64(len(ms)))
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+	return round(mean), round(stddev), round(lo), round(hi)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FramesConfig configures the live screen stream at /frames
+type FramesConfig struct {
+	// Codecs are the codecs offered, in the server's order of preference:
+	// jpeg, webp and zstd-raw (default all three)
+	Codecs []string `json:"codecs"`
+	// FPS is the most frames per second sent (default 2)
+	FPS float64 `json:"fps"`
+	// JPEGLadder is the JPEG qualities stepped down through as bandwidth
+	// runs short, best first (default 85, 70, 50, 30)
+	JPEGLadder []int `json:"jpeg_ladder"`
+	// MaxWidth scales frames down to at most this width; 0 keeps them
+	MaxWidth int `json:"max_width"`
+}
+
+// frameCodecs are the codecs /frames can encode with:
+//
+//	jpeg     - lossy, quality adapted along the ladder to the link
+//	webp     - lossless VP8L
+//	zstd-raw - RGBA pixels, after the first frame XORed with the previous
+//	           one, compressed with zstd
+var frameCodecs = []string{"jpeg", "webp", "zstd-raw"}
+
+var (
+	defaultFrameFPS    = 2.0
+	defaultJPEGLadder  = []int{85, 70, 50, 30}
+	frameLadderSamples = 3 // frames in a row over or under budget before a step
+)
+
+// FrameHeader is the text message sent before each binary frame
+type FrameHeader struct {
+	Seq     int    `json:"seq"`
+	Codec   string `json:"codec"`
+	Quality int    `json:"quality,omitempty"` // jpeg only
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	// Delta frames (zstd-raw) are XORed with the previous frame
+	Delta bool  `json:"delta,omitempty"`
+	Bytes int   `json:"bytes"`
+	Time  int64 `json:"time_ms"` // Unix time of the capture
+}
+
+// frameStream is what one client negotiated
+type frameStream struct {
+	codec    string
+	interval time.Duration
+	ladder   []int
+	level    int // index into ladder
+	budget   int // bytes per frame the client can take, 0 for no limit
+	width    int // max width, 0 to keep
+	over     int // consecutive frames over budget
+	under    int // consecutive frames well under budget
+	prev     *image.RGBA
+}
+
+// negotiateFrames picks the stream for a client from its query:
+// codecs=webp,jpeg in its order of preference, fps, kbps for the bandwidth
+// it can take and width for the largest frame it wants
+func negotiateFrames(cfg FramesConfig, q map[string][]string) (*frameStream, error) {
+	offered := cfg.Codecs
+	if len(offered) == 0 {
+		offered = frameCodecs
+	}
+	for _, c := range offered {
+		if !contains(frameCodecs, c) {
+			return nil, fmt.Errorf("serve.frames.codecs: unknown codec %q", c)
+		}
+	}
+	s := &frameStream{ladder: cfg.JPEGLadder, width: cfg.MaxWidth}
+	if len(s.ladder) == 0 {
+		s.ladder = defaultJPEGLadder
+	}
+	wanted := offered
+	if v := first(q["codecs"]); v != "" {
+		wanted = strings.Split(v, ",")
+	}
+	for _, c := range wanted {
+		if c = strings.TrimSpace(c); contains(offered, c) {
+			s.codec = c
+			break
+		}
+	}
+	if s.codec == "" {
+		return nil, fmt.Errorf("no common codec; the server offers %s", strings.Join(offered, ", "))
+	}
+
+	fps := cfg.FPS
+	if fps <= 0 {
+		fps = defaultFrameFPS
+	}
+	if v := first(q["fps"]); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			return nil, fmt.Errorf("invalid fps %q", v)
+		}
+		fps = min(fps, f)
+	}
+	s.interval = time.Duration(float64(time.Second) / fps)
+	if v := first(q["kbps"]); v != "" {
+		kbps, err := strconv.Atoi(v)
+		if err != nil || kbps <= 0 {
+			return nil, fmt.Errorf("invalid kbps %q", v)
+		}
+		s.budget = int(float64(kbps) * 1000 / 8 / fps)
+	}
+	if v := first(q["width"]); v != "" {
+		w, err := strconv.Atoi(v)
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("invalid width %q", v)
+		}
+		if s.width == 0 || w < s.width {
+			s.width = w
+		}
+	}
+	return s, nil
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// encode returns the payload for img, or nil when it shows nothing new
+func (s *frameStream) encode(img *image.RGBA) (FrameHeader, []byte, error) {
+	b := img.Bounds()
+	h := FrameHeader{Codec: s.codec, Width: b.Dx(), Height: b.Dy(), Time: time.Now().UnixMilli()}
+	if s.prev != nil && sameImage(s.prev, img) {
+		return h, nil, nil
+	}
+	var payload []byte
+	switch s.codec {
+	case "jpeg":
+		var buf bytes.Buffer
+		h.Quality = s.ladder[s.level]
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: h.Quality}); err != nil {
+			return h, nil, err
+		}
+		payload = buf.Bytes()
+	case "webp":
+		payload = encodeWebP(img)
+	case "zstd-raw":
+		pix := img.Pix
+		if s.prev != nil && s.prev.Bounds() == b {
+			pix = make([]byte, len(img.Pix))
+			for i := range pix {
+				pix[i] = img.Pix[i] ^ s.prev.Pix[i]
+			}
+			h.Delta = true
+		}
+		payload = zstdCompress(pix)
+	}
+	h.Bytes = len(payload)
+	return h, payload, nil
+}
+
+// adapt moves along the JPEG ladder after a frame of n bytes took took to
+// send: down when frames keep going over the budget or outlast the frame
+// interval, up when they keep fitting with room to spare
+func (s *frameStream) adapt(n int, took time.Duration) {
+	if s.codec != "jpeg" {
+		return
+	}
+	over := took > s.interval || s.budget > 0 && n > s.budget
+	under := took < s.interval/4 && (s.budget == 0 || 2*n < s.budget)
+	switch {
+	case over:
+		s.over, s.under = s.over+1, 0
+	case under:
+		s.over, s.under = 0, s.under+1
+	default:
+		s.over, s.under = 0, 0
+	}
+	if s.over >= frameLadderSamples && s.level < len(s.ladder)-1 {
+		s.level, s.over = s.level+1, 0
+	}
+	if s.under >= frameLadderSamples && s.level > 0 {
+		s.level, s.under = s.level-1, 0
+	}
+}
+
+// handleFrames streams the screen to a watching client. It takes no part
+// in runs, so a supervisor can watch one live. The first text message
+// describes the negotiated stream; then every changed frame is a text
+// FrameHeader followed by a binary message with the encoded frame.
+func (s *server) handleFrames(w http.ResponseWriter, r *http.Request) {
+	cfg, err := currentConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stream, err := negotiateFrames(cfg.Serve.Frames, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	// The client only ever closes the stream
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.readMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	hello, _ := json.Marshal(map[string]interface{}{
+		"codec": stream.codec, "fps": float64(time.Second) / float64(stream.interval),
+		"ladder": stream.ladder, "budget_bytes": stream.budget,
+	})
+	if ws.writeText(hello) != nil {
+		return
+	}
+	ticker := time.NewTicker(stream.interval)
+	defer ticker.Stop()
+	for seq := 1; ; {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+		frame, err := captureFrame(stream.width)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: frames: %v\n", err)
+			continue
+		}
+		header, payload, err := stream.encode(frame)
+		if err != nil || payload == nil {
+			releaseFrame(frame)
+			continue
+		}
+		header.Seq = seq
+		data, _ := json.Marshal(header)
+		start := time.Now()
+		if ws.writeText(data) != nil || ws.writeFrame(wsBinary, payload) != nil {
+			releaseFrame(frame)
+			return
+		}
+		stream.adapt(len(payload), time.Since(start))
+		if stream.prev != nil {
+			releaseFrame(stream.prev)
+		}
+		stream.prev = frame
+		seq++
+	}
+}
+
+// captureFrame grabs the screen as RGBA, scaled to at most width,
+// redacted and masked as stored screenshots are. The cursor is left
+// visible for whoever is watching.
+func captureFrame(width int) (*image.RGBA, error) {
+	img, err := baseBackend().Capture()
+	if err != nil {
+		return nil, err
+	}
+	// Redacted at full size, where OCR reads best and the mask fits
+	if redacted, err := redactFrame(img); err != nil {
+		releaseFrame(img)
+		return nil, fmt.Errorf("redaction failed: %v", err)
+	} else if redacted != img {
+		releaseFrame(img)
+		img = redacted
+	}
+	b := img.Bounds()
+	if width > 0 && b.Dx() > width {
+		defer releaseFrame(img)
+		return scaleDown(img, width), nil
+	}
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba, nil
+	}
+	if frame, ok := img.(*mockFrame); ok {
+		return frame.RGBA, nil
+	}
+	defer releaseFrame(img)
+	out := newFrame(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+	return out, nil
+}
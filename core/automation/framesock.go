@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// localPerception talks to an OCR or vision service on the same host over
+// a unix socket. Frames are not encoded: the raw pixels are written once to
+// shared memory and the file descriptor travels with the request, so the
+// service maps them instead of decoding a base64 PNG. One request per
+// connection:
+//
+//	-> {"op": "ocr"|"locate", "width": W, "height": H, "stride": S,
+//	    "format": "rgba", "path": P, ...} + the frame's descriptor
+//	<- {"words": [...]} for ocr, {"x": X, "y": Y} or {"box": [...]} for
+//	   locate, or {"error": "..."}
+//
+// Services that cannot receive descriptors may open path instead; the file
+// exists until the response is read.
+type localPerception struct {
+	socket  string
+	timeout time.Duration
+	private bool // the socket is the default, in a directory only we may use
+}
+
+func newLocalPerception(socket string, timeoutSec float64) localPerception {
+	private := false
+	if socket == "" {
+		socket, private = defaultPerceptionSocket(), true
+	}
+	timeout := 30 * time.Second
+	if timeoutSec > 0 {
+		timeout = time.Duration(timeoutSec * float64(time.Second))
+	}
+	return localPerception{socket: socket, timeout: timeout, private: private}
+}
+
+// defaultPerceptionSocket is where co-located services listen by default.
+// Outside XDG_RUNTIME_DIR the directory is shared with other users, so
+// frames are only sent to it while it is ours alone.
+func defaultPerceptionSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "agentos", "perception.sock")
+}
+
+// call shares img, sends the request and decodes the response into out
+func (p localPerception) call(img image.Image, req map[string]interface{}, out interface{}) error {
+	if p.private {
+		if err := checkPrivateDir(filepath.Dir(p.socket)); err != nil {
+			return err
+		}
+	}
+	frame, stride, err := shareFrame(img)
+	if err != nil {
+		return fmt.Errorf("sharing frame: %v", err)
+	}
+	defer func() {
+		frame.Close()
+		os.Remove(frame.Name())
+	}()
+	b := img.Bounds()
+	req["width"], req["height"], req["stride"] = b.Dx(), b.Dy(), stride
+	req["format"], req["path"] = "rgba", frame.Name()
+	header, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("unix", p.socket, p.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	if err := sendWithFile(conn.(*net.UnixConn), append(header, '\n'), frame); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return fmt.Errorf("reading response: %v", err)
+	}
+	var failure struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(line, &failure); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	if failure.Error != "" {
+		return fmt.Errorf("%s", failure.Error)
+	}
+	return json.Unmarshal(line, out)
+}
+
+// shareFrame writes the pixels of img as RGBA rows to a file in shared
+// memory (/dev/shm where there is one) and returns it with its row stride
+func shareFrame(img image.Image) (*os.File, int, error) {
+	dir := "/dev/shm"
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = os.TempDir()
+	}
+	file, err := os.CreateTemp(dir, "agentos-frame-")
+	if err != nil {
+		return nil, 0, err
+	}
+	b := img.Bounds()
+	stride := 4 * b.Dx()
+	var rgba *image.RGBA
+	switch img := img.(type) {
+	case *image.RGBA:
+		rgba = img
+	case *mockFrame:
+		rgba = img.RGBA
+	default:
+		rgba = newFrame(b)
+		draw.Draw(rgba, b, img, b.Min, draw.Src)
+		defer releaseFrame(rgba)
+	}
+	if rgba.Stride == stride {
+		start := rgba.PixOffset(b.Min.X, b.Min.Y)
+		_, err = file.Write(rgba.Pix[start : start+stride*b.Dy()])
+	} else {
+		// A sub-image: write its rows out of the parent's
+		for y := b.Min.Y; y < b.Max.Y && err == nil; y++ {
+			start := rgba.PixOffset(b.Min.X, y)
+			_, err = file.Write(rgba.Pix[start : start+stride])
+		}
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, 0, err
+	}
+	return file, stride, nil
+}
+
+// localOCR is an OCR service reached through localPerception
+type localOCR struct {
+	language string
+	peer     localPerception
+}
+
+func (l *localOCR) Name() string { return "local" }
+
+func (l *localOCR) Recognize(img image.Image) ([]OCRWord, error) {
+	var resp struct {
+		Words []OCRWord `json:"words"`
+	}
+	req := map[string]interface{}{"op": "ocr"}
+	if l.language != "" {
+		req["language"] = l.language
+	}
+	if err := l.peer.call(img, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Words, nil
+}
+
+// localGrounding is a vision model reached through localPerception
+type localGrounding struct {
+	config GroundingConfig
+	peer   localPerception
+}
+
+func (g *localGrounding) Name() string { return "local" }
+
+func (g *localGrounding) Locate(img image.Image, description string) (GroundingResult, error) {
+	var resp groundingAnswer
+	req := map[string]interface{}{"op": "locate", "description": description}
+	if err := g.peer.call(img, req, &resp); err != nil {
+		return GroundingResult{}, err
+	}
+	return resp.result(g.config.Coordinates, img.Bounds())
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// sendWithFile writes msg with the descriptor of file attached
+func sendWithFile(conn *net.UnixConn, msg []byte, file *os.File) error {
+	_, _, err := conn.WriteMsgUnix(msg, syscall.UnixRights(int(file.Fd())), nil)
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import (
+	"net"
+	"os"
+)
+
+// sendWithFile sends msg alone: Windows cannot pass descriptors, so the
+// service opens the frame by its path
+func sendWithFile(conn *net.UnixConn, msg []byte, file *os.File) error {
+	_, err := conn.Write(msg)
+	return err
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// GroundingResult is where a vision model located a described element
+type GroundingResult struct {
+	X          int     `json:"x"`
+	Y          int     `json:"y"`
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// GroundingProvider answers "where is <description>?" for a screenshot
+type GroundingProvider interface {
+	Name() string
+	Locate(img image.Image, description string) (GroundingResult, error)
+}
+
+// GroundingConfig selects and configures the grounding provider
+type GroundingConfig struct {
+	Provider string `json:"provider"` // http (default), openai or local
+	Endpoint string `json:"endpoint"` // URL, or the socket path for local
+	APIKey   string `json:"api_key"`  // or "env:NAME"
+	Model    string `json:"model"`
+	// Coordinates is the space the model answers in: pixels (default),
+	// normalized (0..1) or normalized_1000 (0..1000, e.g. Qwen-VL)
+	Coordinates string  `json:"coordinates"`
+	TimeoutSec  float64 `json:"timeout_seconds"`
+}
+
+var groundingProviders = map[string]func(GroundingConfig) GroundingProvider{
+	"http": func(c GroundingConfig) GroundingProvider {
+		return &httpGrounding{config: c, client: groundingHTTPClient(c)}
+	},
+	"openai": func(c GroundingConfig) GroundingProvider {
+		return &openAIGrounding{config: c, client: groundingHTTPClient(c)}
+	},
+	"local": func(c GroundingConfig) GroundingProvider {
+		return &localGrounding{config: c, peer: newLocalPerception(c.Endpoint, c.TimeoutSec)}
+	},
+}
+
+func groundingHTTPClient(c GroundingConfig) *http.Client {
+	timeout := 60 * time.Second
+	if c.TimeoutSec > 0 {
+		timeout = time.Duration(c.TimeoutSec * float64(time.Second))
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// currentGrounding returns the configured grounding provider
+func currentGrounding() (GroundingProvider, error) {
+	cfg, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	name := cfg.Grounding.Provider
+	if name == "" {
+		name = "http"
+	}
+	if cfg.Grounding.Endpoint == "" && name != "local" {
+		return nil, fmt.Errorf("no grounding endpoint configured")
+	}
+	factory, ok := groundingProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown grounding provider: %s", name)
+	}
+	return factory(cfg.Grounding), nil
+}
+
+// locateDescribed captures the screen and asks the grounding model for the
+// described element
+func locateDescribed(description string) (GroundingResult, error) {
+	provider, err := currentGrounding()
+	if err != nil {
+		return GroundingResult{}, err
+	}
+	screen, err := captureScreen()
+	if err != nil {
+		return GroundingResult{}, err
+	}
+	defer releaseFrame(screen)
+	result, err := provider.Locate(screen, description)
+	if err != nil {
+		return GroundingResult{}, fmt.Errorf("%s grounding: %v", provider.Name(), err)
+	}
+	b := screen.Bounds()
+	if result.X < 0 || result.Y < 0 || result.X >= b.Dx() || result.Y >= b.Dy() {
+		return GroundingResult{}, fmt.Errorf("%s grounding: %q located off screen at %d,%d", provider.Name(), description, result.X, result.Y)
+	}
+	return result, nil
+}
+
+// toPixels converts a model answer to pixel coordinates
+func toPixels(x, y float64, space string, bounds image.Rectangle) (int, int) {
+	switch space {
+	case "normalized":
+		x, y = x*float64(bounds.Dx()), y*float64(bounds.Dy())
+	case "normalized_1000":
+		x, y = x*float64(bounds.Dx())/1000, y*float64(bounds.Dy())/1000
+	}
+	return int(x + 0.5), int(y + 0.5)
+}
+
+func pngDataBase64(img image.Image) (string, error) {
+	data, err := encodePNG(img)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// httpGrounding speaks a minimal JSON contract:
+//
+//	POST {"image": base64 PNG, "description": "...", "width": W, "height": H}
+//	  -> {"x": X, "y": Y, "confidence": C} or {"box": [x1, y1, x2, y2]}
+type httpGrounding struct {
+	config GroundingConfig
+	client *http.Client
+}
+
+func (g *httpGrounding) Name() string { return "http" }
+
+func (g *httpGrounding) Locate(img image.Image, description string) (GroundingResult, error) {
+	encoded, err := pngDataBase64(img)
+	if err != nil {
+		return GroundingResult{}, err
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"image":       encoded,
+		"description": description,
+		"width":       img.Bounds().Dx(),
+		"height":      img.Bounds().Dy(),
+	})
+	var resp groundingAnswer
+	req, err := http.NewRequest("POST", g.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return GroundingResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := secretValue(g.config.APIKey); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	if err := doJSON(g.client, req, &resp); err != nil {
+		return GroundingResult{}, err
+	}
+	return resp.result(g.config.Coordinates, img.Bounds())
+}
+
+// groundingAnswer is the reply of the http and local contracts
+type groundingAnswer struct {
+	X          *float64  `json:"x"`
+	Y          *float64  `json:"y"`
+	Box        []float64 `json:"box"`
+	Confidence float64   `json:"confidence"`
+	Error      string    `json:"error"`
+}
+
+func (a groundingAnswer) result(space string, bounds image.Rectangle) (GroundingResult, error) {
+	if a.Error != "" {
+		return GroundingResult{}, fmt.Errorf("%s", a.Error)
+	}
+	var x, y float64
+	switch {
+	case a.X != nil && a.Y != nil:
+		x, y = *a.X, *a.Y
+	case len(a.Box) == 4:
+		x, y = (a.Box[0]+a.Box[2])/2, (a.Box[1]+a.Box[3])/2
+	default:
+		return GroundingResult{}, fmt.Errorf("response has neither x/y nor box")
+	}
+	px, py := toPixels(x, y, space, bounds)
+	return GroundingResult{X: px, Y: py, Confidence: a.Confidence}, nil
+}
+
+// openAIGrounding asks any OpenAI-compatible chat completions endpoint that
+// accepts images (hosted APIs, vLLM, Ollama) to answer with coordinates
+type openAIGrounding struct {
+	config GroundingConfig
+	client *http.Client
+}
+
+func (g *openAIGrounding) Name() string { return "openai" }
+
+var groundingNumbersRe = regexp.MustCompile(`-?\d+(?:\.\d+)?`)
+
+func (g *openAIGrounding) Locate(img image.Image, description string) (GroundingResult, error) {
+	encoded, err := pngDataBase64(img)
+	if err != nil {
+		return GroundingResult{}, err
+	}
+	b := img.Bounds()
+	prompt := fmt.Sprintf("The screenshot is %dx%d pixels. Locate: %s\n"+
+		`Reply with only JSON {"x": <x>, "y": <y>} giving the center of the element.`, b.Dx(), b.Dy(), description)
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":       g.config.Model,
+		"temperature": 0,
+		"messages": []interface{}{map[string]interface{}{
+			"role": "user",
+			"content": []interface{}{
+				map[string]interface{}{"type": "text", "text": prompt},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]string{"url": "data:image/png;base64," + encoded}},
+			},
+		}},
+	})
+	req, err := http.NewRequest("POST", g.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return GroundingResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := secretValue(g.config.APIKey); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := doJSON(g.client, req, &resp); err != nil {
+		return GroundingResult{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return GroundingResult{}, fmt.Errorf("empty response")
+	}
+	answer := resp.Choices[0].Message.Content
+	var point struct{ X, Y *float64 }
+	if start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}"); start >= 0 && end > start {
+		json.Unmarshal([]byte(answer[start:end+1]), &point)
+	}
+	if point.X == nil || point.Y == nil {
+		// Some grounding models answer "(512, 300)" or "<point>512 300</point>"
+		nums := groundingNumbersRe.FindAllString(answer, 2)
+		if len(nums) < 2 {
+			return GroundingResult{}, fmt.Errorf("could not read coordinates from %q", answer)
+		}
+		var x, y float64
+		fmt.Sscan(nums[0], &x)
+		fmt.Sscan(nums[1], &y)
+		point.X, point.Y = &x, &y
+	}
+	px, py := toPixels(*point.X, *point.Y, g.config.Coordinates, b)
+	return GroundingResult{X: px, Y: py}, nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// serve --grpc ADDR also serves the Executor service of
+// proto/executor.proto, over HTTP/2 without TLS as gRPC clients speak it
+// with insecure credentials. Session streams script lines in and step
+// results out, one message at a time, so HTTP/2 flow control holds back a
+// client that sends faster than steps run, and a slow reader holds back
+// the steps. Server reflection (v1 and v1alpha) lets grpcurl and similar
+// tools describe the service. Messages are not compressed. Execute and
+// Session need the serve token as "authorization: Bearer TOKEN" metadata.
+
+// grpcMaxMessage is the largest message accepted, gRPC's usual default
+const grpcMaxMessage = 4 << 20
+
+// gRPC status codes
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcAlreadyExists     = 6
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// grpcError ends a call with a status
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e grpcError) Error() string { return e.msg }
+
+// grpcStream is one call: length-prefixed messages each way, the status
+// in the trailers
+type grpcStream struct {
+	w http.ResponseWriter
+	r *http.Request
+}
+
+// recv reads the next message; io.EOF when the client has sent all
+func (g *grpcStream) recv() ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(g.r.Body, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, grpcError{grpcInvalidArgument, "truncated message"}
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, grpcError{grpcResourceExhausted, fmt.Sprintf("message of %d bytes is over the %d byte limit", size, grpcMaxMessage)}
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(g.r.Body, msg); err != nil {
+		return nil, grpcError{grpcInvalidArgument, "truncated message"}
+	}
+	return msg, nil
+}
+
+func (g *grpcStream) send(msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := g.w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	http.NewResponseController(g.w).Flush()
+	return nil
+}
+
+// finish sends the call's status as trailers
+func (g *grpcStream) finish(err error) {
+	code, msg := grpcOK, ""
+	if err != nil {
+		code, msg = grpcInternal, err.Error()
+		if e, ok := err.(grpcError); ok {
+			code = e.code
+		}
+	}
+	g.w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		// grpc-message is percent-encoded UTF-8
+		g.w.Header().Set(http.TrailerPrefix+"Grpc-Message", strings.ReplaceAll(url.PathEscape(msg), "+", "%2B"))
+	}
+}
+
+// serveGRPC listens for gRPC calls until the process exits
+func (s *server) serveGRPC(addr string) {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(s.handleGRPC), Protocols: &protocols}
+	fmt.Fprintf(os.Stderr, "Serving gRPC on %s\n", addr)
+	if err := srv.ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: gRPC: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func (s *server) handleGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	g := &grpcStream{w: w, r: r}
+	service := "/" + executorProtoPackage + "." + executorService + "/"
+	var err error
+	// The services run scripts, so they take the bearer token /execute does
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if strings.HasPrefix(r.URL.Path, service) && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		g.finish(grpcError{grpcUnauthenticated, "missing or wrong bearer token in authorization metadata"})
+		return
+	}
+	switch r.URL.Path {
+	case service + "Execute":
+		err = s.grpcExecute(g)
+	case service + "Session":
+		err = s.grpcSession(g)
+	case "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo":
+		err = grpcReflection(g)
+	default:
+		err = grpcError{grpcUnimplemented, "unknown method " + r.URL.Path}
+	}
+	g.finish(err)
+}
+
+// grpcExecute runs Execute: one script in, its result out. Like /execute,
+// the run gets a request ID, from x-request-id metadata or generated, and
+// can be fetched from /status/ID.
+func (s *server) grpcExecute(g *grpcStream) error {
+	msg, err := g.recv()
+	if err == io.EOF {
+		return grpcError{grpcInvalidArgument, "no request"}
+	}
+	if err != nil {
+		return err
+	}
+	fields, err := pbDecode(msg)
+	if err != nil {
+		return grpcError{grpcInvalidArgument, err.Error()}
+	}
+	var script []byte
+	var format string
+	for _, f := range fields {
+		switch {
+		case f.field == 1 && f.wire == pbBytes:
+			script = f.data
+		case f.field == 2 && f.wire == pbBytes && len(f.data) > 0:
+			format = string(f.data)
+		}
+	}
+	if script, err = scriptLines(format, script); err != nil {
+		return grpcError{grpcInvalidArgument, err.Error()}
+	}
+
+	run, code, reason := s.newRun(g.r)
+	if run == nil {
+		if code == http.StatusConflict {
+			return grpcError{grpcAlreadyExists, reason}
+		}
+		return grpcError{grpcInvalidArgument, reason}
+	}
+	g.w.Header().Set("X-Request-Id", run.ID)
+	s.execute(run, script)
+	return g.send(pbExecutionResult(*s.snapshot(run).Result))
+}
+
+// grpcSession runs Session, the gRPC form of /ws: each CommandRequest holds
+// script lines and every step is answered with a StepResult as it
+// finishes. The session ends when the client closes its side or the run
+// aborts.
+func (s *server) grpcSession(g *grpcStream) error {
+	if !s.runMu.TryLock() {
+		return grpcError{grpcUnavailable, "another session is running"}
+	}
+	defer s.runMu.Unlock()
+	s.session.Store(true)
+	defer s.session.Store(false)
+
+	run := newRunner()
+	defer func() {
+		run.close()
+		savePortableResult(run.result)
+	}()
+	// Headers go out now, so the client knows the session started
+	g.w.WriteHeader(http.StatusOK)
+	http.NewResponseController(g.w).Flush()
+	for {
+		msg, err := g.recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fields, err := pbDecode(msg)
+		if err != nil {
+			return grpcError{grpcInvalidArgument, err.Error()}
+		}
+		var lines []byte
+		for _, f := range fields {
+			if f.field == 1 && f.wire == pbBytes {
+				lines = f.data
+			}
+		}
+		ended, err := runSessionMessage(run, lines, func(steps []*StepResult) error {
+			for _, step := range steps {
+				s.linkStep(step)
+				if err := g.send(pbStepResult(step)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if ended || err != nil {
+			return err
+		}
+	}
+}
+
+// grpcReflection answers ServerReflectionInfo for executor.proto. Only the
+// Executor service is listed.
+func grpcReflection(g *grpcStream) error {
+	for {
+		msg, err := g.recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fields, err := pbDecode(msg)
+		if err != nil {
+			return grpcError{grpcInvalidArgument, err.Error()}
+		}
+		if err := g.send(reflectionResponse(msg, fields)); err != nil {
+			return err
+		}
+	}
+}
+
+// reflectionResponse is the ServerReflectionResponse to a request
+func reflectionResponse(msg []byte, fields []pbValue) []byte {
+	var resp pbBuf
+	for _, f := range fields {
+		if f.field == 1 && f.wire == pbBytes {
+			resp.string(1, string(f.data)) // valid_host
+		}
+	}
+	resp.bytes(2, msg) // original_request
+	symbols := executorSymbols()
+	for _, f := range fields {
+		if f.wire != pbBytes {
+			continue
+		}
+		arg := string(f.data)
+		found := false
+		switch f.field {
+		case 3: // file_by_filename
+			found = arg == executorProtoFile
+		case 4: // file_containing_symbol
+			found = symbols[arg]
+		case 5: // file_containing_extension; the file has no extensions
+		case 6: // all_extension_numbers_of_type
+			if symbols[arg] {
+				var ext pbBuf
+				ext.string(1, arg)
+				resp.bytes(5, ext)
+				return resp
+			}
+		case 7: // list_services
+			var service, list pbBuf
+			service.string(1, executorProtoPackage+"."+executorService)
+			list.bytes(1, service)
+			resp.bytes(6, list)
+			return resp
+		default:
+			continue
+		}
+		if found {
+			var files pbBuf
+			files.bytes(1, executorFileDescriptor())
+			resp.bytes(4, files)
+		} else {
+			resp.bytes(7, reflectionError(grpcNotFound, arg+" not found"))
+		}
+		return resp
+	}
+	resp.bytes(7, reflectionError(grpcInvalidArgument, "unsupported reflection request"))
+	return resp
+}
+
+func reflectionError(code int, msg string) []byte {
+	var e pbBuf
+	e.int(1, code)
+	e.string(2, msg)
+	return e
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With --control a person can take a run over mid-script and give it back.
+// The run listens on a Unix socket for a request per line:
+//
+//	take_over        pause before the next step and hand over control
+//	give_back        resume from that step
+//	give_back force  resume even if the screen is not as it was left
+//	status           who has control
+//
+//	echo take_over | nc -U /tmp/run.sock
+//
+// take_over answers once the step under way has finished: held keys are
+// released, the lock keys put back as the user had them, and the handover
+// announced on stderr, as a handed_over event and to notifiers that want
+// handoff messages. give_back first checks that the active window and the
+// screen are what they were at the handover; if not, it answers with what
+// changed and the run stays paused, for the person to put things back or
+// give it back with force. Each answer is a JSON line.
+
+// controlPath is set by --control
+var controlPath string
+
+// handoffSimilarity is the least screen similarity give_back accepts
+const handoffSimilarity = 0.95
+
+// handoff is the state of a run's control socket. Requests arrive on the
+// socket's goroutines; the runner hands over and takes back on its own.
+type handoff struct {
+	mu    sync.Mutex
+	state string // running, requested or human
+	step  int    // the step the run is paused before
+	// taken is closed once a requested handover is done
+	taken chan struct{}
+	give  chan giveBack
+}
+
+// giveBack is a give_back request for the paused runner
+type giveBack struct {
+	force bool
+	reply chan error
+}
+
+// handoffReply answers a control request
+type handoffReply struct {
+	State   string `json:"state,omitempty"`
+	Step    int    `json:"step,omitempty"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handoffScene is what give_back compares: the active window and the
+// screen when control was handed over
+type handoffScene struct {
+	window string // the active window's title; empty if unknown
+	screen image.Image
+}
+
+// startControl listens on --control, aborting the run if it cannot, since
+// nobody could take it over
+func (r *runner) startControl() {
+	if controlPath == "" {
+		return
+	}
+	ln, err := listenUnix(controlPath)
+	if err != nil {
+		r.result.Errors = append(r.result.Errors, fmt.Sprintf("--control: %v", err))
+		r.abort("no control socket")
+		return
+	}
+	h := &handoff{state: "running", give: make(chan giveBack)}
+	r.handoff = h
+	r.background.start("control", func(ctx context.Context) error {
+		defer context.AfterFunc(ctx, func() { ln.Close() })()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			go h.serve(ctx, conn)
+		}
+	})
+}
+
+// serve answers a control connection's requests until it closes or the
+// run ends
+func (h *handoff) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	// Reading stops when the run ends; a waiting take_over still answers
+	defer context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })()
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var reply handoffReply
+		switch fields := strings.Fields(scanner.Text()); {
+		case len(fields) == 0:
+			continue
+		case len(fields) == 1 && fields[0] == "take_over":
+			reply = h.takeOver(ctx)
+		case fields[0] == "give_back" && (len(fields) == 1 || len(fields) == 2 && fields[1] == "force"):
+			reply = h.giveBack(ctx, len(fields) == 2)
+		case len(fields) == 1 && fields[0] == "status":
+			h.mu.Lock()
+			reply = handoffReply{State: h.state, Step: h.step}
+			h.mu.Unlock()
+		default:
+			reply.Error = fmt.Sprintf("unknown request %q: want take_over, give_back, give_back force or status", scanner.Text())
+		}
+		if enc.Encode(reply) != nil {
+			return
+		}
+	}
+}
+
+// takeOver asks the runner to hand over and waits until it has
+func (h *handoff) takeOver(ctx context.Context) handoffReply {
+	h.mu.Lock()
+	switch h.state {
+	case "human":
+		h.mu.Unlock()
+		return handoffReply{State: "human", Step: h.step, Error: "a human already has control"}
+	case "running":
+		h.state, h.taken = "requested", make(chan struct{})
+	}
+	taken := h.taken
+	h.mu.Unlock()
+	select {
+	case <-taken:
+	case <-ctx.Done():
+		return handoffReply{Error: "the run ended"}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return handoffReply{State: h.state, Step: h.step,
+		Message: fmt.Sprintf("human has control; the run is paused before step %d", h.step)}
+}
+
+// giveBack passes a give_back to the paused runner and waits for its
+// answer
+func (h *handoff) giveBack(ctx context.Context, force bool) handoffReply {
+	h.mu.Lock()
+	state, step := h.state, h.step
+	h.mu.Unlock()
+	if state != "human" {
+		return handoffReply{State: state, Error: "the run has control"}
+	}
+	req := giveBack{force: force, reply: make(chan error, 1)}
+	select {
+	case h.give <- req:
+	case <-ctx.Done():
+		return handoffReply{Error: "the run ended"}
+	}
+	if err := <-req.reply; err != nil {
+		return handoffReply{State: "human", Step: step, Error: err.Error()}
+	}
+	return handoffReply{State: "running", Step: step, Message: fmt.Sprintf("resuming at step %d", step)}
+}
+
+// handOver pauses the run before step while a person has control, if one
+// asked for it, returning the handover's events
+func (r *runner) handOver(step *StepResult) []Event {
+	h := r.handoff
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	requested := h.state == "requested"
+	h.mu.Unlock()
+	if !requested {
+		return nil
+	}
+	start := clock.Now()
+	r.releaseHeldKeys(step, "a human took over")
+	r.restoreLockKeys()
+	before := readHandoffScene()
+	msg := fmt.Sprintf("human has control before step %d", step.Step)
+	fmt.Fprintf(os.Stderr, "Warning: %s; give it back with: echo give_back | nc -U %s\n", msg, controlPath)
+	events := []Event{{Step: step.Step, Type: "handed_over", Message: msg}}
+	r.notify(notifyMessage{kind: "handoff", text: fmt.Sprintf("🙋 %s: %s", runName(), msg)})
+	h.mu.Lock()
+	h.state, h.step = "human", step.Step
+	close(h.taken)
+	h.mu.Unlock()
+
+	// The screen is compared with the capture taken here, so it is kept
+	// until the run resumes
+	var forced []string
+	for req := range h.give {
+		changed := before.changes(readHandoffScene())
+		if len(changed) > 0 && !req.force {
+			req.reply <- fmt.Errorf("not resuming: %s (put it back or give_back force)", strings.Join(changed, "; "))
+			continue
+		}
+		forced = changed
+		h.mu.Lock()
+		h.state = "running"
+		h.mu.Unlock()
+		req.reply <- nil
+		break
+	}
+	if before.screen != nil {
+		releaseFrame(before.screen)
+	}
+	r.holdLockKeys()
+	msg = fmt.Sprintf("resumed at step %d after %.1fs under human control", step.Step, since(start).Seconds())
+	if len(forced) > 0 {
+		msg += "; forced past: " + strings.Join(forced, "; ")
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", msg)
+	r.notify(notifyMessage{kind: "handoff", text: fmt.Sprintf("▶️ %s: %s", runName(), msg)})
+	return append(events, Event{Step: step.Step, Type: "taken_back", Message: msg})
+}
+
+// readHandoffScene reads the active window and the screen, leaving out
+// whichever the backend cannot tell
+func readHandoffScene() handoffScene {
+	var scene handoffScene
+	scene.window, _ = activeWindowTitle()
+	if screen, err := captureScreen(); err == nil {
+		scene.screen = screen
+	}
+	return scene
+}
+
+// changes lists how now differs from the scene at the handover, releasing
+// now's capture
+func (s handoffScene) changes(now handoffScene) []string {
+	var changed []string
+	if s.window != now.window {
+		changed = append(changed, fmt.Sprintf("the active window is %q, was %q", now.window, s.window))
+	}
+	if s.screen != nil && now.screen != nil {
+		score, err := compareImages(now.screen, s.screen)
+		switch {
+		case err != nil:
+			changed = append(changed, fmt.Sprintf("the screen changed: %v", err))
+		case score < handoffSimilarity:
+			changed = append(changed, fmt.Sprintf("the screen differs from the handover (similarity %.3f < %.3f)", score, handoffSimilarity))
+		}
+	}
+	if now.screen != nil {
+		releaseFrame(now.screen)
+	}
+	return changed
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --headless runs each run on an X server of its own, started for it and
+// stopped when it ends, failed or not, so CI needs no wrapper script to
+// manage one:
+//
+//	executor --headless 1920x1080 script.gcode
+//	executor daemon --headless 1280x800x24 --headless-server xephyr
+//
+// The server picks a free display number itself. Xvfb has no window;
+// Xephyr shows the screen in a window on the current display, to watch a
+// run. The daemon starts one per job, so jobs never see each other's
+// windows. Programs the run launches get the server's DISPLAY, and an
+// x11 or xdotool backend drives it.
+//
+// As with xvfb-run, the server only lets in clients that have its cookie,
+// a fresh one written with xauth to a file only this user can read, and
+// the run's XAUTHORITY points at that file while the server is up.
+
+// headlessSize and headlessServer are set by --headless and
+// --headless-server
+var (
+	headlessSize   string
+	headlessServer = "xvfb"
+)
+
+var headlessServers = map[string]string{"xvfb": "Xvfb", "xephyr": "Xephyr"}
+
+// headlessStart is how long a server gets to start listening
+const headlessStart = 10 * time.Second
+
+var headlessSizePattern = regexp.MustCompile(`^(\d+)x(\d+)(?:x(8|15|16|24|30))?$`)
+
+// headlessSession is a run's X server
+type headlessSession struct {
+	cmd     *exec.Cmd
+	display string
+	authDir string // holds the Xauthority file with the server's cookie
+}
+
+// checkHeadless validates --headless and --headless-server
+func checkHeadless() error {
+	if headlessSize == "" {
+		return nil
+	}
+	if !headlessSizePattern.MatchString(headlessSize) {
+		return fmt.Errorf("--headless: want WIDTHxHEIGHT or WIDTHxHEIGHTxDEPTH, got %q", headlessSize)
+	}
+	program, ok := headlessServers[headlessServer]
+	if !ok {
+		return fmt.Errorf("unknown --headless-server %q: want xvfb or xephyr", headlessServer)
+	}
+	if displayFlag != "" {
+		return fmt.Errorf("--headless and --display are exclusive")
+	}
+	if _, err := exec.LookPath(program); err != nil {
+		return fmt.Errorf("--headless: %s is not in PATH", program)
+	}
+	return nil
+}
+
+// startHeadless starts an X server of the given size, returning once it
+// accepts connections
+func startHeadless(size string) (*headlessSession, error) {
+	m := headlessSizePattern.FindStringSubmatch(size)
+	if m == nil {
+		return nil, fmt.Errorf("invalid size %q", size)
+	}
+	depth := firstNonEmpty(m[3], "24")
+	program := headlessServers[headlessServer]
+	// -displayfd makes the server choose a free display and write its
+	// number to the pipe once it listens
+	authDir, err := os.MkdirTemp("", "agentos-xauth-")
+	if err != nil {
+		return nil, err
+	}
+	auth := filepath.Join(authDir, "Xauthority")
+	if err := writeXauth(auth); err != nil {
+		os.RemoveAll(authDir)
+		return nil, err
+	}
+	ready, w, err := os.Pipe()
+	if err != nil {
+		os.RemoveAll(authDir)
+		return nil, err
+	}
+	defer ready.Close()
+	args := []string{"-displayfd", "3", "-nolisten", "tcp", "-auth", auth}
+	if program == "Xvfb" {
+		args = append(args, "-screen", "0", m[1]+"x"+m[2]+"x"+depth)
+	} else {
+		args = append(args, "-screen", m[1]+"x"+m[2]+"x"+depth, "-title", "AgentOS run")
+	}
+	cmd := exec.Command(program, args...)
+	cmd.ExtraFiles = []*os.File{w}
+	detachGroup(cmd)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		os.RemoveAll(authDir)
+		return nil, fmt.Errorf("starting %s: %v", program, err)
+	}
+	s := &headlessSession{cmd: cmd, authDir: authDir}
+
+	number := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(ready).ReadString('\n')
+		number <- strings.TrimSpace(line)
+	}()
+	select {
+	case n := <-number:
+		if _, err := strconv.Atoi(n); err != nil {
+			s.stop()
+			return nil, fmt.Errorf("%s exited before it was ready", program)
+		}
+		s.display = ":" + n
+	case <-clock.After(headlessStart):
+		s.stop()
+		return nil, fmt.Errorf("%s did not start within %v", program, headlessStart)
+	}
+	return s, nil
+}
+
+// writeXauth writes a fresh MIT-MAGIC-COOKIE-1 to a new Xauthority file
+// at path, readable by this user only. The display is chosen by the server
+// once it starts, so the record is for any display, and -auth makes the
+// server read its cookie from the same file.
+func writeXauth(path string) error {
+	cookie := make([]byte, 16)
+	if _, err := rand.Read(cookie); err != nil {
+		return err
+	}
+	// xauth nlist's hex records: family, then address, display number,
+	// name and data, each with its length
+	name := "MIT-MAGIC-COOKIE-1"
+	record := fmt.Sprintf("ffff 0000  0000  %04x %x %04x %x\n", len(name), name, len(cookie), cookie)
+	cmd := exec.Command("xauth", "-q", "-f", path, "nmerge", "-")
+	cmd.Stdin = strings.NewReader(record)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("xauth: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// auth is the Xauthority file clients of the server need
+func (s *headlessSession) auth() string {
+	return filepath.Join(s.authDir, "Xauthority")
+}
+
+// stop ends the server and whatever the run left on it
+func (s *headlessSession) stop() {
+	killGroup(s.cmd.Process)
+	s.cmd.Wait()
+	os.RemoveAll(s.authDir)
+}
+
+// startHeadless gives the run its own X server for --headless, aborting
+// the run if it cannot start rather than running on the real display
+func (r *runner) startHeadless() {
+	if headlessSize == "" {
+		return
+	}
+	s, err := startHeadless(headlessSize)
+	if err == nil {
+		r.xauthBefore, r.hadXauth = os.LookupEnv("XAUTHORITY")
+		os.Setenv("XAUTHORITY", s.auth())
+		if err = useDisplay(s.display); err != nil {
+			r.restoreXauth()
+			s.stop()
+		}
+	}
+	if err != nil {
+		r.result.Errors = append(r.result.Errors, fmt.Sprintf("--headless: %v", err))
+		r.abort("no headless display")
+		return
+	}
+	r.headless = s
+	r.result.Events = append(r.result.Events, Event{Type: "headless",
+		Message: fmt.Sprintf("%s on %s at %s", headlessServers[headlessServer], s.display, headlessSize)})
+}
+
+// stopHeadless stops the run's X server. Lock keys set on it go with it.
+func (r *runner) stopHeadless() {
+	if r.headless == nil {
+		return
+	}
+	r.restoreXauth()
+	r.restoreDisplay()
+	r.headless.stop()
+	r.headless, r.locksBefore = nil, nil
+}
+
+// restoreXauth puts back the XAUTHORITY the run had before --headless
+func (r *runner) restoreXauth() {
+	if r.hadXauth {
+		os.Setenv("XAUTHORITY", r.xauthBefore)
+	} else {
+		os.Unsetenv("XAUTHORITY")
+	}
+}
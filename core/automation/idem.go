@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdemRecord is a step that completed under an idempotency key
+type IdemRecord struct {
+	Action string    `json:"action"`
+	Line   string    `json:"line"`
+	Done   time.Time `json:"done"`
+}
+
+// idemStore remembers which idempotency keys have succeeded, per tenant,
+// in the state directory
+type idemStore struct {
+	mu   sync.Mutex
+	path string
+	keys map[string]IdemRecord
+}
+
+func idemStorePath() string {
+	name := tenant
+	if name == "" {
+		name = "default"
+	}
+	return filepath.Join(stateDir(), "idempotency", name+".json")
+}
+
+func openIdemStore() *idemStore {
+	s := &idemStore{path: idemStorePath(), keys: map[string]IdemRecord{}}
+	if data, err := os.ReadFile(s.path); err == nil {
+		json.Unmarshal(data, &s.keys)
+	}
+	return s
+}
+
+func (s *idemStore) done(key string) (IdemRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.keys[key]
+	return rec, ok
+}
+
+// record marks key as done. The store is rewritten atomically so a crash
+// never loses keys recorded earlier.
+func (s *idemStore) record(key string, rec IdemRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = rec
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// parseAnnotation reads a step annotation line, `@name: value` or
+// `@name value`. Annotations apply to the next step.
+func parseAnnotation(line string) (name, value string, ok bool) {
+	if !strings.HasPrefix(line, "@") {
+		return "", "", false
+	}
+	body := line[1:]
+	end := strings.IndexAny(body, ": \t")
+	if end < 0 {
+		return strings.ToLower(body), "", body != ""
+	}
+	name = strings.ToLower(body[:end])
+	value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(body[end:]), ":"))
+	return name, value, name != ""
+}
+
+// knownAnnotations lists the annotations steps may carry
+var knownAnnotations = map[string]bool{
+	"idem":    true,
+	"budget":  true,
+	"name":    true,
+	"timeout": true,
+	"retries": true,
+	"env":     true,
+	"cwd":     true,
+	"pre":     true,
+	"post":    true,
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With --when-idle the daemon runs jobs only on a machine nobody is using.
+// A queued job starts once the user has been idle that long, and a job
+// under way pauses before its next step when they come back, going on
+// once they have been idle that long again, as with --yield:
+//
+//	executor daemon --when-idle 10m
+//
+// Idle time is the time since the last key press or pointer motion on the
+// machine's physical devices, read through evdev so the jobs' own input
+// does not count; it starts from the X screen saver's or logind's idle
+// time. Without readable input devices the X screen saver's or logind's
+// idle time alone decides when jobs start, and jobs do not pause; on X a
+// job's own input then counts as use, so the next job waits the full
+// time.
+
+// idlePoll is how often the idle time is read while a job waits for it
+// without evdev
+const idlePoll = 30 * time.Second
+
+// idleGate holds jobs back until the user is idle
+type idleGate struct {
+	quiet    time.Duration
+	activity *userActivity // nil without evdev
+}
+
+// newIdleGate starts watching the user's input for the daemon's lifetime
+func newIdleGate(quiet time.Duration) *idleGate {
+	g := &idleGate{quiet: quiet}
+	activity := newUserActivity()
+	if err := watchUserInput(newSupervisor(), activity); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: --when-idle: %v; jobs will not pause when the user returns\n", err)
+		return g
+	}
+	if idle, err := systemIdleTime(); err == nil {
+		activity.last.Store(clock.Now().Add(-idle).UnixNano())
+	} else {
+		activity.input() // unknown, so wait the full time
+	}
+	g.activity = activity
+	return g
+}
+
+// idle is how long the user has been idle
+func (g *idleGate) idle() (time.Duration, error) {
+	if g.activity == nil {
+		return systemIdleTime()
+	}
+	return clock.Now().Sub(time.Unix(0, g.activity.last.Load())), nil
+}
+
+// wait blocks until the user has been idle for the gate's time
+func (g *idleGate) wait() {
+	announced := false
+	for {
+		idle, err := g.idle()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: --when-idle: reading idle time: %v\n", err)
+			idle = 0
+		}
+		if idle >= g.quiet {
+			return
+		}
+		if !announced {
+			fmt.Fprintf(os.Stderr, "Waiting for the user to be idle for %v before the next job\n", g.quiet)
+			announced = true
+		}
+		pause := g.quiet - idle
+		if g.activity == nil && pause > idlePoll {
+			pause = idlePoll
+		}
+		clock.Sleep(pause)
+	}
+}
+
+// systemIdleTime reads the session's idle time from the X screen saver
+// extension, else from logind
+func systemIdleTime() (time.Duration, error) {
+	idle, xErr := xScreenSaverIdle()
+	if xErr == nil {
+		return idle, nil
+	}
+	idle, err := logindIdle()
+	if err != nil {
+		return 0, fmt.Errorf("%v; logind: %v", xErr, err)
+	}
+	return idle, nil
+}
+
+// xScreenSaverIdle asks the X server how long ago it last had input
+func xScreenSaverIdle() (time.Duration, error) {
+	x, err := openX("")
+	if err != nil {
+		return 0, err
+	}
+	defer x.Close()
+	major, present, err := x.queryExtension("MIT-SCREEN-SAVER")
+	if err != nil {
+		return 0, err
+	}
+	if !present {
+		return 0, fmt.Errorf("X server has no MIT-SCREEN-SAVER extension")
+	}
+	reply, err := x.roundTrip(x.req(major, 1, u32(x.screen.Root))) // QueryInfo
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(xByteOrder.Uint32(reply[16:])) * time.Millisecond, nil
+}
+
+// logindIdle reads the session's IdleHint: zero while it is in use, else
+// the time since IdleSinceHint
+func logindIdle() (time.Duration, error) {
+	props, err := logindSession("IdleHint", "IdleSinceHint")
+	if err != nil {
+		return 0, err
+	}
+	if props["IdleHint"] != "yes" {
+		return 0, nil
+	}
+	usec, err := strconv.ParseInt(props["IdleSinceHint"], 10, 64)
+	if err != nil || usec == 0 {
+		return 0, fmt.Errorf("no IdleSinceHint")
+	}
+	return time.Since(time.UnixMicro(usec)), nil
+}
+
+// logindSession reads properties of the executor's logind session
+func logindSession(names ...string) (map[string]string, error) {
+	session := os.Getenv("XDG_SESSION_ID")
+	if session == "" {
+		session = "auto"
+	}
+	args := []string{"show-session", session}
+	for _, name := range names {
+		args = append(args, "--property="+name)
+	}
+	out, err := exec.Command("loginctl", args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	props := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			props[k] = v
+		}
+	}
+	return props, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+)
+
+// colorNormalize selects how images are normalized before template
+// matching and visual diffs:
+//
+//	none - compare raw RGB
+//	gray - compare luminance only, ignoring tint (night light, ICC white point)
+//	ncc  - gray plus zero-mean normalized cross-correlation, which also
+//	       tolerates brightness, contrast and most gamma differences
+var colorNormalize = "none"
+
+var colorNormalizeModes = []string{"none", "gray", "ncc"}
+
+const defaultImageThreshold = 0.9
+
+// coarseCandidates is how many downscaled hits are refined at full size
+const coarseCandidates = 5
+
+// plane is one channel of an image with values in [0,1]
+type plane struct {
+	w, h int
+	pix  []float64
+}
+
+func (p plane) at(x, y int) float64 {
+	return p.pix[y*p.w+x]
+}
+
+// imagePlanes splits an image into the channels compared under mode
+func imagePlanes(img image.Image, mode string) []plane {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	channels := 3
+	if mode != "none" {
+		channels = 1
+	}
+	planes := make([]plane, channels)
+	for i := range planes {
+		planes[i] = plane{w: w, h: h, pix: newPlanePix(w * h)}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			rf, gf, bf := float64(r)/0xffff, float64(g)/0xffff, float64(bl)/0xffff
+			if channels == 1 {
+				planes[0].pix[y*w+x] = 0.299*rf + 0.587*gf + 0.114*bf
+			} else {
+				planes[0].pix[y*w+x] = rf
+				planes[1].pix[y*w+x] = gf
+				planes[2].pix[y*w+x] = bf
+			}
+		}
+	}
+	return planes
+}
+
+// downscale averages factor x factor blocks
+func (p plane) downscale(factor int) plane {
+	if factor <= 1 {
+		return p
+	}
+	out := plane{w: p.w / factor, h: p.h / factor}
+	out.pix = newPlanePix(out.w * out.h)
+	n := float64(factor * factor)
+	for y := 0; y < out.h; y++ {
+		for x := 0; x < out.w; x++ {
+			sum := 0.0
+			for dy := 0; dy < factor; dy++ {
+				for dx := 0; dx < factor; dx++ {
+					sum += p.at(x*factor+dx, y*factor+dy)
+				}
+			}
+			out.pix[y*out.w+x] = sum / n
+		}
+	}
+	return out
+}
+
+// similarity scores the template against the screen at offset ox,oy.
+// 1.0 is a perfect match.
+func similarity(screen, tpl []plane, ox, oy int, mode string) float64 {
+	total := 0.0
+	for c := range tpl {
+		s, t := screen[c], tpl[c]
+		if mode == "ncc" {
+			total += ncc(s, t, ox, oy)
+			continue
+		}
+		diff := 0.0
+		for y := 0; y < t.h; y++ {
+			for x := 0; x < t.w; x++ {
+				diff += math.Abs(s.at(ox+x, oy+y) - t.at(x, y))
+			}
+		}
+		total += 1 - diff/float64(t.w*t.h)
+	}
+	return total / float64(len(tpl))
+}
+
+func ncc(s, t plane, ox, oy int) float64 {
+	n := float64(t.w * t.h)
+	var sumS, sumT float64
+	for y := 0; y < t.h; y++ {
+		for x := 0; x < t.w; x++ {
+			sumS += s.at(ox+x, oy+y)
+			sumT += t.at(x, y)
+		}
+	}
+	meanS, meanT := sumS/n, sumT/n
+	var cov, varS, varT float64
+	for y := 0; y < t.h; y++ {
+		for x := 0; x < t.w; x++ {
+			ds := s.at(ox+x, oy+y) - meanS
+			dt := t.at(x, y) - meanT
+			cov += ds * dt
+			varS += ds * ds
+			varT += dt * dt
+		}
+	}
+	if varS == 0 && varT == 0 {
+		// Both patches flat: fall back to comparing the flat levels
+		return 1 - math.Abs(meanS-meanT)
+	}
+	if varS == 0 || varT == 0 {
+		return 0
+	}
+	return math.Max(0, cov/math.Sqrt(varS*varT))
+}
+
+// matchTemplate finds the best position of tpl inside screen. It searches a
+// downscaled copy first and refines around the coarse hits at full size.
+func matchTemplate(screen, tpl image.Image) (x, y int, score float64, err error) {
+	sb, tb := screen.Bounds(), tpl.Bounds()
+	if tb.Dx() > sb.Dx() || tb.Dy() > sb.Dy() {
+		return 0, 0, 0, fmt.Errorf("template %dx%d is larger than the screen %dx%d", tb.Dx(), tb.Dy(), sb.Dx(), sb.Dy())
+	}
+	sp := imagePlanes(screen, colorNormalize)
+	tp := imagePlanes(tpl, colorNormalize)
+	defer releasePlanes(sp)
+	defer releasePlanes(tp)
+
+	factor := 4
+	for factor > 1 && (tb.Dx()/factor < 8 || tb.Dy()/factor < 8) {
+		factor /= 2
+	}
+	coarseS := make([]plane, len(sp))
+	coarseT := make([]plane, len(tp))
+	for i := range sp {
+		coarseS[i] = sp[i].downscale(factor)
+		coarseT[i] = tp[i].downscale(factor)
+	}
+	if factor > 1 {
+		defer releasePlanes(coarseS)
+		defer releasePlanes(coarseT)
+	}
+
+	// Keep the best few coarse hits; a single one is easily fooled by
+	// repetitive UI chrome once detail has been averaged away
+	type candidate struct {
+		x, y  int
+		score float64
+	}
+	var top []candidate
+	for cy := 0; cy+coarseT[0].h <= coarseS[0].h; cy++ {
+		for cx := 0; cx+coarseT[0].w <= coarseS[0].w; cx++ {
+			s := similarity(coarseS, coarseT, cx, cy, colorNormalize)
+			if len(top) == coarseCandidates && s <= top[len(top)-1].score {
+				continue
+			}
+			i := sort.Search(len(top), func(i int) bool { return top[i].score < s })
+			top = append(top, candidate{})
+			copy(top[i+1:], top[i:])
+			top[i] = candidate{cx, cy, s}
+			if len(top) > coarseCandidates {
+				top = top[:coarseCandidates]
+			}
+		}
+	}
+	if factor == 1 {
+		return top[0].x, top[0].y, top[0].score, nil
+	}
+
+	bestX, bestY, best := 0, 0, -1.0
+	for _, c := range top {
+		cx, cy := c.x*factor, c.y*factor
+		for y := cy - factor; y <= cy+factor; y++ {
+			for x := cx - factor; x <= cx+factor; x++ {
+				if x < 0 || y < 0 || x+tp[0].w > sp[0].w || y+tp[0].h > sp[0].h {
+					continue
+				}
+				if s := similarity(sp, tp, x, y, colorNormalize); s > best {
+					bestX, bestY, best = x, y, s
+				}
+			}
+		}
+	}
+	return bestX, bestY, best, nil
+}
+
+// compareImages scores two same-sized images, used for whole-screen diffs
+func compareImages(a, b image.Image) (float64, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return 0, fmt.Errorf("image sizes differ: %v vs %v", a.Bounds().Size(), b.Bounds().Size())
+	}
+	pa, pb := imagePlanes(a, colorNormalize), imagePlanes(b, colorNormalize)
+	defer releasePlanes(pa)
+	defer releasePlanes(pb)
+	return similarity(pa, pb, 0, 0, colorNormalize), nil
+}
+
+func loadPNG(filename string) (image.Image, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
+
+// captureScreen grabs the whole screen into memory, minding --cursor
+func captureScreen() (image.Image, error) {
+	return captureClean()
+}
+
+// findOnScreen locates a template image on the current screen
+func findOnScreen(templateFile string, threshold float64) (x, y int, err error) {
+	screen, err := captureScreen()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer releaseFrame(screen)
+	match, err := matchOnFrame(screen, templateFile, threshold)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !match.Found {
+		return 0, 0, fmt.Errorf("image %s not found (best match %.3f < %.3f at %d,%d)", templateFile, match.Score, threshold, match.X, match.Y)
+	}
+	return match.X, match.Y, nil
+}
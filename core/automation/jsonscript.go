@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// With --format json a script is a JSON array of commands, or one command
+// object per line:
+//
+//	{"action": "click", "params": {"button": 1, "clicks": "s"}, "annotations": {"budget": "2s"}}
+//
+// Params are checked against the action's spec before anything runs. Each
+// command becomes one script line holding its JSON, which parseCommand
+// reads back with the types intact, so text that could not be written in
+// the line syntax, a newline or a quote, survives. on_rollback, parallel,
+// branch, end and setup (with an optional policy param) are accepted as
+// actions too.
+
+// scriptFormat is set by --format
+var scriptFormat = "text"
+
+var scriptFormats = []string{"text", "json", "yaml"}
+
+// scriptLines converts a script in one of scriptFormats to script lines
+func scriptLines(format string, data []byte) ([]byte, error) {
+	switch format {
+	case "", "text":
+		return data, nil
+	case "json":
+		return jsonScript(data)
+	case "yaml":
+		return yamlScript(data)
+	}
+	return nil, fmt.Errorf("unknown format %q (want text, json or yaml)", format)
+}
+
+// JSONCommand is one command of a JSON script
+type JSONCommand struct {
+	Action      string                     `json:"action"`
+	Params      map[string]json.RawMessage `json:"params,omitempty"`
+	Annotations map[string]string          `json:"annotations,omitempty"`
+}
+
+// jsonScript converts a JSON script to script lines, reporting the first
+// command that is invalid by its position: its index in an array, or its
+// line in JSON Lines
+func jsonScript(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, fmt.Errorf("script is not a JSON array: %v", err)
+		}
+		for i, obj := range raw {
+			if err := writeJSONCommand(&out, obj); err != nil {
+				return nil, fmt.Errorf("command %d: %v", i+1, err)
+			}
+		}
+		return out.Bytes(), nil
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if err := writeJSONCommand(&out, []byte(line)); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// writeJSONCommand checks a command and writes its script lines
+func writeJSONCommand(out *bytes.Buffer, obj []byte) error {
+	c, err := decodeJSONCommand(obj)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range c.Annotations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := c.Annotations[name]
+		if !knownAnnotations[name] {
+			return fmt.Errorf("unknown annotation %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("annotation %s: value must be one line", name)
+		}
+		if err := checkAnnotation(name, value); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "@%s %s\n", name, value)
+	}
+	switch c.Action {
+	case "on_rollback", "parallel", "branch", "end":
+		if len(c.Params) > 0 {
+			return fmt.Errorf("%s takes no params", c.Action)
+		}
+		fmt.Fprintln(out, c.Action)
+		return nil
+	case "setup":
+		var policy string
+		for name, v := range c.Params {
+			if name != "policy" || json.Unmarshal(v, &policy) != nil || strings.ContainsAny(policy, "\r\n") {
+				return fmt.Errorf("setup takes one string param, policy")
+			}
+		}
+		fmt.Fprintf(out, "setup: %s\n", policy)
+		return nil
+	}
+	if _, err := c.command(); err != nil {
+		return err
+	}
+	line, _ := json.Marshal(JSONCommand{Action: c.Action, Params: c.Params})
+	out.Write(line)
+	out.WriteByte('\n')
+	return nil
+}
+
+func decodeJSONCommand(obj []byte) (JSONCommand, error) {
+	var c JSONCommand
+	dec := json.NewDecoder(bytes.NewReader(obj))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("not a command object: %v", err)
+	}
+	if dec.More() {
+		return c, fmt.Errorf("more than one object")
+	}
+	if c.Action == "" {
+		return c, fmt.Errorf("missing action")
+	}
+	return c, nil
+}
+
+// parseJSONCommand reads a script line holding a command object; nil if
+// it is not a valid command
+func parseJSONCommand(line string) *Command {
+	c, err := decodeJSONCommand([]byte(line))
+	if err != nil {
+		return nil
+	}
+	cmd, err := c.command()
+	if err != nil {
+		return nil
+	}
+	cmd.Original = line
+	return cmd
+}
+
+// command checks the params against the action's spec and converts them
+// to the values the line parser produces: int, float64, string, bool and
+// []string, with defaults filled in
+func (c JSONCommand) command() (*Command, error) {
+	var spec *ActionSpec
+	for i := range actionSpecs {
+		if actionSpecs[i].Name == c.Action {
+			spec = &actionSpecs[i]
+		}
+	}
+	if spec == nil {
+		return nil, fmt.Errorf("unknown action %q", c.Action)
+	}
+	cmd := &Command{Action: c.Action, Params: map[string]interface{}{}}
+	known := map[string]bool{}
+	for _, p := range spec.Params {
+		known[p.Name] = true
+		raw, ok := c.Params[p.Name]
+		if !ok || string(raw) == "null" {
+			switch {
+			case p.Required:
+				return nil, fmt.Errorf("%s: missing param %s", c.Action, p.Name)
+			case p.Type == "array":
+				cmd.Params[p.Name] = []string{}
+			case p.Default != nil && p.Type != "boolean":
+				cmd.Params[p.Name] = p.Default
+			}
+			continue
+		}
+		v, err := jsonParam(p, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: param %s: %v", c.Action, p.Name, err)
+		}
+		// Optional flags are only present when set, as in the line syntax
+		if b, isBool := v.(bool); isBool && !b && !p.Required {
+			continue
+		}
+		cmd.Params[p.Name] = v
+	}
+	for name := range c.Params {
+		if !known[name] {
+			return nil, fmt.Errorf("%s: unknown param %s", c.Action, name)
+		}
+	}
+	if check, ok := jsonChecks[c.Action]; ok {
+		if err := check(cmd); err != nil {
+			return nil, fmt.Errorf("%s: %v", c.Action, err)
+		}
+	}
+	return cmd, nil
+}
+
+// jsonParam decodes one param as its spec's type
+func jsonParam(p ParamSpec, raw json.RawMessage) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch p.Type {
+	case "integer":
+		n, ok := v.(json.Number)
+		i, err := n.Int64()
+		if !ok || err != nil {
+			return nil, fmt.Errorf("want an integer, got %s", raw)
+		}
+		value = int(i)
+	case "number":
+		n, ok := v.(json.Number)
+		f, err := n.Float64()
+		if !ok || err != nil {
+			return nil, fmt.Errorf("want a number, got %s", raw)
+		}
+		value = f
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("want a string, got %s", raw)
+		}
+		value = s
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("want true or false, got %s", raw)
+		}
+		value = b
+	case "array":
+		items, ok := v.([]interface{})
+		strs := []string{}
+		for _, item := range items {
+			s, isString := item.(string)
+			ok = ok && isString
+			strs = append(strs, s)
+		}
+		if !ok {
+			return nil, fmt.Errorf("want an array of strings, got %s", raw)
+		}
+		value = strs
+	}
+	if len(p.Enum) > 0 {
+		text := fmt.Sprint(value)
+		if !contains(p.Enum, text) {
+			return nil, fmt.Errorf("%s is not one of %s", text, strings.Join(p.Enum, ", "))
+		}
+	}
+	return value, nil
+}
+
+// jsonChecks are the rules of an action's params beyond their types
+var jsonChecks = map[string]func(cmd *Command) error{
+	"read_text":        checkRegionParams,
+	"read_qr":          checkRegionParams,
+	"snapshot_session": checkSessionParam,
+	"restore_session":  checkSessionParam,
+	"display": func(cmd *Command) error {
+		_, _, err := parseDisplay(cmd.Params["display"].(string))
+		return err
+	},
+	"click": func(cmd *Command) error {
+		_, x := cmd.Params["x"]
+		_, y := cmd.Params["y"]
+		_, monitor := cmd.Params["monitor"]
+		if x != y || (monitor && !x) {
+			return fmt.Errorf("give both x and y, or neither")
+		}
+		return nil
+	},
+	"key":     func(cmd *Command) error { return checkKeys(cmd.Params["key"].(string)) },
+	"keydown": func(cmd *Command) error { return checkKeyHold(cmd.Params["key"].(string)) },
+	"keyup":   func(cmd *Command) error { return checkKeyHold(cmd.Params["key"].(string)) },
+	"observe": func(cmd *Command) error {
+		req := PerceptionRequest{Threshold: cmd.Params["threshold"].(float64)}
+		req.OCR, _ = cmd.Params["ocr"].(bool)
+		req.A11y, _ = cmd.Params["a11y"].(bool)
+		req.Templates = cmd.Params["image"].([]string)
+		if !req.OCR && !req.A11y && len(req.Templates) == 0 {
+			return fmt.Errorf("ask for at least one of ocr, a11y or image")
+		}
+		cmd.Params = map[string]interface{}{"request": req}
+		return nil
+	},
+	"tty_open": func(cmd *Command) error {
+		if cmd.Params["baud"].(int) <= 0 {
+			return fmt.Errorf("baud must be positive")
+		}
+		return nil
+	},
+	"tty_expect": func(cmd *Command) error {
+		if cmd.Params["timeout"].(float64) <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+		_, err := regexp.Compile(cmd.Params["pattern"].(string))
+		return err
+	},
+	"type_totp": func(cmd *Command) error {
+		if !validCredentialRef(cmd.Params["credential"].(string)) {
+			return fmt.Errorf("credential must be a cred:SERVICE/FIELD reference")
+		}
+		return nil
+	},
+	"capture_camera": func(cmd *Command) error {
+		if !validCameraFile(cmd.Params["file"].(string)) {
+			return fmt.Errorf("file must end in .jpg, .jpeg or .png")
+		}
+		return nil
+	},
+	"print_to_pdf": func(cmd *Command) error {
+		if !validPrintFile(cmd.Params["file"].(string)) {
+			return fmt.Errorf("file must end in .pdf")
+		}
+		if cmd.Params["timeout"].(float64) <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+		return nil
+	},
+	"wait_for_sound": func(cmd *Command) error {
+		if cmd.Params["threshold"].(float64) > 0 {
+			return fmt.Errorf("threshold is in dBFS and at most 0")
+		}
+		if cmd.Params["timeout"].(float64) <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+		return nil
+	},
+	"hover": func(cmd *Command) error {
+		if cmd.Params["seconds"].(float64) < 0 {
+			return fmt.Errorf("seconds must not be negative")
+		}
+		return nil
+	},
+	"wait": func(cmd *Command) error {
+		if cmd.Params["seconds"].(float64) < 0 {
+			return fmt.Errorf("seconds must not be negative")
+		}
+		return nil
+	},
+}
+
+func checkRegionParams(cmd *Command) error {
+	if n := len(cmd.Params); n != 0 && n != 4 {
+		return fmt.Errorf("give all of x, y, w and h, or none")
+	}
+	return nil
+}
+
+func checkSessionParam(cmd *Command) error {
+	if name := cmd.Params["name"].(string); !validSessionName(name) {
+		return fmt.Errorf("invalid session name %s", strconv.Quote(name))
+	}
+	return nil
+}
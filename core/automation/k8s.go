@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// k8s-run turns a script into a disposable cluster job: it creates a pod
+// from an image holding Xvfb, the executor and the target application,
+// waits for the virtual display, pipes the script into the executor in the
+// pod, copies the artifacts back and deletes the pod, whatever happens.
+// It drives the cluster through kubectl, with its usual configuration.
+
+const (
+	k8sExecutor  = "/usr/local/bin/executor_binary"
+	k8sArtifacts = "/artifacts"
+	k8sDisplay   = ":99"
+)
+
+// k8sOptions are the k8s-run flags
+type k8sOptions struct {
+	image, namespace, context, app, screen string
+	cpu, memory, artifacts                 string
+	timeout                                time.Duration
+	executorArgs                           []string
+}
+
+func runK8s(args []string) {
+	fs := flag.NewFlagSet("k8s-run", flag.ExitOnError)
+	var o k8sOptions
+	fs.StringVar(&o.image, "image", "", "image with Xvfb, "+k8sExecutor+" and the target application (required)")
+	fs.StringVar(&o.namespace, "namespace", "", "namespace for the pod (default: kubectl's)")
+	fs.StringVar(&o.context, "context", "", "kubeconfig context (default: kubectl's)")
+	fs.StringVar(&o.app, "app", "", "command started in the pod once the display is up, e.g. \"firefox about:blank\"")
+	fs.StringVar(&o.screen, "screen", "1920x1080x24", "Xvfb screen geometry and depth")
+	fs.StringVar(&o.cpu, "cpu", "1", "CPU request and limit for the pod")
+	fs.StringVar(&o.memory, "memory", "2Gi", "memory request and limit for the pod")
+	fs.StringVar(&o.artifacts, "artifacts", screenshotsDir, "local directory the run's screenshots are copied to")
+	fs.DurationVar(&o.timeout, "timeout", 15*time.Minute, "longest the pod may live, start-up included")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: executor k8s-run --image IMAGE [flags] SCRIPT [-- EXECUTOR FLAGS...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if o.image == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	rest := fs.Args()
+	scriptFile := rest[0]
+	if len(rest) > 1 && rest[1] == "--" {
+		o.executorArgs = rest[2:]
+	}
+	script, err := os.ReadFile(scriptFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening file: %v\n", err)
+		os.Exit(1)
+	}
+
+	result, err := k8sRun(o, script)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	jsonOutput, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(jsonOutput))
+}
+
+// k8sRun runs script in a fresh pod and returns its result, with
+// screenshot paths pointing at the local copies
+func k8sRun(o k8sOptions, script []byte) (ExecutionResult, error) {
+	var result ExecutionResult
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	pod := "agentos-run-" + hex.EncodeToString(suffix)
+
+	manifest, _ := json.Marshal(k8sPodManifest(o, pod))
+	if _, err := kubectl(o, bytes.NewReader(manifest), "create", "-f", "-"); err != nil {
+		return result, fmt.Errorf("creating pod: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Created pod %s\n", pod)
+
+	// The pod goes on every way out, an interrupt included
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	deleted := make(chan struct{})
+	teardown := func() {
+		if _, err := kubectl(o, nil, "delete", "pod", pod, "--wait=false", "--ignore-not-found"); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: deleting pod %s: %v\n", pod, err)
+		}
+	}
+	go func() {
+		select {
+		case <-interrupt:
+			teardown()
+			os.Exit(130)
+		case <-deleted:
+		}
+	}()
+	defer func() {
+		signal.Stop(interrupt)
+		close(deleted)
+		teardown()
+	}()
+
+	if _, err := kubectl(o, nil, "wait", "--for=condition=Ready", "pod/"+pod, "--timeout="+o.timeout.String()); err != nil {
+		return result, fmt.Errorf("waiting for pod %s: %v", pod, err)
+	}
+
+	// The executor's warnings stream through; its result is read whole
+	execArgs := append([]string{"exec", "-i", pod, "-c", "executor", "--", k8sExecutor, "--screenshots-dir", k8sArtifacts}, o.executorArgs...)
+	out, err := kubectl(o, bytes.NewReader(script), execArgs...)
+	if err != nil && len(out) == 0 {
+		return result, fmt.Errorf("running script in pod %s: %v", pod, err)
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return result, fmt.Errorf("reading result from pod %s: %v", pod, err)
+	}
+
+	if len(result.Screenshots) > 0 && o.artifacts != "" {
+		dir := filepath.Join(o.artifacts, pod)
+		if _, err := kubectl(o, nil, "cp", "-c", "executor", pod+":"+k8sArtifacts, dir); err != nil {
+			result.Events = append(result.Events, Event{Type: "artifacts_error", Message: fmt.Sprintf("copying artifacts: %v", err)})
+		} else {
+			for i, shot := range result.Screenshots {
+				rel := strings.TrimPrefix(path.Clean(shot.File), k8sArtifacts+"/")
+				result.Screenshots[i].File = filepath.Join(dir, filepath.FromSlash(rel))
+			}
+		}
+	}
+	return result, nil
+}
+
+// k8sPodManifest is the pod: Xvfb, then the application, then idle until
+// the executor is run in it. It is ready once the display accepts
+// connections, and never outlives the timeout.
+func k8sPodManifest(o k8sOptions, name string) map[string]interface{} {
+	socket := "/tmp/.X11-unix/X" + strings.TrimPrefix(k8sDisplay, ":")
+	start := fmt.Sprintf("mkdir -p %s && Xvfb %s -screen 0 %s -nolisten tcp & "+
+		"while [ ! -e %s ]; do sleep 0.1; done; ", k8sArtifacts, k8sDisplay, o.screen, socket)
+	if o.app != "" {
+		start += o.app + " & "
+	}
+	start += "exec sleep infinity"
+	resources := map[string]string{"cpu": o.cpu, "memory": o.memory}
+	metadata := map[string]interface{}{"name": name, "labels": map[string]string{"app.kubernetes.io/name": "agentos-executor"}}
+	if o.namespace != "" {
+		metadata["namespace"] = o.namespace
+	}
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   metadata,
+		"spec": map[string]interface{}{
+			"restartPolicy":                 "Never",
+			"activeDeadlineSeconds":         int(o.timeout.Seconds()),
+			"terminationGracePeriodSeconds": 5,
+			"automountServiceAccountToken":  false,
+			"containers": []map[string]interface{}{{
+				"name":      "executor",
+				"image":     o.image,
+				"command":   []string{"sh", "-c", start},
+				"env":       []map[string]string{{"name": "DISPLAY", "value": k8sDisplay}},
+				"resources": map[string]interface{}{"requests": resources, "limits": resources},
+				"readinessProbe": map[string]interface{}{
+					"exec":          map[string]interface{}{"command": []string{"test", "-e", socket}},
+					"periodSeconds": 1,
+				},
+			}},
+		},
+	}
+}
+
+// kubectl runs kubectl with the context and namespace flags, returning
+// its output. What it prints on stderr passes through.
+func kubectl(o k8sOptions, stdin io.Reader, args ...string) ([]byte, error) {
+	var global []string
+	if o.context != "" {
+		global = append(global, "--context", o.context)
+	}
+	if o.namespace != "" {
+		global = append(global, "--namespace", o.namespace)
+	}
+	cmd := exec.Command("kubectl", append(global, args...)...)
+	cmd.Stdin = stdin
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keydown and keyup hold keys across steps, for a shift-click or a
+// ctrl-drag:
+//
+//	keydown ctrl
+//	drag 100 100 300 300
+//	keyup ctrl
+//
+// KEY is a key name as for key, or a combination such as ctrl+shift,
+// pressed in order and released in reverse. Keys still held when a step
+// fails or the script ends are released then, with a keys_released
+// event, so a run never leaves a modifier stuck; the stuck modifier check
+// before keyboard steps leaves them alone meanwhile.
+
+// parseKeyHold reads keydown KEY and keyup KEY
+func parseKeyHold(cmd *Command, parts []string) *Command {
+	if len(parts) != 2 {
+		return nil
+	}
+	cmd.Params["key"] = parts[1]
+	return cmd
+}
+
+// checkKeyHold checks the names of a keydown or keyup combination; any
+// key may be held, not only modifiers
+func checkKeyHold(keys string) error {
+	for _, name := range strings.Split(keys, "+") {
+		if err := checkKeyName(name); err != nil {
+			return fmt.Errorf("%s: %v", keys, err)
+		}
+	}
+	return nil
+}
+
+// holdKeys runs keydown and keyup. A keydown that fails partway releases
+// the keys it pressed.
+func holdKeys(cmd *Command) error {
+	if err := checkKeyHold(cmd.Params["key"].(string)); err != nil {
+		return err
+	}
+	input := currentBackend()
+	names := strings.Split(cmd.Params["key"].(string), "+")
+	if cmd.Action == "keyup" {
+		for i := len(names) - 1; i >= 0; i-- {
+			if err := input.KeyUp(names[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, name := range names {
+		if err := input.KeyDown(name); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				input.KeyUp(names[j])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// trackHeldKeys notes the keys a keydown or keyup step left held
+func (r *runner) trackHeldKeys(cmd *Command) {
+	names := strings.Split(cmd.Params["key"].(string), "+")
+	if cmd.Action == "keydown" {
+		for _, name := range names {
+			if !contains(r.heldKeys, name) {
+				r.heldKeys = append(r.heldKeys, name)
+			}
+		}
+		return
+	}
+	var held []string
+	for _, name := range r.heldKeys {
+		if !contains(names, name) {
+			held = append(held, name)
+		}
+	}
+	r.heldKeys = held
+}
+
+// heldModifiers are the modifiers among the keys the run holds
+func (r *runner) heldModifiers() []string {
+	var mods []string
+	for _, key := range r.heldKeys {
+		if name, ok := modifierName(key); ok {
+			mods = append(mods, name)
+		}
+	}
+	return mods
+}
+
+// releaseHeldKeys releases the keys the run holds, latest first, and
+// records a keys_released event saying why
+func (r *runner) releaseHeldKeys(step *StepResult, why string) {
+	if len(r.heldKeys) == 0 {
+		return
+	}
+	awaitAbandoned()
+	input := currentBackend()
+	msg := fmt.Sprintf("released %s, held when %s", strings.Join(r.heldKeys, "+"), why)
+	var failed []string
+	for i := len(r.heldKeys) - 1; i >= 0; i-- {
+		if err := input.KeyUp(r.heldKeys[i]); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.heldKeys[i], err))
+		}
+	}
+	r.heldKeys = nil
+	if len(failed) > 0 {
+		msg += "; failed: " + strings.Join(failed, "; ")
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", msg)
+	}
+	event := Event{Type: "keys_released", Message: msg}
+	if step != nil {
+		event.Step = step.Step
+		step.Events = append(step.Events, event)
+	}
+	r.result.Events = append(r.result.Events, event)
+}
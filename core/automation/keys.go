@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Key names are checked before anything is pressed, so a typo fails the
+// step instead of xdotool skipping the key with a warning nobody reads. A
+// key step is combinations separated by spaces, each a chord of modifiers
+// and then one key, pressed in order and released in reverse:
+//
+//	key ctrl+shift+t
+//	key ctrl+a Delete
+//
+// Names are xdotool's, in any case: the modifiers below, Return, F5,
+// KP_7, bracketleft, XF86AudioMute and the like, or a single character.
+// The keys action lists them all. Backends map them to what they can send
+// (the adb backend also takes Android's KEYCODE_ names, and on macOS cmd
+// and option are Command and Option).
+
+// keyModifierNames are the names accepted before the last key of a chord
+var keyModifierNames = []string{
+	"ctrl", "Control_L", "Control_R", "shift", "Shift_L", "Shift_R",
+	"alt", "Alt_L", "Alt_R", "super", "Super_L", "Super_R", "meta", "Meta_L", "Meta_R",
+	"ISO_Level3_Shift", "cmd", "option", "fn",
+}
+
+// isKeyModifier reports whether name is a modifier, in any case
+func isKeyModifier(name string) bool {
+	for _, m := range keyModifierNames {
+		if strings.EqualFold(m, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKeyName checks a single key name
+func checkKeyName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty key name")
+	}
+	if _, ok := xKeysym(name); ok || isKeyModifier(name) || strings.HasPrefix(name, "KEYCODE_") {
+		return nil
+	}
+	if suggestion := closestKeyName(name); suggestion != "" {
+		return fmt.Errorf("unknown key %q (did you mean %s?)", name, suggestion)
+	}
+	return fmt.Errorf("unknown key %q (the keys action lists the names)", name)
+}
+
+// checkKeys checks the combinations of a key step: every name known, and
+// every key but a chord's last a modifier
+func checkKeys(keys string) error {
+	combos := strings.Fields(keys)
+	if len(combos) == 0 {
+		return fmt.Errorf("no key given")
+	}
+	for _, combo := range combos {
+		names := strings.Split(combo, "+")
+		for i, name := range names {
+			if err := checkKeyName(name); err != nil {
+				return fmt.Errorf("%s: %v", combo, err)
+			}
+			// Android's key codes combine as they are
+			if i < len(names)-1 && !isKeyModifier(name) && !strings.HasPrefix(name, "KEYCODE_") {
+				return fmt.Errorf("%s: %q is not a modifier (want ctrl, shift, alt, super or meta)", combo, name)
+			}
+		}
+	}
+	return nil
+}
+
+// closestKeyName is the known name within two edits of name, if any
+func closestKeyName(name string) string {
+	best, bestDist := "", 3
+	for _, known := range listKeyNames() {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(known)); d < bestDist {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// listKeyNames is every named key, sorted: the modifiers, the names of
+// xKeysymNames, F1 to F35 and KP_0 to KP_9
+func listKeyNames() []string {
+	seen := map[string]bool{}
+	var names []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, m := range keyModifierNames {
+		add(m)
+	}
+	for name := range xKeysymNames {
+		add(name)
+	}
+	for i := 1; i <= 35; i++ {
+		add(fmt.Sprintf("F%d", i))
+	}
+	for i := 0; i <= 9; i++ {
+		add(fmt.Sprintf("KP_%d", i))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keysOutput answers the keys action
+func keysOutput() map[string]interface{} {
+	var keys []string
+	for _, name := range listKeyNames() {
+		if !isKeyModifier(name) {
+			keys = append(keys, name)
+		}
+	}
+	return map[string]interface{}{
+		"modifiers":  keyModifierNames,
+		"keys":       keys,
+		"characters": "any single character, e.g. a, A or 7",
+		"syntax":     "combinations separated by spaces, each MODIFIER+...+KEY, e.g. ctrl+shift+t",
+	}
+}
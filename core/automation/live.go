@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// /live is the interactive session for a browser control panel. Like /ws,
+// each text message from the client holds script lines, but every message
+// back is a JSON object with a type, sent as soon as there is something to
+// show:
+//
+//	{"type": "hello", "backend": "xdotool"}
+//	{"type": "step", "step": StepResult}         as each step finishes
+//	{"type": "screenshot", "screenshot": ...}    as each capture is taken
+//	{"type": "ready"}                             the message's lines are done
+//	{"type": "end", "result": ExecutionResult}   the run aborted and rolled back
+//
+// A step's screenshot can come before or after its step message: input
+// steps are captured once the burst they belong to is over.
+
+// liveMessage is one message of /live
+type liveMessage struct {
+	Type       string           `json:"type"`
+	Backend    string           `json:"backend,omitempty"`
+	Step       *StepResult      `json:"step,omitempty"`
+	Screenshot *Screenshot      `json:"screenshot,omitempty"`
+	Result     *ExecutionResult `json:"result,omitempty"`
+}
+
+func (s *server) handleLive(w http.ResponseWriter, r *http.Request) {
+	send := func(ws *wsConn, m liveMessage) error {
+		data, _ := json.Marshal(m)
+		return ws.writeText(data)
+	}
+	open := func(ws *wsConn, run *runner) error {
+		run.onCapture = func(step *StepResult) {
+			s.linkStep(step)
+			send(ws, liveMessage{Type: "screenshot", Screenshot: step.Screenshot})
+		}
+		return send(ws, liveMessage{Type: "hello", Backend: backendName})
+	}
+	s.serveSession(w, r, open, func(ws *wsConn, run *runner, message []byte) (bool, error) {
+		preloadScript(message)
+		for _, line := range strings.Split(string(message), "\n") {
+			if step := run.runLine(line); step != nil {
+				if err := send(ws, liveMessage{Type: "step", Step: step}); err != nil {
+					return false, err
+				}
+			}
+			if run.aborted {
+				for _, step := range run.finish() {
+					if err := send(ws, liveMessage{Type: "step", Step: step}); err != nil {
+						return false, err
+					}
+				}
+				result := s.linked(run.result)
+				return true, send(ws, liveMessage{Type: "end", Result: &result})
+			}
+		}
+		run.flushScreenshot()
+		return false, send(ws, liveMessage{Type: "ready"})
+	})
+}
+
+// allowedOrigin reports whether a WebSocket client may connect: programs,
+// which send no Origin, pages served from this host, and the origins in
+// serve.allowed_origins ("*" for any). Browsers always send Origin, so
+// other sites cannot drive the desktop through a visitor's browser. The
+// request must also name this server as its Host, see allowedHost.
+func (s *server) allowedOrigin(r *http.Request) bool {
+	if !s.allowedHost(r) {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.origins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
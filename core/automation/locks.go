@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// An engaged Caps Lock turns every typed password into a wrong one, so a
+// run starts with the lock keys in a known state, lock_keys.caps and
+// lock_keys.num (Caps Lock off and Num Lock on by default, "keep" to leave
+// one alone), and puts them back as it found them when it ends:
+//
+//	{"lock_keys": {"caps": "off", "num": "keep"}}
+//
+// set_capslock and set_numlock switch them within a run. Backends that
+// cannot read the lock state (qmp, vnc, adb, replays) leave them alone.
+
+// LockKeysConfig sets the lock key state runs start with
+type LockKeysConfig struct {
+	Caps string `json:"caps"` // on, off or keep (default off)
+	Num  string `json:"num"`  // on, off or keep (default on)
+}
+
+var lockKeySettings = []string{"on", "off", "keep"}
+
+// lockState is whether Caps Lock and Num Lock are on
+type lockState struct {
+	Caps bool
+	Num  bool
+}
+
+// lockKeyReader is implemented by backends that can read the lock state
+type lockKeyReader interface {
+	lockKeys() (lockState, error)
+}
+
+// xkNumLock is the Num_Lock keysym
+const xkNumLock = 0xff7f
+
+func checkLockKeysConfig(c LockKeysConfig) error {
+	if c.Caps != "" && !contains(lockKeySettings, c.Caps) {
+		return fmt.Errorf("lock_keys.caps: want on, off or keep, got %q", c.Caps)
+	}
+	if c.Num != "" && !contains(lockKeySettings, c.Num) {
+		return fmt.Errorf("lock_keys.num: want on, off or keep, got %q", c.Num)
+	}
+	return nil
+}
+
+// lockKeys reads the lock state from the X server: Caps Lock is the Lock
+// modifier, Num Lock whichever modifier Num_Lock is mapped to
+func (xdotoolBackend) lockKeys() (lockState, error) {
+	x, err := openX("")
+	if err != nil {
+		return lockState{}, err
+	}
+	defer x.Close()
+	mask, err := x.modifierState()
+	if err != nil {
+		return lockState{}, err
+	}
+	numMask, err := x.modifierMask(xkNumLock)
+	if err != nil {
+		return lockState{}, err
+	}
+	const lockMask = 1 << 1
+	return lockState{Caps: mask&lockMask != 0, Num: numMask != 0 && mask&numMask != 0}, nil
+}
+
+// readLockKeys returns the lock state, or an error if the backend cannot
+// tell it
+func readLockKeys() (lockState, error) {
+	reader, ok := baseBackend().(lockKeyReader)
+	if !ok {
+		return lockState{}, fmt.Errorf("the %s backend cannot read lock keys", baseBackend().Name())
+	}
+	return reader.lockKeys()
+}
+
+// setLockKeys toggles the lock keys that differ from want. The key presses
+// go to the backend under any recording, which would not replay them.
+func setLockKeys(want lockState) error {
+	have, err := readLockKeys()
+	if err != nil {
+		return err
+	}
+	input := baseBackend()
+	if have.Caps != want.Caps {
+		if err := input.Key("Caps_Lock"); err != nil {
+			return err
+		}
+	}
+	if have.Num != want.Num {
+		if err := input.Key("Num_Lock"); err != nil {
+			return err
+		}
+	}
+	if have, err = readLockKeys(); err == nil && have != want {
+		err = fmt.Errorf("lock keys did not change: Caps Lock %s, Num Lock %s", onOff(have.Caps), onOff(have.Num))
+	}
+	return err
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// holdLockKeys puts the lock keys in the configured state for the run,
+// remembering the state they had
+func (r *runner) holdLockKeys() {
+	if _, ok := baseBackend().(lockKeyReader); !ok {
+		return
+	}
+	cfg, err := currentConfig()
+	if err != nil {
+		return
+	}
+	report := func(kind, msg string) {
+		r.result.Events = append(r.result.Events, Event{Type: kind, Message: msg})
+	}
+	before, err := readLockKeys()
+	if err != nil {
+		report("lock_keys_error", err.Error())
+		return
+	}
+	want := before
+	var changed []string
+	for _, k := range []struct {
+		name    string
+		setting string
+		state   *bool
+	}{
+		{"Caps Lock", firstNonEmpty(cfg.LockKeys.Caps, "off"), &want.Caps},
+		{"Num Lock", firstNonEmpty(cfg.LockKeys.Num, "on"), &want.Num},
+	} {
+		if k.setting != "keep" && *k.state != (k.setting == "on") {
+			*k.state = k.setting == "on"
+			changed = append(changed, fmt.Sprintf("%s was %s", k.name, onOff(!*k.state)))
+		}
+	}
+	r.locksBefore = &before
+	if len(changed) == 0 {
+		return
+	}
+	if err := setLockKeys(want); err != nil {
+		report("lock_keys_error", err.Error())
+		return
+	}
+	report("lock_keys", strings.Join(changed, ", ")+"; set for the run")
+}
+
+// restoreLockKeys puts the lock keys back as the run found them
+func (r *runner) restoreLockKeys() {
+	if r.locksBefore == nil {
+		return
+	}
+	// The result is out by now, so a failure can only be warned about
+	if err := setLockKeys(*r.locksBefore); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: restoring lock keys: %v\n", err)
+	}
+	r.locksBefore = nil
+}
+
+// setLockKey runs set_capslock and set_numlock
+func setLockKey(cmd *Command) error {
+	want, err := readLockKeys()
+	if err != nil {
+		return err
+	}
+	on := cmd.Params["on"].(bool)
+	if cmd.Action == "set_capslock" {
+		want.Caps = on
+	} else {
+		want.Num = on
+	}
+	return setLockKeys(want)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// macBackend drives a macOS desktop. Pointer, keys and text go through
+// cliclick, which posts CGEvents and is installed with Homebrew; the wheel,
+// which cliclick has no command for, is a CGEvent posted from JavaScript
+// for Automation. screencapture takes the screenshots. The terminal or
+// agent running the executor needs the Accessibility and Screen Recording
+// permissions. It is the default backend on macOS.
+//
+// Screenshots of a Retina display have two pixels to the point while
+// events are placed in points, so positions are divided by the ratio of
+// the first capture's width to the desktop's.
+type macBackend struct {
+	mu    sync.Mutex
+	scale float64 // pixels per point, 0 until measured
+}
+
+// macKeyDelay is the wait between cliclick's events, in milliseconds
+const macKeyDelay = "20"
+
+func newMacBackend() (Backend, error) {
+	for _, tool := range []string{"cliclick", "screencapture", "osascript"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, fmt.Errorf("%s not found (brew install cliclick)", tool)
+		}
+	}
+	return &macBackend{}, nil
+}
+
+func runCliclick(args ...string) error {
+	cmd := exec.Command("cliclick", append([]string{"-w", macKeyDelay}, args...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cliclick %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (*macBackend) Name() string { return "macos" }
+
+// pixelScale measures the pixels per point once
+func (b *macBackend) pixelScale() (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.scale > 0 {
+		return b.scale, nil
+	}
+	// The desktop's bounds span every display, in points
+	out, err := exec.Command("osascript", "-e", `tell application "Finder" to get bounds of window of desktop`).Output()
+	if err != nil {
+		return 0, fmt.Errorf("reading the desktop size: %v", commandError(err))
+	}
+	fields := strings.Split(strings.TrimSpace(string(out)), ", ")
+	if len(fields) != 4 {
+		return 0, fmt.Errorf("reading the desktop size: unexpected %q", out)
+	}
+	left, _ := strconv.Atoi(fields[0])
+	right, _ := strconv.Atoi(fields[2])
+	img, err := b.Capture()
+	if err != nil {
+		return 0, err
+	}
+	width := img.Bounds().Dx()
+	releaseFrame(img)
+	if right <= left || width == 0 {
+		return 0, fmt.Errorf("reading the desktop size: %d points, %d pixels", right-left, width)
+	}
+	b.scale = float64(width) / float64(right-left)
+	return b.scale, nil
+}
+
+// point is cliclick's form of a pixel position
+func (b *macBackend) point(x, y int) (string, error) {
+	scale, err := b.pixelScale()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d,%d", int(float64(x)/scale), int(float64(y)/scale)), nil
+}
+
+func (b *macBackend) MoveMouse(x, y int) error {
+	p, err := b.point(x, y)
+	if err != nil {
+		return err
+	}
+	return runCliclick("m:" + p)
+}
+
+func (*macBackend) MouseDown(button int) error {
+	if button != 1 {
+		return fmt.Errorf("button %d cannot be held with cliclick", button)
+	}
+	return runCliclick("dd:.")
+}
+
+func (*macBackend) MouseUp(button int) error {
+	if button != 1 {
+		return fmt.Errorf("button %d cannot be held with cliclick", button)
+	}
+	return runCliclick("du:.")
+}
+
+// Click clicks where the pointer is. Buttons 4 to 7 scroll one line per
+// click.
+func (*macBackend) Click(button, count int) error {
+	switch button {
+	case 1:
+		switch count {
+		case 1:
+			return runCliclick("c:.")
+		case 2:
+			return runCliclick("dc:.")
+		case 3:
+			return runCliclick("tc:.")
+		}
+		args := make([]string, count)
+		for i := range args {
+			args[i] = "c:."
+		}
+		return runCliclick(args...)
+	case 3:
+		args := make([]string, count)
+		for i := range args {
+			args[i] = "rc:."
+		}
+		return runCliclick(args...)
+	case 4, 5, 6, 7:
+		dy, dx := 0, 0
+		switch button {
+		case 4:
+			dy = count
+		case 5:
+			dy = -count
+		case 6:
+			dx = count
+		case 7:
+			dx = -count
+		}
+		return macScroll(dy, dx)
+	}
+	return fmt.Errorf("button %d cannot be sent with cliclick", button)
+}
+
+// macScroll posts a line-based wheel event
+func macScroll(dy, dx int) error {
+	script := fmt.Sprintf(`ObjC.import('CoreGraphics');
+$.CGEventPost($.kCGHIDEventTap, $.CGEventCreateScrollWheelEvent2(null, $.kCGScrollEventUnitLine, 2, %d, %d, 0));`, dy, dx)
+	if out, err := exec.Command("osascript", "-l", "JavaScript", "-e", script).CombinedOutput(); err != nil {
+		return fmt.Errorf("scrolling: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// TypeText types with cliclick's t:, which types any character; line
+// breaks and tabs are pressed as keys
+func (*macBackend) TypeText(text string) error {
+	var args []string
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			args = append(args, "kp:return")
+		}
+		for j, part := range strings.Split(line, "\t") {
+			if j > 0 {
+				args = append(args, "kp:tab")
+			}
+			if part != "" {
+				args = append(args, "t:"+part)
+			}
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return runCliclick(args...)
+}
+
+// macModifiers are cliclick's modifier names for xdotool's. super and meta
+// are Command, so ctrl+c in a script written for Linux stays Control.
+var macModifiers = map[string]string{
+	"ctrl": "ctrl", "control_l": "ctrl", "control_r": "ctrl",
+	"alt": "alt", "alt_l": "alt", "alt_r": "alt", "option": "alt",
+	"shift": "shift", "shift_l": "shift", "shift_r": "shift",
+	"super": "cmd", "super_l": "cmd", "super_r": "cmd", "meta": "cmd", "meta_l": "cmd", "meta_r": "cmd", "cmd": "cmd",
+	"fn": "fn",
+}
+
+// macKeys are cliclick's key names for xdotool's other keys
+var macKeys = map[string]string{
+	"return": "return", "kp_enter": "enter", "escape": "esc", "backspace": "delete", "delete": "fwd-delete",
+	"tab": "tab", "space": "space", "home": "home", "end": "end",
+	"prior": "page-up", "page_up": "page-up", "next": "page-down", "page_down": "page-down",
+	"left": "arrow-left", "right": "arrow-right", "up": "arrow-up", "down": "arrow-down",
+}
+
+// macKeyArg is cliclick's command to press a non-modifier key
+func macKeyArg(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	lower := strings.ToLower(name)
+	if k, ok := macKeys[lower]; ok {
+		return "kp:" + k, true
+	}
+	if n, err := strconv.Atoi(lower[1:]); err == nil && lower[0] == 'f' && n >= 1 && n <= 16 {
+		return "kp:" + lower, true
+	}
+	if r := []rune(name); len(r) == 1 {
+		return "t:" + name, true
+	}
+	if k, ok := xKeysymNames[name]; ok && k < 0x80 {
+		return "t:" + string(rune(k)), true
+	}
+	return "", false
+}
+
+// Key takes xdotool key syntax, holding each combination's modifiers
+// around its key
+func (*macBackend) Key(keys string) error {
+	var args []string
+	for _, combo := range strings.Fields(keys) {
+		var mods []string
+		var press []string
+		for _, name := range strings.Split(combo, "+") {
+			if m, ok := macModifiers[strings.ToLower(name)]; ok {
+				mods = append(mods, m)
+				continue
+			}
+			arg, ok := macKeyArg(name)
+			if !ok {
+				return fmt.Errorf("unknown key %q", name)
+			}
+			press = append(press, arg)
+		}
+		if len(mods) > 0 {
+			args = append(args, "kd:"+strings.Join(mods, ","))
+		}
+		args = append(args, press...)
+		if len(mods) > 0 {
+			args = append(args, "ku:"+strings.Join(mods, ","))
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return runCliclick(args...)
+}
+
+// KeyDown and KeyUp hold modifiers only; cliclick cannot hold other keys
+func (*macBackend) KeyDown(key string) error { return macHold(key, "kd:") }
+
+func (*macBackend) KeyUp(key string) error { return macHold(key, "ku:") }
+
+func macHold(key, command string) error {
+	m, ok := macModifiers[strings.ToLower(key)]
+	if !ok {
+		return fmt.Errorf("cliclick can only hold modifiers, not %q", key)
+	}
+	return runCliclick(command + m)
+}
+
+// Capture has screencapture write a PNG, silently
+func (b *macBackend) Capture() (image.Image, error) {
+	f, err := os.CreateTemp("", "agentos-capture-*.png")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := b.captureFile(f.Name()); err != nil {
+		return nil, err
+	}
+	return loadPNG(f.Name())
+}
+
+func (*macBackend) captureFile(path string) error {
+	if out, err := exec.Command("screencapture", "-x", "-t", "png", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("screencapture: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// cursorPosition reads cliclick's position, in points, as pixels
+func (b *macBackend) cursorPosition() (int, int, error) {
+	scale, err := b.pixelScale()
+	if err != nil {
+		return 0, 0, err
+	}
+	out, err := exec.Command("cliclick", "p").Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("cliclick p: %v", commandError(err))
+	}
+	xs, ys, ok := strings.Cut(strings.TrimSpace(string(out)), ",")
+	x, errX := strconv.Atoi(xs)
+	y, errY := strconv.Atoi(ys)
+	if !ok || errX != nil || errY != nil {
+		return 0, 0, fmt.Errorf("cliclick p: unexpected %q", out)
+	}
+	return int(float64(x) * scale), int(float64(y) * scale), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// maskWindows is set by --mask-windows: screenshots keep only the windows
+// of these applications and black out everything else, using the window
+// layout at capture time. Patterns match window titles, ignoring case, or
+// with a class: prefix the window class.
+var maskWindows string
+
+// windowAllowlist is --mask-windows, else redact.allow_windows
+func windowAllowlist(rc RedactConfig) []string {
+	if maskWindows == "" {
+		return rc.AllowWindows
+	}
+	var allow []string
+	for _, p := range strings.Split(maskWindows, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			allow = append(allow, p)
+		}
+	}
+	return allow
+}
+
+// windowMask is which windows of a layout may be shown, bottom to top
+type windowMask struct {
+	windows []SessionWindow
+	allowed []bool
+}
+
+// currentWindowMask reads the window layout now. Without a layout nothing
+// can be shown safely, so that is an error rather than an empty mask.
+func currentWindowMask(allow []string) (*windowMask, error) {
+	wm, err := currentWindowManager()
+	if err != nil {
+		return nil, err
+	}
+	snap, err := wm.sessionLayout()
+	if err != nil {
+		return nil, fmt.Errorf("reading window layout: %v", err)
+	}
+	m := &windowMask{windows: snap.Windows}
+	for _, w := range snap.Windows {
+		m.allowed = append(m.allowed, windowAllowed(w, allow))
+	}
+	return m, nil
+}
+
+func windowAllowed(w SessionWindow, allow []string) bool {
+	for _, p := range allow {
+		if class, ok := strings.CutPrefix(p, "class:"); ok {
+			if w.Class != "" && strings.EqualFold(w.Class, class) {
+				return true
+			}
+		} else if strings.Contains(strings.ToLower(w.Title), strings.ToLower(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// shows reports whether p is on an allowed window that nothing covers
+func (m *windowMask) shows(p image.Point) bool {
+	for i := len(m.windows) - 1; i >= 0; i-- {
+		w := m.windows[i]
+		if p.In(image.Rect(w.X, w.Y, w.X+w.Width, w.Y+w.Height)) {
+			return m.allowed[i]
+		}
+	}
+	return false
+}
+
+// apply blacks out every pixel of img not shown by the mask. Windows are
+// painted bottom to top, so a hidden window over an allowed one is masked.
+func (m *windowMask) apply(img *image.RGBA) {
+	b := img.Bounds()
+	keep := make([]bool, b.Dx()*b.Dy())
+	for i, w := range m.windows {
+		r := image.Rect(w.X, w.Y, w.X+w.Width, w.Y+w.Height).Intersect(b)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := keep[(y-b.Min.Y)*b.Dx():]
+			for x := r.Min.X; x < r.Max.X; x++ {
+				row[x-b.Min.X] = m.allowed[i]
+			}
+		}
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := keep[(y-b.Min.Y)*b.Dx():]
+		pix := img.Pix[img.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			if !row[x] {
+				p := pix[4*x : 4*x+4 : 4*x+4]
+				p[0], p[1], p[2], p[3] = 0, 0, 0, 255
+			}
+		}
+	}
+}
+
+// maskWords drops the words that the mask hides
+func (m *windowMask) maskWords(words []OCRWord) []OCRWord {
+	var kept []OCRWord
+	for _, w := range words {
+		if m.shows(image.Pt(w.X+w.Width/2, w.Y+w.Height/2)) {
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
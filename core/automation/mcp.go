@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mcp serves the actions as Model Context Protocol tools over stdio, so an
+// LLM client calls click, type or screenshot directly:
+//
+//	{"mcpServers": {"agentos": {"command": "executor_binary", "args": ["mcp"]}}}
+//
+// Messages are JSON-RPC 2.0, one per line. The tools and their input
+// schemas come from actionSpecs, as for the tools subcommand. Calls are
+// steps of one run, so a rollback block registered by one call runs when
+// a later one fails; after an abort the next call starts a new run. A
+// step's result is returned as JSON text, with its screenshot as an image.
+
+// mcpProtocolVersions are the protocol revisions spoken, newest first
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// mcpServer holds the run that tool calls are steps of
+type mcpServer struct {
+	run *runner
+	out *json.Encoder
+}
+
+func runMCP(args []string) {
+	fs := flag.NewFlagSet("mcp", flag.ExitOnError)
+	serviceFlags(fs)
+	fs.Parse(args)
+
+	checkServiceSetup()
+	if err := initBackend(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	os.MkdirAll(screenshotsDir, 0755)
+
+	s := &mcpServer{out: json.NewEncoder(os.Stdout)}
+	defer func() {
+		if s.run != nil {
+			s.end()
+		}
+	}()
+	if err := s.serve(os.Stdin); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
+
+// serve answers requests until the client closes stdin
+func (s *mcpServer) serve(in io.Reader) error {
+	reader := bufio.NewReader(in)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			s.handle(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *mcpServer) handle(line []byte) {
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		s.out.Encode(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}})
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		if req.ID != nil {
+			s.out.Encode(rpcResponse{JSONRPC: "2.0", ID: req.ID, Error: &rpcError{rpcInvalidRequest, "not a JSON-RPC 2.0 request"}})
+		}
+		return
+	}
+	result, rerr := s.call(req.Method, req.Params)
+	// Notifications get no response
+	if req.ID == nil {
+		return
+	}
+	resp := rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rerr}
+	if rerr == nil && result == nil {
+		resp.Result = jsonObject{}
+	}
+	s.out.Encode(resp)
+}
+
+func (s *mcpServer) call(method string, params json.RawMessage) (interface{}, *rpcError) {
+	switch method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(params, &p)
+		version := mcpProtocolVersions[0]
+		if contains(mcpProtocolVersions, p.ProtocolVersion) {
+			version = p.ProtocolVersion
+		}
+		return jsonObject{
+			"protocolVersion": version,
+			"capabilities":    jsonObject{"tools": jsonObject{"listChanged": false}},
+			"serverInfo":      jsonObject{"name": "agentos-executor", "version": apiVersion},
+			"instructions":    "Each tool is one step of an AgentOS automation run on this desktop. Results hold the step as JSON and the screenshot taken after it.",
+		}, nil
+	case "ping":
+		return jsonObject{}, nil
+	case "tools/list":
+		return jsonObject{"tools": mcpTools()}, nil
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		if !hasActionSpec(p.Name) {
+			return nil, &rpcError{rpcInvalidParams, "unknown tool " + p.Name}
+		}
+		return s.callTool(p.Name, p.Arguments), nil
+	}
+	if strings.HasPrefix(method, "notifications/") {
+		return nil, nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, "unknown method " + method}
+}
+
+// mcpTools lists every action as a tool
+func mcpTools() []jsonObject {
+	var tools []jsonObject
+	for _, spec := range actionSpecs {
+		tools = append(tools, jsonObject{
+			"name":        spec.Name,
+			"description": toolDescription(spec),
+			"inputSchema": spec.paramsSchema(),
+		})
+	}
+	return tools
+}
+
+func hasActionSpec(name string) bool {
+	for _, spec := range actionSpecs {
+		if spec.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// callTool runs one action as a step. Bad arguments and failed steps are
+// tool results with isError set, which the model gets to see.
+func (s *mcpServer) callTool(name string, arguments json.RawMessage) jsonObject {
+	if len(arguments) == 0 || string(arguments) == "null" {
+		arguments = json.RawMessage("{}")
+	}
+	command, _ := json.Marshal(jsonObject{"action": name, "params": arguments})
+	var line bytes.Buffer
+	if err := writeJSONCommand(&line, command); err != nil {
+		return mcpToolError(err.Error())
+	}
+	if s.run == nil {
+		s.run = newRunner()
+	}
+	var steps []*StepResult
+	ended, _ := runSessionMessage(s.run, bytes.TrimSpace(line.Bytes()), func(done []*StepResult) error {
+		steps = append(steps, done...)
+		return nil
+	})
+
+	failed := false
+	for _, step := range steps {
+		failed = failed || step.Status == "error"
+	}
+	var text []byte
+	if len(steps) == 1 {
+		text, _ = json.Marshal(steps[0])
+	} else {
+		text, _ = json.Marshal(steps)
+	}
+	content := []jsonObject{{"type": "text", "text": string(text)}}
+	if ended {
+		s.end()
+		content = append(content, jsonObject{"type": "text", "text": "The run aborted and was rolled back; the next call starts a new run."})
+	}
+	for _, step := range steps {
+		if image := mcpImage(step.Screenshot); image != nil {
+			content = append(content, image)
+		}
+	}
+	return jsonObject{"content": content, "isError": failed}
+}
+
+// end closes the current run and keeps its result
+func (s *mcpServer) end() {
+	s.run.close()
+	savePortableResult(s.run.result)
+	s.run = nil
+}
+
+func mcpToolError(msg string) jsonObject {
+	return jsonObject{"content": []jsonObject{{"type": "text", "text": msg}}, "isError": true}
+}
+
+// mcpImage is a screenshot as image content, nil if there is none
+func mcpImage(shot *Screenshot) jsonObject {
+	if shot == nil || shot.File == "" {
+		return nil
+	}
+	mime := map[string]string{".png": "image/png", ".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".webp": "image/webp"}[strings.ToLower(filepath.Ext(shot.File))]
+	if mime == "" {
+		return nil
+	}
+	data, err := os.ReadFile(shot.File)
+	if err != nil {
+		return nil
+	}
+	return jsonObject{"type": "image", "data": base64.StdEncoding.EncodeToString(data), "mimeType": mime}
+}
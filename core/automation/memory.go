@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxFrameBuffer is set by --max-frame-buffer: the most memory kept in
+// pooled frame and plane buffers between captures. A 4K capture is 33MB
+// of pixels and 200MB of matching planes; buffers beyond the limit are
+// left to the garbage collector instead of being kept for reuse.
+var maxFrameBuffer = "256MB"
+
+// framePool recycles the large buffers each capture needs, keyed by
+// length, so long runs reuse them instead of allocating fresh ones
+var framePool = struct {
+	mu     sync.Mutex
+	limit  int64
+	held   int64
+	pix    map[int][][]uint8
+	planes map[int][][]float64
+}{limit: 256 << 20, pix: map[int][][]uint8{}, planes: map[int][][]float64{}}
+
+// setFrameBufferLimit applies --max-frame-buffer
+func setFrameBufferLimit(size string) error {
+	n, err := parseByteSize(size)
+	if err != nil {
+		return fmt.Errorf("--max-frame-buffer: %v", err)
+	}
+	framePool.mu.Lock()
+	framePool.limit = n
+	framePool.mu.Unlock()
+	return nil
+}
+
+// parseByteSize reads sizes such as 512MB, 1GiB or 1048576
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{{"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	scale := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper, scale = strings.TrimSpace(strings.TrimSuffix(upper, u.suffix)), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * scale, nil
+}
+
+// newFrame returns an RGBA image, reusing a pooled buffer if one fits. Its
+// pixels are not cleared.
+func newFrame(r image.Rectangle) *image.RGBA {
+	n := 4 * r.Dx() * r.Dy()
+	framePool.mu.Lock()
+	defer framePool.mu.Unlock()
+	free := framePool.pix[n]
+	if len(free) == 0 {
+		return image.NewRGBA(r)
+	}
+	pix := free[len(free)-1]
+	framePool.pix[n] = free[:len(free)-1]
+	framePool.held -= int64(n)
+	return &image.RGBA{Pix: pix, Stride: 4 * r.Dx(), Rect: r}
+}
+
+// releaseFrame hands a frame nobody uses any more back to the pool
+func releaseFrame(img image.Image) {
+	var rgba *image.RGBA
+	switch img := img.(type) {
+	case *image.RGBA:
+		rgba = img
+	case *mockFrame:
+		rgba = img.RGBA
+	default:
+		return
+	}
+	n := len(rgba.Pix)
+	if n == 0 || n != 4*rgba.Rect.Dx()*rgba.Rect.Dy() {
+		return // a sub-image shares its parent's buffer
+	}
+	framePool.mu.Lock()
+	defer framePool.mu.Unlock()
+	if framePool.held+int64(n) > framePool.limit {
+		return
+	}
+	framePool.pix[n] = append(framePool.pix[n], rgba.Pix)
+	framePool.held += int64(n)
+}
+
+// newPlanePix returns n values for a plane, not cleared
+func newPlanePix(n int) []float64 {
+	framePool.mu.Lock()
+	defer framePool.mu.Unlock()
+	free := framePool.planes[n]
+	if len(free) == 0 {
+		return make([]float64, n)
+	}
+	pix := free[len(free)-1]
+	framePool.planes[n] = free[:len(free)-1]
+	framePool.held -= int64(8 * n)
+	return pix
+}
+
+// releasePlanes hands planes nobody uses any more back to the pool
+func releasePlanes(planes []plane) {
+	framePool.mu.Lock()
+	defer framePool.mu.Unlock()
+	for _, p := range planes {
+		n := len(p.pix)
+		if n == 0 || framePool.held+int64(8*n) > framePool.limit {
+			continue
+		}
+		framePool.planes[n] = append(framePool.planes[n], p.pix)
+		framePool.held += int64(8 * n)
+	}
+}
+
+// pngBuffers lets PNG encoders reuse their compression buffers
+type pngBuffers struct{ pool sync.Pool }
+
+func (p *pngBuffers) Get() *png.EncoderBuffer {
+	b, _ := p.pool.Get().(*png.EncoderBuffer)
+	return b
+}
+
+func (p *pngBuffers) Put(b *png.EncoderBuffer) { p.pool.Put(b) }
+
+var pngEncoder = png.Encoder{BufferPool: &pngBuffers{}}
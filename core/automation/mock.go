@@ -0,0 +1,481 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MockConfig sets up the mock backend's virtual screen
+type MockConfig struct {
+	Width   int          `json:"width"`
+	Height  int          `json:"height"`
+	Windows []MockWindow `json:"windows"`
+	// CapsLock starts the screen with Caps Lock on; Num Lock starts on
+	CapsLock bool `json:"caps_lock"`
+	// HeldModifiers are held down from the start, as by a user: shift,
+	// ctrl, alt or super
+	HeldModifiers []string `json:"held_modifiers"`
+}
+
+// MockWindow is a window on the virtual screen; the last one is on top
+type MockWindow struct {
+	Title  string `json:"title"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Text   string `json:"text"`
+}
+
+var defaultMockWindows = []MockWindow{
+	{Title: "Files", X: 60, Y: 60, Width: 520, Height: 360, Text: "Documents\nDownloads\nPictures"},
+	{Title: "Terminal", X: 420, Y: 240, Width: 720, Height: 420},
+}
+
+const (
+	mockTitleHeight = 24
+	mockGlyphScale  = 2
+	mockAdvance     = (mockGlyphW + 1) * mockGlyphScale
+	mockLineHeight  = (mockGlyphH + 3) * mockGlyphScale
+	mockPadding     = 8
+)
+
+var (
+	mockDesktop  = color.RGBA{0x2e, 0x34, 0x40, 0xff}
+	mockBorder   = color.RGBA{0x1e, 0x22, 0x2a, 0xff}
+	mockActive   = color.RGBA{0x35, 0x84, 0xe4, 0xff}
+	mockInactive = color.RGBA{0x80, 0x86, 0x90, 0xff}
+)
+
+// mockBackend executes input against an in-memory window model and renders
+// deterministic screenshots of it, for testing scripts without a display
+type mockBackend struct {
+	mu      sync.Mutex
+	bounds  image.Rectangle
+	cursor  image.Point
+	windows []*mockWindow // bottom to top
+	focused *mockWindow
+	drag    *mockWindow
+	grab    image.Point // cursor offset within the dragged window
+
+	cursorHidden bool
+	clipboard    string
+	locks        lockState
+	held         []string // modifiers held down
+}
+
+type mockWindow struct {
+	MockWindow
+	id       string
+	scroll   int  // lines scrolled off the top
+	selected bool // ctrl+a selected all of the text
+}
+
+// mockFrame is a rendered screen that also knows where its text is, so the
+// mock OCR provider can read it exactly
+type mockFrame struct {
+	*image.RGBA
+	words []OCRWord
+}
+
+func newMockBackend() (Backend, error) {
+	cfg, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	mc := cfg.Mock
+	if mc.Width <= 0 || mc.Height <= 0 {
+		mc.Width, mc.Height = 1280, 800
+	}
+	if mc.Windows == nil {
+		mc.Windows = defaultMockWindows
+	}
+	m := &mockBackend{bounds: image.Rect(0, 0, mc.Width, mc.Height), locks: lockState{Caps: mc.CapsLock, Num: true},
+		held: append([]string(nil), mc.HeldModifiers...)}
+	for i, w := range mc.Windows {
+		m.windows = append(m.windows, &mockWindow{MockWindow: w, id: fmt.Sprint(i + 1)})
+	}
+	if len(m.windows) > 0 {
+		m.focused = m.windows[len(m.windows)-1]
+	}
+	return m, nil
+}
+
+func (m *mockBackend) Name() string { return "mock" }
+
+func (m *mockBackend) MoveMouse(x, y int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cursor = image.Pt(clampInt(x, 0, m.bounds.Dx()-1), clampInt(y, 0, m.bounds.Dy()-1))
+	if m.drag != nil {
+		m.drag.X, m.drag.Y = m.cursor.X-m.grab.X, m.cursor.Y-m.grab.Y
+	}
+	return nil
+}
+
+func (m *mockBackend) MouseDown(button int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.press(button)
+	return nil
+}
+
+func (m *mockBackend) MouseUp(button int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if button == 1 {
+		m.drag = nil
+	}
+	return nil
+}
+
+func (m *mockBackend) Click(button, count int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < count; i++ {
+		m.press(button)
+		m.drag = nil
+	}
+	return nil
+}
+
+// press focuses and raises the window under the cursor; button 1 on a
+// title bar starts moving the window, buttons 4 and 5 scroll it
+func (m *mockBackend) press(button int) {
+	w := m.windowAt(m.cursor)
+	if w == nil {
+		return
+	}
+	switch button {
+	case 4:
+		if w.scroll > 0 {
+			w.scroll--
+		}
+	case 5:
+		if w.scroll < strings.Count(w.Text, "\n") {
+			w.scroll++
+		}
+	default:
+		m.raise(w)
+		if button == 1 && m.cursor.Y < w.Y+mockTitleHeight {
+			m.drag, m.grab = w, m.cursor.Sub(image.Pt(w.X, w.Y))
+		}
+	}
+}
+
+func (m *mockBackend) windowAt(p image.Point) *mockWindow {
+	for i := len(m.windows) - 1; i >= 0; i-- {
+		w := m.windows[i]
+		if p.In(image.Rect(w.X, w.Y, w.X+w.Width, w.Y+w.Height)) {
+			return w
+		}
+	}
+	return nil
+}
+
+func (m *mockBackend) raise(w *mockWindow) {
+	for i, other := range m.windows {
+		if other == w {
+			m.windows = append(append(m.windows[:i:i], m.windows[i+1:]...), w)
+			break
+		}
+	}
+	m.focused = w
+}
+
+func (m *mockBackend) TypeText(text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// With ctrl, alt or super held the keys are shortcuts, not text
+	if m.focused != nil && (len(m.held) == 0 || (len(m.held) == 1 && m.held[0] == "shift")) {
+		if m.locks.Caps != (len(m.held) == 1) {
+			text = swapCase(text)
+		}
+		m.focused.insert(text)
+	}
+	return nil
+}
+
+// swapCase is text as typed with Caps Lock on
+func swapCase(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, text)
+}
+
+func (m *mockBackend) lockKeys() (lockState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.locks, nil
+}
+
+func (m *mockBackend) heldModifiers() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.held...), nil
+}
+
+func (m *mockBackend) releaseModifiers(names []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var held []string
+	for _, name := range m.held {
+		if !contains(names, name) {
+			held = append(held, name)
+		}
+	}
+	m.held = held
+	return nil
+}
+
+// KeyDown holds a modifier, which then applies to typed text and keys;
+// other keys are accepted and ignored
+func (m *mockBackend) KeyDown(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name, ok := modifierName(key); ok && !contains(m.held, name) {
+		m.held = append(m.held, name)
+	}
+	return nil
+}
+
+func (m *mockBackend) KeyUp(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name, ok := modifierName(key); ok {
+		var held []string
+		for _, h := range m.held {
+			if h != name {
+				held = append(held, h)
+			}
+		}
+		m.held = held
+	}
+	return nil
+}
+
+// insert types text at the end of the window's text, or over all of it
+// when it is selected
+func (w *mockWindow) insert(text string) {
+	if w.selected {
+		w.Text, w.selected = "", false
+	}
+	w.Text += text
+}
+
+// Key applies the editing keys to the focused window, and ctrl+a, ctrl+c
+// and ctrl+v, which select, copy and paste all of its text; other keys
+// and shortcuts are accepted and ignored
+func (m *mockBackend) Key(keys string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := m.focused
+	for _, combo := range strings.Fields(keys) {
+		parts := strings.Split(combo, "+")
+		key := parts[len(parts)-1]
+		mods := strings.ToLower(strings.Join(append(append([]string(nil), m.held...), parts[:len(parts)-1]...), "+"))
+		switch key {
+		case "Caps_Lock":
+			m.locks.Caps = !m.locks.Caps
+			continue
+		case "Num_Lock":
+			m.locks.Num = !m.locks.Num
+			continue
+		}
+		if w == nil {
+			continue
+		}
+		if mods == "ctrl" {
+			switch strings.ToLower(key) {
+			case "a":
+				w.selected = true
+			case "c":
+				if w.selected {
+					m.clipboard = w.Text
+				}
+			case "v":
+				w.insert(m.clipboard)
+			default:
+				w.selected = false
+			}
+			continue
+		}
+		if mods != "" && mods != "shift" {
+			continue
+		}
+		switch key {
+		case "Return", "KP_Enter":
+			w.insert("\n")
+		case "Tab":
+			w.insert("    ")
+		case "space":
+			w.insert(" ")
+		case "BackSpace":
+			if w.selected {
+				w.insert("")
+			} else if _, size := utf8.DecodeLastRuneInString(w.Text); size > 0 {
+				w.Text = w.Text[:len(w.Text)-size]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				if (mods == "shift") != m.locks.Caps {
+					key = strings.ToUpper(key)
+				}
+				w.insert(key)
+			} else {
+				w.selected = false
+			}
+		}
+	}
+	return nil
+}
+
+// Capture renders the virtual screen
+func (m *mockBackend) Capture() (image.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	frame := &mockFrame{RGBA: newFrame(m.bounds)}
+	draw.Draw(frame, m.bounds, &image.Uniform{mockDesktop}, image.Point{}, draw.Src)
+	for _, w := range m.windows {
+		frame.drawWindow(w, w == m.focused)
+	}
+	if !m.cursorHidden {
+		frame.drawCursor(m.cursor)
+	}
+	return frame, nil
+}
+
+func (f *mockFrame) drawWindow(w *mockWindow, focused bool) {
+	outer := image.Rect(w.X, w.Y, w.X+w.Width, w.Y+w.Height)
+	// Text of windows underneath is no longer visible
+	visible := f.words[:0]
+	for _, word := range f.words {
+		if !image.Rect(word.X, word.Y, word.X+word.Width, word.Y+word.Height).Overlaps(outer) {
+			visible = append(visible, word)
+		}
+	}
+	f.words = visible
+	draw.Draw(f, outer, &image.Uniform{mockBorder}, image.Point{}, draw.Src)
+	title := image.Rect(w.X+1, w.Y+1, w.X+w.Width-1, w.Y+mockTitleHeight)
+	bar := mockInactive
+	if focused {
+		bar = mockActive
+	}
+	draw.Draw(f, title, &image.Uniform{bar}, image.Point{}, draw.Src)
+	body := image.Rect(w.X+1, w.Y+mockTitleHeight, w.X+w.Width-1, w.Y+w.Height-1)
+	draw.Draw(f, body, &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	titleY := w.Y + (mockTitleHeight-mockGlyphH*mockGlyphScale)/2
+	f.drawText(w.Title, w.X+mockPadding, titleY, title, color.White)
+
+	y := body.Min.Y + mockPadding
+	lines := strings.Split(w.Text, "\n")
+	if w.scroll < len(lines) {
+		lines = lines[w.scroll:]
+	}
+	for _, line := range lines {
+		f.drawText(line, body.Min.X+mockPadding, y, body, color.Black)
+		y += mockLineHeight
+	}
+}
+
+// drawText renders one line of text clipped to clip and records each
+// fully visible word's box
+func (f *mockFrame) drawText(text string, x, y int, clip image.Rectangle, c color.Color) {
+	clip = clip.Intersect(f.Bounds())
+	pen := &image.Uniform{c}
+	var word strings.Builder
+	wordX := x
+	flush := func(end int) {
+		box := image.Rect(wordX, y, end-mockGlyphScale, y+mockGlyphH*mockGlyphScale)
+		if word.Len() > 0 && box.In(clip) {
+			f.words = append(f.words, OCRWord{
+				Text: word.String(), Confidence: 1,
+				X: box.Min.X, Y: box.Min.Y, Width: box.Dx(), Height: box.Dy(),
+			})
+		}
+		word.Reset()
+	}
+	for _, r := range text {
+		if r == ' ' || r == '\t' {
+			flush(x)
+			x += mockAdvance
+			wordX = x
+			continue
+		}
+		glyph := mockGlyph(r)
+		for row := 0; row < mockGlyphH; row++ {
+			for col := 0; col < mockGlyphW; col++ {
+				if glyph[row]&(1<<(mockGlyphW-1-col)) == 0 {
+					continue
+				}
+				dot := image.Rect(x+col*mockGlyphScale, y+row*mockGlyphScale, x+(col+1)*mockGlyphScale, y+(row+1)*mockGlyphScale)
+				draw.Draw(f, dot.Intersect(clip), pen, image.Point{}, draw.Src)
+			}
+		}
+		word.WriteRune(r)
+		x += mockAdvance
+	}
+	flush(x)
+}
+
+// drawCursor draws an arrow with its tip at p
+func (f *mockFrame) drawCursor(p image.Point) {
+	for row := 0; row < 12; row++ {
+		for col := 0; col <= row/2+1 && col < 7; col++ {
+			c := color.Color(color.Black)
+			if col == 0 || col == row/2+1 || row == 11 {
+				c = color.White
+			}
+			f.Set(p.X+col, p.Y+row, c)
+		}
+	}
+}
+
+// crop cuts a region out of the frame, keeping the words inside it
+func (f *mockFrame) crop(r image.Rectangle) image.Image {
+	out := &mockFrame{RGBA: image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))}
+	draw.Draw(out, out.Bounds(), f, r.Min, draw.Src)
+	for _, w := range f.words {
+		if image.Rect(w.X, w.Y, w.X+w.Width, w.Y+w.Height).In(r) {
+			w.X, w.Y = w.X-r.Min.X, w.Y-r.Min.Y
+			out.words = append(out.words, w)
+		}
+	}
+	return out
+}
+
+// mockOCR reads text straight from mock backend frames
+type mockOCR struct{}
+
+var errMockOCR = errors.New("the mock OCR provider only reads mock backend frames")
+
+func (mockOCR) Name() string { return "mock" }
+
+func (mockOCR) Recognize(img image.Image) ([]OCRWord, error) {
+	frame, ok := img.(*mockFrame)
+	if !ok {
+		return nil, errMockOCR
+	}
+	return append([]OCRWord(nil), frame.words...), nil
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
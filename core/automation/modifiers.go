@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Shift or Ctrl held down by the person at the machine, or left down by
+// a crashed tool, changes every key a step sends: "hello" types "HELLO",
+// Return becomes ctrl+Return. Before each keyboard action the held
+// modifiers are read and, by default, released, with a stuck_modifiers
+// event on the step. modifiers.stuck set to "warn" only reports them, and
+// "ignore" skips the check:
+//
+//	{"modifiers": {"stuck": "warn"}}
+//
+// Caps Lock and Num Lock are latched rather than held; lock_keys handles
+// them.
+
+// ModifiersConfig sets what happens to modifiers held before a key step
+type ModifiersConfig struct {
+	Stuck string `json:"stuck"` // release, warn or ignore (default release)
+}
+
+var stuckModifierSettings = []string{"release", "warn", "ignore"}
+
+// keyboardActions are the actions whose keys a held modifier changes
+var keyboardActions = map[string]bool{
+	"type": true, "key": true, "type_verified": true, "type_totp": true, "print_to_pdf": true, "fill_form": true,
+}
+
+// modifierKeys are the modifiers checked, by name, with the keysyms that
+// release them
+var modifierKeys = []struct {
+	name    string
+	keysyms []string
+}{
+	{"shift", []string{"Shift_L", "Shift_R"}},
+	{"ctrl", []string{"Control_L", "Control_R"}},
+	{"alt", []string{"Alt_L", "Alt_R", "Meta_L", "Meta_R"}},
+	{"super", []string{"Super_L", "Super_R"}},
+}
+
+// modifierName is the modifier a key name holds, such as shift for
+// Shift_R, if it is one
+func modifierName(key string) (string, bool) {
+	for _, m := range modifierKeys {
+		if strings.EqualFold(key, m.name) {
+			return m.name, true
+		}
+		for _, k := range m.keysyms {
+			if strings.EqualFold(key, k) || strings.EqualFold(key+"_L", k) {
+				return m.name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// modifierChecker is implemented by backends that can tell which
+// modifiers are held and release them
+type modifierChecker interface {
+	heldModifiers() ([]string, error)
+	releaseModifiers(names []string) error
+}
+
+// X keysyms of the modifiers that are not always on the same modifier bit
+const (
+	xkAltL   = 0xffe9
+	xkSuperL = 0xffeb
+)
+
+func checkModifiersConfig(c ModifiersConfig) error {
+	if c.Stuck != "" && !contains(stuckModifierSettings, c.Stuck) {
+		return fmt.Errorf("modifiers.stuck: want release, warn or ignore, got %q", c.Stuck)
+	}
+	return nil
+}
+
+// heldModifiers reads the held modifiers from the X server. Shift and
+// Control have fixed bits; Alt and Super are wherever they are mapped.
+func (xdotoolBackend) heldModifiers() ([]string, error) {
+	x, err := openX("")
+	if err != nil {
+		return nil, err
+	}
+	defer x.Close()
+	mask, err := x.modifierState()
+	if err != nil {
+		return nil, err
+	}
+	masks := map[string]uint16{"shift": 1 << 0, "ctrl": 1 << 2}
+	for name, keysym := range map[string]uint32{"alt": xkAltL, "super": xkSuperL} {
+		if masks[name], err = x.modifierMask(keysym); err != nil {
+			return nil, err
+		}
+	}
+	var held []string
+	for _, m := range modifierKeys {
+		if masks[m.name] != 0 && mask&masks[m.name] != 0 {
+			held = append(held, m.name)
+		}
+	}
+	return held, nil
+}
+
+// releaseModifiers sends key releases for both sides of each modifier
+func (xdotoolBackend) releaseModifiers(names []string) error {
+	args := []string{"keyup"}
+	for _, m := range modifierKeys {
+		if contains(names, m.name) {
+			args = append(args, m.keysyms...)
+		}
+	}
+	return runXdotool(args...)
+}
+
+// checkStuckModifiers handles the modifiers held before a keyboard step,
+// other than those the run holds with keydown, returning the event to
+// report, if any
+func checkStuckModifiers(step int, holding []string) *Event {
+	cfg, _ := currentConfig()
+	setting := firstNonEmpty(cfg.Modifiers.Stuck, "release")
+	checker, ok := baseBackend().(modifierChecker)
+	if setting == "ignore" || !ok {
+		return nil
+	}
+	all, err := checker.heldModifiers()
+	if err != nil {
+		return &Event{Step: step, Type: "stuck_modifiers_error", Message: err.Error()}
+	}
+	var held []string
+	for _, name := range all {
+		if !contains(holding, name) {
+			held = append(held, name)
+		}
+	}
+	if len(held) == 0 {
+		return nil
+	}
+	msg := strings.Join(held, "+") + " held before the step"
+	if setting == "release" {
+		if err := checker.releaseModifiers(held); err != nil {
+			return &Event{Step: step, Type: "stuck_modifiers_error", Message: fmt.Sprintf("%s; releasing: %v", msg, err)}
+		}
+		if still, err := checker.heldModifiers(); err == nil && len(still) > len(all)-len(held) {
+			// A key physically held down comes back at once
+			msg += "; still held after release: " + strings.Join(still, "+")
+		} else {
+			msg += "; released"
+		}
+	}
+	return &Event{Step: step, Type: "stuck_modifiers", Message: msg}
+}
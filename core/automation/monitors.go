@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Monitor describes one active output in root-window coordinates. Index
+// numbers the monitors from 1 in xrandr's order; scripts qualify
+// coordinates with it, "pointer 2:400 2:300", and the monitors action
+// lists it.
+type Monitor struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Primary bool   `json:"primary"`
+}
+
+func (m Monitor) contains(x, y int) bool {
+	return x >= m.X && x < m.X+m.Width && y >= m.Y && y < m.Y+m.Height
+}
+
+// "HDMI-1 connected primary 1920x1080+0+0 (normal left inverted ...) 527mm x 296mm"
+var xrandrOutputRe = regexp.MustCompile(`^(\S+) connected( primary)? (\d+)x(\d+)\+(\d+)\+(\d+)`)
+
+// queryMonitors lists the active monitors of the X display. --current
+// avoids re-probing the outputs, so it is cheap enough to call between
+// steps. Other backends have no monitors to list: xrandr would describe
+// the local display instead.
+func queryMonitors() ([]Monitor, error) {
+	if b := baseBackend(); !isXDisplay(b) {
+		return nil, fmt.Errorf("monitors are not supported on backend %s", b.Name())
+	}
+	out, err := exec.Command("xrandr", "--current").Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing monitors: %v", commandError(err))
+	}
+	return parseXrandr(string(out)), nil
+}
+
+func parseXrandr(out string) []Monitor {
+	var monitors []Monitor
+	for _, line := range strings.Split(out, "\n") {
+		m := xrandrOutputRe.FindStringSubmatch(line)
+		if m == nil {
+			continue // disconnected or disabled output
+		}
+		w, _ := strconv.Atoi(m[3])
+		h, _ := strconv.Atoi(m[4])
+		x, _ := strconv.Atoi(m[5])
+		y, _ := strconv.Atoi(m[6])
+		monitors = append(monitors, Monitor{
+			Index:   len(monitors) + 1,
+			Name:    m[1],
+			X:       x,
+			Y:       y,
+			Width:   w,
+			Height:  h,
+			Primary: m[2] != "",
+		})
+	}
+	return monitors
+}
+
+func sameLayout(a, b []Monitor) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// geometryWatcher keeps script coordinates pointing at the same spot on
+// the same monitor when outputs are plugged, unplugged or resized mid-run.
+// Coordinates are always interpreted against the layout seen at run start.
+type geometryWatcher struct {
+	baseline []Monitor
+	current  []Monitor
+	dirty    atomic.Bool
+	// subscribed is set while xev delivers notifications; without them
+	// the layout is re-queried before every step
+	subscribed atomic.Bool
+	// cancel ends the watcher's xev, before the run ends if another
+	// display replaces this one
+	cancel context.CancelFunc
+}
+
+// startGeometryWatcher records the starting layout and subscribes to RandR
+// notifications through xev, which runs under the run's supervisor.
+// Returns nil when monitors cannot be queried
+// (no X server, no xrandr) or the backend is not an X display, in which
+// case coordinates are used as-is.
+func startGeometryWatcher() *geometryWatcher {
+	monitors, err := queryMonitors()
+	if err != nil || len(monitors) == 0 {
+		return nil
+	}
+	watch, cancel := context.WithCancel(context.Background())
+	g := &geometryWatcher{baseline: monitors, current: monitors, cancel: cancel}
+
+	cmd := exec.Command("xev", "-root", "-event", "randr")
+	stdout, err := cmd.StdoutPipe()
+	if err == nil && cmd.Start() == nil {
+		g.subscribed.Store(true)
+		context.AfterFunc(watch, func() { cmd.Process.Kill() })
+		background.start("monitor watcher", func(ctx context.Context) error {
+			defer context.AfterFunc(ctx, cancel)()
+			scanner := bufio.NewScanner(stdout)
+			for scanner.Scan() {
+				if strings.HasPrefix(scanner.Text(), "RR") {
+					g.dirty.Store(true)
+				}
+			}
+			g.subscribed.Store(false)
+			err := cmd.Wait()
+			if watch.Err() != nil {
+				return nil // stopped
+			}
+			if err != nil {
+				return fmt.Errorf("xev: %v", commandError(err))
+			}
+			return fmt.Errorf("xev exited")
+		})
+	}
+	return g
+}
+
+// stop ends the watcher's subscription
+func (g *geometryWatcher) stop() {
+	if g != nil {
+		g.cancel()
+	}
+}
+
+// refresh re-queries the layout if it may have changed and returns a
+// description of the change, or "" if nothing changed.
+func (g *geometryWatcher) refresh() string {
+	if g == nil {
+		return ""
+	}
+	if g.subscribed.Load() && !g.dirty.Swap(false) {
+		return ""
+	}
+	monitors, err := queryMonitors()
+	if err != nil || sameLayout(monitors, g.current) {
+		return ""
+	}
+	previous := g.current
+	g.current = monitors
+	return fmt.Sprintf("monitor layout changed: %s -> %s", describeLayout(previous), describeLayout(monitors))
+}
+
+// mapPoint translates a point from the baseline layout into the current one,
+// keeping its relative position on the monitor it was authored against.
+func (g *geometryWatcher) mapPoint(x, y int) (int, int, error) {
+	if g == nil || sameLayout(g.baseline, g.current) {
+		return x, y, nil
+	}
+	for _, old := range g.baseline {
+		if !old.contains(x, y) {
+			continue
+		}
+		for _, cur := range g.current {
+			if cur.Name != old.Name {
+				continue
+			}
+			nx := cur.X + (x-old.X)*cur.Width/old.Width
+			ny := cur.Y + (y-old.Y)*cur.Height/old.Height
+			return nx, ny, nil
+		}
+		return 0, 0, fmt.Errorf("monitor %s was disconnected; refusing to act on stale geometry at %d,%d", old.Name, x, y)
+	}
+	return 0, 0, fmt.Errorf("point %d,%d is outside the monitor layout the run started with", x, y)
+}
+
+func describeLayout(monitors []Monitor) string {
+	parts := make([]string, len(monitors))
+	for i, m := range monitors {
+		parts[i] = fmt.Sprintf("%s %dx%d+%d+%d", m.Name, m.Width, m.Height, m.X, m.Y)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// coordinateParams lists the x/y param pairs that carry screen
+// coordinates, with the param naming the monitor they are relative to
+var coordinateParams = [][3]string{{"x", "y", "monitor"}, {"x1", "y1", "monitor1"}, {"x2", "y2", "monitor2"}}
+
+// remapCommand rewrites a command's coordinates for the current layout.
+// Coordinates on a named monitor already follow it.
+func (g *geometryWatcher) remapCommand(cmd *Command) error {
+	for _, pair := range coordinateParams {
+		if _, ok := cmd.Params[pair[2]]; ok {
+			continue
+		}
+		x, okX := cmd.Params[pair[0]].(int)
+		y, okY := cmd.Params[pair[1]].(int)
+		if !okX || !okY {
+			continue
+		}
+		nx, ny, err := g.mapPoint(x, y)
+		if err != nil {
+			return err
+		}
+		cmd.Params[pair[0]] = nx
+		cmd.Params[pair[1]] = ny
+	}
+	return nil
+}
+
+// parseCoordinate reads a script coordinate, N or MONITOR:N, giving 0 for
+// an unqualified one
+func parseCoordinate(s string) (value, monitor int, err error) {
+	if m, v, ok := strings.Cut(s, ":"); ok {
+		if monitor, err = strconv.Atoi(m); err != nil || monitor < 1 {
+			return 0, 0, fmt.Errorf("bad monitor in %q", s)
+		}
+		s = v
+	}
+	value, err = strconv.Atoi(s)
+	return value, monitor, err
+}
+
+// parsePoint sets a coordinate pair's params from its script words. Both
+// must be on the same monitor, or neither. Unqualified words that are not
+// numbers read as 0, as they always have; qualified ones must parse.
+func parsePoint(cmd *Command, pair [3]string, xs, ys string) bool {
+	x, mx, errX := parseCoordinate(xs)
+	y, my, errY := parseCoordinate(ys)
+	qualified := strings.Contains(xs, ":") || strings.Contains(ys, ":")
+	if mx != my || (qualified && (errX != nil || errY != nil)) {
+		return false
+	}
+	cmd.Params[pair[0]] = x
+	cmd.Params[pair[1]] = y
+	if mx > 0 {
+		cmd.Params[pair[2]] = mx
+	}
+	return true
+}
+
+// findMonitor returns the monitor with an index, by the current layout
+func findMonitor(index int) (Monitor, error) {
+	monitors, err := queryMonitors()
+	if err != nil {
+		return Monitor{}, err
+	}
+	for _, m := range monitors {
+		if m.Index == index {
+			return m, nil
+		}
+	}
+	return Monitor{}, fmt.Errorf("there is no monitor %d (%d connected)", index, len(monitors))
+}
+
+// resolveMonitorCoordinates turns a command's monitor-relative coordinates
+// into screen coordinates
+func resolveMonitorCoordinates(cmd *Command) error {
+	for _, pair := range coordinateParams {
+		index, ok := cmd.Params[pair[2]].(int)
+		if !ok {
+			continue
+		}
+		m, err := findMonitor(index)
+		if err != nil {
+			return err
+		}
+		x, y := cmd.Params[pair[0]].(int), cmd.Params[pair[1]].(int)
+		if x < 0 || y < 0 || x >= m.Width || y >= m.Height {
+			return fmt.Errorf("%d,%d is outside monitor %d (%dx%d)", x, y, index, m.Width, m.Height)
+		}
+		cmd.Params[pair[0]] = m.X + x
+		cmd.Params[pair[1]] = m.Y + y
+		delete(cmd.Params, pair[2])
+	}
+	return nil
+}
+
+// monitorScreenshot saves the part of the screen one monitor shows
+func monitorScreenshot(filename string, index int) (string, error) {
+	m, err := findMonitor(index)
+	if err != nil {
+		return "", err
+	}
+	screen, err := captureScreen()
+	if err != nil {
+		return "", err
+	}
+	defer releaseFrame(screen)
+	// Redaction regions and window masks are in root coordinates, so they
+	// are applied before the crop moves the monitor to the origin
+	redacted, err := redactFrame(screen)
+	if err != nil {
+		return "", fmt.Errorf("screenshot of monitor %d could not be redacted: %v", index, err)
+	}
+	if filepath.Ext(filename) == "" {
+		filename += ".png"
+	}
+	path := filepath.Join(screenshotsDir, filepath.Base(filename))
+	if err := writePNG(path, cropImage(redacted, image.Rect(m.X, m.Y, m.X+m.Width, m.Y+m.Height))); err != nil {
+		return "", err
+	}
+	return path, nil
+}
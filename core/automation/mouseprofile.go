@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// --mouse-profile human moves the pointer the way a hand does, for pages
+// that reject a pointer warping straight onto a button and for drag
+// handles that only follow motion events. Pointer moves of pointer, hover,
+// click, scroll and the click_ actions, and drags, follow a curved path
+// from where the pointer is, easing in and out, with a pixel of jitter
+// along the way, taking longer the farther they go:
+//
+//	executor --mouse-profile human script.gcode
+//
+// The default, direct, warps. A path is drawn from its own end points, so
+// a recorded run replays with the same moves. Backends that cannot report
+// the pointer position start from the last position the run moved to,
+// and the run's first move there warps.
+
+// mouseProfile is set by --mouse-profile
+var mouseProfile = "direct"
+
+var mouseProfiles = []string{"direct", "human"}
+
+// humanMoveInterval is the time between the points of a path
+const humanMoveInterval = 10 * time.Millisecond
+
+// lastPointer is where movePointer last moved the pointer, for backends
+// that cannot say where it is
+var lastPointer struct {
+	sync.Mutex
+	p     image.Point
+	known bool
+}
+
+func checkMouseProfile() error {
+	if !contains(mouseProfiles, mouseProfile) {
+		return fmt.Errorf("unknown --mouse-profile %q: want direct or human", mouseProfile)
+	}
+	return nil
+}
+
+// movePointer moves the pointer to x, y under --mouse-profile
+func movePointer(input Backend, x, y int) error {
+	if mouseProfile != "human" {
+		return input.MoveMouse(x, y)
+	}
+	from, ok := pointerPosition(input)
+	to := image.Pt(x, y)
+	if !ok {
+		return rememberPointer(to, input.MoveMouse(x, y))
+	}
+	return moveHuman(input, from, to, humanMoveTime(from, to))
+}
+
+// pointerPosition is where the pointer is, as far as is known
+func pointerPosition(input Backend) (image.Point, bool) {
+	if l, ok := input.(cursorLocator); ok {
+		if x, y, err := l.cursorPosition(); err == nil {
+			return image.Pt(x, y), true
+		}
+	}
+	lastPointer.Lock()
+	defer lastPointer.Unlock()
+	return lastPointer.p, lastPointer.known
+}
+
+func rememberPointer(p image.Point, err error) error {
+	if err == nil {
+		lastPointer.Lock()
+		lastPointer.p, lastPointer.known = p, true
+		lastPointer.Unlock()
+	}
+	return err
+}
+
+// humanMoveTime is how long a move takes: a little over a tenth of a
+// second for a short one, growing with the log of the distance as aimed
+// movements do, up to a second
+func humanMoveTime(from, to image.Point) time.Duration {
+	d := math.Hypot(float64(to.X-from.X), float64(to.Y-from.Y))
+	t := 80*time.Millisecond + time.Duration(120*math.Log2(1+d/10))*time.Millisecond
+	return min(t, time.Second)
+}
+
+// moveHuman moves the pointer from from to to along a curved, eased path
+// taking about took, ending exactly on to
+func moveHuman(input Backend, from, to image.Point, took time.Duration) error {
+	for _, p := range humanPath(from, to, int(took/humanMoveInterval)) {
+		if err := input.MoveMouse(p.X, p.Y); err != nil {
+			return err
+		}
+		clock.Sleep(humanMoveInterval)
+	}
+	return rememberPointer(to, input.MoveMouse(to.X, to.Y))
+}
+
+// humanPath is n points of a cubic Bézier curve from from towards to,
+// excluding both ends. Its control points sit off the straight line by up
+// to a fifth of its length, on a side and at distances drawn from a source
+// seeded with the end points.
+func humanPath(from, to image.Point, n int) []image.Point {
+	dx, dy := float64(to.X-from.X), float64(to.Y-from.Y)
+	length := math.Hypot(dx, dy)
+	if n < 2 || length < 2 {
+		return nil
+	}
+	rng := rand.New(rand.NewSource(int64(from.X)*73856093 ^ int64(from.Y)*19349663 ^ int64(to.X)*83492791 ^ int64(to.Y)))
+	// The unit normal of the straight line
+	nx, ny := -dy/length, dx/length
+	bend := func() float64 { return (rng.Float64()*2 - 1) * length / 5 }
+	b1, b2 := bend(), bend()
+	p0 := [2]float64{float64(from.X), float64(from.Y)}
+	p1 := [2]float64{p0[0] + dx/3 + nx*b1, p0[1] + dy/3 + ny*b1}
+	p2 := [2]float64{p0[0] + 2*dx/3 + nx*b2, p0[1] + 2*dy/3 + ny*b2}
+	p3 := [2]float64{float64(to.X), float64(to.Y)}
+	path := make([]image.Point, 0, n-1)
+	for i := 1; i < n; i++ {
+		// Smoothstep: slow to start, fast midway, slow to arrive
+		t := float64(i) / float64(n)
+		t = t * t * (3 - 2*t)
+		u := 1 - t
+		x := u*u*u*p0[0] + 3*u*u*t*p1[0] + 3*u*t*t*p2[0] + t*t*t*p3[0]
+		y := u*u*u*p0[1] + 3*u*u*t*p1[1] + 3*u*t*t*p2[1] + t*t*t*p3[1]
+		jitter := func() float64 { return float64(rng.Intn(3) - 1) }
+		path = append(path, image.Pt(int(math.Round(x+jitter())), int(math.Round(y+jitter()))))
+	}
+	return path
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NotifyConfig posts run messages to chat and mails reports, so whoever
+// is on call hears when an unattended workflow breaks. Tokens and
+// passwords take "env:NAME".
+type NotifyConfig struct {
+	Slack  []SlackNotify  `json:"slack"`
+	Matrix []MatrixNotify `json:"matrix"`
+	Email  []EmailNotify  `json:"email"`
+}
+
+// SlackNotify posts through an incoming webhook, or with a bot token to a
+// channel. Only a bot token can attach the failing screenshot; a webhook
+// links it when it has a URL.
+type SlackNotify struct {
+	Webhook string `json:"webhook_url"`
+	Token   string `json:"token"`
+	Channel string `json:"channel"` // channel ID, with token
+	// On lists the messages sent: start, finish, failure and handoff
+	// (default failure only). finish is a run that did not fail; handoff
+	// is a person taking over a run through --control and giving it back.
+	On []string `json:"on"`
+}
+
+// MatrixNotify posts to a Matrix room as the user of the access token
+type MatrixNotify struct {
+	Homeserver  string   `json:"homeserver"` // e.g. https://matrix.org
+	AccessToken string   `json:"access_token"`
+	Room        string   `json:"room_id"`
+	On          []string `json:"on"`
+}
+
+var notifyKinds = []string{"start", "finish", "failure", "handoff"}
+
+// notifyScript names the script in messages, when it came from a file
+var notifyScript string
+
+const notifyTimeout = 15 * time.Second
+
+// notifyMessage is one message for every configured notifier
+type notifyMessage struct {
+	kind string
+	text string
+	shot string // screenshot file to attach, if any
+	// result is the finished run, for finish and failure messages
+	result *ExecutionResult
+}
+
+// checkNotifyConfig reports notifiers that cannot work before a run
+func checkNotifyConfig(cfg NotifyConfig) error {
+	for i, n := range cfg.Slack {
+		if n.Webhook == "" && (n.Token == "" || n.Channel == "") {
+			return fmt.Errorf("notify.slack[%d]: needs webhook_url, or token and channel", i)
+		}
+		if err := checkNotifyKinds(n.On); err != nil {
+			return fmt.Errorf("notify.slack[%d].on: %v", i, err)
+		}
+	}
+	for i, n := range cfg.Matrix {
+		if n.Homeserver == "" || n.AccessToken == "" || n.Room == "" {
+			return fmt.Errorf("notify.matrix[%d]: needs homeserver, access_token and room_id", i)
+		}
+		if err := checkNotifyKinds(n.On); err != nil {
+			return fmt.Errorf("notify.matrix[%d].on: %v", i, err)
+		}
+	}
+	for i, n := range cfg.Email {
+		if err := checkEmailNotify(i, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkNotifyKinds(kinds []string) error {
+	for _, k := range kinds {
+		if !contains(notifyKinds, k) {
+			return fmt.Errorf("unknown message %q (want start, finish, failure or handoff)", k)
+		}
+	}
+	return nil
+}
+
+func notifyWants(on []string, kind string) bool {
+	if len(on) == 0 {
+		return kind == "failure"
+	}
+	return contains(on, kind)
+}
+
+// notifyStart announces a run
+func (r *runner) notifyStart() {
+	r.notify(notifyMessage{kind: "start", text: fmt.Sprintf("▶️ %s started", runName())})
+}
+
+// notifyFinish announces how a run ended, attaching the screenshot of the
+// first failing step
+func (r *runner) notifyFinish() {
+	res := &r.result
+	took := since(r.started).Round(time.Millisecond)
+	if res.Status != "error" {
+		r.notify(notifyMessage{kind: "finish", result: res,
+			text: fmt.Sprintf("✅ %s finished %s: %d steps in %s", runName(), res.Status, res.CommandsExecuted, took)})
+		return
+	}
+	msg := notifyMessage{kind: "failure", result: res}
+	text := fmt.Sprintf("❌ %s failed after %s", runName(), took)
+	if len(res.Errors) > 0 {
+		text += "\n" + res.Errors[0]
+		if more := len(res.Errors) - 1; more > 0 {
+			text += fmt.Sprintf(" (and %d more errors)", more)
+		}
+		var step int
+		if _, err := fmt.Sscanf(res.Errors[0], "Step %d:", &step); err == nil {
+			for _, shot := range res.Screenshots {
+				if shot.Step == step {
+					msg.shot = shot.File
+					if link := shotURL(shot); link != "" {
+						text += "\n" + link
+					}
+				}
+			}
+		}
+	}
+	msg.text = text
+	r.notify(msg)
+}
+
+// runName describes the run in messages
+func runName() string {
+	name := "AgentOS run"
+	if notifyScript != "" {
+		name += " of " + filepath.Base(notifyScript)
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += " on " + host
+	}
+	if tenant != "" {
+		name += " for " + tenant
+	}
+	return name
+}
+
+// notify sends msg to every notifier that wants it. Failures are events;
+// they never fail the run.
+func (r *runner) notify(msg notifyMessage) {
+	cfg, err := currentConfig()
+	if err != nil {
+		return
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	fail := func(target string, err error) {
+		r.result.Events = append(r.result.Events, Event{Step: r.step, Type: "notify_error",
+			Message: fmt.Sprintf("%s %s message: %v", target, msg.kind, err)})
+	}
+	for _, n := range cfg.Notify.Slack {
+		if notifyWants(n.On, msg.kind) {
+			if err := n.send(client, msg); err != nil {
+				fail("slack", err)
+			}
+		}
+	}
+	for _, n := range cfg.Notify.Matrix {
+		if notifyWants(n.On, msg.kind) {
+			if err := n.send(client, msg); err != nil {
+				fail("matrix", err)
+			}
+		}
+	}
+	for _, n := range cfg.Notify.Email {
+		if notifyWants(n.On, msg.kind) {
+			if err := n.send(msg); err != nil {
+				fail("email", err)
+			}
+		}
+	}
+}
+
+// withoutURL drops the URL from a client error, for URLs that are
+// secrets themselves, as webhooks and signed upload links are
+func withoutURL(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return fmt.Errorf("%s: %v", ue.Op, ue.Err)
+	}
+	return err
+}
+
+func (n SlackNotify) send(client *http.Client, msg notifyMessage) error {
+	token := secretValue(n.Token)
+	if token != "" && n.Channel != "" {
+		if msg.shot != "" {
+			return n.upload(client, token, msg)
+		}
+		var resp slackResponse
+		return slackCall(client, token, "chat.postMessage", map[string]interface{}{"channel": n.Channel, "text": msg.text}, &resp)
+	}
+	body, _ := json.Marshal(map[string]string{"text": msg.text})
+	resp, err := client.Post(secretValue(n.Webhook), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// upload shares the screenshot to the channel with the text as its
+// comment, through Slack's external upload flow
+func (n SlackNotify) upload(client *http.Client, token string, msg notifyMessage) error {
+	data, err := os.ReadFile(msg.shot)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(msg.shot)
+	form := url.Values{"filename": {name}, "length": {strconv.Itoa(len(data))}}
+	req, err := http.NewRequest(http.MethodPost, "https://slack.com/api/files.getUploadURLExternal", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+	var target struct {
+		slackResponse
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	if err := slackDo(client, req, &target); err != nil {
+		return fmt.Errorf("files.getUploadURLExternal: %v", err)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	part, _ := mw.CreateFormFile("file", name)
+	part.Write(data)
+	mw.Close()
+	resp, err := client.Post(target.UploadURL, mw.FormDataContentType(), &buf)
+	if err != nil {
+		return withoutURL(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("uploading %s: HTTP %d", name, resp.StatusCode)
+	}
+
+	var done slackResponse
+	return slackCall(client, token, "files.completeUploadExternal", map[string]interface{}{
+		"files":           []map[string]string{{"id": target.FileID, "title": name}},
+		"channel_id":      n.Channel,
+		"initial_comment": msg.text,
+	}, &done)
+}
+
+// slackResponse is the envelope of every Slack Web API response
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func (s slackResponse) failure() error {
+	if s.OK {
+		return nil
+	}
+	return fmt.Errorf("%s", s.Error)
+}
+
+func slackCall(client *http.Client, token, method string, args interface{}, out interface{ failure() error }) error {
+	body, _ := json.Marshal(args)
+	req, err := http.NewRequest(http.MethodPost, "https://slack.com/api/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if err := slackDo(client, req, out); err != nil {
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	return nil
+}
+
+func slackDo(client *http.Client, req *http.Request, out interface{ failure() error }) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return err
+	}
+	return out.failure()
+}
+
+func (n MatrixNotify) send(client *http.Client, msg notifyMessage) error {
+	if err := n.message(client, map[string]interface{}{"msgtype": "m.text", "body": msg.text}); err != nil {
+		return err
+	}
+	if msg.shot == "" {
+		return nil
+	}
+	data, err := os.ReadFile(msg.shot)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(msg.shot)
+	var uploaded struct {
+		ContentURI string `json:"content_uri"`
+	}
+	err = n.do(client, http.MethodPost, "/_matrix/media/v3/upload?filename="+url.QueryEscape(name), "image/png", data, &uploaded)
+	if err != nil {
+		return fmt.Errorf("uploading %s: %v", name, err)
+	}
+	return n.message(client, map[string]interface{}{
+		"msgtype": "m.image", "body": name, "url": uploaded.ContentURI,
+		"info": map[string]interface{}{"mimetype": "image/png", "size": len(data)},
+	})
+}
+
+// message sends an m.room.message event to the room
+func (n MatrixNotify) message(client *http.Client, content map[string]interface{}) error {
+	body, _ := json.Marshal(content)
+	txn := fmt.Sprintf("agentos-%d", time.Now().UnixNano())
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(n.Room) + "/send/m.room.message/" + txn
+	return n.do(client, http.MethodPut, path, "application/json", body, nil)
+}
+
+func (n MatrixNotify) do(client *http.Client, method, path, contentType string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, strings.TrimSuffix(n.Homeserver, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+secretValue(n.AccessToken))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&failure)
+		return fmt.Errorf("HTTP %d %s", resp.StatusCode, failure.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OCRWord is one recognized word in screen coordinates
+type OCRWord struct {
+	Text       string  `json:"text"`
+	Confidence float64 `json:"confidence"` // 0..1
+	X          int     `json:"x"`
+	Y          int     `json:"y"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+}
+
+// OCRProvider recognizes words in an image
+type OCRProvider interface {
+	Name() string
+	Recognize(img image.Image) ([]OCRWord, error)
+}
+
+// OCRConfig selects and configures the OCR provider
+type OCRConfig struct {
+	Provider      string  `json:"provider"`       // tesseract (default), paddle, cloud-vision or local
+	Language      string  `json:"language"`       // tesseract language, e.g. "eng+deu"
+	Endpoint      string  `json:"endpoint"`       // paddle / cloud-vision URL, or local socket path
+	APIKey        string  `json:"api_key"`        // cloud-vision key, or "env:NAME"
+	MinConfidence float64 `json:"min_confidence"` // drop words below this
+	TimeoutSec    float64 `json:"timeout_seconds"`
+	// FullReads reads the whole screen every time instead of only what
+	// changed since the last read
+	FullReads bool `json:"full_reads"`
+}
+
+var ocrProviders = map[string]func(OCRConfig) OCRProvider{
+	"tesseract": func(c OCRConfig) OCRProvider { return &tesseractOCR{language: c.Language} },
+	"mock":      func(c OCRConfig) OCRProvider { return mockOCR{} },
+	"paddle":    func(c OCRConfig) OCRProvider { return &paddleOCR{endpoint: c.Endpoint, client: ocrHTTPClient(c)} },
+	"cloud-vision": func(c OCRConfig) OCRProvider {
+		return &cloudVisionOCR{endpoint: c.Endpoint, apiKey: secretValue(c.APIKey), client: ocrHTTPClient(c)}
+	},
+	"local": func(c OCRConfig) OCRProvider {
+		return &localOCR{language: c.Language, peer: newLocalPerception(c.Endpoint, c.TimeoutSec)}
+	},
+}
+
+func ocrHTTPClient(c OCRConfig) *http.Client {
+	timeout := 30 * time.Second
+	if c.TimeoutSec > 0 {
+		timeout = time.Duration(c.TimeoutSec * float64(time.Second))
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// currentOCR returns the configured OCR provider
+func currentOCR() (OCRProvider, OCRConfig, error) {
+	cfg, err := currentConfig()
+	if err != nil {
+		return nil, OCRConfig{}, err
+	}
+	name := cfg.OCR.Provider
+	if name == "" {
+		name = "tesseract"
+		if currentBackend().Name() == "mock" {
+			name = "mock"
+		}
+	}
+	factory, ok := ocrProviders[name]
+	if !ok {
+		return nil, cfg.OCR, fmt.Errorf("unknown OCR provider: %s", name)
+	}
+	return factory(cfg.OCR), cfg.OCR, nil
+}
+
+// recognizeScreen runs OCR over a region of the screen (the whole screen if
+// region is empty) and returns words in screen coordinates
+func recognizeScreen(region image.Rectangle) ([]OCRWord, error) {
+	screen, err := captureScreen()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseFrame(screen)
+	return recognizeFrame(screen, region)
+}
+
+// recognizeFrame is recognizeScreen over an already captured frame
+func recognizeFrame(frame image.Image, region image.Rectangle) ([]OCRWord, error) {
+	provider, cfg, err := currentOCR()
+	if err != nil {
+		return nil, err
+	}
+	var words []OCRWord
+	if region.Empty() && !cfg.FullReads {
+		words, err = recognizeDifferential(provider, provider.Name()+"\x00"+cfg.Language+"\x00"+cfg.Endpoint, frame)
+	} else {
+		img := frame
+		if !region.Empty() {
+			region = region.Intersect(frame.Bounds())
+			img = cropImage(frame, region)
+		}
+		words, err = provider.Recognize(img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s OCR: %v", provider.Name(), err)
+	}
+	kept := words[:0]
+	for _, w := range words {
+		if w.Confidence < cfg.MinConfidence {
+			continue
+		}
+		w.X += region.Min.X
+		w.Y += region.Min.Y
+		kept = append(kept, w)
+	}
+	return kept, nil
+}
+
+// cropImage copies r out of img into a new image with a zero origin
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	if frame, ok := img.(*mockFrame); ok {
+		return frame.crop(r)
+	}
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := 0; y < r.Dy(); y++ {
+			start := rgba.PixOffset(r.Min.X, r.Min.Y+y)
+			copy(out.Pix[y*out.Stride:], rgba.Pix[start:start+4*r.Dx()])
+		}
+		return out
+	}
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			out.Set(x, y, img.At(r.Min.X+x, r.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// findText looks for text (one or more words, case-insensitive) among
+// recognized words and returns the bounding box of the first match
+func findText(words []OCRWord, text string) (OCRWord, bool) {
+	matches := findAllText(words, text)
+	if len(matches) == 0 {
+		return OCRWord{}, false
+	}
+	return matches[0], true
+}
+
+// findAllText returns the bounding boxes of every match of text, in the
+// words' order
+func findAllText(words []OCRWord, text string) []OCRWord {
+	target := strings.Fields(strings.ToLower(text))
+	if len(target) == 0 {
+		return nil
+	}
+	var matches []OCRWord
+	for i := 0; i+len(target) <= len(words); i++ {
+		match := true
+		for j, t := range target {
+			w := strings.ToLower(strings.Trim(words[i+j].Text, ".,:;!?\"'()"))
+			// The last word may be a prefix ("Save" matches "Save...")
+			if w != t && !(j == len(target)-1 && strings.HasPrefix(w, t)) {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		box := words[i]
+		box.Text = text
+		for _, w := range words[i+1 : i+len(target)] {
+			right := max(box.X+box.Width, w.X+w.Width)
+			bottom := max(box.Y+box.Height, w.Y+w.Height)
+			box.X, box.Y = min(box.X, w.X), min(box.Y, w.Y)
+			box.Width, box.Height = right-box.X, bottom-box.Y
+			box.Confidence = min(box.Confidence, w.Confidence)
+		}
+		matches = append(matches, box)
+	}
+	return matches
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tesseractOCR runs the local tesseract binary
+type tesseractOCR struct {
+	language string
+}
+
+func (t *tesseractOCR) Name() string { return "tesseract" }
+
+func (t *tesseractOCR) Recognize(img image.Image) ([]OCRWord, error) {
+	data, err := encodePNG(img)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"stdin", "stdout"}
+	if t.language != "" {
+		args = append(args, "-l", t.language)
+	}
+	args = append(args, "tsv")
+	cmd := exec.Command("tesseract", args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = io.Discard
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseTesseractTSV(out)
+}
+
+// parseTesseractTSV reads word rows (level 5) of tesseract's TSV output:
+// level page block par line word left top width height conf text
+func parseTesseractTSV(out []byte) ([]OCRWord, error) {
+	r := csv.NewReader(bytes.NewReader(out))
+	r.Comma = '\t'
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var words []OCRWord
+	for _, row := range rows {
+		if len(row) < 12 || row[0] != "5" {
+			continue
+		}
+		text := strings.TrimSpace(row[11])
+		conf, _ := strconv.ParseFloat(row[10], 64)
+		if text == "" || conf < 0 {
+			continue
+		}
+		x, _ := strconv.Atoi(row[6])
+		y, _ := strconv.Atoi(row[7])
+		w, _ := strconv.Atoi(row[8])
+		h, _ := strconv.Atoi(row[9])
+		words = append(words, OCRWord{Text: text, Confidence: conf / 100, X: x, Y: y, Width: w, Height: h})
+	}
+	return words, nil
+}
+
+// paddleOCR talks to a PaddleHub ocr_system serving endpoint
+type paddleOCR struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (p *paddleOCR) Name() string { return "paddle" }
+
+func (p *paddleOCR) Recognize(img image.Image) ([]OCRWord, error) {
+	endpoint := p.endpoint
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:8868/predict/ocr_system"
+	}
+	data, err := encodePNG(img)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"images": []string{base64.StdEncoding.EncodeToString(data)},
+	})
+	var resp struct {
+		Msg     string `json:"msg"`
+		Results [][]struct {
+			Text       string       `json:"text"`
+			Confidence float64      `json:"confidence"`
+			Region     [][2]float64 `json:"text_region"`
+		} `json:"results"`
+	}
+	if err := postJSON(p.client, endpoint, body, &resp); err != nil {
+		return nil, err
+	}
+	var words []OCRWord
+	for _, result := range resp.Results {
+		for _, line := range result {
+			x, y, w, h := polygonBounds(line.Region)
+			// PaddleOCR recognizes whole lines; split them into words with
+			// an even share of the box so phrase matching still works
+			fields := strings.Fields(line.Text)
+			total := len([]rune(strings.Join(fields, " ")))
+			offset := 0
+			for _, f := range fields {
+				n := len([]rune(f))
+				words = append(words, OCRWord{
+					Text:       f,
+					Confidence: line.Confidence,
+					X:          x + w*offset/max(total, 1),
+					Y:          y,
+					Width:      w * n / max(total, 1),
+					Height:     h,
+				})
+				offset += n + 1
+			}
+		}
+	}
+	return words, nil
+}
+
+// cloudVisionOCR uses the Google Cloud Vision images:annotate API
+type cloudVisionOCR struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+func (c *cloudVisionOCR) Name() string { return "cloud-vision" }
+
+func (c *cloudVisionOCR) Recognize(img image.Image) ([]OCRWord, error) {
+	endpoint := c.endpoint
+	if endpoint == "" {
+		endpoint = "https://vision.googleapis.com/v1/images:annotate"
+	}
+	if c.apiKey == "" {
+		return nil, fmt.Errorf("no api_key configured")
+	}
+	data, err := encodePNG(img)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"requests": []interface{}{map[string]interface{}{
+			"image":    map[string]string{"content": base64.StdEncoding.EncodeToString(data)},
+			"features": []map[string]string{{"type": "DOCUMENT_TEXT_DETECTION"}},
+		}},
+	})
+	type vertex struct{ X, Y float64 }
+	var resp struct {
+		Responses []struct {
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+			FullTextAnnotation struct {
+				Pages []struct {
+					Blocks []struct {
+						Paragraphs []struct {
+							Words []struct {
+								Confidence  float64 `json:"confidence"`
+								BoundingBox struct {
+									Vertices []vertex `json:"vertices"`
+								} `json:"boundingBox"`
+								Symbols []struct {
+									Text string `json:"text"`
+								} `json:"symbols"`
+							} `json:"words"`
+						} `json:"paragraphs"`
+					} `json:"blocks"`
+				} `json:"pages"`
+			} `json:"fullTextAnnotation"`
+		} `json:"responses"`
+	}
+	// The key goes in a header: an error from the client quotes the URL
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Goog-Api-Key", c.apiKey)
+	if err := doJSON(c.client, req, &resp); err != nil {
+		return nil, err
+	}
+	var words []OCRWord
+	for _, r := range resp.Responses {
+		if r.Error != nil {
+			return nil, fmt.Errorf("%s", r.Error.Message)
+		}
+		for _, page := range r.FullTextAnnotation.Pages {
+			for _, block := range page.Blocks {
+				for _, para := range block.Paragraphs {
+					for _, w := range para.Words {
+						var text strings.Builder
+						for _, s := range w.Symbols {
+							text.WriteString(s.Text)
+						}
+						points := make([][2]float64, len(w.BoundingBox.Vertices))
+						for i, v := range w.BoundingBox.Vertices {
+							points[i] = [2]float64{v.X, v.Y}
+						}
+						x, y, width, height := polygonBounds(points)
+						words = append(words, OCRWord{Text: text.String(), Confidence: w.Confidence, X: x, Y: y, Width: width, Height: height})
+					}
+				}
+			}
+		}
+	}
+	return words, nil
+}
+
+func polygonBounds(points [][2]float64) (x, y, w, h int) {
+	if len(points) == 0 {
+		return 0, 0, 0, 0
+	}
+	minX, minY, maxX, maxY := points[0][0], points[0][1], points[0][0], points[0][1]
+	for _, p := range points[1:] {
+		minX, maxX = min(minX, p[0]), max(maxX, p[0])
+		minY, maxY = min(minY, p[1]), max(maxY, p[1])
+	}
+	return int(minX), int(minY), int(maxX - minX), int(maxY - minY)
+}
+
+// postJSON posts a JSON body and decodes a JSON response
+func postJSON(client *http.Client, url string, body []byte, out interface{}) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doJSON(client, req, out)
+}
+
+// doJSON sends a request and decodes a JSON response
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ocrRegionFromParams reads an optional x y w h region from command params
+func ocrRegionFromParams(params map[string]interface{}) image.Rectangle {
+	w, ok := params["w"].(int)
+	if !ok {
+		return image.Rectangle{}
+	}
+	x := params["x"].(int)
+	y := params["y"].(int)
+	h := params["h"].(int)
+	return image.Rect(x, y, x+w, y+h)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"hash/fnv"
+	"image"
+	"sort"
+	"sync"
+)
+
+// Differential OCR. Whole-screen reads remember the words of the last
+// frame with a hash of each strip of ocrStripHeight pixel rows. The next
+// read hashes the new frame's strips and recognizes only the bands of
+// strips that changed, widened to take in the remembered words they cut
+// through, keeping the remembered words elsewhere; a frame with nothing
+// changed is not recognized at all. Bands run the full width of the
+// screen so no line of text is split. A new screen size, another provider
+// or more than half the screen changed reads the whole frame again, as
+// does ocr.full_reads in the config. Region reads always read their
+// region.
+
+// ocrStripHeight is the height of the strips compared between frames
+const ocrStripHeight = 16
+
+// ocrMaxChanged is the part of the screen above which a read is of the
+// whole frame
+const ocrMaxChanged = 0.5
+
+// ocrRead is a whole-screen read
+type ocrRead struct {
+	key    string // the provider and settings that read it
+	size   image.Point
+	strips []uint64
+	words  []OCRWord // before the confidence filter
+}
+
+// lastOCR is the last whole-screen read
+var lastOCR struct {
+	sync.Mutex
+	read ocrRead
+}
+
+// ocrBand is a horizontal band of the frame, rows y0 to y1
+type ocrBand struct{ y0, y1 int }
+
+// recognizeDifferential reads frame, only where it changed since the last
+// read. It returns the unfiltered words in reading order.
+func recognizeDifferential(provider OCRProvider, key string, frame image.Image) ([]OCRWord, error) {
+	rgba := frameRGBA(frame)
+	if rgba == nil {
+		return provider.Recognize(frame)
+	}
+	size := frame.Bounds().Size()
+	strips := stripHashes(rgba)
+
+	lastOCR.Lock()
+	cached := lastOCR.read
+	lastOCR.Unlock()
+
+	var words []OCRWord
+	var err error
+	bands, ok := changedBands(cached, key, size, strips)
+	switch {
+	case !ok:
+		words, err = provider.Recognize(frame)
+		countOCR(false)
+	case len(bands) == 0:
+		words = cached.words
+		countOCR(true)
+	default:
+		words, err = rereadBands(provider, frame, cached.words, bands)
+		countOCR(true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lastOCR.Lock()
+	lastOCR.read = ocrRead{key: key, size: size, strips: strips, words: words}
+	lastOCR.Unlock()
+	return append([]OCRWord(nil), words...), nil
+}
+
+// frameRGBA is the pixels of a captured frame, or nil if it has none to
+// hash
+func frameRGBA(frame image.Image) *image.RGBA {
+	switch f := frame.(type) {
+	case *image.RGBA:
+		return f
+	case *mockFrame:
+		return f.RGBA
+	}
+	return nil
+}
+
+// stripHashes hashes each strip of the frame's rows
+func stripHashes(img *image.RGBA) []uint64 {
+	b := img.Bounds()
+	var hashes []uint64
+	for y := b.Min.Y; y < b.Max.Y; y += ocrStripHeight {
+		h := fnv.New64a()
+		for row := y; row < min(y+ocrStripHeight, b.Max.Y); row++ {
+			start := img.PixOffset(b.Min.X, row)
+			h.Write(img.Pix[start : start+4*b.Dx()])
+		}
+		hashes = append(hashes, h.Sum64())
+	}
+	return hashes
+}
+
+// changedBands lists the bands to read again, or reports false if the
+// whole frame must be read
+func changedBands(cached ocrRead, key string, size image.Point, strips []uint64) ([]ocrBand, bool) {
+	if cached.key != key || cached.size != size || len(cached.strips) != len(strips) {
+		return nil, false
+	}
+	var bands []ocrBand
+	for i := range strips {
+		if strips[i] == cached.strips[i] {
+			continue
+		}
+		// A strip of margin either side catches text drawn across the edge
+		y0, y1 := max(0, (i-1)*ocrStripHeight), min(size.Y, (i+2)*ocrStripHeight)
+		if n := len(bands); n > 0 && y0 <= bands[n-1].y1 {
+			bands[n-1].y1 = y1
+		} else {
+			bands = append(bands, ocrBand{y0, y1})
+		}
+	}
+	bands = widenBands(bands, cached.words)
+	changed := 0
+	for _, b := range bands {
+		changed += b.y1 - b.y0
+	}
+	if float64(changed) > ocrMaxChanged*float64(size.Y) {
+		return nil, false
+	}
+	return bands, true
+}
+
+// widenBands grows bands over the words they cut through, merging those
+// that come to overlap, so a word is read whole or not at all
+func widenBands(bands []ocrBand, words []OCRWord) []ocrBand {
+	for grown := true; grown; {
+		grown = false
+		for i := range bands {
+			for _, w := range words {
+				if w.Y < bands[i].y1 && w.Y+w.Height > bands[i].y0 && (w.Y < bands[i].y0 || w.Y+w.Height > bands[i].y1) {
+					bands[i].y0, bands[i].y1 = min(bands[i].y0, w.Y), max(bands[i].y1, w.Y+w.Height)
+					grown = true
+				}
+			}
+		}
+		merged := bands[:0]
+		for _, b := range bands {
+			if n := len(merged); n > 0 && b.y0 <= merged[n-1].y1 {
+				merged[n-1].y1 = max(merged[n-1].y1, b.y1)
+				continue
+			}
+			merged = append(merged, b)
+		}
+		bands = merged
+	}
+	return bands
+}
+
+// rereadBands recognizes the bands and merges their words with the cached
+// words outside them, in reading order
+func rereadBands(provider OCRProvider, frame image.Image, cached []OCRWord, bands []ocrBand) ([]OCRWord, error) {
+	inBand := func(w OCRWord) int {
+		for i, b := range bands {
+			if w.Y < b.y1 && w.Y+w.Height > b.y0 {
+				return i
+			}
+		}
+		return -1
+	}
+	var words []OCRWord
+	for _, w := range cached {
+		if inBand(w) < 0 {
+			words = append(words, w)
+		}
+	}
+	width := frame.Bounds().Dx()
+	for _, b := range bands {
+		read, err := provider.Recognize(cropImage(frame, image.Rect(0, b.y0, width, b.y1).Add(frame.Bounds().Min)))
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range read {
+			w.Y += b.y0
+			words = append(words, w)
+		}
+	}
+	// Bands and the stretches between them follow each other down the
+	// screen; within each the provider's order stands
+	section := func(w OCRWord) int {
+		if i := inBand(w); i >= 0 {
+			return bands[i].y0
+		}
+		top := 0
+		for _, b := range bands {
+			if b.y1 <= w.Y {
+				top = b.y1
+			}
+		}
+		return top
+	}
+	sort.SliceStable(words, func(i, j int) bool { return section(words[i]) < section(words[j]) })
+	return words, nil
+}
+
+// countOCR counts a whole-screen read in the cache statistics: a hit when
+// any of the last read was reused
+func countOCR(hit bool) {
+	warm.mu.Lock()
+	defer warm.mu.Unlock()
+	if hit {
+		warm.stats.OCR.Hits++
+	} else {
+		warm.stats.OCR.Misses++
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// apiVersion is the version of the HTTP API described by /openapi.json
+const apiVersion = "1.1.0"
+
+type jsonObject = map[string]interface{}
+
+func schemaRef(name string) jsonObject {
+	return jsonObject{"$ref": "#/components/schemas/" + name}
+}
+
+func arrayOf(items jsonObject) jsonObject {
+	return jsonObject{"type": "array", "items": items}
+}
+
+func jsonContent(schema jsonObject) jsonObject {
+	return jsonObject{"application/json": jsonObject{"schema": schema}}
+}
+
+// openAPIDocument describes serve mode's endpoints and every script action
+func openAPIDocument(serverURL string) jsonObject {
+	schemas := jsonObject{
+		"Screenshot": jsonObject{
+			"type": "object",
+			"properties": jsonObject{
+				"step":     jsonObject{"type": "integer"},
+				"name":     jsonObject{"type": "string", "description": "The step's @name"},
+				"file":     jsonObject{"type": "string", "description": "Path on the executor host"},
+				"action":   jsonObject{"type": "string"},
+				"url":      jsonObject{"type": "string", "description": "Signed, expiring download link"},
+				"uploaded": jsonObject{"type": "string", "description": "Where --push uploaded the file"},
+			},
+			"required": []string{"step", "file", "action"},
+		},
+		"Event": jsonObject{
+			"type": "object",
+			"properties": jsonObject{
+				"step":    jsonObject{"type": "integer"},
+				"type":    jsonObject{"type": "string", "examples": []string{"geometry_changed", "upload_failed", "chaos", "idempotent_skip", "aborted", "rollback", "setup_failed", "notifications", "over_budget", "transcript_error", "notify_error", "retry", "audio", "audio_error"}},
+				"message": jsonObject{"type": "string"},
+			},
+		},
+		"CacheCounts": jsonObject{
+			"type": "object",
+			"properties": jsonObject{
+				"hits":    jsonObject{"type": "integer"},
+				"misses":  jsonObject{"type": "integer"},
+				"entries": jsonObject{"type": "integer"},
+			},
+		},
+		"CacheStats": jsonObject{
+			"type":        "object",
+			"description": "Warm cache use since the executor started; in serve mode this spans runs",
+			"properties": jsonObject{
+				"templates": schemaRef("CacheCounts"),
+				"regexps":   schemaRef("CacheCounts"),
+				"a11y":      schemaRef("CacheCounts"),
+			},
+		},
+		"FrameHeader": jsonObject{
+			"type":        "object",
+			"description": "Sent over /frames before each binary frame",
+			"properties": jsonObject{
+				"seq":     jsonObject{"type": "integer"},
+				"codec":   jsonObject{"type": "string", "enum": frameCodecs},
+				"quality": jsonObject{"type": "integer", "description": "JPEG quality"},
+				"width":   jsonObject{"type": "integer"},
+				"height":  jsonObject{"type": "integer"},
+				"delta":   jsonObject{"type": "boolean", "description": "zstd-raw pixels are XORed with the previous frame"},
+				"bytes":   jsonObject{"type": "integer"},
+				"time_ms": jsonObject{"type": "integer"},
+			},
+		},
+		"StepOutput": jsonObject{
+			"type": "object",
+			"properties": jsonObject{
+				"step":   jsonObject{"type": "integer"},
+				"name":   jsonObject{"type": "string", "description": "The step's @name"},
+				"action": jsonObject{"type": "string"},
+				"data":   jsonObject{"type": "object", "additionalProperties": true},
+			},
+		},
+		"ExecutionResult": jsonObject{
+			"type": "object",
+			"properties": jsonObject{
+				"status":             jsonObject{"type": "string", "enum": []string{"success", "degraded", "error"}},
+				"commands_executed":  jsonObject{"type": "integer"},
+				"screenshots":        arrayOf(schemaRef("Screenshot")),
+				"errors":             arrayOf(jsonObject{"type": "string"}),
+				"events":             arrayOf(schemaRef("Event")),
+				"outputs":            arrayOf(schemaRef("StepOutput")),
+				"filmstrip":          jsonObject{"type": "string", "description": "Animation of the step screenshots, with --filmstrip"},
+				"cache":              schemaRef("CacheStats"),
+				"filmstrip_uploaded": jsonObject{"type": "string", "description": "Where --push sent the filmstrip"},
+			},
+			"required": []string{"status", "commands_executed", "screenshots", "errors"},
+		},
+		"Run": jsonObject{
+			"type":        "object",
+			"description": "A script submitted to /execute",
+			"properties": jsonObject{
+				"id":        jsonObject{"type": "string", "description": "The request ID"},
+				"status":    jsonObject{"type": "string", "enum": []string{"queued", "running", "done"}},
+				"submitted": jsonObject{"type": "string", "format": "date-time"},
+				"started":   jsonObject{"type": "string", "format": "date-time"},
+				"finished":  jsonObject{"type": "string", "format": "date-time"},
+				"result":    schemaRef("ExecutionResult"),
+			},
+			"required": []string{"id", "status", "submitted"},
+		},
+		"ServerStatus": jsonObject{
+			"type": "object",
+			"properties": jsonObject{
+				"backend": jsonObject{"type": "string"},
+				"current": jsonObject{"type": "string", "description": "ID of the running request, if any"},
+				"session": jsonObject{"type": "boolean", "description": "A /ws session holds the screen"},
+				"queued":  jsonObject{"type": "integer", "description": "Requests waiting for the screen"},
+				"runs":    jsonObject{"type": "array", "items": schemaRef("Run"), "description": "Known runs, newest first, without their results"},
+			},
+		},
+		"LiveMessage": jsonObject{
+			"type":        "object",
+			"description": "Sent over /live; which other field is set depends on type",
+			"properties": jsonObject{
+				"type":       jsonObject{"type": "string", "enum": []string{"hello", "step", "screenshot", "ready", "end"}},
+				"backend":    jsonObject{"type": "string", "description": "With hello"},
+				"step":       schemaRef("StepResult"),
+				"screenshot": schemaRef("Screenshot"),
+				"result":     jsonObject{"$ref": "#/components/schemas/ExecutionResult", "description": "With end, once an aborted run has rolled back"},
+			},
+			"required": []string{"type"},
+		},
+		"StepResult": jsonObject{
+			"type":        "object",
+			"description": "Sent over /ws for each step",
+			"properties": jsonObject{
+				"step":       jsonObject{"type": "integer"},
+				"name":       jsonObject{"type": "string", "description": "The step's @name"},
+				"action":     jsonObject{"type": "string"},
+				"status":     jsonObject{"type": "string", "enum": []string{"success", "error", "skipped"}},
+				"error":      jsonObject{"type": "string"},
+				"screenshot": schemaRef("Screenshot"),
+				"output":     jsonObject{"type": "object", "additionalProperties": true},
+				"events":     arrayOf(schemaRef("Event")),
+				"audio":      jsonObject{"type": "boolean", "description": "Sound played during the step, with --audio"},
+			},
+			"required": []string{"step", "status"},
+		},
+	}
+	var actions []interface{}
+	for _, spec := range actionSpecs {
+		name := "Action_" + spec.Name
+		schemas[name] = jsonObject{
+			"type":        "object",
+			"description": toolDescription(spec),
+			"properties": jsonObject{
+				"action": jsonObject{"const": spec.Name},
+				"params": spec.paramsSchema(),
+			},
+			"required": []string{"action", "params"},
+		}
+		actions = append(actions, schemaRef(name))
+	}
+	schemas["Action"] = jsonObject{"oneOf": actions, "discriminator": jsonObject{"propertyName": "action"}}
+
+	textError := jsonObject{"text/plain": jsonObject{"schema": jsonObject{"type": "string"}}}
+	bearerAuth := []jsonObject{{"bearer": []string{}}}
+	unauthorized := jsonObject{"description": "No bearer token or the wrong one", "content": textError}
+	wsTokenNote := "A browser, which cannot set the Authorization header, offers the subprotocols \"executor\" and \"bearer.TOKEN\" instead."
+	forbidden := jsonObject{"description": "The Host is not this server, or the page's origin is not allowed", "content": textError}
+	paths := jsonObject{
+		"/execute": jsonObject{"post": jsonObject{
+			"operationId": "execute",
+			"security":    bearerAuth,
+			"summary":     "Run a script and return its result",
+			"description": "The body is a script, one action per line. Runs are serialized because they share the screen. The last 100 finished runs can be fetched again from /status/{id}.",
+			"parameters": []jsonObject{
+				{"name": "wait", "in": "query", "schema": jsonObject{"type": "boolean", "default": true}, "description": "false to return at once and poll /status/{id}"},
+				{"name": "X-Request-ID", "in": "header", "schema": jsonObject{"type": "string", "pattern": requestIDPattern.String()}, "description": "ID for the run, generated when absent; echoed in the response header"},
+			},
+			"requestBody": jsonObject{
+				"required": true,
+				"content":  jsonObject{"text/plain": jsonObject{"schema": jsonObject{"type": "string"}, "example": "pointer 100 200\nclick 1 s\n"}},
+			},
+			"responses": jsonObject{
+				"200": jsonObject{"description": "Script finished; check status for step errors", "content": jsonContent(schemaRef("ExecutionResult"))},
+				"202": jsonObject{"description": "Queued, with wait=false", "content": jsonContent(schemaRef("Run"))},
+				"400": jsonObject{"description": "Bad wait or X-Request-ID", "content": textError},
+				"401": unauthorized,
+				"403": forbidden,
+				"405": jsonObject{"description": "Not a POST", "content": textError},
+				"409": jsonObject{"description": "The request ID is taken", "content": textError},
+			},
+		}},
+		"/status": jsonObject{"get": jsonObject{
+			"operationId": "status",
+			"security":    bearerAuth,
+			"summary":     "What the server is running and the runs it knows",
+			"responses": jsonObject{
+				"200": jsonObject{"description": "Server status", "content": jsonContent(schemaRef("ServerStatus"))},
+				"401": unauthorized,
+				"403": forbidden,
+			},
+		}},
+		"/status/{id}": jsonObject{"get": jsonObject{
+			"operationId": "getRun",
+			"security":    bearerAuth,
+			"summary":     "One run, with its result once done",
+			"parameters":  []jsonObject{{"name": "id", "in": "path", "required": true, "schema": jsonObject{"type": "string"}}},
+			"responses": jsonObject{
+				"200": jsonObject{"description": "The run", "content": jsonContent(schemaRef("Run"))},
+				"401": unauthorized,
+				"403": forbidden,
+				"404": jsonObject{"description": "Unknown or forgotten request ID", "content": textError},
+			},
+		}},
+		"/screenshot": jsonObject{"get": jsonObject{
+			"operationId": "screenshot",
+			"security":    bearerAuth,
+			"summary":     "The screen as it is now",
+			"description": "Does not wait for a running script. Redacted and masked as stored screenshots are.",
+			"parameters":  []jsonObject{{"name": "width", "in": "query", "schema": jsonObject{"type": "integer"}, "description": "Largest width wanted"}},
+			"responses": jsonObject{
+				"200": jsonObject{"description": "PNG image", "content": jsonObject{"image/png": jsonObject{"schema": jsonObject{"type": "string", "format": "binary"}}}},
+				"400": jsonObject{"description": "Bad width", "content": textError},
+				"401": unauthorized,
+				"403": forbidden,
+			},
+		}},
+		"/artifacts/{path}": jsonObject{"get": jsonObject{
+			"operationId": "getArtifact",
+			"summary":     "Download an artifact through a signed URL from a result",
+			"parameters": []jsonObject{
+				{"name": "path", "in": "path", "required": true, "schema": jsonObject{"type": "string"}},
+				{"name": "expires", "in": "query", "required": true, "schema": jsonObject{"type": "integer"}, "description": "Unix time the link expires"},
+				{"name": "sig", "in": "query", "required": true, "schema": jsonObject{"type": "string"}, "description": "Hex HMAC-SHA256 of the path and expiry"},
+			},
+			"responses": jsonObject{
+				"200": jsonObject{"description": "The file", "content": jsonObject{"application/octet-stream": jsonObject{"schema": jsonObject{"type": "string", "format": "binary"}}}},
+				"403": jsonObject{"description": "Bad signature or expired link", "content": textError},
+				"404": jsonObject{"description": "No such file", "content": textError},
+			},
+		}},
+		"/ws": jsonObject{"get": jsonObject{
+			"operationId": "session",
+			"security":    bearerAuth,
+			"summary":     "Interactive WebSocket session",
+			"description": "Each text message holds one or more script lines; each step is answered with a StepResult message. One session runs at a time. " + wsTokenNote,
+			"responses": jsonObject{
+				"101": jsonObject{"description": "Switched to the WebSocket protocol"},
+				"401": unauthorized,
+				"403": forbidden,
+				"409": jsonObject{"description": "Another session is running", "content": textError},
+			},
+		}},
+		"/live": jsonObject{"get": jsonObject{
+			"operationId": "live",
+			"security":    bearerAuth,
+			"summary":     "Interactive WebSocket session for control panels",
+			"description": "Each text message holds one or more script lines. The server answers with LiveMessage objects: hello on connect, step as each step finishes, screenshot as each capture is taken, ready when a message's lines are done, and end when an aborted run has rolled back. One session runs at a time, shared with /ws. " + wsTokenNote,
+			"responses": jsonObject{
+				"101": jsonObject{"description": "Switched to the WebSocket protocol"},
+				"401": unauthorized,
+				"403": forbidden,
+				"409": jsonObject{"description": "Another session is running", "content": textError},
+			},
+		}},
+		"/frames": jsonObject{"get": jsonObject{
+			"operationId": "frames",
+			"security":    bearerAuth,
+			"summary":     "Live screen stream over WebSocket",
+			"description": "The first text message describes the negotiated stream. Each changed frame then arrives as a FrameHeader text message followed by a binary message: a JPEG, a lossless WebP, or zstd-compressed RGBA pixels, XORed with the previous frame when delta is set. JPEG quality steps down the ladder when frames outgrow kbps or the link. " + wsTokenNote,
+			"parameters": []jsonObject{
+				{"name": "codecs", "in": "query", "schema": jsonObject{"type": "string"}, "description": "Comma-separated codecs in the client's order of preference: jpeg, webp, zstd-raw"},
+				{"name": "fps", "in": "query", "schema": jsonObject{"type": "number"}, "description": "Frames per second wanted, capped by serve.frames.fps"},
+				{"name": "kbps", "in": "query", "schema": jsonObject{"type": "integer"}, "description": "Bandwidth the client can take"},
+				{"name": "width", "in": "query", "schema": jsonObject{"type": "integer"}, "description": "Largest frame width wanted"},
+			},
+			"responses": jsonObject{
+				"101": jsonObject{"description": "Switched to the WebSocket protocol"},
+				"400": jsonObject{"description": "No common codec or a bad parameter", "content": textError},
+				"401": unauthorized,
+				"403": forbidden,
+			},
+		}},
+		"/openapi.json": jsonObject{"get": jsonObject{
+			"operationId": "openapi",
+			"summary":     "This document",
+			"responses":   jsonObject{"200": jsonObject{"description": "OpenAPI 3.1 document", "content": jsonContent(jsonObject{"type": "object"})}},
+		}},
+	}
+
+	return jsonObject{
+		"openapi": "3.1.0",
+		"info": jsonObject{
+			"title":       "AgentOS executor",
+			"version":     apiVersion,
+			"description": "Desktop automation executor. Scripts are lines of actions; the Action schemas describe each one's parameters.",
+		},
+		"servers": []jsonObject{{"url": serverURL}},
+		"paths":   paths,
+		"components": jsonObject{
+			"schemas":         schemas,
+			"securitySchemes": jsonObject{"bearer": jsonObject{"type": "http", "scheme": "bearer", "description": "serve.token, or the token printed at start"}},
+		},
+	}
+}
+
+func (s *server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(openAPIDocument(s.publicURL))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Parallel blocks. The branches of a block, separated by branch lines,
+// run at the same time, each one step after another, and the block ends
+// when every branch has:
+//
+//	@name copy
+//	parallel
+//	tty_expect "100%" 600
+//	branch
+//	wait_for_sound -30 600
+//	end
+//
+// A branch stops at its first failed step. The block is one step of its
+// own, after the steps of its branches, failing if any branch failed; its
+// output lists each branch's status and steps. Steps that only wait or
+// read (wait, tty_expect, read_text and the like) overlap; steps that send
+// input take turns, so one branch's key presses never land between
+// another's. Blocks cannot be nested, nor used in setup or on_rollback.
+
+// ParallelBranch is how one branch of a parallel block ended
+type ParallelBranch struct {
+	Status string        `json:"status"`
+	Error  string        `json:"error,omitempty"`
+	Steps  []*StepResult `json:"steps"`
+}
+
+// inputTurn is held by a parallel branch while its step sends input
+var inputTurn sync.Mutex
+
+// parallelStep is a step line of a branch with its annotations
+type parallelStep struct {
+	line        string
+	annotations map[string]string
+}
+
+// parallelLine collects a parallel block, running it at its end. It
+// reports whether the line was consumed, with the block's step once run.
+func (r *runner) parallelLine(line string) (*StepResult, bool) {
+	if r.branches == nil {
+		if !strings.EqualFold(line, "parallel") || r.collecting != nil {
+			return nil, false
+		}
+		if r.inSetup {
+			r.result.Errors = append(r.result.Errors, "setup: parallel blocks cannot be used in setup")
+			r.result.Status = "error"
+		}
+		r.branches = [][]string{nil}
+		return nil, true
+	}
+	switch {
+	case strings.EqualFold(line, "end"):
+		branches := r.branches
+		r.branches = nil
+		if r.inSetup {
+			return nil, true
+		}
+		return r.runParallel(branches), true
+	case strings.EqualFold(line, "branch"):
+		r.branches = append(r.branches, nil)
+	case markerLine(line):
+		r.result.Errors = append(r.result.Errors, fmt.Sprintf("After step %d: %s cannot be used in a parallel block", r.step, line))
+		r.result.Status = "error"
+	case line != "" && !strings.HasPrefix(line, "#"):
+		last := len(r.branches) - 1
+		r.branches[last] = append(r.branches[last], line)
+	}
+	return nil, true
+}
+
+// runParallel runs the branches of a block together and joins them
+func (r *runner) runParallel(branches [][]string) *StepResult {
+	annotations := r.pending
+	r.pending = nil
+	plans := make([][]parallelStep, len(branches))
+	for i, lines := range branches {
+		var pending map[string]string
+		for _, line := range lines {
+			name, value, ok := parseAnnotation(line)
+			if !ok {
+				plans[i] = append(plans[i], parallelStep{line, pending})
+				pending = nil
+				continue
+			}
+			err := checkAnnotation(name, value)
+			if !knownAnnotations[name] {
+				err = fmt.Errorf("unknown annotation @%s", name)
+			}
+			if err != nil {
+				r.result.Errors = append(r.result.Errors, fmt.Sprintf("Parallel branch %d: %v", i+1, err))
+				r.result.Status = "error"
+				continue
+			}
+			pending = addAnnotation(pending, name, value)
+		}
+	}
+
+	results := make([]ParallelBranch, len(plans))
+	var wg sync.WaitGroup
+	r.inParallel = true
+	for i, plan := range plans {
+		wg.Add(1)
+		go func(branch *ParallelBranch, plan []parallelStep) {
+			defer wg.Done()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			branch.Status, branch.Steps = "success", []*StepResult{}
+			for _, s := range plan {
+				start := clock.Now()
+				step := r.runAnnotated(s.line, s.annotations)
+				r.transcript.add(start, s.line, step)
+				branch.Steps = append(branch.Steps, step)
+				if step.Status == "error" {
+					branch.Status, branch.Error = "error", fmt.Sprintf("%s: %s", stepLabel(step.Step, step.Name), step.Error)
+					break
+				}
+			}
+		}(&results[i], plan)
+	}
+	wg.Wait()
+	r.inParallel = false
+
+	r.step++
+	step := &StepResult{Step: r.step, Name: annotations["name"], Action: "parallel", Status: "success",
+		Output: map[string]interface{}{"branches": results}}
+	var failed []string
+	for i, b := range results {
+		if b.Status != "error" {
+			continue
+		}
+		failed = append(failed, fmt.Sprintf("branch %d failed at %s", i+1, b.Error))
+		step.timedOut = step.timedOut || b.Steps[len(b.Steps)-1].timedOut
+	}
+	if len(failed) > 0 {
+		// The failed steps are in the run's errors already
+		step.Status, step.Error = "error", strings.Join(failed, "; ")
+	} else {
+		r.result.CommandsExecuted++
+	}
+	r.result.Outputs = append(r.result.Outputs, StepOutput{Step: r.step, Name: step.Name, Action: step.Action, Data: step.Output})
+	return step
+}
+
+// executeOnce makes one attempt at a step's command. In a parallel block
+// the other branches go on meanwhile, and steps that can change the
+// screen wait for their turn at the input.
+func (r *runner) executeOnce(cmd *Command, timeout time.Duration) (err error) {
+	r.unlocked(func() {
+		if r.inParallel && defaultScreenshotPolicies[cmd.Action] != "never" {
+			inputTurn.Lock()
+			defer inputTurn.Unlock()
+		}
+		err = executeWithin(cmd, timeout)
+	})
+	return err
+}
+
+// unlocked runs f without holding the run's state in a parallel block
+func (r *runner) unlocked(f func()) {
+	if !r.inParallel {
+		f()
+		return
+	}
+	r.mu.Unlock()
+	defer r.mu.Lock()
+	f()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PerceptionRequest lists everything a step wants to know about the screen
+type PerceptionRequest struct {
+	OCR       bool
+	OCRRegion image.Rectangle
+	Templates []string
+	Threshold float64
+	A11y      bool
+}
+
+// TemplateMatch is the result of looking for one template image
+type TemplateMatch struct {
+	File  string  `json:"file"`
+	Found bool    `json:"found"`
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+	Score float64 `json:"score"`
+}
+
+// PerceptionResult is the combined output of one perception pass
+type PerceptionResult struct {
+	Words   []OCRWord         `json:"words,omitempty"`
+	Text    string            `json:"text,omitempty"`
+	Matches []TemplateMatch   `json:"matches,omitempty"`
+	A11y    []A11yElement     `json:"a11y,omitempty"`
+	Timings map[string]int64  `json:"timings_ms"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// perceive captures a single frame and runs all requested analyses on it
+// concurrently. The a11y dump does not need the frame, so it starts before
+// the capture.
+func perceive(req PerceptionRequest) PerceptionResult {
+	result := PerceptionResult{Timings: map[string]int64{}, Errors: map[string]string{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	record := func(name string, start time.Time, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		result.Timings[name] = time.Since(start).Milliseconds()
+		if err != nil {
+			result.Errors[name] = err.Error()
+		}
+	}
+
+	if req.A11y {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			elements, err := dumpA11y()
+			mu.Lock()
+			result.A11y = elements
+			mu.Unlock()
+			record("a11y", start, err)
+		}()
+	}
+
+	var frame image.Image
+	if req.OCR || len(req.Templates) > 0 {
+		start := time.Now()
+		var err error
+		frame, err = captureScreen()
+		record("capture", start, err)
+		if err == nil {
+			if req.OCR {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					start := time.Now()
+					words, err := recognizeFrame(frame, req.OCRRegion)
+					if err == nil {
+						words, err = redactWords(words)
+					}
+					texts := make([]string, len(words))
+					for i, w := range words {
+						texts[i] = w.Text
+					}
+					mu.Lock()
+					result.Words = words
+					result.Text = strings.Join(texts, " ")
+					mu.Unlock()
+					record("ocr", start, err)
+				}()
+			}
+			result.Matches = make([]TemplateMatch, len(req.Templates))
+			for i, file := range req.Templates {
+				wg.Add(1)
+				go func(i int, file string) {
+					defer wg.Done()
+					start := time.Now()
+					match, err := matchOnFrame(frame, file, req.Threshold)
+					mu.Lock()
+					result.Matches[i] = match
+					mu.Unlock()
+					record("template:"+file, start, err)
+				}(i, file)
+			}
+		}
+	}
+	wg.Wait()
+	if frame != nil {
+		releaseFrame(frame)
+	}
+
+	if len(result.Errors) == 0 {
+		result.Errors = nil
+	}
+	return result
+}
+
+// matchOnFrame looks for a template image in an already captured frame;
+// X and Y are the center of the best match
+func matchOnFrame(frame image.Image, templateFile string, threshold float64) (TemplateMatch, error) {
+	match := TemplateMatch{File: templateFile}
+	tpl, err := loadTemplate(templateFile)
+	if err != nil {
+		return match, err
+	}
+	x, y, score, err := matchTemplate(frame, tpl)
+	if err != nil {
+		return match, err
+	}
+	b := tpl.Bounds()
+	match.X, match.Y, match.Score = x+b.Dx()/2, y+b.Dy()/2, score
+	match.Found = score >= threshold
+	return match, nil
+}
+
+// parseObserve reads `observe [ocr] [a11y] [image=file.png]... [threshold=0.9]`
+func parseObserve(cmd *Command, parts []string) *Command {
+	req := PerceptionRequest{Threshold: defaultImageThreshold}
+	for _, p := range parts[1:] {
+		switch {
+		case p == "ocr":
+			req.OCR = true
+		case p == "a11y":
+			req.A11y = true
+		case strings.HasPrefix(p, "image="):
+			req.Templates = append(req.Templates, strings.Trim(strings.TrimPrefix(p, "image="), "\""))
+		case strings.HasPrefix(p, "threshold="):
+			fmt.Sscan(strings.TrimPrefix(p, "threshold="), &req.Threshold)
+		default:
+			return nil
+		}
+	}
+	if !req.OCR && !req.A11y && len(req.Templates) == 0 {
+		return nil
+	}
+	cmd.Params["request"] = req
+	return cmd
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// pointer_rel moves the pointer by an offset from wherever it is, for
+// canvas tools and games where the absolute position is not known:
+//
+//	pointer_rel 40 -15
+//
+// Backends that can send relative motion do (xdotool, x11, wayland, qmp,
+// windows), so a game holding the pointer captured sees the motion it
+// expects; SendInput's motion is scaled by Windows' pointer speed
+// setting. Others read the pointer position and move to the sum. The
+// step's output is the offset and, where the backend can tell, the
+// position the pointer ended at.
+
+// relativeMover is implemented by backends that send relative pointer
+// motion
+type relativeMover interface {
+	moveMouseRelative(dx, dy int) error
+}
+
+// parsePointerRel reads pointer_rel DX DY
+func parsePointerRel(cmd *Command, parts []string) *Command {
+	if len(parts) != 3 {
+		return nil
+	}
+	dx, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil
+	}
+	dy, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil
+	}
+	cmd.Params["dx"], cmd.Params["dy"] = dx, dy
+	return cmd
+}
+
+// pointerRel runs pointer_rel
+func pointerRel(cmd *Command) error {
+	dx, dy := cmd.Params["dx"].(int), cmd.Params["dy"].(int)
+	input := currentBackend()
+	if err := moveRelative(input, dx, dy); err != nil {
+		return err
+	}
+	cmd.Output = map[string]interface{}{"dx": dx, "dy": dy}
+	if l, ok := input.(cursorLocator); ok {
+		if x, y, err := l.cursorPosition(); err == nil {
+			cmd.Output["x"], cmd.Output["y"] = x, y
+		}
+	}
+	return nil
+}
+
+// moveRelative moves b's pointer by dx, dy
+func moveRelative(b Backend, dx, dy int) error {
+	if m, ok := b.(relativeMover); ok {
+		return m.moveMouseRelative(dx, dy)
+	}
+	l, ok := b.(cursorLocator)
+	if !ok {
+		return fmt.Errorf("backend %s can neither move the pointer relatively nor tell where it is", b.Name())
+	}
+	x, y, err := l.cursorPosition()
+	if err != nil {
+		return fmt.Errorf("reading the pointer position: %v", err)
+	}
+	return b.MoveMouse(x+dx, y+dy)
+}
+
+func (xdotoolBackend) moveMouseRelative(dx, dy int) error {
+	return runXdotool("mousemove_relative", "--", strconv.Itoa(dx), strconv.Itoa(dy))
+}
+
+// moveMouseRelative sends an XTEST motion event with detail 1, relative
+func (b *x11Backend) moveMouseRelative(dx, dy int) error {
+	return b.do(func(x *xConn) (uint16, error) {
+		body := cat([]byte{xMotionNotify, 1, 0, 0}, u32(0), u32(0), u32(0), u32(0),
+			u16(uint16(int16(dx))), u16(uint16(int16(dy))), make([]byte, 8))
+		return x.request(x.req(b.xtest, 2, body))
+	})
+}
+
+func (waylandBackend) moveMouseRelative(dx, dy int) error {
+	return runYdotool("mousemove", "-x", strconv.Itoa(dx), "-y", strconv.Itoa(dy))
+}
+
+func (b *qmpBackend) moveMouseRelative(dx, dy int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.send(qmpRelEvent("x", dx), qmpRelEvent("y", dy))
+}
+
+func qmpRelEvent(axis string, value int) map[string]interface{} {
+	return map[string]interface{}{"type": "rel", "data": map[string]interface{}{"axis": axis, "value": value}}
+}
+
+// RFB pointer events are absolute, so the VNC backend's pointer is where
+// it last sent it
+func (b *vncBackend) cursorPosition() (int, int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pos.X, b.pos.Y, nil
+}
+
+// The touch position is the backend's own
+func (b *adbBackend) cursorPosition() (int, int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pos.X, b.pos.Y, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// portableDir, when set, holds all executor state: config, calibration,
+// audit log, screenshots, results, temp files and the run lock. Nothing is
+// written outside it, so the executor can run from removable media.
+var portableDir string
+
+// stripPortableFlag removes a leading --portable DIR from args, so it can
+// precede subcommands as well as plain runs
+func stripPortableFlag(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	if dir, ok := strings.CutPrefix(args[0], "--portable="); ok {
+		portableDir = dir
+		return args[1:], nil
+	}
+	if args[0] == "--portable" || args[0] == "-portable" {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("--portable needs a directory")
+		}
+		portableDir = args[1]
+		return args[2:], nil
+	}
+	return args, nil
+}
+
+// setupPortable creates the portable layout and redirects temp files into
+// it, including those of child processes
+func setupPortable() error {
+	if portableDir == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(portableDir)
+	if err != nil {
+		return err
+	}
+	portableDir = abs
+	for _, sub := range []string{"config", "state", "screenshots", "results", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(portableDir, sub), 0755); err != nil {
+			return fmt.Errorf("portable dir: %v", err)
+		}
+	}
+	tmp := filepath.Join(portableDir, "tmp")
+	os.Setenv("TMPDIR", tmp)
+	os.Setenv("TMP", tmp)
+	os.Setenv("TEMP", tmp)
+	screenshotsDir = filepath.Join(portableDir, "screenshots")
+	configPath = filepath.Join(configDir(), "executor.json")
+	return nil
+}
+
+// acquirePortableLock stops two executors sharing one portable directory.
+// A lock left behind by a dead process is taken over.
+func acquirePortableLock() (release func(), err error) {
+	if portableDir == "" {
+		return func() {}, nil
+	}
+	path := filepath.Join(portableDir, "executor.lock")
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		data, _ := os.ReadFile(path)
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		if pid > 0 && processAlive(pid) {
+			return nil, fmt.Errorf("portable directory %s is in use by process %d", portableDir, pid)
+		}
+		os.Remove(path)
+	}
+	return nil, fmt.Errorf("could not lock portable directory %s", portableDir)
+}
+
+// savePortableResult keeps a copy of the run result in the portable dir
+func savePortableResult(result ExecutionResult) {
+	if portableDir == "" {
+		return
+	}
+	name := time.Now().UTC().Format("20060102T150405.000000000Z") + ".json"
+	data, _ := json.MarshalIndent(result, "", "  ")
+	if err := os.WriteFile(filepath.Join(portableDir, "results", name), data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not save result: %v\n", err)
+	}
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return signalZero(p) == nil
+}
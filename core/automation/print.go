@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// print_to_pdf "FILE" [SECONDS] prints the focused application to a PDF
+// through its print dialog, finding the dialog's parts with OCR:
+//
+//	print_to_pdf "/out/report.pdf"
+//
+// It presses ctrl+p, tells the GTK dialog ("Print to File" in the printer
+// list, the file button below it) from the Qt one ("Output file" under
+// the printer combo), selects printing to a file, enters FILE, presses
+// Print and waits until FILE is a complete PDF. SECONDS (default 60)
+// bounds the whole flow. A relative FILE goes in the screenshots
+// directory; an existing one is replaced.
+
+const defaultPrintWait = 60.0
+
+// printPoll is how often the screen and the output file are checked
+const printPoll = 500 * time.Millisecond
+
+// parsePrintToPDF reads print_to_pdf "FILE" [SECONDS]
+func parsePrintToPDF(cmd *Command, line string, parts []string) *Command {
+	rest := strings.TrimSpace(line[len(parts[0]):])
+	file, after := "", ""
+	if end := closingQuote(rest); end > 0 {
+		file, after = rest[1:end], strings.TrimSpace(rest[end+1:])
+	} else if len(parts) >= 2 {
+		file, after = parts[1], strings.TrimSpace(strings.Join(parts[2:], " "))
+	}
+	timeout := defaultPrintWait
+	if after != "" {
+		var err error
+		if timeout, err = strconv.ParseFloat(after, 64); err != nil || timeout <= 0 {
+			return nil
+		}
+	}
+	if !validPrintFile(file) {
+		return nil
+	}
+	cmd.Params["file"] = file
+	cmd.Params["timeout"] = timeout
+	return cmd
+}
+
+func validPrintFile(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), ".pdf")
+}
+
+// printToPDF runs the dialog flow and verifies the file
+func printToPDF(cmd *Command) error {
+	input := currentBackend()
+	file := cmd.Params["file"].(string)
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(screenshotsDir, file)
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// Gone beforehand, so no dialog asks about replacing it and the
+	// check below sees this run's file
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	deadline := clock.Now().Add(time.Duration(cmd.Params["timeout"].(float64) * float64(time.Second)))
+
+	if err := input.Key("ctrl+p"); err != nil {
+		return err
+	}
+	found, words, err := waitForScreenText(deadline, "Output file", "Print to File")
+	if err != nil {
+		return fmt.Errorf("no print dialog: %v", err)
+	}
+	toolkit := "gtk"
+	if found == "Output file" {
+		toolkit = "qt"
+		err = printQtDialog(file, words, deadline)
+	} else {
+		err = printGTKDialog(file, words, deadline)
+	}
+	if err != nil {
+		return fmt.Errorf("%s print dialog: %v", toolkit, err)
+	}
+
+	size, err := waitForPDF(file, deadline)
+	if err != nil {
+		return err
+	}
+	cmd.Output = map[string]interface{}{"file": file, "bytes": size, "toolkit": toolkit}
+	return nil
+}
+
+// printGTKDialog picks Print to File in the printer list, sets the file in
+// the chooser behind the file button, and prints
+func printGTKDialog(file string, words []OCRWord, deadline time.Time) error {
+	input := currentBackend()
+	if err := clickWord(words, "Print to File"); err != nil {
+		return err
+	}
+	// The file button shows the current name, output.pdf by default
+	_, words, err := waitForScreenText(deadline, ".pdf")
+	if err != nil {
+		return fmt.Errorf("no file button: %v", err)
+	}
+	button, ok := lastText(words, ".pdf")
+	if !ok {
+		return fmt.Errorf("no file button")
+	}
+	clickBox(button)
+	// The chooser's name field is focused and takes a full path
+	if _, _, err := waitForScreenText(deadline, "Name"); err != nil {
+		return fmt.Errorf("no file chooser: %v", err)
+	}
+	if err := replaceFocusedText(file); err != nil {
+		return err
+	}
+	if err := input.Key("Return"); err != nil {
+		return err
+	}
+	_, words, err = waitForScreenText(deadline, "Print to File")
+	if err != nil {
+		return fmt.Errorf("file chooser did not close: %v", err)
+	}
+	if pdf, ok := findText(words, "PDF"); ok {
+		clickBox(pdf)
+	}
+	return clickPrintButton(deadline)
+}
+
+// printQtDialog picks Print to File (PDF) in the printer combo, types the
+// file into the output field, and prints
+func printQtDialog(file string, words []OCRWord, deadline time.Time) error {
+	if _, ok := findText(words, "Print to File"); !ok {
+		name, ok := findText(words, "Name")
+		if !ok {
+			return fmt.Errorf("no printer combo")
+		}
+		// The combo is right of its label
+		clickPoint(name.X+name.Width+120, name.Y+name.Height/2)
+		_, words, err := waitForScreenText(deadline, "Print to File")
+		if err != nil {
+			return fmt.Errorf("no Print to File printer: %v", err)
+		}
+		if err := clickWord(words, "Print to File"); err != nil {
+			return err
+		}
+		if _, words, err = waitForScreenText(deadline, "Output file"); err != nil {
+			return err
+		}
+	}
+	label, ok := findText(words, "Output file")
+	if !ok {
+		return fmt.Errorf("no output file field")
+	}
+	clickPoint(label.X+label.Width+80, label.Y+label.Height/2)
+	if err := replaceFocusedText(file); err != nil {
+		return err
+	}
+	return clickPrintButton(deadline)
+}
+
+// clickPrintButton presses the dialog's Print button: the lowest "Print"
+// on screen, below the title and the printer names
+func clickPrintButton(deadline time.Time) error {
+	_, words, err := waitForScreenText(deadline, "Print")
+	if err != nil {
+		return err
+	}
+	button, ok := lastText(words, "Print")
+	if !ok {
+		return fmt.Errorf("no Print button")
+	}
+	clickBox(button)
+	return nil
+}
+
+// waitForScreenText reads the screen until one of texts is on it, and
+// returns which one with the words read
+func waitForScreenText(deadline time.Time, texts ...string) (string, []OCRWord, error) {
+	for {
+		words, err := recognizeScreen(image.Rectangle{})
+		if err != nil {
+			return "", nil, err
+		}
+		for _, text := range texts {
+			if _, ok := findScreenText(words, text); ok {
+				return text, words, nil
+			}
+		}
+		if clock.Now().After(deadline) {
+			return "", nil, fmt.Errorf("%q not on screen", strings.Join(texts, `" or "`))
+		}
+		clock.Sleep(printPoll)
+	}
+}
+
+// findScreenText is findText, or for a text starting with "." the first
+// word with that suffix, such as a file name by its extension
+func findScreenText(words []OCRWord, text string) (OCRWord, bool) {
+	if strings.HasPrefix(text, ".") {
+		for _, w := range words {
+			if strings.HasSuffix(strings.ToLower(w.Text), text) {
+				return w, true
+			}
+		}
+		return OCRWord{}, false
+	}
+	return findText(words, text)
+}
+
+// lastText is the lowest match of text on screen, the rightmost of a row
+func lastText(words []OCRWord, text string) (OCRWord, bool) {
+	n := 1
+	if !strings.HasPrefix(text, ".") {
+		n = len(strings.Fields(text))
+	}
+	var best OCRWord
+	found := false
+	for i := 0; i+n <= len(words); i++ {
+		box, ok := findScreenText(words[i:i+n], text)
+		if !ok {
+			continue
+		}
+		if !found || box.Y > best.Y+best.Height/2 || (box.Y >= best.Y-best.Height/2 && box.X > best.X) {
+			best, found = box, true
+		}
+	}
+	return best, found
+}
+
+func clickWord(words []OCRWord, text string) error {
+	box, ok := findText(words, text)
+	if !ok {
+		return fmt.Errorf("%q not on screen", text)
+	}
+	clickBox(box)
+	return nil
+}
+
+func clickBox(box OCRWord) {
+	clickPoint(box.X+box.Width/2, box.Y+box.Height/2)
+}
+
+func clickPoint(x, y int) {
+	input := currentBackend()
+	input.MoveMouse(x, y)
+	input.Click(1, 1)
+	clock.Sleep(printPoll)
+}
+
+// replaceFocusedText selects what the focused field holds and types text
+// over it
+func replaceFocusedText(text string) error {
+	input := currentBackend()
+	if err := input.Key("ctrl+a"); err != nil {
+		return err
+	}
+	return input.TypeText(text)
+}
+
+// waitForPDF waits until file is a PDF that has stopped growing, and
+// returns its size
+func waitForPDF(file string, deadline time.Time) (int64, error) {
+	last := int64(-1)
+	for {
+		if info, err := os.Stat(file); err == nil {
+			size := info.Size()
+			if size > 0 && size == last {
+				head := make([]byte, 5)
+				f, err := os.Open(file)
+				if err != nil {
+					return 0, err
+				}
+				f.Read(head)
+				f.Close()
+				if !bytes.Equal(head, []byte("%PDF-")) {
+					return 0, fmt.Errorf("%s is not a PDF", file)
+				}
+				return size, nil
+			}
+			last = size
+		}
+		if clock.Now().After(deadline) {
+			if last >= 0 {
+				return 0, fmt.Errorf("%s was still being written", file)
+			}
+			return 0, fmt.Errorf("%s did not appear", file)
+		}
+		clock.Sleep(printPoll)
+	}
+}
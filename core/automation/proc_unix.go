@@ -0,0 +1,89 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// signalZero checks that a process exists without affecting it
+func signalZero(p *os.Process) error {
+	return p.Signal(syscall.Signal(0))
+}
+
+// detachGroup starts cmd in its own process group, so it and its children
+// can be stopped together
+func detachGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killGroup asks the process group of p to exit, then kills what is left
+// after a grace period
+func killGroup(p *os.Process) {
+	syscall.Kill(-p.Pid, syscall.SIGTERM)
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if syscall.Kill(-p.Pid, 0) != nil {
+			return
+		}
+	}
+	syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
+
+// privateDir creates dir for this user only, or checks that it is
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return checkPrivateDir(dir)
+}
+
+// checkPrivateDir checks that dir is a directory, not a link to one, owned
+// by this user and closed to others, so no one else can plant or swap what
+// is in it
+func checkPrivateDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by another user", dir)
+	}
+	if fi.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s is open to other users (mode %04o, want 0700)", dir, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// checkOwnWritable checks that the file at path, as info describes it, is
+// owned by this user and writable by no one else
+func checkOwnWritable(path string, info os.FileInfo) error {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by another user", path)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by other users (mode %04o)", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+// listenPrivate listens on a Unix socket that only this user can connect
+// to. The umask is set for the listen, so the socket is never open to
+// others, however briefly.
+func listenPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
+
+// makeFIFO creates a named pipe readable and writable by this user only
+func makeFIFO(path string) error {
+	return syscall.Mkfifo(path, 0600)
+}
@@ -0,0 +1,53 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+)
+
+// signalZero reports success: on Windows os.FindProcess already fails for
+// processes that no longer exist
+func signalZero(p *os.Process) error {
+	p.Release()
+	return nil
+}
+
+// detachGroup does nothing: Windows has no process groups to signal
+func detachGroup(cmd *exec.Cmd) {}
+
+// killGroup kills the process itself
+func killGroup(p *os.Process) {
+	p.Kill()
+}
+
+// privateDir creates dir; access to it is left to its ACL, which the
+// file mode does not describe
+func privateDir(dir string) error {
+	return os.MkdirAll(dir, 0700)
+}
+
+// checkPrivateDir does nothing, as privateDir
+func checkPrivateDir(dir string) error {
+	return nil
+}
+
+// checkOwnWritable does nothing, as checkPrivateDir
+func checkOwnWritable(path string, info os.FileInfo) error {
+	return nil
+}
+
+// listenPrivate listens on a Unix socket; as with privateDir its access
+// is left to the ACL of its directory
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
+
+// makeFIFO fails: Windows named pipes live in their own namespace, not
+// the file system
+func makeFIFO(path string) error {
+	return fmt.Errorf("named pipes in the file system are not supported on Windows")
+}
@@ -0,0 +1,28 @@
+#!/bin/bash
+# Regenerates the typed executor clients in core/automation/sdk from
+# executor.proto. Needs protoc with protoc-gen-go and protoc-gen-go-grpc on
+# PATH, and grpcio-tools for Python.
+
+set -e
+
+PROTO_DIR="$(cd "$(dirname "$0")" && pwd)"
+SDK_DIR="$PROTO_DIR/../sdk"
+
+mkdir -p "$SDK_DIR/go/executorpb" "$SDK_DIR/python/agentos_executor"
+
+protoc -I "$PROTO_DIR" \
+    --go_out="$SDK_DIR/go/executorpb" --go_opt=paths=source_relative \
+    --go-grpc_out="$SDK_DIR/go/executorpb" --go-grpc_opt=paths=source_relative \
+    "$PROTO_DIR/executor.proto"
+
+python3 -m grpc_tools.protoc -I "$PROTO_DIR" \
+    --python_out="$SDK_DIR/python/agentos_executor" \
+    --grpc_python_out="$SDK_DIR/python/agentos_executor" \
+    "$PROTO_DIR/executor.proto"
+
+# grpc_tools emits an absolute import; make it package-relative
+sed -i 's/^import executor_pb2/from . import executor_pb2/' \
+    "$SDK_DIR/python/agentos_executor/executor_pb2_grpc.py"
+touch "$SDK_DIR/python/agentos_executor/__init__.py"
+
+echo "Generated clients in $SDK_DIR"
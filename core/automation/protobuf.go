@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// Minimal protobuf wire format, enough for the messages of
+// proto/executor.proto and server reflection. Messages are built and read
+// by field number; the tables below mirror the .proto for reflection and
+// must change with it.
+
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+// pbBuf builds a message. As in proto3, zero scalars are left out.
+type pbBuf []byte
+
+func (b *pbBuf) tag(field, wire int) {
+	*b = binary.AppendUvarint(*b, uint64(field)<<3|uint64(wire))
+}
+
+func (b *pbBuf) uint(field int, v uint64) {
+	if v != 0 {
+		b.tag(field, pbVarint)
+		*b = binary.AppendUvarint(*b, v)
+	}
+}
+
+// int writes an int32; negative values take ten bytes, as protobuf has it
+func (b *pbBuf) int(field int, v int) {
+	b.uint(field, uint64(int64(v)))
+}
+
+func (b *pbBuf) bool(field int, v bool) {
+	if v {
+		b.uint(field, 1)
+	}
+}
+
+// bytes writes a length-delimited field even when empty, for embedded
+// messages and repeated elements
+func (b *pbBuf) bytes(field int, v []byte) {
+	b.tag(field, pbBytes)
+	*b = binary.AppendUvarint(*b, uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *pbBuf) string(field int, s string) {
+	if s != "" {
+		b.bytes(field, []byte(s))
+	}
+}
+
+// pbValue is one field read from a message: n for varints and fixed
+// widths, data for length-delimited fields
+type pbValue struct {
+	field int
+	wire  int
+	n     uint64
+	data  []byte
+}
+
+func pbDecode(data []byte) ([]pbValue, error) {
+	var values []pbValue
+	for len(data) > 0 {
+		key, k := binary.Uvarint(data)
+		if k <= 0 || key>>3 == 0 {
+			return nil, fmt.Errorf("malformed protobuf field")
+		}
+		data = data[k:]
+		v := pbValue{field: int(key >> 3), wire: int(key & 7)}
+		switch v.wire {
+		case pbVarint:
+			if v.n, k = binary.Uvarint(data); k <= 0 {
+				return nil, fmt.Errorf("malformed protobuf varint")
+			}
+			data = data[k:]
+		case pbFixed64, pbFixed32:
+			size := 8
+			if v.wire == pbFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return nil, fmt.Errorf("truncated protobuf field")
+			}
+			data = data[size:]
+		case pbBytes:
+			size, k := binary.Uvarint(data)
+			if k <= 0 || uint64(len(data)-k) < size {
+				return nil, fmt.Errorf("truncated protobuf field")
+			}
+			v.data, data = data[k:k+int(size)], data[k+int(size):]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d", v.wire)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func pbScreenshot(s Screenshot) []byte {
+	var b pbBuf
+	b.int(1, s.Step)
+	b.string(2, s.File)
+	b.string(3, s.Action)
+	b.string(4, s.URL)
+	b.string(5, s.Uploaded)
+	b.string(6, s.Name)
+	return b
+}
+
+func pbEvent(e Event) []byte {
+	var b pbBuf
+	b.int(1, e.Step)
+	b.string(2, e.Type)
+	b.string(3, e.Message)
+	return b
+}
+
+// pbJSON is the JSON text of an output object, empty for none
+func pbJSON(v map[string]interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func pbStepResult(s *StepResult) []byte {
+	var b pbBuf
+	b.int(1, s.Step)
+	b.string(2, s.Action)
+	b.string(3, s.Status)
+	b.string(4, s.Error)
+	if s.Screenshot != nil {
+		b.bytes(5, pbScreenshot(*s.Screenshot))
+	}
+	b.string(6, pbJSON(s.Output))
+	for _, e := range s.Events {
+		b.bytes(7, pbEvent(e))
+	}
+	b.string(8, s.Name)
+	b.bool(9, s.Audio)
+	return b
+}
+
+func pbExecutionResult(r ExecutionResult) []byte {
+	var b pbBuf
+	b.string(1, r.Status)
+	b.int(2, r.CommandsExecuted)
+	for _, s := range r.Screenshots {
+		b.bytes(3, pbScreenshot(s))
+	}
+	for _, e := range r.Errors {
+		b.bytes(4, []byte(e))
+	}
+	for _, e := range r.Events {
+		b.bytes(5, pbEvent(e))
+	}
+	for _, o := range r.Outputs {
+		var ob pbBuf
+		ob.int(1, o.Step)
+		ob.string(2, o.Action)
+		ob.string(3, pbJSON(o.Data))
+		ob.string(4, o.Name)
+		b.bytes(6, ob)
+	}
+	b.string(7, r.Filmstrip)
+	if r.Cache != nil {
+		var cb pbBuf
+		cb.bytes(1, pbCacheCounts(r.Cache.Templates))
+		cb.bytes(2, pbCacheCounts(r.Cache.Regexps))
+		cb.bytes(3, pbCacheCounts(r.Cache.A11y))
+		cb.bytes(4, pbCacheCounts(r.Cache.OCR))
+		b.bytes(8, cb)
+	}
+	b.string(9, r.FilmstripUploaded)
+	return b
+}
+
+func pbCacheCounts(c CacheCounts) []byte {
+	var b pbBuf
+	b.int(1, c.Hits)
+	b.int(2, c.Misses)
+	b.int(3, c.Entries)
+	return b
+}
+
+// pbField mirrors a field of proto/executor.proto
+type pbField struct {
+	name     string
+	number   int
+	kind     string // string, int32, bool, or a message of the file
+	repeated bool
+}
+
+type pbMessage struct {
+	name   string
+	fields []pbField
+}
+
+type pbMethod struct {
+	name, input, output string
+	clientStreaming     bool
+	serverStreaming     bool
+}
+
+const (
+	executorProtoFile    = "executor.proto"
+	executorProtoPackage = "agentos.executor.v1"
+	executorService      = "Executor"
+	executorGoPackage    = "github.com/aarohkandy/AgentOS/core/automation/sdk/go/executorpb;executorpb"
+)
+
+var executorMethods = []pbMethod{
+	{name: "Execute", input: "ExecuteRequest", output: "ExecutionResult"},
+	{name: "Session", input: "CommandRequest", output: "StepResult", clientStreaming: true, serverStreaming: true},
+}
+
+var executorMessages = []pbMessage{
+	{"ExecuteRequest", []pbField{{"script", 1, "string", false}, {"format", 2, "string", false}}},
+	{"CommandRequest", []pbField{{"line", 1, "string", false}}},
+	{"Screenshot", []pbField{
+		{"step", 1, "int32", false}, {"file", 2, "string", false}, {"action", 3, "string", false},
+		{"url", 4, "string", false}, {"uploaded", 5, "string", false}, {"name", 6, "string", false},
+	}},
+	{"Event", []pbField{{"step", 1, "int32", false}, {"type", 2, "string", false}, {"message", 3, "string", false}}},
+	{"StepOutput", []pbField{
+		{"step", 1, "int32", false}, {"action", 2, "string", false}, {"data_json", 3, "string", false},
+		{"name", 4, "string", false},
+	}},
+	{"StepResult", []pbField{
+		{"step", 1, "int32", false}, {"action", 2, "string", false}, {"status", 3, "string", false},
+		{"error", 4, "string", false}, {"screenshot", 5, "Screenshot", false}, {"output_json", 6, "string", false},
+		{"events", 7, "Event", true}, {"name", 8, "string", false}, {"audio", 9, "bool", false},
+	}},
+	{"ExecutionResult", []pbField{
+		{"status", 1, "string", false}, {"commands_executed", 2, "int32", false},
+		{"screenshots", 3, "Screenshot", true}, {"errors", 4, "string", true},
+		{"events", 5, "Event", true}, {"outputs", 6, "StepOutput", true},
+		{"filmstrip", 7, "string", false}, {"cache", 8, "CacheStats", false},
+		{"filmstrip_uploaded", 9, "string", false},
+	}},
+	{"CacheCounts", []pbField{{"hits", 1, "int32", false}, {"misses", 2, "int32", false}, {"entries", 3, "int32", false}}},
+	{"CacheStats", []pbField{
+		{"templates", 1, "CacheCounts", false}, {"regexps", 2, "CacheCounts", false},
+		{"a11y", 3, "CacheCounts", false}, {"ocr", 4, "CacheCounts", false},
+	}},
+}
+
+// Field types and labels of descriptor.proto
+var pbFieldTypes = map[string]int{"int32": 5, "bool": 8, "string": 9}
+
+const (
+	pbTypeMessage   = 11
+	pbLabelOptional = 1
+	pbLabelRepeated = 3
+)
+
+// executorFileDescriptor is executor.proto as a serialized
+// google.protobuf.FileDescriptorProto, for reflection
+func executorFileDescriptor() []byte {
+	var file pbBuf
+	file.string(1, executorProtoFile)
+	file.string(2, executorProtoPackage)
+	for _, m := range executorMessages {
+		var msg pbBuf
+		msg.string(1, m.name)
+		for _, f := range m.fields {
+			var field pbBuf
+			field.string(1, f.name)
+			field.int(3, f.number)
+			label := pbLabelOptional
+			if f.repeated {
+				label = pbLabelRepeated
+			}
+			field.int(4, label)
+			if t, ok := pbFieldTypes[f.kind]; ok {
+				field.int(5, t)
+			} else {
+				field.int(5, pbTypeMessage)
+				field.string(6, "."+executorProtoPackage+"."+f.kind)
+			}
+			field.string(10, lowerCamel(f.name))
+			msg.bytes(2, field)
+		}
+		file.bytes(4, msg)
+	}
+	var service pbBuf
+	service.string(1, executorService)
+	for _, m := range executorMethods {
+		var method pbBuf
+		method.string(1, m.name)
+		method.string(2, "."+executorProtoPackage+"."+m.input)
+		method.string(3, "."+executorProtoPackage+"."+m.output)
+		method.bool(5, m.clientStreaming)
+		method.bool(6, m.serverStreaming)
+		service.bytes(2, method)
+	}
+	file.bytes(6, service)
+	var options pbBuf
+	options.string(11, executorGoPackage)
+	file.bytes(8, options)
+	file.string(12, "proto3")
+	return file
+}
+
+// executorSymbols are the fully qualified names defined in executor.proto
+func executorSymbols() map[string]bool {
+	service := executorProtoPackage + "." + executorService
+	symbols := map[string]bool{service: true}
+	for _, m := range executorMethods {
+		symbols[service+"."+m.name] = true
+	}
+	for _, m := range executorMessages {
+		symbols[executorProtoPackage+"."+m.name] = true
+	}
+	return symbols
+}
+
+// lowerCamel is protoc's JSON name for a field
+func lowerCamel(name string) string {
+	out := []byte{}
+	upper := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && c >= 'a' && c <= 'z':
+			out = append(out, c-'a'+'A')
+			upper = false
+		default:
+			out = append(out, c)
+			upper = false
+		}
+	}
+	return string(out)
+}
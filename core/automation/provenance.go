@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Input provenance: to answer "was that click the agent's or a person's?"
+// after the fact, each run's first input step writes an input_provenance
+// entry to the audit log, saying how the run's input can be told apart
+// where the backend allows:
+//
+//	XTEST (x11, xdotool)   the Virtual core XTEST devices and their XInput ids
+//	uinput                 the AgentOS Executor devices: sysfs path, event
+//	                       node and bus:vendor:product (0006:4147:0001)
+//	ydotool (wayland)      the ydotoold virtual device
+//	SendInput (windows)    the dwExtraInfo every event carries
+//
+// Other backends are recorded by name; mock runs and replays are not
+// recorded. A device listed is one the run's input came through, and
+// input on it at the time was the agent's; on XTEST other clients'
+// synthetic input shares the devices.
+
+// provenanceReporter is implemented by backends that can say how their
+// input is identified
+type provenanceReporter interface {
+	inputProvenance() map[string]interface{}
+}
+
+// auditInput records the run's input provenance once, after its first
+// input step
+func (r *runner) auditInput() {
+	if r.inputAudited {
+		return
+	}
+	r.inputAudited = true
+	b := baseBackend()
+	if _, replay := b.(*replayBackend); replay || b.Name() == "mock" {
+		return // nothing reached a real machine
+	}
+	details := map[string]interface{}{"backend": b.Name(), "pid": os.Getpid(), "run_started": r.started.UTC()}
+	if p, ok := b.(provenanceReporter); ok {
+		for k, v := range p.inputProvenance() {
+			details[k] = v
+		}
+	}
+	if err := audit("input_provenance", tenant, details); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: recording input provenance: %v\n", err)
+	}
+}
+
+// inputProvenance lists the XTEST devices XTEST input arrives from
+func (xdotoolBackend) inputProvenance() map[string]interface{} {
+	p := map[string]interface{}{"mechanism": "XTEST", "display": os.Getenv("DISPLAY")}
+	var devices []map[string]interface{}
+	for _, name := range []string{"Virtual core XTEST pointer", "Virtual core XTEST keyboard"} {
+		device := map[string]interface{}{"name": name}
+		// The XInput id, if xinput is there to ask
+		if out, err := exec.Command("xinput", "list", "--id-only", name).Output(); err == nil {
+			device["xinput_id"] = strings.TrimSpace(string(out))
+		}
+		devices = append(devices, device)
+	}
+	p["devices"] = devices
+	return p
+}
+
+// inputProvenance lists ydotoold's device
+func (waylandBackend) inputProvenance() map[string]interface{} {
+	return map[string]interface{}{"mechanism": "ydotool", "devices": evdevDevices("ydotoold virtual device")}
+}
+
+// evdevDevices describes the input devices whose names start with prefix,
+// from /proc/bus/input/devices
+func evdevDevices(prefix string) []map[string]interface{} {
+	data, err := os.ReadFile("/proc/bus/input/devices")
+	if err != nil {
+		return nil
+	}
+	var devices []map[string]interface{}
+	for _, block := range strings.Split(string(data), "\n\n") {
+		device := map[string]interface{}{}
+		for _, line := range strings.Split(block, "\n") {
+			kind, value, ok := strings.Cut(line, ": ")
+			if !ok {
+				continue
+			}
+			switch kind {
+			case "N":
+				device["name"] = strings.Trim(strings.TrimPrefix(value, "Name="), `"`)
+			case "I":
+				var bus, vendor, product string
+				for _, field := range strings.Fields(value) {
+					k, v, _ := strings.Cut(field, "=")
+					switch k {
+					case "Bus":
+						bus = v
+					case "Vendor":
+						vendor = v
+					case "Product":
+						product = v
+					}
+				}
+				device["id"] = fmt.Sprintf("%s:%s:%s", bus, vendor, product)
+			case "S":
+				device["sysfs"] = "/sys" + strings.TrimPrefix(value, "Sysfs=")
+			case "H":
+				for _, h := range strings.Fields(strings.TrimPrefix(value, "Handlers=")) {
+					if strings.HasPrefix(h, "event") {
+						device["event"] = "/dev/input/" + h
+					}
+				}
+			}
+		}
+		if name, _ := device["name"].(string); strings.HasPrefix(name, prefix) {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PushConfig configures streaming artifacts to an orchestrator. Uploads use
+// the tus resumable upload protocol (https://tus.io), so an interrupted
+// transfer continues from the last acknowledged byte, even across restarts.
+type PushConfig struct {
+	Endpoint       string  `json:"endpoint"` // tus creation URL; empty disables push
+	APIKey         string  `json:"api_key"`  // sent as a bearer token; "env:NAME" is resolved
+	ThresholdBytes int64   `json:"threshold_bytes"`
+	ChunkBytes     int64   `json:"chunk_bytes"`
+	TimeoutSec     float64 `json:"timeout_seconds"`
+}
+
+const (
+	defaultPushThreshold = 1 << 20
+	defaultPushChunk     = 4 << 20
+	pushAttempts         = 5
+)
+
+// pushEnabled is set by --push; push also needs push.endpoint in the config
+var pushEnabled bool
+
+var pushStateMu sync.Mutex
+
+// pushArtifacts uploads the run's screenshots and filmstrip that are over
+// the size threshold, recording where each one went
+func pushArtifacts(result *ExecutionResult) {
+	cfg, err := currentConfig()
+	if err != nil || !pushEnabled {
+		return
+	}
+	pc := cfg.Push
+	if pc.Endpoint == "" {
+		result.Events = append(result.Events, Event{Type: "upload_failed", Message: "--push needs push.endpoint in the config"})
+		return
+	}
+	if pc.ThresholdBytes <= 0 {
+		pc.ThresholdBytes = defaultPushThreshold
+	}
+	if pc.ChunkBytes <= 0 {
+		pc.ChunkBytes = defaultPushChunk
+	}
+	for i, shot := range result.Screenshots {
+		info, err := os.Stat(shot.File)
+		if err != nil || info.Size() < pc.ThresholdBytes {
+			continue
+		}
+		location, err := pushFile(pc, shot.File)
+		if err != nil {
+			result.Events = append(result.Events, Event{Step: shot.Step, Type: "upload_failed", Message: err.Error()})
+			continue
+		}
+		result.Screenshots[i].Uploaded = location
+	}
+	if result.Filmstrip == "" {
+		return
+	}
+	if info, err := os.Stat(result.Filmstrip); err != nil || info.Size() < pc.ThresholdBytes {
+		return
+	}
+	location, err := pushFile(pc, result.Filmstrip)
+	if err != nil {
+		result.Events = append(result.Events, Event{Type: "upload_failed", Message: err.Error()})
+		return
+	}
+	result.FilmstripUploaded = location
+}
+
+// pushFile uploads one file and returns its upload URL
+func pushFile(pc PushConfig, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	digest, err := fileDigest(file)
+	if err != nil {
+		return "", err
+	}
+	client := pushHTTPClient(pc)
+
+	var lastErr error
+	for attempt := 0; attempt < pushAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<attempt) * time.Second)
+		}
+		location := loadUploadLocation(digest)
+		offset := int64(-1)
+		if location != "" {
+			// Resume where the server says we are; a forgotten upload starts over
+			if offset, lastErr = tusOffset(client, pc, location); lastErr != nil {
+				location, offset = "", -1
+			}
+		}
+		if location == "" {
+			if location, lastErr = tusCreate(client, pc, filepath.Base(path), info.Size(), digest); lastErr != nil {
+				continue
+			}
+			offset = 0
+			saveUploadLocation(digest, location)
+		}
+		for offset < info.Size() {
+			if offset, lastErr = tusPatch(client, pc, location, file, offset, pc.ChunkBytes); lastErr != nil {
+				break
+			}
+		}
+		if lastErr == nil {
+			saveUploadLocation(digest, "")
+			return location, nil
+		}
+	}
+	return "", fmt.Errorf("uploading %s: %v", path, lastErr)
+}
+
+func tusRequest(pc PushConfig, method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	if key := secretValue(pc.APIKey); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	return req, nil
+}
+
+func tusCreate(client *http.Client, pc PushConfig, name string, size int64, digest string) (string, error) {
+	req, err := tusRequest(pc, http.MethodPost, pc.Endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("Upload-Metadata", fmt.Sprintf("filename %s,sha256 %s,tenant %s",
+		base64.StdEncoding.EncodeToString([]byte(name)),
+		base64.StdEncoding.EncodeToString([]byte(digest)),
+		base64.StdEncoding.EncodeToString([]byte(tenant))))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("creating upload: HTTP %d", resp.StatusCode)
+	}
+	base, err := url.Parse(pc.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	location, err := base.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return "", fmt.Errorf("creating upload: no Location in response")
+	}
+	return location.String(), nil
+}
+
+func tusOffset(client *http.Client, pc PushConfig, location string) (int64, error) {
+	req, err := tusRequest(pc, http.MethodHead, location, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return 0, fmt.Errorf("checking upload: HTTP %d", resp.StatusCode)
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// tusPatch sends one chunk starting at offset and returns the new offset
+func tusPatch(client *http.Client, pc PushConfig, location string, file *os.File, offset, chunk int64) (int64, error) {
+	req, err := tusRequest(pc, http.MethodPatch, location, io.NewSectionReader(file, offset, chunk))
+	if err != nil {
+		return offset, err
+	}
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	resp, err := client.Do(req)
+	if err != nil {
+		return offset, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return offset, fmt.Errorf("uploading chunk at %d: HTTP %d", offset, resp.StatusCode)
+	}
+	next, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || next <= offset {
+		return offset, fmt.Errorf("uploading chunk at %d: server made no progress", offset)
+	}
+	return next, nil
+}
+
+func fileDigest(file *os.File) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, 1<<62)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Unfinished upload URLs are kept by content digest so a restarted
+// executor resumes instead of re-sending
+func uploadStatePath() string {
+	return filepath.Join(stateDir(), "uploads.json")
+}
+
+func loadUploads() map[string]string {
+	uploads := map[string]string{}
+	if data, err := os.ReadFile(uploadStatePath()); err == nil {
+		json.Unmarshal(data, &uploads)
+	}
+	return uploads
+}
+
+func loadUploadLocation(digest string) string {
+	pushStateMu.Lock()
+	defer pushStateMu.Unlock()
+	return loadUploads()[digest]
+}
+
+// saveUploadLocation records an in-progress upload; an empty location
+// forgets it
+func saveUploadLocation(digest, location string) {
+	pushStateMu.Lock()
+	defer pushStateMu.Unlock()
+	uploads := loadUploads()
+	if location == "" {
+		delete(uploads, digest)
+	} else {
+		uploads[digest] = location
+	}
+	data, _ := json.MarshalIndent(uploads, "", "  ")
+	os.MkdirAll(stateDir(), 0700)
+	os.WriteFile(uploadStatePath(), data, 0600)
+}
+
+func pushHTTPClient(c PushConfig) *http.Client {
+	timeout := 60 * time.Second
+	if c.TimeoutSec > 0 {
+		timeout = time.Duration(c.TimeoutSec * float64(time.Second))
+	}
+	return &http.Client{Timeout: timeout}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QMPConfig points the qmp backend at a virtual machine, either at QEMU's
+// own monitor socket or at a libvirt domain
+type QMPConfig struct {
+	// Socket is QEMU's -qmp address: unix:PATH or tcp:HOST:PORT
+	Socket string `json:"socket"`
+	// Domain is a libvirt domain, reached through virsh
+	Domain string `json:"domain"`
+	// URI is the libvirt connection URI (default: virsh's)
+	URI string `json:"uri"`
+}
+
+// qmpBackend drives a QEMU virtual machine from outside: input goes in as
+// virtual keyboard, mouse and tablet events with input-send-event, and
+// screenshots are dumps of the console framebuffer. Nothing runs in the
+// guest, so this also works for firmware, boot loaders and OS installers.
+// Absolute pointer moves need a tablet device in the VM (-device usb-tablet).
+type qmpBackend struct {
+	mu sync.Mutex
+
+	// QEMU's monitor socket, when talking to it directly
+	conn net.Conn
+	dec  *json.Decoder
+	// Otherwise the libvirt domain
+	domain, uri string
+
+	size image.Point // console size as of the last capture
+}
+
+// qmpKeyDelay is how long keys are held, so that guests polling the
+// keyboard, as firmware does, see every press
+const qmpKeyDelay = 10 * time.Millisecond
+
+// qmpAbsMax is the top of QEMU's absolute pointer axis range
+const qmpAbsMax = 0x7fff
+
+func newQMPBackend() (Backend, error) {
+	cfg, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	q := cfg.QMP
+	b := &qmpBackend{domain: q.Domain, uri: q.URI}
+	switch {
+	case q.Socket != "" && q.Domain != "":
+		return nil, fmt.Errorf("qmp.socket and qmp.domain are exclusive")
+	case q.Socket != "":
+		network, address, ok := strings.Cut(q.Socket, ":")
+		if !ok || (network != "unix" && network != "tcp") {
+			return nil, fmt.Errorf("qmp.socket %q: want unix:PATH or tcp:HOST:PORT", q.Socket)
+		}
+		if err := b.dial(network, address); err != nil {
+			return nil, fmt.Errorf("connecting to %s: %v", q.Socket, err)
+		}
+	case q.Domain == "":
+		return nil, fmt.Errorf("set qmp.socket or qmp.domain in the config")
+	}
+	// The first capture proves the VM is there and sizes the pointer axes
+	if _, err := b.Capture(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// dial connects to the monitor and leaves capabilities negotiation mode
+func (b *qmpBackend) dial(network, address string) error {
+	conn, err := net.DialTimeout(network, address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	b.conn, b.dec = conn, json.NewDecoder(bufio.NewReader(conn))
+	var greeting struct{ QMP json.RawMessage }
+	if err := b.dec.Decode(&greeting); err != nil || greeting.QMP == nil {
+		conn.Close()
+		return fmt.Errorf("no QMP greeting: %v", err)
+	}
+	if _, err := b.execute("qmp_capabilities", nil); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// execute runs a QMP command and returns what it returned
+func (b *qmpBackend) execute(command string, args interface{}) (json.RawMessage, error) {
+	req := map[string]interface{}{"execute": command}
+	if args != nil {
+		req["arguments"] = args
+	}
+	data, _ := json.Marshal(req)
+
+	var reply struct {
+		Return json.RawMessage
+		Error  *struct{ Class, Desc string }
+		Event  string
+	}
+	if b.conn == nil {
+		cmd := []string{"qemu-monitor-command", b.domain, string(data)}
+		out, err := b.virsh(cmd...)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(out, &reply); err != nil {
+			return nil, fmt.Errorf("%s: %v", command, err)
+		}
+	} else {
+		b.conn.SetDeadline(time.Now().Add(30 * time.Second))
+		if _, err := b.conn.Write(append(data, '\n')); err != nil {
+			return nil, fmt.Errorf("%s: %v", command, err)
+		}
+		// Asynchronous events may come first
+		for {
+			reply.Event = ""
+			if err := b.dec.Decode(&reply); err != nil {
+				return nil, fmt.Errorf("%s: %v", command, err)
+			}
+			if reply.Event == "" {
+				break
+			}
+		}
+	}
+	if reply.Error != nil {
+		return nil, fmt.Errorf("%s: %s: %s", command, reply.Error.Class, reply.Error.Desc)
+	}
+	return reply.Return, nil
+}
+
+// virsh runs virsh against the configured connection
+func (b *qmpBackend) virsh(args ...string) ([]byte, error) {
+	if b.uri != "" {
+		args = append([]string{"--connect", b.uri}, args...)
+	}
+	out, err := exec.Command("virsh", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("virsh %s: %v", args[0], commandError(err))
+	}
+	return out, nil
+}
+
+// send injects input events
+func (b *qmpBackend) send(events ...map[string]interface{}) error {
+	_, err := b.execute("input-send-event", map[string]interface{}{"events": events})
+	return err
+}
+
+func qmpKeyEvent(qcode string, down bool) map[string]interface{} {
+	return map[string]interface{}{"type": "key", "data": map[string]interface{}{
+		"down": down, "key": map[string]string{"type": "qcode", "data": qcode}}}
+}
+
+func qmpButtonEvent(button string, down bool) map[string]interface{} {
+	return map[string]interface{}{"type": "btn", "data": map[string]interface{}{"down": down, "button": button}}
+}
+
+func qmpAbsEvent(axis string, value int) map[string]interface{} {
+	return map[string]interface{}{"type": "abs", "data": map[string]interface{}{"axis": axis, "value": value}}
+}
+
+func (b *qmpBackend) Name() string { return "qmp" }
+
+func (b *qmpBackend) MoveMouse(x, y int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w, h := b.size.X, b.size.Y
+	if w < 2 || h < 2 {
+		return fmt.Errorf("unknown console size")
+	}
+	return b.send(
+		qmpAbsEvent("x", clampInt(x, 0, w-1)*qmpAbsMax/(w-1)),
+		qmpAbsEvent("y", clampInt(y, 0, h-1)*qmpAbsMax/(h-1)))
+}
+
+// qmpButtons are QEMU's names for X button numbers
+var qmpButtons = map[int]string{1: "left", 2: "middle", 3: "right", 4: "wheel-up", 5: "wheel-down", 8: "side", 9: "extra"}
+
+func qmpButton(button int) (string, error) {
+	name, ok := qmpButtons[button]
+	if !ok {
+		return "", fmt.Errorf("button %d cannot be sent to a VM", button)
+	}
+	return name, nil
+}
+
+func (b *qmpBackend) MouseDown(button int) error {
+	name, err := qmpButton(button)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.send(qmpButtonEvent(name, true))
+}
+
+func (b *qmpBackend) MouseUp(button int) error {
+	name, err := qmpButton(button)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.send(qmpButtonEvent(name, false))
+}
+
+func (b *qmpBackend) Click(button, count int) error {
+	name, err := qmpButton(button)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := 0; i < count; i++ {
+		if err := b.send(qmpButtonEvent(name, true)); err != nil {
+			return err
+		}
+		time.Sleep(qmpKeyDelay)
+		if err := b.send(qmpButtonEvent(name, false)); err != nil {
+			return err
+		}
+		time.Sleep(qmpKeyDelay)
+	}
+	return nil
+}
+
+// press holds the keys down in order, then releases them in reverse
+func (b *qmpBackend) press(qcodes []string) error {
+	for _, k := range qcodes {
+		if err := b.send(qmpKeyEvent(k, true)); err != nil {
+			return err
+		}
+	}
+	time.Sleep(qmpKeyDelay)
+	for i := len(qcodes) - 1; i >= 0; i-- {
+		if err := b.send(qmpKeyEvent(qcodes[i], false)); err != nil {
+			return err
+		}
+	}
+	time.Sleep(qmpKeyDelay)
+	return nil
+}
+
+func (b *qmpBackend) KeyDown(key string) error {
+	qcodes, ok := qmpQcode(key)
+	if !ok {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, q := range qcodes {
+		if err := b.send(qmpKeyEvent(q, true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *qmpBackend) KeyUp(key string) error {
+	qcodes, ok := qmpQcode(key)
+	if !ok {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := len(qcodes) - 1; i >= 0; i-- {
+		if err := b.send(qmpKeyEvent(qcodes[i], false)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TypeText types on the VM's keyboard as a US layout, which is what the
+// guest sees unless it was told otherwise
+func (b *qmpBackend) TypeText(text string) error {
+	var keys [][]string
+	for _, r := range text {
+		k, ok := qmpTypeable(r)
+		if !ok {
+			return fmt.Errorf("cannot type %q on the VM's keyboard", r)
+		}
+		keys = append(keys, k)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, k := range keys {
+		if err := b.press(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Key takes xdotool key syntax: combinations like ctrl+alt+Delete,
+// separated by spaces
+func (b *qmpBackend) Key(keys string) error {
+	var combos [][]string
+	for _, combo := range strings.Fields(keys) {
+		var qcodes []string
+		for _, name := range strings.Split(combo, "+") {
+			q, ok := qmpQcode(name)
+			if !ok {
+				return fmt.Errorf("unknown key %q", name)
+			}
+			qcodes = append(qcodes, q...)
+		}
+		combos = append(combos, qcodes)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, qcodes := range combos {
+		if err := b.press(qcodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Capture dumps the console. QEMU writes the dump itself, so with a direct
+// socket the file must be somewhere QEMU can write; virsh streams it over
+// the libvirt connection instead.
+func (b *qmpBackend) Capture() (image.Image, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("agentos-qmp-%d-%d.ppm", os.Getpid(), time.Now().UnixNano()))
+	defer os.Remove(path)
+	if b.conn == nil {
+		if _, err := b.virsh("screenshot", b.domain, "--file", path); err != nil {
+			return nil, fmt.Errorf("screen capture failed: %v", err)
+		}
+	} else if _, err := b.execute("screendump", map[string]string{"filename": path}); err != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", err)
+	}
+	defer f.Close()
+	img, err := decodeDump(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", err)
+	}
+	b.size = img.Bounds().Size()
+	return img, nil
+}
+
+// decodeDump reads a console dump: binary PPM, which QEMU writes by
+// default, or PNG, which newer libvirt may hand back
+func decodeDump(r *bufio.Reader) (image.Image, error) {
+	magic, err := r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if string(magic) != "P6" {
+		return png.Decode(r)
+	}
+	r.Discard(2)
+	var header [3]int // width, height, maxval
+	for i := range header {
+		field, err := ppmField(r)
+		if err != nil {
+			return nil, fmt.Errorf("PPM header: %v", err)
+		}
+		if header[i], err = strconv.Atoi(field); err != nil || header[i] <= 0 {
+			return nil, fmt.Errorf("PPM header: bad value %q", field)
+		}
+	}
+	w, h, max := header[0], header[1], header[2]
+	if max > 255 {
+		return nil, fmt.Errorf("PPM with %d levels is not supported", max+1)
+	}
+	row := make([]byte, 3*w)
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		if _, err := io.ReadFull(r, row); err != nil {
+			return nil, fmt.Errorf("PPM data: %v", err)
+		}
+		pix := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			for c := 0; c < 3; c++ {
+				pix[4*x+c] = byte(int(row[3*x+c]) * 255 / max)
+			}
+			pix[4*x+3] = 255
+		}
+	}
+	return img, nil
+}
+
+// ppmField reads a header field, skipping whitespace and comments, and
+// the single whitespace byte that ends it
+func ppmField(r *bufio.Reader) (string, error) {
+	var field bytes.Buffer
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case c == '#' && field.Len() == 0:
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if field.Len() > 0 {
+				return field.String(), nil
+			}
+		default:
+			field.WriteByte(c)
+		}
+	}
+}
+
+// qmpKeyNames maps xdotool key names to QEMU key codes
+var qmpKeyNames = map[string]string{
+	"Return": "ret", "KP_Enter": "kp_enter", "Escape": "esc", "BackSpace": "backspace",
+	"Tab": "tab", "space": "spc", "Delete": "delete", "Insert": "insert",
+	"Home": "home", "End": "end", "Prior": "pgup", "Page_Up": "pgup", "Next": "pgdn", "Page_Down": "pgdn",
+	"Up": "up", "Down": "down", "Left": "left", "Right": "right",
+	"ctrl": "ctrl", "Control_L": "ctrl", "Control_R": "ctrl_r",
+	"alt": "alt", "Alt_L": "alt", "Alt_R": "alt_r", "ISO_Level3_Shift": "alt_r",
+	"shift": "shift", "Shift_L": "shift", "Shift_R": "shift_r",
+	"super": "meta_l", "Super_L": "meta_l", "Super_R": "meta_r", "meta": "meta_l", "Meta_L": "meta_l",
+	"Menu": "menu", "Print": "print", "Sys_Req": "sysrq", "Pause": "pause", "Break": "pause",
+	"Caps_Lock": "caps_lock", "Num_Lock": "num_lock", "Scroll_Lock": "scroll_lock",
+	"minus": "minus", "equal": "equal", "bracketleft": "bracket_left", "bracketright": "bracket_right",
+	"backslash": "backslash", "semicolon": "semicolon", "apostrophe": "apostrophe", "grave": "grave_accent",
+	"comma": "comma", "period": "dot", "slash": "slash", "less": "less",
+}
+
+// qmpUnshifted and qmpShifted are the US layout's printable keys
+const (
+	qmpUnshifted = "`1234567890-=qwertyuiop[]\\asdfghjkl;'zxcvbnm,./"
+	qmpShifted   = "~!@#$%^&*()_+QWERTYUIOP{}|ASDFGHJKL:\"ZXCVBNM<>?"
+)
+
+// qmpPunctuation names the US layout's unshifted punctuation keys
+var qmpPunctuation = map[rune]string{
+	'`': "grave_accent", '-': "minus", '=': "equal", '[': "bracket_left", ']': "bracket_right",
+	'\\': "backslash", ';': "semicolon", '\'': "apostrophe", ',': "comma", '.': "dot", '/': "slash",
+}
+
+// qmpQcode resolves a key name to the keys pressed for it
+func qmpQcode(name string) ([]string, bool) {
+	if q, ok := qmpKeyNames[name]; ok {
+		return []string{q}, true
+	}
+	if lower := strings.ToLower(name); qmpKeyNames[lower] != "" {
+		return []string{qmpKeyNames[lower]}, true
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "F")); err == nil && strings.HasPrefix(name, "F") && n >= 1 && n <= 24 {
+		return []string{"f" + strconv.Itoa(n)}, true
+	}
+	if r := []rune(name); len(r) == 1 {
+		return qmpTypeable(r[0])
+	}
+	return nil, false
+}
+
+// qmpTypeable is the keys that type r on a US layout
+func qmpTypeable(r rune) ([]string, bool) {
+	switch r {
+	case '\n':
+		return []string{"ret"}, true
+	case '\t':
+		return []string{"tab"}, true
+	case ' ':
+		return []string{"spc"}, true
+	}
+	if i := strings.IndexRune(qmpShifted, r); i >= 0 {
+		q, _ := qmpTypeable(rune(qmpUnshifted[i]))
+		return append([]string{"shift"}, q...), true
+	}
+	if !strings.ContainsRune(qmpUnshifted, r) {
+		return nil, false
+	}
+	if q, ok := qmpPunctuation[r]; ok {
+		return []string{q}, true
+	}
+	return []string{string(r)}, true
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Barcode is a QR code or barcode read off the screen, in screen
+// coordinates. Type is ZBar's name for the symbology, e.g. QR-Code or
+// EAN-13.
+type Barcode struct {
+	Type   string `json:"type"`
+	Data   string `json:"data"`
+	X      int    `json:"x,omitempty"`
+	Y      int    `json:"y,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// zbarNoSymbols is zbarimg's exit status when an image holds no codes
+const zbarNoSymbols = 4
+
+// readBarcodes decodes the codes in a region of the screen, the whole
+// screen if region is empty, with ZBar's zbarimg
+func readBarcodes(region image.Rectangle) ([]Barcode, error) {
+	screen, err := captureScreen()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseFrame(screen)
+	img := image.Image(screen)
+	if !region.Empty() {
+		region = region.Intersect(screen.Bounds())
+		img = cropImage(screen, region)
+	}
+	data, err := encodePNG(img)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "agentos-qr-*.png")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	f.Write(data)
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	out, err := exec.Command("zbarimg", "--quiet", "--xml", f.Name()).Output()
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == zbarNoSymbols {
+		return []Barcode{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("zbarimg: %v", commandError(err))
+	}
+	codes, err := parseZbarXML(out)
+	if err != nil {
+		return nil, fmt.Errorf("zbarimg: %v", err)
+	}
+	for i := range codes {
+		if codes[i].Width > 0 {
+			codes[i].X += region.Min.X
+			codes[i].Y += region.Min.Y
+		}
+	}
+	return codes, nil
+}
+
+// parseZbarXML reads zbarimg --xml output. Binary data comes base64
+// encoded; the polygon, which older ZBar versions leave out, gives the
+// bounding box.
+func parseZbarXML(out []byte) ([]Barcode, error) {
+	var doc struct {
+		Symbols []struct {
+			Type    string `xml:"type,attr"`
+			Polygon struct {
+				Points string `xml:"points,attr"`
+			} `xml:"polygon"`
+			Data struct {
+				Format string `xml:"format,attr"`
+				Text   string `xml:",chardata"`
+			} `xml:"data"`
+		} `xml:"source>index>symbol"`
+	}
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		return nil, err
+	}
+	codes := []Barcode{}
+	for _, s := range doc.Symbols {
+		code := Barcode{Type: s.Type, Data: s.Data.Text}
+		if s.Data.Format == "base64" {
+			raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s.Data.Text))
+			if err != nil {
+				return nil, err
+			}
+			code.Data = string(raw)
+		}
+		var box image.Rectangle
+		for i, p := range strings.Fields(s.Polygon.Points) {
+			xs, ys, _ := strings.Cut(p, ",")
+			x, errX := strconv.Atoi(strings.TrimPrefix(xs, "+"))
+			y, errY := strconv.Atoi(strings.TrimPrefix(ys, "+"))
+			if errX != nil || errY != nil {
+				continue
+			}
+			pt := image.Rect(x, y, x+1, y+1)
+			if i == 0 {
+				box = pt
+			} else {
+				box = box.Union(pt)
+			}
+		}
+		if !box.Empty() {
+			code.X, code.Y, code.Width, code.Height = box.Min.X, box.Min.Y, box.Dx(), box.Dy()
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// A recording is every backend call of a run, in order, with its result.
+// Captured frames are stored as PNGs next to recording.json. Secrets the
+// run types, the credentials of fill_form and the codes of type_totp, are
+// not: their calls are marked secret and hold a placeholder.
+type Recording struct {
+	Version int          `json:"version"`
+	Backend string       `json:"backend"`
+	Calls   []RecordCall `json:"calls"`
+}
+
+// RecordCall is one backend call and what it returned
+type RecordCall struct {
+	Op     string    `json:"op"`
+	Args   []string  `json:"args,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Frame  string    `json:"frame,omitempty"`
+	Words  []OCRWord `json:"words,omitempty"`  // text layout of mock frames
+	Result []string  `json:"result,omitempty"` // what queries such as the cursor position returned
+	Secret bool      `json:"secret,omitempty"` // typed text left out, as recordedSecret
+}
+
+const recordingVersion = 1
+
+// recordedSecret stands in for secret text in recordings
+const recordedSecret = "[secret]"
+
+// secretTyper is implemented by backends that must not keep what they type
+// when it is a secret
+type secretTyper interface {
+	typeSecret(text string) error
+}
+
+// typeSecret types text that must not be kept, such as resolved credentials
+// and one-time codes: a recording stores recordedSecret in its place
+func typeSecret(input Backend, text string) error {
+	if s, ok := input.(secretTyper); ok {
+		return s.typeSecret(text)
+	}
+	return input.TypeText(text)
+}
+
+// recordDir and replayDir are set by --record and --replay
+var recordDir, replayDir string
+
+// wrapBackend applies --record or --replay to the selected backend
+func wrapBackend() error {
+	switch {
+	case recordDir != "" && replayDir != "":
+		return fmt.Errorf("--record and --replay cannot be combined")
+	case recordDir != "":
+		if err := os.MkdirAll(filepath.Join(recordDir, "frames"), 0755); err != nil {
+			return err
+		}
+		activeBackend = &recordingBackend{inner: activeBackend, dir: recordDir,
+			rec: Recording{Version: recordingVersion, Backend: activeBackend.Name()}}
+	case replayDir != "":
+		data, err := os.ReadFile(filepath.Join(replayDir, "recording.json"))
+		if err != nil {
+			return fmt.Errorf("reading recording: %v", err)
+		}
+		var rec Recording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("parsing recording: %v", err)
+		}
+		if rec.Version != recordingVersion {
+			return fmt.Errorf("recording version %d is not supported", rec.Version)
+		}
+		activeBackend = &replayBackend{dir: replayDir, rec: rec}
+	}
+	return nil
+}
+
+// closeBackend finishes a recording, or checks a replay consumed the whole
+// recording without diverging
+func closeBackend() error {
+	switch b := currentBackend().(type) {
+	case *recordingBackend:
+		return b.save()
+	case *replayBackend:
+		return b.verify()
+	}
+	return nil
+}
+
+// baseBackend looks through recording wrappers
+func baseBackend() Backend {
+	if r, ok := currentBackend().(*recordingBackend); ok {
+		return r.inner
+	}
+	return currentBackend()
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func intArgs(values ...int) []string {
+	args := make([]string, len(values))
+	for i, v := range values {
+		args[i] = fmt.Sprint(v)
+	}
+	return args
+}
+
+// recordingBackend passes calls through and logs them
+type recordingBackend struct {
+	inner Backend
+	dir   string
+	mu    sync.Mutex
+	rec   Recording
+}
+
+func (r *recordingBackend) log(op string, args []string, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rec.Calls = append(r.rec.Calls, RecordCall{Op: op, Args: args, Error: errString(err)})
+	return err
+}
+
+func (r *recordingBackend) Name() string { return r.inner.Name() }
+
+func (r *recordingBackend) MoveMouse(x, y int) error {
+	return r.log("move", intArgs(x, y), r.inner.MoveMouse(x, y))
+}
+
+func (r *recordingBackend) MouseDown(button int) error {
+	return r.log("down", intArgs(button), r.inner.MouseDown(button))
+}
+
+func (r *recordingBackend) MouseUp(button int) error {
+	return r.log("up", intArgs(button), r.inner.MouseUp(button))
+}
+
+func (r *recordingBackend) Click(button, count int) error {
+	return r.log("click", intArgs(button, count), r.inner.Click(button, count))
+}
+
+func (r *recordingBackend) TypeText(text string) error {
+	return r.log("type", []string{text}, r.inner.TypeText(text))
+}
+
+func (r *recordingBackend) typeSecret(text string) error {
+	err := r.inner.TypeText(text)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rec.Calls = append(r.rec.Calls, RecordCall{Op: "type", Args: []string{recordedSecret}, Error: errString(err), Secret: true})
+	return err
+}
+
+func (r *recordingBackend) Key(keys string) error {
+	return r.log("key", []string{keys}, r.inner.Key(keys))
+}
+
+func (r *recordingBackend) KeyDown(key string) error {
+	return r.log("keydown", []string{key}, r.inner.KeyDown(key))
+}
+
+func (r *recordingBackend) KeyUp(key string) error {
+	return r.log("keyup", []string{key}, r.inner.KeyUp(key))
+}
+
+func (r *recordingBackend) Capture() (image.Image, error) {
+	img, err := r.inner.Capture()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	call := RecordCall{Op: "capture", Error: errString(err)}
+	if err == nil {
+		call.Frame = filepath.Join("frames", fmt.Sprintf("%06d.png", len(r.rec.Calls)))
+		path := filepath.Join(r.dir, call.Frame)
+		if err := writePNG(path, img); err != nil {
+			return nil, fmt.Errorf("recording frame: %v", err)
+		}
+		// Recordings hold the same screen content as screenshots, so they
+		// get the same redaction
+		if err := redactScreenshotFile(path); err != nil {
+			os.Remove(path)
+			return nil, fmt.Errorf("redacting recorded frame: %v", err)
+		}
+		if frame, ok := img.(*mockFrame); ok {
+			call.Words = frame.words
+		}
+	}
+	r.rec.Calls = append(r.rec.Calls, call)
+	return img, err
+}
+
+func (r *recordingBackend) cursorPosition() (int, int, error) {
+	l, ok := r.inner.(cursorLocator)
+	if !ok {
+		return 0, 0, fmt.Errorf("backend %s cannot report the cursor position", r.inner.Name())
+	}
+	x, y, err := l.cursorPosition()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rec.Calls = append(r.rec.Calls, RecordCall{Op: "position", Error: errString(err), Result: intArgs(x, y)})
+	return x, y, err
+}
+
+func (r *recordingBackend) moveMouseRelative(dx, dy int) error {
+	return r.log("move_rel", intArgs(dx, dy), moveRelative(r.inner, dx, dy))
+}
+
+func (r *recordingBackend) save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(r.rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.dir, "recording.json"), data, 0644)
+}
+
+// replayBackend answers calls from a recording, failing as soon as the run
+// asks for something the recording did not
+type replayBackend struct {
+	dir      string
+	mu       sync.Mutex
+	rec      Recording
+	next     int
+	diverged error
+}
+
+// Name is the recorded backend's, so backend-specific defaults such as
+// mock OCR carry over to the replay
+func (r *replayBackend) Name() string { return r.rec.Backend }
+
+// expect consumes the next recorded call, which must match op and args
+func (r *replayBackend) expect(op string, args []string) (RecordCall, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.diverged != nil {
+		return RecordCall{}, r.diverged
+	}
+	if r.next >= len(r.rec.Calls) {
+		r.diverged = fmt.Errorf("replay diverged at call %d: %s %v is past the end of the recording", r.next+1, op, args)
+		return RecordCall{}, r.diverged
+	}
+	call := r.rec.Calls[r.next]
+	if call.Op != op || !reflect.DeepEqual(call.Args, args) {
+		r.diverged = fmt.Errorf("replay diverged at call %d: recorded %s %v, got %s %v", r.next+1, call.Op, call.Args, op, args)
+		return RecordCall{}, r.diverged
+	}
+	r.next++
+	return call, nil
+}
+
+func (r *replayBackend) replay(op string, args []string) error {
+	call, err := r.expect(op, args)
+	if err != nil {
+		return err
+	}
+	if call.Error != "" {
+		return fmt.Errorf("%s", call.Error)
+	}
+	return nil
+}
+
+func (r *replayBackend) MoveMouse(x, y int) error   { return r.replay("move", intArgs(x, y)) }
+func (r *replayBackend) MouseDown(button int) error { return r.replay("down", intArgs(button)) }
+func (r *replayBackend) MouseUp(button int) error   { return r.replay("up", intArgs(button)) }
+func (r *replayBackend) Click(button, count int) error {
+	return r.replay("click", intArgs(button, count))
+}
+func (r *replayBackend) TypeText(text string) error { return r.replay("type", []string{text}) }
+func (r *replayBackend) typeSecret(string) error    { return r.replay("type", []string{recordedSecret}) }
+func (r *replayBackend) Key(keys string) error      { return r.replay("key", []string{keys}) }
+func (r *replayBackend) KeyDown(key string) error   { return r.replay("keydown", []string{key}) }
+func (r *replayBackend) KeyUp(key string) error     { return r.replay("keyup", []string{key}) }
+
+func (r *replayBackend) moveMouseRelative(dx, dy int) error {
+	return r.replay("move_rel", intArgs(dx, dy))
+}
+
+func (r *replayBackend) Capture() (image.Image, error) {
+	call, err := r.expect("capture", nil)
+	if err != nil {
+		return nil, err
+	}
+	if call.Error != "" {
+		return nil, fmt.Errorf("%s", call.Error)
+	}
+	img, err := loadPNG(filepath.Join(r.dir, call.Frame))
+	if err != nil {
+		return nil, fmt.Errorf("replaying frame: %v", err)
+	}
+	if call.Words != nil {
+		// Restore the text layout so mock OCR reads the same words
+		frame := &mockFrame{RGBA: image.NewRGBA(img.Bounds()), words: call.Words}
+		draw.Draw(frame, frame.Bounds(), img, img.Bounds().Min, draw.Src)
+		return frame, nil
+	}
+	return img, nil
+}
+
+func (r *replayBackend) cursorPosition() (int, int, error) {
+	call, err := r.expect("position", nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if call.Error != "" {
+		return 0, 0, fmt.Errorf("%s", call.Error)
+	}
+	if len(call.Result) != 2 {
+		return 0, 0, fmt.Errorf("recorded cursor position is malformed")
+	}
+	x, _ := strconv.Atoi(call.Result[0])
+	y, _ := strconv.Atoi(call.Result[1])
+	return x, y, nil
+}
+
+// The recorded frames already show the cursor the way the run had it
+func (r *replayBackend) hideCursor() (func(), error) { return func() {}, nil }
+
+func (r *replayBackend) verify() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.diverged != nil {
+		return r.diverged
+	}
+	if r.next < len(r.rec.Calls) {
+		return fmt.Errorf("replay diverged: %d recorded calls were never made, starting with %s %v",
+			len(r.rec.Calls)-r.next, r.rec.Calls[r.next].Op, r.rec.Calls[r.next].Args)
+	}
+	return nil
+}
+
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// Encoded straight to disk through a buffer, with pooled compression
+	// state
+	w := bufio.NewWriterSize(file, 64<<10)
+	err = pngEncoder.Encode(w, img)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Recovery playbooks put a run back on track after the failures a long
+// unattended run meets, then run the failed step again. A recover block
+// declares one for a class of failure, like on_rollback:
+//
+//	recover dialog_detected:Update
+//	key Escape
+//	end
+//	recover window_not_found:Firefox
+//	launch firefox
+//	wait 5
+//	end
+//	recover focus_lost
+//	end
+//
+// The classes are
+//
+//	dialog_detected:TITLE     a window whose title contains TITLE is open
+//	window_not_found[:TITLE]  no window matches the step's @pre window
+//	focus_lost[:TITLE]        one does, but it is not the active window
+//
+// TITLE narrows window_not_found and focus_lost to @pre windows naming
+// it. A focus_lost block with no steps activates the window. Playbooks in
+// the config's recovery.playbooks apply to every script, after the
+// script's own:
+//
+//	{"recovery": {"attempts": 5, "playbooks": [{"on": "dialog_detected:Update", "steps": ["key Escape"]}]}}
+//
+// A run makes at most recovery.attempts recoveries (default 3). Each is
+// a recovery event; if the playbook's steps succeed the failed step runs
+// again as a new step, and if that succeeds the failure no longer fails
+// the run, though its step keeps its error. Steps of setup, rollback and
+// parallel blocks and of playbooks themselves are not recovered.
+
+// RecoveryConfig holds playbooks for every script and the run's budget
+type RecoveryConfig struct {
+	Attempts  int                `json:"attempts"` // default 3
+	Playbooks []RecoveryPlaybook `json:"playbooks"`
+}
+
+// RecoveryPlaybook is the steps that recover a class of failure
+type RecoveryPlaybook struct {
+	On    string   `json:"on"` // CLASS or CLASS:TITLE
+	Steps []string `json:"steps"`
+}
+
+const defaultRecoveryAttempts = 3
+
+var recoveryClasses = []string{"dialog_detected", "window_not_found", "focus_lost"}
+
+func checkRecoveryConfig(c RecoveryConfig) error {
+	if c.Attempts < 0 {
+		return fmt.Errorf("recovery.attempts must not be negative")
+	}
+	for i, p := range c.Playbooks {
+		if err := checkRecoveryClass(p.On); err != nil {
+			return fmt.Errorf("recovery.playbooks[%d].on: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// checkRecoveryClass validates CLASS or CLASS:TITLE
+func checkRecoveryClass(on string) error {
+	class, title, _ := strings.Cut(on, ":")
+	if !contains(recoveryClasses, class) {
+		return fmt.Errorf("unknown failure class %q: want dialog_detected:TITLE, window_not_found or focus_lost", on)
+	}
+	if class == "dialog_detected" && title == "" {
+		return fmt.Errorf("dialog_detected needs the dialog's title, dialog_detected:TITLE")
+	}
+	return nil
+}
+
+// recoverLine starts a recover block or collects its lines. It reports
+// whether the line was consumed.
+func (r *runner) recoverLine(line string) bool {
+	if r.playbook != nil {
+		switch {
+		case strings.EqualFold(line, "end"):
+			r.playbooks = append(r.playbooks, *r.playbook)
+			r.playbook = nil
+		case markerLine(line):
+			r.result.Errors = append(r.result.Errors, fmt.Sprintf("After step %d: %s cannot be used in a recover block", r.step, line))
+			r.result.Status = "error"
+		case line != "" && !strings.HasPrefix(line, "#"):
+			r.playbook.Steps = append(r.playbook.Steps, line)
+		}
+		return true
+	}
+	on, ok := recoverBlock(line)
+	if !ok || r.collecting != nil {
+		return false
+	}
+	if err := checkRecoveryClass(on); err != nil {
+		r.result.Errors = append(r.result.Errors, fmt.Sprintf("After step %d: recover: %v", r.step, err))
+		r.result.Status = "error"
+	}
+	r.playbook = &RecoveryPlaybook{On: on}
+	return true
+}
+
+// recoverBlock reads the class of a recover line
+func recoverBlock(line string) (string, bool) {
+	name, on, _ := strings.Cut(line, " ")
+	return strings.TrimSpace(on), strings.EqualFold(name, "recover")
+}
+
+// failure is a class of failure found after a step failed, with the
+// window it concerns
+type failure struct {
+	class  string
+	title  string // what the playbook's TITLE is matched against
+	window string // the window's ID, for focus_lost
+}
+
+// classifyFailure lists the classes of failure the desktop shows
+func classifyFailure(annotations map[string]string) []failure {
+	wm, err := currentWindowManager()
+	if err != nil {
+		return nil
+	}
+	layout, err := wm.sessionLayout()
+	if err != nil {
+		return nil
+	}
+	var found []failure
+	for _, w := range layout.Windows {
+		found = append(found, failure{class: "dialog_detected", title: w.Title, window: w.ID})
+	}
+	conds, _ := parseConditions(annotations["pre"])
+	for _, c := range conds {
+		if c.kind != "window" || c.not {
+			continue
+		}
+		// The topmost match is the one to bring back
+		match := -1
+		for i, w := range layout.Windows {
+			if strings.Contains(strings.ToLower(w.Title), strings.ToLower(c.arg)) {
+				match = i
+			}
+		}
+		switch {
+		case match < 0:
+			found = append(found, failure{class: "window_not_found", title: c.arg})
+		case layout.Windows[match].ID != layout.Focused:
+			found = append(found, failure{class: "focus_lost", title: c.arg, window: layout.Windows[match].ID})
+		}
+	}
+	return found
+}
+
+// matchPlaybook finds the first playbook, the script's then the config's,
+// for one of the failures
+func (r *runner) matchPlaybook(failures []failure) (RecoveryPlaybook, failure, bool) {
+	playbooks := r.playbooks
+	if cfg, err := currentConfig(); err == nil {
+		playbooks = append(append([]RecoveryPlaybook{}, playbooks...), cfg.Recovery.Playbooks...)
+	}
+	for _, p := range playbooks {
+		class, title, _ := strings.Cut(p.On, ":")
+		for _, f := range failures {
+			if f.class == class && strings.Contains(strings.ToLower(f.title), strings.ToLower(title)) {
+				return p, f, true
+			}
+		}
+	}
+	return RecoveryPlaybook{}, failure{}, false
+}
+
+// recoverStep runs the playbook for a failed step and the step again,
+// for as long as the budget lasts, returning the last run of the step.
+// errors and status are the run's before the failed step, restored once
+// it is recovered.
+func (r *runner) recoverStep(failed *StepResult, line string, annotations map[string]string, errors int, status string) *StepResult {
+	if r.recovering || r.inSetup || r.rollingBack || r.inParallel {
+		return failed
+	}
+	if len(r.playbooks) == 0 {
+		if cfg, err := currentConfig(); err != nil || len(cfg.Recovery.Playbooks) == 0 {
+			return failed
+		}
+	}
+	r.recovering = true
+	defer func() { r.recovering = false }()
+	step := failed
+	for step.Status == "error" && !r.aborted {
+		playbook, f, ok := r.matchPlaybook(classifyFailure(annotations))
+		if !ok {
+			return step
+		}
+		if attempts := recoveryAttempts(); r.recoveries >= attempts {
+			r.recoveryEvent(step, "recovery_exhausted", fmt.Sprintf("%s after all %d recoveries of the run", f, attempts))
+			return step
+		}
+		r.recoveries++
+		r.recoveryEvent(step, "recovery", fmt.Sprintf("%s, running its playbook (recovery %d of %d)", f, r.recoveries, recoveryAttempts()))
+		if err := r.runPlaybook(playbook, f); err != nil {
+			r.recoveryEvent(step, "recovery_failed", err.Error())
+			return step
+		}
+		retry := r.runAnnotated(line, annotations)
+		r.transcript.add(clock.Now(), line, retry)
+		r.recoveryEvent(retry, "retried", fmt.Sprintf("step %d again after recovering from %s", failed.Step, f.class))
+		step = retry
+	}
+	if step != failed && step.Status != "error" {
+		r.result.Errors, r.result.Status = r.result.Errors[:errors], status
+	}
+	return step
+}
+
+func (f failure) String() string {
+	if f.title == "" {
+		return f.class
+	}
+	return fmt.Sprintf("%s:%s", f.class, f.title)
+}
+
+// runPlaybook runs a playbook's steps, stopping at the first that fails
+func (r *runner) runPlaybook(p RecoveryPlaybook, f failure) error {
+	if len(p.Steps) == 0 && f.class == "focus_lost" {
+		wm, err := currentWindowManager()
+		if err == nil {
+			err = wm.activateWindow(f.window)
+		}
+		if err != nil {
+			return fmt.Errorf("activating %s: %v", f.title, err)
+		}
+		return nil
+	}
+	for _, line := range p.Steps {
+		start := clock.Now()
+		step := r.runStep(line)
+		if step == nil {
+			continue
+		}
+		r.transcript.add(start, line, step)
+		r.recoveryEvent(step, "recovery_step", "recovering from "+f.String())
+		if step.Status == "error" {
+			return fmt.Errorf("playbook step %d failed: %s", step.Step, step.Error)
+		}
+	}
+	return nil
+}
+
+func recoveryAttempts() int {
+	if cfg, err := currentConfig(); err == nil && cfg.Recovery.Attempts > 0 {
+		return cfg.Recovery.Attempts
+	}
+	return defaultRecoveryAttempts
+}
+
+func (r *runner) recoveryEvent(step *StepResult, kind, msg string) {
+	event := Event{Step: step.Step, Type: kind, Message: msg}
+	r.result.Events = append(r.result.Events, event)
+	step.Events = append(step.Events, event)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// RedactConfig configures PII redaction of stored screenshots
+type RedactConfig struct {
+	Enabled bool `json:"enabled"`
+	// Patterns are builtin names (email, credit_card, phone, iban) or
+	// regular expressions matched against OCR'd text
+	Patterns []string `json:"patterns"`
+	// Regions are always redacted, as [x, y, width, height]
+	Regions [][4]int `json:"regions"`
+	// Method is pixelate (default) or fill
+	Method string `json:"method"`
+	// AllowWindows blacks out all but these windows, even with redaction
+	// off; see --mask-windows
+	AllowWindows []string `json:"allow_windows"`
+}
+
+var builtinRedactPatterns = map[string]string{
+	"email":       `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"credit_card": `\b(?:\d[ -]?){13,19}\b`,
+	"phone":       `\+?\d{1,3}?[ .-]?\(?\d{3}\)?[ .-]?\d{3}[ .-]?\d{4}\b`,
+	"iban":        `\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){3,7}(?: ?[A-Z0-9]{1,4})?\b`,
+}
+
+// redactEnabled is set by --redact to force redaction on
+var redactEnabled bool
+
+// redactMaxSpan is how many consecutive OCR words a pattern may span;
+// card numbers are usually recognized as four separate groups
+const redactMaxSpan = 5
+
+type redactPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+func compileRedactPatterns(patterns []string) ([]redactPattern, error) {
+	var compiled []redactPattern
+	for _, p := range patterns {
+		expr, ok := builtinRedactPatterns[p]
+		if !ok {
+			expr = p
+		}
+		re, err := compileRegexp(expr)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %q: %v", p, err)
+		}
+		compiled = append(compiled, redactPattern{name: p, re: re})
+	}
+	return compiled, nil
+}
+
+// sensitiveBoxes returns the boxes of OCR words that match any pattern,
+// trying runs of consecutive words on the same line
+func sensitiveBoxes(words []OCRWord, patterns []redactPattern) []image.Rectangle {
+	var boxes []image.Rectangle
+	for i := range words {
+		var joined strings.Builder
+		var starts []int
+		for j := i; j < len(words) && j < i+redactMaxSpan; j++ {
+			if j > i {
+				if !sameLine(words[j-1], words[j]) {
+					break
+				}
+				joined.WriteByte(' ')
+			}
+			starts = append(starts, joined.Len())
+			joined.WriteString(words[j].Text)
+			start, end, ok := matchSpan(joined.String(), patterns)
+			if !ok {
+				continue
+			}
+			// Only the words the match overlaps, not labels before it
+			for k, at := range starts {
+				w := words[i+k]
+				if at < end && at+len(w.Text) > start {
+					boxes = append(boxes, image.Rect(w.X, w.Y, w.X+w.Width, w.Y+w.Height))
+				}
+			}
+			break
+		}
+	}
+	return boxes
+}
+
+func sameLine(a, b OCRWord) bool {
+	return b.X >= a.X && b.Y < a.Y+a.Height && a.Y < b.Y+b.Height
+}
+
+// matchSpan returns the byte range of the first pattern match in text
+func matchSpan(text string, patterns []redactPattern) (int, int, bool) {
+	for _, p := range patterns {
+		for _, m := range p.re.FindAllStringIndex(text, -1) {
+			if p.name == "credit_card" && !luhnValid(text[m[0]:m[1]]) {
+				continue
+			}
+			return m[0], m[1], true
+		}
+	}
+	return 0, 0, false
+}
+
+// luhnValid filters out long digit runs (timestamps, ids) that are not
+// card numbers
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// redactImage pixelates (or fills) the given boxes, padded a little so
+// glyph edges outside the OCR box don't survive
+func redactImage(img image.Image, boxes []image.Rectangle, method string) *image.RGBA {
+	out := image.NewRGBA(img.Bounds())
+	draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Src)
+	const pad, block = 3, 12
+	for _, box := range boxes {
+		r := image.Rect(box.Min.X-pad, box.Min.Y-pad, box.Max.X+pad, box.Max.Y+pad).Intersect(out.Bounds())
+		if method == "fill" {
+			draw.Draw(out, r, &image.Uniform{color.Black}, image.Point{}, draw.Src)
+			continue
+		}
+		for by := r.Min.Y; by < r.Max.Y; by += block {
+			for bx := r.Min.X; bx < r.Max.X; bx += block {
+				cell := image.Rect(bx, by, bx+block, by+block).Intersect(r)
+				var rs, gs, bs, n uint32
+				for y := cell.Min.Y; y < cell.Max.Y; y++ {
+					for x := cell.Min.X; x < cell.Max.X; x++ {
+						c := out.RGBAAt(x, y)
+						rs, gs, bs, n = rs+uint32(c.R), gs+uint32(c.G), bs+uint32(c.B), n+1
+					}
+				}
+				avg := color.RGBA{uint8(rs / n), uint8(gs / n), uint8(bs / n), 255}
+				draw.Draw(out, cell, &image.Uniform{avg}, image.Point{}, draw.Src)
+			}
+		}
+	}
+	return out
+}
+
+// redactScreenshotFile rewrites a stored screenshot with sensitive areas
+// obscured and windows outside the allowlist blacked out. Returns nil
+// without touching the file when both are off.
+func redactScreenshotFile(path string) error {
+	if !redacting() {
+		return nil
+	}
+	img, err := loadPNG(path)
+	if err != nil {
+		return err
+	}
+	out, err := redactFrame(img)
+	if err != nil || out == img {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, out); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	file.Close()
+	return os.Rename(tmp, path)
+}
+
+// redacting reports whether captures are redacted or masked
+func redacting() bool {
+	cfg, err := currentConfig()
+	return err != nil || cfg.Redact.Enabled || redactEnabled || len(windowAllowlist(cfg.Redact)) > 0
+}
+
+// redactFrame returns a capture with sensitive areas obscured and windows
+// outside the allowlist blacked out, or the capture itself when there is
+// nothing to hide. The window layout should still match the capture.
+func redactFrame(img image.Image) (image.Image, error) {
+	cfg, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	rc := cfg.Redact
+	redact := rc.Enabled || redactEnabled
+	allow := windowAllowlist(rc)
+	if !redact && len(allow) == 0 {
+		return img, nil
+	}
+	var mask *windowMask
+	if len(allow) > 0 {
+		if mask, err = currentWindowMask(allow); err != nil {
+			return nil, fmt.Errorf("masking windows: %v", err)
+		}
+	}
+	var boxes []image.Rectangle
+	if redact {
+		var patterns []redactPattern
+		if boxes, patterns, err = redactRules(rc); err != nil {
+			return nil, err
+		}
+		if len(patterns) > 0 {
+			words, err := recognizeFrame(img, image.Rectangle{})
+			if err != nil {
+				return nil, err
+			}
+			boxes = append(boxes, sensitiveBoxes(words, patterns)...)
+		}
+	}
+	if len(boxes) == 0 && mask == nil {
+		return img, nil
+	}
+	out := redactImage(img, boxes, rc.Method)
+	if mask != nil {
+		mask.apply(out)
+	}
+	return out, nil
+}
+
+// redactRules returns the regions and compiled patterns redaction hides,
+// email and card numbers when the config names neither
+func redactRules(rc RedactConfig) ([]image.Rectangle, []redactPattern, error) {
+	if len(rc.Patterns) == 0 && len(rc.Regions) == 0 {
+		rc.Patterns = []string{"email", "credit_card"}
+	}
+	patterns, err := compileRedactPatterns(rc.Patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	var boxes []image.Rectangle
+	for _, r := range rc.Regions {
+		boxes = append(boxes, image.Rect(r[0], r[1], r[0]+r[2], r[1]+r[3]))
+	}
+	return boxes, patterns, nil
+}
+
+// redactWords drops the OCR words of the screen that redactFrame would
+// hide: those of windows outside the allowlist and, with redaction on,
+// those matching a pattern or inside a region
+func redactWords(words []OCRWord) ([]OCRWord, error) {
+	cfg, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	rc := cfg.Redact
+	if allow := windowAllowlist(rc); len(allow) > 0 {
+		mask, err := currentWindowMask(allow)
+		if err != nil {
+			return nil, fmt.Errorf("masking windows: %v", err)
+		}
+		words = mask.maskWords(words)
+	}
+	if !rc.Enabled && !redactEnabled {
+		return words, nil
+	}
+	boxes, patterns, err := redactRules(rc)
+	if err != nil {
+		return nil, err
+	}
+	boxes = append(boxes, sensitiveBoxes(words, patterns)...)
+	var kept []OCRWord
+	for _, w := range words {
+		hidden := false
+		for _, b := range boxes {
+			if wordRect(w).Overlaps(b) {
+				hidden = true
+				break
+			}
+		}
+		if !hidden {
+			kept = append(kept, w)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Bundles are shared through a registry, an HTTP server that stores each
+// version of a bundle as a gzipped tar with a detached signature:
+//
+//	executor bundle push registry.example.com/team/login-flow:1.2 ./login-flow
+//	executor bundle pull registry.example.com/team/login-flow:1.2
+//
+// puts and gets
+//
+//	https://registry.example.com/v1/bundles/team/login-flow/1.2.tar.gz
+//	https://registry.example.com/v1/bundles/team/login-flow/1.2.tar.gz.sig
+//
+// so any server that stores what is PUT to it will do. Pushes are signed
+// with registry.signing_key, an Ed25519 key made by `bundle keygen`, and
+// a pull installs nothing that is not signed by one of
+// registry.trusted_keys. The signature covers the bundle's name and tag as
+// well as its content, so a vetted bundle cannot be served under another
+// name or version.
+
+// RegistryConfig configures the bundle registry client
+type RegistryConfig struct {
+	Token       string   `json:"token"`        // sent as a bearer token; "env:NAME" is resolved
+	SigningKey  string   `json:"signing_key"`  // PEM file of the Ed25519 key pushes are signed with
+	TrustedKeys []string `json:"trusted_keys"` // base64 Ed25519 public keys pulls are checked against
+}
+
+// BundleSignature is the content of a bundle's .sig file
+type BundleSignature struct {
+	Key       string `json:"key"`       // base64 public key
+	Digest    string `json:"digest"`    // sha256:HEX of the archive
+	Signature string `json:"signature"` // base64, over bundleSignedMessage
+}
+
+const (
+	registryTimeout = 5 * time.Minute
+	// maxBundleBytes bounds a pulled archive and what it unpacks to
+	maxBundleBytes = 256 << 20
+)
+
+// bundleRef is a parsed HOST/REPO:TAG
+type bundleRef struct {
+	host, repo, tag string
+}
+
+func parseBundleRef(s string) (bundleRef, error) {
+	host, rest, ok := strings.Cut(s, "/")
+	if !ok || host == "" || rest == "" {
+		return bundleRef{}, fmt.Errorf("bundle reference %q is not HOST/NAME[:TAG]", s)
+	}
+	ref := bundleRef{host: host, repo: rest}
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		ref.repo, ref.tag = rest[:i], rest[i+1:]
+	}
+	for _, part := range append(strings.Split(ref.repo, "/"), ref.tag) {
+		if part == "." || part == ".." || strings.ContainsAny(part, `\?#`) {
+			return bundleRef{}, fmt.Errorf("bundle reference %q is not HOST/NAME[:TAG]", s)
+		}
+	}
+	return ref, nil
+}
+
+func (r bundleRef) String() string {
+	return r.host + "/" + r.repo + ":" + r.tag
+}
+
+// url is where the archive is kept; plain selects http
+func (r bundleRef) url(plain bool) string {
+	scheme := "https"
+	if plain {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v1/bundles/%s/%s.tar.gz", scheme, r.host, r.repo, r.tag)
+}
+
+// bundleSignedMessage is what a signature signs: the reference without its
+// host, which may differ between mirrors, and the archive's digest
+func bundleSignedMessage(r bundleRef, digest string) []byte {
+	return []byte("agentos-bundle\n" + r.repo + ":" + r.tag + "\n" + digest + "\n")
+}
+
+func runBundle(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: executor bundle push [--plain-http] HOST/NAME[:TAG] DIR")
+		fmt.Fprintln(os.Stderr, "       executor bundle pull [--plain-http] [--dir DIR] HOST/NAME:TAG")
+		fmt.Fprintln(os.Stderr, "       executor bundle keygen KEYFILE")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	fs := flag.NewFlagSet("bundle "+args[0], flag.ExitOnError)
+	plain := fs.Bool("plain-http", false, "talk to the registry over http instead of https")
+	dir := fs.String("dir", "", "directory to unpack into (default: the bundle's name)")
+	fs.StringVar(&configPath, "config", configPath, "path to the executor config file")
+	fs.Parse(args[1:])
+
+	var err error
+	switch {
+	case args[0] == "push" && fs.NArg() == 2:
+		err = pushBundle(fs.Arg(0), fs.Arg(1), *plain)
+	case args[0] == "pull" && fs.NArg() == 1:
+		err = pullBundle(fs.Arg(0), *dir, *plain)
+	case args[0] == "keygen" && fs.NArg() == 1:
+		err = bundleKeygen(fs.Arg(0))
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// pushBundle signs and uploads a bundle. The tag defaults to the bundle's
+// version, and must match it when given.
+func pushBundle(refArg, dir string, plain bool) error {
+	ref, err := parseBundleRef(refArg)
+	if err != nil {
+		return err
+	}
+	b, err := loadBundle(dir)
+	if err != nil {
+		return fmt.Errorf("bundle %s: %v", dir, err)
+	}
+	if ref.tag == "" {
+		ref.tag = b.manifest.Version
+	} else if ref.tag != b.manifest.Version {
+		return fmt.Errorf("tag %s does not match the bundle's version %s", ref.tag, b.manifest.Version)
+	}
+	cfg, err := currentConfig()
+	if err != nil {
+		return err
+	}
+	key, err := loadSigningKey(cfg.Registry.SigningKey)
+	if err != nil {
+		return err
+	}
+	archive, err := packBundle(dir)
+	if err != nil {
+		return fmt.Errorf("packing %s: %v", dir, err)
+	}
+	sum := sha256.Sum256(archive)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	sig, _ := json.MarshalIndent(BundleSignature{
+		Key:       base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Digest:    digest,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, bundleSignedMessage(ref, digest))),
+	}, "", "  ")
+
+	// The signature goes last, so a half-finished push never verifies
+	target := ref.url(plain)
+	if err := registryPut(cfg.Registry, target, archive, "application/gzip"); err != nil {
+		return err
+	}
+	if err := registryPut(cfg.Registry, target+".sig", sig, "application/json"); err != nil {
+		return err
+	}
+	fmt.Printf("Pushed %s (%d bytes, %s)\n", ref, len(archive), digest)
+	return nil
+}
+
+// pullBundle downloads a bundle, checks its signature and unpacks it
+func pullBundle(refArg, dir string, plain bool) error {
+	ref, err := parseBundleRef(refArg)
+	if err != nil {
+		return err
+	}
+	if ref.tag == "" {
+		return fmt.Errorf("pull needs a tag, e.g. %s:1.0", refArg)
+	}
+	cfg, err := currentConfig()
+	if err != nil {
+		return err
+	}
+	if len(cfg.Registry.TrustedKeys) == 0 {
+		return fmt.Errorf("registry.trusted_keys is empty, so no bundle can be verified")
+	}
+	target := ref.url(plain)
+	archive, err := registryGet(cfg.Registry, target)
+	if err != nil {
+		return err
+	}
+	sigData, err := registryGet(cfg.Registry, target+".sig")
+	if err != nil {
+		return err
+	}
+	if err := verifyBundle(ref, archive, sigData, cfg.Registry.TrustedKeys); err != nil {
+		return fmt.Errorf("%s: %v", ref, err)
+	}
+
+	if dir == "" {
+		dir = path.Base(ref.repo)
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists", dir)
+	}
+	// Unpack next to the destination and move it into place when whole
+	tmp, err := os.MkdirTemp(filepath.Dir(filepath.Clean(dir)), ".bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := unpackBundle(archive, tmp); err != nil {
+		return fmt.Errorf("unpacking %s: %v", ref, err)
+	}
+	b, err := loadBundle(tmp)
+	if err != nil {
+		return fmt.Errorf("%s: %v", ref, err)
+	}
+	if b.manifest.Version != ref.tag {
+		return fmt.Errorf("%s holds version %s", ref, b.manifest.Version)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return err
+	}
+	fmt.Printf("Pulled %s into %s\n", ref, dir)
+	return nil
+}
+
+// verifyBundle checks an archive against its signature file and the
+// trusted keys
+func verifyBundle(ref bundleRef, archive, sigData []byte, trusted []string) error {
+	var sig BundleSignature
+	if err := json.Unmarshal(sigData, &sig); err != nil {
+		return fmt.Errorf("reading signature: %v", err)
+	}
+	sum := sha256.Sum256(archive)
+	if digest := "sha256:" + hex.EncodeToString(sum[:]); sig.Digest != digest {
+		return fmt.Errorf("archive digest %s does not match the signed %s", digest, sig.Digest)
+	}
+	if !contains(trusted, sig.Key) {
+		return fmt.Errorf("signed by %s, which is not in registry.trusted_keys", sig.Key)
+	}
+	key, err := base64.StdEncoding.DecodeString(sig.Key)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("signature key is not an Ed25519 public key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), bundleSignedMessage(ref, sig.Digest), signature) {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
+
+func registryRequest(rc RegistryConfig, method, target string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token := secretValue(rc.Token); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return (&http.Client{Timeout: registryTimeout}).Do(req)
+}
+
+func registryPut(rc RegistryConfig, target string, body []byte, contentType string) error {
+	resp, err := registryRequest(rc, http.MethodPut, target, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("PUT %s: %s: %s", target, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func registryGet(rc RegistryConfig, target string) ([]byte, error) {
+	resp, err := registryRequest(rc, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleBytes+1))
+	if err == nil && len(data) > maxBundleBytes {
+		err = fmt.Errorf("GET %s: larger than %d bytes", target, maxBundleBytes)
+	}
+	return data, err
+}
+
+// packBundle archives a bundle's directories and regular files in name
+// order with no owners or times, so the same content packs to the same
+// bytes
+func packBundle(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		if rel == "." || !(d.IsDir() || d.Type().IsRegular()) {
+			return nil
+		}
+		hdr := &tar.Header{Name: filepath.ToSlash(rel), Mode: 0644, Typeflag: tar.TypeReg}
+		if d.IsDir() {
+			hdr.Name, hdr.Mode, hdr.Typeflag = hdr.Name+"/", 0755, tar.TypeDir
+			return tw.WriteHeader(hdr)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	return buf.Bytes(), err
+}
+
+// unpackBundle extracts directories and regular files into dir, refusing
+// names that would land outside it
+func unpackBundle(archive []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return fmt.Errorf("%q is outside the bundle", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if total += hdr.Size; total > maxBundleBytes {
+				return fmt.Errorf("unpacks to more than %d bytes", maxBundleBytes)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, io.LimitReader(tr, hdr.Size))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%q is not a file or directory", hdr.Name)
+		}
+	}
+}
+
+// bundleKeygen writes a new signing key and prints its public key for
+// registry.trusted_keys
+func bundleKeygen(file string) error {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(pub))
+	return nil
+}
+
+func loadSigningKey(file string) (ed25519.PrivateKey, error) {
+	if file == "" {
+		return nil, errors.New("pushing needs registry.signing_key in the config (make one with bundle keygen)")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM key", file)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %v", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", file)
+	}
+	return priv, nil
+}
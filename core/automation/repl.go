@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// repl runs script lines typed at a prompt, printing each step's result as
+// it finishes, so one action can be tried without writing a script and
+// running all of it:
+//
+//	$ executor_binary repl
+//	agentos> click 640 300
+//	[1] click ok, screenshot /tmp/screenshots/screenshot_1_click_1.png
+//
+// Lines are steps of one run, as in watch; an on_rollback block is typed
+// at a "...>" prompt up to its end. On a terminal, lines are edited with
+// the arrow keys, Home/End and the usual ctrl keys, and up and down walk
+// the history, kept across sessions in repl_history in the state
+// directory. Lines starting with ":" are for the REPL itself:
+//
+//	:screenshot    take a screenshot now
+//	:help [ACTION] list the actions, or show one
+//	:quit          end the run and leave (also ctrl+d)
+//
+// ctrl+c while a step runs aborts the run after it, rolling it back, and
+// the next line starts a new run. SIGTERM, or SIGINT at the prompt, rolls
+// the run back and leaves.
+
+const (
+	replPrompt        = "agentos> "
+	replBlockPrompt   = "...> "
+	replHistoryLimit  = 1000
+	replHistoryFile   = "repl_history"
+	replScreenshotTag = "repl"
+)
+
+// lineEditor reads lines from the terminal with editing and history
+type lineEditor struct {
+	in      *bufio.Reader
+	tty     bool
+	history []string
+	file    string // where entered lines are appended, "" for none
+
+	mu    sync.Mutex
+	saved string // terminal settings to restore while raw
+}
+
+type replInput struct {
+	line string
+	err  error
+}
+
+func runREPL(args []string) {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	historyPath := fs.String("history", "", "history file (default repl_history in the state directory)")
+	serviceFlags(fs)
+	fs.Parse(args)
+
+	checkServiceSetup()
+	if err := initBackend(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	os.MkdirAll(screenshotsDir, 0755)
+
+	editor := newLineEditor(os.Stdin, firstNonEmpty(*historyPath, filepath.Join(stateDir(), replHistoryFile)))
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	if editor.tty {
+		fmt.Printf("AgentOS executor %s, %s backend. :help lists the actions, :quit leaves.\n", apiVersion, currentBackend().Name())
+	}
+
+	// Lines are read in the background so a signal at the prompt is seen
+	prompts := make(chan string)
+	input := make(chan replInput)
+	go func() {
+		for prompt := range prompts {
+			line, err := editor.readLine(prompt)
+			input <- replInput{line, err}
+		}
+	}()
+
+	run := newRunner()
+	for {
+		prompt := replPrompt
+		if run.collecting != nil {
+			prompt = replBlockPrompt
+		}
+		prompts <- prompt
+		var in replInput
+		select {
+		case in = <-input:
+		case sig := <-interrupted:
+			editor.restore()
+			fmt.Println()
+			interruptREPLRun(run, sig)
+			endREPLRun(run)
+			return
+		}
+		if in.err != nil {
+			if in.err != io.EOF {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", in.err)
+			}
+			break
+		}
+		if cmd := strings.TrimSpace(in.line); strings.HasPrefix(cmd, ":") && run.collecting == nil {
+			if replCommand(cmd, run) {
+				break
+			}
+			continue
+		}
+		ended, _ := runSessionMessage(run, []byte(in.line), func(steps []*StepResult) error {
+			for _, step := range steps {
+				printREPLStep(step)
+			}
+			return nil
+		})
+		select {
+		case sig := <-interrupted:
+			if !ended {
+				interruptREPLRun(run, sig)
+				ended = true
+			}
+			if sig == syscall.SIGTERM {
+				endREPLRun(run)
+				return
+			}
+		default:
+		}
+		if ended {
+			endREPLRun(run)
+			fmt.Println("The run aborted and was rolled back; the next line starts a new run.")
+			run = newRunner()
+		}
+	}
+	if editor.tty {
+		fmt.Println()
+	}
+	run.flushScreenshot()
+	endREPLRun(run)
+	fmt.Printf("%d steps, %s\n", run.result.CommandsExecuted, run.result.Status)
+}
+
+// interruptREPLRun aborts the run for a signal and prints its rollback
+func interruptREPLRun(run *runner, sig os.Signal) {
+	run.abort(fmt.Sprintf("interrupted by %v", sig))
+	for _, step := range run.finish() {
+		printREPLStep(step)
+	}
+}
+
+// replCommand runs a ":" line and reports whether to leave
+func replCommand(line string, run *runner) bool {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":quit", ":exit", ":q":
+		return true
+	case ":screenshot":
+		run.flushScreenshot()
+		if file := takeScreenshot(run.step, replScreenshotTag); file != "" {
+			fmt.Println(file)
+		} else {
+			fmt.Println("no screenshot could be taken")
+		}
+	case ":help":
+		for _, spec := range actionSpecs {
+			if len(fields) > 1 && spec.Name != fields[1] {
+				continue
+			}
+			fmt.Printf("  %-40s %s\n", spec.Syntax, spec.Description)
+		}
+	default:
+		fmt.Printf("unknown command %s (:screenshot, :help, :quit)\n", fields[0])
+	}
+	return false
+}
+
+// printREPLStep prints a step on a line, with its output and events below
+func printREPLStep(step *StepResult) {
+	label := firstNonEmpty(step.Name, step.Action, "step")
+	if step.Status == "error" {
+		fmt.Printf("[%d] %s error: %s\n", step.Step, label, step.Error)
+	} else if step.Screenshot != nil && step.Screenshot.File != "" {
+		fmt.Printf("[%d] %s ok, screenshot %s\n", step.Step, label, step.Screenshot.File)
+	} else {
+		fmt.Printf("[%d] %s ok\n", step.Step, label)
+	}
+	if len(step.Output) > 0 {
+		output, _ := json.Marshal(step.Output)
+		fmt.Printf("    %s\n", output)
+	}
+	for _, e := range step.Events {
+		fmt.Printf("    %s: %s\n", e.Type, e.Message)
+	}
+}
+
+func endREPLRun(run *runner) {
+	run.close()
+	savePortableResult(run.result)
+}
+
+func newLineEditor(in *os.File, historyFile string) *lineEditor {
+	e := &lineEditor{in: bufio.NewReader(in)}
+	if info, err := in.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		// Editing needs the terminal raw, which stty does where there is one
+		if _, err := exec.LookPath("stty"); err == nil {
+			e.tty = true
+		}
+	}
+	if !e.tty {
+		return e
+	}
+	e.file = historyFile
+	if data, err := os.ReadFile(historyFile); err == nil {
+		e.history = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		if len(e.history) > replHistoryLimit {
+			e.history = e.history[len(e.history)-replHistoryLimit:]
+		}
+	}
+	return e
+}
+
+// readLine reads one line, returning io.EOF at the end of input
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if !e.tty {
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return "", err
+	}
+	e.mu.Lock()
+	e.saved = saved
+	e.mu.Unlock()
+	if _, err := stty("raw", "-echo"); err != nil {
+		return "", err
+	}
+	line, err := e.edit(prompt)
+	e.restore()
+	fmt.Print("\r\n")
+	if err == nil {
+		e.remember(line)
+	}
+	return line, err
+}
+
+// restore leaves raw mode
+func (e *lineEditor) restore() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.saved != "" {
+		stty(e.saved)
+		e.saved = ""
+	}
+}
+
+// edit reads keys until Return with the terminal raw
+func (e *lineEditor) edit(prompt string) (string, error) {
+	var buf []rune
+	pos := 0
+	browse := len(e.history) // history entry shown, len for the new line
+	draft := ""
+	redraw := func() {
+		fmt.Printf("\r%s%s\x1b[K", prompt, string(buf))
+		if back := len(buf) - pos; back > 0 {
+			fmt.Printf("\x1b[%dD", back)
+		}
+	}
+	show := func(i int) {
+		if browse == len(e.history) {
+			draft = string(buf)
+		}
+		browse = i
+		if i == len(e.history) {
+			buf = []rune(draft)
+		} else {
+			buf = []rune(e.history[i])
+		}
+		pos = len(buf)
+	}
+	redraw()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			return string(buf), nil
+		case 3: // ctrl+c drops the line
+			fmt.Print("^C\r\n")
+			buf, pos, browse = nil, 0, len(e.history)
+		case 4: // ctrl+d leaves on an empty line
+			if len(buf) == 0 {
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 1: // ctrl+a
+			pos = 0
+		case 5: // ctrl+e
+			pos = len(buf)
+		case 11: // ctrl+k
+			buf = buf[:pos]
+		case 21: // ctrl+u
+			buf, pos = buf[pos:], 0
+		case 127, 8: // backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 27:
+			switch e.escape() {
+			case "A":
+				if browse > 0 {
+					show(browse - 1)
+				}
+			case "B":
+				if browse < len(e.history) {
+					show(browse + 1)
+				}
+			case "C":
+				if pos < len(buf) {
+					pos++
+				}
+			case "D":
+				if pos > 0 {
+					pos--
+				}
+			case "H", "1~":
+				pos = 0
+			case "F", "4~":
+				pos = len(buf)
+			case "3~":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r < ' ' {
+				continue
+			}
+			buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+		redraw()
+	}
+}
+
+// escape reads the rest of an escape sequence after ESC, returning its
+// final part: "A" for ESC [ A or ESC O A, "3~" for ESC [ 3 ~
+func (e *lineEditor) escape() string {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return ""
+	}
+	var seq []rune
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, r)
+		if r < '0' || r > '9' {
+			return string(seq)
+		}
+	}
+}
+
+// remember adds a line to the history and its file
+func (e *lineEditor) remember(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if e.file == "" {
+		return
+	}
+	os.MkdirAll(filepath.Dir(e.file), 0700)
+	if f, err := os.OpenFile(e.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err == nil {
+		fmt.Fprintln(f, line)
+		f.Close()
+	}
+}
+
+// stty runs stty on the terminal and returns what it printed
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"strings"
+)
+
+// reportFormat is set by --report: json prints the full result, md a short
+// Markdown summary an orchestrator can post as is to Slack or a GitHub
+// comment, html a standalone page
+var reportFormat = "json"
+
+var reportFormats = []string{"json", "md", "html"}
+
+const reportMaxEvents = 10
+
+// markdownReport summarizes a result: a status table, then each failure
+// with the screenshot of its step, inline where it has a URL
+func markdownReport(result ExecutionResult) string {
+	var b strings.Builder
+	icon := map[string]string{"success": "✅", "degraded": "⚠️", "error": "❌"}[result.Status]
+	fmt.Fprintf(&b, "### %s Run %s\n\n", icon, result.Status)
+	b.WriteString("| Steps | Errors | Screenshots | Events |\n")
+	b.WriteString("|---|---|---|---|\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d |\n", result.CommandsExecuted, len(result.Errors), len(result.Screenshots), len(result.Events))
+
+	shots := map[int]Screenshot{}
+	for _, shot := range result.Screenshots {
+		shots[shot.Step] = shot
+	}
+	if len(result.Errors) > 0 {
+		b.WriteString("\n**Failures**\n\n")
+		for _, msg := range result.Errors {
+			fmt.Fprintf(&b, "- %s\n", markdownEscape(msg))
+			var step int
+			if _, err := fmt.Sscanf(msg, "Step %d:", &step); err != nil {
+				continue
+			}
+			if shot, ok := shots[step]; ok {
+				fmt.Fprintf(&b, "  %s\n", reportImage(shot))
+			}
+		}
+	}
+
+	if len(result.Events) > 0 {
+		b.WriteString("\n<details><summary>Events</summary>\n\n")
+		for i, ev := range result.Events {
+			if i == reportMaxEvents {
+				fmt.Fprintf(&b, "- … %d more\n", len(result.Events)-i)
+				break
+			}
+			where := ""
+			if ev.Step > 0 {
+				where = fmt.Sprintf("step %d ", ev.Step)
+			}
+			fmt.Fprintf(&b, "- %s`%s` %s\n", where, ev.Type, markdownEscape(ev.Message))
+		}
+		b.WriteString("\n</details>\n")
+	}
+
+	var links []string
+	if u := result.FilmstripUploaded; strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") {
+		links = append(links, fmt.Sprintf("[filmstrip](%s)", u))
+	} else if result.Filmstrip != "" {
+		links = append(links, "filmstrip "+inlineCode(result.Filmstrip))
+	}
+	if n := len(result.Screenshots); n > 0 {
+		last := result.Screenshots[n-1]
+		if link := shotURL(last); link != "" {
+			links = append(links, fmt.Sprintf("[last screenshot](%s)", link))
+		} else {
+			links = append(links, "screenshots in "+inlineCode(filepath.Dir(last.File)))
+		}
+	}
+	if len(links) > 0 {
+		fmt.Fprintf(&b, "\n%s\n", strings.Join(links, " · "))
+	}
+	return b.String()
+}
+
+// shotURL is where a screenshot can be fetched from, if anywhere
+func shotURL(shot Screenshot) string {
+	if shot.URL != "" {
+		return shot.URL
+	}
+	if strings.HasPrefix(shot.Uploaded, "http://") || strings.HasPrefix(shot.Uploaded, "https://") {
+		return shot.Uploaded
+	}
+	return ""
+}
+
+// reportImage shows a screenshot inline when it has a URL, or names the
+// local file otherwise
+func reportImage(shot Screenshot) string {
+	alt := fmt.Sprintf("step %d %s", shot.Step, shot.Action)
+	if link := shotURL(shot); link != "" {
+		return fmt.Sprintf("[![%s](%s)](%s)", alt, link, link)
+	}
+	return alt + ": " + inlineCode(shot.File)
+}
+
+// markdownEscape keeps text from being read as Markdown or HTML
+func markdownEscape(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("\\`*_[]<>|#~", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// reportFailure is a failed step with its screenshot, for the HTML report
+type reportFailure struct {
+	Message string
+	Shot    *Screenshot
+	Src     template.URL // our own links and cid: references
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Run {{.Result.Status}}</title>
+<style>
+body { font-family: sans-serif; margin: 24px; color: #222 }
+table { border-collapse: collapse; margin: 12px 0 }
+td, th { border: 1px solid #ccc; padding: 4px 10px; text-align: left }
+.success { color: #1a7f37 } .degraded { color: #9a6700 } .error { color: #cf222e }
+img { max-width: 640px; border: 1px solid #ccc; display: block; margin: 6px 0 16px }
+code { background: #f3f3f3; padding: 1px 4px }
+</style></head><body>
+<h2>Run <span class="{{.Result.Status}}">{{.Result.Status}}</span>{{if .Title}} &mdash; {{.Title}}{{end}}</h2>
+<table>
+<tr><th>Steps</th><th>Errors</th><th>Screenshots</th><th>Events</th></tr>
+<tr><td>{{.Result.CommandsExecuted}}</td><td>{{len .Result.Errors}}</td><td>{{len .Result.Screenshots}}</td><td>{{len .Result.Events}}</td></tr>
+</table>
+{{if .Failures}}<h3>Failures</h3>
+{{range .Failures}}<p>{{.Message}}</p>
+{{if .Src}}<img src="{{.Src}}" alt="step {{.Shot.Step}} {{.Shot.Action}}">
+{{else if .Shot}}<p><code>{{.Shot.File}}</code></p>
+{{end}}{{end}}{{end}}
+{{if .Result.Events}}<h3>Events</h3>
+<table>
+<tr><th>Step</th><th>Type</th><th>Message</th></tr>
+{{range .Result.Events}}<tr><td>{{if .Step}}{{.Step}}{{end}}</td><td><code>{{.Type}}</code></td><td>{{.Message}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Result.Screenshots}}<h3>Screenshots</h3>
+<table>
+<tr><th>Step</th><th>Action</th><th>File</th></tr>
+{{range .Result.Screenshots}}<tr><td>{{.Step}}</td><td>{{.Action}}</td><td><code>{{.File}}</code></td></tr>
+{{end}}</table>{{end}}
+</body></html>
+`))
+
+// htmlReport renders a result as a page. src gives the image source for a
+// failing step's screenshot, or "" to name the file instead.
+func htmlReport(result ExecutionResult, title string, src func(Screenshot) string) string {
+	shots := map[int]Screenshot{}
+	for _, shot := range result.Screenshots {
+		shots[shot.Step] = shot
+	}
+	var failures []reportFailure
+	for _, msg := range result.Errors {
+		f := reportFailure{Message: msg}
+		var step int
+		if _, err := fmt.Sscanf(msg, "Step %d:", &step); err == nil {
+			if shot, ok := shots[step]; ok {
+				f.Shot, f.Src = &shot, template.URL(src(shot))
+			}
+		}
+		failures = append(failures, f)
+	}
+	var b strings.Builder
+	htmlReportTemplate.Execute(&b, map[string]interface{}{"Result": result, "Title": title, "Failures": failures})
+	return b.String()
+}
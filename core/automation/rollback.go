@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Compensation blocks. A block written after a step is registered once
+// that step succeeds; a block before the first step belongs to the whole
+// script:
+//
+//	click_text Settings
+//	on_rollback
+//	key Escape
+//	end
+//
+// Once any block is registered, the first failing step aborts the run and
+// the registered blocks run newest first to restore the desktop.
+
+type rollbackBlock struct {
+	step  int // 0 for the whole script
+	lines []string
+}
+
+// blockLine collects on_rollback blocks. It reports whether the line was
+// consumed.
+func (r *runner) blockLine(line string) bool {
+	if r.collecting != nil {
+		switch {
+		case strings.EqualFold(line, "end"):
+			block := r.collecting
+			r.collecting = nil
+			if block.step == 0 || r.last.Status == "success" {
+				r.rollbacks = append(r.rollbacks, *block)
+			}
+		case strings.EqualFold(line, "on_rollback"):
+			r.result.Errors = append(r.result.Errors, fmt.Sprintf("After step %d: on_rollback blocks cannot be nested", r.step))
+			r.result.Status = "error"
+		case line != "" && !strings.HasPrefix(line, "#"):
+			r.collecting.lines = append(r.collecting.lines, line)
+		}
+		return true
+	}
+	if !strings.EqualFold(line, "on_rollback") {
+		return false
+	}
+	r.collecting = &rollbackBlock{}
+	if r.last != nil {
+		r.collecting.step = r.last.Step
+	}
+	return true
+}
+
+// rollback runs the registered blocks in reverse order and returns their
+// steps. Failures are reported but do not stop the remaining blocks.
+func (r *runner) rollback() []*StepResult {
+	var steps []*StepResult
+	r.rollingBack = true
+	defer func() { r.rollingBack = false }()
+	for i := len(r.rollbacks) - 1; i >= 0; i-- {
+		block := r.rollbacks[i]
+		target := "the script"
+		if block.step > 0 {
+			target = fmt.Sprintf("step %d", block.step)
+		}
+		for _, line := range block.lines {
+			start := clock.Now()
+			step := r.runStep(line)
+			if step == nil {
+				continue
+			}
+			r.transcript.add(start, line, step)
+			event := Event{Step: step.Step, Type: "rollback", Message: "compensating " + target}
+			r.result.Events = append(r.result.Events, event)
+			step.Events = append(step.Events, event)
+			steps = append(steps, step)
+		}
+	}
+	r.rollbacks = nil
+	r.flushScreenshot()
+	return steps
+}
+
+// finish ends a script: an unclosed block or section is an error, and an
+// aborted run is rolled back
+func (r *runner) finish() []*StepResult {
+	r.flushScreenshot()
+	r.releaseHeldKeys(nil, "the script ended")
+	if r.collecting != nil {
+		r.result.Errors = append(r.result.Errors, "on_rollback block is missing its end")
+		r.result.Status = "error"
+		r.collecting = nil
+	}
+	if r.playbook != nil {
+		r.result.Errors = append(r.result.Errors, "recover block is missing its end")
+		r.result.Status = "error"
+		r.playbook = nil
+	}
+	if r.inSetup {
+		r.result.Errors = append(r.result.Errors, "setup: section is missing its end")
+		r.result.Status = "error"
+		r.inSetup = false
+	}
+	r.settleBudget()
+	r.result.Cache = cacheStats()
+	if !r.aborted {
+		return nil
+	}
+	return r.rollback()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every script posted to /execute is a run with a request ID, the client's
+// X-Request-ID or a generated one. With ?wait=false the request returns at
+// once and the run is polled at /status/ID; the last keptRuns finished runs
+// stay retrievable either way.
+
+const keptRuns = 100
+
+// serveRun is a script submitted to /execute and, once done, its result
+type serveRun struct {
+	ID        string           `json:"id"`
+	Status    string           `json:"status"` // queued, running or done
+	Submitted time.Time        `json:"submitted"`
+	Started   *time.Time       `json:"started,omitempty"`
+	Finished  *time.Time       `json:"finished,omitempty"`
+	Result    *ExecutionResult `json:"result,omitempty"`
+}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// newRun registers a queued run under the request's ID
+func (s *server) newRun(r *http.Request) (*serveRun, int, string) {
+	id := r.Header.Get("X-Request-ID")
+	if id == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	} else if !requestIDPattern.MatchString(id) {
+		return nil, http.StatusBadRequest, "X-Request-ID must be 1 to 64 letters, digits, dots, dashes or underscores"
+	}
+	s.runsMu.Lock()
+	defer s.runsMu.Unlock()
+	if s.runs == nil {
+		s.runs = map[string]*serveRun{}
+	}
+	if _, ok := s.runs[id]; ok {
+		return nil, http.StatusConflict, "request " + id + " already exists"
+	}
+	run := &serveRun{ID: id, Status: "queued", Submitted: time.Now().UTC()}
+	s.runs[id] = run
+	s.runOrder = append(s.runOrder, id)
+	// Forget the oldest finished runs; queued and running ones are kept
+	done := 0
+	for _, id := range s.runOrder {
+		if s.runs[id].Status == "done" {
+			done++
+		}
+	}
+	kept := s.runOrder[:0]
+	for _, id := range s.runOrder {
+		if done > keptRuns && s.runs[id].Status == "done" {
+			delete(s.runs, id)
+			done--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.runOrder = kept
+	return run, 0, ""
+}
+
+// setRunStatus moves a run on to running or done
+func (s *server) setRunStatus(run *serveRun, status string, result *ExecutionResult) {
+	s.runsMu.Lock()
+	defer s.runsMu.Unlock()
+	now := time.Now().UTC()
+	run.Status = status
+	if status == "running" {
+		run.Started = &now
+	} else {
+		run.Finished = &now
+		run.Result = result
+	}
+}
+
+// snapshot copies a run for a response, its screenshots linked afresh so
+// an old result's links still work
+func (s *server) snapshot(run *serveRun) serveRun {
+	s.runsMu.Lock()
+	out := *run
+	s.runsMu.Unlock()
+	if out.Result != nil {
+		result := s.linked(*out.Result)
+		out.Result = &result
+	}
+	return out
+}
+
+// linked gives a result's screenshots signed links
+func (s *server) linked(result ExecutionResult) ExecutionResult {
+	expires := time.Now().Add(s.ttl)
+	result.Screenshots = append([]Screenshot(nil), result.Screenshots...)
+	for i, shot := range result.Screenshots {
+		if link, err := s.signedURL(shot.File, expires); err == nil {
+			result.Screenshots[i].URL = link
+		}
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleStatus reports what the server is doing at /status, and one run
+// at /status/ID
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if id := strings.TrimPrefix(r.URL.Path, "/status/"); id != r.URL.Path {
+		s.runsMu.Lock()
+		run, ok := s.runs[id]
+		s.runsMu.Unlock()
+		if !ok {
+			http.Error(w, "no such request", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.snapshot(run))
+		return
+	}
+	s.runsMu.Lock()
+	var runs []serveRun
+	current := ""
+	for i := len(s.runOrder) - 1; i >= 0; i-- {
+		run := *s.runs[s.runOrder[i]]
+		if run.Status == "running" {
+			current = run.ID
+		}
+		run.Result = nil
+		runs = append(runs, run)
+	}
+	s.runsMu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"backend": backendName,
+		"current": current,
+		"session": s.session.Load(),
+		"queued":  s.waiting.Load(),
+		"runs":    runs,
+	})
+}
+
+// handleScreenshot returns the screen as it is now as a PNG, scaled to at
+// most ?width, redacted and masked as stored screenshots are. It does not
+// wait for a running script.
+func (s *server) handleScreenshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	width := 0
+	if v := r.URL.Query().Get("width"); v != "" {
+		var err error
+		if width, err = strconv.Atoi(v); err != nil || width <= 0 {
+			http.Error(w, "width must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	frame, err := captureFrame(width)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := encodePNG(frame)
+	releaseFrame(frame)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Applications started with launch_sandboxed get a throwaway home: HOME
+// and the XDG base directories point into a temporary directory made for
+// the run, and browsers get a fresh profile there too. At the end of the
+// run the applications are stopped and the directory is removed, so the
+// real user's settings, history and caches never see automated runs.
+
+// sandbox is a run's profile directory and what runs in it. The branches
+// of a parallel block may launch into it at once, so it has its own lock.
+type sandbox struct {
+	mu    sync.Mutex
+	dir   string
+	procs []*os.Process
+}
+
+// appLauncher is implemented by backends that start applications their
+// own way instead of as local processes
+type appLauncher interface {
+	launchApp(app string, args, env []string) error
+}
+
+// profileArgs adds the options that point an application at its own
+// profile, for applications that keep it outside the XDG directories
+var profileArgs = map[string]func(dir string) []string{
+	"firefox": func(dir string) []string { return []string{"-profile", dir, "-no-remote"} },
+	"chromium": func(dir string) []string {
+		return []string{"--user-data-dir=" + dir, "--no-first-run", "--no-default-browser-check"}
+	},
+	"google-chrome": func(dir string) []string {
+		return []string{"--user-data-dir=" + dir, "--no-first-run", "--no-default-browser-check"}
+	},
+	"code": func(dir string) []string {
+		return []string{"--user-data-dir=" + dir, "--extensions-dir=" + filepath.Join(dir, "extensions"), "--new-window"}
+	},
+}
+
+// launchApp starts app, detached from the executor, with the step's
+// environment and, if sandboxed, in the run's sandbox sb. Returns where the
+// sandbox lives, or "" when not sandboxed.
+func launchApp(app string, args []string, sandboxed bool, le launchEnv, sb *sandbox) (string, error) {
+	if currentTarget != nil {
+		return currentTarget.launch(app, args, sandboxed, le)
+	}
+	dir, err := le.localDir()
+	if err != nil {
+		return "", err
+	}
+	env := mergeEnv(os.Environ(), le.vars)
+	var home string
+	if sandboxed {
+		if sb == nil {
+			return "", fmt.Errorf("no run to sandbox %s in", app)
+		}
+		if home, err = sb.make(); err != nil {
+			return "", fmt.Errorf("creating sandbox: %v", err)
+		}
+		env = sandboxEnv(env, home)
+		if profile, ok := profileArgs[filepath.Base(app)]; ok {
+			pdir := filepath.Join(home, "profiles", filepath.Base(app))
+			if err := os.MkdirAll(pdir, 0700); err != nil {
+				return "", err
+			}
+			args = append(profile(pdir), args...)
+		}
+	}
+	if l, ok := baseBackend().(appLauncher); ok {
+		return home, l.launchApp(app, args, env)
+	}
+	cmd := exec.Command(app, args...)
+	cmd.Env, cmd.Dir = env, dir
+	detachGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	if sandboxed {
+		sb.mu.Lock()
+		sb.procs = append(sb.procs, cmd.Process)
+		sb.mu.Unlock()
+	}
+	// Reap it whenever it exits
+	go cmd.Wait()
+	return home, nil
+}
+
+// make creates the sandbox's directory on first use
+func (sb *sandbox) make() (string, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.dir != "" {
+		return sb.dir, nil
+	}
+	dir, err := os.MkdirTemp("", "agentos-sandbox-")
+	if err != nil {
+		return "", err
+	}
+	for _, sub := range []string{"home", "config", "data", "cache", "state"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	sb.dir = dir
+	return dir, nil
+}
+
+// sandboxVars points home and the XDG directories into dir
+func sandboxVars(dir string) map[string]string {
+	return map[string]string{
+		"HOME":            dir + "/home",
+		"XDG_CONFIG_HOME": dir + "/config",
+		"XDG_DATA_HOME":   dir + "/data",
+		"XDG_CACHE_HOME":  dir + "/cache",
+		"XDG_STATE_HOME":  dir + "/state",
+	}
+}
+
+// sandboxEnv is env with sandboxVars. The display credentials stay those
+// of the real home, which the new one lacks.
+func sandboxEnv(env []string, dir string) []string {
+	set := sandboxVars(dir)
+	if os.Getenv("XAUTHORITY") == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			set["XAUTHORITY"] = filepath.Join(home, ".Xauthority")
+		}
+	}
+	return mergeEnv(env, set)
+}
+
+// closeSandbox stops what the run launched in its sandbox sb and removes it
+func closeSandbox(sb *sandbox) {
+	if currentTarget != nil {
+		currentTarget.close()
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for _, p := range sb.procs {
+		killGroup(p)
+	}
+	if sb.dir != "" {
+		if err := os.RemoveAll(sb.dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: removing sandbox %s: %v\n", sb.dir, err)
+		}
+	}
+	sb.dir, sb.procs = "", nil
+}
+
+var mockLaunches int
+
+// The mock screen opens a window named after the application, showing its
+// arguments
+func (m *mockBackend) launchApp(app string, args, env []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mockLaunches++
+	n := len(m.windows)
+	w := &mockWindow{MockWindow: MockWindow{Title: filepath.Base(app), X: 100 + 30*n, Y: 80 + 30*n,
+		Width: 480, Height: 320, Text: strings.Join(args, "\n")}, id: fmt.Sprintf("launched-%d", mockLaunches)}
+	m.windows = append(m.windows, w)
+	m.focused = w
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Keys typed at a lock screen go into its password field, and clicks land
+// on nothing. Before each step that sends input or changes the screen the
+// session's lock state is read, from logind's LockedHint or else the
+// screen saver's D-Bus interface, and a locked session fails the step
+// with a SESSION_LOCKED error, aborting the run. screen_lock.policy
+// "wait" waits for someone to unlock it instead, up to screen_lock.wait
+// if set, and "ignore" skips the check:
+//
+//	{"screen_lock": {"policy": "wait", "wait": "30m"}}
+//
+// The executor never unlocks the session itself. Only local desktops are
+// checked: not --headless servers, VMs, VNC or devices, and not where
+// neither logind nor the screen saver can tell.
+
+// ScreenLockConfig sets what a step does on a locked session
+type ScreenLockConfig struct {
+	Policy string `json:"policy"` // fail, wait or ignore (default fail)
+	// Wait is the longest the wait policy waits, e.g. "30m" (default: as
+	// long as it takes)
+	Wait string `json:"wait"`
+}
+
+var screenLockPolicies = []string{"fail", "wait", "ignore"}
+
+// screenLockPoll is how often a locked session is checked while waiting
+const screenLockPoll = 2 * time.Second
+
+// sessionLockedError starts the error of a step that met a locked session
+const sessionLockedError = "SESSION_LOCKED"
+
+// lockCheckedBackends are the backends that drive the session logind and
+// the screen saver describe
+var lockCheckedBackends = map[string]bool{"x11": true, "xdotool": true, "wayland": true, "uinput": true}
+
+func checkScreenLockConfig(c ScreenLockConfig) error {
+	if c.Policy != "" && !contains(screenLockPolicies, c.Policy) {
+		return fmt.Errorf("screen_lock.policy: want fail, wait or ignore, got %q", c.Policy)
+	}
+	if c.Wait != "" {
+		if d, err := time.ParseDuration(c.Wait); err != nil || d <= 0 {
+			return fmt.Errorf("screen_lock.wait: invalid duration %q", c.Wait)
+		}
+	}
+	return nil
+}
+
+// sessionLocked reads whether the session is locked
+func sessionLocked() (bool, error) {
+	props, err := logindSession("LockedHint")
+	if err == nil && props["LockedHint"] != "" {
+		return props["LockedHint"] == "yes", nil
+	}
+	out, serr := exec.Command("gdbus", "call", "--session", "--dest", "org.freedesktop.ScreenSaver",
+		"--object-path", "/org/freedesktop/ScreenSaver", "--method", "org.freedesktop.ScreenSaver.GetActive").Output()
+	if serr != nil {
+		return false, fmt.Errorf("logind: %v; screen saver: %v", err, commandError(serr))
+	}
+	return strings.Contains(string(out), "true"), nil
+}
+
+// checkScreenLock stops a step from running into a locked session. It
+// returns the event of a wait for an unlock, or the step's error.
+func (r *runner) checkScreenLock(step int) (*Event, error) {
+	if r.headless != nil || !lockCheckedBackends[baseBackend().Name()] {
+		return nil, nil
+	}
+	cfg, err := currentConfig()
+	if err != nil || cfg.ScreenLock.Policy == "ignore" {
+		return nil, nil
+	}
+	if locked, err := sessionLocked(); err != nil || !locked {
+		return nil, nil // unknown counts as unlocked
+	}
+	if cfg.ScreenLock.Policy != "wait" {
+		return nil, fmt.Errorf("%s: the session is locked", sessionLockedError)
+	}
+	limit, _ := time.ParseDuration(cfg.ScreenLock.Wait) // validated at startup
+	fmt.Fprintf(os.Stderr, "Warning: the session is locked, waiting for it to be unlocked before step %d\n", step)
+	start := clock.Now()
+	for {
+		clock.Sleep(screenLockPoll)
+		if locked, err := sessionLocked(); err == nil && !locked {
+			break
+		}
+		if limit > 0 && since(start) >= limit {
+			return nil, fmt.Errorf("%s: the session is still locked after %v", sessionLockedError, limit)
+		}
+	}
+	return &Event{Step: step, Type: "session_unlocked",
+		Message: fmt.Sprintf("waited %.1fs for the session to be unlocked", since(start).Seconds())}, nil
+}
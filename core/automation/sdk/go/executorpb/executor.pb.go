@@ -0,0 +1,826 @@
+// gRPC API of the automation executor, served by `executor_binary serve
+// --grpc ADDR` with server reflection. Clients in sdk/ are generated from
+// this file with generate.sh; keep field numbers stable, and keep the
+// tables in protobuf.go, which the server encodes and reflects from, in
+// step with this file.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: executor.proto
+
+package executorpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ExecuteRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Script string                 `protobuf:"bytes,1,opt,name=script,proto3" json:"script,omitempty"`
+	// text (the default), json or yaml, as with --format
+	Format        string `protobuf:"bytes,2,opt,name=format,proto3" json:"format,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExecuteRequest) Reset() {
+	*x = ExecuteRequest{}
+	mi := &file_executor_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExecuteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExecuteRequest) ProtoMessage() {}
+
+func (x *ExecuteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_executor_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExecuteRequest.ProtoReflect.Descriptor instead.
+func (*ExecuteRequest) Descriptor() ([]byte, []int) {
+	return file_executor_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ExecuteRequest) GetScript() string {
+	if x != nil {
+		return x.Script
+	}
+	return ""
+}
+
+func (x *ExecuteRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+type CommandRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// One or more script lines
+	Line          string `protobuf:"bytes,1,opt,name=line,proto3" json:"line,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandRequest) Reset() {
+	*x = CommandRequest{}
+	mi := &file_executor_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandRequest) ProtoMessage() {}
+
+func (x *CommandRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_executor_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandRequest.ProtoReflect.Descriptor instead.
+func (*CommandRequest) Descriptor() ([]byte, []int) {
+	return file_executor_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CommandRequest) GetLine() string {
+	if x != nil {
+		return x.Line
+	}
+	return ""
+}
+
+type Screenshot struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Step     int32                  `protobuf:"varint,1,opt,name=step,proto3" json:"step,omitempty"`
+	File     string                 `protobuf:"bytes,2,opt,name=file,proto3" json:"file,omitempty"`
+	Action   string                 `protobuf:"bytes,3,opt,name=action,proto3" json:"action,omitempty"`
+	Url      string                 `protobuf:"bytes,4,opt,name=url,proto3" json:"url,omitempty"`
+	Uploaded string                 `protobuf:"bytes,5,opt,name=uploaded,proto3" json:"uploaded,omitempty"`
+	// The step's @name
+	Name          string `protobuf:"bytes,6,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Screenshot) Reset() {
+	*x = Screenshot{}
+	mi := &file_executor_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Screenshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Screenshot) ProtoMessage() {}
+
+func (x *Screenshot) ProtoReflect() protoreflect.Message {
+	mi := &file_executor_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Screenshot.ProtoReflect.Descriptor instead.
+func (*Screenshot) Descriptor() ([]byte, []int) {
+	return file_executor_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Screenshot) GetStep() int32 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *Screenshot) GetFile() string {
+	if x != nil {
+		return x.File
+	}
+	return ""
+}
+
+func (x *Screenshot) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *Screenshot) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Screenshot) GetUploaded() string {
+	if x != nil {
+		return x.Uploaded
+	}
+	return ""
+}
+
+func (x *Screenshot) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Step          int32                  `protobuf:"varint,1,opt,name=step,proto3" json:"step,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_executor_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_executor_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_executor_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Event) GetStep() int32 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type StepOutput struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Step   int32                  `protobuf:"varint,1,opt,name=step,proto3" json:"step,omitempty"`
+	Action string                 `protobuf:"bytes,2,opt,name=action,proto3" json:"action,omitempty"`
+	// JSON object, as in the executor's JSON output
+	DataJson      string `protobuf:"bytes,3,opt,name=data_json,json=dataJson,proto3" json:"data_json,omitempty"`
+	Name          string `protobuf:"bytes,4,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StepOutput) Reset() {
+	*x = StepOutput{}
+	mi := &file_executor_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StepOutput) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StepOutput) ProtoMessage() {}
+
+func (x *StepOutput) ProtoReflect() protoreflect.Message {
+	mi := &file_executor_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StepOutput.ProtoReflect.Descriptor instead.
+func (*StepOutput) Descriptor() ([]byte, []int) {
+	return file_executor_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StepOutput) GetStep() int32 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *StepOutput) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *StepOutput) GetDataJson() string {
+	if x != nil {
+		return x.DataJson
+	}
+	return ""
+}
+
+func (x *StepOutput) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type StepResult struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Step       int32                  `protobuf:"varint,1,opt,name=step,proto3" json:"step,omitempty"`
+	Action     string                 `protobuf:"bytes,2,opt,name=action,proto3" json:"action,omitempty"`
+	Status     string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Error      string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	Screenshot *Screenshot            `protobuf:"bytes,5,opt,name=screenshot,proto3" json:"screenshot,omitempty"`
+	OutputJson string                 `protobuf:"bytes,6,opt,name=output_json,json=outputJson,proto3" json:"output_json,omitempty"`
+	Events     []*Event               `protobuf:"bytes,7,rep,name=events,proto3" json:"events,omitempty"`
+	Name       string                 `protobuf:"bytes,8,opt,name=name,proto3" json:"name,omitempty"`
+	// Sound played during the step, with --audio
+	Audio         bool `protobuf:"varint,9,opt,name=audio,proto3" json:"audio,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StepResult) Reset() {
+	*x = StepResult{}
+	mi := &file_executor_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StepResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StepResult) ProtoMessage() {}
+
+func (x *StepResult) ProtoReflect() protoreflect.Message {
+	mi := &file_executor_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StepResult.ProtoReflect.Descriptor instead.
+func (*StepResult) Descriptor() ([]byte, []int) {
+	return file_executor_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *StepResult) GetStep() int32 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *StepResult) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *StepResult) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *StepResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *StepResult) GetScreenshot() *Screenshot {
+	if x != nil {
+		return x.Screenshot
+	}
+	return nil
+}
+
+func (x *StepResult) GetOutputJson() string {
+	if x != nil {
+		return x.OutputJson
+	}
+	return ""
+}
+
+func (x *StepResult) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+func (x *StepResult) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *StepResult) GetAudio() bool {
+	if x != nil {
+		return x.Audio
+	}
+	return false
+}
+
+type ExecutionResult struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Status           string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	CommandsExecuted int32                  `protobuf:"varint,2,opt,name=commands_executed,json=commandsExecuted,proto3" json:"commands_executed,omitempty"`
+	Screenshots      []*Screenshot          `protobuf:"bytes,3,rep,name=screenshots,proto3" json:"screenshots,omitempty"`
+	Errors           []string               `protobuf:"bytes,4,rep,name=errors,proto3" json:"errors,omitempty"`
+	Events           []*Event               `protobuf:"bytes,5,rep,name=events,proto3" json:"events,omitempty"`
+	Outputs          []*StepOutput          `protobuf:"bytes,6,rep,name=outputs,proto3" json:"outputs,omitempty"`
+	// Animation of the run's screenshots, with --filmstrip
+	Filmstrip string `protobuf:"bytes,7,opt,name=filmstrip,proto3" json:"filmstrip,omitempty"`
+	// Use of the run's caches
+	Cache *CacheStats `protobuf:"bytes,8,opt,name=cache,proto3" json:"cache,omitempty"`
+	// Where --push sent the filmstrip
+	FilmstripUploaded string `protobuf:"bytes,9,opt,name=filmstrip_uploaded,json=filmstripUploaded,proto3" json:"filmstrip_uploaded,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ExecutionResult) Reset() {
+	*x = ExecutionResult{}
+	mi := &file_executor_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExecutionResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExecutionResult) ProtoMessage() {}
+
+func (x *ExecutionResult) ProtoReflect() protoreflect.Message {
+	mi := &file_executor_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExecutionResult.ProtoReflect.Descriptor instead.
+func (*ExecutionResult) Descriptor() ([]byte, []int) {
+	return file_executor_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ExecutionResult) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ExecutionResult) GetCommandsExecuted() int32 {
+	if x != nil {
+		return x.CommandsExecuted
+	}
+	return 0
+}
+
+func (x *ExecutionResult) GetScreenshots() []*Screenshot {
+	if x != nil {
+		return x.Screenshots
+	}
+	return nil
+}
+
+func (x *ExecutionResult) GetErrors() []string {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
+func (x *ExecutionResult) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+func (x *ExecutionResult) GetOutputs() []*StepOutput {
+	if x != nil {
+		return x.Outputs
+	}
+	return nil
+}
+
+func (x *ExecutionResult) GetFilmstrip() string {
+	if x != nil {
+		return x.Filmstrip
+	}
+	return ""
+}
+
+func (x *ExecutionResult) GetCache() *CacheStats {
+	if x != nil {
+		return x.Cache
+	}
+	return nil
+}
+
+func (x *ExecutionResult) GetFilmstripUploaded() string {
+	if x != nil {
+		return x.FilmstripUploaded
+	}
+	return ""
+}
+
+type CacheCounts struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hits          int32                  `protobuf:"varint,1,opt,name=hits,proto3" json:"hits,omitempty"`
+	Misses        int32                  `protobuf:"varint,2,opt,name=misses,proto3" json:"misses,omitempty"`
+	Entries       int32                  `protobuf:"varint,3,opt,name=entries,proto3" json:"entries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CacheCounts) Reset() {
+	*x = CacheCounts{}
+	mi := &file_executor_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CacheCounts) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CacheCounts) ProtoMessage() {}
+
+func (x *CacheCounts) ProtoReflect() protoreflect.Message {
+	mi := &file_executor_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CacheCounts.ProtoReflect.Descriptor instead.
+func (*CacheCounts) Descriptor() ([]byte, []int) {
+	return file_executor_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CacheCounts) GetHits() int32 {
+	if x != nil {
+		return x.Hits
+	}
+	return 0
+}
+
+func (x *CacheCounts) GetMisses() int32 {
+	if x != nil {
+		return x.Misses
+	}
+	return 0
+}
+
+func (x *CacheCounts) GetEntries() int32 {
+	if x != nil {
+		return x.Entries
+	}
+	return 0
+}
+
+type CacheStats struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Templates *CacheCounts           `protobuf:"bytes,1,opt,name=templates,proto3" json:"templates,omitempty"`
+	Regexps   *CacheCounts           `protobuf:"bytes,2,opt,name=regexps,proto3" json:"regexps,omitempty"`
+	// Hits reuse the running accessibility helper; misses start it
+	A11Y *CacheCounts `protobuf:"bytes,3,opt,name=a11y,proto3" json:"a11y,omitempty"`
+	// Hits are whole-screen reads that reused words of the last one
+	Ocr           *CacheCounts `protobuf:"bytes,4,opt,name=ocr,proto3" json:"ocr,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CacheStats) Reset() {
+	*x = CacheStats{}
+	mi := &file_executor_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CacheStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CacheStats) ProtoMessage() {}
+
+func (x *CacheStats) ProtoReflect() protoreflect.Message {
+	mi := &file_executor_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CacheStats.ProtoReflect.Descriptor instead.
+func (*CacheStats) Descriptor() ([]byte, []int) {
+	return file_executor_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CacheStats) GetTemplates() *CacheCounts {
+	if x != nil {
+		return x.Templates
+	}
+	return nil
+}
+
+func (x *CacheStats) GetRegexps() *CacheCounts {
+	if x != nil {
+		return x.Regexps
+	}
+	return nil
+}
+
+func (x *CacheStats) GetA11Y() *CacheCounts {
+	if x != nil {
+		return x.A11Y
+	}
+	return nil
+}
+
+func (x *CacheStats) GetOcr() *CacheCounts {
+	if x != nil {
+		return x.Ocr
+	}
+	return nil
+}
+
+var File_executor_proto protoreflect.FileDescriptor
+
+const file_executor_proto_rawDesc = "" +
+	"\n" +
+	"\x0eexecutor.proto\x12\x13agentos.executor.v1\"@\n" +
+	"\x0eExecuteRequest\x12\x16\n" +
+	"\x06script\x18\x01 \x01(\tR\x06script\x12\x16\n" +
+	"\x06format\x18\x02 \x01(\tR\x06format\"$\n" +
+	"\x0eCommandRequest\x12\x12\n" +
+	"\x04line\x18\x01 \x01(\tR\x04line\"\x8e\x01\n" +
+	"\n" +
+	"Screenshot\x12\x12\n" +
+	"\x04step\x18\x01 \x01(\x05R\x04step\x12\x12\n" +
+	"\x04file\x18\x02 \x01(\tR\x04file\x12\x16\n" +
+	"\x06action\x18\x03 \x01(\tR\x06action\x12\x10\n" +
+	"\x03url\x18\x04 \x01(\tR\x03url\x12\x1a\n" +
+	"\buploaded\x18\x05 \x01(\tR\buploaded\x12\x12\n" +
+	"\x04name\x18\x06 \x01(\tR\x04name\"I\n" +
+	"\x05Event\x12\x12\n" +
+	"\x04step\x18\x01 \x01(\x05R\x04step\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\"i\n" +
+	"\n" +
+	"StepOutput\x12\x12\n" +
+	"\x04step\x18\x01 \x01(\x05R\x04step\x12\x16\n" +
+	"\x06action\x18\x02 \x01(\tR\x06action\x12\x1b\n" +
+	"\tdata_json\x18\x03 \x01(\tR\bdataJson\x12\x12\n" +
+	"\x04name\x18\x04 \x01(\tR\x04name\"\xa6\x02\n" +
+	"\n" +
+	"StepResult\x12\x12\n" +
+	"\x04step\x18\x01 \x01(\x05R\x04step\x12\x16\n" +
+	"\x06action\x18\x02 \x01(\tR\x06action\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12?\n" +
+	"\n" +
+	"screenshot\x18\x05 \x01(\v2\x1f.agentos.executor.v1.ScreenshotR\n" +
+	"screenshot\x12\x1f\n" +
+	"\voutput_json\x18\x06 \x01(\tR\n" +
+	"outputJson\x122\n" +
+	"\x06events\x18\a \x03(\v2\x1a.agentos.executor.v1.EventR\x06events\x12\x12\n" +
+	"\x04name\x18\b \x01(\tR\x04name\x12\x14\n" +
+	"\x05audio\x18\t \x01(\bR\x05audio\"\xa4\x03\n" +
+	"\x0fExecutionResult\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12+\n" +
+	"\x11commands_executed\x18\x02 \x01(\x05R\x10commandsExecuted\x12A\n" +
+	"\vscreenshots\x18\x03 \x03(\v2\x1f.agentos.executor.v1.ScreenshotR\vscreenshots\x12\x16\n" +
+	"\x06errors\x18\x04 \x03(\tR\x06errors\x122\n" +
+	"\x06events\x18\x05 \x03(\v2\x1a.agentos.executor.v1.EventR\x06events\x129\n" +
+	"\aoutputs\x18\x06 \x03(\v2\x1f.agentos.executor.v1.StepOutputR\aoutputs\x12\x1c\n" +
+	"\tfilmstrip\x18\a \x01(\tR\tfilmstrip\x125\n" +
+	"\x05cache\x18\b \x01(\v2\x1f.agentos.executor.v1.CacheStatsR\x05cache\x12-\n" +
+	"\x12filmstrip_uploaded\x18\t \x01(\tR\x11filmstripUploaded\"S\n" +
+	"\vCacheCounts\x12\x12\n" +
+	"\x04hits\x18\x01 \x01(\x05R\x04hits\x12\x16\n" +
+	"\x06misses\x18\x02 \x01(\x05R\x06misses\x12\x18\n" +
+	"\aentries\x18\x03 \x01(\x05R\aentries\"\xf2\x01\n" +
+	"\n" +
+	"CacheStats\x12>\n" +
+	"\ttemplates\x18\x01 \x01(\v2 .agentos.executor.v1.CacheCountsR\ttemplates\x12:\n" +
+	"\aregexps\x18\x02 \x01(\v2 .agentos.executor.v1.CacheCountsR\aregexps\x124\n" +
+	"\x04a11y\x18\x03 \x01(\v2 .agentos.executor.v1.CacheCountsR\x04a11y\x122\n" +
+	"\x03ocr\x18\x04 \x01(\v2 .agentos.executor.v1.CacheCountsR\x03ocr2\xb5\x01\n" +
+	"\bExecutor\x12T\n" +
+	"\aExecute\x12#.agentos.executor.v1.ExecuteRequest\x1a$.agentos.executor.v1.ExecutionResult\x12S\n" +
+	"\aSession\x12#.agentos.executor.v1.CommandRequest\x1a\x1f.agentos.executor.v1.StepResult(\x010\x01BLZJgithub.com/aarohkandy/AgentOS/core/automation/sdk/go/executorpb;executorpbb\x06proto3"
+
+var (
+	file_executor_proto_rawDescOnce sync.Once
+	file_executor_proto_rawDescData []byte
+)
+
+func file_executor_proto_rawDescGZIP() []byte {
+	file_executor_proto_rawDescOnce.Do(func() {
+		file_executor_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_executor_proto_rawDesc), len(file_executor_proto_rawDesc)))
+	})
+	return file_executor_proto_rawDescData
+}
+
+var file_executor_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_executor_proto_goTypes = []any{
+	(*ExecuteRequest)(nil),  // 0: agentos.executor.v1.ExecuteRequest
+	(*CommandRequest)(nil),  // 1: agentos.executor.v1.CommandRequest
+	(*Screenshot)(nil),      // 2: agentos.executor.v1.Screenshot
+	(*Event)(nil),           // 3: agentos.executor.v1.Event
+	(*StepOutput)(nil),      // 4: agentos.executor.v1.StepOutput
+	(*StepResult)(nil),      // 5: agentos.executor.v1.StepResult
+	(*ExecutionResult)(nil), // 6: agentos.executor.v1.ExecutionResult
+	(*CacheCounts)(nil),     // 7: agentos.executor.v1.CacheCounts
+	(*CacheStats)(nil),      // 8: agentos.executor.v1.CacheStats
+}
+var file_executor_proto_depIdxs = []int32{
+	2,  // 0: agentos.executor.v1.StepResult.screenshot:type_name -> agentos.executor.v1.Screenshot
+	3,  // 1: agentos.executor.v1.StepResult.events:type_name -> agentos.executor.v1.Event
+	2,  // 2: agentos.executor.v1.ExecutionResult.screenshots:type_name -> agentos.executor.v1.Screenshot
+	3,  // 3: agentos.executor.v1.ExecutionResult.events:type_name -> agentos.executor.v1.Event
+	4,  // 4: agentos.executor.v1.ExecutionResult.outputs:type_name -> agentos.executor.v1.StepOutput
+	8,  // 5: agentos.executor.v1.ExecutionResult.cache:type_name -> agentos.executor.v1.CacheStats
+	7,  // 6: agentos.executor.v1.CacheStats.templates:type_name -> agentos.executor.v1.CacheCounts
+	7,  // 7: agentos.executor.v1.CacheStats.regexps:type_name -> agentos.executor.v1.CacheCounts
+	7,  // 8: agentos.executor.v1.CacheStats.a11y:type_name -> agentos.executor.v1.CacheCounts
+	7,  // 9: agentos.executor.v1.CacheStats.ocr:type_name -> agentos.executor.v1.CacheCounts
+	0,  // 10: agentos.executor.v1.Executor.Execute:input_type -> agentos.executor.v1.ExecuteRequest
+	1,  // 11: agentos.executor.v1.Executor.Session:input_type -> agentos.executor.v1.CommandRequest
+	6,  // 12: agentos.executor.v1.Executor.Execute:output_type -> agentos.executor.v1.ExecutionResult
+	5,  // 13: agentos.executor.v1.Executor.Session:output_type -> agentos.executor.v1.StepResult
+	12, // [12:14] is the sub-list for method output_type
+	10, // [10:12] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
+}
+
+func init() { file_executor_proto_init() }
+func file_executor_proto_init() {
+	if File_executor_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_executor_proto_rawDesc), len(file_executor_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_executor_proto_goTypes,
+		DependencyIndexes: file_executor_proto_depIdxs,
+		MessageInfos:      file_executor_proto_msgTypes,
+	}.Build()
+	File_executor_proto = out.File
+	file_executor_proto_goTypes = nil
+	file_executor_proto_depIdxs = nil
+}
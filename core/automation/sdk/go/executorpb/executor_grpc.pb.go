@@ -0,0 +1,172 @@
+// gRPC API of the automation executor, served by `executor_binary serve
+// --grpc ADDR` with server reflection. Clients in sdk/ are generated from
+// this file with generate.sh; keep field numbers stable, and keep the
+// tables in protobuf.go, which the server encodes and reflects from, in
+// step with this file.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: executor.proto
+
+package executorpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Executor_Execute_FullMethodName = "/agentos.executor.v1.Executor/Execute"
+	Executor_Session_FullMethodName = "/agentos.executor.v1.Executor/Session"
+)
+
+// ExecutorClient is the client API for Executor service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ExecutorClient interface {
+	// Execute runs a whole script and returns the combined result. The run's
+	// request ID is taken from x-request-id metadata or generated, returned
+	// in the x-request-id header, and usable with serve mode's /status/ID.
+	Execute(ctx context.Context, in *ExecuteRequest, opts ...grpc.CallOption) (*ExecutionResult, error)
+	// Session runs script lines as they arrive, answering each step as soon
+	// as it finishes. It ends when the client closes its side or the run
+	// aborts; a second concurrent session fails with UNAVAILABLE.
+	Session(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[CommandRequest, StepResult], error)
+}
+
+type executorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExecutorClient(cc grpc.ClientConnInterface) ExecutorClient {
+	return &executorClient{cc}
+}
+
+func (c *executorClient) Execute(ctx context.Context, in *ExecuteRequest, opts ...grpc.CallOption) (*ExecutionResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExecutionResult)
+	err := c.cc.Invoke(ctx, Executor_Execute_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *executorClient) Session(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[CommandRequest, StepResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Executor_ServiceDesc.Streams[0], Executor_Session_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CommandRequest, StepResult]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Executor_SessionClient = grpc.BidiStreamingClient[CommandRequest, StepResult]
+
+// ExecutorServer is the server API for Executor service.
+// All implementations must embed UnimplementedExecutorServer
+// for forward compatibility.
+type ExecutorServer interface {
+	// Execute runs a whole script and returns the combined result. The run's
+	// request ID is taken from x-request-id metadata or generated, returned
+	// in the x-request-id header, and usable with serve mode's /status/ID.
+	Execute(context.Context, *ExecuteRequest) (*ExecutionResult, error)
+	// Session runs script lines as they arrive, answering each step as soon
+	// as it finishes. It ends when the client closes its side or the run
+	// aborts; a second concurrent session fails with UNAVAILABLE.
+	Session(grpc.BidiStreamingServer[CommandRequest, StepResult]) error
+	mustEmbedUnimplementedExecutorServer()
+}
+
+// UnimplementedExecutorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedExecutorServer struct{}
+
+func (UnimplementedExecutorServer) Execute(context.Context, *ExecuteRequest) (*ExecutionResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Execute not implemented")
+}
+func (UnimplementedExecutorServer) Session(grpc.BidiStreamingServer[CommandRequest, StepResult]) error {
+	return status.Errorf(codes.Unimplemented, "method Session not implemented")
+}
+func (UnimplementedExecutorServer) mustEmbedUnimplementedExecutorServer() {}
+func (UnimplementedExecutorServer) testEmbeddedByValue()                  {}
+
+// UnsafeExecutorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExecutorServer will
+// result in compilation errors.
+type UnsafeExecutorServer interface {
+	mustEmbedUnimplementedExecutorServer()
+}
+
+func RegisterExecutorServer(s grpc.ServiceRegistrar, srv ExecutorServer) {
+	// If the following call pancis, it indicates UnimplementedExecutorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Executor_ServiceDesc, srv)
+}
+
+func _Executor_Execute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExecuteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExecutorServer).Execute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Executor_Execute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExecutorServer).Execute(ctx, req.(*ExecuteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Executor_Session_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ExecutorServer).Session(&grpc.GenericServerStream[CommandRequest, StepResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Executor_SessionServer = grpc.BidiStreamingServer[CommandRequest, StepResult]
+
+// Executor_ServiceDesc is the grpc.ServiceDesc for Executor service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Executor_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "agentos.executor.v1.Executor",
+	HandlerType: (*ExecutorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Execute",
+			Handler:    _Executor_Execute_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Session",
+			Handler:       _Executor_Session_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "executor.proto",
+}
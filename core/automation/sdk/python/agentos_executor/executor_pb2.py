@@ -0,0 +1,55 @@
+# -*- coding: utf-8 -*-
+# Generated by the protocol buffer compiler.  DO NOT EDIT!
+# NO CHECKED-IN PROTOBUF GENCODE
+# source: executor.proto
+# Protobuf Python Version: 5.29.0
+"""Generated protocol buffer code."""
+from google.protobuf import descriptor as _descriptor
+from google.protobuf import descriptor_pool as _descriptor_pool
+from google.protobuf import runtime_version as _runtime_version
+from google.protobuf import symbol_database as _symbol_database
+from google.protobuf.internal import builder as _builder
+_runtime_version.ValidateProtobufRuntimeVersion(
+    _runtime_version.Domain.PUBLIC,
+    5,
+    29,
+    0,
+    '',
+    'executor.proto'
+)
+# @@protoc_insertion_point(imports)
+
+_sym_db = _symbol_database.Default()
+
+
+
+
+DESCRIPTOR = _descriptor_pool.Default().AddSerializedFile(b'\n\016executor.proto\022\023agentos.executor.v1\"0\n\016ExecuteRequest\022\016\n\006script\030\001 \001(\t\022\016\n\006format\030\002 \001(\t\"\036\n\016CommandRequest\022\014\n\004line\030\001 \001(\t\"e\n\nScreenshot\022\014\n\004step\030\001 \001(\005\022\014\n\004file\030\002 \001(\t\022\016\n\006action\030\003 \001(\t\022\013\n\003url\030\004 \001(\t\022\020\n\010uploaded\030\005 \001(\t\022\014\n\004name\030\006 \001(\t\"4\n\005Event\022\014\n\004step\030\001 \001(\005\022\014\n\004type\030\002 \001(\t\022\017\n\007message\030\003 \001(\t\"K\n\nStepOutput\022\014\n\004step\030\001 \001(\005\022\016\n\006action\030\002 \001(\t\022\021\n\tdata_json\030\003 \001(\t\022\014\n\004name\030\004 \001(\t\"\334\001\n\nStepResult\022\014\n\004step\030\001 \001(\005\022\016\n\006action\030\002 \001(\t\022\016\n\006status\030\003 \001(\t\022\r\n\005error\030\004 \001(\t\0223\n\nscreenshot\030\005 \001(\0132\037.agentos.executor.v1.Screenshot\022\023\n\013output_json\030\006 \001(\t\022*\n\006events\030\007 \003(\0132\032.agentos.executor.v1.Event\022\014\n\004name\030\010 \001(\t\022\r\n\005audio\030\t \001(\010\"\277\002\n\017ExecutionResult\022\016\n\006status\030\001 \001(\t\022\031\n\021commands_executed\030\002 \001(\005\0224\n\013screenshots\030\003 \003(\0132\037.agentos.executor.v1.Screenshot\022\016\n\006errors\030\004 \003(\t\022*\n\006events\030\005 \003(\0132\032.agentos.executor.v1.Event\0220\n\007outputs\030\006 \003(\0132\037.agentos.executor.v1.StepOutput\022\021\n\tfilmstrip\030\007 \001(\t\022.\n\005cache\030\010 \001(\0132\037.agentos.executor.v1.CacheStats\022\032\n\022filmstrip_uploaded\030\t \001(\t\"<\n\013CacheCounts\022\014\n\004hits\030\001 \001(\005\022\016\n\006misses\030\002 \001(\005\022\017\n\007entries\030\003 \001(\005\"\323\001\n\nCacheStats\0223\n\ttemplates\030\001 \001(\0132 .agentos.executor.v1.CacheCounts\0221\n\007regexps\030\002 \001(\0132 .agentos.executor.v1.CacheCounts\022.\n\004a11y\030\003 \001(\0132 .agentos.executor.v1.CacheCounts\022-\n\003ocr\030\004 \001(\0132 .agentos.executor.v1.CacheCounts2\265\001\n\010Executor\022T\n\007Execute\022#.agentos.executor.v1.ExecuteRequest\032$.agentos.executor.v1.ExecutionResult\022S\n\007Session\022#.agentos.executor.v1.CommandRequest\032\037.agentos.executor.v1.StepResult(\0010\001BLZJgithub.com/aarohkandy/AgentOS/core/automation/sdk/go/executorpb;executorpbb\006proto3')
+
+_globals = globals()
+_builder.BuildMessageAndEnumDescriptors(DESCRIPTOR, _globals)
+_builder.BuildTopDescriptorsAndMessages(DESCRIPTOR, 'executor_pb2', _globals)
+if not _descriptor._USE_C_DESCRIPTORS:
+  _globals['DESCRIPTOR']._loaded_options = None
+  _globals['DESCRIPTOR']._serialized_options = b'ZJgithub.com/aarohkandy/AgentOS/core/automation/sdk/go/executorpb;executorpb'
+  _globals['_EXECUTEREQUEST']._serialized_start=39
+  _globals['_EXECUTEREQUEST']._serialized_end=87
+  _globals['_COMMANDREQUEST']._serialized_start=89
+  _globals['_COMMANDREQUEST']._serialized_end=119
+  _globals['_SCREENSHOT']._serialized_start=121
+  _globals['_SCREENSHOT']._serialized_end=222
+  _globals['_EVENT']._serialized_start=224
+  _globals['_EVENT']._serialized_end=276
+  _globals['_STEPOUTPUT']._serialized_start=278
+  _globals['_STEPOUTPUT']._serialized_end=353
+  _globals['_STEPRESULT']._serialized_start=356
+  _globals['_STEPRESULT']._serialized_end=576
+  _globals['_EXECUTIONRESULT']._serialized_start=579
+  _globals['_EXECUTIONRESULT']._serialized_end=898
+  _globals['_CACHECOUNTS']._serialized_start=900
+  _globals['_CACHECOUNTS']._serialized_end=960
+  _globals['_CACHESTATS']._serialized_start=963
+  _globals['_CACHESTATS']._serialized_end=1174
+  _globals['_EXECUTOR']._serialized_start=1177
+  _globals['_EXECUTOR']._serialized_end=1358
+# @@protoc_insertion_point(module_scope)
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// SelftestCheck is the outcome of one capability check
+type SelftestCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // pass, fail or skipped
+	Detail string `json:"detail,omitempty"`
+}
+
+// SelftestResult is printed by `selftest`
+type SelftestResult struct {
+	Status string          `json:"status"`
+	Checks []SelftestCheck `json:"checks"`
+}
+
+func (r *SelftestResult) add(name, status, detail string) {
+	r.Checks = append(r.Checks, SelftestCheck{Name: name, Status: status, Detail: detail})
+	if status == "fail" {
+		r.Status = "fail"
+	}
+}
+
+// Layout of the calibration window, relative to its top-left corner
+const (
+	selftestX, selftestY = 40, 40
+	selftestW, selftestH = 480, 240
+	selftestText         = "AGENTOS SELFTEST 4217"
+	selftestTyped        = "Hello, AgentOS 42!"
+)
+
+// selftestTargets are the click targets drawn in the calibration window
+var selftestTargets = []struct {
+	x, y, size int
+	pixel      uint32 // 24-bit TrueColor value
+}{
+	{20, 20, 40, 0xd03030},
+	{220, 100, 40, 0x30a030},
+	{420, 180, 40, 0x3050d0},
+}
+
+// selftestRequiredTools are the external programs each backend relies
+// on; the windows backend calls the system directly
+var selftestRequiredTools = map[string][]string{
+	"xdotool": {"xdotool", "import", "xrandr"},
+	"x11":     {"xdotool", "import", "xrandr"},
+	"wayland": {"ydotool", "grim"},
+	"macos":   {"cliclick", "screencapture", "osascript"},
+}
+
+func runSelftest(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	gui := fs.Bool("gui", false, "open a calibration window and verify input and capture end to end")
+	fs.Parse(args)
+
+	result := &SelftestResult{Status: "pass"}
+	for _, tool := range selftestRequiredTools[backendName] {
+		if path, err := exec.LookPath(tool); err != nil {
+			result.add("tool:"+tool, "fail", "not found in PATH")
+		} else {
+			result.add("tool:"+tool, "pass", path)
+		}
+	}
+	if *gui {
+		selftestGUI(result)
+	}
+
+	jsonOutput, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(jsonOutput))
+	if result.Status != "pass" {
+		os.Exit(1)
+	}
+}
+
+// calibrationWindow is the bundled test window used by selftest and
+// calibrate, drawn with known targets and text
+type calibrationWindow struct {
+	x       *xConn
+	wid, gc uint32
+	fontErr error
+}
+
+func openCalibrationWindow(x *xConn) (*calibrationWindow, error) {
+	if x.screen.RootDepth < 24 {
+		return nil, fmt.Errorf("calibration needs a TrueColor display of depth 24 or more")
+	}
+	wid, err := x.createWindow(selftestX, selftestY, selftestW, selftestH, 0xffffff,
+		xKeyPressMask|xButtonPressMask|xExposureMask)
+	if err != nil {
+		return nil, err
+	}
+	w := &calibrationWindow{x: x, wid: wid}
+	var font uint32
+	font, w.fontErr = x.openFont("-misc-fixed-bold-r-normal--18-*-*-*-*-*-iso8859-1", "10x20", "fixed")
+	if w.gc, err = x.createGC(wid, 0x000000, font); err != nil {
+		x.destroyWindow(wid)
+		return nil, err
+	}
+	if err := x.mapWindow(wid); err != nil {
+		x.destroyWindow(wid)
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *calibrationWindow) draw() {
+	for _, t := range selftestTargets {
+		w.x.setForeground(w.gc, t.pixel)
+		w.x.fillRect(w.wid, w.gc, t.x, t.y, t.size, t.size)
+	}
+	if w.fontErr == nil {
+		w.x.setForeground(w.gc, 0x000000)
+		w.x.drawText(w.wid, w.gc, 120, 60, selftestText)
+	}
+}
+
+func (w *calibrationWindow) close() {
+	w.x.destroyWindow(w.wid)
+	w.x.flush()
+}
+
+func selftestGUI(result *SelftestResult) {
+	x, err := openX("")
+	if err != nil {
+		result.add("display", "fail", err.Error())
+		return
+	}
+	defer x.Close()
+	result.add("display", "pass", fmt.Sprintf("%dx%d, depth %d", x.screen.Width, x.screen.Height, x.screen.RootDepth))
+
+	w, err := openCalibrationWindow(x)
+	if err != nil {
+		result.add("window", "fail", err.Error())
+		return
+	}
+	defer w.close()
+	w.draw()
+	x.setInputFocus(w.wid)
+	x.flush()
+	// Give the compositor a moment to put the window on screen
+	time.Sleep(300 * time.Millisecond)
+	drainEvents(x, w.draw)
+	result.add("window", "pass", fmt.Sprintf("%dx%d at %d,%d", selftestW, selftestH, selftestX, selftestY))
+
+	selftestScreenshot(result)
+	selftestClicks(x, w.draw, result)
+	selftestTyping(x, w.draw, result)
+	selftestOCR(result, w.fontErr)
+}
+
+// drainEvents discards queued events, redrawing on Expose
+func drainEvents(x *xConn, redraw func()) {
+	for {
+		select {
+		case ev := <-x.Events:
+			if ev.code() == xExpose {
+				redraw()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// waitEvent returns the next event with the given code, redrawing on Expose
+func waitEvent(x *xConn, code byte, timeout time.Duration, redraw func()) (xEvent, bool) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev := <-x.Events:
+			if ev.code() == code {
+				return ev, true
+			}
+			if ev.code() == xExpose {
+				redraw()
+			}
+		case <-deadline:
+			return nil, false
+		}
+	}
+}
+
+func selftestScreenshot(result *SelftestResult) {
+	screen, err := captureScreen()
+	if err != nil {
+		result.add("screenshot", "fail", err.Error())
+		return
+	}
+	for _, t := range selftestTargets {
+		px := selftestX + t.x + t.size/2
+		py := selftestY + t.y + t.size/2
+		r, g, b, _ := screen.At(px, py).RGBA()
+		got := uint32(r>>8)<<16 | uint32(g>>8)<<8 | uint32(b>>8)
+		if !closeColor(got, t.pixel, 8) {
+			result.add("screenshot", "fail", fmt.Sprintf("pixel at %d,%d is #%06x, expected #%06x", px, py, got, t.pixel))
+			return
+		}
+	}
+	result.add("screenshot", "pass", fmt.Sprintf("%dx%d capture matches the calibration window", screen.Bounds().Dx(), screen.Bounds().Dy()))
+}
+
+func closeColor(a, b uint32, tolerance int) bool {
+	for shift := 0; shift <= 16; shift += 8 {
+		d := int(a>>shift&0xff) - int(b>>shift&0xff)
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func selftestClicks(x *xConn, redraw func(), result *SelftestResult) {
+	drainEvents(x, redraw)
+	for _, t := range selftestTargets {
+		px := selftestX + t.x + t.size/2
+		py := selftestY + t.y + t.size/2
+		cmd := parseCommand(fmt.Sprintf("pointer %d %d", px, py))
+		if err := executeCommand(cmd); err != nil {
+			result.add("click", "fail", err.Error())
+			return
+		}
+		if err := executeCommand(parseCommand("click 1 s")); err != nil {
+			result.add("click", "fail", err.Error())
+			return
+		}
+		ev, ok := waitEvent(x, xButtonPress, 2*time.Second, redraw)
+		if !ok {
+			result.add("click", "fail", fmt.Sprintf("no button press received for target at %d,%d", px, py))
+			return
+		}
+		if ev.rootX() != px || ev.rootY() != py {
+			result.add("click", "fail", fmt.Sprintf("click aimed at %d,%d landed at %d,%d", px, py, ev.rootX(), ev.rootY()))
+			return
+		}
+	}
+	result.add("click", "pass", fmt.Sprintf("%d targets hit exactly", len(selftestTargets)))
+}
+
+func selftestTyping(x *xConn, redraw func(), result *SelftestResult) {
+	keysyms, perCode, err := x.keyboardMapping()
+	if err != nil {
+		result.add("typing", "fail", err.Error())
+		return
+	}
+	drainEvents(x, redraw)
+	if err := executeCommand(parseCommand(`type "` + selftestTyped + `"`)); err != nil {
+		result.add("typing", "fail", err.Error())
+		return
+	}
+
+	var typed strings.Builder
+	for done := false; !done; {
+		var ev xEvent
+		select {
+		case ev = <-x.Events:
+		case <-time.After(time.Second):
+			done = true
+			continue
+		}
+		switch ev.code() {
+		case xExpose:
+			redraw()
+			continue
+		case xMappingNotify:
+			// xdotool may remap spare keycodes for missing symbols
+			keysyms, perCode, _ = x.keyboardMapping()
+			continue
+		case xKeyPress:
+		default:
+			continue
+		}
+		base := (int(ev.detail()) - int(x.minKeycode)) * perCode
+		if base < 0 || base+1 >= len(keysyms) {
+			continue
+		}
+		sym := keysyms[base]
+		if ev.state()&1 != 0 && keysyms[base+1] != 0 { // Shift
+			sym = keysyms[base+1]
+		}
+		if sym >= 0x20 && sym <= 0x7e {
+			typed.WriteByte(byte(sym))
+		}
+	}
+	if typed.String() != selftestTyped {
+		result.add("typing", "fail", fmt.Sprintf("typed %q, window received %q", selftestTyped, typed.String()))
+		return
+	}
+	result.add("typing", "pass", fmt.Sprintf("%d characters received intact", len(selftestTyped)))
+}
+
+func selftestOCR(result *SelftestResult, fontErr error) {
+	provider, _, err := currentOCR()
+	if err != nil {
+		result.add("ocr", "fail", err.Error())
+		return
+	}
+	if _, ok := provider.(*tesseractOCR); ok {
+		if _, err := exec.LookPath("tesseract"); err != nil {
+			result.add("ocr", "skipped", "tesseract not installed")
+			return
+		}
+	}
+	if fontErr != nil {
+		result.add("ocr", "skipped", fontErr.Error())
+		return
+	}
+	screen, err := captureScreen()
+	if err != nil {
+		result.add("ocr", "fail", err.Error())
+		return
+	}
+	crop := upscale(screen, image.Rect(selftestX, selftestY, selftestX+selftestW, selftestY+selftestH), 2)
+	words, err := provider.Recognize(crop)
+	if err != nil {
+		result.add("ocr", "fail", err.Error())
+		return
+	}
+	if _, ok := findText(words, selftestText); !ok {
+		var seen []string
+		for _, w := range words {
+			seen = append(seen, w.Text)
+		}
+		result.add("ocr", "fail", fmt.Sprintf("%s expected %q, recognized %q", provider.Name(), selftestText, strings.Join(seen, " ")))
+		return
+	}
+	result.add("ocr", "pass", provider.Name()+" recognized the calibration text")
+}
+
+// upscale crops r out of img and enlarges it by an integer factor, which
+// helps tesseract with small bitmap fonts
+func upscale(img image.Image, r image.Rectangle, factor int) image.Image {
+	r = r.Intersect(img.Bounds())
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx()*factor, r.Dy()*factor))
+	for y := 0; y < out.Bounds().Dy(); y++ {
+		for x := 0; x < out.Bounds().Dx(); x++ {
+			out.Set(x, y, img.At(r.Min.X+x/factor, r.Min.Y+y/factor))
+		}
+	}
+	return out
+}
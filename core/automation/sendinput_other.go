@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+func newWindowsBackend() (Backend, error) {
+	return nil, fmt.Errorf("the windows backend is only available on Windows")
+}
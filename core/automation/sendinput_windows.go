@@ -0,0 +1,551 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// windowsBackend drives a Windows desktop through user32 and gdi32, with
+// no programs to install: input is SendInput, which the system treats
+// as coming from real devices, and screenshots are a GDI copy of the
+// whole virtual screen. Positions are pixels from the top-left corner of
+// the virtual screen, as in its screenshots; the process is made DPI
+// aware so a scaled display does not shift them. Text is typed as Unicode
+// characters, independent of the keyboard layout. It is the default
+// backend on Windows.
+type windowsBackend struct {
+	mu sync.Mutex
+}
+
+var (
+	user32 = syscall.NewLazyDLL("user32.dll")
+	gdi32  = syscall.NewLazyDLL("gdi32.dll")
+
+	procSendInput              = user32.NewProc("SendInput")
+	procGetSystemMetrics       = user32.NewProc("GetSystemMetrics")
+	procGetCursorPos           = user32.NewProc("GetCursorPos")
+	procGetAsyncKeyState       = user32.NewProc("GetAsyncKeyState")
+	procGetKeyState            = user32.NewProc("GetKeyState")
+	procVkKeyScanW             = user32.NewProc("VkKeyScanW")
+	procSetProcessDPIAware     = user32.NewProc("SetProcessDPIAware")
+	procGetDC                  = user32.NewProc("GetDC")
+	procReleaseDC              = user32.NewProc("ReleaseDC")
+	procCreateCompatibleDC     = gdi32.NewProc("CreateCompatibleDC")
+	procCreateCompatibleBitmap = gdi32.NewProc("CreateCompatibleBitmap")
+	procSelectObject           = gdi32.NewProc("SelectObject")
+	procBitBlt                 = gdi32.NewProc("BitBlt")
+	procGetDIBits              = gdi32.NewProc("GetDIBits")
+	procDeleteObject           = gdi32.NewProc("DeleteObject")
+	procDeleteDC               = gdi32.NewProc("DeleteDC")
+)
+
+const (
+	inputMouse    = 0
+	inputKeyboard = 1
+
+	mouseMove       = 0x0001
+	mouseLeftDown   = 0x0002
+	mouseLeftUp     = 0x0004
+	mouseRightDown  = 0x0008
+	mouseRightUp    = 0x0010
+	mouseMiddleDown = 0x0020
+	mouseMiddleUp   = 0x0040
+	mouseXDown      = 0x0080
+	mouseXUp        = 0x0100
+	mouseWheel      = 0x0800
+	mouseHWheel     = 0x1000
+	mouseVirtual    = 0x4000
+	mouseAbsolute   = 0x8000
+	wheelDelta      = 120
+
+	keyExtended = 0x0001
+	keyUp       = 0x0002
+	keyUnicode  = 0x0004
+
+	smXVirtualScreen  = 76
+	smYVirtualScreen  = 77
+	smCXVirtualScreen = 78
+	smCYVirtualScreen = 79
+
+	srcCopy    = 0x00CC0020
+	captureBlt = 0x40000000
+)
+
+// windowsKeyDelay is the pause between typed characters and key
+// combinations
+const windowsKeyDelay = 20 * time.Millisecond
+
+// mouseInput and keybdInput are the MOUSEINPUT and KEYBDINPUT members of
+// the INPUT union, which is as large as mouseInput
+type mouseInput struct {
+	dx, dy    int32
+	mouseData uint32
+	flags     uint32
+	time      uint32
+	extraInfo uintptr
+}
+
+type keybdInput struct {
+	vk, scan  uint16
+	flags     uint32
+	time      uint32
+	extraInfo uintptr
+}
+
+type winInput struct {
+	kind uint32
+	mi   mouseInput
+}
+
+// windowsInputTag is the dwExtraInfo of every event sent, "AGOS", which
+// low-level hooks and GetMessageExtraInfo see, telling the executor's
+// input from other injected input
+const windowsInputTag = 0x41474f53
+
+func mouseEvent(flags, data uint32) winInput {
+	return winInput{kind: inputMouse, mi: mouseInput{mouseData: data, flags: flags, extraInfo: windowsInputTag}}
+}
+
+func keyEvent(vk, scan uint16, flags uint32) winInput {
+	in := winInput{kind: inputKeyboard}
+	*(*keybdInput)(unsafe.Pointer(&in.mi)) = keybdInput{vk: vk, scan: scan, flags: flags, extraInfo: windowsInputTag}
+	return in
+}
+
+func sendInput(inputs ...winInput) error {
+	n, _, err := procSendInput.Call(uintptr(len(inputs)), uintptr(unsafe.Pointer(&inputs[0])), unsafe.Sizeof(inputs[0]))
+	if int(n) != len(inputs) {
+		// A blocked injection, as into an elevated window, sends nothing
+		return fmt.Errorf("SendInput: %v", err)
+	}
+	return nil
+}
+
+func newWindowsBackend() (Backend, error) {
+	if err := procSendInput.Find(); err != nil {
+		return nil, err
+	}
+	procSetProcessDPIAware.Call()
+	return &windowsBackend{}, nil
+}
+
+func (*windowsBackend) Name() string { return "windows" }
+
+// inputProvenance names the tag; Windows also flags the events injected
+func (*windowsBackend) inputProvenance() map[string]interface{} {
+	return map[string]interface{}{"mechanism": "SendInput", "extra_info": fmt.Sprintf("%#x", windowsInputTag)}
+}
+
+// virtualScreen is the bounds of all monitors, in physical pixels
+func virtualScreen() image.Rectangle {
+	metric := func(i uintptr) int {
+		v, _, _ := procGetSystemMetrics.Call(i)
+		return int(int32(v))
+	}
+	x, y := metric(smXVirtualScreen), metric(smYVirtualScreen)
+	return image.Rect(x, y, x+metric(smCXVirtualScreen), y+metric(smCYVirtualScreen))
+}
+
+// MoveMouse places the pointer with an absolute move scaled to the
+// virtual screen's 0 to 65535
+func (b *windowsBackend) MoveMouse(x, y int) error {
+	vs := virtualScreen()
+	w, h := vs.Dx(), vs.Dy()
+	if w < 2 || h < 2 {
+		return fmt.Errorf("unknown screen size")
+	}
+	in := mouseEvent(mouseMove|mouseAbsolute|mouseVirtual, 0)
+	in.mi.dx = int32(clampInt(x, 0, w-1) * 65535 / (w - 1))
+	in.mi.dy = int32(clampInt(y, 0, h-1) * 65535 / (h - 1))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sendInput(in)
+}
+
+// moveMouseRelative sends relative motion, which Windows scales by the
+// pointer speed setting
+func (b *windowsBackend) moveMouseRelative(dx, dy int) error {
+	in := mouseEvent(mouseMove, 0)
+	in.mi.dx, in.mi.dy = int32(dx), int32(dy)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sendInput(in)
+}
+
+// windowsButton is the down and up flags and data of an X button number
+func windowsButton(button int) (down, up, data uint32, err error) {
+	switch button {
+	case 1:
+		return mouseLeftDown, mouseLeftUp, 0, nil
+	case 2:
+		return mouseMiddleDown, mouseMiddleUp, 0, nil
+	case 3:
+		return mouseRightDown, mouseRightUp, 0, nil
+	case 8:
+		return mouseXDown, mouseXUp, 1, nil
+	case 9:
+		return mouseXDown, mouseXUp, 2, nil
+	}
+	return 0, 0, 0, fmt.Errorf("button %d cannot be sent with SendInput", button)
+}
+
+func (b *windowsBackend) MouseDown(button int) error {
+	down, _, data, err := windowsButton(button)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sendInput(mouseEvent(down, data))
+}
+
+func (b *windowsBackend) MouseUp(button int) error {
+	_, up, data, err := windowsButton(button)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sendInput(mouseEvent(up, data))
+}
+
+// Click turns buttons 4 to 7 into wheel notches
+func (b *windowsBackend) Click(button, count int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var wheel winInput
+	switch button {
+	case 4:
+		wheel = mouseEvent(mouseWheel, wheelDelta)
+	case 5:
+		wheel = mouseEvent(mouseWheel, uint32(-wheelDelta&0xffffffff))
+	case 6:
+		wheel = mouseEvent(mouseHWheel, uint32(-wheelDelta&0xffffffff))
+	case 7:
+		wheel = mouseEvent(mouseHWheel, wheelDelta)
+	}
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(windowsKeyDelay)
+		}
+		var err error
+		if wheel.mi.flags != 0 {
+			err = sendInput(wheel)
+		} else {
+			down, up, data, berr := windowsButton(button)
+			if berr != nil {
+				return berr
+			}
+			err = sendInput(mouseEvent(down, data), mouseEvent(up, data))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TypeText sends each UTF-16 unit as a Unicode key; line breaks and tabs
+// are pressed as their keys, which applications handle better
+func (b *windowsBackend) TypeText(text string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, r := range []rune(text) {
+		if i > 0 {
+			time.Sleep(windowsKeyDelay)
+		}
+		var inputs []winInput
+		switch r {
+		case '\n':
+			inputs = []winInput{keyEvent(vkReturn, 0, 0), keyEvent(vkReturn, 0, keyUp)}
+		case '\t':
+			inputs = []winInput{keyEvent(vkTab, 0, 0), keyEvent(vkTab, 0, keyUp)}
+		default:
+			for _, u := range utf16Units(r) {
+				inputs = append(inputs, keyEvent(0, u, keyUnicode), keyEvent(0, u, keyUnicode|keyUp))
+			}
+		}
+		if err := sendInput(inputs...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func utf16Units(r rune) []uint16 {
+	if r < 0x10000 {
+		return []uint16{uint16(r)}
+	}
+	r -= 0x10000
+	return []uint16{uint16(0xd800 + r>>10), uint16(0xdc00 + r&0x3ff)}
+}
+
+const (
+	vkShift   = 0x10
+	vkControl = 0x11
+	vkMenu    = 0x12
+	vkReturn  = 0x0d
+	vkTab     = 0x09
+)
+
+// windowsKeys are virtual-key codes for xdotool key names, by lower case
+var windowsKeys = map[string]uint16{
+	"return": vkReturn, "kp_enter": vkReturn, "escape": 0x1b, "backspace": 0x08, "tab": vkTab, "space": 0x20,
+	"delete": 0x2e, "insert": 0x2d, "home": 0x24, "end": 0x23,
+	"prior": 0x21, "page_up": 0x21, "next": 0x22, "page_down": 0x22,
+	"left": 0x25, "up": 0x26, "right": 0x27, "down": 0x28,
+	"ctrl": vkControl, "control_l": 0xa2, "control_r": 0xa3,
+	"alt": vkMenu, "alt_l": 0xa4, "alt_r": 0xa5, "iso_level3_shift": 0xa5,
+	"shift": vkShift, "shift_l": 0xa0, "shift_r": 0xa1,
+	"super": 0x5b, "super_l": 0x5b, "super_r": 0x5c, "meta": 0x5b, "meta_l": 0x5b, "meta_r": 0x5c,
+	"menu": 0x5d, "print": 0x2c, "sys_req": 0x2c, "pause": 0x13, "break": 0x13,
+	"caps_lock": 0x14, "num_lock": 0x90, "scroll_lock": 0x91,
+}
+
+// windowsExtended are the keys sent with the extended flag, which tells
+// them apart from their numeric keypad twins
+var windowsExtended = map[uint16]bool{
+	0x2e: true, 0x2d: true, 0x24: true, 0x23: true, 0x21: true, 0x22: true,
+	0x25: true, 0x26: true, 0x27: true, 0x28: true,
+	0xa3: true, 0xa5: true, 0x5b: true, 0x5c: true, 0x5d: true, 0x90: true, 0x2c: true,
+}
+
+// windowsKey resolves a key name to the virtual keys pressed for it: the
+// key, after Shift, Ctrl or Alt for a character the layout types with them
+func windowsKey(name string) ([]uint16, bool) {
+	lower := strings.ToLower(name)
+	if vk, ok := windowsKeys[lower]; ok {
+		return []uint16{vk}, true
+	}
+	if n, err := strconv.Atoi(lower[1:]); err == nil && lower[0] == 'f' && n >= 1 && n <= 24 {
+		return []uint16{0x70 + uint16(n-1)}, true
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(lower, "kp_")); err == nil && strings.HasPrefix(lower, "kp_") && n >= 0 && n <= 9 {
+		return []uint16{0x60 + uint16(n)}, true
+	}
+	r := []rune(name)
+	if len(r) != 1 {
+		k, ok := xKeysymNames[name]
+		if !ok || k >= 0x80 {
+			return nil, false
+		}
+		r = []rune{rune(k)}
+	}
+	scan, _, _ := procVkKeyScanW.Call(uintptr(r[0]))
+	if int16(scan) == -1 {
+		return nil, false
+	}
+	var keys []uint16
+	for _, mod := range []struct {
+		bit uintptr
+		vk  uint16
+	}{{1, vkShift}, {2, vkControl}, {4, vkMenu}} {
+		if scan>>8&mod.bit != 0 {
+			keys = append(keys, mod.vk)
+		}
+	}
+	return append(keys, uint16(scan&0xff)), true
+}
+
+// Key takes xdotool key syntax: combinations like ctrl+shift+Escape,
+// separated by spaces
+func (b *windowsBackend) Key(keys string) error {
+	var combos [][]uint16
+	for _, combo := range strings.Fields(keys) {
+		var vks []uint16
+		for _, name := range strings.Split(combo, "+") {
+			k, ok := windowsKey(name)
+			if name == "" || !ok {
+				return fmt.Errorf("unknown key %q", name)
+			}
+			vks = append(vks, k...)
+		}
+		combos = append(combos, vks)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, vks := range combos {
+		if i > 0 {
+			time.Sleep(windowsKeyDelay)
+		}
+		var inputs []winInput
+		for _, vk := range vks {
+			inputs = append(inputs, keyEvent(vk, 0, windowsKeyFlags(vk)))
+		}
+		for j := len(vks) - 1; j >= 0; j-- {
+			inputs = append(inputs, keyEvent(vks[j], 0, windowsKeyFlags(vks[j])|keyUp))
+		}
+		if err := sendInput(inputs...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *windowsBackend) KeyDown(key string) error { return b.holdKey(key, false) }
+
+func (b *windowsBackend) KeyUp(key string) error { return b.holdKey(key, true) }
+
+// holdKey presses a key's virtual keys in order, or releases them in
+// reverse
+func (b *windowsBackend) holdKey(key string, up bool) error {
+	vks, ok := windowsKey(key)
+	if key == "" || !ok {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	var inputs []winInput
+	for i := range vks {
+		if up {
+			vk := vks[len(vks)-1-i]
+			inputs = append(inputs, keyEvent(vk, 0, windowsKeyFlags(vk)|keyUp))
+		} else {
+			inputs = append(inputs, keyEvent(vks[i], 0, windowsKeyFlags(vks[i])))
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sendInput(inputs...)
+}
+
+// windowsModifierKeys are the left and right virtual keys of each
+// modifier in modifierKeys
+var windowsModifierKeys = map[string][]uint16{
+	"shift": {0xa0, 0xa1},
+	"ctrl":  {0xa2, 0xa3},
+	"alt":   {0xa4, 0xa5},
+	"super": {0x5b, 0x5c},
+}
+
+// heldModifiers reads which modifiers are down on either side
+func (*windowsBackend) heldModifiers() ([]string, error) {
+	var held []string
+	for _, m := range modifierKeys {
+		for _, vk := range windowsModifierKeys[m.name] {
+			if state, _, _ := procGetAsyncKeyState.Call(uintptr(vk)); state&0x8000 != 0 {
+				held = append(held, m.name)
+				break
+			}
+		}
+	}
+	return held, nil
+}
+
+// releaseModifiers sends key releases for both sides of each modifier
+func (b *windowsBackend) releaseModifiers(names []string) error {
+	var inputs []winInput
+	for _, name := range names {
+		for _, vk := range windowsModifierKeys[name] {
+			inputs = append(inputs, keyEvent(vk, 0, windowsKeyFlags(vk)|keyUp))
+		}
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sendInput(inputs...)
+}
+
+// lockKeys reads the toggled bit of Caps Lock and Num Lock
+func (*windowsBackend) lockKeys() (lockState, error) {
+	toggled := func(vk uintptr) bool {
+		state, _, _ := procGetKeyState.Call(vk)
+		return state&1 != 0
+	}
+	return lockState{Caps: toggled(0x14), Num: toggled(0x90)}, nil
+}
+
+func windowsKeyFlags(vk uint16) uint32 {
+	if windowsExtended[vk] {
+		return keyExtended
+	}
+	return 0
+}
+
+// bitmapInfoHeader is a BITMAPINFOHEADER
+type bitmapInfoHeader struct {
+	size          uint32
+	width         int32
+	height        int32
+	planes        uint16
+	bitCount      uint16
+	compression   uint32
+	sizeImage     uint32
+	xPelsPerMeter int32
+	yPelsPerMeter int32
+	clrUsed       uint32
+	clrImportant  uint32
+}
+
+// Capture copies the virtual screen with BitBlt, layered windows included,
+// and reads it back as top-down 32-bit pixels
+func (b *windowsBackend) Capture() (image.Image, error) {
+	vs := virtualScreen()
+	w, h := vs.Dx(), vs.Dy()
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("screen capture failed: no screen")
+	}
+	screen, _, _ := procGetDC.Call(0)
+	if screen == 0 {
+		return nil, fmt.Errorf("screen capture failed: GetDC")
+	}
+	defer procReleaseDC.Call(0, screen)
+	mem, _, _ := procCreateCompatibleDC.Call(screen)
+	if mem == 0 {
+		return nil, fmt.Errorf("screen capture failed: CreateCompatibleDC")
+	}
+	defer procDeleteDC.Call(mem)
+	bitmap, _, _ := procCreateCompatibleBitmap.Call(screen, uintptr(w), uintptr(h))
+	if bitmap == 0 {
+		return nil, fmt.Errorf("screen capture failed: CreateCompatibleBitmap")
+	}
+	defer procDeleteObject.Call(bitmap)
+	old, _, _ := procSelectObject.Call(mem, bitmap)
+	ok, _, err := procBitBlt.Call(mem, 0, 0, uintptr(w), uintptr(h), screen, uintptr(vs.Min.X), uintptr(vs.Min.Y), srcCopy|captureBlt)
+	procSelectObject.Call(mem, old)
+	if ok == 0 {
+		return nil, fmt.Errorf("screen capture failed: BitBlt: %v", err)
+	}
+
+	// A negative height asks for the rows top down
+	header := bitmapInfoHeader{bitCount: 32, planes: 1, width: int32(w), height: -int32(h)}
+	header.size = uint32(unsafe.Sizeof(header))
+	img := newFrame(image.Rect(0, 0, w, h))
+	lines, _, _ := procGetDIBits.Call(mem, bitmap, 0, uintptr(h), uintptr(unsafe.Pointer(&img.Pix[0])), uintptr(unsafe.Pointer(&header)), 0)
+	if int(lines) != h {
+		releaseFrame(img)
+		return nil, fmt.Errorf("screen capture failed: GetDIBits")
+	}
+	// BGRA to RGBA, opaque
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+2], img.Pix[i+3] = img.Pix[i+2], img.Pix[i], 0xff
+	}
+	return img, nil
+}
+
+func (b *windowsBackend) captureFile(path string) error {
+	img, err := b.Capture()
+	if err != nil {
+		return err
+	}
+	defer releaseFrame(img)
+	return writePNG(path, img)
+}
+
+// cursorPosition is the pointer's position on the virtual screen
+func (*windowsBackend) cursorPosition() (int, int, error) {
+	var pt struct{ x, y int32 }
+	if ok, _, err := procGetCursorPos.Call(uintptr(unsafe.Pointer(&pt))); ok == 0 {
+		return 0, 0, fmt.Errorf("GetCursorPos: %v", err)
+	}
+	vs := virtualScreen()
+	return int(pt.x) - vs.Min.X, int(pt.y) - vs.Min.Y, nil
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// openSerial opens a serial device raw at baud, 8N1 without flow control.
+// It is opened without waiting for carrier and without becoming the
+// controlling terminal, then stty sets the line up through it.
+func openSerial(device string, baud int) (*os.File, error) {
+	f, err := os.OpenFile(device, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("stty", strconv.Itoa(baud), "raw", "-echo", "clocal", "cs8", "-cstopb", "-parenb", "-crtscts", "-ixon", "-ixoff")
+	cmd.Stdin = f
+	if out, err := cmd.CombinedOutput(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("stty: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return f, nil
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// openSerial opens a COM port raw at baud, 8N1 without flow control, set
+// up with mode first
+func openSerial(device string, baud int) (*os.File, error) {
+	port := strings.TrimSuffix(strings.TrimPrefix(strings.ToUpper(device), `\\.\`), ":")
+	cmd := exec.Command("mode", port+":", fmt.Sprintf("BAUD=%d", baud), "PARITY=n", "DATA=8", "STOP=1", "XON=off", "OCTS=off", "RTS=on", "DTR=on")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("mode: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.OpenFile(`\\.\`+port, os.O_RDWR, 0)
+}
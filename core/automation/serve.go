@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServeConfig configures serve mode
+type ServeConfig struct {
+	// SigningKey signs artifact URLs; "env:NAME" is resolved. A random key
+	// is generated per process when empty, so URLs die with the server.
+	SigningKey string `json:"signing_key"`
+	// URLTTL is how long artifact URLs stay valid, e.g. "1h" (default 1h)
+	URLTTL string `json:"url_ttl"`
+	// PublicURL is the externally reachable base URL used in results
+	PublicURL string `json:"public_url"`
+	// Frames configures the live screen stream
+	Frames FramesConfig `json:"frames"`
+	// AllowedOrigins are the web origins, e.g. "https://panel.example.com",
+	// whose pages may open /ws, /live and /frames besides this server's
+	// own; "*" allows any
+	AllowedOrigins []string `json:"allowed_origins"`
+	// Token is the bearer token every endpoint but /artifacts and
+	// /openapi.json requires in an Authorization header; "env:NAME" is
+	// resolved. A random token is generated per process and printed at
+	// start when empty.
+	Token string `json:"token"`
+}
+
+const defaultURLTTL = time.Hour
+
+// server runs scripts submitted over HTTP and serves their artifacts
+type server struct {
+	root      string // artifacts root, never listed
+	key       []byte
+	ttl       time.Duration
+	publicURL string
+	origins   []string
+	token     string
+	listen    string     // the address served, which requests must name as Host
+	runMu     sync.Mutex // the screen is shared, so runs are serialized
+	waiting   atomic.Int32
+	session   atomic.Bool // a /ws session holds the screen
+	alerts    *alertManager
+
+	runsMu   sync.Mutex
+	runs     map[string]*serveRun
+	runOrder []string // IDs, oldest first
+}
+
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "address to listen on")
+	serviceFlags(fs)
+	publicURL := fs.String("public-url", "", "base URL used in artifact links (default http://<listen>)")
+	grpcListen := fs.String("grpc", "", "also serve the gRPC API of proto/executor.proto on this address, e.g. 127.0.0.1:9090")
+	fs.Parse(args)
+
+	cfg := checkServiceSetup()
+	if _, err := negotiateFrames(cfg.Serve.Frames, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := initBackend(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	srv, err := newServer(cfg.Serve, screenshotsDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	srv.publicURL = strings.TrimSuffix(firstNonEmpty(*publicURL, cfg.Serve.PublicURL, "http://"+*listen), "/")
+	srv.listen = *listen
+	if cfg.Serve.Token == "" {
+		fmt.Fprintf(os.Stderr, "Token: %s\n", srv.token)
+	}
+	if srv.alerts, err = newAlertManager(cfg.Alerts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	go srv.alerts.watchDisplay(&srv.runMu)
+	os.MkdirAll(srv.root, 0755)
+
+	go enforceRetentionHourly(srv.root)
+
+	if *grpcListen != "" {
+		go srv.serveGRPC(*grpcListen)
+	}
+	fmt.Fprintf(os.Stderr, "Serving on %s\n", *listen)
+	if err := http.ListenAndServe(*listen, srv.routes()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newServer(cfg ServeConfig, root string) (*server, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	srv := &server{root: abs, ttl: defaultURLTTL, origins: cfg.AllowedOrigins}
+	if cfg.URLTTL != "" {
+		if srv.ttl, err = time.ParseDuration(cfg.URLTTL); err != nil || srv.ttl <= 0 {
+			return nil, fmt.Errorf("serve.url_ttl: invalid duration %q", cfg.URLTTL)
+		}
+	}
+	if srv.token = secretValue(cfg.Token); srv.token == "" {
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err != nil {
+			return nil, err
+		}
+		srv.token = hex.EncodeToString(token)
+	}
+	if key := secretValue(cfg.SigningKey); key != "" {
+		srv.key = []byte(key)
+	} else {
+		srv.key = make([]byte, 32)
+		if _, err := rand.Read(srv.key); err != nil {
+			return nil, err
+		}
+	}
+	return srv, nil
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/execute", s.authorized(s.handleExecute))
+	mux.HandleFunc("/status", s.authorized(s.handleStatus))
+	mux.HandleFunc("/status/", s.authorized(s.handleStatus))
+	mux.HandleFunc("/screenshot", s.authorized(s.handleScreenshot))
+	mux.HandleFunc("/artifacts/", s.handleArtifact)
+	mux.HandleFunc("/ws", s.authorized(s.handleWebSocket))
+	mux.HandleFunc("/live", s.authorized(s.handleLive))
+	mux.HandleFunc("/frames", s.authorized(s.handleFrames))
+	mux.HandleFunc("/openapi.json", s.handleOpenAPI)
+	return mux
+}
+
+// authorized lets through requests that name this server as their Host,
+// come from no page or an allowed one, and carry the bearer token. A form
+// on any web page can POST to a local port, and a page whose name is
+// rebound to 127.0.0.1 can read the answers; neither can set the header.
+func (s *server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedOrigin(r) {
+			http.Error(w, "origin or host not allowed", http.StatusForbidden)
+			return
+		}
+		token, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="executor"`)
+			http.Error(w, "missing or wrong bearer token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// bearerToken returns the token of the Authorization header or, since a
+// browser cannot set headers on a WebSocket, of a "bearer.TOKEN" entry in
+// Sec-WebSocket-Protocol. Such pages offer wsProtocol alongside it for
+// the server to pick.
+func bearerToken(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token, true
+	}
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			if token, ok := strings.CutPrefix(strings.TrimSpace(p), "bearer."); ok {
+				return token, true
+			}
+		}
+	}
+	return "", false
+}
+
+// allowedHost reports whether the request names this server: its listen
+// address, the public URL's host, localhost or an IP address. Rebinding
+// a page's name to this server leaves the page's name as the Host.
+func (s *server) allowedHost(r *http.Request) bool {
+	if strings.EqualFold(r.Host, s.listen) {
+		return true
+	}
+	if u, err := url.Parse(s.publicURL); err == nil && strings.EqualFold(r.Host, u.Host) {
+		return true
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return strings.EqualFold(host, "localhost") || net.ParseIP(host) != nil
+}
+
+// handleExecute runs the script in the request body and returns the result,
+// with signed links to its screenshots. With ?wait=false it answers 202 with
+// the queued run instead, to be polled at /status/ID.
+func (s *server) handleExecute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST a script", http.StatusMethodNotAllowed)
+		return
+	}
+	script, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wait := true
+	if v := r.URL.Query().Get("wait"); v != "" {
+		if wait, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "wait must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	run, code, msg := s.newRun(r)
+	if run == nil {
+		http.Error(w, msg, code)
+		return
+	}
+	w.Header().Set("X-Request-ID", run.ID)
+	if !wait {
+		go s.execute(run, script)
+		w.Header().Set("Location", "/status/"+run.ID)
+		writeJSON(w, http.StatusAccepted, s.snapshot(run))
+		return
+	}
+	s.execute(run, script)
+	writeJSON(w, http.StatusOK, *s.snapshot(run).Result)
+}
+
+// execute runs a submitted script once the screen is free
+func (s *server) execute(run *serveRun, script []byte) {
+	s.alerts.queued(int(s.waiting.Add(1)))
+	s.runMu.Lock()
+	s.alerts.queued(int(s.waiting.Add(-1)))
+	s.setRunStatus(run, "running", nil)
+	preloadScript(script)
+	result := runCommands(bufio.NewScanner(bytes.NewReader(script)))
+	s.runMu.Unlock()
+	s.setRunStatus(run, "done", &result)
+	s.alerts.ran(result)
+	savePortableResult(result)
+}
+
+// handleWebSocket runs an interactive session. Each text message from the
+// client holds one or more script lines; every step is answered with its
+// StepResult as soon as it finishes. Comment-only messages get no reply.
+func (s *server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	s.serveSession(w, r, nil, func(ws *wsConn, run *runner, message []byte) (bool, error) {
+		return runSessionMessage(run, message, func(steps []*StepResult) error {
+			return s.sendSteps(ws, steps)
+		})
+	})
+}
+
+// serveSession upgrades an authorized request to an interactive session
+// that owns the screen until it disconnects. open, when set, runs first;
+// each text message then goes to handle, whose ended closes the session.
+func (s *server) serveSession(w http.ResponseWriter, r *http.Request, open func(*wsConn, *runner) error,
+	handle func(ws *wsConn, run *runner, message []byte) (ended bool, err error)) {
+	if !s.runMu.TryLock() {
+		http.Error(w, "another session is running", http.StatusConflict)
+		return
+	}
+	defer s.runMu.Unlock()
+	s.session.Store(true)
+	defer s.session.Store(false)
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	run := newRunner()
+	defer func() {
+		run.close()
+		savePortableResult(run.result)
+	}()
+	if open != nil && open(ws, run) != nil {
+		return
+	}
+	for {
+		opcode, message, err := ws.readMessage()
+		if err != nil {
+			if err != errWSClosed {
+				fmt.Fprintf(os.Stderr, "Warning: websocket: %v\n", err)
+			}
+			return
+		}
+		if opcode != wsText {
+			ws.writeFrame(wsClose, []byte{0x03, 0xEB}) // 1003 unsupported data
+			return
+		}
+		ended, err := handle(ws, run, message)
+		if ended && err == nil {
+			ws.writeFrame(wsClose, []byte{0x03, 0xE8}) // 1000 normal closure
+		}
+		if ended || err != nil {
+			return
+		}
+	}
+}
+
+// runSessionMessage runs the script lines of one message of an interactive
+// session, passing steps to send as they finish. Steps are held back from
+// the one whose screenshot is coalesced with later steps until it has been
+// taken. ended reports that the run aborted, its rollback steps sent, which
+// ends the session.
+func runSessionMessage(run *runner, message []byte, send func([]*StepResult) error) (ended bool, err error) {
+	preloadScript(message)
+	var held []*StepResult
+	for _, line := range strings.Split(string(message), "\n") {
+		if step := run.runLine(line); step != nil {
+			held = append(held, step)
+		}
+		if run.aborted {
+			return true, send(append(held, run.finish()...))
+		}
+		ready := len(held)
+		for i, step := range held {
+			if step == run.pendingShot {
+				ready = i
+			}
+		}
+		if err := send(held[:ready]); err != nil {
+			return false, err
+		}
+		held = held[ready:]
+	}
+	run.flushScreenshot()
+	return false, send(held)
+}
+
+// sendSteps sends each step's StepResult with a signed screenshot link
+func (s *server) sendSteps(ws *wsConn, steps []*StepResult) error {
+	for _, step := range steps {
+		s.linkStep(step)
+		data, _ := json.Marshal(step)
+		if err := ws.writeText(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkStep gives a step's screenshot a signed link
+func (s *server) linkStep(step *StepResult) {
+	if step.Screenshot != nil {
+		if link, err := s.signedURL(step.Screenshot.File, time.Now().Add(s.ttl)); err == nil {
+			step.Screenshot.URL = link
+		}
+	}
+}
+
+// signedURL links to a file under the artifacts root until expires
+func (s *server) signedURL(file string, expires time.Time) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(s.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the artifacts directory", file)
+	}
+	rel = filepath.ToSlash(rel)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	u := url.URL{Path: "/artifacts/" + rel}
+	return fmt.Sprintf("%s%s?expires=%s&sig=%s", s.publicURL, u.EscapedPath(), exp, s.sign(rel, exp)), nil
+}
+
+func (s *server) sign(rel, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(rel + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleArtifact serves one file given a valid, unexpired signature.
+// Directories are never listed.
+func (s *server) handleArtifact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel := strings.TrimPrefix(r.URL.Path, "/artifacts/")
+	exp := r.URL.Query().Get("expires")
+	sig, err := hex.DecodeString(r.URL.Query().Get("sig"))
+	expected, _ := hex.DecodeString(s.sign(rel, exp))
+	if err != nil || !hmac.Equal(sig, expected) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		http.Error(w, "link expired", http.StatusForbidden)
+		return
+	}
+
+	path := filepath.Join(s.root, filepath.FromSlash(rel))
+	if !strings.HasPrefix(path, s.root+string(filepath.Separator)) {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
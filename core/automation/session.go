@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionWindow is one window's place in a desktop session
+type SessionWindow struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Class     string `json:"class,omitempty"`
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Workspace int    `json:"workspace"`
+}
+
+// SessionSnapshot is the window layout saved by snapshot_session
+type SessionSnapshot struct {
+	Name      string          `json:"name"`
+	Taken     time.Time       `json:"taken"`
+	Workspace int             `json:"workspace"`
+	Focused   string          `json:"focused,omitempty"`
+	Windows   []SessionWindow `json:"windows"` // bottom to top
+}
+
+// windowManager is implemented by backends that can list and arrange
+// windows
+type windowManager interface {
+	sessionLayout() (SessionSnapshot, error)
+	placeWindow(w SessionWindow) error
+	activateWindow(id string) error
+	setWorkspace(n int) error
+	closeWindow(id string) error
+}
+
+func currentWindowManager() (windowManager, error) {
+	wm, ok := baseBackend().(windowManager)
+	if !ok {
+		return nil, fmt.Errorf("backend %s cannot arrange windows", currentBackend().Name())
+	}
+	if currentTarget != nil {
+		return targetWindows{wm, currentTarget}, nil
+	}
+	return wm, nil
+}
+
+func sessionPath(name string) string {
+	return filepath.Join(stateDir(), "sessions", name+".json")
+}
+
+func validSessionName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && name != "." && name != ".."
+}
+
+// snapshotSession saves the current window layout under name
+func snapshotSession(name string) (SessionSnapshot, error) {
+	wm, err := currentWindowManager()
+	if err != nil {
+		return SessionSnapshot{}, err
+	}
+	snap, err := wm.sessionLayout()
+	if err != nil {
+		return SessionSnapshot{}, fmt.Errorf("reading window layout: %v", err)
+	}
+	snap.Name, snap.Taken = name, time.Now().UTC()
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return SessionSnapshot{}, err
+	}
+	path := sessionPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return SessionSnapshot{}, err
+	}
+	return snap, os.WriteFile(path, data, 0600)
+}
+
+// restoreSession moves the windows of a snapshot back into place, raising
+// them in their saved stacking order and focusing the saved window last.
+// Windows that have since closed are matched by title, or reported missing.
+func restoreSession(name string) (map[string]interface{}, error) {
+	wm, err := currentWindowManager()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(sessionPath(name))
+	if err != nil {
+		return nil, fmt.Errorf("no session snapshot %q: %v", name, err)
+	}
+	var snap SessionSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("session snapshot %q: %v", name, err)
+	}
+	now, err := wm.sessionLayout()
+	if err != nil {
+		return nil, fmt.Errorf("reading window layout: %v", err)
+	}
+
+	current := map[string]bool{}
+	byTitle := map[string][]string{}
+	for _, w := range now.Windows {
+		current[w.ID] = true
+		byTitle[w.Title] = append(byTitle[w.Title], w.ID)
+	}
+	used := map[string]bool{}
+	ids := map[string]string{} // snapshot ID to current ID
+	for _, w := range snap.Windows {
+		if current[w.ID] {
+			ids[w.ID], used[w.ID] = w.ID, true
+		}
+	}
+	restored, missing := []string{}, []string{}
+	var problems []string
+	for _, w := range snap.Windows {
+		id, ok := ids[w.ID]
+		if !ok {
+			for _, candidate := range byTitle[w.Title] {
+				if !used[candidate] {
+					id, ok = candidate, true
+					ids[w.ID], used[candidate] = id, true
+					break
+				}
+			}
+		}
+		if !ok {
+			missing = append(missing, w.Title)
+			continue
+		}
+		w.ID = id
+		if err := wm.placeWindow(w); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", w.Title, err))
+			continue
+		}
+		restored = append(restored, w.Title)
+	}
+	if err := wm.setWorkspace(snap.Workspace); err != nil {
+		problems = append(problems, fmt.Sprintf("workspace %d: %v", snap.Workspace, err))
+	}
+	if id, ok := ids[snap.Focused]; ok {
+		if err := wm.activateWindow(id); err != nil {
+			problems = append(problems, fmt.Sprintf("focus: %v", err))
+		}
+	}
+
+	output := map[string]interface{}{"restored": restored, "missing": missing, "taken": snap.Taken}
+	if len(problems) > 0 {
+		return output, fmt.Errorf("restore_session: %s", strings.Join(problems, "; "))
+	}
+	return output, nil
+}
+
+// closeWindows politely closes every window whose title contains title,
+// ignoring case, and returns the titles closed
+func closeWindows(title string) ([]string, error) {
+	wm, err := currentWindowManager()
+	if err != nil {
+		return nil, err
+	}
+	layout, err := wm.sessionLayout()
+	if err != nil {
+		return nil, fmt.Errorf("reading window layout: %v", err)
+	}
+	closed := []string{}
+	for _, w := range layout.Windows {
+		if !strings.Contains(strings.ToLower(w.Title), strings.ToLower(title)) {
+			continue
+		}
+		if err := wm.closeWindow(w.ID); err != nil {
+			return closed, fmt.Errorf("closing %s: %v", w.Title, err)
+		}
+		closed = append(closed, w.Title)
+	}
+	return closed, nil
+}
+
+// activeWindowTitle is the title of the focused window, empty if none is
+func activeWindowTitle() (string, error) {
+	wm, err := currentWindowManager()
+	if err != nil {
+		return "", err
+	}
+	layout, err := wm.sessionLayout()
+	if err != nil {
+		return "", fmt.Errorf("reading window layout: %v", err)
+	}
+	for _, w := range layout.Windows {
+		if w.ID == layout.Focused {
+			return w.Title, nil
+		}
+	}
+	return "", nil
+}
+
+// xdotool reads the layout window by window; stacking order is the order
+// of xdotool search, which lists windows bottom to top
+func (xdotoolBackend) sessionLayout() (SessionSnapshot, error) {
+	var snap SessionSnapshot
+	out, err := exec.Command("xdotool", "search", "--onlyvisible", "--name", "").Output()
+	// xdotool search exits non-zero when no window matches
+	if _, none := err.(*exec.ExitError); err != nil && !none {
+		return snap, err
+	}
+	if desk, err := xdotoolInt("get_desktop"); err == nil {
+		snap.Workspace = desk
+	}
+	if active, err := exec.Command("xdotool", "getactivewindow").Output(); err == nil {
+		snap.Focused = strings.TrimSpace(string(active))
+	}
+	snap.Windows = []SessionWindow{}
+	for _, id := range strings.Fields(string(out)) {
+		w := SessionWindow{ID: id}
+		geometry, err := exec.Command("xdotool", "getwindowgeometry", "--shell", id).Output()
+		if err != nil {
+			continue // closed while listing
+		}
+		for _, line := range strings.Split(string(geometry), "\n") {
+			key, value, _ := strings.Cut(line, "=")
+			n, _ := strconv.Atoi(value)
+			switch key {
+			case "X":
+				w.X = n
+			case "Y":
+				w.Y = n
+			case "WIDTH":
+				w.Width = n
+			case "HEIGHT":
+				w.Height = n
+			}
+		}
+		if name, err := exec.Command("xdotool", "getwindowname", id).Output(); err == nil {
+			w.Title = strings.TrimSpace(string(name))
+		}
+		// getwindowclassname needs xdotool 3.20210804 or later
+		if class, err := exec.Command("xdotool", "getwindowclassname", id).Output(); err == nil {
+			w.Class = strings.TrimSpace(string(class))
+		}
+		if desk, err := xdotoolInt("get_desktop_for_window", id); err == nil {
+			w.Workspace = desk
+		}
+		snap.Windows = append(snap.Windows, w)
+	}
+	return snap, nil
+}
+
+func (xdotoolBackend) placeWindow(w SessionWindow) error {
+	// Not every window manager has workspaces; the move still matters
+	runXdotool("set_desktop_for_window", w.ID, strconv.Itoa(w.Workspace))
+	if err := runXdotool("windowsize", w.ID, strconv.Itoa(w.Width), strconv.Itoa(w.Height)); err != nil {
+		return err
+	}
+	if err := runXdotool("windowmove", w.ID, strconv.Itoa(w.X), strconv.Itoa(w.Y)); err != nil {
+		return err
+	}
+	return runXdotool("windowraise", w.ID)
+}
+
+func (xdotoolBackend) activateWindow(id string) error {
+	return runXdotool("windowactivate", "--sync", id)
+}
+
+func (xdotoolBackend) setWorkspace(n int) error {
+	return runXdotool("set_desktop", strconv.Itoa(n))
+}
+
+func (xdotoolBackend) closeWindow(id string) error {
+	if err := runXdotool("windowclose", id); err == nil {
+		return nil
+	}
+	// xdotool before 3.20210804 has no windowclose
+	return exec.Command("wmctrl", "-i", "-c", id).Run()
+}
+
+func xdotoolInt(args ...string) (int, error) {
+	out, err := exec.Command("xdotool", args...).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
+
+// The mock screen has a single workspace
+func (m *mockBackend) sessionLayout() (SessionSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snap := SessionSnapshot{Windows: []SessionWindow{}}
+	for _, w := range m.windows {
+		snap.Windows = append(snap.Windows, SessionWindow{ID: w.id, Title: w.Title,
+			X: w.X, Y: w.Y, Width: w.Width, Height: w.Height})
+	}
+	if m.focused != nil {
+		snap.Focused = m.focused.id
+	}
+	return snap, nil
+}
+
+func (m *mockBackend) placeWindow(sw SessionWindow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := m.window(sw.ID)
+	if w == nil {
+		return fmt.Errorf("no window %s", sw.ID)
+	}
+	w.X, w.Y, w.Width, w.Height = sw.X, sw.Y, sw.Width, sw.Height
+	focused := m.focused
+	m.raise(w)
+	m.focused = focused
+	return nil
+}
+
+func (m *mockBackend) activateWindow(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := m.window(id)
+	if w == nil {
+		return fmt.Errorf("no window %s", id)
+	}
+	m.raise(w)
+	return nil
+}
+
+func (m *mockBackend) setWorkspace(n int) error {
+	if n != 0 {
+		return fmt.Errorf("the mock screen has no workspace %d", n)
+	}
+	return nil
+}
+
+func (m *mockBackend) closeWindow(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, w := range m.windows {
+		if w.id == id {
+			m.windows = append(m.windows[:i:i], m.windows[i+1:]...)
+			if m.focused == w {
+				m.focused = nil
+				if len(m.windows) > 0 {
+					m.focused = m.windows[len(m.windows)-1]
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no window %s", id)
+}
+
+// The mock clipboard holds what ctrl+c copied from a window
+func (m *mockBackend) clearClipboard() error { return m.writeClipboard("") }
+
+func (m *mockBackend) readClipboard() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.clipboard, nil
+}
+
+func (m *mockBackend) writeClipboard(text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clipboard = text
+	return nil
+}
+
+func (m *mockBackend) window(id string) *mockWindow {
+	for _, w := range m.windows {
+		if w.id == id {
+			return w
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A setup section runs before the main body to bring the desktop to a
+// known state:
+//
+//	setup: abort
+//	close_window "Update available"
+//	clear_clipboard
+//	do_not_disturb on
+//	end
+//
+// Its policy decides what a failing setup step does: continue (the
+// default) reports it as a setup_failed event and keeps going without
+// failing the run; abort stops the run before the body starts.
+
+// setupFile and setupPolicy are set by --setup and --setup-policy
+var (
+	setupFile   string
+	setupPolicy = "continue"
+)
+
+var setupPolicies = []string{"continue", "abort"}
+
+// setupLine starts or ends a setup section. It reports whether the line
+// was consumed.
+func (r *runner) setupLine(line string) bool {
+	if r.inSetup && strings.EqualFold(line, "end") {
+		r.inSetup = false
+		return true
+	}
+	name, policy, _ := strings.Cut(line, ":")
+	if !strings.EqualFold(strings.TrimSpace(name), "setup") || !strings.Contains(line, ":") {
+		return false
+	}
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	if policy == "" {
+		policy = setupPolicy
+	}
+	switch {
+	case r.bodyStarted || r.inSetup:
+		r.result.Errors = append(r.result.Errors, fmt.Sprintf("After step %d: setup: must come before the first step", r.step))
+		r.result.Status = "error"
+	case !contains(setupPolicies, policy):
+		r.result.Errors = append(r.result.Errors, fmt.Sprintf("Unknown setup policy: %s", policy))
+		r.result.Status = "error"
+	default:
+		r.inSetup, r.setupPolicy = true, policy
+	}
+	return true
+}
+
+// markerLine reports whether line opens or closes a block or section
+func markerLine(line string) bool {
+	name, _, section := strings.Cut(line, ":")
+	_, recover := recoverBlock(line)
+	return recover || strings.EqualFold(line, "on_rollback") || strings.EqualFold(line, "end") ||
+		strings.EqualFold(line, "parallel") || strings.EqualFold(line, "branch") ||
+		section && strings.EqualFold(strings.TrimSpace(name), "setup")
+}
+
+// runSetupStep runs a step of the setup section under its policy
+func (r *runner) runSetupStep(line string) *StepResult {
+	errors, status := len(r.result.Errors), r.result.Status
+	step := r.runStep(line)
+	if step == nil {
+		return nil
+	}
+	event := Event{Step: step.Step, Type: "setup", Message: "setup step"}
+	if step.Status == "error" {
+		event.Type, event.Message = "setup_failed", step.Error
+		switch r.setupPolicy {
+		case "continue":
+			r.result.Errors, r.result.Status = r.result.Errors[:errors], status
+		case "abort":
+			r.abort(fmt.Sprintf("setup step %d failed", step.Step))
+		}
+	}
+	r.result.Events = append(r.result.Events, event)
+	step.Events = append(step.Events, event)
+	return step
+}
+
+// runSetupFile runs --setup as the script's setup section
+func (r *runner) runSetupFile() {
+	if setupFile == "" {
+		return
+	}
+	file, err := os.Open(setupFile)
+	if err != nil {
+		r.result.Errors = append(r.result.Errors, fmt.Sprintf("Setup: %v", err))
+		r.result.Status = "error"
+		r.aborted = true
+		return
+	}
+	defer file.Close()
+	r.inSetup, r.setupPolicy = true, setupPolicy
+	scanner := bufio.NewScanner(file)
+	for !r.aborted && scanner.Scan() {
+		r.runLine(scanner.Text())
+	}
+	r.inSetup = false
+}
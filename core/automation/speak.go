@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// speak and beep call a person to an unattended machine, at an approval
+// gate or, in an on_rollback block, when a run fails:
+//
+//	speak "Need human approval"
+//	beep
+//	beep /usr/share/sounds/freedesktop/stereo/bell.oga
+//
+// speak goes through speech-dispatcher's spd-say and returns once the
+// text has been spoken. beep plays a short tone, or a sound file, with
+// paplay.
+
+const (
+	beepHz      = 880
+	beepSeconds = 0.4
+	beepRate    = 22050
+)
+
+// parseSound reads speak "TEXT" and beep [FILE]
+func parseSound(cmd *Command, line string, parts []string) *Command {
+	rest := strings.Trim(strings.TrimSpace(line[len(parts[0]):]), "\"")
+	switch {
+	case cmd.Action == "speak" && rest != "":
+		cmd.Params["text"] = rest
+	case cmd.Action == "beep":
+		if rest != "" {
+			cmd.Params["file"] = rest
+		}
+	default:
+		return nil
+	}
+	return cmd
+}
+
+func speak(text string) error {
+	if err := exec.Command("spd-say", "--wait", "--", text).Run(); err != nil {
+		return fmt.Errorf("spd-say: %v", commandError(err))
+	}
+	return nil
+}
+
+// beep plays file, or a tone when file is empty
+func beep(file string) error {
+	if file == "" {
+		f, err := os.CreateTemp("", "agentos-beep-*.wav")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		f.Write(beepWAV())
+		if err := f.Close(); err != nil {
+			return err
+		}
+		file = f.Name()
+	}
+	if err := exec.Command("paplay", file).Run(); err != nil {
+		return fmt.Errorf("paplay: %v", commandError(err))
+	}
+	return nil
+}
+
+// beepWAV is a sine tone as 16-bit mono WAV, faded in and out so it does
+// not click
+func beepWAV() []byte {
+	n := int(beepRate * beepSeconds)
+	fade := beepRate / 100
+	samples := make([]int16, n)
+	for i := range samples {
+		gain := math.Min(1, math.Min(float64(i), float64(n-1-i))/float64(fade))
+		samples[i] = int16(gain * 0.5 * 32767 * math.Sin(2*math.Pi*beepHz*float64(i)/beepRate))
+	}
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+2*n))
+	b.WriteString("WAVEfmt ")
+	// PCM, mono, the rate, bytes per second, bytes per frame, bits
+	for _, v := range []interface{}{uint32(16), uint16(1), uint16(1), uint32(beepRate), uint32(2 * beepRate), uint16(2), uint16(16)} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(2*n))
+	binary.Write(&b, binary.LittleEndian, samples)
+	return b.Bytes()
+}
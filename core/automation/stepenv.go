@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Programs a step starts inherit the executor's environment and working
+// directory unless the run or the step says otherwise:
+//
+//	@env: LANG=de_DE.UTF-8 GDK_SCALE=2   sets variables for the next step
+//	@cwd: ~/projects/demo                starts its programs there
+//
+// Values with spaces are double-quoted, NAME="a b". Several @env lines
+// before one step add up. --env and --cwd set the same for every step; a
+// step's @env adds to and overrides the run's variables and its @cwd
+// replaces the run's directory. They apply to launch and launch_sandboxed,
+// whose sandbox variables still win.
+
+// runEnv and runCwd are set by --env and --cwd
+var (
+	runEnv string
+	runCwd string
+)
+
+// launchEnv is what a step's programs start with
+type launchEnv struct {
+	vars map[string]string
+	dir  string // "" for the executor's own
+}
+
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnvAssignments reads NAME=value pairs separated by spaces
+func parseEnvAssignments(value string) (map[string]string, error) {
+	vars := map[string]string{}
+	fields, err := quotedFields(value)
+	if err != nil {
+		return nil, fmt.Errorf("@env: %v", err)
+	}
+	for _, field := range fields {
+		name, v, ok := strings.Cut(field, "=")
+		if !ok || !envNameRe.MatchString(name) {
+			return nil, fmt.Errorf("@env needs NAME=value pairs, got %q", field)
+		}
+		vars[name] = v
+	}
+	if len(vars) == 0 {
+		return nil, fmt.Errorf("@env needs a value")
+	}
+	return vars, nil
+}
+
+// quotedFields splits s at spaces outside double quotes, dropping the
+// quotes
+func quotedFields(s string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	quoted, inField := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted, inField = !quoted, true
+		case unicode.IsSpace(r) && !quoted:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// resolveDir expands a leading ~ and makes dir absolute
+func resolveDir(dir string) (string, error) {
+	if dir == "~" || strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, dir[1:])
+	}
+	return filepath.Abs(dir)
+}
+
+// checkRunEnv validates --env and --cwd at startup
+func checkRunEnv() error {
+	if runEnv != "" {
+		if _, err := parseEnvAssignments(runEnv); err != nil {
+			return fmt.Errorf("--env: %v", err)
+		}
+	}
+	if runCwd != "" {
+		dir, err := resolveDir(runCwd)
+		if err == nil {
+			var info os.FileInfo
+			if info, err = os.Stat(dir); err == nil && !info.IsDir() {
+				err = fmt.Errorf("%s is not a directory", dir)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("--cwd: %v", err)
+		}
+	}
+	return nil
+}
+
+// stepLaunchEnv combines the run's defaults with a step's annotations,
+// which checkAnnotation has validated
+func stepLaunchEnv(annotations map[string]string) launchEnv {
+	e := launchEnv{vars: map[string]string{}, dir: runCwd}
+	for _, spec := range []string{runEnv, annotations["env"]} {
+		if spec == "" {
+			continue
+		}
+		vars, _ := parseEnvAssignments(spec)
+		for k, v := range vars {
+			e.vars[k] = v
+		}
+	}
+	if dir := annotations["cwd"]; dir != "" {
+		e.dir = dir
+	}
+	return e
+}
+
+// localDir is the directory to start a local program in, checked to exist
+// so a typo fails the step rather than the program silently
+func (e launchEnv) localDir() (string, error) {
+	if e.dir == "" {
+		return "", nil
+	}
+	dir, err := resolveDir(e.dir)
+	if err != nil {
+		return "", fmt.Errorf("@cwd: %v", err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("@cwd: %s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// mergeEnv is env with the variables in set added or replaced
+func mergeEnv(env []string, set map[string]string) []string {
+	out := make([]string, 0, len(env)+len(set))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, replaced := set[name]; !replaced {
+			out = append(out, kv)
+		}
+	}
+	for name, value := range set {
+		out = append(out, name+"="+value)
+	}
+	return out
+}
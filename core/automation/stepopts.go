@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Step options, written as annotations before a step:
+//
+//	@name open settings   labels the step in errors, outputs and screenshots
+//	@timeout 5s           fails the step if it takes longer, aborting the run
+//	@retries 2            runs a failing step again, up to twice more
+//	@pre window "Mail"    checks a condition before the step
+//	@post text "Sent"     checks a condition after it
+//
+// A step that timed out may still be acting, so it is not retried and the
+// run stops there, rolling back if it has rollback blocks once the step
+// has stopped.
+
+// retryPause is how long a failed step waits before it is tried again
+const retryPause = time.Second
+
+// timeoutError is the error of a step that ran out of time
+type timeoutError struct{ after time.Duration }
+
+func (e timeoutError) Error() string { return fmt.Sprintf("timed out after %s", e.after) }
+
+// checkAnnotation validates an annotation's value
+func checkAnnotation(name, value string) error {
+	var err error
+	switch name {
+	case "budget":
+		_, err = parseBudget(value)
+	case "timeout":
+		_, err = parseTimeout(value)
+	case "retries":
+		_, err = parseRetries(value)
+	case "env":
+		_, err = parseEnvAssignments(value)
+	case "pre", "post":
+		if _, perr := parseConditions(value); perr != nil {
+			err = fmt.Errorf("@%s: %v", name, perr)
+		}
+	case "name", "idem", "cwd":
+		if value == "" {
+			err = fmt.Errorf("@%s needs a value", name)
+		}
+	}
+	return err
+}
+
+func parseTimeout(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("@timeout needs a positive duration such as 30s, got %q", value)
+	}
+	return d, nil
+}
+
+func parseRetries(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("@retries needs a count such as 2, got %q", value)
+	}
+	return n, nil
+}
+
+// stepLabel names a step in messages: its number, and its @name if it has
+// one
+func stepLabel(step int, name string) string {
+	if name == "" {
+		return fmt.Sprintf("Step %d", step)
+	}
+	return fmt.Sprintf("Step %d (%s)", step, name)
+}
+
+// execute runs a step's command under its @timeout and @retries
+func (r *runner) execute(cmd *Command, step *StepResult) error {
+	timeout, _ := parseTimeout(cmd.Annotations["timeout"])
+	retries, _ := parseRetries(cmd.Annotations["retries"])
+	cmd.sandbox = r.sandbox
+	for attempt := 1; ; attempt++ {
+		err := r.executeOnce(cmd, timeout)
+		if err == nil || attempt > retries {
+			return err
+		}
+		if _, ok := err.(timeoutError); ok {
+			return err
+		}
+		event := Event{Step: step.Step, Type: "retry",
+			Message: fmt.Sprintf("attempt %d of %d failed: %v", attempt, retries+1, err)}
+		r.result.Events = append(r.result.Events, event)
+		step.Events = append(step.Events, event)
+		r.unlocked(func() { clock.Sleep(retryPause) })
+	}
+}
+
+// abandoned holds the finished channels of timed-out commands that may
+// still be sending input
+var abandoned struct {
+	sync.Mutex
+	commands []chan struct{}
+}
+
+// awaitAbandoned waits for timed-out commands to stop, so that their
+// input does not interleave with the next step's, a rollback's or the
+// release of held keys
+func awaitAbandoned() {
+	abandoned.Lock()
+	pending := abandoned.commands
+	abandoned.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	for _, finished := range pending {
+		<-finished
+	}
+	abandoned.Lock()
+	defer abandoned.Unlock()
+	running := abandoned.commands[:0]
+	for _, finished := range abandoned.commands {
+		select {
+		case <-finished:
+		default:
+			running = append(running, finished)
+		}
+	}
+	abandoned.commands = running
+}
+
+// executeWithin runs cmd, giving up after timeout if it is set. The command
+// runs on a copy, so an abandoned one cannot write the step's output; it
+// is left to finish, and awaitAbandoned waits for it before more input.
+func executeWithin(cmd *Command, timeout time.Duration) error {
+	awaitAbandoned()
+	if timeout <= 0 {
+		return executeCommand(cmd)
+	}
+	c := *cmd
+	done := make(chan error, 1)
+	finished := make(chan struct{})
+	deadline := clock.After(timeout)
+	go func() {
+		defer close(finished)
+		done <- executeCommand(&c)
+	}()
+	select {
+	case err := <-done:
+		// Finishing only as the deadline passed is still too late
+		select {
+		case <-deadline:
+			return timeoutError{timeout}
+		default:
+		}
+		cmd.Output = c.Output
+		return err
+	case <-deadline:
+		abandoned.Lock()
+		abandoned.commands = append(abandoned.commands, finished)
+		abandoned.Unlock()
+		return timeoutError{timeout}
+	}
+}
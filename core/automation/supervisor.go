@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// supervisor owns the goroutines a run keeps in the background: the
+// interrupt handler, the monitor layout watcher, the audio recorder and
+// whatever else needs to outlive a single step. Components start in the
+// order they are added and stop in reverse, each seeing its context
+// cancelled and being waited for before the one started before it, so a
+// component may rely on anything started earlier for its whole life.
+//
+// A component that fails does not stop the others or the run; its error
+// is kept and reported with the result when the run ends.
+type supervisor struct {
+	mu         sync.Mutex
+	components []*component
+	errs       []error
+	stopped    bool
+}
+
+type component struct {
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// background is the supervisor of the run in progress, for components
+// started by steps rather than the runner; nil between runs
+var background *supervisor
+
+func newSupervisor() *supervisor {
+	return &supervisor{}
+}
+
+// start runs fn in its own goroutine until its context is cancelled by
+// stop. fn returns nil when cancelled; anything else it returns is kept as
+// the component's failure. Without a supervisor, as outside a run, fn
+// runs unsupervised until it returns by itself.
+func (s *supervisor) start(name string, fn func(ctx context.Context) error) {
+	if s == nil {
+		go fn(context.Background())
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &component{name: name, cancel: cancel, done: make(chan struct{})}
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		cancel()
+		close(c.done)
+		return
+	}
+	s.components = append(s.components, c)
+	s.mu.Unlock()
+	go func() {
+		defer close(c.done)
+		err := fn(ctx)
+		if err != nil && ctx.Err() == nil {
+			s.mu.Lock()
+			s.errs = append(s.errs, fmt.Errorf("%s: %v", name, err))
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// stop shuts the components down, last started first, and returns the
+// errors of those that failed while running
+func (s *supervisor) stop() []error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	s.stopped = true
+	components := s.components
+	s.components = nil
+	s.mu.Unlock()
+	for i := len(components) - 1; i >= 0; i-- {
+		components[i].cancel()
+		<-components[i].done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := s.errs
+	s.errs = nil
+	return errs
+}
+
+// stopBackground stops the run's background components, recording any
+// that failed as events. It is called again by close, when it does nothing.
+func (r *runner) stopBackground() {
+	if background == r.background {
+		background = nil
+	}
+	for _, err := range r.background.stop() {
+		r.result.Events = append(r.result.Events, Event{Step: r.step, Type: "background_error", Message: err.Error()})
+	}
+}
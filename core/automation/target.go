@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// targetSpec is set by --target: docker:CONTAINER drives applications in a
+// running container that shares the host's X socket. The container gets
+// an X cookie, launch starts applications inside it, and window queries
+// only see the windows its clients opened. adb:SERIAL drives an Android
+// device instead of the display.
+var targetSpec string
+
+// containerXauth is where the container's copy of the X cookie goes
+const containerXauth = "/tmp/.agentos-xauth"
+
+// dockerTarget is the container selected with --target
+type dockerTarget struct {
+	name     string
+	hostname string // what its X clients report as WM_CLIENT_MACHINE
+	env      map[string]string
+	sandbox  string // sandbox directory inside the container, if any
+}
+
+var currentTarget *dockerTarget
+
+// initTarget sets up the target selected with --target
+func initTarget() error {
+	if targetSpec == "" {
+		return nil
+	}
+	kind, name, _ := strings.Cut(targetSpec, ":")
+	switch {
+	case name == "":
+	case kind == "docker":
+		return initDockerTarget(name)
+	case kind == "adb":
+		return initADBTarget(name)
+	}
+	return fmt.Errorf("unknown --target %q (want docker:CONTAINER or adb:SERIAL)", targetSpec)
+}
+
+// initDockerTarget checks the container can reach the display and hands
+// it the display credentials
+func initDockerTarget(name string) error {
+	if backendName == "mock" {
+		return fmt.Errorf("--target %s needs a display backend, not mock", targetSpec)
+	}
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		return fmt.Errorf("--target %s: DISPLAY is not set", targetSpec)
+	}
+
+	out, err := exec.Command("docker", "inspect", "--format", "{{json .}}", name).Output()
+	if err != nil {
+		return fmt.Errorf("docker inspect %s: %v", name, commandError(err))
+	}
+	var info struct {
+		State      struct{ Running bool }
+		Config     struct{ Hostname string }
+		HostConfig struct{ NetworkMode string }
+		Mounts     []struct{ Source, Destination string }
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return fmt.Errorf("docker inspect %s: %v", name, err)
+	}
+	if !info.State.Running {
+		return fmt.Errorf("container %s is not running", name)
+	}
+	mounted := map[string]bool{}
+	for _, m := range info.Mounts {
+		mounted[m.Destination] = true
+	}
+	// A container on the host network also reaches the abstract socket
+	if !mounted["/tmp/.X11-unix"] && info.HostConfig.NetworkMode != "host" {
+		return fmt.Errorf("container %s cannot reach the X server: run it with -v /tmp/.X11-unix:/tmp/.X11-unix", name)
+	}
+
+	t := &dockerTarget{name: name, hostname: info.Config.Hostname, env: map[string]string{"DISPLAY": display}}
+	if wayland, runtime := os.Getenv("WAYLAND_DISPLAY"), os.Getenv("XDG_RUNTIME_DIR"); wayland != "" && runtime != "" {
+		socket := filepath.Join(runtime, wayland)
+		if mounted[runtime] || mounted[socket] {
+			t.env["WAYLAND_DISPLAY"], t.env["XDG_RUNTIME_DIR"] = wayland, runtime
+			if mounted[socket] && !mounted[runtime] {
+				t.env["WAYLAND_DISPLAY"] = socket
+			}
+		}
+	}
+	if cookie, err := wildcardCookie(display); err == nil && len(cookie) > 0 {
+		if err := t.copyIn(cookie, containerXauth); err != nil {
+			return fmt.Errorf("copying the X cookie into %s: %v", name, err)
+		}
+		t.env["XAUTHORITY"] = containerXauth
+	}
+	currentTarget = t
+	return nil
+}
+
+// wildcardCookie is the display's X cookie as an Xauthority file valid from
+// any host name, since the container's differs from ours. xauth nlist
+// prints the records hex encoded, so they only need decoding.
+func wildcardCookie(display string) ([]byte, error) {
+	out, err := exec.Command("xauth", "nlist", display).Output()
+	if err != nil {
+		return nil, err
+	}
+	var file []byte
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = "ffff" // FamilyWild
+		record, err := hex.DecodeString(strings.Join(fields, ""))
+		if err != nil {
+			return nil, fmt.Errorf("xauth nlist: %v", err)
+		}
+		file = append(file, record...)
+	}
+	return file, nil
+}
+
+// copyIn writes data to path in the container, readable only by the
+// user its applications run as, whom docker exec runs as too. The data
+// is streamed in, so no copy lands on the host's disk, and written to a
+// private file that is then moved into place.
+func (t *dockerTarget) copyIn(data []byte, path string) error {
+	script := fmt.Sprintf(`umask 077 && tmp=$(mktemp "%[1]s.XXXXXX") && cat > "$tmp" && mv -f "$tmp" "%[1]s"`, path)
+	cmd := exec.Command("docker", "exec", "-i", t.name, "sh", "-c", script)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// launch starts app in the container, detached. Sandboxed applications get
+// a temporary home inside the container, and their process IDs are kept
+// there so closeSandbox can stop them.
+func (t *dockerTarget) launch(app string, args []string, sandboxed bool, le launchEnv) (string, error) {
+	env := map[string]string{}
+	for k, v := range t.env {
+		env[k] = v
+	}
+	for k, v := range le.vars {
+		env[k] = v
+	}
+	if sandboxed {
+		if t.sandbox == "" {
+			out, err := exec.Command("docker", "exec", t.name, "mktemp", "-d", "/tmp/agentos-sandbox-XXXXXX").Output()
+			if err != nil {
+				return "", fmt.Errorf("creating sandbox in %s: %v", t.name, commandError(err))
+			}
+			t.sandbox = strings.TrimSpace(string(out))
+		}
+		for k, v := range sandboxVars(t.sandbox) {
+			env[k] = v
+		}
+		if profile, ok := profileArgs[filepath.Base(app)]; ok {
+			args = append(profile(t.sandbox+"/profiles/"+filepath.Base(app)), args...)
+		}
+		// Record the PID, then become the application
+		script := fmt.Sprintf(`mkdir -p "$HOME" "$XDG_CONFIG_HOME" "$XDG_DATA_HOME" "$XDG_CACHE_HOME" "$XDG_STATE_HOME" && echo $$ >> %s/pids && exec "$0" "$@"`, t.sandbox)
+		args = append([]string{"-c", script, app}, args...)
+		app = "sh"
+	}
+	cmd := []string{"exec", "-d"}
+	for k, v := range env {
+		cmd = append(cmd, "-e", k+"="+v)
+	}
+	if le.dir != "" {
+		// The directory is the container's, relative to its working directory
+		cmd = append(cmd, "-w", le.dir)
+	}
+	cmd = append(append(cmd, t.name, app), args...)
+	if out, err := exec.Command("docker", cmd...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("docker exec: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return t.sandbox, nil
+}
+
+// close stops the sandboxed applications and removes the sandbox
+func (t *dockerTarget) close() {
+	if t.sandbox == "" {
+		return
+	}
+	script := fmt.Sprintf(`pids=$(cat %[1]s/pids 2>/dev/null); [ -n "$pids" ] && kill $pids 2>/dev/null && sleep 2 && kill -9 $pids 2>/dev/null; rm -rf %[1]s`, t.sandbox)
+	if out, err := exec.Command("docker", "exec", t.name, "sh", "-c", script).CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: removing sandbox %s in %s: %v %s\n", t.sandbox, t.name, err, strings.TrimSpace(string(out)))
+	}
+	t.sandbox = ""
+}
+
+// targetWindows scopes a window manager to the container's windows
+type targetWindows struct {
+	windowManager
+	target *dockerTarget
+}
+
+func (s targetWindows) sessionLayout() (SessionSnapshot, error) {
+	snap, err := s.windowManager.sessionLayout()
+	if err != nil {
+		return snap, err
+	}
+	var kept []SessionWindow
+	focused := false
+	for _, w := range snap.Windows {
+		if clientMachine(w.ID) == s.target.hostname {
+			kept = append(kept, w)
+			focused = focused || w.ID == snap.Focused
+		}
+	}
+	snap.Windows = append([]SessionWindow{}, kept...)
+	if !focused {
+		snap.Focused = ""
+	}
+	return snap, nil
+}
+
+// clientMachine is the host an X client says it runs on
+func clientMachine(id string) string {
+	out, err := exec.Command("xprop", "-id", id, "WM_CLIENT_MACHINE").Output()
+	if err != nil {
+		return ""
+	}
+	// WM_CLIENT_MACHINE(STRING) = "host"
+	_, value, ok := strings.Cut(string(out), "=")
+	if !ok {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(value), `"`)
+}
+
+// commandError includes what a failed command printed
+func commandError(err error) error {
+	if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exit.Stderr)))
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+# Click by name, with a position, and by X button number
+click right double 100 200
+click double right
+click middle
+click triple 640 400
+click 9 s
+click 4 s
+click 10 s
+click 0 d
+click left left
+click 100
@@ -0,0 +1,8 @@
+# Preconditions gate a step; postconditions must hold after it
+@pre: text "Documents"
+@post: text "Downloads"
+pointer 120 120
+@pre: not text "Sign in"
+click 1 s
+@pre: text "no such text"
+type never typed
@@ -0,0 +1,6 @@
+# Lines that do not parse and actions that fail
+pointer
+frobnicate 1 2
+click_image missing.png 0.8
+drag 100 100 300 300 0.1
+scroll 700 400 -3
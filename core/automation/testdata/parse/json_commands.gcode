@@ -0,0 +1,6 @@
+# Command objects on their own lines keep their types
+{"action": "pointer", "params": {"x": 200, "y": 70}}
+{"action": "click", "params": {"button": 1, "clicks": "s"}}
+{"action": "type", "params": {"text": "two\nlines"}}
+{"action": "click_image", "params": {"file": "missing.png"}}
+{"action": "click", "params": {"button": "1", "clicks": "s"}}
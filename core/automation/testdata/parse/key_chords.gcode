@@ -0,0 +1,9 @@
+# Chords are modifiers then one key; names are checked before pressing
+key ctrl+shift+t
+key ctrl+a Delete
+key CTRL+Return
+key Retrun
+key a+ctrl
+keydown shift
+keyup shift
+keys
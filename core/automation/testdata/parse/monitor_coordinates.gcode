@@ -0,0 +1,8 @@
+# Coordinates qualified with a monitor number; the mock backend has none
+pointer 1:400 1:300
+click left 2:10 2:20
+drag 1:0 1:0 1:50 1:50 0.1
+screenshot monitor 1
+pointer 1:400 300
+pointer 0:10 0:10
+pointer 1:x 1:10
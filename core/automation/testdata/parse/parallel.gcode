@@ -0,0 +1,13 @@
+# Branches run together and the block is a step of its own
+@name both
+parallel
+wait 0.01
+pointer 100 100
+branch
+read_text
+end
+parallel
+assert_text "missing"
+branch
+wait 0.01
+end
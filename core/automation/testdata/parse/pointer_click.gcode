@@ -0,0 +1,6 @@
+# Focus the Files window and double click an entry
+pointer 200 70
+click 1 s
+pointer 120 120
+click 1 d
+click 3 s
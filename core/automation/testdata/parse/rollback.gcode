@@ -0,0 +1,11 @@
+# A failing step rolls back completed steps, newest first
+on_rollback
+key Escape
+end
+click 100 100
+on_rollback
+click 640 400
+end
+type hello
+assert_text "no such text"
+type never run
@@ -0,0 +1,9 @@
+# A setup section's failures do not fail the run under the continue policy
+setup:
+close_window Files
+close_window "No such window"
+clear_clipboard
+assert_text "Update available"
+end
+assert_text Terminal
+type ls
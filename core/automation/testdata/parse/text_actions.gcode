@@ -0,0 +1,5 @@
+assert_text "Documents"
+click_text "Downloads"
+read_text 60 60 520 360
+assert_text "not on screen"
+observe ocr
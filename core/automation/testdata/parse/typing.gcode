@@ -0,0 +1,8 @@
+pointer 700 400
+click 1 s
+type "echo hello"
+key Return
+type "second line"
+key BackSpace
+wait 0.01
+wait auto 0
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Golden files for `test-parse`: next to each NAME.gcode script are
+// NAME.parsed.json, the parsed commands, and NAME.result.json, the shape of
+// the result of running it on the mock backend. A NAME.yaml script is
+// converted to script lines first, and its parsed lines are numbered as
+// those.
+
+// ParsedLine is one script line in a parse golden file
+type ParsedLine struct {
+	Line    int      `json:"line"`
+	Command *Command `json:"command"` // null when the line does not parse
+}
+
+// ResultSkeleton is the part of a result that must not change between
+// runs: no file names, timings or recognized text
+type ResultSkeleton struct {
+	Status           string         `json:"status"`
+	CommandsExecuted int            `json:"commands_executed"`
+	Steps            []StepSkeleton `json:"steps"`
+}
+
+// StepSkeleton is the stable part of a StepResult
+type StepSkeleton struct {
+	Step       int      `json:"step"`
+	Action     string   `json:"action,omitempty"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	Screenshot bool     `json:"screenshot"`
+	Output     []string `json:"output,omitempty"` // keys only
+	Events     []string `json:"events,omitempty"` // types only
+}
+
+func runTestParse(args []string) {
+	fs := flag.NewFlagSet("test-parse", flag.ExitOnError)
+	update := fs.Bool("update", false, "rewrite the golden files from the current output")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: executor test-parse [--update] DIR")
+		os.Exit(2)
+	}
+
+	scripts, _ := filepath.Glob(filepath.Join(fs.Arg(0), "*.gcode"))
+	yamlScripts, _ := filepath.Glob(filepath.Join(fs.Arg(0), "*.yaml"))
+	scripts = append(scripts, yamlScripts...)
+	if len(scripts) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no .gcode or .yaml scripts in %s\n", fs.Arg(0))
+		os.Exit(2)
+	}
+	sort.Strings(scripts)
+
+	// Results come from the mock backend with its default screen, so they
+	// do not depend on this machine's display or config, and waits take
+	// no real time
+	backendName = "mock"
+	clock = newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	config = Config{}
+	configOnce.Do(func() {})
+	if err := initBackend(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	tmp, err := os.MkdirTemp("", "test-parse")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	defer os.RemoveAll(tmp)
+	screenshotsDir = tmp
+
+	failed := 0
+	for _, script := range scripts {
+		name := strings.TrimSuffix(filepath.Base(script), filepath.Ext(script))
+		problems, err := checkGolden(script, *update)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Printf("FAIL %s: %v\n", name, err)
+		case len(problems) > 0:
+			failed++
+			fmt.Printf("FAIL %s\n", name)
+			for _, p := range problems {
+				fmt.Printf("    %s\n", p)
+			}
+		case *update:
+			fmt.Printf("UPDATED %s\n", name)
+		default:
+			fmt.Printf("ok   %s\n", name)
+		}
+	}
+	fmt.Printf("%d scripts, %d failed\n", len(scripts), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkGolden compares a script's parse and result with its golden files,
+// or rewrites them with --update
+func checkGolden(script string, update bool) ([]string, error) {
+	data, err := os.ReadFile(script)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(script) == ".yaml" {
+		if data, err = scriptLines("yaml", data); err != nil {
+			return nil, err
+		}
+	}
+	base := strings.TrimSuffix(script, filepath.Ext(script))
+	actual := map[string][]byte{}
+	if actual[base+".parsed.json"], err = goldenJSON(parseScript(data)); err != nil {
+		return nil, err
+	}
+	if actual[base+".result.json"], err = goldenJSON(skeletonOf(data)); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, path := range []string{base + ".parsed.json", base + ".result.json"} {
+		if update {
+			if err := os.WriteFile(path, actual[path], 0644); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		expected, err := os.ReadFile(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v (run with --update to create it)", filepath.Base(path), err))
+			continue
+		}
+		if diff := firstDifference(expected, actual[path]); diff != "" {
+			problems = append(problems, filepath.Base(path)+": "+diff)
+		}
+	}
+	return problems, nil
+}
+
+func parseScript(data []byte) []ParsedLine {
+	parsed := []ParsedLine{}
+	var pending map[string]string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if markerLine(line) {
+			// Annotations before a parallel line are the block's
+			pending = nil
+			continue
+		}
+		if name, value, ok := parseAnnotation(line); ok {
+			if pending == nil {
+				pending = map[string]string{}
+			}
+			pending[name] = value
+			continue
+		}
+		cmd := parseCommand(line)
+		if cmd != nil {
+			cmd.Annotations = pending
+		}
+		pending = nil
+		parsed = append(parsed, ParsedLine{Line: i + 1, Command: cmd})
+	}
+	return parsed
+}
+
+func skeletonOf(data []byte) ResultSkeleton {
+	backend, _ := newMockBackend() // a fresh screen for every script
+	activeBackend = backend
+	screenshotCounter = 0
+
+	r := newRunner()
+	defer r.close()
+	skeleton := ResultSkeleton{Steps: []StepSkeleton{}}
+	var steps []*StepResult
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if step := r.runLine(scanner.Text()); step != nil {
+			steps = append(steps, step)
+		}
+	}
+	steps = append(steps, r.finish()...)
+	for _, step := range steps {
+		s := StepSkeleton{Step: step.Step, Action: step.Action, Status: step.Status, Error: step.Error, Screenshot: step.Screenshot != nil}
+		for key := range step.Output {
+			s.Output = append(s.Output, key)
+		}
+		sort.Strings(s.Output)
+		for _, e := range step.Events {
+			s.Events = append(s.Events, e.Type)
+		}
+		skeleton.Steps = append(skeleton.Steps, s)
+	}
+	skeleton.Status, skeleton.CommandsExecuted = r.result.Status, r.result.CommandsExecuted
+	return skeleton
+}
+
+func goldenJSON(v interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	return append(data, '\n'), err
+}
+
+// firstDifference describes the first line where two golden files differ
+func firstDifference(expected, actual []byte) string {
+	if bytes.Equal(expected, actual) {
+		return ""
+	}
+	want := strings.Split(string(expected), "\n")
+	got := strings.Split(string(actual), "\n")
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d: expected %q, got %q", i+1, strings.TrimSpace(w), strings.TrimSpace(g))
+		}
+	}
+	return "files differ"
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+)
+
+// find_text finds text by where it is relative to other text, for the
+// value in a column, the total under a subtotal, the label beside a
+// field, which plain OCR matches cannot tell apart from the same words
+// elsewhere:
+//
+//	find_text "Total" below="Subtotal" right_of="Qty"
+//	find_text "Edit" near="Invoice 1043"
+//
+// The relations are above, below, left_of, right_of and near (within
+// textNearDistance pixels), each naming anchor text; the text must stand
+// in every relation to some occurrence of its anchor. Of the matches the
+// nearest to its anchors wins, straying off a relation's axis counting
+// double, so text in the anchor's row or column comes first. The step's
+// output is the match's box, as click_text's.
+//
+// The words of the frame are put in a grid index, so each relation only
+// looks at the words in its part of the screen.
+
+// textRelations are the relations find_text takes, in the order they are
+// described
+var textRelations = []string{"above", "below", "left_of", "right_of", "near"}
+
+// textNearDistance is how far near reaches from its anchor's box
+const textNearDistance = 150
+
+// textIndexCell is the side of the index's grid cells, in pixels
+const textIndexCell = 64
+
+// textIndex is a grid of a frame's words, by the cells their centres are in
+type textIndex struct {
+	words  []OCRWord
+	bounds image.Rectangle
+	cells  map[image.Point][]int
+}
+
+func newTextIndex(words []OCRWord) *textIndex {
+	ix := &textIndex{words: words, cells: map[image.Point][]int{}}
+	for i, w := range words {
+		box := wordRect(w)
+		ix.bounds = ix.bounds.Union(box)
+		c := wordCentre(w)
+		cell := image.Pt(c.X/textIndexCell, c.Y/textIndexCell)
+		ix.cells[cell] = append(ix.cells[cell], i)
+	}
+	return ix
+}
+
+// within lists the words centred in r, in reading order
+func (ix *textIndex) within(r image.Rectangle) []OCRWord {
+	r = r.Intersect(ix.bounds)
+	if r.Empty() {
+		return nil
+	}
+	var found []int
+	for cy := r.Min.Y / textIndexCell; cy <= (r.Max.Y-1)/textIndexCell; cy++ {
+		for cx := r.Min.X / textIndexCell; cx <= (r.Max.X-1)/textIndexCell; cx++ {
+			for _, i := range ix.cells[image.Pt(cx, cy)] {
+				if wordCentre(ix.words[i]).In(r) {
+					found = append(found, i)
+				}
+			}
+		}
+	}
+	sort.Ints(found)
+	words := make([]OCRWord, len(found))
+	for j, i := range found {
+		words[j] = ix.words[i]
+	}
+	return words
+}
+
+// relationArea is the part of the screen in relation to anchor
+func (ix *textIndex) relationArea(relation string, anchor image.Rectangle) image.Rectangle {
+	b := ix.bounds
+	switch relation {
+	case "above":
+		return image.Rect(b.Min.X, b.Min.Y, b.Max.X, anchor.Min.Y)
+	case "below":
+		return image.Rect(b.Min.X, anchor.Max.Y, b.Max.X, b.Max.Y)
+	case "left_of":
+		return image.Rect(b.Min.X, b.Min.Y, anchor.Min.X, b.Max.Y)
+	case "right_of":
+		return image.Rect(anchor.Max.X, b.Min.Y, b.Max.X, b.Max.Y)
+	}
+	return anchor.Inset(-textNearDistance)
+}
+
+// relationDistance is how far box is from anchor for ranking matches
+func relationDistance(relation string, box, anchor image.Rectangle) float64 {
+	dx := math.Abs(float64(box.Min.X+box.Max.X-anchor.Min.X-anchor.Max.X) / 2)
+	dy := math.Abs(float64(box.Min.Y+box.Max.Y-anchor.Min.Y-anchor.Max.Y) / 2)
+	switch relation {
+	case "above", "below":
+		return dy + 2*dx
+	case "left_of", "right_of":
+		return dx + 2*dy
+	}
+	return math.Hypot(dx, dy)
+}
+
+// parseFindText reads find_text "TEXT" [RELATION="ANCHOR"]...
+func parseFindText(cmd *Command, rest string) *Command {
+	fields, err := quotedFields(rest)
+	if err != nil || len(fields) == 0 || fields[0] == "" {
+		return nil
+	}
+	cmd.Params["text"] = fields[0]
+	for _, f := range fields[1:] {
+		relation, anchor, ok := strings.Cut(f, "=")
+		if !ok || !contains(textRelations, relation) || anchor == "" {
+			return nil
+		}
+		cmd.Params[relation] = anchor
+	}
+	return cmd
+}
+
+// findTextRelative runs find_text
+func findTextRelative(cmd *Command) error {
+	text := cmd.Params["text"].(string)
+	words, err := recognizeScreen(image.Rectangle{})
+	if err == nil {
+		// Masked or redacted text neither matches nor anchors
+		words, err = redactWords(words)
+	}
+	if err != nil {
+		return err
+	}
+	ix := newTextIndex(words)
+	type candidate struct {
+		box      OCRWord
+		distance float64
+	}
+	var candidates map[image.Rectangle]*candidate
+	var described []string
+	for _, relation := range textRelations {
+		anchor, ok := cmd.Params[relation].(string)
+		if !ok {
+			continue
+		}
+		described = append(described, fmt.Sprintf("%s %q", strings.ReplaceAll(relation, "_", " "), anchor))
+		anchors := findAllText(words, anchor)
+		if len(anchors) == 0 {
+			return fmt.Errorf("anchor text %q not found on screen", anchor)
+		}
+		// The nearest of the anchors' matches, for each box
+		nearest := map[image.Rectangle]*candidate{}
+		for _, a := range anchors {
+			area := wordRect(a)
+			for _, box := range findAllText(ix.within(ix.relationArea(relation, area)), text) {
+				r := wordRect(box)
+				if r == area || (relation != "near" && r.Overlaps(area)) {
+					continue
+				}
+				d := relationDistance(relation, r, area)
+				if c, ok := nearest[r]; !ok || d < c.distance {
+					nearest[r] = &candidate{box: box, distance: d}
+				}
+			}
+		}
+		if candidates == nil {
+			candidates = nearest
+			continue
+		}
+		for r, c := range candidates {
+			if n, ok := nearest[r]; ok {
+				c.distance += n.distance
+			} else {
+				delete(candidates, r)
+			}
+		}
+	}
+	if candidates == nil {
+		candidates = map[image.Rectangle]*candidate{}
+		for _, box := range findAllText(words, text) {
+			candidates[wordRect(box)] = &candidate{box: box}
+		}
+		described = []string{"on screen"}
+	}
+	var best *candidate
+	for _, c := range candidates {
+		if best == nil || c.distance < best.distance || (c.distance == best.distance && readsBefore(c.box, best.box)) {
+			best = c
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("text %q not found %s", text, strings.Join(described, " and "))
+	}
+	cmd.Output = map[string]interface{}{"match": best.box, "matches": len(candidates)}
+	return nil
+}
+
+func readsBefore(a, b OCRWord) bool {
+	if a.Y != b.Y {
+		return a.Y < b.Y
+	}
+	return a.X < b.X
+}
+
+func wordRect(w OCRWord) image.Rectangle {
+	return image.Rect(w.X, w.Y, w.X+w.Width, w.Y+w.Height)
+}
+
+func wordCentre(w OCRWord) image.Point {
+	return image.Pt(w.X+w.Width/2, w.Y+w.Height/2)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var toolFormats = []string{"openai", "gemini"}
+
+// runTools prints function-calling definitions for every action
+func runTools(args []string) {
+	fs := flag.NewFlagSet("tools", flag.ExitOnError)
+	format := fs.String("format", "openai", "tool manifest format: openai or gemini")
+	fs.Parse(args)
+
+	var manifest interface{}
+	switch *format {
+	case "openai":
+		manifest = openAITools()
+	case "gemini":
+		manifest = geminiTools()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown --format: %s (want %s)\n", *format, strings.Join(toolFormats, " or "))
+		os.Exit(2)
+	}
+	jsonOutput, _ := json.MarshalIndent(manifest, "", "  ")
+	fmt.Println(string(jsonOutput))
+}
+
+func toolDescription(spec ActionSpec) string {
+	return spec.Description + ". Script syntax: " + spec.Syntax
+}
+
+// openAITools is the Chat Completions / Responses "tools" array
+func openAITools() []jsonObject {
+	var tools []jsonObject
+	for _, spec := range actionSpecs {
+		tools = append(tools, jsonObject{
+			"type": "function",
+			"function": jsonObject{
+				"name":        spec.Name,
+				"description": toolDescription(spec),
+				"parameters":  spec.paramsSchema(),
+			},
+		})
+	}
+	return tools
+}
+
+// geminiTools is a Gemini "tools" entry. Gemini schemas are an OpenAPI
+// subset: types are upper case, enums must be strings and there are no
+// defaults.
+func geminiTools() []jsonObject {
+	var declarations []jsonObject
+	for _, spec := range actionSpecs {
+		properties := jsonObject{}
+		required := []string{}
+		for _, p := range spec.Params {
+			prop := jsonObject{"type": strings.ToUpper(p.Type), "description": p.Description}
+			if p.Type == "array" {
+				prop["items"] = jsonObject{"type": "STRING"}
+			}
+			if len(p.Enum) > 0 {
+				if p.Type == "string" {
+					prop["enum"] = p.Enum
+				} else {
+					prop["description"] = fmt.Sprintf("%s (one of %s)", p.Description, strings.Join(p.Enum, ", "))
+				}
+			}
+			if p.Default != nil {
+				prop["description"] = fmt.Sprintf("%s (default %v)", prop["description"], p.Default)
+			}
+			properties[p.Name] = prop
+			if p.Required {
+				required = append(required, p.Name)
+			}
+		}
+		declaration := jsonObject{"name": spec.Name, "description": toolDescription(spec)}
+		if len(properties) > 0 {
+			declaration["parameters"] = jsonObject{"type": "OBJECT", "properties": properties, "required": required}
+		}
+		declarations = append(declarations, declaration)
+	}
+	return []jsonObject{{"functionDeclarations": declarations}}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// type_totp types the current one-time code of a time-based (RFC 6238)
+// authenticator, from a secret in the credentials store:
+//
+//	type_totp cred:github/totp-secret
+//
+// The secret is the base32 key an enrolment QR code carries, or the whole
+// otpauth:// URI when the service uses other digits, period or algorithm.
+// A code about to expire is not typed; the next one is awaited instead.
+
+// totpMinValid is how long a code must stay valid to be typed
+const totpMinValid = 3 * time.Second
+
+// totpKey is a parsed TOTP secret
+type totpKey struct {
+	secret []byte
+	digits int
+	period time.Duration
+	hash   func() hash.Hash
+}
+
+func parseTOTPKey(s string) (totpKey, error) {
+	key := totpKey{digits: 6, period: 30 * time.Second, hash: sha1.New}
+	secret := s
+	if strings.HasPrefix(s, "otpauth://") {
+		u, err := url.Parse(s)
+		if err != nil || u.Host != "totp" {
+			return key, fmt.Errorf("not an otpauth://totp/ URI")
+		}
+		q := u.Query()
+		secret = q.Get("secret")
+		if v := q.Get("digits"); v != "" {
+			if key.digits, err = strconv.Atoi(v); err != nil || key.digits < 6 || key.digits > 10 {
+				return key, fmt.Errorf("digits must be 6 to 10")
+			}
+		}
+		if v := q.Get("period"); v != "" {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return key, fmt.Errorf("period must be a positive number of seconds")
+			}
+			key.period = time.Duration(seconds) * time.Second
+		}
+		switch strings.ToUpper(q.Get("algorithm")) {
+		case "", "SHA1":
+		case "SHA256":
+			key.hash = sha256.New
+		case "SHA512":
+			key.hash = sha512.New
+		default:
+			return key, fmt.Errorf("unsupported algorithm %s", q.Get("algorithm"))
+		}
+	}
+	// Secrets are often shown in groups, lower case and unpadded
+	secret = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(secret))
+	raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil || len(raw) == 0 {
+		return key, fmt.Errorf("the secret is not base32")
+	}
+	key.secret = raw
+	return key, nil
+}
+
+// code is the key's code at t and how long it stays valid
+func (k totpKey) code(t time.Time) (string, time.Duration) {
+	counter := t.Unix() / int64(k.period/time.Second)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(k.hash, k.secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := uint64(1)
+	for i := 0; i < k.digits; i++ {
+		mod *= 10
+	}
+	next := time.Unix((counter+1)*int64(k.period/time.Second), 0)
+	return fmt.Sprintf("%0*d", k.digits, value%mod), next.Sub(t)
+}
+
+// typeTOTP types the current code for a credential reference
+func typeTOTP(cmd *Command) error {
+	ref := cmd.Params["credential"].(string)
+	secret, err := resolveCredential(ref)
+	if err != nil {
+		return err
+	}
+	key, err := parseTOTPKey(secret)
+	if err != nil {
+		return fmt.Errorf("%s: %v", ref, err)
+	}
+	code, valid := key.code(clock.Now())
+	if valid < totpMinValid {
+		clock.Sleep(valid)
+		code, valid = key.code(clock.Now())
+	}
+	if err := typeSecret(currentBackend(), code); err != nil {
+		return err
+	}
+	// The code itself stays out of the result
+	cmd.Output = map[string]interface{}{"digits": key.digits, "valid_seconds": int(valid / time.Second)}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// transcriptPath is set by --transcript: a Markdown file narrating the run
+// step by step, with the text that appeared on screen after each captured
+// step and every error, for reading a long session back in a postmortem.
+// Screen text costs an OCR pass per screenshot; what redaction or window
+// masking hides in frames is left out of it.
+var transcriptPath string
+
+const (
+	transcriptMaxLines = 8   // new screen lines shown per step
+	transcriptMaxWidth = 100 // runes kept of each
+)
+
+// transcript collects the steps of a run as they happen
+type transcript struct {
+	started time.Time
+	entries []*transcriptEntry
+	byStep  map[*StepResult]*transcriptEntry
+	screen  map[string]bool // lines on screen at the last read
+	ocrErr  string          // why screen text is missing, once it fails
+}
+
+type transcriptEntry struct {
+	at       time.Time
+	line     string
+	step     *StepResult
+	appeared []string
+	vanished int
+}
+
+// newTranscript returns nil unless --transcript is set; a nil transcript
+// ignores everything
+func newTranscript() *transcript {
+	if transcriptPath == "" {
+		return nil
+	}
+	return &transcript{started: clock.Now(), byStep: map[*StepResult]*transcriptEntry{}}
+}
+
+// add records a step that started at at
+func (t *transcript) add(at time.Time, line string, step *StepResult) {
+	if t == nil || step == nil {
+		return
+	}
+	e := &transcriptEntry{at: at, line: line, step: step}
+	t.entries = append(t.entries, e)
+	t.byStep[step] = e
+}
+
+// readScreen reads the screen as captured for step and keeps the lines
+// that were not there at the last read
+func (t *transcript) readScreen(step *StepResult) {
+	if t == nil || t.ocrErr != "" {
+		return
+	}
+	words, err := recognizeScreen(image.Rectangle{})
+	if err == nil {
+		// Text that is masked or redacted in frames stays out of it too
+		words, err = redactWords(words)
+	}
+	if err != nil {
+		t.ocrErr = err.Error()
+		return
+	}
+	lines := ocrLines(words)
+	now := map[string]bool{}
+	var appeared []string
+	for _, line := range lines {
+		now[line] = true
+		if !t.screen[line] {
+			appeared = append(appeared, line)
+		}
+	}
+	vanished := 0
+	for line := range t.screen {
+		if !now[line] {
+			vanished++
+		}
+	}
+	t.screen = now
+	if e := t.byStep[step]; e != nil {
+		e.appeared, e.vanished = appeared, vanished
+	}
+}
+
+// ocrLines joins words into lines of text, top to bottom
+func ocrLines(words []OCRWord) []string {
+	words = append([]OCRWord(nil), words...)
+	sort.Slice(words, func(i, j int) bool {
+		if words[i].Y != words[j].Y {
+			return words[i].Y < words[j].Y
+		}
+		return words[i].X < words[j].X
+	})
+	var lines [][]OCRWord
+	for _, w := range words {
+		mid := w.Y + w.Height/2
+		if n := len(lines); n > 0 {
+			head := lines[n-1][0]
+			if mid >= head.Y && mid < head.Y+head.Height {
+				lines[n-1] = append(lines[n-1], w)
+				continue
+			}
+		}
+		lines = append(lines, []OCRWord{w})
+	}
+	var out []string
+	for _, line := range lines {
+		sort.Slice(line, func(i, j int) bool { return line[i].X < line[j].X })
+		texts := make([]string, len(line))
+		for i, w := range line {
+			texts[i] = w.Text
+		}
+		out = append(out, strings.Join(texts, " "))
+	}
+	return out
+}
+
+// writeTranscript writes the transcript file and reports it in the result
+func writeTranscript(t *transcript, result *ExecutionResult) {
+	if t == nil {
+		return
+	}
+	if err := os.WriteFile(transcriptPath, []byte(t.markdown(result)), 0644); err != nil {
+		result.Events = append(result.Events, Event{Type: "transcript_error", Message: err.Error()})
+	}
+}
+
+func (t *transcript) markdown(result *ExecutionResult) string {
+	var b strings.Builder
+	failed := 0
+	stepErrors := map[string]bool{}
+	for _, e := range t.entries {
+		if e.step.Status == "error" {
+			failed++
+			stepErrors[fmt.Sprintf("Step %d: %s", e.step.Step, e.step.Error)] = true
+		}
+	}
+	b.WriteString("# Session transcript\n\n")
+	fmt.Fprintf(&b, "- Started: %s\n", t.started.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "- Duration: %s\n", since(t.started).Round(time.Millisecond))
+	fmt.Fprintf(&b, "- Status: %s\n", result.Status)
+	fmt.Fprintf(&b, "- Steps: %d, %d failed\n", len(t.entries), failed)
+	if t.ocrErr != "" {
+		fmt.Fprintf(&b, "- Screen text unavailable: %s\n", t.ocrErr)
+	}
+
+	// Events are told under their step, the rest at the end
+	byStep := map[int][]Event{}
+	for _, ev := range result.Events {
+		byStep[ev.Step] = append(byStep[ev.Step], ev)
+	}
+
+	b.WriteString("\n## Timeline\n")
+	for _, e := range t.entries {
+		s := e.step
+		fmt.Fprintf(&b, "\n**%s** step %d %s", transcriptClock(e.at.Sub(t.started)), s.Step, inlineCode(e.line))
+		switch s.Status {
+		case "error":
+			fmt.Fprintf(&b, " — **failed**: %s", s.Error)
+		case "skipped":
+			b.WriteString(" — skipped")
+		}
+		b.WriteString("\n")
+		if s.Step > 0 {
+			for _, ev := range byStep[s.Step] {
+				fmt.Fprintf(&b, "- %s: %s\n", ev.Type, ev.Message)
+			}
+			delete(byStep, s.Step)
+		}
+		if len(e.appeared) > 0 {
+			b.WriteString("- screen now shows:\n")
+			for i, line := range e.appeared {
+				if i == transcriptMaxLines {
+					fmt.Fprintf(&b, "  > … %d more lines\n", len(e.appeared)-i)
+					break
+				}
+				if r := []rune(line); len(r) > transcriptMaxWidth {
+					line = string(r[:transcriptMaxWidth]) + "…"
+				}
+				fmt.Fprintf(&b, "  > %s\n", line)
+			}
+		}
+		if e.vanished > 0 {
+			fmt.Fprintf(&b, "- %d lines left the screen\n", e.vanished)
+		}
+	}
+
+	var other []string
+	for _, msg := range result.Errors {
+		if !stepErrors[msg] {
+			other = append(other, msg)
+		}
+	}
+	var runEvents []Event
+	for _, ev := range result.Events {
+		if _, left := byStep[ev.Step]; left {
+			runEvents = append(runEvents, ev)
+		}
+	}
+	if len(other) > 0 || len(runEvents) > 0 {
+		b.WriteString("\n## Run\n\n")
+		for _, msg := range other {
+			fmt.Fprintf(&b, "- error: %s\n", msg)
+		}
+		for _, ev := range runEvents {
+			fmt.Fprintf(&b, "- %s: %s\n", ev.Type, ev.Message)
+		}
+	}
+	return b.String()
+}
+
+// transcriptClock formats time since the start as mm:ss.mmm
+func transcriptClock(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d.%03d", ms/60000, ms/1000%60, ms%1000)
+}
+
+// inlineCode quotes s as Markdown code, however many backticks it holds
+func inlineCode(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The tty actions talk to a serial console next to the GUI steps, for labs
+// where a board's console and a desktop are driven by the same script:
+//
+//	tty_open /dev/ttyUSB0 115200
+//	tty_send "root\r"
+//	tty_expect "Password:" 5
+//
+// Everything the device prints is read in the background from tty_open
+// until the end of the run, so nothing is lost between steps. tty_expect
+// waits for a regular expression in what arrived since its last match.
+
+const (
+	defaultBaud       = 115200
+	defaultTTYTimeout = 10.0
+	ttyBufferLimit    = 1 << 20
+	ttyTail           = 200 // characters of output quoted when an expect fails
+)
+
+// serialConsole is the open serial port and what it printed
+type serialConsole struct {
+	device string
+	f      *os.File
+
+	mu       sync.Mutex
+	buf      []byte // received since the last match
+	err      error  // why reading stopped
+	received chan struct{}
+}
+
+// runTTY is the run's serial console, if tty_open opened one
+var runTTY *serialConsole
+
+func openConsole(device string, baud int) (*serialConsole, error) {
+	f, err := openSerial(device, baud)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %v", device, err)
+	}
+	c := &serialConsole{device: device, f: f, received: make(chan struct{}, 1)}
+	go c.read()
+	return c, nil
+}
+
+func (c *serialConsole) read() {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := c.f.Read(chunk)
+		c.mu.Lock()
+		c.buf = append(c.buf, chunk[:n]...)
+		if len(c.buf) > ttyBufferLimit {
+			c.buf = append([]byte{}, c.buf[len(c.buf)-ttyBufferLimit:]...)
+		}
+		if err != nil {
+			c.err = err
+		}
+		c.mu.Unlock()
+		select {
+		case c.received <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// expect waits for pattern in the output since the last match, and
+// consumes the output up to the end of the match
+func (c *serialConsole) expect(pattern *regexp.Regexp, timeout time.Duration) (map[string]interface{}, error) {
+	deadline := clock.After(timeout)
+	for {
+		c.mu.Lock()
+		loc := pattern.FindSubmatchIndex(c.buf)
+		if loc != nil {
+			groups := []string{}
+			for i := 2; i < len(loc); i += 2 {
+				if loc[i] < 0 {
+					groups = append(groups, "")
+				} else {
+					groups = append(groups, string(c.buf[loc[i]:loc[i+1]]))
+				}
+			}
+			out := map[string]interface{}{
+				"match":  string(c.buf[loc[0]:loc[1]]),
+				"before": string(c.buf[:loc[0]]),
+				"groups": groups,
+			}
+			c.buf = append([]byte{}, c.buf[loc[1]:]...)
+			c.mu.Unlock()
+			return out, nil
+		}
+		readErr, tail := c.err, ttyTailText(c.buf)
+		c.mu.Unlock()
+		if readErr != nil {
+			return nil, fmt.Errorf("%s closed before %q was seen: %v; last output: %q", c.device, pattern, readErr, tail)
+		}
+		select {
+		case <-c.received:
+		case <-deadline:
+			return nil, fmt.Errorf("%q not seen on %s within %s; last output: %q", pattern, c.device, timeout, tail)
+		}
+	}
+}
+
+func ttyTailText(buf []byte) string {
+	s := []rune(string(buf))
+	if len(s) > ttyTail {
+		s = s[len(s)-ttyTail:]
+	}
+	return string(s)
+}
+
+func (c *serialConsole) close() {
+	if err := c.f.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: closing %s: %v\n", c.device, err)
+	}
+}
+
+// closeTTY closes the run's serial console
+func closeTTY() {
+	if runTTY != nil {
+		runTTY.close()
+		runTTY = nil
+	}
+}
+
+// parseTTY reads the tty actions:
+//
+//	tty_open DEVICE [BAUD]
+//	tty_send "TEXT"           Go escapes such as \r and \x03 apply in quotes
+//	tty_expect "REGEXP" [SECONDS]
+func parseTTY(cmd *Command, line string, parts []string) *Command {
+	rest := strings.TrimSpace(line[len(parts[0]):])
+	switch cmd.Action {
+	case "tty_open":
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil
+		}
+		baud := defaultBaud
+		if len(parts) == 3 {
+			var err error
+			if baud, err = strconv.Atoi(parts[2]); err != nil || baud <= 0 {
+				return nil
+			}
+		}
+		cmd.Params["device"] = parts[1]
+		cmd.Params["baud"] = baud
+	case "tty_send":
+		text := rest
+		if quoted, err := strconv.QuotedPrefix(rest); err == nil && quoted == rest {
+			text, _ = strconv.Unquote(quoted)
+		}
+		if text == "" {
+			return nil
+		}
+		cmd.Params["text"] = text
+	case "tty_expect":
+		// The pattern is taken as written, quotes aside, so backslashes
+		// are the regular expression's
+		pattern, after := "", ""
+		if end := closingQuote(rest); end > 0 {
+			pattern, after = rest[1:end], strings.TrimSpace(rest[end+1:])
+		} else if len(parts) >= 2 {
+			pattern, after = parts[1], strings.TrimSpace(strings.Join(parts[2:], " "))
+		}
+		timeout := defaultTTYTimeout
+		if after != "" {
+			var err error
+			if timeout, err = strconv.ParseFloat(after, 64); err != nil || timeout <= 0 {
+				return nil
+			}
+		}
+		if _, err := regexp.Compile(pattern); err != nil || pattern == "" {
+			return nil
+		}
+		cmd.Params["pattern"] = pattern
+		cmd.Params["timeout"] = timeout
+	}
+	return cmd
+}
+
+// closingQuote is the index of the quote closing the one s starts with,
+// passing over backslash escapes; -1 if there is none
+func closingQuote(s string) int {
+	if !strings.HasPrefix(s, `"`) {
+		return -1
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// runTTYAction executes a tty action
+func runTTYAction(cmd *Command) error {
+	if cmd.Action == "tty_open" {
+		closeTTY()
+		c, err := openConsole(cmd.Params["device"].(string), cmd.Params["baud"].(int))
+		if err != nil {
+			return err
+		}
+		runTTY = c
+		cmd.Output = map[string]interface{}{"device": c.device, "baud": cmd.Params["baud"]}
+		return nil
+	}
+	if runTTY == nil {
+		return fmt.Errorf("%s: no serial console, open one with tty_open", cmd.Action)
+	}
+	switch cmd.Action {
+	case "tty_send":
+		n, err := runTTY.f.Write([]byte(cmd.Params["text"].(string)))
+		cmd.Output = map[string]interface{}{"bytes": n}
+		if err != nil {
+			return fmt.Errorf("writing to %s: %v", runTTY.device, err)
+		}
+		return nil
+	case "tty_expect":
+		pattern := regexp.MustCompile(cmd.Params["pattern"].(string))
+		timeout := time.Duration(cmd.Params["timeout"].(float64) * float64(time.Second))
+		out, err := runTTY.expect(pattern, timeout)
+		cmd.Output = out
+		return err
+	}
+	return fmt.Errorf("unknown action: %s", cmd.Action)
+}
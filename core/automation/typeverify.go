@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// type_verified "TEXT" types into the focused field and reads the field
+// back, so characters dropped by a busy application fail the step instead
+// of surfacing steps later:
+//
+//	click 640 300
+//	type_verified "jane.doe@example.com"
+//
+// The field ends up holding exactly TEXT: each attempt selects all of it
+// (ctrl+a) and types over it. It is read back by copying it (ctrl+a,
+// ctrl+c), the clipboard being restored afterwards, or, with no clipboard
+// tool, from the focused element's value in the accessibility tree. A
+// mismatch is typed again, up to typeVerifyAttempts times.
+
+const typeVerifyAttempts = 3
+
+// typeVerifySettle is how long the field gets to take keystrokes and
+// copies before it is read
+const typeVerifySettle = 150 * time.Millisecond
+
+func typeVerified(cmd *Command) error {
+	attempts, via, err := enterVerified(cmd.Params["text"].(string))
+	if err != nil {
+		return err
+	}
+	cmd.Output = map[string]interface{}{"attempts": attempts, "via": via}
+	return nil
+}
+
+// fieldMismatch is the error of a field still not holding its text after
+// every attempt
+type fieldMismatch struct{ got, want string }
+
+func (e *fieldMismatch) Error() string {
+	return fmt.Sprintf("field holds %q after %d attempts, want %q", e.got, typeVerifyAttempts, e.want)
+}
+
+// enterVerified types text over the focused field's content until the
+// field reads back as text, returning the attempts taken and how it was
+// read
+func enterVerified(text string) (int, string, error) {
+	input := currentBackend()
+	saved, clipErr := readClipboard()
+	via := "clipboard"
+	if clipErr != nil {
+		via = "a11y"
+	} else {
+		defer writeClipboard(saved)
+	}
+
+	var got string
+	for attempt := 1; attempt <= typeVerifyAttempts; attempt++ {
+		if err := input.Key("ctrl+a"); err != nil {
+			return 0, via, err
+		}
+		// Typing nothing leaves the selection; delete it
+		if text == "" {
+			if err := input.Key("BackSpace"); err != nil {
+				return 0, via, err
+			}
+		}
+		if err := input.TypeText(text); err != nil {
+			return 0, via, err
+		}
+		clock.Sleep(typeVerifySettle)
+		var err error
+		if via == "clipboard" {
+			got, err = copyFocusedField()
+		} else {
+			got, err = focusedA11yValue()
+		}
+		if err != nil {
+			return 0, via, fmt.Errorf("reading the field back: %v", err)
+		}
+		if strings.ReplaceAll(got, "\r\n", "\n") == text {
+			return attempt, via, nil
+		}
+	}
+	return 0, via, &fieldMismatch{got: got, want: text}
+}
+
+// copyFocusedField copies all of the focused field's text through the
+// clipboard, leaving the caret at its end
+func copyFocusedField() (string, error) {
+	input := currentBackend()
+	if err := clearClipboard(); err != nil {
+		return "", err
+	}
+	if err := input.Key("ctrl+a"); err != nil {
+		return "", err
+	}
+	if err := input.Key("ctrl+c"); err != nil {
+		return "", err
+	}
+	clock.Sleep(typeVerifySettle)
+	text, err := readClipboard()
+	if err != nil {
+		return "", err
+	}
+	return text, input.Key("ctrl+End")
+}
+
+// focusedA11yValue is the value of the focused element that has one
+func focusedA11yValue() (string, error) {
+	elements, err := dumpA11y()
+	if err != nil {
+		return "", err
+	}
+	for _, e := range elements {
+		if e.Value != nil && contains(e.States, "focused") {
+			return *e.Value, nil
+		}
+	}
+	return "", fmt.Errorf("no focused element with a value in the accessibility tree")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// --typing-profile human types the text of type steps a key at a time at
+// a person's pace, for sites that score keystroke timing and reject the
+// even beat of the backends' fixed delay, and so long text goes as fast
+// as the configured speed allows:
+//
+//	executor --typing-profile human script.gcode
+//
+// The config's typing section sets the speed and how often a key is
+// mistyped; a typo is a neighbouring key on a QWERTY keyboard, noticed
+// after a moment and taken back with BackSpace:
+//
+//	{"typing": {"wpm": 90, "typos": 0.03}}
+//
+// Pauses between keys vary around the speed's mean, longer after spaces
+// and punctuation. Like pointer paths, they and the typos are drawn from
+// the text, so a recorded run replays with the same keys. The default,
+// fixed, hands the whole text to the backend.
+
+// TypingConfig sets the pace of --typing-profile human
+type TypingConfig struct {
+	WPM   float64 `json:"wpm"`   // words of five characters a minute (default 60)
+	Typos float64 `json:"typos"` // chance of mistyping a letter, 0 to 1 (default 0)
+}
+
+// typingProfile is set by --typing-profile
+var typingProfile = "fixed"
+
+var typingProfiles = []string{"fixed", "human"}
+
+const defaultTypingWPM = 60
+
+// qwertyNeighbours are the keys around each letter, typed as typos
+var qwertyNeighbours = map[rune]string{
+	'q': "wa", 'w': "qes", 'e': "wrd", 'r': "etf", 't': "ryg", 'y': "tuh", 'u': "yij", 'i': "uok", 'o': "ipl", 'p': "o",
+	'a': "qsz", 's': "adwx", 'd': "sfec", 'f': "dgrv", 'g': "fhtb", 'h': "gjyn", 'j': "hkum", 'k': "jli", 'l': "ko",
+	'z': "ax", 'x': "zcs", 'c': "xvd", 'v': "cbf", 'b': "vng", 'n': "bmh", 'm': "nj",
+}
+
+func checkTypingProfile() error {
+	if !contains(typingProfiles, typingProfile) {
+		return fmt.Errorf("unknown --typing-profile %q: want fixed or human", typingProfile)
+	}
+	return nil
+}
+
+func checkTypingConfig(c TypingConfig) error {
+	if c.WPM < 0 {
+		return fmt.Errorf("typing.wpm must not be negative")
+	}
+	if c.Typos < 0 || c.Typos >= 1 {
+		return fmt.Errorf("typing.typos must be from 0 to below 1, got %g", c.Typos)
+	}
+	return nil
+}
+
+// typeText types a type step's text under --typing-profile
+func typeText(cmd *Command, input Backend, text string) error {
+	if typingProfile != "human" {
+		return input.TypeText(text)
+	}
+	var c TypingConfig
+	if cfg, err := currentConfig(); err == nil {
+		c = cfg.Typing
+	}
+	if c.WPM == 0 {
+		c.WPM = defaultTypingWPM
+	}
+	typos, err := typeHuman(input, text, c)
+	if err != nil {
+		return err
+	}
+	cmd.Output = map[string]interface{}{"wpm": c.WPM, "typos": typos}
+	return nil
+}
+
+// typeHuman types text a key at a time at c's pace, returning the number
+// of typos made and corrected
+func typeHuman(input Backend, text string, c TypingConfig) (int, error) {
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+	mean := time.Duration(float64(time.Minute) / (c.WPM * 5))
+	// Exponentially spread around the mean, with no pause under half of it
+	pause := func(scale float64) {
+		clock.Sleep(time.Duration(scale * float64(mean) * (0.5 + rng.ExpFloat64()/2)))
+	}
+	typos := 0
+	for i, ch := range text {
+		if i > 0 {
+			pause(1)
+		}
+		if near, ok := qwertyNeighbours[unicode.ToLower(ch)]; ok && rng.Float64() < c.Typos {
+			wrong := rune(near[rng.Intn(len(near))])
+			if unicode.IsUpper(ch) {
+				wrong = unicode.ToUpper(wrong)
+			}
+			if err := input.TypeText(string(wrong)); err != nil {
+				return typos, err
+			}
+			// A beat to notice it, another to reach for BackSpace
+			pause(3)
+			if err := input.Key("BackSpace"); err != nil {
+				return typos, err
+			}
+			pause(1.5)
+			typos++
+		}
+		if err := input.TypeText(string(ch)); err != nil {
+			return typos, err
+		}
+		if strings.ContainsRune(" .,;:!?\n", ch) {
+			pause(0.8)
+		}
+	}
+	return typos, nil
+}
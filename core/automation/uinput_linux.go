@@ -0,0 +1,575 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// uinputBackend injects input through virtual devices it creates with the
+// kernel's uinput, below any display server: it works on every Wayland
+// compositor without ydotoold, and on X where XTEST events are filtered.
+// There is an absolute pointer, seen as a tablet spanning the screen, and
+// a keyboard whose key codes the compositor reads through its keymap, so
+// key names and typed text are for a US layout as with the qmp backend.
+// Screenshots are taken by the session's own backend. Writing to
+// /dev/uinput needs permissions that uinput-setup installs.
+//
+// The devices are named "AgentOS Executor keyboard" and "AgentOS Executor
+// pointer", so libinput list-devices, audit rules and the user can tell
+// the run's input from a person's. Each run creates them when it first
+// sends input and removes them when it ends.
+type uinputBackend struct {
+	screen Backend // takes the screenshots
+
+	mu       sync.Mutex
+	keyboard *os.File // nil outside a run's input
+	pointer  *os.File
+	size     image.Point // screen size, read from the first capture
+}
+
+const (
+	uinputPath = "/dev/uinput"
+	// uinputAbsMax is the top of the pointer's axes, scaled to the screen
+	uinputAbsMax = 0x7fff
+	// uinputKeyDelay is the pause between keys, and between press and
+	// release, as for qmp
+	uinputKeyDelay = 20 * time.Millisecond
+	// uinputSettle is how long the compositor is given to pick up new
+	// devices before they are used
+	uinputSettle = 500 * time.Millisecond
+)
+
+// uinput ioctls, from linux/uinput.h
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetRelBit  = 0x40045566
+	uiSetAbsBit  = 0x40045567
+)
+
+// Event types and codes, from linux/input-event-codes.h
+const (
+	evSyn     = 0x00
+	evKey     = 0x01
+	evRel     = 0x02
+	evAbs     = 0x03
+	synReport = 0
+	relHWheel = 0x06
+	relWheel  = 0x08
+	absX      = 0x00
+	absY      = 0x01
+)
+
+// uinputButtons are the kernel's button codes for X button numbers
+var uinputButtons = map[int]uint16{1: 0x110, 2: 0x112, 3: 0x111, 8: 0x113, 9: 0x114}
+
+// uinputWheel is the wheel axis and step for the X scroll buttons
+var uinputWheel = map[int]struct {
+	axis  uint16
+	value int32
+}{4: {relWheel, 1}, 5: {relWheel, -1}, 6: {relHWheel, -1}, 7: {relHWheel, 1}}
+
+// uinputDeviceName starts the names of the run's devices
+const uinputDeviceName = "AgentOS Executor"
+
+// newUinputBackend checks /dev/uinput can be written; the devices are
+// created by the first run that sends input
+func newUinputBackend() (Backend, error) {
+	screen := Backend(xdotoolBackend{})
+	if defaultBackend() == "wayland" {
+		screen = waylandBackend{}
+	}
+	f, err := os.OpenFile(uinputPath, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if os.IsPermission(err) || os.IsNotExist(err) {
+			return nil, fmt.Errorf("%v (run uinput-setup)", err)
+		}
+		return nil, err
+	}
+	f.Close()
+	return &uinputBackend{screen: screen}, nil
+}
+
+// open creates the devices if the run has none yet. The caller holds mu.
+func (b *uinputBackend) open() error {
+	if b.keyboard != nil {
+		return nil
+	}
+	keyboard, err := uinputDevice(uinputDeviceName+" keyboard", func(f *os.File) error {
+		if err := uinputIoctl(f, uiSetEvBit, evKey); err != nil {
+			return err
+		}
+		for code := 1; code < 256; code++ {
+			if err := uinputIoctl(f, uiSetKeyBit, code); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	abs := map[int]int32{absX: uinputAbsMax, absY: uinputAbsMax}
+	pointer, err := uinputDevice(uinputDeviceName+" pointer", func(f *os.File) error {
+		for _, bit := range []struct{ req, value int }{
+			{uiSetEvBit, evKey}, {uiSetEvBit, evRel}, {uiSetEvBit, evAbs},
+			{uiSetRelBit, relWheel}, {uiSetRelBit, relHWheel},
+			{uiSetAbsBit, absX}, {uiSetAbsBit, absY},
+		} {
+			if err := uinputIoctl(f, bit.req, bit.value); err != nil {
+				return err
+			}
+		}
+		for _, code := range uinputButtons {
+			if err := uinputIoctl(f, uiSetKeyBit, int(code)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, abs)
+	if err != nil {
+		removeUinputDevice(keyboard)
+		return err
+	}
+	time.Sleep(uinputSettle)
+	b.keyboard, b.pointer = keyboard, pointer
+	return nil
+}
+
+// closeRun removes the run's devices. Keys and buttons still held are
+// released with them.
+func (b *uinputBackend) closeRun() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keyboard == nil {
+		return
+	}
+	removeUinputDevice(b.keyboard)
+	removeUinputDevice(b.pointer)
+	b.keyboard, b.pointer = nil, nil
+}
+
+func removeUinputDevice(f *os.File) {
+	uinputIoctl(f, uiDevDestroy, 0)
+	f.Close()
+}
+
+// uinputDevice creates a virtual device with the capabilities setup
+// enables and the given absolute axis maxima
+func uinputDevice(name string, setup func(f *os.File) error, absMax map[int]int32) (*os.File, error) {
+	f, err := os.OpenFile(uinputPath, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if os.IsPermission(err) || os.IsNotExist(err) {
+			return nil, fmt.Errorf("%v (run uinput-setup)", err)
+		}
+		return nil, err
+	}
+	if err := setup(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("setting up %s: %v", name, err)
+	}
+	// struct uinput_user_dev: the name, the device ids and the ranges of
+	// the absolute axes
+	dev := make([]byte, 80+8+4+4*64*4)
+	copy(dev[:79], name)
+	binary.LittleEndian.PutUint16(dev[80:], 0x06) // BUS_VIRTUAL
+	binary.LittleEndian.PutUint16(dev[82:], 0x4147)
+	binary.LittleEndian.PutUint16(dev[84:], 0x0001)
+	binary.LittleEndian.PutUint16(dev[86:], 1)
+	for axis, max := range absMax {
+		binary.LittleEndian.PutUint32(dev[92+4*axis:], uint32(max))
+	}
+	if _, err := f.Write(dev); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("setting up %s: %v", name, err)
+	}
+	if err := uinputIoctl(f, uiDevCreate, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("creating %s: %v", name, err)
+	}
+	return f, nil
+}
+
+func uinputIoctl(f *os.File, req, value int) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(req), uintptr(value)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// inputEvent is a struct input_event, its time left for the kernel to set
+type inputEvent struct {
+	kind, code uint16
+	value      int32
+}
+
+// emit writes events to a device followed by a report that they belong
+// together
+func emit(f *os.File, events ...inputEvent) error {
+	timeval := 2 * strconv.IntSize / 8
+	var buf []byte
+	for _, e := range append(events, inputEvent{evSyn, synReport, 0}) {
+		ev := make([]byte, timeval+8)
+		binary.LittleEndian.PutUint16(ev[timeval:], e.kind)
+		binary.LittleEndian.PutUint16(ev[timeval+2:], e.code)
+		binary.LittleEndian.PutUint32(ev[timeval+4:], uint32(e.value))
+		buf = append(buf, ev...)
+	}
+	_, err := f.Write(buf)
+	return err
+}
+
+func (*uinputBackend) Name() string { return "uinput" }
+
+// cursorPosition asks the screen's backend, the pointer being absolute
+func (b *uinputBackend) cursorPosition() (int, int, error) {
+	l, ok := b.screen.(cursorLocator)
+	if !ok {
+		return 0, 0, fmt.Errorf("backend %s cannot report the cursor position", b.screen.Name())
+	}
+	return l.cursorPosition()
+}
+
+// MoveMouse scales the position to the pointer's axes, which the
+// compositor maps onto the whole screen
+func (b *uinputBackend) MoveMouse(x, y int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.open(); err != nil {
+		return err
+	}
+	if b.size == (image.Point{}) {
+		img, err := b.screen.Capture()
+		if err != nil {
+			return fmt.Errorf("reading the screen size: %v", err)
+		}
+		b.size = img.Bounds().Size()
+		releaseFrame(img)
+	}
+	w, h := b.size.X, b.size.Y
+	if w < 2 || h < 2 {
+		return fmt.Errorf("unknown screen size")
+	}
+	return emit(b.pointer,
+		inputEvent{evAbs, absX, int32(clampInt(x, 0, w-1) * uinputAbsMax / (w - 1))},
+		inputEvent{evAbs, absY, int32(clampInt(y, 0, h-1) * uinputAbsMax / (h - 1))})
+}
+
+func uinputButton(button int) (uint16, error) {
+	code, ok := uinputButtons[button]
+	if !ok {
+		return 0, fmt.Errorf("button %d cannot be sent with uinput", button)
+	}
+	return code, nil
+}
+
+func (b *uinputBackend) MouseDown(button int) error {
+	code, err := uinputButton(button)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.open(); err != nil {
+		return err
+	}
+	return emit(b.pointer, inputEvent{evKey, code, 1})
+}
+
+func (b *uinputBackend) MouseUp(button int) error {
+	code, err := uinputButton(button)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.open(); err != nil {
+		return err
+	}
+	return emit(b.pointer, inputEvent{evKey, code, 0})
+}
+
+// Click turns buttons 4 to 7 into wheel steps, which are not buttons to
+// the kernel
+func (b *uinputBackend) Click(button, count int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.open(); err != nil {
+		return err
+	}
+	if wheel, ok := uinputWheel[button]; ok {
+		for i := 0; i < count; i++ {
+			if err := emit(b.pointer, inputEvent{evRel, wheel.axis, wheel.value}); err != nil {
+				return err
+			}
+			time.Sleep(uinputKeyDelay)
+		}
+		return nil
+	}
+	code, err := uinputButton(button)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		if err := emit(b.pointer, inputEvent{evKey, code, 1}); err != nil {
+			return err
+		}
+		time.Sleep(uinputKeyDelay)
+		if err := emit(b.pointer, inputEvent{evKey, code, 0}); err != nil {
+			return err
+		}
+		time.Sleep(uinputKeyDelay)
+	}
+	return nil
+}
+
+// press holds the keys down in order, then releases them in reverse
+func (b *uinputBackend) press(codes []int) error {
+	if err := b.open(); err != nil {
+		return err
+	}
+	for _, c := range codes {
+		if err := emit(b.keyboard, inputEvent{evKey, uint16(c), 1}); err != nil {
+			return err
+		}
+	}
+	time.Sleep(uinputKeyDelay)
+	for i := len(codes) - 1; i >= 0; i-- {
+		if err := emit(b.keyboard, inputEvent{evKey, uint16(codes[i]), 0}); err != nil {
+			return err
+		}
+	}
+	time.Sleep(uinputKeyDelay)
+	return nil
+}
+
+func (b *uinputBackend) TypeText(text string) error {
+	var keys [][]int
+	for _, r := range text {
+		qcodes, ok := qmpTypeable(r)
+		if !ok {
+			return fmt.Errorf("cannot type %q with uinput's US layout", r)
+		}
+		var codes []int
+		for _, q := range qcodes {
+			codes = append(codes, evdevCodes[q])
+		}
+		keys = append(keys, codes)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, codes := range keys {
+		if err := b.press(codes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Key takes xdotool key syntax: combinations like ctrl+alt+Delete,
+// separated by spaces
+func (b *uinputBackend) Key(keys string) error {
+	var combos [][]int
+	for _, combo := range strings.Fields(keys) {
+		var codes []int
+		for _, name := range strings.Split(combo, "+") {
+			c, ok := evdevKeys(name)
+			if !ok {
+				return fmt.Errorf("unknown key %q", name)
+			}
+			codes = append(codes, c...)
+		}
+		combos = append(combos, codes)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, codes := range combos {
+		if err := b.press(codes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *uinputBackend) KeyDown(key string) error { return b.holdKey(key, 1) }
+
+func (b *uinputBackend) KeyUp(key string) error { return b.holdKey(key, 0) }
+
+// holdKey sets the key codes of a key to value, releasing in reverse
+func (b *uinputBackend) holdKey(key string, value int32) error {
+	codes, ok := evdevKeys(key)
+	if !ok {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.open(); err != nil {
+		return err
+	}
+	for i := range codes {
+		c := codes[i]
+		if value == 0 {
+			c = codes[len(codes)-1-i]
+		}
+		if err := emit(b.keyboard, inputEvent{evKey, uint16(c), value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inputProvenance lists the run's devices, as long as they exist
+func (b *uinputBackend) inputProvenance() map[string]interface{} {
+	return map[string]interface{}{"mechanism": "uinput", "devices": evdevDevices(uinputDeviceName + " ")}
+}
+
+func (b *uinputBackend) Capture() (image.Image, error) {
+	return b.screen.Capture()
+}
+
+func (b *uinputBackend) captureFile(path string) error {
+	if fc, ok := b.screen.(fileCapturer); ok {
+		return fc.captureFile(path)
+	}
+	img, err := b.Capture()
+	if err != nil {
+		return err
+	}
+	defer releaseFrame(img)
+	return writePNG(path, img)
+}
+
+// What uinput-setup installs
+const (
+	uinputRulePath   = "/etc/udev/rules.d/60-agentos-uinput.rules"
+	uinputRule       = `KERNEL=="uinput", SUBSYSTEM=="misc", GROUP="input", MODE="0660", OPTIONS+="static_node=uinput"` + "\n"
+	uinputModulePath = "/etc/modules-load.d/agentos-uinput.conf"
+	uinputGroup      = "input"
+)
+
+// runUinputSetup gives the input group access to /dev/uinput, loads the
+// module at boot and adds the invoking user to the group. Without
+// --install it only reports what is missing.
+func runUinputSetup(args []string) {
+	fs := flag.NewFlagSet("uinput-setup", flag.ExitOnError)
+	install := fs.Bool("install", false, "install the udev rule and module config and add the user to the input group (needs root)")
+	fs.Parse(args)
+
+	// Under sudo the user to set up is the one who ran it
+	name := os.Getenv("SUDO_USER")
+	if name == "" {
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+	}
+	result := &SelftestResult{Status: "pass"}
+	if *install {
+		uinputInstall(result, name)
+	}
+	uinputCheck(result, name)
+
+	jsonOutput, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(jsonOutput))
+	if result.Status != "pass" {
+		os.Exit(1)
+	}
+}
+
+func uinputInstall(result *SelftestResult, name string) {
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"install:module", func() error {
+			if err := os.WriteFile(uinputModulePath, []byte("uinput\n"), 0644); err != nil {
+				return err
+			}
+			return runSetupCommand("modprobe", "uinput")
+		}},
+		{"install:udev_rule", func() error {
+			if err := os.WriteFile(uinputRulePath, []byte(uinputRule), 0644); err != nil {
+				return err
+			}
+			if err := runSetupCommand("udevadm", "control", "--reload-rules"); err != nil {
+				return err
+			}
+			return runSetupCommand("udevadm", "trigger", "--name-match=uinput")
+		}},
+		{"install:group", func() error {
+			if name == "" || name == "root" {
+				return nil
+			}
+			return runSetupCommand("usermod", "-aG", uinputGroup, name)
+		}},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			result.add(step.name, "fail", err.Error())
+		} else {
+			result.add(step.name, "pass", "")
+		}
+	}
+}
+
+func runSetupCommand(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// uinputCheck reports whether the device exists, whether its group lets
+// the user in, and whether this process can open it now; a group just
+// joined only counts from the next login
+func uinputCheck(result *SelftestResult, name string) {
+	info, err := os.Stat(uinputPath)
+	if err != nil {
+		result.add("device", "fail", fmt.Sprintf("%v (modprobe uinput)", err))
+		return
+	}
+	result.add("device", "pass", uinputPath)
+
+	st, _ := info.Sys().(*syscall.Stat_t)
+	group, err := user.LookupGroup(uinputGroup)
+	switch {
+	case err != nil:
+		result.add("group", "fail", err.Error())
+	case st == nil || strconv.Itoa(int(st.Gid)) != group.Gid || info.Mode().Perm()&0060 != 0060:
+		result.add("group", "fail", fmt.Sprintf("%s is %v and not writable by group %s (run uinput-setup --install as root)", uinputPath, info.Mode().Perm(), uinputGroup))
+	case !userInGroup(name, group.Gid):
+		result.add("group", "fail", fmt.Sprintf("%s is not in group %s (run uinput-setup --install as root)", name, uinputGroup))
+	default:
+		result.add("group", "pass", fmt.Sprintf("%s in group %s", name, uinputGroup))
+	}
+
+	if f, err := os.OpenFile(uinputPath, os.O_WRONLY, 0); err != nil {
+		result.add("access", "fail", fmt.Sprintf("%v (log in again after joining group %s)", err, uinputGroup))
+	} else {
+		f.Close()
+		result.add("access", "pass", "")
+	}
+}
+
+func userInGroup(name, gid string) bool {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return false
+	}
+	gids, _ := u.GroupIds()
+	return contains(gids, gid)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func newUinputBackend() (Backend, error) {
+	return nil, fmt.Errorf("the uinput backend is only available on Linux")
+}
+
+func runUinputSetup(args []string) {
+	fmt.Fprintln(os.Stderr, "Error: uinput-setup is only available on Linux")
+	os.Exit(2)
+}
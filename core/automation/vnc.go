@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"crypto/des"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VNCConfig points the vnc backend at a remote desktop
+type VNCConfig struct {
+	// Address is the server as vncviewer takes it: HOST:DISPLAY for
+	// display numbers below 100 (HOST:1 is port 5901), else HOST:PORT;
+	// a bare HOST is display 0
+	Address string `json:"address"`
+	// Password is a cred:SERVICE/FIELD reference to the VNC password, for
+	// servers that ask for one
+	Password string `json:"password"`
+}
+
+// vncBackend drives a remote machine through its VNC server over RFB:
+// input goes in as pointer and key events and screenshots are full
+// framebuffer updates, so nothing is installed on the remote side. The
+// connection is shared, leaving other viewers connected. Keys are sent as
+// keysyms, which the server maps to its own keyboard.
+type vncBackend struct {
+	mu sync.Mutex
+
+	conn net.Conn
+	r    *bufio.Reader
+	size image.Point // framebuffer size from ServerInit
+
+	pos     image.Point // where the pointer was last sent
+	buttons byte        // buttons held, as RFB's button mask
+}
+
+// vncKeyDelay is how long keys and buttons are held, and the pause between
+// typed characters
+const vncKeyDelay = 10 * time.Millisecond
+
+// vncPixelFormat is the format asked of the server: 32 bits per pixel,
+// little-endian, red in the lowest byte, so a raw rectangle's rows are
+// already RGBA but for the padding byte
+var vncPixelFormat = []byte{32, 24, 0, 1, 0, 255, 0, 255, 0, 255, 0, 8, 16, 0, 0, 0}
+
+// RFB security types
+const (
+	rfbSecurityNone = 1
+	rfbSecurityVNC  = 2
+)
+
+func newVNCBackend() (Backend, error) {
+	cfg, err := currentConfig()
+	if err != nil {
+		return nil, err
+	}
+	v := cfg.VNC
+	if v.Address == "" {
+		return nil, fmt.Errorf("set vnc.address in the config")
+	}
+	address, err := vncAddress(v.Address)
+	if err != nil {
+		return nil, err
+	}
+	password := ""
+	if v.Password != "" {
+		if password, err = resolveCredential(v.Password); err != nil {
+			return nil, fmt.Errorf("vnc.password: %v", err)
+		}
+	}
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %v", v.Address, err)
+	}
+	b := &vncBackend{conn: conn, r: bufio.NewReaderSize(conn, 64<<10)}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err := b.handshake(password); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %v", v.Address, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return b, nil
+}
+
+// vncAddress turns HOST:DISPLAY or HOST:PORT into a dialable address
+func vncAddress(s string) (string, error) {
+	if !strings.Contains(s, ":") {
+		return net.JoinHostPort(s, "5900"), nil
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", fmt.Errorf("vnc.address %q: want HOST:DISPLAY or HOST:PORT", s)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("vnc.address %q: bad display or port %q", s, port)
+	}
+	if n < 100 {
+		n += 5900
+	}
+	return net.JoinHostPort(host, strconv.Itoa(n)), nil
+}
+
+// handshake negotiates the protocol version and security, then shares the
+// desktop and sets the pixel format and encoding captures use
+func (b *vncBackend) handshake(password string) error {
+	version := make([]byte, 12)
+	if _, err := io.ReadFull(b.r, version); err != nil {
+		return fmt.Errorf("no RFB greeting: %v", err)
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(version), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 {
+		return fmt.Errorf("not an RFB 3.x server: %q", version)
+	}
+	// 3.3 is what servers without the later security negotiation speak;
+	// anything past 3.8 is answered as 3.8
+	if minor >= 8 {
+		minor = 8
+	} else if minor != 7 {
+		minor = 3
+	}
+	if _, err := fmt.Fprintf(b.conn, "RFB 003.%03d\n", minor); err != nil {
+		return err
+	}
+
+	var security uint32
+	if minor == 3 {
+		if err := binary.Read(b.r, binary.BigEndian, &security); err != nil {
+			return err
+		}
+		if security == 0 {
+			return b.refused("connection refused")
+		}
+	} else {
+		n, err := b.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return b.refused("connection refused")
+		}
+		offered := make([]byte, n)
+		if _, err := io.ReadFull(b.r, offered); err != nil {
+			return err
+		}
+		// VNC authentication if there is a password for it, else None
+		for _, t := range offered {
+			switch {
+			case t == rfbSecurityVNC && (password != "" || security == 0):
+				security = rfbSecurityVNC
+			case t == rfbSecurityNone && (password == "" || security == 0):
+				security = rfbSecurityNone
+			}
+		}
+		if security == 0 {
+			return fmt.Errorf("no supported security type among %v (None and VNC authentication are)", offered)
+		}
+		if _, err := b.conn.Write([]byte{byte(security)}); err != nil {
+			return err
+		}
+	}
+	switch security {
+	case rfbSecurityNone:
+	case rfbSecurityVNC:
+		if password == "" {
+			return fmt.Errorf("the server wants a password; set vnc.password")
+		}
+		challenge := make([]byte, 16)
+		if _, err := io.ReadFull(b.r, challenge); err != nil {
+			return err
+		}
+		if _, err := b.conn.Write(vncAuthResponse(password, challenge)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported security type %d", security)
+	}
+	// 3.8 reports the outcome of every security type, older versions only
+	// of VNC authentication
+	if minor == 8 || security == rfbSecurityVNC {
+		var result uint32
+		if err := binary.Read(b.r, binary.BigEndian, &result); err != nil {
+			return err
+		}
+		if result != 0 {
+			if minor == 8 {
+				return b.refused("authentication failed")
+			}
+			return fmt.Errorf("authentication failed")
+		}
+	}
+
+	if _, err := b.conn.Write([]byte{1}); err != nil { // ClientInit, shared
+		return err
+	}
+	var init struct {
+		Width, Height uint16
+		Format        [16]byte
+		NameLength    uint32
+	}
+	if err := binary.Read(b.r, binary.BigEndian, &init); err != nil {
+		return fmt.Errorf("no ServerInit: %v", err)
+	}
+	if _, err := b.r.Discard(int(init.NameLength)); err != nil {
+		return err
+	}
+	b.size = image.Pt(int(init.Width), int(init.Height))
+
+	setFormat := append([]byte{0, 0, 0, 0}, vncPixelFormat...)
+	setEncodings := []byte{2, 0, 0, 1, 0, 0, 0, 0} // Raw only
+	_, err := b.conn.Write(append(setFormat, setEncodings...))
+	return err
+}
+
+// refused reads the reason string a server sends with a failure
+func (b *vncBackend) refused(what string) error {
+	var n uint32
+	if err := binary.Read(b.r, binary.BigEndian, &n); err != nil || n > 4096 {
+		return fmt.Errorf("%s", what)
+	}
+	reason := make([]byte, n)
+	if _, err := io.ReadFull(b.r, reason); err != nil {
+		return fmt.Errorf("%s", what)
+	}
+	return fmt.Errorf("%s: %s", what, reason)
+}
+
+// vncAuthResponse encrypts the challenge with DES, keyed by the first
+// eight bytes of the password with each byte's bits reversed, as VNC
+// authentication has it
+func vncAuthResponse(password string, challenge []byte) []byte {
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, c := range key {
+		key[i] = bits.Reverse8(c)
+	}
+	cipher, _ := des.NewCipher(key) // cannot fail with an 8-byte key
+	response := make([]byte, 16)
+	cipher.Encrypt(response[:8], challenge[:8])
+	cipher.Encrypt(response[8:], challenge[8:])
+	return response
+}
+
+func (b *vncBackend) Name() string { return "vnc" }
+
+// pointer sends the pointer position and the buttons held
+func (b *vncBackend) pointer() error {
+	msg := []byte{5, b.buttons, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(msg[2:], uint16(b.pos.X))
+	binary.BigEndian.PutUint16(msg[4:], uint16(b.pos.Y))
+	_, err := b.conn.Write(msg)
+	return err
+}
+
+func (b *vncBackend) MoveMouse(x, y int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pos = image.Pt(clampInt(x, 0, b.size.X-1), clampInt(y, 0, b.size.Y-1))
+	return b.pointer()
+}
+
+// vncButton is a button's bit in RFB's button mask, which has buttons 1
+// to 8, the wheel being 4 to 7
+func vncButton(button int) (byte, error) {
+	if button < 1 || button > 8 {
+		return 0, fmt.Errorf("button %d cannot be sent over VNC", button)
+	}
+	return 1 << (button - 1), nil
+}
+
+func (b *vncBackend) MouseDown(button int) error {
+	bit, err := vncButton(button)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buttons |= bit
+	return b.pointer()
+}
+
+func (b *vncBackend) MouseUp(button int) error {
+	bit, err := vncButton(button)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buttons &^= bit
+	return b.pointer()
+}
+
+func (b *vncBackend) Click(button, count int) error {
+	bit, err := vncButton(button)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := 0; i < count; i++ {
+		b.buttons |= bit
+		if err := b.pointer(); err != nil {
+			return err
+		}
+		time.Sleep(vncKeyDelay)
+		b.buttons &^= bit
+		if err := b.pointer(); err != nil {
+			return err
+		}
+		time.Sleep(vncKeyDelay)
+	}
+	return nil
+}
+
+// key sends a KeyEvent
+func (b *vncBackend) key(keysym uint32, down bool) error {
+	msg := []byte{4, 0, 0, 0, 0, 0, 0, 0}
+	if down {
+		msg[1] = 1
+	}
+	binary.BigEndian.PutUint32(msg[4:], keysym)
+	_, err := b.conn.Write(msg)
+	return err
+}
+
+// press holds the keys down in order, then releases them in reverse
+func (b *vncBackend) press(keysyms []uint32) error {
+	for _, k := range keysyms {
+		if err := b.key(k, true); err != nil {
+			return err
+		}
+	}
+	time.Sleep(vncKeyDelay)
+	for i := len(keysyms) - 1; i >= 0; i-- {
+		if err := b.key(keysyms[i], false); err != nil {
+			return err
+		}
+	}
+	time.Sleep(vncKeyDelay)
+	return nil
+}
+
+// TypeText sends each character's keysym; the server picks the key and
+// any Shift it needs
+func (b *vncBackend) TypeText(text string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, r := range text {
+		if err := b.press([]uint32{runeKeysym(r)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Key takes xdotool key syntax: combinations like ctrl+alt+Delete,
+// separated by spaces
+func (b *vncBackend) Key(keys string) error {
+	var combos [][]uint32
+	for _, combo := range strings.Fields(keys) {
+		var keysyms []uint32
+		for _, name := range strings.Split(combo, "+") {
+			k, ok := xKeysym(name)
+			if !ok {
+				return fmt.Errorf("unknown key %q", name)
+			}
+			keysyms = append(keysyms, k)
+		}
+		combos = append(combos, keysyms)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, keysyms := range combos {
+		if err := b.press(keysyms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *vncBackend) KeyDown(key string) error { return b.holdKey(key, true) }
+
+func (b *vncBackend) KeyUp(key string) error { return b.holdKey(key, false) }
+
+func (b *vncBackend) holdKey(key string, down bool) error {
+	k, ok := xKeysym(key)
+	if !ok {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.key(k, down)
+}
+
+// Capture asks for the whole framebuffer and reads the update, skipping
+// the bells and clipboard text the server may send first
+func (b *vncBackend) Capture() (image.Image, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer b.conn.SetDeadline(time.Time{})
+	req := []byte{3, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(req[6:], uint16(b.size.X))
+	binary.BigEndian.PutUint16(req[8:], uint16(b.size.Y))
+	if _, err := b.conn.Write(req); err != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", err)
+	}
+	for {
+		kind, err := b.r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("screen capture failed: %v", err)
+		}
+		switch kind {
+		case 0: // FramebufferUpdate
+			img, err := b.readUpdate()
+			if err != nil {
+				return nil, fmt.Errorf("screen capture failed: %v", err)
+			}
+			return img, nil
+		case 1: // SetColourMapEntries, unused with true colour
+			var h struct {
+				Pad      byte
+				First, N uint16
+			}
+			if err := binary.Read(b.r, binary.BigEndian, &h); err != nil {
+				return nil, err
+			}
+			if _, err := b.r.Discard(6 * int(h.N)); err != nil {
+				return nil, err
+			}
+		case 2: // Bell
+		case 3: // ServerCutText
+			var n uint32
+			if _, err := b.r.Discard(3); err != nil {
+				return nil, err
+			}
+			if err := binary.Read(b.r, binary.BigEndian, &n); err != nil {
+				return nil, err
+			}
+			if _, err := b.r.Discard(int(n)); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("screen capture failed: unexpected server message %d", kind)
+		}
+	}
+}
+
+// readUpdate reads the raw rectangles of a FramebufferUpdate into a frame
+func (b *vncBackend) readUpdate() (image.Image, error) {
+	var h struct {
+		Pad   byte
+		Rects uint16
+	}
+	if err := binary.Read(b.r, binary.BigEndian, &h); err != nil {
+		return nil, err
+	}
+	img := newFrame(image.Rect(0, 0, b.size.X, b.size.Y))
+	for i := 0; i < int(h.Rects); i++ {
+		var rect struct {
+			X, Y, W, H uint16
+			Encoding   int32
+		}
+		if err := binary.Read(b.r, binary.BigEndian, &rect); err != nil {
+			releaseFrame(img)
+			return nil, err
+		}
+		if rect.Encoding != 0 {
+			releaseFrame(img)
+			return nil, fmt.Errorf("server sent encoding %d, not the raw encoding asked for", rect.Encoding)
+		}
+		r := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.W), int(rect.Y)+int(rect.H))
+		if !r.In(img.Rect) {
+			releaseFrame(img)
+			return nil, fmt.Errorf("rectangle %v is outside the %dx%d framebuffer", r, b.size.X, b.size.Y)
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)]
+			if _, err := io.ReadFull(b.r, row); err != nil {
+				releaseFrame(img)
+				return nil, err
+			}
+			for x := 3; x < len(row); x += 4 {
+				row[x] = 255
+			}
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// watch reads script lines from a named pipe and runs them as they
+// arrive, writing a JSON line per step to a second pipe, for shell agents
+// that want a persistent channel without a network:
+//
+//	executor_binary watch --in /tmp/agentos.in --out /tmp/agentos.out &
+//	cat /tmp/agentos.out &
+//	echo 'click 1 s' > /tmp/agentos.in
+//
+// The pipes are created if missing; existing ones must be this user's and
+// writable by no one else. Writers may come and go; all lines are
+// steps of one run, so a rollback block registered by one writer runs when
+// a later step fails. When the run aborts, and when watch is stopped with
+// SIGINT or SIGTERM, the run's ExecutionResult line (the one with
+// commands_executed) follows its steps; an aborted run is followed by a
+// new one. Pipes watch created are removed when it stops.
+
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	inPath := fs.String("in", "", "named pipe to read script lines from")
+	outPath := fs.String("out", "", "named pipe to write step results to")
+	serviceFlags(fs)
+	fs.Parse(args)
+	if *inPath == "" || *outPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: watch needs --in and --out")
+		os.Exit(2)
+	}
+
+	checkServiceSetup()
+	if err := initBackend(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	os.MkdirAll(screenshotsDir, 0755)
+	var created []string
+	for _, path := range []string{*inPath, *outPath} {
+		made, err := ensureFIFO(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		if made {
+			created = append(created, path)
+		}
+	}
+	defer func() {
+		for _, path := range created {
+			os.Remove(path)
+		}
+	}()
+	// Opened for reading too, so it does not wait for a reader and
+	// results queue in the pipe until one comes
+	out, err := os.OpenFile(*outPath, os.O_RDWR, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer out.Close()
+
+	messages := make(chan []byte)
+	go readFIFO(*inPath, messages)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	enc := json.NewEncoder(out)
+	send := func(steps []*StepResult) error {
+		for _, step := range steps {
+			if err := enc.Encode(step); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Watching %s, results to %s\n", *inPath, *outPath)
+	run := newRunner()
+	for {
+		select {
+		case message := <-messages:
+			ended, err := runSessionMessage(run, message, send)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: writing %s: %v\n", *outPath, err)
+				run.close()
+				os.Exit(1)
+			}
+			if ended {
+				endWatchRun(run, enc)
+				run = newRunner()
+			}
+		case sig := <-stop:
+			run.abort(fmt.Sprintf("interrupted by %v", sig))
+			send(run.finish())
+			endWatchRun(run, enc)
+			return
+		}
+	}
+}
+
+// endWatchRun writes a finished run's result and closes it
+func endWatchRun(run *runner, enc *json.Encoder) {
+	run.close()
+	enc.Encode(run.result)
+	savePortableResult(run.result)
+}
+
+// ensureFIFO creates a named pipe at path unless one is there, and says
+// whether it did
+func ensureFIFO(path string) (bool, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return false, err
+		}
+		return true, makeFIFO(path)
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return false, fmt.Errorf("%s exists and is not a named pipe", path)
+	}
+	// Whoever can write to the pipe runs scripts as us
+	if err := checkOwnWritable(path, info); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// readFIFO sends what writers put in the pipe, reopening it when the last
+// writer closes. Lines that arrive together are sent together, so a
+// script written in one go is run like a /ws message.
+func readFIFO(path string, messages chan<- []byte) {
+	for {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		reader := bufio.NewReader(f)
+		for {
+			var lines []string
+			line, err := reader.ReadString('\n')
+			lines = append(lines, line)
+			for err == nil && reader.Buffered() > 0 {
+				line, err = reader.ReadString('\n')
+				lines = append(lines, line)
+			}
+			if message := strings.Join(lines, ""); strings.TrimSpace(message) != "" {
+				messages <- []byte(message)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: reading %s: %v\n", path, err)
+				os.Exit(1)
+			}
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// waylandBackend drives a Wayland session, where xdotool only reaches
+// XWayland windows. Pointer and keys go through ydotool, which writes to
+// the kernel's uinput and needs its ydotoold daemon running; text is typed
+// with wtype when it is installed, as it sends any character through the
+// compositor's virtual keyboard, and with ydotool otherwise. grim takes the
+// screenshots. It is the default backend when XDG_SESSION_TYPE is wayland.
+type waylandBackend struct {
+	typer string // wtype or ydotool
+}
+
+// waylandKeyDelay is the delay between typed keys, in milliseconds, as for
+// xdotool type
+const waylandKeyDelay = "50"
+
+func newWaylandBackend() (Backend, error) {
+	for _, tool := range []string{"ydotool", "grim"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, fmt.Errorf("%s not found (install ydotool and grim, and start ydotoold)", tool)
+		}
+	}
+	b := waylandBackend{typer: "ydotool"}
+	if _, err := exec.LookPath("wtype"); err == nil && os.Getenv("WAYLAND_DISPLAY") != "" {
+		b.typer = "wtype"
+	}
+	return b, nil
+}
+
+// defaultBackend is the backend used without --backend
+func defaultBackend() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "macos"
+	case "windows":
+		return "windows"
+	}
+	if os.Getenv("XDG_SESSION_TYPE") == "wayland" {
+		return "wayland"
+	}
+	return "x11"
+}
+
+func runYdotool(args ...string) error {
+	cmd := exec.Command("ydotool", args...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ydotool %s: %v", args[0], err)
+	}
+	return nil
+}
+
+func (waylandBackend) Name() string { return "wayland" }
+
+func (waylandBackend) MoveMouse(x, y int) error {
+	return runYdotool("mousemove", "--absolute", "-x", strconv.Itoa(x), "-y", strconv.Itoa(y))
+}
+
+// ydotoolButtons are ydotool's button numbers for X button numbers
+var ydotoolButtons = map[int]int{1: 0, 2: 2, 3: 1, 8: 3, 9: 4}
+
+// ydotool click codes carry the button in the low bits, 0x40 to press and
+// 0x80 to release
+const (
+	ydotoolDown = 0x40
+	ydotoolUp   = 0x80
+)
+
+func ydotoolButton(button int) (int, error) {
+	b, ok := ydotoolButtons[button]
+	if !ok {
+		return 0, fmt.Errorf("button %d cannot be sent with ydotool", button)
+	}
+	return b, nil
+}
+
+func (waylandBackend) MouseDown(button int) error {
+	b, err := ydotoolButton(button)
+	if err != nil {
+		return err
+	}
+	return runYdotool("click", fmt.Sprintf("0x%02X", b|ydotoolDown))
+}
+
+func (waylandBackend) MouseUp(button int) error {
+	b, err := ydotoolButton(button)
+	if err != nil {
+		return err
+	}
+	return runYdotool("click", fmt.Sprintf("0x%02X", b|ydotoolUp))
+}
+
+// Click scrolls with the wheel for buttons 4 and 5, which are not buttons
+// to the kernel
+func (waylandBackend) Click(button, count int) error {
+	if button == 4 || button == 5 {
+		dy := "1"
+		if button == 5 {
+			dy = "-1"
+		}
+		for i := 0; i < count; i++ {
+			if err := runYdotool("mousemove", "--wheel", "-x", "0", "-y", dy); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	b, err := ydotoolButton(button)
+	if err != nil {
+		return err
+	}
+	args := []string{"click"}
+	if count > 1 {
+		args = append(args, "--repeat", strconv.Itoa(count))
+	}
+	return runYdotool(append(args, fmt.Sprintf("0x%02X", b|ydotoolDown|ydotoolUp))...)
+}
+
+func (b waylandBackend) TypeText(text string) error {
+	if b.typer == "wtype" {
+		cmd := exec.Command("wtype", "-d", waylandKeyDelay, "--", text)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("wtype: %v", err)
+		}
+		return nil
+	}
+	return runYdotool("type", "--key-delay", waylandKeyDelay, "--", text)
+}
+
+// Key takes xdotool key syntax, pressing each combination's keys in order
+// and releasing them in reverse. ydotool sends kernel key codes, which the
+// compositor reads through its keymap, so the names are for a US layout as
+// with the qmp backend.
+func (waylandBackend) Key(keys string) error {
+	args := []string{"key"}
+	for _, combo := range strings.Fields(keys) {
+		var codes []int
+		for _, name := range strings.Split(combo, "+") {
+			c, ok := evdevKeys(name)
+			if !ok {
+				return fmt.Errorf("unknown key %q", name)
+			}
+			codes = append(codes, c...)
+		}
+		for _, c := range codes {
+			args = append(args, fmt.Sprintf("%d:1", c))
+		}
+		for i := len(codes) - 1; i >= 0; i-- {
+			args = append(args, fmt.Sprintf("%d:0", codes[i]))
+		}
+	}
+	return runYdotool(args...)
+}
+
+func (waylandBackend) KeyDown(key string) error { return ydotoolHold(key, true) }
+
+func (waylandBackend) KeyUp(key string) error { return ydotoolHold(key, false) }
+
+// ydotoolHold presses or releases the key codes of a key, releasing in
+// reverse
+func ydotoolHold(key string, down bool) error {
+	codes, ok := evdevKeys(key)
+	if !ok {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	args := []string{"key"}
+	for i := range codes {
+		if down {
+			args = append(args, fmt.Sprintf("%d:1", codes[i]))
+		} else {
+			args = append(args, fmt.Sprintf("%d:0", codes[len(codes)-1-i]))
+		}
+	}
+	return runYdotool(args...)
+}
+
+// Capture reads grim's PNG from its output
+func (waylandBackend) Capture() (image.Image, error) {
+	cmd := exec.Command("grim", "-t", "png", "-")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", err)
+	}
+	img, decodeErr := png.Decode(bufio.NewReader(out))
+	io.Copy(io.Discard, out)
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", err)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("screen capture failed: %v", decodeErr)
+	}
+	return img, nil
+}
+
+func (waylandBackend) captureFile(path string) error {
+	if out, err := exec.Command("grim", "-t", "png", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("grim: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// evdevKeys resolves a key name to the kernel key codes pressed for it,
+// through the names the qmp backend knows
+func evdevKeys(name string) ([]int, bool) {
+	qcodes, ok := qmpQcode(name)
+	if !ok {
+		return nil, false
+	}
+	var codes []int
+	for _, q := range qcodes {
+		c, ok := evdevCodes[q]
+		if !ok {
+			return nil, false
+		}
+		codes = append(codes, c)
+	}
+	return codes, true
+}
+
+// evdevCodes maps QEMU key codes to Linux input event codes (KEY_*)
+var evdevCodes = map[string]int{
+	"esc": 1, "1": 2, "2": 3, "3": 4, "4": 5, "5": 6, "6": 7, "7": 8, "8": 9, "9": 10, "0": 11,
+	"minus": 12, "equal": 13, "backspace": 14, "tab": 15,
+	"q": 16, "w": 17, "e": 18, "r": 19, "t": 20, "y": 21, "u": 22, "i": 23, "o": 24, "p": 25,
+	"bracket_left": 26, "bracket_right": 27, "ret": 28, "ctrl": 29,
+	"a": 30, "s": 31, "d": 32, "f": 33, "g": 34, "h": 35, "j": 36, "k": 37, "l": 38,
+	"semicolon": 39, "apostrophe": 40, "grave_accent": 41, "shift": 42, "backslash": 43,
+	"z": 44, "x": 45, "c": 46, "v": 47, "b": 48, "n": 49, "m": 50,
+	"comma": 51, "dot": 52, "slash": 53, "shift_r": 54, "alt": 56, "spc": 57, "caps_lock": 58,
+	"f1": 59, "f2": 60, "f3": 61, "f4": 62, "f5": 63, "f6": 64, "f7": 65, "f8": 66, "f9": 67, "f10": 68,
+	"num_lock": 69, "scroll_lock": 70, "less": 86, "f11": 87, "f12": 88,
+	"kp_enter": 96, "ctrl_r": 97, "sysrq": 99, "print": 99, "alt_r": 100,
+	"home": 102, "up": 103, "pgup": 104, "left": 105, "right": 106, "end": 107, "down": 108, "pgdn": 109,
+	"insert": 110, "delete": 111, "pause": 119, "meta_l": 125, "meta_r": 126, "menu": 127,
+	"f13": 183, "f14": 184, "f15": 185, "f16": 186, "f17": 187, "f18": 188,
+	"f19": 189, "f20": 190, "f21": 191, "f22": 192, "f23": 193, "f24": 194,
+}
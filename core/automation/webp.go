@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"image"
+	"time"
+)
+
+// Lossless animated WebP encoder: a RIFF container of VP8L frames. It
+// codes each frame with LZ77 copies from the pixel to the left or the row
+// above and one set of prefix codes, and only stores the part of a frame
+// that changed. That is plenty for screenshots, which are mostly flat.
+
+// webpFrame is one frame of an animation and how long it shows
+type webpFrame struct {
+	img      *image.RGBA
+	duration time.Duration
+}
+
+// encodeAnimatedWebP encodes frames of equal size as a looping animation
+func encodeAnimatedWebP(frames []webpFrame) []byte {
+	bounds := frames[0].img.Bounds()
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 // animation
+	put24(vp8x[4:], bounds.Dx()-1)
+	put24(vp8x[7:], bounds.Dy()-1)
+	writeChunk(&body, "VP8X", vp8x)
+	writeChunk(&body, "ANIM", make([]byte, 6)) // transparent background, loop forever
+
+	var prev *image.RGBA
+	for i := 0; i < len(frames); i++ {
+		frame := frames[i]
+		// Unchanged frames only lengthen the one before
+		for i+1 < len(frames) && sameImage(frames[i+1].img, frame.img) {
+			i++
+			frame.duration += frames[i].duration
+		}
+		rect := bounds
+		if prev != nil {
+			rect = changedRect(prev, frame.img)
+			// Frame offsets are stored halved
+			rect.Min.X &^= 1
+			rect.Min.Y &^= 1
+		}
+		anmf := make([]byte, 16)
+		put24(anmf[0:], (rect.Min.X-bounds.Min.X)/2)
+		put24(anmf[3:], (rect.Min.Y-bounds.Min.Y)/2)
+		put24(anmf[6:], rect.Dx()-1)
+		put24(anmf[9:], rect.Dy()-1)
+		put24(anmf[12:], int(frame.duration/time.Millisecond))
+		anmf[15] = 0x02 // replace the area rather than blend, no disposal
+		var data bytes.Buffer
+		writeChunk(&data, "VP8L", encodeVP8L(frame.img.SubImage(rect).(*image.RGBA)))
+		writeChunk(&body, "ANMF", append(anmf, data.Bytes()...))
+		prev = frame.img
+	}
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// encodeWebP encodes a single lossless image
+func encodeWebP(img *image.RGBA) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	writeChunk(&body, "VP8L", encodeVP8L(img))
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+func writeChunk(w *bytes.Buffer, fourcc string, payload []byte) {
+	w.WriteString(fourcc)
+	binary.Write(w, binary.LittleEndian, uint32(len(payload)))
+	w.Write(payload)
+	if len(payload)%2 == 1 {
+		w.WriteByte(0)
+	}
+}
+
+func put24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+func sameImage(a, b *image.RGBA) bool {
+	return a.Rect == b.Rect && bytes.Equal(a.Pix, b.Pix)
+}
+
+// changedRect is the smallest rectangle holding every pixel that differs,
+// at least 2x2 so a frame is never empty
+func changedRect(a, b *image.RGBA) image.Rectangle {
+	r := image.Rectangle{}
+	bounds := b.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		rowA := a.Pix[a.PixOffset(bounds.Min.X, y) : a.PixOffset(bounds.Max.X-1, y)+4]
+		rowB := b.Pix[b.PixOffset(bounds.Min.X, y) : b.PixOffset(bounds.Max.X-1, y)+4]
+		if bytes.Equal(rowA, rowB) {
+			continue
+		}
+		for x := 0; x < len(rowA); x += 4 {
+			if !bytes.Equal(rowA[x:x+4], rowB[x:x+4]) {
+				r = r.Union(image.Rect(bounds.Min.X+x/4, y, bounds.Min.X+x/4+1, y+1))
+			}
+		}
+	}
+	if r.Dx() < 2 || r.Dy() < 2 {
+		r = image.Rect(r.Min.X, r.Min.Y, r.Min.X+2, r.Min.Y+2).Intersect(bounds)
+		if r.Dx() < 2 || r.Dy() < 2 {
+			r = image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+2, bounds.Min.Y+2)
+		}
+	}
+	return r
+}
+
+// VP8L alphabet sizes: green with 24 length prefixes, red, blue, alpha
+// and distance
+var vp8lAlphabets = [5]int{256 + 24, 256, 256, 256, 40}
+
+// vp8lCodeLengthOrder is the order code length code lengths are stored in
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+const vp8lMaxLength = 4096
+
+type vp8lToken struct {
+	argb     uint32
+	length   int // 0 for a literal pixel
+	distCode int
+}
+
+// encodeVP8L encodes one image as a VP8L bitstream without transforms
+func encodeVP8L(img *image.RGBA) []byte {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	pixels := make([]uint32, 0, w*h)
+	alpha := false
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			pixels = append(pixels, uint32(p[3])<<24|uint32(p[0])<<16|uint32(p[1])<<8|uint32(p[2]))
+			alpha = alpha || p[3] != 0xff
+		}
+	}
+
+	// LZ77 against the pixel to the left (distance code 2) or above (1)
+	var tokens []vp8lToken
+	for i := 0; i < len(pixels); {
+		best, bestCode := 0, 0
+		for _, c := range [2][2]int{{w, 1}, {1, 2}} {
+			dist, code := c[0], c[1]
+			if i < dist {
+				continue
+			}
+			n := 0
+			for n < vp8lMaxLength && i+n < len(pixels) && pixels[i+n] == pixels[i+n-dist] {
+				n++
+			}
+			if n > best {
+				best, bestCode = n, code
+			}
+		}
+		if best >= 3 {
+			tokens = append(tokens, vp8lToken{length: best, distCode: bestCode})
+			i += best
+		} else {
+			tokens = append(tokens, vp8lToken{argb: pixels[i]})
+			i++
+		}
+	}
+
+	var freqs [5][]int
+	for i, size := range vp8lAlphabets {
+		freqs[i] = make([]int, size)
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			freqs[0][t.argb>>8&0xff]++
+			freqs[1][t.argb>>16&0xff]++
+			freqs[2][t.argb&0xff]++
+			freqs[3][t.argb>>24]++
+			continue
+		}
+		prefix, _, _ := vp8lPrefix(t.length)
+		freqs[0][256+prefix]++
+		prefix, _, _ = vp8lPrefix(t.distCode)
+		freqs[4][prefix]++
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+	bw.write(0, 1) // no transform
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // one prefix code group
+	var codes [5]prefixCode
+	for i := range codes {
+		codes[i] = newPrefixCode(freqs[i], 15)
+		codes[i].writeTo(bw)
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].emit(bw, int(t.argb>>8&0xff))
+			codes[1].emit(bw, int(t.argb>>16&0xff))
+			codes[2].emit(bw, int(t.argb&0xff))
+			codes[3].emit(bw, int(t.argb>>24))
+			continue
+		}
+		prefix, bits, extra := vp8lPrefix(t.length)
+		codes[0].emit(bw, 256+prefix)
+		bw.write(uint32(extra), bits)
+		prefix, bits, extra = vp8lPrefix(t.distCode)
+		codes[4].emit(bw, prefix)
+		bw.write(uint32(extra), bits)
+	}
+	return bw.bytes()
+}
+
+// vp8lPrefix splits a length or distance code into its prefix symbol and
+// extra bits
+func vp8lPrefix(v int) (prefix int, bits uint, extra int) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	high := 0
+	for d>>(high+1) != 0 {
+		high++
+	}
+	second := d >> (high - 1) & 1
+	bits = uint(high - 1)
+	return 2*high + second, bits, d & (1<<bits - 1)
+}
+
+// prefixCode is a canonical Huffman code
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint32 // bit reversed, ready to write
+}
+
+// newPrefixCode builds a code from symbol frequencies. At least two
+// symbols always get a code, so decoders never meet a degenerate tree.
+func newPrefixCode(freq []int, maxLen int) prefixCode {
+	freq = append([]int(nil), freq...)
+	used := 0
+	for _, f := range freq {
+		if f > 0 {
+			used++
+		}
+	}
+	for i := 0; used < 2; i++ {
+		if freq[i] == 0 {
+			freq[i] = 1
+			used++
+		}
+	}
+	lengths := huffmanLengths(freq, maxLen)
+	return prefixCode{lengths: lengths, codes: canonicalCodes(lengths)}
+}
+
+func (c prefixCode) emit(bw *bitWriter, symbol int) {
+	bw.write(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+// writeTo stores the code lengths, themselves prefix coded
+func (c prefixCode) writeTo(bw *bitWriter) {
+	clFreq := make([]int, 19)
+	for _, l := range c.lengths {
+		clFreq[l]++
+	}
+	cl := newPrefixCode(clFreq, 7)
+	count := 4
+	for i, sym := range vp8lCodeLengthOrder {
+		if cl.lengths[sym] != 0 && i+1 > count {
+			count = i + 1
+		}
+	}
+	bw.write(0, 1) // normal code
+	bw.write(uint32(count-4), 4)
+	for _, sym := range vp8lCodeLengthOrder[:count] {
+		bw.write(uint32(cl.lengths[sym]), 3)
+	}
+	bw.write(0, 1) // lengths for every symbol follow
+	for _, l := range c.lengths {
+		cl.emit(bw, int(l))
+	}
+}
+
+type huffNode struct {
+	freq        int
+	symbol      int // -1 for inner nodes
+	left, right *huffNode
+}
+
+type huffHeap []*huffNode
+
+func (h huffHeap) Len() int            { return len(h) }
+func (h huffHeap) Less(i, j int) bool  { return h[i].freq < h[j].freq }
+func (h huffHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffHeap) Push(x interface{}) { *h = append(*h, x.(*huffNode)) }
+func (h *huffHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths returns code lengths no longer than maxLen, flattening
+// the frequencies until the tree fits
+func huffmanLengths(freq []int, maxLen int) []uint8 {
+	for {
+		h := &huffHeap{}
+		for s, f := range freq {
+			if f > 0 {
+				*h = append(*h, &huffNode{freq: f, symbol: s})
+			}
+		}
+		heap.Init(h)
+		for h.Len() > 1 {
+			a, b := heap.Pop(h).(*huffNode), heap.Pop(h).(*huffNode)
+			heap.Push(h, &huffNode{freq: a.freq + b.freq, symbol: -1, left: a, right: b})
+		}
+		lengths := make([]uint8, len(freq))
+		deepest := 0
+		var walk func(n *huffNode, depth int)
+		walk = func(n *huffNode, depth int) {
+			if n.symbol >= 0 {
+				lengths[n.symbol] = uint8(depth)
+				if depth > deepest {
+					deepest = depth
+				}
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk((*h)[0], 0)
+		if deepest <= maxLen {
+			return lengths
+		}
+		for s, f := range freq {
+			if f > 0 {
+				freq[s] = (f + 1) / 2
+			}
+		}
+	}
+}
+
+// canonicalCodes assigns codes in order of length, then symbol, and
+// reverses them since the bitstream is read least significant bit first
+func canonicalCodes(lengths []uint8) []uint32 {
+	var count [16]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]uint32
+	code := uint32(0)
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		var rev uint32
+		for i := uint8(0); i < l; i++ {
+			rev = rev<<1 | c>>i&1
+		}
+		codes[s] = rev
+	}
+	return codes
+}
+
+// bitWriter packs bits least significant first
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint
+}
+
+func (w *bitWriter) write(v uint32, bits uint) {
+	w.acc |= uint64(v) << w.n
+	w.n += bits
+	for w.n >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.n -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.n > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.n = 0, 0
+	}
+	return w.buf
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal X11 protocol client. It implements just enough of the core
+// protocol to open windows, draw, read input events and query the server,
+// without linking Xlib or spawning helper processes.
+
+// X event codes we care about
+const (
+	xKeyPress      = 2
+	xKeyRelease    = 3
+	xButtonPress   = 4
+	xButtonRelease = 5
+	xMotionNotify  = 6
+	xExpose        = 12
+	xMappingNotify = 34
+	xGenericEvent  = 35
+)
+
+// X event masks
+const (
+	xKeyPressMask    = 1 << 0
+	xButtonPressMask = 1 << 2
+	xExposureMask    = 1 << 15
+)
+
+var xByteOrder = binary.LittleEndian
+
+// xEvent is a raw 32-byte X event
+type xEvent []byte
+
+func (e xEvent) code() byte    { return e[0] & 0x7f }
+func (e xEvent) detail() byte  { return e[1] }
+func (e xEvent) rootX() int    { return int(int16(xByteOrder.Uint16(e[20:]))) }
+func (e xEvent) rootY() int    { return int(int16(xByteOrder.Uint16(e[22:]))) }
+func (e xEvent) state() uint16 { return xByteOrder.Uint16(e[28:]) }
+
+// xError is an error packet returned by the server
+type xError struct {
+	Code     byte
+	Sequence uint16
+	Major    byte
+}
+
+func (e *xError) Error() string {
+	return fmt.Sprintf("X error %d for request opcode %d", e.Code, e.Major)
+}
+
+type xScreen struct {
+	Root       uint32
+	RootVisual uint32
+	RootDepth  byte
+	WhitePixel uint32
+	BlackPixel uint32
+	Width      int
+	Height     int
+	// Colour masks of the root visual, for reading its pixels
+	RedMask, GreenMask, BlueMask uint32
+}
+
+type xConn struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	seq     uint16
+	pending map[uint16]chan []byte
+	errs    map[uint16]*xError
+	flushed uint16 // sequence of the last flush to complete
+	nextID  uint32
+
+	idBase, idMask uint32
+	screen         xScreen
+	minKeycode     byte
+	maxKeycode     byte
+	imageMSBFirst  bool          // image byte order
+	pixmapBPP      map[byte]byte // bits per pixel of images by depth
+
+	Events chan xEvent
+	closed chan struct{}
+}
+
+// parseDisplay splits ":1.0" or "host:1" into host and display number
+func parseDisplay(display string) (host string, number int, err error) {
+	i := strings.LastIndex(display, ":")
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid display %q", display)
+	}
+	host = display[:i]
+	num := display[i+1:]
+	if dot := strings.Index(num, "."); dot >= 0 {
+		num = num[:dot]
+	}
+	number, err = strconv.Atoi(num)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid display %q", display)
+	}
+	return host, number, nil
+}
+
+// openX connects to the given display ("" means $DISPLAY)
+func openX(display string) (*xConn, error) {
+	if display == "" {
+		display = os.Getenv("DISPLAY")
+	}
+	host, number, err := parseDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	if host == "" || host == "unix" {
+		conn, err = net.Dial("unix", fmt.Sprintf("/tmp/.X11-unix/X%d", number))
+	} else {
+		conn, err = net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(6000+number)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to X display %s: %v", display, err)
+	}
+
+	x := &xConn{
+		conn:    conn,
+		pending: make(map[uint16]chan []byte),
+		errs:    make(map[uint16]*xError),
+		Events:  make(chan xEvent, 256),
+		closed:  make(chan struct{}),
+	}
+	authName, authData := xauthCookie(host, number)
+	if err := x.handshake(authName, authData); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go x.readLoop()
+	return x, nil
+}
+
+// xauthCookie looks up the MIT-MAGIC-COOKIE-1 for a display in Xauthority
+func xauthCookie(host string, number int) (string, []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, ".Xauthority")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil
+	}
+	hostname, _ := os.Hostname()
+	want := strconv.Itoa(number)
+
+	readField := func() ([]byte, bool) {
+		if len(data) < 2 {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+n {
+			return nil, false
+		}
+		field := data[2 : 2+n]
+		data = data[2+n:]
+		return field, true
+	}
+	for len(data) >= 2 {
+		family := binary.BigEndian.Uint16(data)
+		data = data[2:]
+		addr, ok1 := readField()
+		num, ok2 := readField()
+		name, ok3 := readField()
+		cookie, ok4 := readField()
+		if !(ok1 && ok2 && ok3 && ok4) {
+			break
+		}
+		// A record without a display number is for every display
+		if (len(num) > 0 && string(num) != want) || string(name) != "MIT-MAGIC-COOKIE-1" {
+			continue
+		}
+		// 256 = FamilyLocal, 65535 = FamilyWild
+		local := family == 256 && (host == "" || host == "unix" || string(addr) == hostname)
+		if local || family == 65535 || string(addr) == host {
+			return string(name), cookie
+		}
+	}
+	return "", nil
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+func (x *xConn) handshake(authName string, authData []byte) error {
+	req := make([]byte, 12, 12+len(authName)+len(authData)+8)
+	req[0] = 'l' // little-endian
+	xByteOrder.PutUint16(req[2:], 11)
+	xByteOrder.PutUint16(req[6:], uint16(len(authName)))
+	xByteOrder.PutUint16(req[8:], uint16(len(authData)))
+	req = append(req, authName...)
+	req = append(req, make([]byte, pad4(len(authName)))...)
+	req = append(req, authData...)
+	req = append(req, make([]byte, pad4(len(authData)))...)
+	if _, err := x.conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(x.conn, head); err != nil {
+		return err
+	}
+	body := make([]byte, int(xByteOrder.Uint16(head[6:]))*4)
+	if _, err := io.ReadFull(x.conn, body); err != nil {
+		return err
+	}
+	if head[0] != 1 {
+		reason := body
+		if int(head[1]) <= len(reason) {
+			reason = reason[:head[1]]
+		}
+		return fmt.Errorf("X server refused connection: %s", strings.TrimSpace(string(reason)))
+	}
+
+	// body offsets are relative to byte 8 of the setup reply
+	x.idBase = xByteOrder.Uint32(body[4:])
+	x.idMask = xByteOrder.Uint32(body[8:])
+	vendorLen := int(xByteOrder.Uint16(body[16:]))
+	numFormats := int(body[21])
+	x.imageMSBFirst = body[22] == 1
+	x.minKeycode = body[26]
+	x.maxKeycode = body[27]
+	x.pixmapBPP = map[byte]byte{}
+	formats := 32 + vendorLen + pad4(vendorLen)
+	for i := 0; i < numFormats; i++ {
+		f := body[formats+8*i:]
+		x.pixmapBPP[f[0]] = f[1]
+	}
+	s := body[formats+8*numFormats:]
+	x.screen = xScreen{
+		Root:       xByteOrder.Uint32(s[0:]),
+		WhitePixel: xByteOrder.Uint32(s[8:]),
+		BlackPixel: xByteOrder.Uint32(s[12:]),
+		Width:      int(xByteOrder.Uint16(s[20:])),
+		Height:     int(xByteOrder.Uint16(s[22:])),
+		RootVisual: xByteOrder.Uint32(s[32:]),
+		RootDepth:  s[38],
+	}
+	// The screen's allowed depths follow it, each with its visuals
+	depths, off := int(s[39]), 40
+	for i := 0; i < depths && off+8 <= len(s); i++ {
+		visuals := int(xByteOrder.Uint16(s[off+2:]))
+		for v := 0; v < visuals && off+8+24*(v+1) <= len(s); v++ {
+			vt := s[off+8+24*v:]
+			if xByteOrder.Uint32(vt) == x.screen.RootVisual {
+				x.screen.RedMask = xByteOrder.Uint32(vt[8:])
+				x.screen.GreenMask = xByteOrder.Uint32(vt[12:])
+				x.screen.BlueMask = xByteOrder.Uint32(vt[16:])
+			}
+		}
+		off += 8 + 24*visuals
+	}
+	return nil
+}
+
+func (x *xConn) readLoop() {
+	r := bufio.NewReader(x.conn)
+	defer close(x.closed)
+	for {
+		packet := make([]byte, 32)
+		if _, err := io.ReadFull(r, packet); err != nil {
+			return
+		}
+		switch packet[0] {
+		case 0: // error
+			e := &xError{Code: packet[1], Sequence: xByteOrder.Uint16(packet[2:]), Major: packet[10]}
+			x.mu.Lock()
+			x.errs[e.Sequence] = e
+			if ch, ok := x.pending[e.Sequence]; ok {
+				delete(x.pending, e.Sequence)
+				close(ch)
+			}
+			x.mu.Unlock()
+		case 1: // reply
+			extra := int(xByteOrder.Uint32(packet[4:])) * 4
+			if extra > 0 {
+				more := make([]byte, extra)
+				if _, err := io.ReadFull(r, more); err != nil {
+					return
+				}
+				packet = append(packet, more...)
+			}
+			seq := xByteOrder.Uint16(packet[2:])
+			x.mu.Lock()
+			ch, ok := x.pending[seq]
+			delete(x.pending, seq)
+			x.mu.Unlock()
+			if ok {
+				ch <- packet
+			}
+		default:
+			if packet[0]&0x7f == xGenericEvent {
+				extra := int(xByteOrder.Uint32(packet[4:])) * 4
+				if _, err := io.CopyN(io.Discard, r, int64(extra)); err != nil {
+					return
+				}
+				continue
+			}
+			select {
+			case x.Events <- xEvent(packet):
+			default:
+				// Nobody is reading events; drop rather than stall replies
+			}
+		}
+	}
+}
+
+// send writes a request, padding it to a multiple of 4 and filling in the
+// length field. If wantReply is set the returned channel yields the reply,
+// or is closed on error.
+func (x *xConn) send(req []byte, wantReply bool) (uint16, chan []byte, error) {
+	req = append(req, make([]byte, pad4(len(req)))...)
+	xByteOrder.PutUint16(req[2:], uint16(len(req)/4))
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.seq++
+	seq := x.seq
+	var ch chan []byte
+	if wantReply {
+		ch = make(chan []byte, 1)
+		x.pending[seq] = ch
+	}
+	if _, err := x.conn.Write(req); err != nil {
+		delete(x.pending, seq)
+		return 0, nil, err
+	}
+	return seq, ch, nil
+}
+
+// request sends a request without a reply
+func (x *xConn) request(req []byte) (uint16, error) {
+	seq, _, err := x.send(req, false)
+	return seq, err
+}
+
+// roundTrip sends a request and waits for its reply
+func (x *xConn) roundTrip(req []byte) ([]byte, error) {
+	seq, ch, err := x.send(req, true)
+	if err != nil {
+		return nil, err
+	}
+	return x.wait(seq, ch)
+}
+
+// wait waits for the reply to the request with sequence seq
+func (x *xConn) wait(seq uint16, ch chan []byte) ([]byte, error) {
+	select {
+	case reply, ok := <-ch:
+		if !ok {
+			x.mu.Lock()
+			e := x.errs[seq]
+			delete(x.errs, seq)
+			x.mu.Unlock()
+			return nil, e
+		}
+		return reply, nil
+	case <-x.closed:
+		return nil, errors.New("X connection closed")
+	case <-time.After(5 * time.Second):
+		return nil, errors.New("X server did not reply")
+	}
+}
+
+// flush waits until the server has processed everything sent so far.
+// Errors of requests nobody synced are dropped once they have outlived a
+// whole flush, so they do not pile up for the life of the connection.
+func (x *xConn) flush() error {
+	seq, ch, err := x.send(x.req(43, 0, nil), true) // GetInputFocus
+	if err == nil {
+		_, err = x.wait(seq, ch)
+	}
+	if err != nil {
+		return err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for s := range x.errs {
+		if seqBefore(s, x.flushed) {
+			delete(x.errs, s)
+		}
+	}
+	if seqBefore(x.flushed, seq) {
+		x.flushed = seq
+	}
+	return nil
+}
+
+// seqBefore says whether sequence a was sent before b, allowing for the
+// 16-bit sequence numbers wrapping around
+func seqBefore(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+// sync flushes and returns the error, if any, raised by the request with
+// sequence seq.
+func (x *xConn) sync(seq uint16) error {
+	if err := x.flush(); err != nil {
+		return err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.errs[seq]; ok {
+		delete(x.errs, seq)
+		return e
+	}
+	return nil
+}
+
+func (x *xConn) Close() error {
+	return x.conn.Close()
+}
+
+func (x *xConn) newID() uint32 {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.nextID++
+	return x.idBase | (x.nextID & x.idMask)
+}
+
+// req builds a request header followed by body; length is set by send
+func (x *xConn) req(opcode, data byte, body []byte) []byte {
+	r := make([]byte, 4, 4+len(body))
+	r[0] = opcode
+	r[1] = data
+	return append(r, body...)
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	xByteOrder.PutUint32(b, v)
+	return b
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	xByteOrder.PutUint16(b, v)
+	return b
+}
+
+func cat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// createWindow opens a top-level override-redirect window, so it is placed
+// exactly where asked and left undecorated by the window manager.
+func (x *xConn) createWindow(px, py, w, h int, background uint32, eventMask uint32) (uint32, error) {
+	wid := x.newID()
+	const cwBackPixel, cwOverrideRedirect, cwEventMask = 0x2, 0x200, 0x800
+	body := cat(
+		u32(wid), u32(x.screen.Root),
+		u16(uint16(px)), u16(uint16(py)), u16(uint16(w)), u16(uint16(h)),
+		u16(0), u16(1), // border width, InputOutput
+		u32(0), // CopyFromParent visual
+		u32(cwBackPixel|cwOverrideRedirect|cwEventMask),
+		u32(background), u32(1), u32(eventMask),
+	)
+	if _, err := x.request(x.req(1, 0, body)); err != nil {
+		return 0, err
+	}
+	return wid, nil
+}
+
+func (x *xConn) mapWindow(wid uint32) error {
+	_, err := x.request(x.req(8, 0, u32(wid)))
+	return err
+}
+
+func (x *xConn) destroyWindow(wid uint32) error {
+	_, err := x.request(x.req(4, 0, u32(wid)))
+	return err
+}
+
+func (x *xConn) setInputFocus(wid uint32) error {
+	_, err := x.request(x.req(42, 2, cat(u32(wid), u32(0)))) // RevertToParent, CurrentTime
+	return err
+}
+
+// createGC creates a graphics context with a foreground colour and font
+func (x *xConn) createGC(drawable, foreground, font uint32) (uint32, error) {
+	gc := x.newID()
+	const gcForeground, gcFont = 0x4, 0x4000
+	mask := uint32(gcForeground)
+	values := u32(foreground)
+	if font != 0 {
+		mask |= gcFont
+		values = append(values, u32(font)...)
+	}
+	_, err := x.request(x.req(55, 0, cat(u32(gc), u32(drawable), u32(mask), values)))
+	return gc, err
+}
+
+func (x *xConn) setForeground(gc, pixel uint32) error {
+	_, err := x.request(x.req(56, 0, cat(u32(gc), u32(0x4), u32(pixel))))
+	return err
+}
+
+func (x *xConn) fillRect(drawable, gc uint32, rx, ry, w, h int) error {
+	body := cat(u32(drawable), u32(gc), u16(uint16(rx)), u16(uint16(ry)), u16(uint16(w)), u16(uint16(h)))
+	_, err := x.request(x.req(70, 0, body))
+	return err
+}
+
+// openFont opens the first available core font from names
+func (x *xConn) openFont(names ...string) (uint32, error) {
+	for _, name := range names {
+		fid := x.newID()
+		body := cat(u32(fid), u16(uint16(len(name))), u16(0), []byte(name))
+		seq, err := x.request(x.req(45, 0, body))
+		if err != nil {
+			return 0, err
+		}
+		if x.sync(seq) == nil {
+			return fid, nil
+		}
+	}
+	return 0, fmt.Errorf("none of the fonts %v are available", names)
+}
+
+func (x *xConn) drawText(drawable, gc uint32, tx, ty int, text string) error {
+	if len(text) > 255 {
+		text = text[:255]
+	}
+	body := cat(u32(drawable), u32(gc), u16(uint16(tx)), u16(uint16(ty)), []byte(text))
+	_, err := x.request(x.req(76, byte(len(text)), body))
+	return err
+}
+
+// keyboardMapping returns the keysyms for every keycode, indexed by
+// (keycode-minKeycode)*perCode + column
+func (x *xConn) keyboardMapping() (keysyms []uint32, perCode int, err error) {
+	count := int(x.maxKeycode) - int(x.minKeycode) + 1
+	reply, err := x.roundTrip(x.req(101, 0, []byte{x.minKeycode, byte(count), 0, 0}))
+	if err != nil {
+		return nil, 0, err
+	}
+	perCode = int(reply[1])
+	data := reply[32:]
+	keysyms = make([]uint32, len(data)/4)
+	for i := range keysyms {
+		keysyms[i] = xByteOrder.Uint32(data[i*4:])
+	}
+	return keysyms, perCode, nil
+}
+
+// queryExtension returns the major opcode of a protocol extension
+func (x *xConn) queryExtension(name string) (byte, bool, error) {
+	body := cat(u16(uint16(len(name))), u16(0), []byte(name))
+	reply, err := x.roundTrip(x.req(98, 0, body))
+	if err != nil {
+		return 0, false, err
+	}
+	return reply[9], reply[8] != 0, nil
+}
+
+// modifierState returns the pointer's modifier mask, which includes the
+// locked modifiers: Lock for Caps Lock, and the one Num_Lock is mapped to
+func (x *xConn) modifierState() (uint16, error) {
+	reply, err := x.roundTrip(x.req(38, 0, u32(x.screen.Root)))
+	if err != nil {
+		return 0, err
+	}
+	return xByteOrder.Uint16(reply[24:]), nil
+}
+
+// modifierMask returns the modifier mask a keysym is mapped to, 0 for none
+func (x *xConn) modifierMask(keysym uint32) (uint16, error) {
+	keysyms, perCode, err := x.keyboardMapping()
+	if err != nil {
+		return 0, err
+	}
+	reply, err := x.roundTrip(x.req(119, 0, nil)) // GetModifierMapping
+	if err != nil {
+		return 0, err
+	}
+	perModifier := int(reply[1])
+	codes := reply[32:]
+	for mod := 0; mod < 8; mod++ {
+		for i := 0; i < perModifier && (mod*perModifier+i) < len(codes); i++ {
+			code := int(codes[mod*perModifier+i])
+			if code < int(x.minKeycode) {
+				continue
+			}
+			base := (code - int(x.minKeycode)) * perCode
+			for col := 0; col < perCode && base+col < len(keysyms); col++ {
+				if keysyms[base+col] == keysym {
+					return 1 << mod, nil
+				}
+			}
+		}
+	}
+	return 0, nil
+}
+
+// queryPointer returns the pointer position in root coordinates
+func (x *xConn) queryPointer() (int, int, error) {
+	reply, err := x.roundTrip(x.req(38, 0, u32(x.screen.Root)))
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(int16(xByteOrder.Uint16(reply[16:]))), int(int16(xByteOrder.Uint16(reply[18:]))), nil
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// x11Backend drives an X display over one X connection kept for the run,
+// instead of starting xdotool for every pointer move and key: keys and
+// buttons are XTEST fake input, pointer moves are core WarpPointer
+// requests and screenshots are GetImage. A drag is then a stream of
+// requests rather than a process per step. Everything else an X display
+// offers (sessions, lock keys, cursor hiding) it does as xdotoolBackend.
+// It is the default backend outside Wayland sessions.
+type x11Backend struct {
+	xdotoolBackend
+
+	mu    sync.Mutex
+	x     *xConn
+	xtest byte // XTEST's major opcode
+}
+
+// x11KeyDelay is the pause between typed characters, as xdotool type's
+// default, and x11ClickDelay between the clicks of a double click
+const (
+	x11KeyDelay   = 50 * time.Millisecond
+	x11ClickDelay = 100 * time.Millisecond
+)
+
+// The connection is opened on first use, so runs that never touch the
+// display do not need one
+func newX11Backend() (Backend, error) {
+	return &x11Backend{}, nil
+}
+
+func (*x11Backend) Name() string { return "x11" }
+
+// conn returns the open connection, reconnecting after the server went
+// away
+func (b *x11Backend) conn() (*xConn, error) {
+	if b.x != nil {
+		select {
+		case <-b.x.closed:
+			b.x = nil
+		default:
+			return b.x, nil
+		}
+	}
+	x, err := openX("")
+	if err != nil {
+		return nil, err
+	}
+	major, present, err := x.queryExtension("XTEST")
+	if err == nil && !present {
+		err = fmt.Errorf("X server has no XTEST extension (use --backend xdotool)")
+	}
+	if err != nil {
+		x.Close()
+		return nil, err
+	}
+	b.x, b.xtest = x, major
+	return x, nil
+}
+
+// disconnect closes the connection; the next request opens one to the
+// display DISPLAY then names
+func (b *x11Backend) disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.x != nil {
+		b.x.Close()
+		b.x = nil
+	}
+}
+
+// fake sends an XTEST FakeInput event of one of the core event types
+func (b *x11Backend) fake(x *xConn, kind, detail byte) (uint16, error) {
+	body := make([]byte, 32)
+	body[0], body[1] = kind, detail
+	return x.request(x.req(b.xtest, 2, body))
+}
+
+// do runs fn on the connection and waits until the server has handled its
+// requests, so errors surface on the step that caused them
+func (b *x11Backend) do(fn func(x *xConn) (uint16, error)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	x, err := b.conn()
+	if err != nil {
+		return err
+	}
+	seq, err := fn(x)
+	if err != nil {
+		return err
+	}
+	return x.sync(seq)
+}
+
+func (b *x11Backend) MoveMouse(px, py int) error {
+	return b.do(func(x *xConn) (uint16, error) {
+		body := cat(u32(0), u32(x.screen.Root), u16(0), u16(0), u16(0), u16(0), u16(uint16(int16(px))), u16(uint16(int16(py))))
+		return x.request(x.req(41, 0, body)) // WarpPointer
+	})
+}
+
+func (b *x11Backend) MouseDown(button int) error {
+	return b.do(func(x *xConn) (uint16, error) { return b.fake(x, xButtonPress, byte(button)) })
+}
+
+func (b *x11Backend) MouseUp(button int) error {
+	return b.do(func(x *xConn) (uint16, error) { return b.fake(x, xButtonRelease, byte(button)) })
+}
+
+func (b *x11Backend) Click(button, count int) error {
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(x11ClickDelay)
+		}
+		err := b.do(func(x *xConn) (uint16, error) {
+			if _, err := b.fake(x, xButtonPress, byte(button)); err != nil {
+				return 0, err
+			}
+			return b.fake(x, xButtonRelease, byte(button))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Key takes xdotool key syntax: combinations like ctrl+shift+t, separated
+// by spaces. A key whose symbol is on its key's shifted level is pressed
+// with Shift, as xdotool does.
+func (b *x11Backend) Key(keys string) error {
+	return b.do(func(x *xConn) (uint16, error) {
+		m, err := readKeymap(x)
+		if err != nil {
+			return 0, err
+		}
+		var seq uint16
+		for _, combo := range strings.Fields(keys) {
+			var codes []byte
+			for _, name := range strings.Split(combo, "+") {
+				keysym, ok := xKeysym(name)
+				if !ok {
+					return 0, fmt.Errorf("unknown key %q", name)
+				}
+				code, shifted, ok := m.lookup(keysym)
+				if !ok {
+					return 0, fmt.Errorf("key %q is not on the keyboard", name)
+				}
+				if shifted {
+					codes = append(codes, m.shift)
+				}
+				codes = append(codes, code)
+			}
+			if seq, err = b.press(x, codes); err != nil {
+				return 0, err
+			}
+		}
+		return seq, nil
+	})
+}
+
+func (b *x11Backend) KeyDown(key string) error { return b.holdKey(key, xKeyPress) }
+
+func (b *x11Backend) KeyUp(key string) error { return b.holdKey(key, xKeyRelease) }
+
+// holdKey presses or releases the key that has key's symbol. Unlike Key it
+// adds no Shift for a shifted symbol; a script holds shift for that.
+func (b *x11Backend) holdKey(key string, kind byte) error {
+	keysym, ok := xKeysym(key)
+	if !ok {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return b.do(func(x *xConn) (uint16, error) {
+		m, err := readKeymap(x)
+		if err != nil {
+			return 0, err
+		}
+		code, _, ok := m.lookup(keysym)
+		if !ok {
+			return 0, fmt.Errorf("key %q is not on the keyboard", key)
+		}
+		return b.fake(x, kind, code)
+	})
+}
+
+// press holds the keys down in order, then releases them in reverse
+func (b *x11Backend) press(x *xConn, codes []byte) (uint16, error) {
+	var seq uint16
+	var err error
+	for _, c := range codes {
+		if seq, err = b.fake(x, xKeyPress, c); err != nil {
+			return 0, err
+		}
+	}
+	for i := len(codes) - 1; i >= 0; i-- {
+		if seq, err = b.fake(x, xKeyRelease, codes[i]); err != nil {
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// TypeText types each character with the key that has it, adding Shift for
+// the shifted level. A character on no key is typed by mapping it to a
+// spare keycode for the moment, as xdotool does.
+func (b *x11Backend) TypeText(text string) error {
+	for i, r := range []rune(text) {
+		if i > 0 {
+			time.Sleep(x11KeyDelay)
+		}
+		if err := b.typeRune(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *x11Backend) typeRune(r rune) error {
+	var spare byte
+	perCode := 0
+	err := b.do(func(x *xConn) (uint16, error) {
+		m, err := readKeymap(x)
+		if err != nil {
+			return 0, err
+		}
+		perCode = m.perCode
+		keysym := runeKeysym(r)
+		if code, shifted, ok := m.lookup(keysym); ok {
+			codes := []byte{code}
+			if shifted {
+				codes = []byte{m.shift, code}
+			}
+			return b.press(x, codes)
+		}
+		if spare = m.spare(); spare == 0 {
+			return 0, fmt.Errorf("cannot type %q: no free keycode to map it to", r)
+		}
+		seq, err := x.request(x.req(100, 1, cat([]byte{spare, byte(m.perCode), 0, 0}, keysymRow(keysym, m.perCode))))
+		if err != nil {
+			return 0, err
+		}
+		if err := x.sync(seq); err != nil {
+			return 0, err
+		}
+		// Clients reload the keymap when told it changed; give them time
+		time.Sleep(x11KeyDelay)
+		return b.press(x, []byte{spare})
+	})
+	if spare != 0 {
+		// The key is released; hand the keycode back
+		time.Sleep(x11KeyDelay)
+		b.do(func(x *xConn) (uint16, error) {
+			return x.request(x.req(100, 1, cat([]byte{spare, byte(perCode), 0, 0}, keysymRow(0, perCode))))
+		})
+	}
+	return err
+}
+
+// Capture reads the root window with GetImage
+func (b *x11Backend) Capture() (image.Image, error) {
+	b.mu.Lock()
+	x, err := b.conn()
+	var img *image.RGBA
+	if err == nil {
+		img, err = x.rootImage()
+	}
+	b.mu.Unlock()
+	if err != nil {
+		// Visuals this does not read are left to ImageMagick
+		return b.xdotoolBackend.Capture()
+	}
+	return img, nil
+}
+
+func (b *x11Backend) captureFile(path string) error {
+	img, err := b.Capture()
+	if err != nil {
+		return err
+	}
+	defer releaseFrame(img)
+	return writePNG(path, img)
+}
+
+func (b *x11Backend) cursorPosition() (int, int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	x, err := b.conn()
+	if err != nil {
+		return 0, 0, err
+	}
+	return x.queryPointer()
+}
+
+// rootImage captures the root window. It reads 24- and 32-bit TrueColor
+// pixels, which is what X servers run with today.
+func (x *xConn) rootImage() (*image.RGBA, error) {
+	w, h := x.screen.Width, x.screen.Height
+	body := cat(u32(x.screen.Root), u16(0), u16(0), u16(uint16(w)), u16(uint16(h)), u32(0xffffffff))
+	reply, err := x.roundTrip(x.req(73, 2, body)) // GetImage, ZPixmap
+	if err != nil {
+		return nil, err
+	}
+	depth := reply[1]
+	rm, gm, bm := x.screen.RedMask, x.screen.GreenMask, x.screen.BlueMask
+	if x.pixmapBPP[depth] != 32 || x.imageMSBFirst || rm == 0 || gm == 0 || bm == 0 {
+		return nil, fmt.Errorf("cannot read %d-bit images", depth)
+	}
+	data := reply[32:]
+	if len(data) < 4*w*h {
+		return nil, fmt.Errorf("short image: %d bytes for %dx%d", len(data), w, h)
+	}
+	rs, gs, bs := bits.TrailingZeros32(rm), bits.TrailingZeros32(gm), bits.TrailingZeros32(bm)
+	img := newFrame(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		p := xByteOrder.Uint32(data[4*i:])
+		img.Pix[4*i] = byte((p & rm) >> rs)
+		img.Pix[4*i+1] = byte((p & gm) >> gs)
+		img.Pix[4*i+2] = byte((p & bm) >> bs)
+		img.Pix[4*i+3] = 0xff
+	}
+	return img, nil
+}
+
+// xKeymap is the server's keyboard mapping
+type xKeymap struct {
+	keysyms []uint32
+	perCode int
+	min     byte
+	shift   byte // the keycode of Shift_L
+}
+
+func readKeymap(x *xConn) (*xKeymap, error) {
+	keysyms, perCode, err := x.keyboardMapping()
+	if err != nil {
+		return nil, err
+	}
+	m := &xKeymap{keysyms: keysyms, perCode: perCode, min: x.minKeycode}
+	m.shift, _, _ = m.lookup(xkShiftL)
+	return m, nil
+}
+
+// lookup finds the keycode with keysym on its first or shifted level
+func (m *xKeymap) lookup(keysym uint32) (code byte, shifted, ok bool) {
+	for level := 0; level < 2 && level < m.perCode; level++ {
+		for i := level; i < len(m.keysyms); i += m.perCode {
+			if m.keysyms[i] == keysym {
+				return m.min + byte(i/m.perCode), level == 1, true
+			}
+		}
+	}
+	return 0, false, false
+}
+
+// spare is the highest keycode with no symbols, 0 if there is none
+func (m *xKeymap) spare() byte {
+	for code := len(m.keysyms)/m.perCode - 1; code >= 0; code-- {
+		empty := true
+		for _, k := range m.keysyms[code*m.perCode : (code+1)*m.perCode] {
+			empty = empty && k == 0
+		}
+		if empty {
+			return m.min + byte(code)
+		}
+	}
+	return 0
+}
+
+// keysymRow is a ChangeKeyboardMapping row with keysym on both levels
+func keysymRow(keysym uint32, perCode int) []byte {
+	var row []byte
+	for i := 0; i < perCode; i++ {
+		k := uint32(0)
+		if i < 2 {
+			k = keysym
+		}
+		row = append(row, u32(k)...)
+	}
+	return row
+}
+
+// runeKeysym is the keysym of a character: Latin-1 directly, the rest with
+// the Unicode keysym offset
+func runeKeysym(r rune) uint32 {
+	switch r {
+	case '\n':
+		return xkReturn
+	case '\t':
+		return 0xff09
+	}
+	if r < 0x100 {
+		return uint32(r)
+	}
+	return 0x01000000 | uint32(r)
+}
+
+// Keysyms named in code
+const (
+	xkReturn = 0xff0d
+	xkShiftL = 0xffe1
+)
+
+// xKeysymNames maps xdotool key names to keysyms
+var xKeysymNames = map[string]uint32{
+	"Return": xkReturn, "KP_Enter": 0xff8d, "Escape": 0xff1b, "BackSpace": 0xff08,
+	"Tab": 0xff09, "space": 0x20, "Delete": 0xffff, "Insert": 0xff63,
+	"Home": 0xff50, "End": 0xff57, "Prior": 0xff55, "Page_Up": 0xff55, "Next": 0xff56, "Page_Down": 0xff56,
+	"Left": 0xff51, "Up": 0xff52, "Right": 0xff53, "Down": 0xff54,
+	"ctrl": 0xffe3, "Control_L": 0xffe3, "Control_R": 0xffe4,
+	"alt": xkAltL, "Alt_L": xkAltL, "Alt_R": 0xffea, "ISO_Level3_Shift": 0xfe03,
+	"shift": xkShiftL, "Shift_L": xkShiftL, "Shift_R": 0xffe2,
+	"super": xkSuperL, "Super_L": xkSuperL, "Super_R": 0xffec, "meta": 0xffe7, "Meta_L": 0xffe7, "Meta_R": 0xffe8,
+	"Menu": 0xff67, "Print": 0xff61, "Sys_Req": 0xff15, "Pause": 0xff13, "Break": 0xff6b,
+	"Caps_Lock": 0xffe5, "Num_Lock": xkNumLock, "Scroll_Lock": 0xff14,
+	"minus": '-', "equal": '=', "plus": '+', "bracketleft": '[', "bracketright": ']',
+	"backslash": '\\', "semicolon": ';', "apostrophe": '\'', "grave": '`',
+	"comma": ',', "period": '.', "slash": '/', "less": '<', "greater": '>',
+	"XF86AudioMute": 0x1008ff12, "XF86AudioLowerVolume": 0x1008ff11, "XF86AudioRaiseVolume": 0x1008ff13,
+	"XF86AudioPlay": 0x1008ff14, "XF86AudioStop": 0x1008ff15, "XF86AudioPrev": 0x1008ff16, "XF86AudioNext": 0x1008ff17,
+	"XF86MonBrightnessUp": 0x1008ff02, "XF86MonBrightnessDown": 0x1008ff03,
+}
+
+// xKeysym resolves a key name: the names above in any case, F1 to F35,
+// KP_0 to KP_9, and single characters
+func xKeysym(name string) (uint32, bool) {
+	if name == "" {
+		return 0, false
+	}
+	if k, ok := xKeysymNames[name]; ok {
+		return k, true
+	}
+	for n, k := range xKeysymNames {
+		if strings.EqualFold(n, name) {
+			return k, true
+		}
+	}
+	if n, err := strconv.Atoi(name[1:]); err == nil && (name[0] == 'F' || name[0] == 'f') && n >= 1 && n <= 35 {
+		return 0xffbe + uint32(n-1), true
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "KP_")); err == nil && strings.HasPrefix(name, "KP_") && n >= 0 && n <= 9 {
+		return 0xffb0 + uint32(n), true
+	}
+	if r := []rune(name); len(r) == 1 {
+		return runeKeysym(r[0]), true
+	}
+	return 0, false
+}
+
+// isXDisplay reports whether b drives an X display
+func isXDisplay(b Backend) bool {
+	switch b.(type) {
+	case xdotoolBackend, *x11Backend:
+		return true
+	}
+	return false
+}
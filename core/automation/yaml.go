@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// With --format yaml a script is a list of steps, or a mapping with the
+// steps under steps: and optionally setup steps under setup:
+//
+//	setup_policy: abort
+//	setup:
+//	  - action: launch
+//	    params: {app: gedit}
+//	steps:
+//	  - name: open settings
+//	    action: click_text
+//	    params:
+//	      text: Settings
+//	    timeout: 10s
+//	    retries: 2
+//	    on_rollback:
+//	      - action: key
+//	        params: {key: Escape}
+//
+// name, timeout, retries, budget, idem, env and cwd become the step's
+// annotations, so results refer to steps by name. Steps are checked like
+// JSON commands. Only the YAML a script needs is read: block and flow
+// collections, plain and quoted scalars, | and > block scalars and
+// comments; anchors, tags and multi-line plain scalars are not supported.
+
+// yamlNode is a parsed YAML value: a scalar, a sequence or a mapping
+type yamlNode struct {
+	line   int
+	kind   byte // 's' scalar, 'l' sequence, 'm' mapping
+	value  string
+	quoted bool
+	items  []*yamlNode
+	keys   []string
+	values []*yamlNode
+}
+
+// get is a mapping's value for key, or nil
+func (n *yamlNode) get(key string) *yamlNode {
+	for i, k := range n.keys {
+		if k == key {
+			return n.values[i]
+		}
+	}
+	return nil
+}
+
+// yamlLine is a content line with its indentation and comment removed;
+// raw keeps the whole line for block scalars
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+	raw    string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func parseYAML(data []byte) (*yamlNode, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", i+1)
+		}
+		text := strings.TrimRight(stripYAMLComment(trimmed), " \t")
+		if text == "---" || text == "..." {
+			continue
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(raw) - len(trimmed), text: text, raw: raw})
+	}
+	p.skipBlank()
+	if p.pos == len(p.lines) {
+		return nil, fmt.Errorf("the script is empty")
+	}
+	n, err := p.block(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank(); p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return n, nil
+}
+
+// stripYAMLComment cuts a # comment, which starts a line or follows a
+// space, outside quotes
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" \t[{,:", rune(s[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && p.lines[p.pos].text == "" {
+		p.pos++
+	}
+}
+
+// block parses the collection whose lines start at indent
+func (p *yamlParser) block(indent int) (*yamlNode, error) {
+	p.skipBlank()
+	l := p.lines[p.pos]
+	if l.indent != indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+	}
+	if l.text == "-" || strings.HasPrefix(l.text, "- ") {
+		return p.sequence(indent)
+	}
+	if _, _, ok := cutYAMLKey(l.text); ok {
+		return p.mapping(indent)
+	}
+	p.pos++
+	if p.skipBlank(); p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, fmt.Errorf("line %d: multi-line plain scalars are not supported", l.num)
+	}
+	return inlineYAML(l.text, l.num)
+}
+
+func (p *yamlParser) sequence(indent int) (*yamlNode, error) {
+	n := &yamlNode{kind: 'l', line: p.lines[p.pos].num}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		l := &p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent || !(l.text == "-" || strings.HasPrefix(l.text, "- ")) {
+			return nil, fmt.Errorf("line %d: expected a list item", l.num)
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		var item *yamlNode
+		var err error
+		switch _, _, isKey := cutYAMLKey(rest); {
+		case rest == "":
+			p.pos++
+			item = &yamlNode{kind: 's', line: l.num}
+			if p.skipBlank(); p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				item, err = p.block(p.lines[p.pos].indent)
+			}
+		case isKey || rest == "-" || strings.HasPrefix(rest, "- "):
+			// A collection starting on the item's line: read the line again
+			// as if it started where the collection does
+			offset := len(l.text) - len(rest)
+			l.indent, l.text = l.indent+offset, rest
+			item, err = p.block(l.indent)
+		default:
+			p.pos++
+			item, err = inlineYAML(rest, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, item)
+	}
+	return n, nil
+}
+
+func (p *yamlParser) mapping(indent int) (*yamlNode, error) {
+	n := &yamlNode{kind: 'm', line: p.lines[p.pos].num}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		key, rest, ok := cutYAMLKey(l.text)
+		if l.indent > indent || !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", l.num)
+		}
+		if n.get(key) != nil {
+			return nil, fmt.Errorf("line %d: duplicate key %s", l.num, key)
+		}
+		p.pos++
+		var value *yamlNode
+		var err error
+		switch {
+		case rest == "":
+			value = &yamlNode{kind: 's', line: l.num}
+			if p.skipBlank(); p.pos < len(p.lines) {
+				next := p.lines[p.pos]
+				// A list may sit at its key's indentation
+				if next.indent > indent || (next.indent == indent && (next.text == "-" || strings.HasPrefix(next.text, "- "))) {
+					value, err = p.block(next.indent)
+				}
+			}
+		case strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">"):
+			value, err = p.blockScalar(rest, indent, l.num)
+		default:
+			value, err = inlineYAML(rest, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, key)
+		n.values = append(n.values, value)
+	}
+	return n, nil
+}
+
+// blockScalar reads a | (literal) or > (folded) scalar, with the - and +
+// chomping indicators
+func (p *yamlParser) blockScalar(header string, indent, num int) (*yamlNode, error) {
+	style, chomp := header[0], header[1:]
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, fmt.Errorf("line %d: unsupported block scalar header %s", num, header)
+	}
+	var lines []string
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		l := p.lines[p.pos]
+		if strings.TrimSpace(l.raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if blockIndent < 0 {
+			blockIndent = l.indent
+		}
+		if l.indent < blockIndent || l.indent <= indent {
+			break
+		}
+		lines = append(lines, l.raw[blockIndent:])
+	}
+	// Trailing blank lines belong to the chomping, not the text
+	body := lines
+	for len(body) > 0 && body[len(body)-1] == "" {
+		body = body[:len(body)-1]
+	}
+	var text string
+	if style == '|' {
+		text = strings.Join(body, "\n")
+	} else {
+		for i, line := range body {
+			switch {
+			case i == 0:
+			case line == "" || body[i-1] == "":
+				text += "\n"
+			default:
+				text += " "
+			}
+			text += line
+		}
+	}
+	switch chomp {
+	case "":
+		if len(body) > 0 {
+			text += "\n"
+		}
+	case "+":
+		text += strings.Repeat("\n", len(lines)-len(body)+1)
+	}
+	return &yamlNode{kind: 's', line: num, value: text, quoted: true}, nil
+}
+
+// cutYAMLKey splits "key: value" or "key:", the key plain or quoted
+func cutYAMLKey(s string) (key, rest string, ok bool) {
+	if s == "" || strings.ContainsRune("[{", rune(s[0])) {
+		return "", "", false
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		end := closingYAMLQuote(s)
+		if end < 0 || !(end+1 == len(s) || s[end+1] == ':') {
+			return "", "", false
+		}
+		k, err := inlineYAML(s[:end+1], 0)
+		if err != nil {
+			return "", "", false
+		}
+		after := s[end+1:]
+		if after == ":" || strings.HasPrefix(after, ": ") {
+			return k.value, strings.TrimSpace(after[1:]), true
+		}
+		return "", "", false
+	}
+	if i := strings.Index(s, ": "); i > 0 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+2:]), true
+	}
+	if strings.HasSuffix(s, ":") && len(s) > 1 {
+		return strings.TrimSpace(s[:len(s)-1]), "", true
+	}
+	return "", "", false
+}
+
+// closingYAMLQuote is the index of the quote closing the one s starts with
+func closingYAMLQuote(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case q == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// inlineYAML parses a value written on one line: a scalar or a flow
+// collection
+func inlineYAML(s string, num int) (*yamlNode, error) {
+	f := &yamlFlow{s: s, num: num}
+	n, err := f.value("")
+	if err != nil {
+		return nil, err
+	}
+	if f.skipSpace(); f.i < len(f.s) {
+		return nil, fmt.Errorf("line %d: unexpected %q", num, f.s[f.i:])
+	}
+	return n, nil
+}
+
+// yamlFlow reads flow syntax: [a, b], {k: v} and scalars
+type yamlFlow struct {
+	s   string
+	i   int
+	num int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+// value reads a value ending at one of the stop characters, or the end
+func (f *yamlFlow) value(stop string) (*yamlNode, error) {
+	f.skipSpace()
+	if f.i == len(f.s) {
+		return &yamlNode{kind: 's', line: f.num}, nil
+	}
+	switch c := f.s[f.i]; c {
+	case '[':
+		n := &yamlNode{kind: 'l', line: f.num}
+		f.i++
+		for {
+			if f.skipSpace(); f.i < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return n, nil
+			}
+			item, err := f.value(",]")
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, item)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		n := &yamlNode{kind: 'm', line: f.num}
+		f.i++
+		for {
+			if f.skipSpace(); f.i < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return n, nil
+			}
+			key, err := f.value(":,}")
+			if err != nil {
+				return nil, err
+			}
+			value := &yamlNode{kind: 's', line: f.num}
+			if f.skipSpace(); f.i < len(f.s) && f.s[f.i] == ':' {
+				f.i++
+				if value, err = f.value(",}"); err != nil {
+					return nil, err
+				}
+			}
+			n.keys = append(n.keys, key.value)
+			n.values = append(n.values, value)
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		end := closingYAMLQuote(f.s[f.i:])
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: unterminated string", f.num)
+		}
+		quoted := f.s[f.i : f.i+end+1]
+		f.i += end + 1
+		var text string
+		if c == '\'' {
+			text = strings.ReplaceAll(quoted[1:len(quoted)-1], "''", "'")
+		} else {
+			var err error
+			if text, err = strconv.Unquote(quoted); err != nil {
+				return nil, fmt.Errorf("line %d: bad escape in %s", f.num, quoted)
+			}
+		}
+		return &yamlNode{kind: 's', line: f.num, value: text, quoted: true}, nil
+	}
+	start := f.i
+	for f.i < len(f.s) {
+		c := f.s[f.i]
+		// In flow context a colon ends a key only before a space
+		if strings.IndexByte(stop, c) >= 0 && (c != ':' || f.i+1 == len(f.s) || f.s[f.i+1] == ' ') {
+			break
+		}
+		f.i++
+	}
+	return &yamlNode{kind: 's', line: f.num, value: strings.TrimSpace(f.s[start:f.i])}, nil
+}
+
+// separator reads the comma between flow items, or sees the closing bracket
+func (f *yamlFlow) separator(closing byte) error {
+	f.skipSpace()
+	switch {
+	case f.i < len(f.s) && f.s[f.i] == ',':
+		f.i++
+		return nil
+	case f.i < len(f.s) && f.s[f.i] == closing:
+		return nil
+	}
+	return fmt.Errorf("line %d: expected , or %c", f.num, closing)
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// plain converts a node to what JSON would hold: plain scalars are
+// resolved as YAML's core schema does, unless asString keeps their text
+func (n *yamlNode) plain(asString bool) interface{} {
+	switch n.kind {
+	case 'l':
+		items := []interface{}{}
+		for _, item := range n.items {
+			items = append(items, item.plain(asString))
+		}
+		return items
+	case 'm':
+		m := map[string]interface{}{}
+		for i, k := range n.keys {
+			m[k] = n.values[i].plain(false)
+		}
+		return m
+	}
+	if n.quoted || asString {
+		return n.value
+	}
+	switch v := n.value; {
+	case v == "" || v == "~" || v == "null" || v == "Null" || v == "NULL":
+		return nil
+	case v == "true" || v == "True" || v == "TRUE":
+		return true
+	case v == "false" || v == "False" || v == "FALSE":
+		return false
+	case yamlInt.MatchString(v):
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	case yamlFloat.MatchString(v):
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return n.value
+}
+
+// yamlScript converts a YAML script to script lines
+func yamlScript(data []byte) ([]byte, error) {
+	doc, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	steps := doc
+	if doc.kind == 'm' {
+		for _, k := range doc.keys {
+			if k != "steps" && k != "setup" && k != "setup_policy" {
+				return nil, fmt.Errorf("line %d: unknown key %s (want steps, setup or setup_policy)", doc.get(k).line, k)
+			}
+		}
+		if setup := doc.get("setup"); setup != nil {
+			policy := ""
+			if p := doc.get("setup_policy"); p != nil {
+				policy = p.value
+			}
+			fmt.Fprintf(&out, "setup: %s\n", policy)
+			if err := writeYAMLSteps(&out, setup, false); err != nil {
+				return nil, err
+			}
+			out.WriteString("end\n")
+		}
+		if steps = doc.get("steps"); steps == nil {
+			return nil, fmt.Errorf("line %d: no steps", doc.line)
+		}
+	}
+	if err := writeYAMLSteps(&out, steps, true); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// yamlStepKeys are the keys a step may have besides action and params,
+// and the annotation each becomes
+var yamlStepKeys = map[string]string{"name": "name", "timeout": "timeout", "retries": "retries", "budget": "budget", "idem": "idem", "env": "env", "cwd": "cwd"}
+
+func writeYAMLSteps(out *bytes.Buffer, list *yamlNode, rollbacks bool) error {
+	if list.kind != 'l' {
+		return fmt.Errorf("line %d: steps must be a list", list.line)
+	}
+	for i, s := range list.items {
+		if err := writeYAMLStep(out, s, rollbacks); err != nil {
+			label := fmt.Sprintf("step %d", i+1)
+			if name := s.get("name"); s.kind == 'm' && name != nil {
+				label = fmt.Sprintf("step %d (%s)", i+1, name.value)
+			}
+			return fmt.Errorf("line %d: %s: %v", s.line, label, err)
+		}
+	}
+	return nil
+}
+
+func writeYAMLStep(out *bytes.Buffer, s *yamlNode, rollbacks bool) error {
+	if s.kind != 'm' {
+		return fmt.Errorf("a step is a mapping with an action")
+	}
+	action := s.get("action")
+	if action == nil || action.kind != 's' || action.value == "" {
+		return fmt.Errorf("missing action")
+	}
+	c := JSONCommand{Action: action.value, Params: map[string]json.RawMessage{}, Annotations: map[string]string{}}
+	var spec ActionSpec
+	for _, a := range actionSpecs {
+		if a.Name == c.Action {
+			spec = a
+		}
+	}
+	var onRollback *yamlNode
+	for i, k := range s.keys {
+		v := s.values[i]
+		switch annotation, isOption := yamlStepKeys[k]; {
+		case k == "action":
+		case k == "params":
+			if v.kind != 'm' {
+				return fmt.Errorf("params must be a mapping")
+			}
+			for j, name := range v.keys {
+				// Plain scalars stay text where the action wants text
+				asString := false
+				for _, p := range spec.Params {
+					asString = asString || (p.Name == name && (p.Type == "string" || p.Type == "array"))
+				}
+				c.Params[name], _ = json.Marshal(v.values[j].plain(asString))
+			}
+		case k == "on_rollback" && rollbacks:
+			onRollback = v
+		case isOption:
+			if v.kind != 's' {
+				return fmt.Errorf("%s must be a single value", k)
+			}
+			value := v.value
+			// A bare number of seconds is a timeout too
+			if k == "timeout" || k == "budget" {
+				if _, isNumber := v.plain(false).(string); !isNumber && !v.quoted {
+					value += "s"
+				}
+			}
+			c.Annotations[annotation] = value
+		default:
+			return fmt.Errorf("unknown key %s", k)
+		}
+	}
+	obj, _ := json.Marshal(c)
+	if err := writeJSONCommand(out, obj); err != nil {
+		return err
+	}
+	if onRollback != nil {
+		out.WriteString("on_rollback\n")
+		if err := writeYAMLSteps(out, onRollback, false); err != nil {
+			return err
+		}
+		out.WriteString("end\n")
+	}
+	return nil
+}
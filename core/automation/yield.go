@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// With --yield the run gives way to the person at the machine. The
+// keyboards, mice and touchpads are watched through evdev, and a step that
+// comes due while someone is using them waits until they have been idle
+// for the --yield period, or until the executor gets SIGUSR1:
+//
+//	executor --yield 5s script.gcode
+//	kill -USR1 $(pidof executor)   # resume now
+//
+// The run's own virtual devices are not watched, and XTEST input never
+// reaches evdev, so the run does not pause itself. A step already under
+// way finishes first. Each pause is a yielded event on the step after it.
+// Reading /dev/input needs membership of the input group.
+
+// yieldQuiet is set by --yield; 0 leaves the user's input unwatched
+var yieldQuiet time.Duration
+
+// sharedActivity, when set, is watched for the whole process, as by the
+// daemon's --when-idle, and runs use it instead of watching their own
+var sharedActivity *userActivity
+
+// userActivity is what the input watcher has seen
+type userActivity struct {
+	last    atomic.Int64 // clock time of the latest user input, in ns
+	resumed atomic.Int64 // clock time of the latest SIGUSR1, in ns
+	resume  chan struct{}
+}
+
+func newUserActivity() *userActivity {
+	return &userActivity{resume: make(chan struct{}, 1)}
+}
+
+// input records user input now
+func (a *userActivity) input() {
+	a.last.Store(clock.Now().UnixNano())
+}
+
+// resumeNow ends a pause, ignoring the input seen so far
+func (a *userActivity) resumeNow() {
+	a.resumed.Store(clock.Now().UnixNano())
+	select {
+	case a.resume <- struct{}{}:
+	default:
+	}
+}
+
+// busy reports how long ago the user last gave input, if within quiet
+// and since the last resume
+func (a *userActivity) busy(quiet time.Duration) (time.Duration, bool) {
+	last := a.last.Load()
+	if last == 0 || last <= a.resumed.Load() {
+		return 0, false
+	}
+	ago := clock.Now().Sub(time.Unix(0, last))
+	return ago, ago < quiet
+}
+
+// startYield starts watching the user's input for --yield
+func (r *runner) startYield() {
+	if yieldQuiet <= 0 {
+		return
+	}
+	if sharedActivity != nil {
+		r.activity = sharedActivity
+		return
+	}
+	activity := newUserActivity()
+	if err := watchUserInput(r.background, activity); err != nil {
+		r.result.Events = append(r.result.Events, Event{Type: "yield_error", Message: err.Error()})
+		return
+	}
+	r.activity = activity
+}
+
+// yieldToUser waits before a step while the user is giving input,
+// returning the yielded event if it did
+func (r *runner) yieldToUser(step int) *Event {
+	a := r.activity
+	if a == nil {
+		return nil
+	}
+	ago, busy := a.busy(yieldQuiet)
+	if !busy {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Warning: user input, pausing before step %d until %v without input (kill -USR1 %d to resume)\n",
+		step, yieldQuiet, os.Getpid())
+	start := clock.Now()
+	resumed := false
+	for busy && !resumed {
+		// A resume sent before this pause is only drained
+		select {
+		case <-a.resume:
+			resumed = a.resumed.Load() > start.UnixNano()
+		case <-clock.After(yieldQuiet - ago):
+		}
+		ago, busy = a.busy(yieldQuiet)
+	}
+	how := "after the user was idle for " + yieldQuiet.String()
+	if resumed {
+		how = "on SIGUSR1"
+	}
+	return &Event{Step: step, Type: "yielded",
+		Message: fmt.Sprintf("paused %.1fs for user input, resumed %s", since(start).Seconds(), how)}
+}
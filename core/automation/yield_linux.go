@@ -0,0 +1,89 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// yieldIgnoredDevices are the name prefixes of virtual devices that carry
+// input injected by the executor or its tools, not by a person
+var yieldIgnoredDevices = []string{uinputDeviceName + " ", "ydotoold virtual device"}
+
+// inputEventSize is the size of a struct input_event: a timeval, then
+// type, code and value
+var inputEventSize = int(unsafe.Sizeof(syscall.Timeval{})) + 8
+
+// watchUserInput reads every physical input device, recording key presses
+// and pointer motion in a, and resumes on SIGUSR1
+func watchUserInput(s *supervisor, a *userActivity) error {
+	paths, _ := filepath.Glob("/dev/input/event*")
+	var devices []*os.File
+	for _, path := range paths {
+		name, _ := os.ReadFile(filepath.Join("/sys/class/input", filepath.Base(path), "device/name"))
+		if ignoredInputDevice(strings.TrimSpace(string(name))) {
+			continue
+		}
+		if f, err := os.Open(path); err == nil {
+			devices = append(devices, f)
+		}
+	}
+	if len(devices) == 0 {
+		return fmt.Errorf("no readable input devices in /dev/input (add the user to the input group)")
+	}
+	for _, f := range devices {
+		f := f
+		s.start("input watcher "+filepath.Base(f.Name()), func(ctx context.Context) error {
+			defer context.AfterFunc(ctx, func() { f.Close() })()
+			buf := make([]byte, inputEventSize*64)
+			for {
+				n, err := f.Read(buf)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return err
+				}
+				for i := 0; i+inputEventSize <= n; i += inputEventSize {
+					// Keys, buttons and motion; not the LEDs the run's own
+					// lock keys light, nor sync and scan code events
+					if kind := *(*uint16)(unsafe.Pointer(&buf[i+inputEventSize-8])); kind >= evKey && kind <= evAbs {
+						a.input()
+						break
+					}
+				}
+			}
+		})
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	s.start("resume signal", func(ctx context.Context) error {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-signals:
+				a.resumeNow()
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+	return nil
+}
+
+func ignoredInputDevice(name string) bool {
+	for _, prefix := range yieldIgnoredDevices {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
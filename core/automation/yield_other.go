@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func watchUserInput(s *supervisor, a *userActivity) error {
+	return fmt.Errorf("--yield watches input through evdev and is only available on Linux")
+}
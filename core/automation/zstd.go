@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Minimal Zstandard (RFC 8878) encoder: single segment frames of blocks
+// whose literals are stored raw and whose sequences use the predefined FSE
+// tables, found by greedy LZ77 over a hash of the last position of every
+// four bytes. That gives up entropy coding, but raw and delta frames are
+// mostly long runs of repeated bytes, where matching is nearly all of the
+// gain, and any zstd decoder reads the result.
+
+const (
+	zstdMagic    = 0xFD2FB528
+	zstdBlockMax = 128 << 10
+	zstdMinMatch = 4
+	zstdHashBits = 18
+)
+
+// fseEntry is one state of an FSE decoding table
+type fseEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	baseline uint16
+}
+
+// fseTable is a decoding table with its inverse for the encoder: prev
+// gives, for a symbol and the state the decoder must reach next, the state
+// to be in now
+type fseTable struct {
+	log    uint
+	states []fseEntry
+	prev   [][]uint16
+}
+
+// newFSETable builds the table for a normalized distribution, where -1
+// marks a "less than one" probability (RFC 8878 section 4.1.1)
+func newFSETable(norm []int16, log uint) *fseTable {
+	size := 1 << log
+	t := &fseTable{log: log, states: make([]fseEntry, size), prev: make([][]uint16, len(norm))}
+	high := size - 1
+	next := make([]int, len(norm))
+	for s, n := range norm {
+		if n == -1 {
+			t.states[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = int(n)
+		}
+	}
+	pos, step := 0, size>>1+size>>3+3
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			t.states[pos].symbol = uint8(s)
+			for pos = (pos + step) & (size - 1); pos > high; pos = (pos + step) & (size - 1) {
+			}
+		}
+	}
+	for s := range t.prev {
+		t.prev[s] = make([]uint16, size)
+	}
+	for u := range t.states {
+		e := &t.states[u]
+		n := next[e.symbol]
+		next[e.symbol]++
+		e.nbBits = uint8(int(log) - (bits.Len(uint(n)) - 1))
+		e.baseline = uint16(n<<e.nbBits - size)
+		for k := 0; k < 1<<e.nbBits; k++ {
+			t.prev[e.symbol][int(e.baseline)+k] = uint16(u)
+		}
+	}
+	return t
+}
+
+// The predefined distributions (RFC 8878 section 3.1.1.3.2.2)
+var (
+	zstdLLTable = newFSETable([]int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1, -1, -1, -1, -1}, 6)
+	zstdMLTable = newFSETable([]int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, -1, -1, -1, -1, -1, -1, -1}, 6)
+	zstdOFTable = newFSETable([]int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}, 5)
+)
+
+// Literal and match length codes above the direct ones: baseline and
+// number of extra bits
+var (
+	zstdLLBase = []int{16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	zstdLLBits = []uint{1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	zstdMLBase = []int{35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051, 4099, 8195, 16387, 32771, 65539}
+	zstdMLBits = []uint{1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// lengthCode finds the code past the direct ones for v: the index of the
+// last baseline not above it, with the extra bits
+func lengthCode(v int, base []int, extra []uint) (index int, value uint32, nb uint) {
+	i := len(base) - 1
+	for base[i] > v {
+		i--
+	}
+	return i, uint32(v - base[i]), extra[i]
+}
+
+type zstdSequence struct {
+	literals, match, offset int
+}
+
+// zstdCompress encodes data as one zstd frame
+func zstdCompress(data []byte) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, zstdMagic)
+	// Single segment with a four byte content size, no checksum
+	out = append(out, 0xA0)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+
+	table := make([]int32, 1<<zstdHashBits)
+	for i := range table {
+		table[i] = -1
+	}
+	hash := func(p int) uint32 {
+		return (binary.LittleEndian.Uint32(data[p:]) * 2654435761) >> (32 - zstdHashBits)
+	}
+	for start := 0; start < len(data) || start == 0; start += zstdBlockMax {
+		end := min(start+zstdBlockMax, len(data))
+		block := data[start:end]
+		last := end == len(data)
+
+		// Greedy matching within the block, against anything before it
+		var seqs []zstdSequence
+		var literals []byte
+		anchor := start
+		for p := start; p+zstdMinMatch <= end; {
+			h := hash(p)
+			cand := int(table[h])
+			table[h] = int32(p)
+			if cand < 0 || binary.LittleEndian.Uint32(data[cand:]) != binary.LittleEndian.Uint32(data[p:]) {
+				p++
+				continue
+			}
+			n := zstdMinMatch
+			for p+n < end && data[cand+n] == data[p+n] {
+				n++
+			}
+			literals = append(literals, data[anchor:p]...)
+			seqs = append(seqs, zstdSequence{literals: p - anchor, match: n, offset: p - cand})
+			// Index a few positions inside the match, enough to find
+			// the next repetition without hashing every byte
+			for q := p + 1; q < p+n && q+zstdMinMatch <= end; q += max(1, n/8) {
+				table[hash(q)] = int32(q)
+			}
+			p += n
+			anchor = p
+		}
+		literals = append(literals, data[anchor:end]...)
+
+		out = append(out, zstdBlock(block, literals, seqs, last)...)
+		if len(data) == 0 {
+			break
+		}
+	}
+	return out
+}
+
+// zstdBlock encodes one block, falling back to a raw block when that is
+// smaller
+func zstdBlock(raw, literals []byte, seqs []zstdSequence, last bool) []byte {
+	header := func(kind, size int) []byte {
+		v := size<<3 | kind<<1
+		if last {
+			v |= 1
+		}
+		return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	}
+	body := zstdLiterals(literals)
+	body = append(body, zstdSequences(seqs)...)
+	if len(seqs) == 0 || len(body) >= len(raw) {
+		return append(header(0, len(raw)), raw...)
+	}
+	return append(header(2, len(body)), body...)
+}
+
+// zstdLiterals is a raw literals section
+func zstdLiterals(lit []byte) []byte {
+	n := len(lit)
+	var h []byte
+	switch {
+	case n < 32:
+		h = []byte{byte(n << 3)}
+	case n < 4096:
+		h = []byte{byte(n<<4 | 1<<2), byte(n >> 4)}
+	default:
+		h = []byte{byte(n<<4 | 3<<2), byte(n >> 4), byte(n >> 12)}
+	}
+	return append(h, lit...)
+}
+
+// zstdSequences is the sequences section in predefined mode. The bit
+// stream is read backwards, so the sequences are written last to first,
+// each field in the reverse of the order it is read.
+func zstdSequences(seqs []zstdSequence) []byte {
+	n := len(seqs)
+	var out []byte
+	switch {
+	case n < 128:
+		out = []byte{byte(n)}
+	case n < 0x7F00:
+		out = []byte{byte(n>>8 + 128), byte(n)}
+	default:
+		out = []byte{255, byte(n - 0x7F00), byte((n - 0x7F00) >> 8)}
+	}
+	if n == 0 {
+		return out
+	}
+	out = append(out, 0) // predefined mode for all three codes
+
+	type coded struct {
+		ll, ml, of          int
+		llv, mlv, ofv       uint32
+		llBits, mlBits, ofb uint
+	}
+	codes := make([]coded, n)
+	for i, s := range seqs {
+		c := &codes[i]
+		if c.ll = s.literals; s.literals >= 16 {
+			c.ll, c.llv, c.llBits = lengthCode(s.literals, zstdLLBase, zstdLLBits)
+			c.ll += 16
+		}
+		if c.ml = s.match - 3; c.ml >= 32 {
+			c.ml, c.mlv, c.mlBits = lengthCode(s.match, zstdMLBase, zstdMLBits)
+			c.ml += 32
+		}
+		// Offsets go in as offset+3, clear of the repeat offset codes
+		v := uint32(s.offset + 3)
+		c.of = bits.Len32(v) - 1
+		c.ofv, c.ofb = v-1<<c.of, uint(c.of)
+	}
+
+	// The last sequence may end in any state of its symbols; walking
+	// back, each earlier state is the one of its symbol leading there
+	ll := zstdLLTable.prev[codes[n-1].ll][0]
+	ml := zstdMLTable.prev[codes[n-1].ml][0]
+	of := zstdOFTable.prev[codes[n-1].of][0]
+	var w bitWriter
+	transition := func(t *fseTable, symbol int, state *uint16) {
+		from := t.prev[symbol][*state]
+		e := t.states[from]
+		w.write(uint32(*state-e.baseline), uint(e.nbBits))
+		*state = from
+	}
+	for i := n - 1; i >= 0; i-- {
+		c := codes[i]
+		if i < n-1 {
+			transition(zstdOFTable, c.of, &of)
+			transition(zstdMLTable, c.ml, &ml)
+			transition(zstdLLTable, c.ll, &ll)
+		}
+		w.write(c.llv, c.llBits)
+		w.write(c.mlv, c.mlBits)
+		w.write(c.ofv, c.ofb)
+	}
+	w.write(uint32(ml), zstdMLTable.log)
+	w.write(uint32(of), zstdOFTable.log)
+	w.write(uint32(ll), zstdLLTable.log)
+	w.write(1, 1) // end mark
+	return append(out, w.bytes()...)
+}